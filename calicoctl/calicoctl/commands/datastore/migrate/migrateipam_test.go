@@ -20,6 +20,7 @@ import (
 
 	"github.com/projectcalico/calico/calicoctl/calicoctl/commands/datastore/migrate"
 
+	"github.com/projectcalico/calico/libcalico-go/lib/apiconfig"
 	bapi "github.com/projectcalico/calico/libcalico-go/lib/backend/api"
 	"github.com/projectcalico/calico/libcalico-go/lib/backend/model"
 	client "github.com/projectcalico/calico/libcalico-go/lib/clientv3"
@@ -292,6 +293,21 @@ func (c *MockIPAMClient) EnsureInitialized(ctx context.Context, calicoVersion, c
 	return nil
 }
 
+func (c *MockIPAMClient) BackendType() apiconfig.DatastoreType {
+	// DO NOTHING
+	return ""
+}
+
+func (c *MockIPAMClient) Capabilities() client.Capabilities {
+	// DO NOTHING
+	return client.Capabilities{}
+}
+
+func (c *MockIPAMClient) Txn() *client.Txn {
+	// DO NOTHING
+	return nil
+}
+
 // MockIPAMBackendClient stubs out bapi.Client but only implements List
 // for the IPAM objects in order to test IPAM migration logic.
 type MockIPAMBackendClient struct {
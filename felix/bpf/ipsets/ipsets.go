@@ -360,6 +360,13 @@ func (m *bpfIPSets) ApplyUpdates() {
 	bpfIPSetsGauge.Set(float64(len(m.ipSets)))
 }
 
+// ApplyUpdatesChecked applies the updates, as for ApplyUpdates().  Failures to update the BPF map
+// are retried via a resync on the next call so it never returns an error.
+func (m *bpfIPSets) ApplyUpdatesChecked() error {
+	m.ApplyUpdates()
+	return nil
+}
+
 // ApplyDeletions tries to delete any IP sets that are no longer needed.
 // Failures are ignored, deletions will be retried the next time we do a resync.
 func (m *bpfIPSets) ApplyDeletions() bool {
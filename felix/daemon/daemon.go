@@ -415,6 +415,7 @@ configRetry:
 	// one.
 	var dpDriver dp.DataplaneDriver
 	var dpDriverCmd *exec.Cmd
+	var err error
 
 	failureReportChan := make(chan string)
 	configChangedRestartCallback := func() {
@@ -429,12 +430,15 @@ configRetry:
 		log.Panic("Graceful shutdown took too long")
 	}
 
-	dpDriver, dpDriverCmd = dp.StartDataplaneDriver(
+	dpDriver, dpDriverCmd, err = dp.StartDataplaneDriver(
 		configParams.Copy(), // Copy to avoid concurrent access.
 		healthAggregator,
 		configChangedRestartCallback,
 		fatalErrorCallback,
 		k8sClientSet)
+	if err != nil {
+		log.WithError(err).Fatal("Failed to start dataplane driver")
+	}
 
 	// Initialise the glue logic that connects the calculation graph to/from the dataplane driver.
 	log.Info("Connect to the dataplane driver.")
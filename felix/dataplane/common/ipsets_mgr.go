@@ -31,7 +31,7 @@ type IPSetsDataplane interface {
 	GetTypeOf(setID string) (ipsets.IPSetType, error)
 	GetDesiredMembers(setID string) (set.Set[string], error)
 	QueueResync()
-	ApplyUpdates()
+	ApplyUpdatesChecked() error
 	ApplyDeletions() (reschedule bool)
 }
 
@@ -95,6 +95,11 @@ func (s *MockIPSets) ApplyUpdates() {
 	// Not implemented for UT.
 }
 
+func (s *MockIPSets) ApplyUpdatesChecked() error {
+	// Not implemented for UT.
+	return nil
+}
+
 func (s *MockIPSets) ApplyDeletions() bool {
 	// Not implemented for UT.
 	return false
@@ -62,12 +62,12 @@ func StartDataplaneDriver(configParams *config.Config,
 	healthAggregator *health.HealthAggregator,
 	configChangedRestartCallback func(),
 	fatalErrorCallback func(error),
-	k8sClientSet *kubernetes.Clientset) (DataplaneDriver, *exec.Cmd) {
+	k8sClientSet *kubernetes.Clientset) (DataplaneDriver, *exec.Cmd, error) {
 
 	if !configParams.IsLeader() {
 		// Return an inactive dataplane, since we're not the leader.
 		log.Info("Not the leader, using an inactive dataplane")
-		return &inactive.InactiveDataplane{}, nil
+		return &inactive.InactiveDataplane{}, nil, nil
 	}
 
 	if configParams.UseInternalDataplaneDriver {
@@ -220,12 +220,14 @@ func StartDataplaneDriver(configParams *config.Config,
 					rules.IPSetNamePrefix,
 					rules.AllHistoricIPSetNamePrefixes,
 					rules.LegacyV4IPSetNames,
+					nil,
 				),
 				IPSetConfigV6: ipsets.NewIPVersionConfig(
 					ipsets.IPFamilyV6,
 					rules.IPSetNamePrefix,
 					rules.AllHistoricIPSetNamePrefixes,
 					nil,
+					nil,
 				),
 
 				KubeNodePortRanges:     configParams.KubeNodePortRanges,
@@ -405,12 +407,13 @@ func StartDataplaneDriver(configParams *config.Config,
 			go aws.WaitForEC2SrcDstCheckUpdate(check, healthAggregator, updater, c)
 		}
 
-		return intDP, nil
+		return intDP, nil, nil
 	} else {
 		log.WithField("driver", configParams.DataplaneDriver).Info(
 			"Using external dataplane driver.")
 
-		return extdataplane.StartExtDataplaneDriver(configParams.DataplaneDriver)
+		extDP, cmd := extdataplane.StartExtDataplaneDriver(configParams.DataplaneDriver)
+		return extDP, cmd, nil
 	}
 }
 
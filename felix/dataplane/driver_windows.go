@@ -39,7 +39,7 @@ func StartDataplaneDriver(configParams *config.Config,
 	healthAggregator *health.HealthAggregator,
 	configChangedRestartCallback func(),
 	fatalErrorCallback func(error),
-	k8sClientSet *kubernetes.Clientset) (DataplaneDriver, *exec.Cmd) {
+	k8sClientSet *kubernetes.Clientset) (DataplaneDriver, *exec.Cmd, error) {
 	log.Info("Using Windows dataplane driver.")
 
 	dpConfig := windataplane.Config{
@@ -50,12 +50,18 @@ func StartDataplaneDriver(configParams *config.Config,
 		VXLANEnabled: configParams.Encapsulation.VXLANEnabled,
 		VXLANID:      configParams.VXLANVNI,
 		VXLANPort:    configParams.VXLANPort,
+		VXLANMTU:     configParams.VXLANMTU,
 	}
 
-	winDP := windataplane.NewWinDataplaneDriver(hns.API{}, dpConfig)
-	winDP.Start()
+	winDP, err := windataplane.NewWinDataplaneDriver(hns.API{}, dpConfig)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create Windows dataplane driver: %w", err)
+	}
+	if err := winDP.Start(); err != nil {
+		return nil, nil, fmt.Errorf("failed to start Windows dataplane driver: %w", err)
+	}
 
-	return winDP, nil
+	return winDP, nil, nil
 }
 
 func SupportsBPF() error {
@@ -678,8 +678,8 @@ func endpointManagerTests(ipVersion uint8) func() {
 			rrConfigNormal = rules.Config{
 				IPIPEnabled:                 true,
 				IPIPTunnelAddress:           nil,
-				IPSetConfigV4:               ipsets.NewIPVersionConfig(ipsets.IPFamilyV4, "cali", nil, nil),
-				IPSetConfigV6:               ipsets.NewIPVersionConfig(ipsets.IPFamilyV6, "cali", nil, nil),
+				IPSetConfigV4:               ipsets.NewIPVersionConfig(ipsets.IPFamilyV4, "cali", nil, nil, nil),
+				IPSetConfigV6:               ipsets.NewIPVersionConfig(ipsets.IPFamilyV6, "cali", nil, nil, nil),
 				IptablesMarkAccept:          0x8,
 				IptablesMarkPass:            0x10,
 				IptablesMarkScratch0:        0x20,
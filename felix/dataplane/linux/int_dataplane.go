@@ -317,9 +317,14 @@ type InternalDataplane struct {
 	// forceXDPRefresh is set by the XDP refresh timer to indicate that we should
 	// check the XDP state in the dataplane.
 	forceXDPRefresh bool
-	// doneFirstApply is set after we finish the first update to the dataplane. It indicates
-	// that the dataplane should now be in sync.
+	// doneFirstApply is set after we finish the first update to the dataplane that programmed
+	// iptables, which needs the IP sets to have been updated. It indicates that the dataplane
+	// should now be in sync.
 	doneFirstApply bool
+	// consecutiveIPSetsFailures counts the updates to the IP sets that have failed since the
+	// last one that succeeded.  Once it reaches maxConsecutiveIPSetsFailures, we report that
+	// we're not live.  Atomic since health is reported from apply()'s goroutines.
+	consecutiveIPSetsFailures atomic.Int32
 
 	reschedTimer *time.Timer
 	reschedC     <-chan time.Time
@@ -349,6 +354,13 @@ const (
 	healthName     = "InternalDataplaneMainLoop"
 	healthInterval = 10 * time.Second
 
+	// ipSetsRetryDelay is how long we wait before retrying after failing to update the IP sets.
+	ipSetsRetryDelay = 1 * time.Second
+	// maxConsecutiveIPSetsFailures is the number of failed updates to the IP sets, in a row,
+	// after which we report that we're not live.  With ipSetsRetryDelay between retries, this
+	// is roughly a minute of failures.
+	maxConsecutiveIPSetsFailures = 60
+
 	ipipMTUOverhead        = 20
 	vxlanMTUOverhead       = 50
 	vxlanV6MTUOverhead     = 70
@@ -1897,7 +1909,7 @@ func (d *InternalDataplane) loopUpdatingDataplane() {
 
 				d.loopSummarizer.EndOfIteration(applyTime)
 
-				if !d.doneFirstApply {
+				if !d.doneFirstApply && d.consecutiveIPSetsFailures.Load() == 0 {
 					log.WithField(
 						"secsSinceStart", time.Since(processStartTime).Seconds(),
 					).Info("Completed first update to dataplane.")
@@ -2176,10 +2188,14 @@ func (d *InternalDataplane) apply() {
 	// Next, create/update IP sets.  We defer deletions of IP sets until after we update
 	// iptables.
 	var ipSetsWG sync.WaitGroup
+	var ipSetsFailed atomic.Bool
 	for _, ipSets := range d.ipSets {
 		ipSetsWG.Add(1)
 		go func(ipSets common.IPSetsDataplane) {
-			ipSets.ApplyUpdates()
+			if err := ipSets.ApplyUpdatesChecked(); err != nil {
+				log.WithError(err).Warn("Failed to update IP sets, will retry...")
+				ipSetsFailed.Store(true)
+			}
 			d.reportHealth()
 			ipSetsWG.Done()
 		}(ipSets)
@@ -2219,12 +2235,25 @@ func (d *InternalDataplane) apply() {
 
 	// Wait for the IP sets update to finish.  We can't update iptables until it has.
 	ipSetsWG.Wait()
-
-	// Update iptables, this should sever any references to now-unused IP sets.
+	iptablesTables, ipSetsToClean := d.allIptablesTables, d.ipSets
 	var reschedDelayMutex sync.Mutex
 	var reschedDelay time.Duration
+	if ipSetsFailed.Load() {
+		// The new iptables rules may refer to IP sets that we failed to create, leave iptables
+		// (and the IP set deletions, which depend on it) until we retry.
+		d.dataplaneNeedsSync = true
+		iptablesTables, ipSetsToClean = nil, nil
+		reschedDelay = ipSetsRetryDelay
+		if n := d.consecutiveIPSetsFailures.Add(1); n == maxConsecutiveIPSetsFailures {
+			log.WithField("numFailures", n).Error("Repeatedly failed to update IP sets, reporting non-live")
+		}
+	} else {
+		d.consecutiveIPSetsFailures.Store(0)
+	}
+
+	// Update iptables, this should sever any references to now-unused IP sets.
 	var iptablesWG sync.WaitGroup
-	for _, t := range d.allIptablesTables {
+	for _, t := range iptablesTables {
 		iptablesWG.Add(1)
 		go func(t *iptables.Table) {
 			tableReschedAfter := t.Apply()
@@ -2242,7 +2271,7 @@ func (d *InternalDataplane) apply() {
 
 	// Now clean up any left-over IP sets.
 	var ipSetsNeedsReschedule atomic.Bool
-	for _, ipSets := range d.ipSets {
+	for _, ipSets := range ipSetsToClean {
 		ipSetsWG.Add(1)
 		go func(s common.IPSetsDataplane) {
 			defer ipSetsWG.Done()
@@ -2337,11 +2366,16 @@ type IptablesTable interface {
 	RemoveChainByName(name string)
 }
 
+// reportHealth reports our liveness and readiness.  We're ready once we've completed the first
+// update to the dataplane, and live unless the IP sets updates have been failing repeatedly.
 func (d *InternalDataplane) reportHealth() {
 	if d.config.HealthAggregator != nil {
 		d.config.HealthAggregator.Report(
 			healthName,
-			&health.HealthReport{Live: true, Ready: d.doneFirstApply && d.ifaceMonitorInSync},
+			&health.HealthReport{
+				Live:  d.consecutiveIPSetsFailures.Load() < maxConsecutiveIPSetsFailures,
+				Ready: d.doneFirstApply && d.ifaceMonitorInSync,
+			},
 		)
 	}
 }
@@ -56,12 +56,14 @@ var _ = Describe("Constructor test", func() {
 					rules.IPSetNamePrefix,
 					rules.AllHistoricIPSetNamePrefixes,
 					rules.LegacyV4IPSetNames,
+					nil,
 				),
 				IPSetConfigV6: ipsets.NewIPVersionConfig(
 					ipsets.IPFamilyV6,
 					rules.IPSetNamePrefix,
 					rules.AllHistoricIPSetNamePrefixes,
 					nil,
+					nil,
 				),
 
 				OpenStackSpecialCasesEnabled: configParams.OpenstackActive(),
@@ -43,6 +43,7 @@ var _ = Describe("Masquerade manager", func() {
 				"cali",
 				nil,
 				nil,
+				nil,
 			),
 			IptablesMarkPass:     0x1,
 			IptablesMarkAccept:   0x2,
@@ -16,6 +16,7 @@ package windataplane
 
 import (
 	"errors"
+	"fmt"
 	"net"
 	"os"
 	"reflect"
@@ -70,7 +71,7 @@ type endpointManager struct {
 	addressToEndpointId map[string]string
 	// lastCacheUpdate records the last time that the addressToEndpointId map was refreshed.
 	lastCacheUpdate time.Time
-	hns             hnsInterface
+	hns             HNSAPI
 
 	// pendingIPSetUpdate stores any ipset id which has been updated.
 	pendingIPSetUpdate set.Set[string]
@@ -81,12 +82,13 @@ type endpointManager struct {
 	hostAddrs []string
 }
 
-type hnsInterface interface {
+// HNSAPI is an interface containing only the parts of the HNS API that we use here.
+type HNSAPI interface {
 	GetHNSSupportedFeatures() hns.HNSSupportedFeatures
 	HNSListEndpointRequest() ([]hns.HNSEndpoint, error)
 }
 
-func newEndpointManager(hns hnsInterface, policysets policysets.PolicySetsDataplane) *endpointManager {
+func newEndpointManager(hns HNSAPI, policysets policysets.PolicySetsDataplane) (*endpointManager, error) {
 	var networkName string
 	if os.Getenv(envNetworkName) != "" {
 		networkName = os.Getenv(envNetworkName)
@@ -97,14 +99,13 @@ func newEndpointManager(hns hnsInterface, policysets policysets.PolicySetsDatapl
 	}
 	networkNameRegexp, err := regexp.Compile(networkName)
 	if err != nil {
-		log.WithError(err).Panicf(
-			"Supplied value (%s) for %s environment variable not a valid regular expression.",
-			networkName, envNetworkName)
+		return nil, fmt.Errorf("supplied value (%s) for %s environment variable not a valid regular expression: %w",
+			networkName, envNetworkName, err)
 	}
 
 	hostAddrs, err := net.InterfaceAddrs()
 	if err != nil {
-		log.WithError(err).Panic("Failed to load host interface addresses.")
+		return nil, fmt.Errorf("failed to load host interface addresses: %w", err)
 	}
 
 	hostIPv4s := extractUnicastIPv4Addrs(hostAddrs)
@@ -119,7 +120,7 @@ func newEndpointManager(hns hnsInterface, policysets policysets.PolicySetsDatapl
 		pendingWlEpUpdates:  map[proto.WorkloadEndpointID]*proto.WorkloadEndpoint{},
 		pendingIPSetUpdate:  set.New[string](),
 		hostAddrs:           hostIPv4s,
-	}
+	}, nil
 }
 
 func (m *endpointManager) OnHostAddrsUpdate(hostAddrs []string) {
@@ -17,6 +17,7 @@ package hcn
 
 import (
 	"encoding/json"
+	"fmt"
 	"reflect"
 
 	"github.com/davecgh/go-spew/spew"
@@ -67,8 +68,72 @@ func (network *HostComputeNetwork) AddPolicy(request PolicyNetworkRequest) error
 	return nil
 }
 
+// ModifyNetworkSettings applies a policy request with the given request type; only policy
+// requests are supported.  An update replaces the existing policy with the same type and ID.
+func (network *HostComputeNetwork) ModifyNetworkSettings(request *ModifyNetworkSettingRequest) error {
+	if network.Err != nil {
+		return network.Err
+	}
+	if request.ResourceType != NetworkResourceTypePolicy {
+		return fmt.Errorf("unsupported resource type %q", request.ResourceType)
+	}
+	var polReq PolicyNetworkRequest
+	if err := json.Unmarshal(request.Settings, &polReq); err != nil {
+		return err
+	}
+	switch request.RequestType {
+	case RequestTypeAdd:
+		return network.AddPolicy(polReq)
+	case RequestTypeRemove:
+		return network.RemovePolicy(polReq)
+	case RequestTypeUpdate:
+	outer:
+		for _, p := range polReq.Policies {
+			id := policyID(p)
+			for i, existing := range network.Ptr.Policies {
+				if existing.Type == p.Type && policyID(existing) == id {
+					network.Ptr.Policies[i] = p
+					continue outer
+				}
+			}
+			return fmt.Errorf("no %s policy with ID %q to update", p.Type, id)
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported request type %q", request.RequestType)
+}
+
+func policyID(p NetworkPolicy) string {
+	var settings struct{ Id string }
+	_ = json.Unmarshal(p.Settings, &settings)
+	return settings.Id
+}
+
 type NetworkType string
 
+// ModifyNetworkSettingRequest is the structure used to send a request to modify a network.
+type ModifyNetworkSettingRequest struct {
+	ResourceType NetworkResourceType
+	RequestType  RequestType
+	Settings     json.RawMessage
+}
+
+// NetworkResourceType are the 3 different Network settings resources.
+type NetworkResourceType string
+
+const (
+	NetworkResourceTypePolicy NetworkResourceType = "Policy"
+)
+
+// RequestType are the different operations performed to settings.
+type RequestType string
+
+const (
+	RequestTypeAdd    RequestType = "Add"
+	RequestTypeRemove RequestType = "Remove"
+	RequestTypeUpdate RequestType = "Update"
+)
+
 type RemoteSubnetRoutePolicySetting struct {
 	DestinationPrefix           string
 	IsolationId                 uint16
@@ -91,6 +156,22 @@ type NetworkPolicyType string
 
 const (
 	RemoteSubnetRoute NetworkPolicyType = "RemoteSubnetRoute"
+	SetPolicy         NetworkPolicyType = "SetPolicy"
+)
+
+// SetPolicySetting creates an IP set on a Network, which ACLs can then refer to.
+type SetPolicySetting struct {
+	Id     string
+	Name   string
+	Type   SetPolicyType `json:"PolicyType"`
+	Values string
+}
+
+// SetPolicyType is the type of a SetPolicy.
+type SetPolicyType string
+
+const (
+	SetPolicyTypeIpSet SetPolicyType = "IPSET"
 )
 
 func (_ API) ListNetworks() ([]HostComputeNetwork, error) {
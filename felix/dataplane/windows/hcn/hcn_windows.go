@@ -23,9 +23,25 @@ type HostComputeNetwork = realhcn.HostComputeNetwork
 type RemoteSubnetRoutePolicySetting = realhcn.RemoteSubnetRoutePolicySetting
 type PolicyNetworkRequest = realhcn.PolicyNetworkRequest
 type NetworkPolicy = realhcn.NetworkPolicy
+type SetPolicySetting = realhcn.SetPolicySetting
+type SetPolicyType = realhcn.SetPolicyType
+type ModifyNetworkSettingRequest = realhcn.ModifyNetworkSettingRequest
+type NetworkResourceType = realhcn.NetworkResourceType
+type RequestType = realhcn.RequestType
 
 const (
 	RemoteSubnetRoute = realhcn.RemoteSubnetRoute
+	SetPolicy         = realhcn.SetPolicy
+
+	SetPolicyTypeIpSet = realhcn.SetPolicyTypeIpSet
+)
+
+var (
+	NetworkResourceTypePolicy = realhcn.NetworkResourceTypePolicy
+
+	RequestTypeAdd    = realhcn.RequestTypeAdd
+	RequestTypeRemove = realhcn.RequestTypeRemove
+	RequestTypeUpdate = realhcn.RequestTypeUpdate
 )
 
 func (_ API) ListNetworks() ([]HostComputeNetwork, error) {
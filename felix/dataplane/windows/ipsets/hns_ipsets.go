@@ -0,0 +1,324 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipsets
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/projectcalico/calico/felix/dataplane/windows/hcn"
+	"github.com/projectcalico/calico/libcalico-go/lib/set"
+)
+
+var ErrSetPolicyUpdatesFailed = errors.New("some HNS SetPolicy updates failed")
+
+// HCNAPI is an interface containing only the parts of the HCN API that we use here.
+type HCNAPI interface {
+	ListNetworks() ([]hcn.HostComputeNetwork, error)
+}
+
+// HNSIPSets manages a whole plane of IP sets, as IPSets does, and programs each of them into HNS
+// as a SetPolicy on the HNS network, so that ACLs can refer to the set rather than listing its
+// members in their remote addresses.  The SetPolicy has the same name as the IP set would have
+// on Linux, and its values are the set's members, as an ACL remote-address list.
+//
+// Only sets of IPs and CIDRs can be programmed; IP and port sets are tracked but not programmed.
+// An empty set is not programmed either, since HNS rejects a SetPolicy with no values.
+//
+// The Windows dataplane driver doesn't use HNSIPSets yet: its policy sets still expand IP sets
+// into the ACLs' address lists, so nothing would refer to the set policies.
+type HNSIPSets struct {
+	IPVersionConfig *IPVersionConfig
+
+	// Shim for the Windows HCN API.
+	hcn HCNAPI
+	// networkName matches the name of the HNS network to program.
+	networkName *regexp.Regexp
+
+	ipSetIDToIPSet map[string]*ipSet
+	// dirty is set when the sets have changed since the last successful apply.
+	dirty bool
+
+	logCxt *log.Entry
+}
+
+func NewHNSIPSets(ipVersionConfig *IPVersionConfig, hcn HCNAPI, networkName *regexp.Regexp) *HNSIPSets {
+	return &HNSIPSets{
+		IPVersionConfig: ipVersionConfig,
+		hcn:             hcn,
+		networkName:     networkName,
+		ipSetIDToIPSet:  map[string]*ipSet{},
+		dirty:           true,
+		logCxt: log.WithFields(log.Fields{
+			"family": ipVersionConfig.Family,
+		}),
+	}
+}
+
+// AddOrReplaceIPSet queues up the creation (or replacement) of an IP set.
+func (s *HNSIPSets) AddOrReplaceIPSet(setMetadata IPSetMetadata, members []string) {
+	s.logCxt.WithFields(log.Fields{
+		"setID":      setMetadata.SetID,
+		"setType":    setMetadata.Type,
+		"numMembers": len(members),
+	}).Info("Queueing IP set for creation")
+	s.ipSetIDToIPSet[setMetadata.SetID] = &ipSet{
+		IPSetMetadata: setMetadata,
+		Members:       filterMembers(s.IPVersionConfig.Family, members, setMetadata.Type),
+	}
+	s.dirty = true
+}
+
+// RemoveIPSet queues up the removal of an IP set.
+func (s *HNSIPSets) RemoveIPSet(setID string) {
+	s.logCxt.WithField("setID", setID).Info("Queueing IP set for removal")
+	delete(s.ipSetIDToIPSet, setID)
+	s.dirty = true
+}
+
+// AddMembers queues up the addition of the given members to an IP set.
+func (s *HNSIPSets) AddMembers(setID string, newMembers []string) {
+	ipSet := s.ipSetIDToIPSet[setID]
+	if ipSet == nil {
+		s.logCxt.WithField("setID", setID).Warn("Ignoring new members for unknown IP set")
+		return
+	}
+	filterMembers(s.IPVersionConfig.Family, newMembers, ipSet.Type).Iter(func(m string) error {
+		if !ipSet.Members.Contains(m) {
+			ipSet.Members.Add(m)
+			s.dirty = true
+		}
+		return nil
+	})
+}
+
+// RemoveMembers queues up the removal of the given members from an IP set.
+func (s *HNSIPSets) RemoveMembers(setID string, removedMembers []string) {
+	ipSet := s.ipSetIDToIPSet[setID]
+	if ipSet == nil {
+		s.logCxt.WithField("setID", setID).Warn("Ignoring removed members for unknown IP set")
+		return
+	}
+	filterMembers(s.IPVersionConfig.Family, removedMembers, ipSet.Type).Iter(func(m string) error {
+		if ipSet.Members.Contains(m) {
+			ipSet.Members.Discard(m)
+			s.dirty = true
+		}
+		return nil
+	})
+}
+
+// GetIPSetMembers returns all of the members for a given IP set.
+func (s *HNSIPSets) GetIPSetMembers(setID string) []string {
+	ipSet := s.ipSetIDToIPSet[setID]
+	if ipSet == nil {
+		return nil
+	}
+	var members []string
+	ipSet.Members.Iter(func(m string) error {
+		members = append(members, m)
+		return nil
+	})
+	return members
+}
+
+func (s *HNSIPSets) GetIPFamily() IPFamily {
+	return s.IPVersionConfig.Family
+}
+
+// ApplyUpdates programs the queued changes into HNS.  If some of them fail, they are retried by
+// the next call.
+func (s *HNSIPSets) ApplyUpdates() {
+	if err := s.ApplyUpdatesChecked(); err != nil {
+		s.logCxt.WithError(err).Warn("Failed to apply IP set updates, will retry")
+	}
+}
+
+// ApplyUpdatesChecked is like ApplyUpdates() but returns an error if not all of the changes
+// were programmed.
+func (s *HNSIPSets) ApplyUpdatesChecked() error {
+	if !s.dirty {
+		s.logCxt.Debug("No change since last application, nothing to do")
+		return nil
+	}
+
+	networks, err := s.hcn.ListNetworks()
+	if err != nil {
+		s.logCxt.WithError(err).Error("Failed to look up HNS networks.")
+		return err
+	}
+	var network *hcn.HostComputeNetwork
+	for i := range networks {
+		if s.networkName.MatchString(networks[i].Name) {
+			network = &networks[i]
+			break
+		}
+	}
+	if network == nil {
+		return fmt.Errorf("didn't find any HNS networks matching regular expression %s", s.networkName.String())
+	}
+
+	// Calculate what should be there, then remove the set policies that are already there from
+	// it.  Set policies whose members have changed are updated in place, rather than removed
+	// and re-added, so that ACLs that refer to them never see them missing.
+	setPolsToAdd := map[string]hcn.SetPolicySetting{}
+	for setID, ipSet := range s.ipSetIDToIPSet {
+		if setPol, ok := s.setPolicyFor(setID, ipSet); ok {
+			setPolsToAdd[setPol.Id] = setPol
+		}
+	}
+	var setPolsToUpdate []hcn.SetPolicySetting
+	var setPolsToRemove []hcn.NetworkPolicy
+	for _, policy := range network.Policies {
+		if policy.Type != hcn.SetPolicy {
+			continue
+		}
+		var existing hcn.SetPolicySetting
+		if err := json.Unmarshal(policy.Settings, &existing); err != nil {
+			s.logCxt.WithError(err).Error("Failed to unmarshal existing set policy")
+			return err
+		}
+		if !s.IPVersionConfig.OwnsIPSet(existing.Id) {
+			continue
+		}
+		logCxt := s.logCxt.WithField("setPolicy", existing.Id)
+		if wanted, ok := setPolsToAdd[existing.Id]; ok {
+			if wanted == existing {
+				logCxt.Debug("Found set policy that we still want")
+			} else {
+				logCxt.Debug("Found set policy that needs updating")
+				setPolsToUpdate = append(setPolsToUpdate, wanted)
+			}
+			delete(setPolsToAdd, existing.Id)
+			continue
+		}
+		logCxt.Debug("Found set policy that we no longer want")
+		setPolsToRemove = append(setPolsToRemove, policy)
+	}
+
+	// Add and update the set policies in order, to make the updates deterministic.  We remove
+	// the unwanted set policies last, by which time the ACLs should no longer refer to them.
+	numFailures := 0
+	ids := make([]string, 0, len(setPolsToAdd))
+	for id := range setPolsToAdd {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		if err := s.modifySetPolicy(network, hcn.RequestTypeAdd, setPolsToAdd[id]); err != nil {
+			numFailures++
+		}
+	}
+	sort.Slice(setPolsToUpdate, func(i, j int) bool {
+		return setPolsToUpdate[i].Id < setPolsToUpdate[j].Id
+	})
+	for _, setPol := range setPolsToUpdate {
+		if err := s.modifySetPolicy(network, hcn.RequestTypeUpdate, setPol); err != nil {
+			numFailures++
+		}
+	}
+	for _, policy := range setPolsToRemove {
+		err := network.RemovePolicy(hcn.PolicyNetworkRequest{Policies: []hcn.NetworkPolicy{policy}})
+		if err != nil {
+			s.logCxt.WithError(err).WithField("policy", policy).Error("Failed to remove unwanted set policy")
+			numFailures++
+		}
+	}
+
+	if numFailures > 0 {
+		s.logCxt.WithField("numFailures", numFailures).Error("Not all set policy updates succeeded.")
+		return ErrSetPolicyUpdatesFailed
+	}
+	s.logCxt.WithFields(log.Fields{
+		"numAdded":   len(setPolsToAdd),
+		"numUpdated": len(setPolsToUpdate),
+		"numRemoved": len(setPolsToRemove),
+	}).Info("All set policy updates succeeded.")
+	s.dirty = false
+	return nil
+}
+
+// modifySetPolicy adds or updates the given set policy on the network.
+func (s *HNSIPSets) modifySetPolicy(network *hcn.HostComputeNetwork, reqType hcn.RequestType, setPol hcn.SetPolicySetting) error {
+	logCxt := s.logCxt.WithFields(log.Fields{
+		"setPolicy":   setPol,
+		"requestType": reqType,
+	})
+	polJSON, err := json.Marshal(setPol)
+	if err != nil {
+		logCxt.WithError(err).Error("Failed to marshal set policy")
+		return err
+	}
+	reqJSON, err := json.Marshal(hcn.PolicyNetworkRequest{
+		Policies: []hcn.NetworkPolicy{{Type: hcn.SetPolicy, Settings: polJSON}},
+	})
+	if err != nil {
+		logCxt.WithError(err).Error("Failed to marshal set policy request")
+		return err
+	}
+	err = network.ModifyNetworkSettings(&hcn.ModifyNetworkSettingRequest{
+		ResourceType: hcn.NetworkResourceTypePolicy,
+		RequestType:  reqType,
+		Settings:     reqJSON,
+	})
+	if err != nil {
+		logCxt.WithError(err).Error("Failed to program set policy")
+	}
+	return err
+}
+
+// setPolicyFor returns the SetPolicy for the IP set, or false if the IP set can't be programmed.
+func (s *HNSIPSets) setPolicyFor(setID string, ipSet *ipSet) (hcn.SetPolicySetting, bool) {
+	if ipSet.Type == IPSetTypeHashIPPort {
+		s.logCxt.WithField("setID", setID).Debug("Not programming IP and port set as a set policy")
+		return hcn.SetPolicySetting{}, false
+	}
+	if ipSet.Members.Len() == 0 {
+		return hcn.SetPolicySetting{}, false
+	}
+	members := make([]string, 0, ipSet.Members.Len())
+	ipSet.Members.Iter(func(m string) error {
+		members = append(members, m)
+		return nil
+	})
+	sort.Strings(members)
+	name := s.IPVersionConfig.NameForMainIPSet(setID)
+	return hcn.SetPolicySetting{
+		Id:     name,
+		Name:   name,
+		Type:   hcn.SetPolicyTypeIpSet,
+		Values: strings.Join(members, ","),
+	}, true
+}
+
+// ApplyDeletions is a no-op; deletions are applied by ApplyUpdates.
+func (s *HNSIPSets) ApplyDeletions() bool {
+	return false
+}
+
+// QueueResync queues a full resync of the set policies with HNS.
+func (s *HNSIPSets) QueueResync() {
+	s.dirty = true
+}
+
+func (s *HNSIPSets) SetFilter(ipSetNames set.Set[string]) {
+	// Not needed for Windows.
+}
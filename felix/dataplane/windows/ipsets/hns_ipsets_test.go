@@ -0,0 +1,158 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipsets
+
+import (
+	"encoding/json"
+	"regexp"
+	"testing"
+
+	. "github.com/onsi/gomega"
+
+	"github.com/projectcalico/calico/felix/dataplane/windows/hcn"
+	"github.com/projectcalico/calico/felix/ipsets"
+)
+
+func TestHNSIPSetsMemberChanges(t *testing.T) {
+	RegisterTestingT(t)
+
+	foreignPolicy := hcn.NetworkPolicy{Type: "Foo", Settings: json.RawMessage("{}")}
+	h := &mockHCN{networks: []hcn.HostComputeNetwork{{Name: "Calico", Policies: []hcn.NetworkPolicy{foreignPolicy}}}}
+	s := NewHNSIPSets(NewIPVersionConfig(IPFamilyV4), h, regexp.MustCompile("Calico"))
+	name := ipsets.NewIPVersionConfig(IPFamilyV4, ipsets.IPSetNamePrefix, nil, nil, nil).NameForMainIPSet("s:abcdef")
+	Expect(s.IPVersionConfig.NameForMainIPSet("s:abcdef")).To(Equal(name))
+
+	// Creating a set programs a set policy with its members, leaving other policies alone.
+	s.AddOrReplaceIPSet(IPSetMetadata{SetID: "s:abcdef", Type: ipsets.IPSetTypeHashNet},
+		[]string{"10.0.0.2", "10.0.0.1", "fe80::1"})
+	Expect(s.ApplyUpdatesChecked()).To(Succeed())
+	Expect(h.networks[0].Policies).To(ContainElement(foreignPolicy))
+	Expect(h.setPolicies()).To(Equal([]hcn.SetPolicySetting{
+		{Id: name, Name: name, Type: hcn.SetPolicyTypeIpSet, Values: "10.0.0.1,10.0.0.2"},
+	}))
+
+	// Member changes replace the set policy.
+	s.AddMembers("s:abcdef", []string{"10.0.1.0/24"})
+	s.RemoveMembers("s:abcdef", []string{"10.0.0.1"})
+	Expect(s.ApplyUpdatesChecked()).To(Succeed())
+	Expect(h.networks[0].Policies).To(ContainElement(foreignPolicy))
+	Expect(h.setPolicies()).To(Equal([]hcn.SetPolicySetting{
+		{Id: name, Name: name, Type: hcn.SetPolicyTypeIpSet, Values: "10.0.0.2,10.0.1.0/24"},
+	}))
+
+	// Changes that don't affect the set don't cause an update.
+	s.AddMembers("s:abcdef", []string{"10.0.0.2", "fe80::2"})
+	s.RemoveMembers("s:abcdef", []string{"10.0.0.3"})
+	numLists := h.numLists
+	Expect(s.ApplyUpdatesChecked()).To(Succeed())
+	Expect(h.numLists).To(Equal(numLists))
+
+	// Removing the last member, or the set, removes the set policy.
+	s.RemoveMembers("s:abcdef", []string{"10.0.0.2", "10.0.1.0/24"})
+	Expect(s.ApplyUpdatesChecked()).To(Succeed())
+	Expect(h.setPolicies()).To(BeEmpty())
+	Expect(h.networks[0].Policies).To(Equal([]hcn.NetworkPolicy{foreignPolicy}))
+}
+
+func TestHNSIPSetsResync(t *testing.T) {
+	RegisterTestingT(t)
+
+	stale := setPolicy(hcn.SetPolicySetting{Id: "cali40s:stale", Name: "cali40s:stale", Type: hcn.SetPolicyTypeIpSet, Values: "10.0.0.9"})
+	notOurs := setPolicy(hcn.SetPolicySetting{Id: "other", Name: "other", Type: hcn.SetPolicyTypeIpSet, Values: "10.0.0.8"})
+	h := &mockHCN{networks: []hcn.HostComputeNetwork{{Name: "Calico", Policies: []hcn.NetworkPolicy{stale, notOurs}}}}
+	s := NewHNSIPSets(NewIPVersionConfig(IPFamilyV4), h, regexp.MustCompile("Calico"))
+	name := s.IPVersionConfig.NameForMainIPSet("s:abcdef")
+
+	// IP and port sets are not programmed.
+	s.AddOrReplaceIPSet(IPSetMetadata{SetID: "s:abcdef", Type: ipsets.IPSetTypeHashIP}, []string{"10.0.0.1"})
+	s.AddOrReplaceIPSet(IPSetMetadata{SetID: "s:ports", Type: ipsets.IPSetTypeHashIPPort}, []string{"10.0.0.1,tcp:80"})
+	Expect(s.ApplyUpdatesChecked()).To(Succeed())
+	Expect(h.setPolicies()).To(Equal([]hcn.SetPolicySetting{
+		{Id: "other", Name: "other", Type: hcn.SetPolicyTypeIpSet, Values: "10.0.0.8"},
+		{Id: name, Name: name, Type: hcn.SetPolicyTypeIpSet, Values: "10.0.0.1"},
+	}))
+	Expect(s.GetIPSetMembers("s:ports")).To(ConsistOf("10.0.0.1,tcp:80"))
+
+	// A failed update is retried.
+	s.RemoveIPSet("s:abcdef")
+	h.networks[0].Err = ErrSetPolicyUpdatesFailed
+	Expect(s.ApplyUpdatesChecked()).To(Equal(ErrSetPolicyUpdatesFailed))
+	h.networks[0].Err = nil
+	Expect(s.ApplyUpdatesChecked()).To(Succeed())
+	Expect(h.setPolicies()).To(Equal([]hcn.SetPolicySetting{
+		{Id: "other", Name: "other", Type: hcn.SetPolicyTypeIpSet, Values: "10.0.0.8"},
+	}))
+}
+
+func TestHNSIPSetsUpdateInPlace(t *testing.T) {
+	RegisterTestingT(t)
+
+	notOurs := setPolicy(hcn.SetPolicySetting{Id: "other", Name: "other", Type: hcn.SetPolicyTypeIpSet, Values: "10.0.0.8"})
+	h := &mockHCN{networks: []hcn.HostComputeNetwork{{Name: "Calico", Policies: []hcn.NetworkPolicy{notOurs}}}}
+	s := NewHNSIPSets(NewIPVersionConfig(IPFamilyV4), h, regexp.MustCompile("Calico"))
+	name := s.IPVersionConfig.NameForMainIPSet("s:abcdef")
+	name2 := s.IPVersionConfig.NameForMainIPSet("s:ghijkl")
+
+	s.AddOrReplaceIPSet(IPSetMetadata{SetID: "s:abcdef", Type: ipsets.IPSetTypeHashIP}, []string{"10.0.0.1"})
+	Expect(s.ApplyUpdatesChecked()).To(Succeed())
+
+	// Move the other policy to the end so that we can tell whether our set policy was removed
+	// and re-added, which would also move it to the end.
+	h.networks[0].Policies = append(h.networks[0].Policies[1:], notOurs)
+
+	// A member change updates the set policy in place, while a new set is added at the end.
+	s.AddMembers("s:abcdef", []string{"10.0.0.2"})
+	s.AddOrReplaceIPSet(IPSetMetadata{SetID: "s:ghijkl", Type: ipsets.IPSetTypeHashIP}, []string{"10.0.0.3"})
+	Expect(s.ApplyUpdatesChecked()).To(Succeed())
+	Expect(h.setPolicies()).To(Equal([]hcn.SetPolicySetting{
+		{Id: name, Name: name, Type: hcn.SetPolicyTypeIpSet, Values: "10.0.0.1,10.0.0.2"},
+		{Id: "other", Name: "other", Type: hcn.SetPolicyTypeIpSet, Values: "10.0.0.8"},
+		{Id: name2, Name: name2, Type: hcn.SetPolicyTypeIpSet, Values: "10.0.0.3"},
+	}))
+}
+
+type mockHCN struct {
+	networks []hcn.HostComputeNetwork
+	numLists int
+}
+
+func (h *mockHCN) ListNetworks() ([]hcn.HostComputeNetwork, error) {
+	h.numLists++
+	// Make sure all the networks have a back-pointer.
+	for i := range h.networks {
+		h.networks[i].Ptr = &h.networks[i]
+	}
+	return h.networks, nil
+}
+
+// setPolicies returns the settings of the set policies on the first network.
+func (h *mockHCN) setPolicies() []hcn.SetPolicySetting {
+	var settings []hcn.SetPolicySetting
+	for _, p := range h.networks[0].Policies {
+		if p.Type != hcn.SetPolicy {
+			continue
+		}
+		var s hcn.SetPolicySetting
+		Expect(json.Unmarshal(p.Settings, &s)).To(Succeed())
+		settings = append(settings, s)
+	}
+	return settings
+}
+
+func setPolicy(s hcn.SetPolicySetting) hcn.NetworkPolicy {
+	polJSON, err := json.Marshal(s)
+	Expect(err).NotTo(HaveOccurred())
+	return hcn.NetworkPolicy{Type: hcn.SetPolicy, Settings: polJSON}
+}
@@ -41,10 +41,25 @@ type ipSet struct {
 // IPVersionConfig wraps up the metadata for a particular IP version.
 type IPVersionConfig struct {
 	Family IPFamily
+
+	// names gives the IP sets the same names as they have on Linux.
+	names *ipsets.IPVersionConfig
 }
 
 func NewIPVersionConfig(family IPFamily) *IPVersionConfig {
 	return &IPVersionConfig{
 		Family: family,
+		names:  ipsets.NewIPVersionConfig(family, ipsets.IPSetNamePrefix, nil, nil, nil),
 	}
 }
+
+// NameForMainIPSet converts the given IP set ID to the name that the IP set has in the dataplane,
+// which is the same as its name on Linux (example: "cali40s:qMt7iLlGDhvLnCjM0l9nzxb").
+func (c IPVersionConfig) NameForMainIPSet(setID string) string {
+	return c.names.NameForMainIPSet(setID)
+}
+
+// OwnsIPSet returns true if the given IP set name appears to belong to Felix.
+func (c IPVersionConfig) OwnsIPSet(setName string) bool {
+	return c.names.OwnsIPSet(setName)
+}
@@ -142,8 +142,13 @@ func (s *IPSets) GetIPSetMembers(setID string) []string {
 // filterMembers filters out any members which are not of the correct
 // ip family for the IPSet
 func (s *IPSets) filterMembers(members []string, setType IPSetType) set.Set[string] {
+	return filterMembers(s.IPVersionConfig.Family, members, setType)
+}
+
+// filterMembers filters out any members which are not of the given ip family.
+func filterMembers(family IPFamily, members []string, setType IPSetType) set.Set[string] {
 	filtered := set.New[string]()
-	wantIPV6 := s.IPVersionConfig.Family == IPFamilyV6
+	wantIPV6 := family == IPFamilyV6
 
 	// IPSet members can come in two forms: IP, or IP and port.
 	// To determine the address family for an IP set member, we must first
@@ -189,6 +194,10 @@ func (m *IPSets) GetDesiredMembers(setID string) (set.Set[string], error) {
 func (m *IPSets) ApplyUpdates() {
 }
 
+func (m *IPSets) ApplyUpdatesChecked() error {
+	return nil
+}
+
 func (m *IPSets) ApplyDeletions() bool {
 	return false
 }
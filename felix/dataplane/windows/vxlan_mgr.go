@@ -18,6 +18,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 	"regexp"
 	"strings"
 
@@ -32,6 +33,15 @@ var (
 	ErrUpdatesFailed = errors.New("some VXLAN route updates failed")
 )
 
+const (
+	// vxlanMTUOverhead is the number of bytes that VXLAN encapsulation adds to a packet.
+	vxlanMTUOverhead = 50
+
+	// minVXLANMTU and maxVXLANMTU bound the VXLAN MTUs that we accept.
+	minVXLANMTU = 576
+	maxVXLANMTU = 9000
+)
+
 type vxlanManager struct {
 	// Shim for the Windows HNS API.
 	hcn hcnInterface
@@ -47,6 +57,7 @@ type vxlanManager struct {
 	networkName *regexp.Regexp
 	vxlanID     int
 	vxlanPort   int
+	vxlanMTU    int
 
 	// Indicates if configuration has changed since the last apply.
 	dirty bool
@@ -56,7 +67,7 @@ type hcnInterface interface {
 	ListNetworks() ([]hcn.HostComputeNetwork, error)
 }
 
-func newVXLANManager(hcn hcnInterface, hostname string, networkName *regexp.Regexp, vxlanID, port int) *vxlanManager {
+func newVXLANManager(hcn hcnInterface, hostname string, networkName *regexp.Regexp, vxlanID, port, mtu int) *vxlanManager {
 	return &vxlanManager{
 		hcn:          hcn,
 		hostname:     hostname,
@@ -65,6 +76,7 @@ func newVXLANManager(hcn hcnInterface, hostname string, networkName *regexp.Rege
 		networkName:  networkName,
 		vxlanID:      vxlanID,
 		vxlanPort:    port,
+		vxlanMTU:     mtu,
 		dirty:        true,
 	}
 }
@@ -254,3 +266,47 @@ func macToWindowsFormat(linuxFormat string) string {
 	windowsFormat := strings.Replace(linuxFormat, ":", "-", -1)
 	return windowsFormat
 }
+
+// determineVXLANMTU validates the configured VXLAN MTU, defaulting it from the host's MTU if it
+// is not set.  A configured MTU that doesn't fit in the host's MTU is allowed but logged.
+func determineVXLANMTU(mtu int, findHostMTU func() (int, error)) (int, error) {
+	hostMTU, err := findHostMTU()
+	if err != nil {
+		logrus.WithError(err).Warn("Failed to detect host MTU")
+		hostMTU = 0
+	}
+	if mtu == 0 {
+		if hostMTU == 0 {
+			logrus.Warn("VXLAN MTU not configured and host MTU unknown, leaving MTU to HNS")
+			return 0, nil
+		}
+		mtu = hostMTU - vxlanMTUOverhead
+		logrus.WithField("mtu", mtu).Info("Defaulting VXLAN MTU based on host")
+	}
+	if mtu < minVXLANMTU || mtu > maxVXLANMTU {
+		return 0, fmt.Errorf("VXLAN MTU %d is outside the allowed range %d-%d", mtu, minVXLANMTU, maxVXLANMTU)
+	}
+	if hostMTU != 0 && mtu > hostMTU-vxlanMTUOverhead {
+		logrus.WithFields(logrus.Fields{"mtu": mtu, "hostMTU": hostMTU}).Warn(
+			"Configured VXLAN MTU is larger than the host interface MTU allows")
+	}
+	return mtu, nil
+}
+
+// findHostMTU auto-detects the smallest MTU of the host's active, non-loopback interfaces.
+func findHostMTU() (int, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return 0, err
+	}
+	smallest := 0
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		if iface.MTU > 0 && (iface.MTU < smallest || smallest == 0) {
+			smallest = iface.MTU
+		}
+	}
+	return smallest, nil
+}
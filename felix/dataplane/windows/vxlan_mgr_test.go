@@ -23,6 +23,7 @@ import (
 	. "github.com/onsi/gomega"
 
 	"github.com/projectcalico/calico/felix/dataplane/windows/hcn"
+	"github.com/projectcalico/calico/felix/dataplane/windows/hns"
 	"github.com/projectcalico/calico/felix/proto"
 )
 
@@ -32,7 +33,7 @@ var _ = Describe("VXLAN manager tests", func() {
 
 	BeforeEach(func() {
 		dataplane = &mockHCN{}
-		mgr = newVXLANManager(dataplane, "my-host", regexp.MustCompile("Calico"), 4096, 8000)
+		mgr = newVXLANManager(dataplane, "my-host", regexp.MustCompile("Calico"), 4096, 8000, 1400)
 	})
 
 	Describe("with an old policy in place", func() {
@@ -306,6 +307,55 @@ var _ = Describe("VXLAN manager tests", func() {
 	})
 })
 
+var _ = Describe("VXLAN MTU tests", func() {
+	hostMTU := func(mtu int) func() (int, error) {
+		return func() (int, error) { return mtu, nil }
+	}
+
+	It("should default the MTU from the host", func() {
+		Expect(determineVXLANMTU(0, hostMTU(1500))).To(Equal(1450))
+	})
+
+	It("should honor a configured MTU", func() {
+		Expect(determineVXLANMTU(1400, hostMTU(1500))).To(Equal(1400))
+	})
+
+	It("should allow, but not default, an MTU if the host MTU is unknown", func() {
+		failed := func() (int, error) { return 0, errors.New("dummy error") }
+		Expect(determineVXLANMTU(1400, failed)).To(Equal(1400))
+		Expect(determineVXLANMTU(0, failed)).To(Equal(0))
+	})
+
+	It("should allow an MTU larger than the host allows", func() {
+		Expect(determineVXLANMTU(1500, hostMTU(1500))).To(Equal(1500))
+	})
+
+	It("should reject an MTU out of range", func() {
+		_, err := determineVXLANMTU(100, hostMTU(1500))
+		Expect(err).To(HaveOccurred())
+		_, err = determineVXLANMTU(65000, hostMTU(1500))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should pass the MTU through to the VXLAN manager", func() {
+		dp, err := NewWinDataplaneDriver(hns.API{}, Config{VXLANEnabled: true, VXLANMTU: 1400})
+		Expect(err).NotTo(HaveOccurred())
+		var vxlanMgr *vxlanManager
+		for _, mgr := range dp.allManagers {
+			if m, ok := mgr.(*vxlanManager); ok {
+				vxlanMgr = m
+			}
+		}
+		Expect(vxlanMgr).NotTo(BeNil())
+		Expect(vxlanMgr.vxlanMTU).To(Equal(1400))
+	})
+
+	It("should fail to construct the driver with an invalid MTU", func() {
+		_, err := NewWinDataplaneDriver(hns.API{}, Config{VXLANEnabled: true, VXLANMTU: 10})
+		Expect(err).To(HaveOccurred())
+	})
+})
+
 type mockHCN struct {
 	networks []hcn.HostComputeNetwork
 }
@@ -15,6 +15,7 @@
 package windataplane
 
 import (
+	"fmt"
 	"math"
 	"regexp"
 	"time"
@@ -23,8 +24,6 @@ import (
 
 	log "github.com/sirupsen/logrus"
 
-	"github.com/projectcalico/calico/felix/dataplane/windows/hns"
-
 	"github.com/projectcalico/calico/felix/dataplane/common"
 	"github.com/projectcalico/calico/felix/dataplane/windows/ipsets"
 	"github.com/projectcalico/calico/felix/dataplane/windows/policysets"
@@ -64,6 +63,7 @@ type Config struct {
 	VXLANEnabled bool
 	VXLANID      int
 	VXLANPort    int
+	VXLANMTU     int // 0 means default it from the host's MTU.
 }
 
 // winDataplane implements an in-process Felix dataplane driver capable of applying network policy
@@ -113,9 +113,13 @@ type WindowsDataplane struct {
 	// dataplaneNeedsSync is set if the dataplane is dirty in some way, i.e. we need to
 	// call apply().
 	dataplaneNeedsSync bool
-	// doneFirstApply is set after we finish the first update to the dataplane. It indicates
-	// that the dataplane should now be in sync.
+	// doneFirstApply is set after we finish the first successful update to the dataplane. It
+	// indicates that the dataplane should now be in sync.
 	doneFirstApply bool
+	// consecutiveApplyFailures counts the updates to the dataplane that have failed since the
+	// last one that succeeded.  Once it reaches maxConsecutiveApplyFailures, we report that
+	// we're not live.
+	consecutiveApplyFailures int
 	// the reschedule timer/channel enable us to force the dataplane driver to attempt to
 	// apply any pending updates to the dataplane. This is only enabled and used if a previous
 	// apply operation has failed and needs to be retried.
@@ -133,6 +137,11 @@ const (
 	healthName     = "WindowsDataplaneMainLoop"
 	healthInterval = 10 * time.Second
 	healthTimeout  = 90 * time.Second
+
+	// maxConsecutiveApplyFailures is the number of failed updates to the dataplane, in a row,
+	// after which we report that we're not live.  Failed updates are retried every
+	// reschedDelay, so this is roughly a minute of HNS failing.
+	maxConsecutiveApplyFailures = 12
 )
 
 // Interface for Managers. Each Manager is responsible for processing updates from felix and
@@ -154,7 +163,7 @@ func (d *WindowsDataplane) RegisterManager(mgr Manager) {
 
 // NewWinDataplaneDriver creates and initializes a new dataplane driver using the provided
 // configuration.
-func NewWinDataplaneDriver(hns hns.API, config Config) *WindowsDataplane {
+func NewWinDataplaneDriver(hns HNSAPI, config Config) (*WindowsDataplane, error) {
 	log.WithField("config", config).Info("Creating Windows dataplane driver.")
 
 	ipSetsConfigV4 := ipsets.NewIPVersionConfig(
@@ -184,17 +193,26 @@ func NewWinDataplaneDriver(hns hns.API, config Config) *WindowsDataplane {
 
 	dp.RegisterManager(common.NewIPSetsManager("ipv4", ipSetsV4, config.MaxIPSetSize))
 	dp.RegisterManager(newPolicyManager(dp.policySets))
-	dp.endpointMgr = newEndpointManager(hns, dp.policySets)
+	endpointMgr, err := newEndpointManager(hns, dp.policySets)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create endpoint manager: %w", err)
+	}
+	dp.endpointMgr = endpointMgr
 	dp.RegisterManager(dp.endpointMgr)
 	ipSetsV4.SetCallback(dp.endpointMgr.OnIPSetsUpdate)
 	if config.VXLANEnabled {
 		log.Info("VXLAN enabled, starting the VXLAN manager")
+		vxlanMTU, err := determineVXLANMTU(config.VXLANMTU, findHostMTU)
+		if err != nil {
+			return nil, err
+		}
 		dp.RegisterManager(newVXLANManager(
 			hcn.API{},
 			config.Hostname,
 			regexp.MustCompile(defaultNetworkName), // FIXME Hard-coded regex
 			config.VXLANID,
 			config.VXLANPort,
+			vxlanMTU,
 		))
 	} else {
 		log.Info("VXLAN disabled, not starting the VXLAN manager")
@@ -210,13 +228,17 @@ func NewWinDataplaneDriver(hns hns.API, config Config) *WindowsDataplane {
 		)
 	}
 
-	return dp
+	return dp, nil
 }
 
-// Starts the driver.
-func (d *WindowsDataplane) Start() {
+// Starts the driver.  Returns an error, without starting the driver, if HNS can't be reached.
+func (d *WindowsDataplane) Start() error {
+	if err := d.endpointMgr.RefreshHnsEndpointCache(true); err != nil {
+		return fmt.Errorf("failed to initialize HNS: %w", err)
+	}
 	go d.loopUpdatingDataplane()
 	go loopPollingForInterfaceAddrs(d.ifaceAddrUpdates)
+	return nil
 }
 
 // Called by someone to put a message into our channel so that the loop will pick it up
@@ -314,13 +336,6 @@ func (d *WindowsDataplane) loopUpdatingDataplane() {
 				log.WithField("msecToApply", applyTime.Seconds()*1000.0).Info(
 					"Finished applying updates to dataplane.")
 
-				if !d.doneFirstApply {
-					log.WithField(
-						"secsSinceStart", time.Since(processStartTime).Seconds(),
-					).Info("Completed first update to dataplane.")
-					d.doneFirstApply = true
-				}
-
 				d.reportHealth()
 			} else {
 				if !beingThrottled {
@@ -350,6 +365,23 @@ func (d *WindowsDataplane) apply() {
 		}
 	}
 
+	// Keep track of failures for health reporting.
+	if scheduleRetry {
+		d.consecutiveApplyFailures++
+		if d.consecutiveApplyFailures == maxConsecutiveApplyFailures {
+			log.WithField("numFailures", d.consecutiveApplyFailures).Error(
+				"Repeatedly failed to apply dataplane updates, reporting non-live")
+		}
+	} else {
+		d.consecutiveApplyFailures = 0
+		if !d.doneFirstApply {
+			log.WithField(
+				"secsSinceStart", time.Since(processStartTime).Seconds(),
+			).Info("Completed first update to dataplane.")
+			d.doneFirstApply = true
+		}
+	}
+
 	// Set up any needed rescheduling kick.
 	if d.reschedC != nil {
 		// We have an active rescheduling timer, stop it so we can restart it with a
@@ -375,12 +407,17 @@ func (d *WindowsDataplane) apply() {
 	}
 }
 
-// Invoked periodically to report health (liveness/readiness)
+// Invoked periodically, and after each update to the dataplane, to report health
+// (liveness/readiness).  We're ready once we've completed the first update to the dataplane,
+// and live unless updates have been failing repeatedly.
 func (d *WindowsDataplane) reportHealth() {
 	if d.config.HealthAggregator != nil {
 		d.config.HealthAggregator.Report(
 			healthName,
-			&health.HealthReport{Live: true, Ready: d.doneFirstApply},
+			&health.HealthReport{
+				Live:  d.consecutiveApplyFailures < maxConsecutiveApplyFailures,
+				Ready: d.doneFirstApply,
+			},
 		)
 	}
 }
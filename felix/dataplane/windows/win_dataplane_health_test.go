@@ -0,0 +1,107 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package windataplane
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/projectcalico/calico/felix/dataplane/windows/hns"
+	"github.com/projectcalico/calico/felix/proto"
+	"github.com/projectcalico/calico/libcalico-go/lib/health"
+)
+
+var _ = Describe("Windows dataplane health tests", func() {
+	var dp *WindowsDataplane
+	var healthAgg *health.HealthAggregator
+
+	wepID := &proto.WorkloadEndpointID{
+		OrchestratorId: "k8s",
+		WorkloadId:     "default/pod",
+		EndpointId:     "eth0",
+	}
+
+	sendUpdate := func(msg interface{}) {
+		for _, mgr := range dp.allManagers {
+			mgr.OnUpdate(msg)
+		}
+	}
+	applyAndReport := func() {
+		dp.apply()
+		dp.reportHealth()
+	}
+
+	BeforeEach(func() {
+		healthAgg = health.NewHealthAggregator()
+		var err error
+		dp, err = NewWinDataplaneDriver(&failingHNS{}, Config{HealthAggregator: healthAgg})
+		Expect(err).NotTo(HaveOccurred())
+		dp.reportHealth()
+	})
+
+	It("should be live but not ready before the first update", func() {
+		Expect(healthAgg.Summary().Live).To(BeTrue())
+		Expect(healthAgg.Summary().Ready).To(BeFalse())
+	})
+
+	It("should become ready after a successful update", func() {
+		applyAndReport()
+		Expect(healthAgg.Summary().Live).To(BeTrue())
+		Expect(healthAgg.Summary().Ready).To(BeTrue())
+	})
+
+	Describe("with an endpoint that can't be found in HNS", func() {
+		BeforeEach(func() {
+			sendUpdate(&proto.WorkloadEndpointUpdate{
+				Id:       wepID,
+				Endpoint: &proto.WorkloadEndpoint{Ipv4Nets: []string{"10.0.0.1/32"}},
+			})
+		})
+
+		It("should stay live, but not ready, while failures are few", func() {
+			for i := 0; i < maxConsecutiveApplyFailures-1; i++ {
+				applyAndReport()
+			}
+			Expect(healthAgg.Summary().Live).To(BeTrue())
+			Expect(healthAgg.Summary().Ready).To(BeFalse())
+		})
+
+		It("should become non-live after repeated failures, then recover", func() {
+			for i := 0; i < maxConsecutiveApplyFailures; i++ {
+				applyAndReport()
+			}
+			Expect(healthAgg.Summary().Live).To(BeFalse())
+			Expect(healthAgg.Summary().Ready).To(BeFalse())
+
+			sendUpdate(&proto.WorkloadEndpointRemove{Id: wepID})
+			applyAndReport()
+			Expect(healthAgg.Summary().Live).To(BeTrue())
+			Expect(healthAgg.Summary().Ready).To(BeTrue())
+		})
+	})
+})
+
+// failingHNS is an HNS API stub that fails to list endpoints.
+type failingHNS struct{}
+
+func (*failingHNS) GetHNSSupportedFeatures() hns.HNSSupportedFeatures {
+	return hns.HNSSupportedFeatures{}
+}
+
+func (*failingHNS) HNSListEndpointRequest() ([]hns.HNSEndpoint, error) {
+	return nil, errors.New("dummy error")
+}
@@ -15,6 +15,8 @@
 package windataplane_test
 
 import (
+	"errors"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
@@ -37,7 +39,29 @@ var _ = Describe("Constructor test", func() {
 	})
 
 	It("should be constructable", func() {
-		var dp = windataplane.NewWinDataplaneDriver(hns.API{}, dpConfig)
+		dp, err := windataplane.NewWinDataplaneDriver(hns.API{}, dpConfig)
+		Expect(err).NotTo(HaveOccurred())
 		Expect(dp).ToNot(BeNil())
 	})
+
+	It("should return an error from Start if HNS fails", func() {
+		dp, err := windataplane.NewWinDataplaneDriver(failingHNS{}, dpConfig)
+		Expect(err).NotTo(HaveOccurred())
+		err = dp.Start()
+		Expect(err).To(HaveOccurred())
+		Expect(errors.Is(err, errHNSDown)).To(BeTrue())
+	})
 })
+
+var errHNSDown = errors.New("HNS is down")
+
+// failingHNS is an HNS API stub that fails all requests.
+type failingHNS struct{}
+
+func (failingHNS) GetHNSSupportedFeatures() hns.HNSSupportedFeatures {
+	return hns.HNSSupportedFeatures{}
+}
+
+func (failingHNS) HNSListEndpointRequest() ([]hns.HNSEndpoint, error) {
+	return nil, errHNSDown
+}
@@ -115,6 +115,10 @@ func (s *DataplaneSetView[K]) Contains(k K) bool {
 	return exists
 }
 
+func (s *DataplaneSetView[K]) Len() int {
+	return s.asMapView().Len()
+}
+
 func (s *DataplaneSetView[K]) Iter(f func(k K)) {
 	s.asMapView().Iter(func(k K, v struct{}) {
 		f(k)
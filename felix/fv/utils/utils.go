@@ -224,6 +224,7 @@ func IPSetNameForSelector(ipVersion int, rawSelector string) string {
 		rules.IPSetNamePrefix,
 		nil,
 		nil,
+		nil,
 	)
 
 	return ipVerConf.NameForMainIPSet(setID)
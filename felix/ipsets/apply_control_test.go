@@ -0,0 +1,267 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipsets_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+	logrustest "github.com/sirupsen/logrus/hooks/test"
+
+	. "github.com/projectcalico/calico/felix/ipsets"
+	"github.com/projectcalico/calico/libcalico-go/lib/set"
+)
+
+// Tests for the modes that control whether IPSets runs its ipset commands.
+var _ = Describe("IP sets apply control", func() {
+	var (
+		dataplane *mockDataplane
+		ipsets    *IPSets
+	)
+
+	meta := IPSetMetadata{
+		MaxSize: 1234,
+		SetID:   ipSetID,
+		Type:    IPSetTypeHashIP,
+	}
+	meta2 := IPSetMetadata{
+		MaxSize: 1234,
+		SetID:   ipSetID2,
+		Type:    IPSetTypeHashIP,
+	}
+
+	BeforeEach(func() {
+		dataplane = newMockDataplane()
+	})
+
+	Describe("suspend and resume", func() {
+		BeforeEach(func() {
+			ipsets = newTestIPSets(dataplane, IPFamilyV4)
+			ipsets.AddOrReplaceIPSet(meta, []string{"10.0.0.1"})
+			ipsets.AddOrReplaceIPSet(meta2, []string{"10.0.0.2"})
+			ipsets.ApplyUpdates()
+			ipsets.ApplyDeletions()
+			dataplane.ExpectMembers(map[string][]string{
+				v4MainIPSetName:  {"10.0.0.1"},
+				v4MainIPSetName2: {"10.0.0.2"},
+			})
+			dataplane.CmdNames = nil
+		})
+
+		It("should not be suspended by default", func() {
+			Expect(ipsets.Suspended()).To(BeFalse())
+		})
+
+		Describe("while suspended", func() {
+			BeforeEach(func() {
+				ipsets.Suspend()
+				Expect(ipsets.Suspended()).To(BeTrue())
+
+				ipsets.AddMembers(ipSetID, []string{"10.0.0.3"})
+				ipsets.RemoveMembers(ipSetID, []string{"10.0.0.1"})
+				ipsets.RemoveIPSet(ipSetID2)
+				ipsets.AddOrReplaceIPSet(IPSetMetadata{
+					MaxSize: 1234,
+					SetID:   ipSetID3,
+					Type:    IPSetTypeHashIP,
+				}, []string{"10.0.0.4"})
+				ipsets.QueueResync()
+			})
+
+			It("should not issue any commands", func() {
+				ipsets.ApplyUpdates()
+				Expect(ipsets.ApplyDeletions()).To(BeFalse())
+				Expect(dataplane.CmdNames).To(BeEmpty())
+				dataplane.ExpectMembers(map[string][]string{
+					v4MainIPSetName:  {"10.0.0.1"},
+					v4MainIPSetName2: {"10.0.0.2"},
+				})
+			})
+
+			It("should still track the desired state", func() {
+				members, err := ipsets.GetDesiredMembers(ipSetID)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(members.Slice()).To(ConsistOf("10.0.0.3"))
+			})
+
+			It("should apply all the accumulated changes on resume", func() {
+				ipsets.ApplyUpdates()
+				ipsets.ApplyDeletions()
+
+				ipsets.Resume()
+				Expect(ipsets.Suspended()).To(BeFalse())
+				Expect(dataplane.CmdNames).NotTo(BeEmpty())
+				dataplane.ExpectMembers(map[string][]string{
+					v4MainIPSetName:  {"10.0.0.3"},
+					v4MainIPSetName3: {"10.0.0.4"},
+				})
+			})
+
+			It("should ignore repeated calls to Suspend", func() {
+				ipsets.Suspend()
+				ipsets.Resume()
+				Expect(ipsets.Suspended()).To(BeFalse())
+			})
+		})
+
+		It("should do nothing on Resume if not suspended", func() {
+			Expect(ipsets.Resume()).To(BeFalse())
+			Expect(dataplane.CmdNames).To(BeEmpty())
+		})
+	})
+
+	Describe("dry run", func() {
+		var (
+			oldHooks log.LevelHooks
+			logHook  *logrustest.Hook
+		)
+
+		newDryRunIPSets := func(opts ...IPSetsOpt) {
+			ipsets = newTestIPSets(dataplane, IPFamilyV4, append([]IPSetsOpt{WithDryRun()}, opts...)...)
+			Expect(ipsets.DryRun()).To(BeTrue())
+		}
+
+		// dryRunCommands returns the commands and input logged in dry-run mode since the last call.
+		dryRunCommands := func() (cmds []string) {
+			for _, e := range logHook.AllEntries() {
+				if e.Message == "Dry run: skipping ipset command." {
+					cmds = append(cmds, e.Data["command"].(string)+"\n"+e.Data["input"].(string))
+				}
+			}
+			logHook.Reset()
+			return
+		}
+
+		BeforeEach(func() {
+			oldHooks = log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+			logHook = logrustest.NewGlobal()
+			// A left-over IP set, which dry-run mode should leave alone.
+			dataplane.IPSetMembers[v4MainIPSetName2] = set.From("10.0.0.9")
+		})
+
+		AfterEach(func() {
+			log.StandardLogger().ReplaceHooks(oldHooks)
+		})
+
+		It("should be disabled by default", func() {
+			Expect(newTestIPSets(dataplane, IPFamilyV4).DryRun()).To(BeFalse())
+		})
+
+		It("should log the updates without running any commands", func() {
+			newDryRunIPSets()
+			ipsets.AddOrReplaceIPSet(meta, []string{"10.0.0.1", "10.0.0.2"})
+			ipsets.ApplyUpdates()
+			Expect(ipsets.ApplyDeletions()).To(BeFalse())
+
+			Expect(dataplane.CmdNames).To(BeEmpty())
+			dataplane.ExpectMembers(map[string][]string{
+				v4MainIPSetName2: {"10.0.0.9"},
+			})
+			Expect(dryRunCommands()).To(Equal([]string{
+				"ipset restore\n" +
+					"create " + v4MainIPSetName + " hash:ip family inet maxelem 1234\n" +
+					"add " + v4MainIPSetName + " 10.0.0.1\n" +
+					"add " + v4MainIPSetName + " 10.0.0.2\n" +
+					"COMMIT\n",
+			}))
+
+			By("only logging the changes since the previous update")
+			ipsets.AddMembers(ipSetID, []string{"10.0.0.3"})
+			ipsets.ApplyUpdates()
+			Expect(ipsets.ApplyDeletions()).To(BeFalse())
+			Expect(dataplane.CmdNames).To(BeEmpty())
+			Expect(dryRunCommands()).To(Equal([]string{
+				"ipset restore\n" +
+					"add " + v4MainIPSetName + " 10.0.0.3\n" +
+					"COMMIT\n",
+			}))
+
+			By("logging nothing once the updates have been applied")
+			ipsets.ApplyUpdates()
+			Expect(dataplane.CmdNames).To(BeEmpty())
+			Expect(dryRunCommands()).To(BeEmpty())
+		})
+
+		It("should log deletions and record them as done without running any commands", func() {
+			newDryRunIPSets()
+			ipsets.AddOrReplaceIPSet(meta, []string{"10.0.0.1"})
+			ipsets.ApplyUpdates()
+			Expect(dryRunCommands()).To(HaveLen(1))
+
+			ipsets.RemoveIPSet(ipSetID)
+			ipsets.ApplyUpdates()
+			Expect(ipsets.ApplyDeletions()).To(BeFalse())
+			Expect(dataplane.CmdNames).To(BeEmpty())
+			Expect(dryRunCommands()).To(Equal([]string{
+				"ipset destroy " + v4MainIPSetName + "\n",
+			}))
+
+			By("not deleting the IP set again")
+			Expect(ipsets.ApplyDeletions()).To(BeFalse())
+			Expect(dryRunCommands()).To(BeEmpty())
+		})
+
+		It("should not read or delete the IP sets in the dataplane", func() {
+			newDryRunIPSets(WithBatchedDeletions(10))
+			ipsets.ApplyUpdates()
+			Expect(ipsets.ApplyDeletions()).To(BeFalse())
+
+			Expect(dataplane.CmdNames).To(BeEmpty())
+			Expect(dataplane.IPSetMembers).To(HaveKey(v4MainIPSetName2))
+			Expect(dryRunCommands()).To(BeEmpty())
+		})
+	})
+
+	Describe("with the ipset command missing", func() {
+		BeforeEach(func() {
+			dataplane.CommandMissing = true
+			ipsets = newTestIPSets(dataplane, IPFamilyV4)
+			ipsets.AddOrReplaceIPSet(meta, []string{"10.0.0.1"})
+		})
+
+		It("should return ErrIPSetCommandMissing rather than retrying and panicking", func() {
+			dataplane.NumMissingCmds = 0
+			Expect(ipsets.ApplyUpdatesChecked()).To(MatchError(ErrIPSetCommandMissing))
+			Expect(dataplane.NumMissingCmds).To(Equal(1), "Should give up after the first failure")
+			Expect(dataplane.CumulativeSleep).To(BeZero())
+			Expect(ipsets.ApplyUpdates).NotTo(Panic())
+		})
+
+		It("should apply the updates once the ipset command is present", func() {
+			Expect(ipsets.ApplyUpdatesChecked()).To(MatchError(ErrIPSetCommandMissing))
+
+			dataplane.CommandMissing = false
+			Expect(ipsets.ApplyUpdatesChecked()).To(Succeed())
+			dataplane.ExpectMembers(map[string][]string{
+				v4MainIPSetName: {"10.0.0.1"},
+			})
+		})
+
+		It("should keep IP sets dirty if the ipset command goes missing after a resync", func() {
+			dataplane.CommandMissing = false
+			Expect(ipsets.ApplyUpdatesChecked()).To(Succeed())
+
+			dataplane.CommandMissing = true
+			ipsets.AddMembers(ipSetID, []string{"10.0.0.2"})
+			Expect(ipsets.ApplyUpdatesChecked()).To(MatchError(ErrIPSetCommandMissing))
+
+			dataplane.CommandMissing = false
+			Expect(ipsets.ApplyUpdatesChecked()).To(Succeed())
+			dataplane.ExpectMembers(map[string][]string{
+				v4MainIPSetName: {"10.0.0.1", "10.0.0.2"},
+			})
+		})
+	})
+})
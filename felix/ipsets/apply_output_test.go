@@ -0,0 +1,209 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipsets_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+	logrustest "github.com/sirupsen/logrus/hooks/test"
+
+	. "github.com/projectcalico/calico/felix/ipsets"
+)
+
+// Tests for the input that IPSets sends to 'ipset restore' and what it logs about it.
+var _ = Describe("IP sets apply output", func() {
+	var (
+		dataplane *mockDataplane
+		ipsets    *IPSets
+	)
+
+	meta := IPSetMetadata{
+		MaxSize: 1234,
+		SetID:   ipSetID,
+		Type:    IPSetTypeHashIP,
+	}
+	meta2 := IPSetMetadata{
+		MaxSize: 1234,
+		SetID:   ipSetID2,
+		Type:    IPSetTypeHashIP,
+	}
+
+	BeforeEach(func() {
+		dataplane = newMockDataplane()
+	})
+
+	Describe("commit strategy", func() {
+		// updateTwoSets creates two IP sets with a single restore and returns the lines that were
+		// sent to ipset restore, with the IP sets in a known order.
+		updateTwoSets := func() []string {
+			ipsets.AddOrReplaceIPSet(meta, []string{"10.0.0.1"})
+			ipsets.AddOrReplaceIPSet(meta2, []string{"10.0.0.2"})
+			ipsets.ApplyUpdates()
+			dataplane.ExpectMembers(map[string][]string{
+				v4MainIPSetName:  {"10.0.0.1"},
+				v4MainIPSetName2: {"10.0.0.2"},
+			})
+			Expect(dataplane.NumRestoreCalls()).To(Equal(1))
+			return dataplane.LinesExecuted
+		}
+
+		// blocks splits the lines into the blocks ended by each COMMIT.
+		blocks := func(lines []string) [][]string {
+			var out [][]string
+			var current []string
+			for _, l := range lines {
+				current = append(current, l)
+				if l == "COMMIT" {
+					out = append(out, current)
+					current = nil
+				}
+			}
+			Expect(current).To(BeEmpty(), "input should end with a COMMIT")
+			return out
+		}
+
+		It("should default to a single COMMIT", func() {
+			ipsets = newTestIPSets(dataplane, IPFamilyV4)
+			lines := updateTwoSets()
+			Expect(blocks(lines)).To(HaveLen(1))
+			Expect(lines[len(lines)-1]).To(Equal("COMMIT"))
+		})
+
+		It("should use a single COMMIT with the batched strategy", func() {
+			ipsets = newTestIPSets(dataplane, IPFamilyV4, WithCommitStrategy(CommitStrategyBatched))
+			Expect(blocks(updateTwoSets())).To(HaveLen(1))
+		})
+
+		It("should COMMIT after each IP set with the per-set strategy", func() {
+			ipsets = newTestIPSets(dataplane, IPFamilyV4, WithCommitStrategy(CommitStrategyPerSet))
+			bs := blocks(updateTwoSets())
+			Expect(bs).To(HaveLen(2))
+			for _, b := range bs {
+				// Each block creates and populates exactly one IP set.
+				Expect(b).To(HaveLen(3))
+				Expect(b[0]).To(HavePrefix("create "))
+				Expect(b[1]).To(HavePrefix("add "))
+			}
+			Expect([]string{bs[0][0], bs[1][0]}).To(ConsistOf(
+				HavePrefix("create "+v4MainIPSetName+" "),
+				HavePrefix("create "+v4MainIPSetName2+" "),
+			))
+		})
+
+		It("should not COMMIT with the legacy restore flavor", func() {
+			dataplane.LegacyIPSet = true
+			ipsets = newTestIPSets(dataplane, IPFamilyV4, WithCommitStrategy(CommitStrategyPerSet))
+			Expect(updateTwoSets()).NotTo(ContainElement("COMMIT"))
+		})
+
+		It("should format the strategies", func() {
+			Expect(CommitStrategyBatched.String()).To(Equal("Batched"))
+			Expect(CommitStrategyPerSet.String()).To(Equal("PerSet"))
+			Expect(CommitStrategy(7).String()).To(Equal("CommitStrategy(7)"))
+		})
+	})
+
+	Describe("summary", func() {
+		var (
+			logHook  *logrustest.Hook
+			oldHooks log.LevelHooks
+		)
+
+		// summaries returns the messages of the summary log lines since the last call.
+		summaries := func() (msgs []string) {
+			for _, e := range logHook.AllEntries() {
+				if e.Level == log.InfoLevel && e.Data["mode"] != nil {
+					msgs = append(msgs, e.Message)
+				}
+			}
+			logHook.Reset()
+			return
+		}
+
+		BeforeEach(func() {
+			oldHooks = log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+			logHook = logrustest.NewGlobal()
+			ipsets = newTestIPSets(dataplane, IPFamilyV4)
+			ipsets.AddOrReplaceIPSet(meta, []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"})
+			ipsets.ApplyUpdates()
+		})
+
+		AfterEach(func() {
+			log.StandardLogger().ReplaceHooks(oldHooks)
+		})
+
+		It("should summarise the creation of an IP set", func() {
+			Expect(summaries()).To(Equal([]string{ipSetID + ": +3 -0 members (create)"}))
+		})
+
+		It("should summarise a delta", func() {
+			summaries()
+			ipsets.AddMembers(ipSetID, []string{"10.0.0.4", "10.0.0.5"})
+			ipsets.RemoveMembers(ipSetID, []string{"10.0.0.1"})
+			ipsets.ApplyUpdates()
+			Expect(summaries()).To(Equal([]string{ipSetID + ": +2 -1 members (delta)"}))
+		})
+
+		It("should summarise a rewrite with the net change in members", func() {
+			summaries()
+			newMeta := meta
+			newMeta.MaxSize = 2000
+			ipsets.AddOrReplaceIPSet(newMeta, []string{"10.0.0.2", "10.0.0.3", "10.0.0.4"})
+			ipsets.ApplyUpdates()
+			ipsets.ApplyDeletions()
+			Expect(summaries()).To(Equal([]string{ipSetID + ": +1 -1 members (rewrite)"}))
+			dataplane.ExpectMembers(map[string][]string{
+				v4MainIPSetName: {"10.0.0.2", "10.0.0.3", "10.0.0.4"},
+			})
+		})
+
+		It("should include the kernel name in the log fields", func() {
+			for _, e := range logHook.AllEntries() {
+				if e.Data["mode"] != nil {
+					Expect(e.Data["setName"]).To(Equal(v4MainIPSetName))
+					Expect(e.Data["setID"]).To(Equal(ipSetID))
+					Expect(e.Data["added"]).To(Equal(3))
+					Expect(e.Data["removed"]).To(Equal(0))
+				}
+			}
+		})
+
+		It("should not log a summary for a no-op apply", func() {
+			summaries()
+			ipsets.AddMembers(ipSetID, []string{"10.0.0.1"})
+			ipsets.ApplyUpdates()
+			ipsets.ApplyUpdates()
+			Expect(summaries()).To(BeEmpty())
+		})
+
+		It("should only summarise the IP sets that changed", func() {
+			summaries()
+			ipsets.AddOrReplaceIPSet(meta2, []string{"10.0.0.1"})
+			ipsets.ApplyUpdates()
+			Expect(summaries()).To(Equal([]string{ipSetID2 + ": +1 -0 members (create)"}))
+		})
+
+		It("should not log a summary for a restore that failed", func() {
+			summaries()
+			dataplane.RestoreOpFailures = []string{"pre-update"}
+			ipsets.AddMembers(ipSetID, []string{"10.0.0.4"})
+			ipsets.ApplyUpdates()
+			msgs := summaries()
+			// The failure triggers a resync and a retry, which succeeds.
+			Expect(msgs).To(Equal([]string{ipSetID + ": +1 -0 members (delta)"}))
+		})
+	})
+})
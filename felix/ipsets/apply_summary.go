@@ -0,0 +1,66 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipsets
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+// How an IP set was updated by an 'ipset restore'.
+const (
+	applyModeCreate  = "create"
+	applyModeRewrite = "rewrite"
+	applyModeDelta   = "delta"
+)
+
+// ipSetApplySummary records the changes that writeUpdates wrote for one IP set, so that they can
+// be logged once the restore has succeeded.
+type ipSetApplySummary struct {
+	setName string
+	added   int
+	removed int
+	mode    string
+}
+
+// recordApplySummary queues the summary of the changes to an IP set for logging after the
+// restore.  Summaries of no-op updates are dropped.
+func (s *IPSets) recordApplySummary(summary ipSetApplySummary) {
+	if summary.added == 0 && summary.removed == 0 && summary.mode == applyModeDelta {
+		return
+	}
+	s.pendingApplySummaries = append(s.pendingApplySummaries, summary)
+}
+
+// logApplySummaries logs a one-line summary of the changes made to each IP set by the restore
+// that just succeeded, for example "cali40s:qMt7iLlGDhvLnCjM0l9nzxb: +3 -1 members (delta)".
+// IP sets are identified by their IP set ID, where we know it, since the kernel name may be a
+// truncated hash of it.
+func (s *IPSets) logApplySummaries() {
+	for _, summary := range s.pendingApplySummaries {
+		name := summary.setName
+		setID, ok := s.SetIDForIPSetName(summary.setName)
+		if ok {
+			name = setID
+		}
+		s.logCxt.WithFields(log.Fields{
+			"setID":   setID,
+			"setName": summary.setName,
+			"added":   summary.added,
+			"removed": summary.removed,
+			"mode":    summary.mode,
+		}).Infof("%s: +%d -%d members (%s)", name, summary.added, summary.removed, summary.mode)
+	}
+	s.pendingApplySummaries = s.pendingApplySummaries[:0]
+}
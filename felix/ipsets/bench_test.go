@@ -0,0 +1,133 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipsets
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/projectcalico/calico/felix/ip"
+	"github.com/projectcalico/calico/felix/logutils"
+)
+
+const benchNumMembers = 100000
+
+func benchMembers() []IPSetMember {
+	r := rand.New(rand.NewSource(1))
+	members := make([]IPSetMember, benchNumMembers)
+	for i := range members {
+		members[i] = ip.V4Addr{10, byte(r.Intn(256)), byte(r.Intn(256)), byte(r.Intn(256))}.AsCIDR()
+	}
+	return members
+}
+
+// BenchmarkSortMembers measures the cost of sorting a large IP set's members.  Compare with
+// BenchmarkFormatMembers, the cost of formatting the restore lines for the same members.
+func BenchmarkSortMembers(b *testing.B) {
+	members := benchMembers()
+	toSort := make([]IPSetMember, len(members))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		copy(toSort, members)
+		sortMembers(toSort)
+	}
+}
+
+func BenchmarkFormatMembers(b *testing.B) {
+	members := benchMembers()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, m := range members {
+			_, _ = fmt.Fprintf(io.Discard, "add %s %s\n", "cali40s:qMt7iLlGDhvLnCjM0l9nzxb", m.String())
+		}
+	}
+}
+
+// discardCmd is an 'ipset restore' that accepts and discards its input.  It lets the benchmarks
+// measure our own per-restore overhead; the fork/exec of a real restore costs much more.
+type discardCmd struct{}
+
+func (discardCmd) StdinPipe() (WriteCloserFlusher, error) {
+	return &BufferedCloser{BufWriter: bufio.NewWriter(io.Discard), Closer: io.NopCloser(nil)}, nil
+}
+func (discardCmd) StdoutPipe() (io.ReadCloser, error) { return nil, nil }
+func (discardCmd) SetStdin(io.Reader)                 {}
+func (discardCmd) SetStdout(io.Writer)                {}
+func (discardCmd) SetStderr(io.Writer)                {}
+func (discardCmd) Start() error                       { return nil }
+func (discardCmd) Wait() error                        { return nil }
+func (discardCmd) Output() ([]byte, error)            { return nil, nil }
+func (discardCmd) CombinedOutput() ([]byte, error)    { return nil, nil }
+
+// BenchmarkUpdateManyIPSets measures updating one member in each of many IP sets, either with a
+// single restore or, as we do after a failure, with a restore per IP set.  The restores/op metric
+// is the number of 'ipset restore' processes that would be forked.
+func BenchmarkUpdateManyIPSets(b *testing.B) {
+	// Per-IP set logging would swamp the results.
+	defer log.SetLevel(log.GetLevel())
+	log.SetLevel(log.WarnLevel)
+	for _, numSets := range []int{10, 100, 500} {
+		for _, oneByOne := range []bool{false, true} {
+			mode := "batched"
+			if oneByOne {
+				mode = "one-by-one"
+			}
+			b.Run(fmt.Sprintf("%s/%d-sets", mode, numSets), func(b *testing.B) {
+				benchUpdateManyIPSets(b, numSets, oneByOne)
+			})
+		}
+	}
+}
+
+func benchUpdateManyIPSets(b *testing.B, numSets int, oneByOne bool) {
+	numRestores := 0
+	s := NewIPSetsWithShims(
+		NewIPVersionConfig(IPFamilyV4, "cali", nil, nil, nil),
+		logutils.NewSummarizer("bench loop"),
+		func(name string, arg ...string) CmdIface {
+			numRestores++
+			return discardCmd{}
+		},
+		func(time.Duration) {},
+		WithRestoreFlavor(RestoreFlavorModern),
+	)
+	setIDs := make([]string, numSets)
+	for i := range setIDs {
+		setIDs[i] = fmt.Sprintf("s:bench-%d", i)
+		s.AddOrReplaceIPSet(IPSetMetadata{SetID: setIDs[i], Type: IPSetTypeHashIP, MaxSize: 1048576}, nil)
+	}
+	if err := s.tryUpdates(false); err != nil {
+		b.Fatal(err)
+	}
+	numRestores = 0
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		member := []string{ip.V4Addr{10, byte(i >> 16), byte(i >> 8), byte(i)}.String()}
+		for _, id := range setIDs {
+			s.AddMembers(id, member)
+		}
+		if err := s.tryUpdates(oneByOne); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(numRestores)/float64(b.N), "restores/op")
+}
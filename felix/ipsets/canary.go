@@ -0,0 +1,114 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipsets
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// CanaryIPSetID is the IP set ID of the canary IP set; see WithCanaryIPSet().
+const CanaryIPSetID = "felix-canary"
+
+// canarySentinels contains the sentinel member of the canary IP set for each IP family.  They
+// are documentation addresses, which should never appear in real traffic.
+var canarySentinels = map[IPFamily]string{
+	IPFamilyV4: "192.0.2.1",
+	IPFamilyV6: "2001:db8::1",
+}
+
+// WithCanaryIPSet makes the IPSets object program a canary IP set, containing a single sentinel
+// member, alongside the real IP sets.  At the start of ApplyUpdates(), if at least checkInterval
+// has passed since the last check, we read back the canary; if it has gone missing, or lost its
+// sentinel, then something outside of Felix (such as another tool, or a kernel event) has
+// probably flushed our IP sets.  In that case, we log a warning, increment the
+// felix_ipset_canary_failures metric, call onInterference (which may be nil) with the reason
+// and queue a resync to repair the damage.  This is much cheaper than auditing every IP set.
+func WithCanaryIPSet(checkInterval time.Duration, onInterference func(reason string)) IPSetsOpt {
+	return func(s *IPSets) {
+		s.canaryEnabled = true
+		s.canaryCheckInterval = checkInterval
+		s.onCanaryInterference = onInterference
+	}
+}
+
+// addCanaryIPSet queues up the creation of the canary IP set.
+func (s *IPSets) addCanaryIPSet() {
+	s.addOrReplaceIPSet(IPSetMetadata{
+		SetID:   CanaryIPSetID,
+		Type:    IPSetTypeHashIP,
+		MaxSize: 1,
+	}, []string{canarySentinels[s.IPVersionConfig.Family]})
+}
+
+// isCanaryIPSet returns true if the given (main) IP set name is that of the canary IP set.
+func (s *IPSets) isCanaryIPSet(setName string) bool {
+	return s.canaryEnabled && setName == s.nameForMainIPSet(CanaryIPSetID)
+}
+
+// maybeCheckCanary checks the canary IP set if it's time to do so.
+func (s *IPSets) maybeCheckCanary() {
+	if !s.canaryEnabled || s.resyncRequired {
+		return
+	}
+	if !s.lastCanaryCheck.IsZero() && time.Since(s.lastCanaryCheck) < s.canaryCheckInterval {
+		return
+	}
+	s.CheckCanary()
+}
+
+// CheckCanary reads back the canary IP set from the dataplane and checks that it still contains
+// its sentinel member.  If not, it reports the interference (as described in WithCanaryIPSet)
+// and queues a resync.  Returns false if interference was detected.  Does nothing, and returns
+// true, if the canary isn't enabled or hasn't been programmed yet.
+func (s *IPSets) CheckCanary() bool {
+	if !s.canaryEnabled {
+		return true
+	}
+	s.lastCanaryCheck = time.Now()
+	setName := s.nameForMainIPSet(CanaryIPSetID)
+	if _, ok := s.setNameToProgrammedMetadata.Dataplane().Get(setName); !ok || s.ipSetsWithDirtyMembers.Contains(setName) {
+		s.logCxt.Debug("Canary IP set not programmed yet, skipping check.")
+		return true
+	}
+
+	sentinel := IPSetTypeHashIP.CanonicaliseMember(canarySentinels[s.IPVersionConfig.Family]).String()
+	listing, err := s.listIPSet(setName)
+	if err != nil {
+		s.reportCanaryInterference(setName, "canary IP set is missing or can't be read")
+		return false
+	}
+	for _, m := range listing.normaliseMembers() {
+		if m == sentinel {
+			s.logCxt.WithField("setName", setName).Debug("Canary IP set is intact.")
+			return true
+		}
+	}
+	s.reportCanaryInterference(setName, "canary IP set has lost its sentinel member")
+	return false
+}
+
+func (s *IPSets) reportCanaryInterference(setName, reason string) {
+	s.logCxt.WithFields(log.Fields{
+		"setName": setName,
+		"reason":  reason,
+	}).Warning("Suspected external interference with IP sets, queueing a resync.")
+	countNumIPSetCanaryFailures.Inc()
+	if s.onCanaryInterference != nil {
+		s.onCanaryInterference(reason)
+	}
+	s.QueueResync()
+}
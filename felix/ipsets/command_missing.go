@@ -0,0 +1,47 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipsets
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os/exec"
+)
+
+// ErrIPSetCommandMissing is returned by ApplyUpdatesChecked() if the ipset binary isn't
+// installed.
+var ErrIPSetCommandMissing = errors.New("ipset command not found")
+
+// wrapIfCommandMissing wraps the given error, from starting an ipset command, with
+// ErrIPSetCommandMissing if it shows that the ipset binary isn't installed.
+func wrapIfCommandMissing(err error) error {
+	if errors.Is(err, exec.ErrNotFound) || errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: %v", ErrIPSetCommandMissing, err)
+	}
+	return err
+}
+
+// reportCommandMissing logs that the ipset binary is missing.  We only warn the first time,
+// until an update succeeds, to avoid flooding the log while the binary stays missing.
+func (s *IPSets) reportCommandMissing(err error) {
+	if s.ipsetCommandMissing {
+		s.logCxt.WithError(err).Debug("ipset command still not found.")
+		return
+	}
+	s.ipsetCommandMissing = true
+	s.logCxt.WithError(err).Warning(
+		"ipset command not found, is ipset installed? IP set updates will be retried later.")
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipsets
+
+import (
+	"fmt"
+	"io"
+)
+
+// CommitStrategy controls where we put the COMMIT lines in the input that we generate for an
+// 'ipset restore' that updates several IP sets.
+//
+// Note that 'ipset restore' is not transactional: each line is applied as it is read and the
+// only atomic operation is the swap of a temporary IP set into place.  If a line fails, the
+// restore stops there, so the lines before it remain applied whatever the strategy.  The strategy
+// therefore controls how the input is divided into blocks, not what ends up in the dataplane:
+//
+//   - CommitStrategyBatched (the default) ends the whole input with a single COMMIT.  This is
+//     the smallest input, but a failure can only be attributed to the restore as a whole.
+//   - CommitStrategyPerSet ends each IP set's updates with its own COMMIT, so that each block
+//     of the input is self-contained and a failure can be attributed to the block (and hence
+//     the IP set) that it occurred in.  This costs an extra line per IP set.
+//
+// With the legacy restore flavor, which doesn't support COMMIT, the strategy has no effect.
+type CommitStrategy int
+
+const (
+	CommitStrategyBatched CommitStrategy = iota
+	CommitStrategyPerSet
+)
+
+func (c CommitStrategy) String() string {
+	switch c {
+	case CommitStrategyBatched:
+		return "Batched"
+	case CommitStrategyPerSet:
+		return "PerSet"
+	default:
+		return fmt.Sprintf("CommitStrategy(%d)", int(c))
+	}
+}
+
+// WithCommitStrategy sets where we put the COMMIT lines when we update several IP sets with a
+// single 'ipset restore'; see CommitStrategy.
+func WithCommitStrategy(strategy CommitStrategy) IPSetsOpt {
+	return func(s *IPSets) {
+		s.commitStrategy = strategy
+	}
+}
+
+// writeSetCommit ends the updates to a single IP set in the input to 'ipset restore', if the
+// commit strategy calls for it.
+func (s *IPSets) writeSetCommit(w io.Writer) error {
+	if s.commitStrategy != CommitStrategyPerSet {
+		return nil
+	}
+	return s.writeCommit(w)
+}
+
+// writeFinalCommit ends the input to an 'ipset restore' that updates several IP sets.
+func (s *IPSets) writeFinalCommit(w io.Writer) error {
+	if s.commitStrategy == CommitStrategyPerSet {
+		// Each IP set's updates already ended with a COMMIT.
+		return nil
+	}
+	return s.writeCommit(w)
+}
@@ -0,0 +1,144 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipsets
+
+import (
+	"fmt"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// MemberCounters contains the packet and byte counters that the kernel maintains for a member of
+// an IP set that was created with IPSetMetadata.Counters.
+type MemberCounters struct {
+	Packets uint64
+	Bytes   uint64
+}
+
+// GetMemberCounters reads the counters of the members of the given IP set back from the
+// dataplane, using "ipset list <name>".  The result is keyed on the canonical form of each
+// member, as returned by GetMembers().  The IP set must have been created with Counters.
+func (s *IPSets) GetMemberCounters(setID string) (map[string]MemberCounters, error) {
+	setName := s.nameForMainIPSet(setID)
+	setMeta, ok := s.setNameToAllMetadata[setName]
+	if !ok {
+		return nil, fmt.Errorf("ipset %s not found", setID)
+	}
+	if !setMeta.Counters {
+		return nil, fmt.Errorf("ipset %s was created without counters", setID)
+	}
+	listing, err := s.listIPSet(setName)
+	if err != nil {
+		return nil, err
+	}
+	counters := map[string]MemberCounters{}
+	for member, c := range listing.memberCounters() {
+		counters[member.String()] = c
+	}
+	return counters, nil
+}
+
+// loadCountersForRewrite records the current counters of those of the given IP sets that have
+// counters and are about to be rewritten via a temporary IP set.  Without this, the swap would
+// reset their counters to zero.  If an IP set can't be listed, its counters are reset.
+func (s *IPSets) loadCountersForRewrite(setNames []string) {
+	for setName := range s.setNameToCarriedCounters {
+		delete(s.setNameToCarriedCounters, setName)
+	}
+	for _, setName := range setNames {
+		desiredMeta, desiredExists := s.setNameToProgrammedMetadata.Desired().Get(setName)
+		dpMeta, dpExists := s.setNameToProgrammedMetadata.Dataplane().Get(setName)
+		if !desiredExists || !dpExists || !desiredMeta.Counters || !dpMeta.Counters {
+			continue
+		}
+		if dpMeta == desiredMeta && !s.ipSetsNeedingRewrite.Contains(setName) {
+			// Will be updated in place, keeping its counters.
+			continue
+		}
+		listing, err := s.listIPSet(setName)
+		if err != nil {
+			s.logCxt.WithError(err).WithField("setName", setName).Warn(
+				"Failed to read IP set counters before rewriting it, they will be reset.")
+			continue
+		}
+		s.setNameToCarriedCounters[setName] = listing.memberCounters()
+	}
+}
+
+// carriedCounterArgs returns the arguments to append to the "add" line for the given member to
+// restore the counters that it had before its IP set was rewritten, or "" if there are none.
+func (s *IPSets) carriedCounterArgs(setName string, member IPSetMember) string {
+	c, ok := s.setNameToCarriedCounters[setName][member]
+	if !ok || (c.Packets == 0 && c.Bytes == 0) {
+		return ""
+	}
+	return fmt.Sprintf(" packets %d bytes %d", c.Packets, c.Bytes)
+}
+
+// memberCounters returns the counters of the listing's members.  Members without counters are
+// omitted.
+func (l *ipSetListing) memberCounters() map[IPSetMember]MemberCounters {
+	counters := map[IPSetMember]MemberCounters{}
+	for _, line := range l.Members {
+		c, ok := listedMemberCounters(line)
+		if !ok {
+			continue
+		}
+		if l.Type.IsValid() {
+			member, _ := canonicaliseListedMember(l.Type, line)
+			counters[member] = c
+		} else {
+			member, _ := splitListedMember(line)
+			counters[rawIPSetMember(member)] = c
+		}
+	}
+	return counters
+}
+
+// listedMemberCounters returns the counters of a member line from 'ipset list', such as
+// "10.0.0.1 packets 10 bytes 840".
+func listedMemberCounters(line string) (c MemberCounters, ok bool) {
+	fields := splitQuotedFields(line)
+	for i := 1; i+1 < len(fields); i++ {
+		var target *uint64
+		switch fields[i] {
+		case "packets":
+			target = &c.Packets
+		case "bytes":
+			target = &c.Bytes
+		default:
+			continue
+		}
+		v, err := strconv.ParseUint(fields[i+1], 10, 64)
+		if err != nil {
+			log.WithError(err).WithField("line", line).Warn("Failed to parse IP set member counters.")
+			return MemberCounters{}, false
+		}
+		*target = v
+		ok = true
+		i++
+	}
+	return
+}
+
+// countersArg returns the "counters" argument for the create line of an IP set, or "" if the
+// IP set has no counters.
+func countersArg(counters bool) string {
+	if !counters {
+		return ""
+	}
+	return " counters"
+}
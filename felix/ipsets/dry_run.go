@@ -0,0 +1,48 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipsets
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+// WithDryRun enables dry-run mode, for troubleshooting.  In dry-run mode, ApplyUpdates() and
+// ApplyDeletions() log the input that they would send to 'ipset restore' at Info level instead
+// of running the command.  No ipset command is run at all: the dataplane isn't read (so it is
+// treated as empty) and the restore flavor isn't detected.
+//
+// Our record of the dataplane is updated as if each command succeeded, for both updates and
+// deletions, so each ApplyUpdates() only logs the changes since the previous call.  The record
+// of the IP sets that we created, used to verify their maxelem, is not updated since there is
+// nothing in the kernel to verify.
+func WithDryRun() IPSetsOpt {
+	return func(s *IPSets) {
+		s.dryRun = true
+	}
+}
+
+// DryRun returns true if dry-run mode is enabled; see WithDryRun().
+func (s *IPSets) DryRun() bool {
+	return s.dryRun
+}
+
+// logDryRun logs the command that we would have run in dry-run mode.
+func (s *IPSets) logDryRun(command string, numIPSets int, input string) {
+	s.logCxt.WithFields(log.Fields{
+		"command":   command,
+		"numIPSets": numIPSets,
+		"input":     input,
+	}).Info("Dry run: skipping ipset command.")
+}
@@ -0,0 +1,185 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipsets
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/projectcalico/calico/libcalico-go/lib/set"
+)
+
+// DualStackIPSets wraps an IPv4 and an IPv6 IPSets so that IP sets with members of both IP
+// families can be managed in one place.  Each IP set is created in both planes; each member is
+// sent only to the plane of its IP family.  Members without an IP family, such as those of a
+// list:set, are sent to both.
+type DualStackIPSets struct {
+	V4 *IPSets
+	V6 *IPSets
+}
+
+func NewDualStackIPSets(v4, v6 *IPSets) *DualStackIPSets {
+	if v4.IPVersionConfig.Family != IPFamilyV4 || v6.IPVersionConfig.Family != IPFamilyV6 {
+		log.WithFields(log.Fields{
+			"v4Family": v4.IPVersionConfig.Family,
+			"v6Family": v6.IPVersionConfig.Family,
+		}).Panic("Dual-stack IP sets given IP sets of the wrong IP families.")
+	}
+	return &DualStackIPSets{
+		V4: v4,
+		V6: v6,
+	}
+}
+
+// AddOrReplaceIPSet queues up the creation (or replacement) of an IP set in both planes, with
+// each plane getting the members of its IP family.
+func (d *DualStackIPSets) AddOrReplaceIPSet(setMetadata IPSetMetadata, members []string) {
+	v4Members, v6Members := splitMembersByFamily(setMetadata.Type, members)
+	d.V4.AddOrReplaceIPSet(setMetadata, v4Members)
+	d.V6.AddOrReplaceIPSet(setMetadata, v6Members)
+}
+
+// RemoveIPSet queues up the removal of an IP set from both planes.
+func (d *DualStackIPSets) RemoveIPSet(setID string) {
+	d.V4.RemoveIPSet(setID)
+	d.V6.RemoveIPSet(setID)
+}
+
+// AddMembers adds the given members to the IP set in the plane of their IP family.
+func (d *DualStackIPSets) AddMembers(setID string, newMembers []string) {
+	setType, err := d.GetTypeOf(setID)
+	if err != nil {
+		log.WithError(err).WithField("setID", setID).Panic("AddMembers called for nonexistent IP set.")
+	}
+	v4Members, v6Members := splitMembersByFamily(setType, newMembers)
+	if len(v4Members) > 0 {
+		d.V4.AddMembers(setID, v4Members)
+	}
+	if len(v6Members) > 0 {
+		d.V6.AddMembers(setID, v6Members)
+	}
+}
+
+// RemoveMembers removes the given members from the IP set in the plane of their IP family.
+func (d *DualStackIPSets) RemoveMembers(setID string, removedMembers []string) {
+	setType, err := d.GetTypeOf(setID)
+	if err != nil {
+		log.WithError(err).WithField("setID", setID).Panic("RemoveMembers called for nonexistent IP set.")
+	}
+	v4Members, v6Members := splitMembersByFamily(setType, removedMembers)
+	if len(v4Members) > 0 {
+		d.V4.RemoveMembers(setID, v4Members)
+	}
+	if len(v6Members) > 0 {
+		d.V6.RemoveMembers(setID, v6Members)
+	}
+}
+
+// GetTypeOf returns the type of the given IP set.
+func (d *DualStackIPSets) GetTypeOf(setID string) (IPSetType, error) {
+	return d.V4.GetTypeOf(setID)
+}
+
+// GetDesiredMembers returns the desired members of the IP set across both planes.
+func (d *DualStackIPSets) GetDesiredMembers(setID string) (set.Set[string], error) {
+	v4Members, err := d.V4.GetDesiredMembers(setID)
+	if err != nil {
+		return nil, err
+	}
+	v6Members, err := d.V6.GetDesiredMembers(setID)
+	if err != nil {
+		return nil, err
+	}
+	members := set.New[string]()
+	members.AddSet(v4Members)
+	members.AddSet(v6Members)
+	return members, nil
+}
+
+// GetMembers returns the desired members of the IP set, the IPv4 members followed by the IPv6
+// members, each in sorted order.  Members without an IP family appear only once.
+func (d *DualStackIPSets) GetMembers(setID string) ([]string, error) {
+	v4Members, err := d.V4.GetMembers(setID)
+	if err != nil {
+		return nil, fmt.Errorf("IPv4: %w", err)
+	}
+	v6Members, err := d.V6.GetMembers(setID)
+	if err != nil {
+		return nil, fmt.Errorf("IPv6: %w", err)
+	}
+	seen := set.FromArray(v4Members)
+	members := v4Members
+	for _, m := range v6Members {
+		if !seen.Contains(m) {
+			members = append(members, m)
+		}
+	}
+	return members, nil
+}
+
+// QueueResync forces a resync of both planes with the dataplane on the next ApplyUpdates() call.
+func (d *DualStackIPSets) QueueResync() {
+	d.V4.QueueResync()
+	d.V6.QueueResync()
+}
+
+// ApplyUpdates applies the pending updates to both planes.
+func (d *DualStackIPSets) ApplyUpdates() {
+	d.V4.ApplyUpdates()
+	d.V6.ApplyUpdates()
+}
+
+// ApplyUpdatesChecked is like ApplyUpdates() but returns the error from either plane rather than
+// panicking; see IPSets.ApplyUpdatesChecked().  Both planes are updated even if one fails.
+func (d *DualStackIPSets) ApplyUpdatesChecked() error {
+	v4Err := d.V4.ApplyUpdatesChecked()
+	v6Err := d.V6.ApplyUpdatesChecked()
+	if v4Err != nil {
+		return fmt.Errorf("IPv4: %w", v4Err)
+	}
+	if v6Err != nil {
+		return fmt.Errorf("IPv6: %w", v6Err)
+	}
+	return nil
+}
+
+// ApplyDeletions applies the pending deletions to both planes.  Returns true if either plane
+// needs to be rescheduled to finish its deletions.
+func (d *DualStackIPSets) ApplyDeletions() (reschedule bool) {
+	v4Reschedule := d.V4.ApplyDeletions()
+	v6Reschedule := d.V6.ApplyDeletions()
+	return v4Reschedule || v6Reschedule
+}
+
+// SetFilter applies the filter to both planes.
+func (d *DualStackIPSets) SetFilter(ipSetNames set.Set[string]) {
+	d.V4.SetFilter(ipSetNames)
+	d.V6.SetFilter(ipSetNames)
+}
+
+// splitMembersByFamily splits the members into those that belong in an IPv4 IP set and those that
+// belong in an IPv6 IP set.  Members without an IP family belong in both.
+func splitMembersByFamily(setType IPSetType, members []string) (v4Members, v6Members []string) {
+	for _, m := range members {
+		if setType.isMemberInFamily(m, IPFamilyV4) {
+			v4Members = append(v4Members, m)
+		}
+		if setType.isMemberInFamily(m, IPFamilyV6) {
+			v6Members = append(v6Members, m)
+		}
+	}
+	return
+}
@@ -0,0 +1,99 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipsets
+
+import (
+	"sync"
+)
+
+// ExecSemaphore limits the number of ipset commands that may be running at once.  A single
+// ExecSemaphore may be shared by several IPSets objects (for example, the IPv4 and IPv6
+// instances) to put a global bound on the number of ipset processes.
+type ExecSemaphore struct {
+	slots chan struct{}
+}
+
+// NewExecSemaphore creates an ExecSemaphore that allows up to maxConcurrent ipset commands to
+// run at once.
+func NewExecSemaphore(maxConcurrent int) *ExecSemaphore {
+	if maxConcurrent < 1 {
+		maxConcurrent = 1
+	}
+	return &ExecSemaphore{
+		slots: make(chan struct{}, maxConcurrent),
+	}
+}
+
+func (e *ExecSemaphore) acquire() {
+	e.slots <- struct{}{}
+}
+
+func (e *ExecSemaphore) release() {
+	<-e.slots
+}
+
+// WithExecSemaphore makes the IPSets object acquire a slot in the given semaphore for the
+// lifetime of each ipset command that it runs, blocking if all slots are in use.
+func WithExecSemaphore(sem *ExecSemaphore) IPSetsOpt {
+	return func(s *IPSets) {
+		newCmd := s.newCmd
+		s.newCmd = func(name string, arg ...string) CmdIface {
+			return &semaphoreCmd{
+				CmdIface: newCmd(name, arg...),
+				sem:      sem,
+			}
+		}
+	}
+}
+
+// semaphoreCmd wraps a CmdIface, holding a semaphore slot from Start() until Wait() returns, or
+// for the duration of Output()/CombinedOutput().
+type semaphoreCmd struct {
+	CmdIface
+	sem         *ExecSemaphore
+	releaseOnce sync.Once
+	started     bool
+}
+
+func (c *semaphoreCmd) Start() error {
+	c.sem.acquire()
+	err := c.CmdIface.Start()
+	if err != nil {
+		c.sem.release()
+		return err
+	}
+	c.started = true
+	return nil
+}
+
+func (c *semaphoreCmd) Wait() error {
+	err := c.CmdIface.Wait()
+	if c.started {
+		c.releaseOnce.Do(c.sem.release)
+	}
+	return err
+}
+
+func (c *semaphoreCmd) Output() ([]byte, error) {
+	c.sem.acquire()
+	defer c.sem.release()
+	return c.CmdIface.Output()
+}
+
+func (c *semaphoreCmd) CombinedOutput() ([]byte, error) {
+	c.sem.acquire()
+	defer c.sem.release()
+	return c.CmdIface.CombinedOutput()
+}
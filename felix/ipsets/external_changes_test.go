@@ -0,0 +1,526 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipsets_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	. "github.com/projectcalico/calico/felix/ipsets"
+	"github.com/projectcalico/calico/libcalico-go/lib/set"
+)
+
+// Tests for how IPSets copes with other processes changing the IP sets in the dataplane.
+var _ = Describe("IP sets with external changes to the dataplane", func() {
+	var (
+		dataplane *mockDataplane
+		ipsets    *IPSets
+	)
+
+	meta := IPSetMetadata{
+		MaxSize: 1234,
+		SetID:   ipSetID,
+		Type:    IPSetTypeHashIP,
+	}
+
+	apply := func() {
+		ipsets.ApplyUpdates()
+		ipsets.ApplyDeletions()
+	}
+
+	BeforeEach(func() {
+		dataplane = newMockDataplane()
+	})
+
+	Describe("canary", func() {
+		const canarySet = "cali40felix-canary"
+
+		var reasons []string
+
+		onInterference := func(reason string) {
+			reasons = append(reasons, reason)
+		}
+
+		BeforeEach(func() {
+			reasons = nil
+		})
+
+		It("should not create the canary by default", func() {
+			ipsets = newTestIPSets(dataplane, IPFamilyV4)
+			apply()
+			Expect(dataplane.IPSetMembers).NotTo(HaveKey(canarySet))
+			Expect(ipsets.CheckCanary()).To(BeTrue())
+		})
+
+		Describe("with the canary enabled", func() {
+			BeforeEach(func() {
+				ipsets = newTestIPSets(dataplane, IPFamilyV4, WithCanaryIPSet(0, onInterference))
+				ipsets.AddOrReplaceIPSet(meta, []string{"10.0.0.1"})
+				apply()
+			})
+
+			It("should program the canary with its sentinel", func() {
+				dataplane.ExpectMembers(map[string][]string{
+					canarySet:       {"192.0.2.1"},
+					v4MainIPSetName: {"10.0.0.1"},
+				})
+			})
+
+			It("should hide the canary from IP set queries", func() {
+				Expect(ipsets.IPSetNames()).NotTo(HaveKey(CanaryIPSetID))
+				Expect(ipsets.SetsByType(IPSetTypeHashIP)).NotTo(ContainElement(canarySet))
+			})
+
+			It("should not signal while the canary is intact", func() {
+				for i := 0; i < 3; i++ {
+					Expect(ipsets.CheckCanary()).To(BeTrue())
+					apply()
+				}
+				Expect(reasons).To(BeEmpty())
+			})
+
+			It("should not remove the canary when cleaning up unneeded IP sets", func() {
+				ipsets.SetFilter(set.From(v4MainIPSetName))
+				ipsets.QueueResync()
+				apply()
+				Expect(dataplane.IPSetMembers).To(HaveKey(canarySet))
+			})
+
+			Describe("after the IP sets are flushed externally", func() {
+				BeforeEach(func() {
+					delete(dataplane.IPSetMembers, canarySet)
+					delete(dataplane.IPSetMembers, v4MainIPSetName)
+					apply()
+				})
+
+				It("should signal interference", func() {
+					Expect(reasons).To(ConsistOf("canary IP set is missing or can't be read"))
+				})
+
+				It("should resync and repair the IP sets", func() {
+					dataplane.ExpectMembers(map[string][]string{
+						canarySet:       {"192.0.2.1"},
+						v4MainIPSetName: {"10.0.0.1"},
+					})
+				})
+			})
+
+			It("should signal interference if the sentinel is removed", func() {
+				dataplane.IPSetMembers[canarySet] = set.New[string]()
+				apply()
+				Expect(reasons).To(ConsistOf("canary IP set has lost its sentinel member"))
+				Expect(dataplane.IPSetMembers[canarySet]).To(Equal(set.From("192.0.2.1")))
+			})
+		})
+
+		It("should only check the canary once per interval", func() {
+			ipsets = newTestIPSets(dataplane, IPFamilyV4, WithCanaryIPSet(time.Hour, onInterference))
+			apply()
+			Expect(ipsets.CheckCanary()).To(BeTrue())
+			delete(dataplane.IPSetMembers, canarySet)
+			apply()
+			Expect(reasons).To(BeEmpty())
+			Expect(ipsets.CheckCanary()).To(BeFalse())
+			Expect(reasons).To(HaveLen(1))
+		})
+	})
+})
+
+// These tests simulate a restart: the kernel already has IP sets from a previous run, and a new
+// IPSets object is created with the desired state.  The first apply loads the kernel members and
+// should only write the difference.
+var _ = Describe("IP sets after restart", func() {
+	const numMembers = 1000
+
+	var (
+		dataplane *mockDataplane
+		ipsets    *IPSets
+	)
+
+	meta := IPSetMetadata{
+		MaxSize: 1234,
+		SetID:   ipSetID,
+		Type:    IPSetTypeHashIP,
+	}
+	meta2 := IPSetMetadata{
+		MaxSize: 1234,
+		SetID:   ipSetID2,
+		Type:    IPSetTypeHashIP,
+	}
+
+	// members returns 10.0.<n/256>.<n%256> for n in [start, end).
+	members := func(start, end int) []string {
+		var ms []string
+		for n := start; n < end; n++ {
+			ms = append(ms, fmt.Sprintf("10.0.%d.%d", n/256, n%256))
+		}
+		return ms
+	}
+
+	seedKernel := func(setName string, ms []string) {
+		dataplane.IPSetMembers[setName] = set.FromArray(ms)
+		dataplane.IPSetMetadata[setName] = setMetadata{
+			Name:    setName,
+			Family:  "inet",
+			Type:    "hash:ip",
+			MaxSize: 1234,
+		}
+	}
+
+	apply := func() {
+		ipsets.ApplyUpdates()
+		ipsets.ApplyDeletions()
+	}
+
+	BeforeEach(func() {
+		dataplane = newMockDataplane()
+		ipsets = newTestIPSets(dataplane, IPFamilyV4)
+	})
+
+	It("should only apply the delta to a large IP set that differs slightly", func() {
+		seedKernel(v4MainIPSetName, members(0, numMembers))
+		ipsets.AddOrReplaceIPSet(meta, members(1, numMembers+1))
+		apply()
+
+		Expect(dataplane.LinesExecuted).To(Equal([]string{
+			"del " + v4MainIPSetName + " 10.0.0.0 --exist",
+			fmt.Sprintf("add %s 10.0.%d.%d", v4MainIPSetName, numMembers/256, numMembers%256),
+			"COMMIT",
+		}), "Expected a minimal update rather than a full rewrite")
+		Expect(dataplane.IPSetMembers[v4MainIPSetName]).To(Equal(set.FromArray(members(1, numMembers+1))))
+	})
+
+	It("should not touch an IP set that is already in sync", func() {
+		seedKernel(v4MainIPSetName, members(0, numMembers))
+		seedKernel(v4MainIPSetName2, members(0, 10))
+		ipsets.AddOrReplaceIPSet(meta, members(0, numMembers))
+		ipsets.AddOrReplaceIPSet(meta2, members(0, 11))
+		apply()
+
+		Expect(dataplane.LinesExecuted).To(Equal([]string{
+			"add " + v4MainIPSetName2 + " 10.0.0.10",
+			"COMMIT",
+		}))
+	})
+
+	It("should skip the restore entirely if everything is in sync", func() {
+		seedKernel(v4MainIPSetName, members(0, numMembers))
+		ipsets.AddOrReplaceIPSet(meta, members(0, numMembers))
+		apply()
+
+		Expect(dataplane.NumRestoreCalls()).To(BeZero())
+		Expect(dataplane.CmdNames).NotTo(ContainElement("restore"))
+	})
+
+	It("should rewrite an IP set whose maxelem has changed, even if its members match", func() {
+		seedKernel(v4MainIPSetName, members(0, 10))
+		ipsets.AddOrReplaceIPSet(IPSetMetadata{MaxSize: 2345, SetID: ipSetID, Type: IPSetTypeHashIP}, members(0, 10))
+		apply()
+
+		Expect(dataplane.NumRestoreCalls()).To(BeNumerically(">", 0))
+		Expect(dataplane.LinesExecuted).To(ContainElement(
+			"create " + v4TempIPSetName0 + " hash:ip family inet maxelem 2345"))
+		Expect(dataplane.IPSetMetadata[v4MainIPSetName].MaxSize).To(Equal(2345))
+		Expect(dataplane.IPSetMembers[v4MainIPSetName]).To(Equal(set.FromArray(members(0, 10))))
+	})
+
+	It("should rewrite an IP set whose type has changed, even if its members match", func() {
+		seedKernel(v4MainIPSetName, members(0, 10))
+		ipsets.AddOrReplaceIPSet(IPSetMetadata{MaxSize: 1234, SetID: ipSetID, Type: IPSetTypeHashNet}, members(0, 10))
+		apply()
+
+		Expect(dataplane.NumRestoreCalls()).To(BeNumerically(">", 0))
+		Expect(dataplane.IPSetMetadata[v4MainIPSetName].Type).To(Equal(IPSetTypeHashNet))
+	})
+
+	It("should continue to apply deltas after the first apply", func() {
+		seedKernel(v4MainIPSetName, members(0, numMembers))
+		ipsets.AddOrReplaceIPSet(meta, members(0, numMembers))
+		apply()
+
+		ipsets.RemoveMembers(ipSetID, []string{"10.0.0.5"})
+		ipsets.AddMembers(ipSetID, []string{"10.1.0.1"})
+		apply()
+
+		Expect(dataplane.LinesExecuted).To(Equal([]string{
+			"del " + v4MainIPSetName + " 10.0.0.5 --exist",
+			"add " + v4MainIPSetName + " 10.1.0.1",
+			"COMMIT",
+		}))
+	})
+})
+
+var _ = Describe("IP set dump to file", func() {
+	var dataplane *mockDataplane
+	var ipsets *IPSets
+	var dir string
+
+	BeforeEach(func() {
+		dataplane = newMockDataplane()
+		ipsets = newTestIPSets(dataplane, IPFamilyV4)
+		var err error
+		dir, err = os.MkdirTemp("", "ipset-dump")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		_ = os.RemoveAll(dir)
+	})
+
+	It("should write the normalised kernel members with a header", func() {
+		dataplane.IPSetMembers[v4MainIPSetName] = set.From("10.0.1.1/24", "10.0.0.0/24", "10.0.2.5")
+		dataplane.IPSetMetadata[v4MainIPSetName] = setMetadata{
+			Name:    v4MainIPSetName,
+			Family:  IPFamilyV4,
+			Type:    IPSetTypeHashNet,
+			MaxSize: 1024,
+		}
+
+		path := filepath.Join(dir, "dump.txt")
+		Expect(ipsets.DumpSetToFile(ipSetID, path)).To(Succeed())
+		Expect(dataplane.CmdNames).To(Equal([]string{"list"}))
+
+		data, err := os.ReadFile(path)
+		Expect(err).NotTo(HaveOccurred())
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		Expect(lines).To(HaveLen(7))
+		Expect(lines[0]).To(Equal("# SetID: " + ipSetID))
+		Expect(lines[1]).To(Equal("# Name: " + v4MainIPSetName))
+		Expect(lines[2]).To(Equal("# Type: hash:net"))
+		Expect(lines[3]).To(HavePrefix("# Timestamp: "))
+		Expect(lines[4:]).To(Equal([]string{"10.0.0.0/24", "10.0.1.0/24", "10.0.2.5/32"}))
+	})
+
+	It("should strip member extensions", func() {
+		dataplane.IPSetMembers[v4MainIPSetName] = set.From("10.0.0.1 timeout 60", `10.0.0.2 comment "a b"`)
+		dataplane.IPSetMetadata[v4MainIPSetName] = setMetadata{
+			Name:    v4MainIPSetName,
+			Family:  IPFamilyV4,
+			Type:    IPSetTypeHashIP,
+			MaxSize: 1024,
+		}
+
+		path := filepath.Join(dir, "dump.txt")
+		Expect(ipsets.DumpSetToFile(ipSetID, path)).To(Succeed())
+
+		data, err := os.ReadFile(path)
+		Expect(err).NotTo(HaveOccurred())
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		Expect(lines[4:]).To(Equal([]string{"10.0.0.1", "10.0.0.2"}))
+	})
+
+	It("should return an error if the IP set doesn't exist", func() {
+		path := filepath.Join(dir, "dump.txt")
+		Expect(ipsets.DumpSetToFile(ipSetID, path)).NotTo(Succeed())
+		_, err := os.Stat(path)
+		Expect(os.IsNotExist(err)).To(BeTrue())
+	})
+})
+
+var _ = Describe("IP sets self-test", func() {
+	var (
+		dataplane *mockDataplane
+		ipsets    *IPSets
+	)
+
+	BeforeEach(func() {
+		dataplane = newMockDataplane()
+		ipsets = newTestIPSets(dataplane, IPFamilyV4)
+	})
+
+	allSupported := func() map[IPSetCapability]bool {
+		results := map[IPSetCapability]bool{}
+		for _, c := range AllIPSetCapabilities {
+			results[c] = true
+		}
+		return results
+	}
+
+	It("should report all capabilities supported by a full-featured binary", func() {
+		Expect(ipsets.SelfTest()).To(Equal(allSupported()))
+		Expect(dataplane.IPSetMembers).To(BeEmpty(), "probe IP sets should be cleaned up")
+		Expect(dataplane.CmdNames).To(HaveLen(2 * len(AllIPSetCapabilities)))
+	})
+
+	It("should report comment unsupported by a binary that lacks it", func() {
+		dataplane.UnsupportedCreateArgs.Add("comment")
+		expected := allSupported()
+		expected[CapabilityComment] = false
+		Expect(ipsets.SelfTest()).To(Equal(expected))
+		Expect(dataplane.IPSetMembers).To(BeEmpty())
+	})
+
+	It("should only test the requested capabilities", func() {
+		dataplane.UnsupportedCreateArgs.Add(string(IPSetTypeHashNetNet))
+		Expect(ipsets.SelfTest(CapabilityHashNetNet, CapabilityCounters)).To(Equal(map[IPSetCapability]bool{
+			CapabilityHashNetNet: false,
+			CapabilityCounters:   true,
+		}))
+		Expect(dataplane.CmdNames).To(Equal([]string{"create", "create", "destroy"}))
+	})
+
+	It("should replace a left-over probe IP set", func() {
+		dataplane.IPSetMembers["cali4tselftest"] = set.New[string]()
+		Expect(ipsets.SelfTest(CapabilityHashIP)).To(Equal(map[IPSetCapability]bool{CapabilityHashIP: true}))
+		Expect(dataplane.CmdNames).To(Equal([]string{"create", "destroy", "create", "destroy"}))
+		Expect(dataplane.IPSetMembers).To(BeEmpty())
+	})
+
+	It("should clean up a left-over probe IP set on resync", func() {
+		dataplane.FailDestroyNames.Add("cali4tselftest")
+		Expect(ipsets.SelfTest(CapabilityHashIP)).To(Equal(map[IPSetCapability]bool{CapabilityHashIP: true}))
+		Expect(dataplane.IPSetMembers).To(HaveKey("cali4tselftest"))
+
+		dataplane.FailDestroyNames.Clear()
+		ipsets.ApplyUpdates()
+		ipsets.ApplyDeletions()
+		Expect(dataplane.IPSetMembers).To(BeEmpty())
+	})
+})
+
+var _ = Describe("IP sets referenced set policy", func() {
+	const referencedSet = "cali40s:referenced"
+
+	type escalation struct {
+		SetName  string
+		Attempts int
+	}
+
+	var (
+		dataplane   *mockDataplane
+		ipsets      *IPSets
+		escalations []escalation
+	)
+
+	onEscalate := func(setName string, attempts int) {
+		escalations = append(escalations, escalation{setName, attempts})
+	}
+
+	// resyncAndApply forces a resync, which makes us retry failed deletions, and returns the
+	// number of attempts to destroy the referenced IP set.
+	resyncAndApply := func() int {
+		dataplane.AttemptedDestroys = nil
+		ipsets.QueueResync()
+		ipsets.ApplyUpdates()
+		ipsets.ApplyDeletions()
+		attempts := 0
+		for _, name := range dataplane.AttemptedDestroys {
+			if name == referencedSet {
+				attempts++
+			}
+		}
+		return attempts
+	}
+
+	BeforeEach(func() {
+		dataplane = newMockDataplane()
+		dataplane.IPSetMembers[referencedSet] = set.From("10.0.0.1")
+		dataplane.FailDestroyNames.Add(referencedSet)
+		escalations = nil
+	})
+
+	It("should retry indefinitely by default", func() {
+		ipsets = newTestIPSets(dataplane, IPFamilyV4)
+		for i := 0; i < 5; i++ {
+			Expect(resyncAndApply()).To(Equal(1))
+		}
+		Expect(dataplane.IPSetMembers).To(HaveKey(referencedSet))
+
+		By("deleting the IP set once it's no longer referenced")
+		dataplane.FailDestroyNames.Clear()
+		Expect(resyncAndApply()).To(Equal(1))
+		Expect(dataplane.IPSetMembers).To(BeEmpty())
+	})
+
+	It("should ignore the callback with the Retry policy", func() {
+		ipsets = newTestIPSets(dataplane, IPFamilyV4, WithReferencedSetPolicy(ReferencedSetPolicyRetry, 2, onEscalate))
+		for i := 0; i < 5; i++ {
+			Expect(resyncAndApply()).To(Equal(1))
+		}
+		Expect(escalations).To(BeEmpty())
+	})
+
+	It("should give up after the configured number of attempts with the GiveUp policy", func() {
+		ipsets = newTestIPSets(dataplane, IPFamilyV4, WithReferencedSetPolicy(ReferencedSetPolicyGiveUp, 3, onEscalate))
+		Expect(resyncAndApply()).To(Equal(1))
+		Expect(resyncAndApply()).To(Equal(1))
+		Expect(escalations).To(BeEmpty())
+		Expect(resyncAndApply()).To(Equal(1))
+		Expect(escalations).To(Equal([]escalation{{referencedSet, 3}}))
+
+		By("not retrying after subsequent resyncs")
+		for i := 0; i < 3; i++ {
+			Expect(resyncAndApply()).To(Equal(0))
+		}
+		Expect(escalations).To(HaveLen(1))
+		Expect(dataplane.IPSetMembers).To(HaveKey(referencedSet))
+
+		By("not retrying even once the IP set is no longer referenced")
+		dataplane.FailDestroyNames.Clear()
+		Expect(resyncAndApply()).To(Equal(0))
+		Expect(dataplane.IPSetMembers).To(HaveKey(referencedSet))
+	})
+
+	It("should try again after giving up if the IP set is re-added and removed", func() {
+		ipsets = newTestIPSets(dataplane, IPFamilyV4, WithReferencedSetPolicy(ReferencedSetPolicyGiveUp, 1, onEscalate))
+		Expect(resyncAndApply()).To(Equal(1))
+		Expect(resyncAndApply()).To(Equal(0))
+		Expect(escalations).To(Equal([]escalation{{referencedSet, 1}}))
+
+		ipsets.AddOrReplaceIPSet(IPSetMetadata{MaxSize: 1234, SetID: "s:referenced", Type: IPSetTypeHashIP}, []string{"10.0.0.1"})
+		ipsets.ApplyUpdates()
+		ipsets.ApplyDeletions()
+		ipsets.RemoveIPSet("s:referenced")
+		dataplane.FailDestroyNames.Clear()
+		Expect(resyncAndApply()).To(Equal(1))
+		Expect(dataplane.IPSetMembers).To(BeEmpty())
+	})
+
+	It("should escalate once and keep retrying with the Escalate policy", func() {
+		ipsets = newTestIPSets(dataplane, IPFamilyV4, WithReferencedSetPolicy(ReferencedSetPolicyEscalate, 2, onEscalate))
+		Expect(resyncAndApply()).To(Equal(1))
+		Expect(escalations).To(BeEmpty())
+		Expect(resyncAndApply()).To(Equal(1))
+		Expect(escalations).To(Equal([]escalation{{referencedSet, 2}}))
+
+		for i := 0; i < 3; i++ {
+			Expect(resyncAndApply()).To(Equal(1))
+		}
+		Expect(escalations).To(HaveLen(1))
+
+		By("deleting the IP set once it's no longer referenced")
+		dataplane.FailDestroyNames.Clear()
+		Expect(resyncAndApply()).To(Equal(1))
+		Expect(dataplane.IPSetMembers).To(BeEmpty())
+	})
+
+	It("should apply the policy to batched deletions", func() {
+		ipsets = newTestIPSets(dataplane, IPFamilyV4, WithBatchedDeletions(5), WithReferencedSetPolicy(ReferencedSetPolicyGiveUp, 2, onEscalate))
+		dataplane.IPSetMembers["cali40s:other"] = set.From("10.0.0.2")
+		// Each round tries the batch and then falls back to deleting the IP sets individually.
+		Expect(resyncAndApply()).To(Equal(2))
+		Expect(dataplane.IPSetMembers).To(Equal(map[string]set.Set[string]{referencedSet: set.From("10.0.0.1")}))
+		Expect(escalations).To(BeEmpty())
+		Expect(resyncAndApply()).To(Equal(2))
+		Expect(escalations).To(Equal([]escalation{{referencedSet, 2}}))
+		Expect(resyncAndApply()).To(Equal(0))
+	})
+})
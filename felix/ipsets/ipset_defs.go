@@ -16,10 +16,9 @@ package ipsets
 
 import (
 	"fmt"
-	"math"
 	"regexp"
-	"strconv"
 	"strings"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	log "github.com/sirupsen/logrus"
@@ -39,6 +38,22 @@ var (
 		Name: "felix_ipsets_total",
 		Help: "Total number of active IP sets.",
 	})
+	gaugeVecNumOrphanIPSets = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "felix_ipsets_orphaned",
+		Help: "Number of left-over Calico IP sets found in the dataplane by the most recent resync.",
+	}, []string{"ip_version", "kind"})
+	gaugeVecRestoreInputBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "felix_ipset_restore_input_bytes",
+		Help: "Size, in bytes, of the input to the most recent ipset restore that updated IP sets.",
+	}, []string{"ip_version"})
+	gaugeVecRestoreInputLines = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "felix_ipset_restore_input_lines",
+		Help: "Number of lines in the input to the most recent ipset restore that updated IP sets.",
+	}, []string{"ip_version"})
+	gaugeVecIPSetTypeAdvice = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "felix_ipsets_type_advice",
+		Help: "Number of IP sets that the most recent check recommended converting to a different type.",
+	}, []string{"ip_version"})
 	countNumIPSetCalls = prometheus.NewCounter(prometheus.CounterOpts{
 		Name: "felix_ipset_calls",
 		Help: "Number of ipset commands executed.",
@@ -51,6 +66,26 @@ var (
 		Name: "felix_ipset_lines_executed",
 		Help: "Number of ipset operations executed.",
 	})
+	countNumIPSetMaxElemMismatches = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "felix_ipset_maxelem_mismatches",
+		Help: "Number of IP sets created with a different maxelem to the one requested.",
+	})
+	countNumIPSetShadowDivergences = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "felix_ipset_shadow_divergences",
+		Help: "Number of IP set updates for which shadow mode generated different IP set contents.",
+	})
+	countNumIPSetDeleteEscalations = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "felix_ipset_delete_escalations",
+		Help: "Number of IP sets that Felix repeatedly failed to delete, for which the referenced set policy escalated or gave up.",
+	})
+	countNumIPSetCanaryFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "felix_ipset_canary_failures",
+		Help: "Number of times that the canary IP set was found to be missing or modified, suggesting external interference with IP sets.",
+	})
+	countNumIPSetInvalidMembers = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "felix_ipset_invalid_members_dropped",
+		Help: "Number of malformed IP set members that Felix dropped rather than writing them to the dataplane.",
+	})
 	summaryExecStart = cprometheus.NewSummary(prometheus.SummaryOpts{
 		Name: "felix_exec_time_micros",
 		Help: "Summary of time taken to fork/exec child processes",
@@ -60,9 +95,18 @@ var (
 func init() {
 	prometheus.MustRegister(gaugeVecNumCalicoIpsets)
 	prometheus.MustRegister(gaugeNumTotalIpsets)
+	prometheus.MustRegister(gaugeVecNumOrphanIPSets)
+	prometheus.MustRegister(gaugeVecRestoreInputBytes)
+	prometheus.MustRegister(gaugeVecRestoreInputLines)
+	prometheus.MustRegister(gaugeVecIPSetTypeAdvice)
 	prometheus.MustRegister(countNumIPSetCalls)
 	prometheus.MustRegister(countNumIPSetErrors)
 	prometheus.MustRegister(countNumIPSetLinesExecuted)
+	prometheus.MustRegister(countNumIPSetMaxElemMismatches)
+	prometheus.MustRegister(countNumIPSetShadowDivergences)
+	prometheus.MustRegister(countNumIPSetDeleteEscalations)
+	prometheus.MustRegister(countNumIPSetCanaryFailures)
+	prometheus.MustRegister(countNumIPSetInvalidMembers)
 	prometheus.MustRegister(summaryExecStart)
 }
 
@@ -74,11 +118,13 @@ const IPSetNamePrefix = "cali"
 type IPSetType string
 
 const (
-	IPSetTypeHashIP     IPSetType = "hash:ip"
-	IPSetTypeHashIPPort IPSetType = "hash:ip,port"
-	IPSetTypeHashNet    IPSetType = "hash:net"
-	IPSetTypeBitmapPort IPSetType = "bitmap:port"
-	IPSetTypeHashNetNet IPSetType = "hash:net,net"
+	IPSetTypeHashIP      IPSetType = "hash:ip"
+	IPSetTypeHashIPPort  IPSetType = "hash:ip,port"
+	IPSetTypeHashNet     IPSetType = "hash:net"
+	IPSetTypeBitmapPort  IPSetType = "bitmap:port"
+	IPSetTypeHashNetNet  IPSetType = "hash:net,net"
+	IPSetTypeHashNetPort IPSetType = "hash:net,port"
+	IPSetTypeListSet     IPSetType = "list:set"
 )
 
 var AllIPSetTypes = []IPSetType{
@@ -87,6 +133,8 @@ var AllIPSetTypes = []IPSetType{
 	IPSetTypeHashNet,
 	IPSetTypeBitmapPort,
 	IPSetTypeHashNetNet,
+	IPSetTypeHashNetPort,
+	IPSetTypeListSet,
 }
 
 func (t IPSetType) SetType() string {
@@ -113,6 +161,26 @@ func (p V6IPPort) String() string {
 	return fmt.Sprintf("%s,%s:%d", p.IP.String(), p.Protocol.String(), p.Port)
 }
 
+type V4NetPort struct {
+	CIDR     ip.V4CIDR
+	Port     uint16
+	Protocol labelindex.IPSetPortProtocol
+}
+
+func (p V4NetPort) String() string {
+	return fmt.Sprintf("%s,%s:%d", p.CIDR.String(), p.Protocol.String(), p.Port)
+}
+
+type V6NetPort struct {
+	CIDR     ip.V6CIDR
+	Port     uint16
+	Protocol labelindex.IPSetPortProtocol
+}
+
+func (p V6NetPort) String() string {
+	return fmt.Sprintf("%s,%s:%d", p.CIDR.String(), p.Protocol.String(), p.Port)
+}
+
 type Port uint16
 
 func (p Port) String() string {
@@ -120,113 +188,25 @@ func (p Port) String() string {
 }
 
 func (t IPSetType) IsMemberIPV6(member string) bool {
-	switch t {
-	case IPSetTypeHashIP, IPSetTypeHashNet:
-		return strings.Contains(member, ":")
-	case IPSetTypeHashIPPort:
-		return strings.Contains(strings.Split(member, ",")[0], ":")
-	case IPSetTypeHashNetNet:
-		cidrs := strings.Split(member, ",")
-		if len(cidrs) != 2 {
-			log.WithField("member", member).Panic("Is not type IPSetTypeHashNetNet")
-		}
-		cidr1 := strings.Contains(cidrs[0], ":")
-		cidr2 := strings.Contains(cidrs[1], ":")
-
-		if cidr1 != cidr2 {
-			log.WithField("member", member).Panic("Each cidr has different version")
-		}
+	return t.encoder().ClassifyFamily(member) == IPFamilyV6
+}
 
-		return cidr1
-	case IPSetTypeBitmapPort:
-		return strings.HasPrefix("v6,", member)
-	}
-	log.WithField("type", string(t)).Panic("Unknown IPSetType")
-	return false
+// isMemberInFamily returns true if the string representation of a member belongs in an IP set of
+// the given family.  Members without an IP family, such as those of a list:set, belong in both.
+func (t IPSetType) isMemberInFamily(member string, family IPFamily) bool {
+	f := t.encoder().ClassifyFamily(member)
+	return f == "" || f == family
 }
 
 // CanonicaliseMember converts the string representation of an IP set member to a canonical
 // object of some kind.  The object is required to by hashable.
 func (t IPSetType) CanonicaliseMember(member string) IPSetMember {
-	switch t {
-	case IPSetTypeHashIP:
-		// Convert the string into our ip.Addr type, which is backed by an array.
-		ipAddr := ip.FromIPOrCIDRString(member)
-		if ipAddr == nil {
-			// This should be prevented by validation in libcalico-go.
-			log.WithField("ip", member).Panic("Failed to parse IP")
-		}
-		return ipAddr
-	case IPSetTypeHashIPPort:
-		// The member should be of the format <IP>,(tcp|udp):<port number>
-		parts := strings.Split(member, ",")
-		if len(parts) != 2 {
-			log.WithField("member", member).Panic("Failed to parse IP,port IP set member")
-		}
-		ipAddr := ip.FromString(parts[0])
-		if ipAddr == nil {
-			// This should be prevented by validation.
-			log.WithField("member", member).Panic("Failed to parse IP part of IP,port member")
-		}
-		// parts[1] should contain "(tcp|udp|sctp):<port number>"
-		parts = strings.Split(parts[1], ":")
-		var proto labelindex.IPSetPortProtocol
-		switch strings.ToLower(parts[0]) {
-		case "udp":
-			proto = labelindex.ProtocolUDP
-		case "tcp":
-			proto = labelindex.ProtocolTCP
-		case "sctp":
-			proto = labelindex.ProtocolSCTP
-		default:
-			log.WithField("member", member).Panic("Unknown protocol")
-		}
-		port, err := strconv.Atoi(parts[1])
-		if err != nil {
-			log.WithField("member", member).WithError(err).Panic("Bad port")
-		}
-		if port > math.MaxUint16 || port < 0 {
-			log.WithField("member", member).Panic("Bad port range (should be between 0 and 65535)")
-		}
-		// Return a dedicated struct for V4 or V6.  This slightly reduces occupancy over storing
-		// the address as an interface by storing one fewer interface headers.  That is worthwhile
-		// because we store many IP set members.
-		if ipAddr.Version() == 4 {
-			return V4IPPort{
-				IP:       ipAddr.(ip.V4Addr),
-				Port:     uint16(port),
-				Protocol: proto,
-			}
-		} else {
-			return V6IPPort{
-				IP:       ipAddr.(ip.V6Addr),
-				Port:     uint16(port),
-				Protocol: proto,
-			}
-		}
-	case IPSetTypeHashNet:
-		// Convert the string into our ip.CIDR type, which is backed by a struct.  When
-		// pretty-printing, the hash:net ipset type prints IPs with no "/32" or "/128"
-		// suffix.
-		return ip.MustParseCIDROrIP(member)
-	case IPSetTypeBitmapPort:
-		// Trim the family if it exists
-		if member[0] == 'v' {
-			member = member[3:]
-		}
-		port, err := strconv.Atoi(member)
-		if err == nil && port >= 0 && port <= 0xffff {
-			return Port(port)
-		}
-	case IPSetTypeHashNetNet:
-		cidrs := strings.Split(member, ",")
-		return netNet{
-			cidr1: ip.MustParseCIDROrIP(cidrs[0]),
-			cidr2: ip.MustParseCIDROrIP(cidrs[1]),
-		}
-	}
-	log.WithField("type", string(t)).Panic("Unknown IPSetType")
-	return nil
+	return t.encoder().CanonicaliseMember(member)
+}
+
+// RenderMember returns the form of a canonical IP set member that 'ipset restore' expects.
+func (t IPSetType) RenderMember(member IPSetMember) string {
+	return t.encoder().RenderMember(member)
 }
 
 type rawIPSetMember string
@@ -247,12 +227,10 @@ func (nn netNet) String() string {
 	return nn.cidr1.String() + "," + nn.cidr2.String()
 }
 
+// IsValid returns true if the type has a registered MemberEncoder.
 func (t IPSetType) IsValid() bool {
-	switch t {
-	case IPSetTypeHashIP, IPSetTypeHashNet, IPSetTypeHashIPPort, IPSetTypeHashNetNet, IPSetTypeBitmapPort:
-		return true
-	}
-	return false
+	_, ok := memberEncoders[t]
+	return ok
 }
 
 // IPFamily constants for the names that the ipset command uses for the IP versions.
@@ -287,6 +265,16 @@ type IPSetMetadata struct {
 	MaxSize  int
 	RangeMin int
 	RangeMax int
+	// HashSize, if non-zero, is the initial hash size of a hash IP set.  Large IP sets perform
+	// better with a larger hash size than ipset's default.  Ignored for bitmap IP sets.
+	HashSize int
+	// Timeout, if non-zero, is the default timeout of the IP set's members, after which the
+	// kernel removes them.  It is rounded up to a whole number of seconds.  IP sets with a
+	// timeout also support per-member timeouts; see IPSets.AddMembersWithTimeout().
+	Timeout time.Duration
+	// Counters, if set, creates the IP set with per-member packet and byte counters, which can
+	// be read back with IPSets.GetMemberCounters().
+	Counters bool
 }
 
 // IPVersionConfig wraps up the metadata for a particular IP version.  It can be used by
@@ -297,6 +285,9 @@ type IPVersionConfig struct {
 	tempSetNamePrefix     string
 	mainSetNamePrefix     string
 	ourNamePrefixesRegexp *regexp.Regexp
+	// excludedNamesRegexp matches IP set names that we must never claim, even if they have one
+	// of our prefixes; nil if there are none.
+	excludedNamesRegexp *regexp.Regexp
 }
 
 const (
@@ -314,11 +305,16 @@ const (
 	tempIpsetToken = "t"
 )
 
+// NewIPVersionConfig returns the IP set naming config for the given IP family.  excludedIPSetNames
+// lists IP sets that belong to other tools and must be left alone even though their names match
+// one of our prefixes.  Each entry is either an exact name or a pattern in which "*" matches any
+// sequence of characters (example: "cali4-theirs*").
 func NewIPVersionConfig(
 	family IPFamily,
 	namePrefix string,
 	allHistoricPrefixes []string,
 	extraUnversionedIPSets []string,
+	excludedIPSetNames []string,
 ) *IPVersionConfig {
 	var version string
 	switch family {
@@ -341,12 +337,25 @@ func NewIPVersionConfig(
 	log.WithField("regexp", ourNamesPattern).Debug("Calculated IP set name regexp.")
 	ourNamesRegexp := regexp.MustCompile(ourNamesPattern)
 
+	var excludedNamesRegexp *regexp.Regexp
+	if len(excludedIPSetNames) > 0 {
+		var excludedPatterns []string
+		for _, name := range excludedIPSetNames {
+			excludedPatterns = append(excludedPatterns,
+				strings.ReplaceAll(regexp.QuoteMeta(name), `\*`, ".*"))
+		}
+		excludedPattern := "^(" + strings.Join(excludedPatterns, "|") + ")$"
+		log.WithField("regexp", excludedPattern).Debug("Calculated excluded IP set name regexp.")
+		excludedNamesRegexp = regexp.MustCompile(excludedPattern)
+	}
+
 	return &IPVersionConfig{
 		Family:                family,
 		setNamePrefix:         versionedPrefix,
 		tempSetNamePrefix:     versionedPrefix + tempIpsetToken,
 		mainSetNamePrefix:     versionedPrefix + mainIpsetToken,
 		ourNamePrefixesRegexp: ourNamesRegexp,
+		excludedNamesRegexp:   excludedNamesRegexp,
 	}
 }
 
@@ -365,8 +374,11 @@ func (c IPVersionConfig) NameForMainIPSet(setID string) string {
 }
 
 // OwnsIPSet returns true if the given IP set name appears to belong to Felix.  i.e. whether it
-// starts with an expected prefix.
+// starts with an expected prefix and hasn't been explicitly excluded.
 func (c IPVersionConfig) OwnsIPSet(setName string) bool {
+	if c.excludedNamesRegexp != nil && c.excludedNamesRegexp.MatchString(setName) {
+		return false
+	}
 	return c.ourNamePrefixesRegexp.MatchString(setName)
 }
 
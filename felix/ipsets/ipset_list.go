@@ -0,0 +1,158 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipsets
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ipSetListing is the parsed form of the output of "ipset list <name>" for a single IP set.
+type ipSetListing struct {
+	Name    string
+	Type    IPSetType
+	Header  string
+	Members []string
+}
+
+// parseIPSetListing parses the output of "ipset list <name>", which has the following form:
+//
+//	Name: cali40s:qMt7iLlGDhvLnCjM0l9nzxb
+//	Type: hash:ip
+//	Revision: 4
+//	Header: family inet hashsize 1024 maxelem 1048576
+//	Size in memory: 224
+//	References: 0
+//	Members:
+//	10.0.0.2
+//	10.0.0.1
+//
+// Only the first IP set in the output is parsed.
+func parseIPSetListing(r io.Reader) (*ipSetListing, error) {
+	listing := &ipSetListing{}
+	scanner := bufio.NewScanner(r)
+	inMembers := false
+	for scanner.Scan() {
+		line := scanner.Text()
+		if inMembers {
+			if line == "" {
+				// End of members.
+				break
+			}
+			listing.Members = append(listing.Members, line)
+			continue
+		}
+		switch {
+		case strings.HasPrefix(line, "Name:"):
+			listing.Name = strings.TrimSpace(strings.TrimPrefix(line, "Name:"))
+		case strings.HasPrefix(line, "Type:"):
+			listing.Type = IPSetType(strings.TrimSpace(strings.TrimPrefix(line, "Type:")))
+		case strings.HasPrefix(line, "Header:"):
+			listing.Header = strings.TrimSpace(strings.TrimPrefix(line, "Header:"))
+		case strings.HasPrefix(line, "Members:"):
+			inMembers = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		// A read error part way through would otherwise leave us with a truncated member list.
+		return nil, fmt.Errorf("failed to read 'ipset list' output: %w", err)
+	}
+	if listing.Name == "" {
+		return nil, fmt.Errorf("no IP set found in 'ipset list' output")
+	}
+	return listing, nil
+}
+
+// listIPSet runs "ipset list <name>" for a single IP set and parses its output.
+func (s *IPSets) listIPSet(setName string) (*ipSetListing, error) {
+	cmd := s.newCmd("ipset", "list", setName)
+	output, err := cmd.Output()
+	if err != nil {
+		s.logCxt.WithError(err).WithField("setName", setName).Warn("Failed to list IP set")
+		return nil, err
+	}
+	return parseIPSetListing(bytes.NewReader(output))
+}
+
+// maxElem returns the maxelem value from the listing's header.
+func (l *ipSetListing) maxElem() (int, error) {
+	parts := strings.Split(l.Header, " ")
+	for idx, p := range parts {
+		if p != "maxelem" {
+			continue
+		}
+		if idx+1 >= len(parts) {
+			return 0, fmt.Errorf("nothing after 'maxelem' in IP set header %q", l.Header)
+		}
+		return strconv.Atoi(parts[idx+1])
+	}
+	return 0, fmt.Errorf("no maxelem in IP set header %q", l.Header)
+}
+
+// normaliseMembers converts the members of the listing to their canonical string form, without
+// any extensions other than "nomatch", and sorts them.  Members of unknown IP set types are returned verbatim.
+func (l *ipSetListing) normaliseMembers() []string {
+	members := make([]string, 0, len(l.Members))
+	for _, m := range l.Members {
+		if l.Type.IsValid() {
+			member, _ := canonicaliseListedMember(l.Type, m)
+			m = member.String()
+		}
+		members = append(members, m)
+	}
+	sort.Strings(members)
+	return members
+}
+
+// DumpSetToFile reads the current contents of the given IP set from the kernel and writes them
+// to the file at path, one normalised member per line, preceded by a short header recording the
+// IP set ID, type and the time of the snapshot.  It is intended for offline analysis, so it only
+// reads from the dataplane; it doesn't touch our in-memory state.
+func (s *IPSets) DumpSetToFile(setID, path string) error {
+	setName := s.nameForMainIPSet(setID)
+	listing, err := s.listIPSet(setName)
+	if err != nil {
+		return fmt.Errorf("failed to list IP set %s: %w", setName, err)
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# SetID: %s\n", setID)
+	fmt.Fprintf(&buf, "# Name: %s\n", listing.Name)
+	fmt.Fprintf(&buf, "# Type: %s\n", listing.Type)
+	fmt.Fprintf(&buf, "# Timestamp: %s\n", time.Now().UTC().Format(time.RFC3339))
+	for _, m := range listing.normaliseMembers() {
+		buf.WriteString(m)
+		buf.WriteString("\n")
+	}
+
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		return fmt.Errorf("failed to write IP set dump to %s: %w", path, err)
+	}
+	s.logCxt.WithFields(log.Fields{
+		"setID":      setID,
+		"path":       path,
+		"numMembers": len(listing.Members),
+	}).Info("Dumped IP set to file")
+	return nil
+}
@@ -0,0 +1,92 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipsets
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing/iotest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// These tests are in the ipsets package so that they can feed readers directly to the parser.
+var _ = Describe("'ipset list' output parsing", func() {
+	const header = "Name: cali40s:abcd\n" +
+		"Type: hash:ip\n" +
+		"Revision: 4\n" +
+		"Header: family inet hashsize 1024 maxelem 1048576\n" +
+		"Size in memory: 224\n" +
+		"References: 0\n" +
+		"Members:\n"
+
+	It("should parse a listing with a trailing newline", func() {
+		listing, err := parseIPSetListing(strings.NewReader(header + "10.0.0.1\n10.0.0.2\n"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(listing.Name).To(Equal("cali40s:abcd"))
+		Expect(listing.Type).To(Equal(IPSetTypeHashIP))
+		Expect(listing.Header).To(Equal("family inet hashsize 1024 maxelem 1048576"))
+		Expect(listing.Members).To(Equal([]string{"10.0.0.1", "10.0.0.2"}))
+	})
+
+	It("should parse a listing without a trailing newline", func() {
+		listing, err := parseIPSetListing(strings.NewReader(header + "10.0.0.1\n10.0.0.2"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(listing.Members).To(Equal([]string{"10.0.0.1", "10.0.0.2"}))
+	})
+
+	It("should trim whitespace from the name", func() {
+		listing, err := parseIPSetListing(strings.NewReader("Name:   cali40s:abcd  \nMembers:\n"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(listing.Name).To(Equal("cali40s:abcd"))
+		Expect(listing.Members).To(BeEmpty())
+	})
+
+	It("should return an error, rather than a partial listing, on a mid-stream read error", func() {
+		readErr := errors.New("dummy read error")
+		r := io.MultiReader(
+			strings.NewReader(header+"10.0.0.1\n"),
+			iotest.ErrReader(readErr),
+		)
+		listing, err := parseIPSetListing(r)
+		Expect(err).To(HaveOccurred())
+		Expect(errors.Is(err, readErr)).To(BeTrue())
+		Expect(listing).To(BeNil())
+	})
+
+	It("should parse member counters", func() {
+		listing, err := parseIPSetListing(strings.NewReader(
+			"Name: cali40s:abcd\n" +
+				"Type: hash:net\n" +
+				"Header: family inet hashsize 1024 maxelem 1048576 counters\n" +
+				"Members:\n" +
+				"10.0.0.0/24 packets 10 bytes 840\n" +
+				"10.0.1.1 timeout 30 packets 0 bytes 0 comment \"a b\"\n" +
+				"10.0.2.0/24 packets x bytes 0\n",
+		))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(listing.memberCounters()).To(Equal(map[IPSetMember]MemberCounters{
+			IPSetTypeHashNet.CanonicaliseMember("10.0.0.0/24"): {Packets: 10, Bytes: 840},
+			IPSetTypeHashNet.CanonicaliseMember("10.0.1.1"):    {},
+		}))
+	})
+
+	It("should return an error for empty output", func() {
+		_, err := parseIPSetListing(strings.NewReader(""))
+		Expect(err).To(HaveOccurred())
+	})
+})
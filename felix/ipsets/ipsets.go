@@ -17,8 +17,10 @@ package ipsets
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -35,15 +37,27 @@ const (
 	MaxIPSetDeletionsPerIteration = 1
 )
 
+// errIPSetDoesNotExist is returned by deleteIPSet if ipset reports that the IP set doesn't exist.
+var errIPSetDoesNotExist = errors.New("IP set does not exist")
+
 type dataplaneMetadata struct {
 	Type         IPSetType
 	MaxSize      int
+	HashSize     int // 0 for ipset's default.
 	RangeMin     int
 	RangeMax     int
+	Timeout      int // In seconds, 0 if the IP set has no timeout.
+	Counters     bool
 	DeleteFailed bool
 }
 
 // IPSets manages a whole "plane" of IP sets, i.e. all the IPv4 sets, or all the IPv6 IP sets.
+//
+// IPSets is not safe for concurrent use; all its methods must be called from the same goroutine.
+// In particular, the clean-up of left-over IP sets is not a separate operation: resync (within
+// ApplyUpdates()) only queues the left-overs for deletion and ApplyDeletions() deletes them.  An
+// IP set that is added or re-added in between is desired again by the time ApplyDeletions()
+// runs, so it is never reaped.
 type IPSets struct {
 	IPVersionConfig *IPVersionConfig
 
@@ -51,6 +65,9 @@ type IPSets struct {
 	// added by a call to AddOrReplaceIPSet (and not subsequently removed).
 	// It is *not* filtered by neededIPSetNames.
 	setNameToAllMetadata map[string]dataplaneMetadata
+	// mainSetNameToSetID maps from the main IP set name back to the IP set ID for each IP
+	// set in setNameToAllMetadata.  Needed because the IP set name may be truncated.
+	mainSetNameToSetID map[string]string
 	// setNameToProgrammedMetadata tracks the IP sets that we want to program and
 	// those that are actually in the dataplane.  It's Desired() map is the
 	// subset of setNameToAllMetadata that matches the neededIPSetNames filter.
@@ -69,6 +86,10 @@ type IPSets struct {
 	mainSetNameToMembers   map[string]*deltatracker.SetDeltaTracker[IPSetMember]
 	nextTempIPSetIdx       uint
 	ipSetsWithDirtyMembers set.Set[string]
+	// ipSetsNeedingRewrite contains the names of IP sets that resync found to have members with
+	// extensions that we don't set, such as "nomatch".  Those can't be fixed with an add or
+	// delete so the IP set is rewritten via a temporary IP set.
+	ipSetsNeedingRewrite set.Set[string]
 
 	resyncRequired bool
 
@@ -79,6 +100,10 @@ type IPSets struct {
 	sleep func(time.Duration)
 
 	gaugeNumIpsets prometheus.Gauge
+	// gaugeNumTempOrphans and gaugeNumMainOrphans record the number of left-over temporary and
+	// main IP sets found by the most recent resync.
+	gaugeNumTempOrphans prometheus.Gauge
+	gaugeNumMainOrphans prometheus.Gauge
 
 	logCxt *log.Entry
 
@@ -97,14 +122,217 @@ type IPSets struct {
 	// Optional filter.  When non-nil, only these IP set IDs will be rendered into the dataplane
 	// as Linux IP sets.
 	neededIPSetNames set.Set[string]
+
+	// expectedDeletions contains the names of IP sets that we've been asked to remove via
+	// RemoveIPSet() but that we haven't yet deleted from the dataplane.  It allows us to
+	// distinguish those from left-over IP sets that we don't recognise.
+	expectedDeletions set.Set[string]
+
+	// ourTempIPSets contains the temporary IP sets that we created to rewrite IP sets and that
+	// we haven't yet deleted.  Any other temporary IP set in the dataplane is left over from a
+	// previous run.
+	ourTempIPSets set.Set[string]
+
+	// Optional callback, called before we delete a left-over IP set that we don't recognise.
+	onLeftoverRemoved func(setName, reason string)
+
+	// checkedFamilyErrors, if set, causes the checked API to reject members of the wrong IP
+	// family rather than silently dropping them.
+	checkedFamilyErrors bool
+
+	// deletionBatchSize, if non-zero, is the maximum number of IP sets that ApplyDeletions()
+	// destroys per call, using a single ipset restore.
+	deletionBatchSize int
+
+	// retryConfig controls the retries in ApplyUpdatesChecked(); see WithRetryConfig().
+	retryConfig RetryConfig
+
+	// verifyMaxElem, if set, causes us to read back the maxelem of each IP set that we create.
+	verifyMaxElem bool
+	// createdIPSets contains the IP sets that we (re)created in the current ipset restore, along
+	// with the maxelem that we requested.
+	createdIPSets map[string]int
+	// setNameToEffectiveMaxSize contains the maxelem that the kernel reported for each IP set
+	// that we've verified.
+	setNameToEffectiveMaxSize map[string]int
+
+	// shadowGenerator, if non-nil, enables shadow mode; see WithShadowMode().
+	shadowGenerator ShadowGenerator
+
+	// restoreFlavor is the dialect of the input that we generate for 'ipset restore'.  If it is
+	// RestoreFlavorAuto, it is detected on the first apply.
+	restoreFlavor RestoreFlavor
+	// minModernIPSetVersion is the oldest ipset version that auto-detection drives with the
+	// modern restore flavor.
+	minModernIPSetVersion [2]int
+
+	// overlapCheckMode controls whether we check hash:net IP sets for members that are
+	// contained within other members; see WithOverlapCheck().
+	overlapCheckMode OverlapCheckMode
+
+	// tempNameEpoch, if non-empty, enables debug temporary IP set names; see
+	// WithDebugTempIPSetNames().
+	tempNameEpoch string
+
+	// restoreInputWarningBytes, if non-zero, is the size of 'ipset restore' input above which we
+	// log a warning; see WithRestoreInputSizeWarning().
+	restoreInputWarningBytes int
+	// lastRestoreInputSize is the size of the input to the most recent 'ipset restore' that
+	// applied IP set updates.
+	lastRestoreInputSize RestoreInputSize
+	// pendingApplySummaries contains the changes written to each IP set by the restore that is
+	// in progress, to be logged if it succeeds.
+	pendingApplySummaries  []ipSetApplySummary
+	gaugeRestoreInputBytes prometheus.Gauge
+	gaugeRestoreInputLines prometheus.Gauge
+
+	// referencedSetPolicy controls what we do about IP sets that we repeatedly fail to delete;
+	// see WithReferencedSetPolicy().
+	referencedSetPolicy       ReferencedSetPolicy
+	referencedSetMaxAttempts  int
+	onReferencedSetEscalation func(setName string, attempts int)
+	// setNameToDeleteFailures contains the number of failed deletions of each IP set that is
+	// pending deletion.
+	setNameToDeleteFailures map[string]int
+	// abandonedDeletions contains the IP sets that, due to the ReferencedSetPolicyGiveUp
+	// policy, we no longer try to delete.
+	abandonedDeletions set.Set[string]
+
+	// canaryEnabled, if set, enables the canary IP set; see WithCanaryIPSet().
+	canaryEnabled        bool
+	canaryCheckInterval  time.Duration
+	onCanaryInterference func(reason string)
+	lastCanaryCheck      time.Time
+
+	// commitStrategy controls where we put the COMMIT lines when updating several IP sets; see
+	// WithCommitStrategy().
+	commitStrategy CommitStrategy
+
+	// suspended, if set, causes ApplyUpdates() and ApplyDeletions() to do nothing; see
+	// Suspend().
+	suspended bool
+
+	// setNameToChurn contains the number of members added to and removed from each IP set since
+	// the last call to Advise().
+	setNameToChurn  map[string]int
+	gaugeTypeAdvice prometheus.Gauge
+
+	// setNameToMemberTimeouts contains the timeout state of the members of IP sets that have
+	// a default timeout; see AddMembersWithTimeout().
+	setNameToMemberTimeouts map[string]map[IPSetMember]*memberTimeout
+
+	// setNameToExceptions contains the exceptions of each hash:net IP set; see AddExceptions().
+	// They're also in the desired members but we keep them separately so that
+	// AddOrReplaceIPSet() can carry them over.
+	setNameToExceptions map[string]set.Set[noMatchMember]
+
+	// setNameToCarriedCounters contains, for each IP set with counters that we're about to
+	// rewrite via a temporary IP set, the counters of its members before the rewrite.  Only
+	// valid during tryRestore().
+	setNameToCarriedCounters map[string]map[IPSetMember]MemberCounters
+
+	// Shim for time.Now(), used to track when members with timeouts expire.
+	now func() time.Time
+
+	// dryRun, if set, causes us to log the ipset commands that we would run, rather than
+	// running them; see WithDryRun().
+	dryRun bool
+
+	// ipsetCommandMissing is set if our last attempt to update the dataplane found that the
+	// ipset binary isn't installed.  Used to only warn about it once.
+	ipsetCommandMissing bool
+}
+
+type IPSetsOpt func(s *IPSets)
+
+// Reasons passed to the WithOnLeftoverRemoved callback.
+const (
+	// LeftoverReasonNotExpected means that the IP set is a main IP set that we don't recognise.
+	LeftoverReasonNotExpected = "not in expected list"
+	// LeftoverReasonTempIPSet means that the IP set is a temporary IP set that we didn't create,
+	// for example because we crashed part way through a rewrite.
+	LeftoverReasonTempIPSet = "left-over temporary IP set"
+)
+
+// WithOnLeftoverRemoved sets a callback that is called just before a left-over Calico IP set
+// (i.e. one that we found in the dataplane but don't recognise) is deleted, with the reason that
+// it is being removed; one of the LeftoverReason... constants.  Such IP sets may indicate a bug
+// or interference from another process.
+func WithOnLeftoverRemoved(cb func(setName, reason string)) IPSetsOpt {
+	return func(s *IPSets) {
+		s.onLeftoverRemoved = cb
+	}
+}
+
+// WithCheckedFamilyErrors makes the checked API (AddOrReplaceIPSetChecked, AddMembersChecked,
+// AddMembersWithTimeout and AddExceptions) return a *WrongFamilyError, and make no changes, if
+// passed members that don't match the IP family of the IPSets object.  It doesn't affect
+// AddOrReplaceIPSet and AddMembers, which always drop such members; dual-stack callers rely on
+// that to pass the same members to the IPv4 and IPv6 IPSets.
+func WithCheckedFamilyErrors() IPSetsOpt {
+	return func(s *IPSets) {
+		s.checkedFamilyErrors = true
+	}
+}
+
+// WithBatchedDeletions makes ApplyDeletions() destroy up to batchSize IP sets per call using a
+// single "ipset restore", rather than one "ipset destroy" per IP set.  If the batch fails (for
+// example, because one of the IP sets is still referenced), we fall back to destroying the IP
+// sets in the batch individually so that one problematic IP set doesn't block the rest.
+func WithBatchedDeletions(batchSize int) IPSetsOpt {
+	return func(s *IPSets) {
+		s.deletionBatchSize = batchSize
+	}
+}
+
+// WithMaxElemVerification makes the IPSets object read back the maxelem of each (hash) IP set
+// that it creates from the kernel, after the create has been committed.  If the kernel has
+// capped the maxelem, we log a warning and increment a counter.  Each check costs an extra
+// "ipset list" so this is not enabled by default.
+func WithMaxElemVerification() IPSetsOpt {
+	return func(s *IPSets) {
+		s.verifyMaxElem = true
+	}
+}
+
+// WrongFamilyError is returned by the checked API, if WithCheckedFamilyErrors is set, when members
+// are passed to an IPSets object that don't match its IP family.
+type WrongFamilyError struct {
+	Family  IPFamily
+	SetID   string
+	Members []string
 }
 
-func NewIPSets(ipVersionConfig *IPVersionConfig, recorder logutils.OpRecorder) *IPSets {
+func (e *WrongFamilyError) Error() string {
+	return fmt.Sprintf("members of IP set %s don't match IP family %s: %v", e.SetID, e.Family, e.Members)
+}
+
+// InvalidMembersError is returned by AddMembersChecked and AddOrReplaceIPSetChecked when members
+// are malformed for the type of the IP set, for example a hash:ip,port member that isn't of the
+// form <IP>,<protocol>:<port>.
+type InvalidMembersError struct {
+	SetID   string
+	Type    IPSetType
+	Members []string
+	// Err is the reason that the first of the Members is invalid.
+	Err error
+}
+
+func (e *InvalidMembersError) Error() string {
+	return fmt.Sprintf("members of IP set %s are not valid for type %s: %v: %v", e.SetID, e.Type, e.Members, e.Err)
+}
+
+func (e *InvalidMembersError) Unwrap() error {
+	return e.Err
+}
+
+func NewIPSets(ipVersionConfig *IPVersionConfig, recorder logutils.OpRecorder, opts ...IPSetsOpt) *IPSets {
 	return NewIPSetsWithShims(
 		ipVersionConfig,
 		recorder,
 		newRealCmd,
 		time.Sleep,
+		opts...,
 	)
 }
 
@@ -114,12 +342,19 @@ func NewIPSetsWithShims(
 	recorder logutils.OpRecorder,
 	cmdFactory cmdFactory,
 	sleep func(time.Duration),
+	opts ...IPSetsOpt,
 ) *IPSets {
 	familyStr := string(ipVersionConfig.Family)
-	return &IPSets{
+	s := &IPSets{
 		IPVersionConfig: ipVersionConfig,
 
 		setNameToAllMetadata: map[string]dataplaneMetadata{},
+		mainSetNameToSetID:   map[string]string{},
+
+		retryConfig: DefaultRetryConfig,
+
+		createdIPSets:             map[string]int{},
+		setNameToEffectiveMaxSize: map[string]int{},
 		setNameToProgrammedMetadata: deltatracker.New[string, dataplaneMetadata](
 			deltatracker.WithValuesEqualFn[string, dataplaneMetadata](func(a, b dataplaneMetadata) bool {
 				return a == b
@@ -131,24 +366,76 @@ func NewIPSetsWithShims(
 		mainSetNameToMembers: map[string]*deltatracker.SetDeltaTracker[IPSetMember]{},
 
 		ipSetsWithDirtyMembers: set.New[string](),
+		ipSetsNeedingRewrite:   set.New[string](),
+		expectedDeletions:      set.New[string](),
+		ourTempIPSets:          set.New[string](),
 		resyncRequired:         true,
 
+		referencedSetPolicy:      ReferencedSetPolicyRetry,
+		setNameToDeleteFailures:  map[string]int{},
+		abandonedDeletions:       set.New[string](),
+		setNameToChurn:           map[string]int{},
+		setNameToMemberTimeouts:  map[string]map[IPSetMember]*memberTimeout{},
+		setNameToExceptions:      map[string]set.Set[noMatchMember]{},
+		setNameToCarriedCounters: map[string]map[IPSetMember]MemberCounters{},
+		minModernIPSetVersion:    defaultMinModernIPSetVersion,
+
 		newCmd: cmdFactory,
 		sleep:  sleep,
+		now:    time.Now,
+
+		gaugeNumIpsets:      gaugeVecNumCalicoIpsets.WithLabelValues(familyStr),
+		gaugeNumTempOrphans: gaugeVecNumOrphanIPSets.WithLabelValues(familyStr, "temp"),
+		gaugeNumMainOrphans: gaugeVecNumOrphanIPSets.WithLabelValues(familyStr, "main"),
 
-		gaugeNumIpsets: gaugeVecNumCalicoIpsets.WithLabelValues(familyStr),
+		gaugeRestoreInputBytes: gaugeVecRestoreInputBytes.WithLabelValues(familyStr),
+		gaugeRestoreInputLines: gaugeVecRestoreInputLines.WithLabelValues(familyStr),
+		gaugeTypeAdvice:        gaugeVecIPSetTypeAdvice.WithLabelValues(familyStr),
 
 		logCxt: log.WithFields(log.Fields{
 			"family": ipVersionConfig.Family,
 		}),
 		opReporter: recorder,
 	}
+	for _, o := range opts {
+		o(s)
+	}
+	if s.canaryEnabled {
+		s.addCanaryIPSet()
+	}
+	return s
 }
 
 // AddOrReplaceIPSet queues up the creation (or replacement) of an IP set.  After the next call
 // to ApplyUpdates(), the IP sets will be replaced with the new contents and the set's metadata
-// will be updated as appropriate.
+// will be updated as appropriate.  Exceptions, added with AddExceptions(), are kept unless the
+// IP set is no longer a hash:net or one of the new members is for the same CIDR.
 func (s *IPSets) AddOrReplaceIPSet(setMetadata IPSetMetadata, members []string) {
+	s.logOverlaps(setMetadata.SetID, setMetadata.Type, nil, members)
+	s.addOrReplaceIPSet(setMetadata, members)
+}
+
+// AddOrReplaceIPSetChecked is as AddOrReplaceIPSet but it returns an *InvalidMembersError if any
+// of the members are malformed and, with WithCheckedFamilyErrors, a *WrongFamilyError if any of the
+// members are of the wrong IP family.  In either case, it leaves the IP set unchanged.
+func (s *IPSets) AddOrReplaceIPSetChecked(setMetadata IPSetMetadata, members []string) error {
+	if err := checkMembersValid(setMetadata.SetID, setMetadata.Type, members); err != nil {
+		return err
+	}
+	if err := s.checkMemberFamilies(setMetadata.SetID, setMetadata.Type, members); err != nil {
+		return err
+	}
+	if err := s.checkOverlaps(setMetadata.SetID, setMetadata.Type, nil, members); err != nil {
+		if s.overlapCheckMode == OverlapCheckReject {
+			return err
+		}
+		s.logCxt.WithError(err).Warning("Adding IP set members that are contained within other members.")
+	}
+	s.addOrReplaceIPSet(setMetadata, members)
+	return nil
+}
+
+func (s *IPSets) addOrReplaceIPSet(setMetadata IPSetMetadata, members []string) {
 	// We need to convert members to a canonical representation (which may be, for example,
 	// an ip.Addr instead of a string) so that we can compare them with members that we read
 	// back from the dataplane.  This also filters out IPs of the incorrect IP version.
@@ -162,33 +449,46 @@ func (s *IPSets) AddOrReplaceIPSet(setMetadata IPSetMetadata, members []string)
 	// If the IP set exists, but it has the wrong metadata then the
 	// DeltaTracker will catch that and mark it for recreation.
 	mainIPSetName := s.IPVersionConfig.NameForMainIPSet(setID)
+	s.expectedDeletions.Discard(mainIPSetName)
 	dpMeta := dataplaneMetadata{
 		Type:     setMetadata.Type,
 		MaxSize:  setMetadata.MaxSize,
+		HashSize: setMetadata.HashSize,
 		RangeMin: setMetadata.RangeMin,
 		RangeMax: setMetadata.RangeMax,
+		Timeout:  timeoutSeconds(setMetadata.Timeout),
+		Counters: setMetadata.Counters,
 	}
 	s.setNameToAllMetadata[mainIPSetName] = dpMeta
+	s.mainSetNameToSetID[mainIPSetName] = setID
 	if s.ipSetNeeded(mainIPSetName) {
 		s.setNameToProgrammedMetadata.Desired().Set(mainIPSetName, dpMeta)
 	}
 
 	// Set the desired contents of the IP set.
 	canonMembers := s.filterAndCanonicaliseMembers(setMetadata.Type, members)
+	s.mergeExceptions(mainIPSetName, setMetadata.Type, canonMembers)
 	memberTracker := s.getOrCreateMemberTracker(mainIPSetName)
 
 	desiredMembers := memberTracker.Desired()
+	numChanges := 0
 	desiredMembers.Iter(func(k IPSetMember) {
 		if canonMembers.Contains(k) {
 			canonMembers.Discard(k)
 		} else {
 			desiredMembers.Delete(k)
+			numChanges++
 		}
 	})
 	canonMembers.Iter(func(m IPSetMember) error {
 		desiredMembers.Add(m)
+		numChanges++
 		return nil
 	})
+	s.recordChurn(mainIPSetName, numChanges)
+	// Keep any growth from a previous update; otherwise we'd shrink the IP set, only to grow
+	// it again in tryUpdates().
+	s.maybeGrowMaxSize(mainIPSetName)
 	s.updateDirtiness(mainIPSetName)
 }
 
@@ -210,12 +510,17 @@ func (s *IPSets) RemoveIPSet(setID string) {
 	// delete it.
 	setName := s.nameForMainIPSet(setID)
 	delete(s.setNameToAllMetadata, setName)
+	delete(s.mainSetNameToSetID, setName)
+	delete(s.setNameToChurn, setName)
+	delete(s.setNameToMemberTimeouts, setName)
+	delete(s.setNameToExceptions, setName)
 	s.setNameToProgrammedMetadata.Desired().Delete(setName)
 	if _, ok := s.setNameToProgrammedMetadata.Dataplane().Get(setName); ok {
 		// Set is currently in the dataplane, clear its desired members but
 		// we keep the member tracker until we actually delete the IP set
 		// from the dataplane later.
 		log.Debug("IP set to remove is in the dataplane.")
+		s.expectedDeletions.Add(setName)
 		s.mainSetNameToMembers[setName].Desired().DeleteAll()
 	} else {
 		// If it's not in the dataplane, clean it up immediately.
@@ -237,16 +542,56 @@ func (s *IPSets) AddMembers(setID string, newMembers []string) {
 	if !ok {
 		log.WithField("setName", setName).Panic("AddMembers called for nonexistent IP set.")
 	}
+	s.logOverlaps(setID, setMeta.Type, s.mainSetNameToMembers[setName].Desired(), newMembers)
+	s.addMembers(setName, setMeta, newMembers)
+}
+
+// AddMembersChecked is as AddMembers but it returns an error, rather than panicking, if the IP
+// set doesn't exist.  It also returns an *InvalidMembersError if any of the members are malformed
+// and, with WithCheckedFamilyErrors, a *WrongFamilyError if any of the members are of the wrong IP
+// family; in either case, it leaves the IP set unchanged.
+func (s *IPSets) AddMembersChecked(setID string, newMembers []string) error {
+	setName := s.nameForMainIPSet(setID)
+	setMeta, ok := s.setNameToAllMetadata[setName]
+	if !ok {
+		return fmt.Errorf("ipset %s not found", setID)
+	}
+	if err := checkMembersValid(setID, setMeta.Type, newMembers); err != nil {
+		return err
+	}
+	if err := s.checkMemberFamilies(setID, setMeta.Type, newMembers); err != nil {
+		return err
+	}
+	if err := s.checkOverlaps(setID, setMeta.Type, s.mainSetNameToMembers[setName].Desired(), newMembers); err != nil {
+		if s.overlapCheckMode == OverlapCheckReject {
+			return err
+		}
+		s.logCxt.WithError(err).Warning("Adding IP set members that are contained within other members.")
+	}
+	if err := s.checkExceptionConflicts(setID, setMeta.Type, newMembers); err != nil {
+		return err
+	}
+	s.addMembers(setName, setMeta, newMembers)
+	return nil
+}
+
+func (s *IPSets) addMembers(setName string, setMeta dataplaneMetadata, newMembers []string) {
 	canonMembers := s.filterAndCanonicaliseMembers(setMeta.Type, newMembers)
 	if canonMembers.Len() == 0 {
 		s.logCxt.Debug("After filtering, found no members to add")
 		return
 	}
+	s.dropConflictingExceptions(setName, setMeta.Type, canonMembers.Slice())
 	membersTracker := s.mainSetNameToMembers[setName]
+	numChanges := 0
 	canonMembers.Iter(func(member IPSetMember) error {
-		membersTracker.Desired().Add(member)
+		if !membersTracker.Desired().Contains(member) {
+			membersTracker.Desired().Add(member)
+			numChanges++
+		}
 		return nil
 	})
+	s.recordChurn(setName, numChanges)
 	s.updateDirtiness(setName)
 }
 
@@ -264,10 +609,15 @@ func (s *IPSets) RemoveMembers(setID string, removedMembers []string) {
 		return
 	}
 	membersTracker := s.mainSetNameToMembers[setName]
+	numChanges := 0
 	canonMembers.Iter(func(member IPSetMember) error {
-		membersTracker.Desired().Delete(member)
+		if membersTracker.Desired().Contains(member) {
+			membersTracker.Desired().Delete(member)
+			numChanges++
+		}
 		return nil
 	})
+	s.recordChurn(setName, numChanges)
 	s.updateDirtiness(setName)
 }
 
@@ -290,19 +640,100 @@ func (s *IPSets) GetTypeOf(setID string) (IPSetType, error) {
 	return setMeta.Type, nil
 }
 
+// SetsByType returns the IDs of the IP sets of the given type that have been added by
+// AddOrReplaceIPSet (and not subsequently removed), in sorted order.  Returns an empty slice if
+// there are no such IP sets.
+func (s *IPSets) SetsByType(t IPSetType) []string {
+	setIDs := []string{}
+	for setName, meta := range s.setNameToAllMetadata {
+		if meta.Type != t || s.isCanaryIPSet(setName) {
+			continue
+		}
+		setIDs = append(setIDs, s.mainSetNameToSetID[setName])
+	}
+	sort.Strings(setIDs)
+	return setIDs
+}
+
 func (s *IPSets) filterAndCanonicaliseMembers(ipSetType IPSetType, members []string) set.Set[IPSetMember] {
 	filtered := set.New[IPSetMember]()
-	wantIPV6 := s.IPVersionConfig.Family == IPFamilyV6
+	validator, _ := ipSetType.encoder().(MemberValidator)
 	for _, member := range members {
-		isIPV6 := ipSetType.IsMemberIPV6(member)
-		if wantIPV6 != isIPV6 {
+		if !ipSetType.isMemberInFamily(member, s.IPVersionConfig.Family) {
 			continue
 		}
+		if validator != nil {
+			if err := validator.ValidateMember(member); err != nil {
+				s.logCxt.WithError(err).WithField("type", ipSetType).Warning("Dropping invalid IP set member.")
+				countNumIPSetInvalidMembers.Inc()
+				continue
+			}
+		}
+		if ipSetType == IPSetTypeListSet {
+			member = s.listSetMemberName(member)
+		}
 		filtered.Add(ipSetType.CanonicaliseMember(member))
 	}
 	return filtered
 }
 
+// checkMembersValid returns an *InvalidMembersError if any of the members are malformed for the
+// type of IP set.
+func checkMembersValid(setID string, ipSetType IPSetType, members []string) error {
+	var invalid []string
+	var firstErr error
+	for _, member := range members {
+		if err := ipSetType.ValidateMember(member); err != nil {
+			invalid = append(invalid, member)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	if len(invalid) == 0 {
+		return nil
+	}
+	return &InvalidMembersError{
+		SetID:   setID,
+		Type:    ipSetType,
+		Members: invalid,
+		Err:     firstErr,
+	}
+}
+
+// wrongFamilyMembers returns the members that don't match our IP family.
+func (s *IPSets) wrongFamilyMembers(ipSetType IPSetType, members []string) []string {
+	var wrong []string
+	for _, member := range members {
+		if !ipSetType.isMemberInFamily(member, s.IPVersionConfig.Family) {
+			wrong = append(wrong, member)
+		}
+	}
+	return wrong
+}
+
+// memberMatchesFamily returns true if the (canonicalised) member matches our IP family.
+func (s *IPSets) memberMatchesFamily(ipSetType IPSetType, member IPSetMember) bool {
+	return ipSetType.isMemberInFamily(ipSetType.RenderMember(member), s.IPVersionConfig.Family)
+}
+
+// checkMemberFamilies returns a *WrongFamilyError if WithCheckedFamilyErrors is set and any of the
+// members don't match our IP family.
+func (s *IPSets) checkMemberFamilies(setID string, ipSetType IPSetType, members []string) error {
+	if !s.checkedFamilyErrors {
+		return nil
+	}
+	wrong := s.wrongFamilyMembers(ipSetType, members)
+	if len(wrong) == 0 {
+		return nil
+	}
+	return &WrongFamilyError{
+		Family:  s.IPVersionConfig.Family,
+		SetID:   setID,
+		Members: wrong,
+	}
+}
+
 func (s *IPSets) GetDesiredMembers(setID string) (set.Set[string], error) {
 	setName := s.nameForMainIPSet(setID)
 
@@ -322,17 +753,69 @@ func (s *IPSets) GetDesiredMembers(setID string) (set.Set[string], error) {
 	return strs, nil
 }
 
+// GetMembers returns the desired members of the IP set, in sorted order (as for the ipset
+// restore input).  It includes any adds and removes that haven't been applied yet, so it reflects
+// the state that the IP set will have after the next ApplyUpdates() rather than what is in the
+// dataplane.  Returns an error if the IP set is unknown.
+func (s *IPSets) GetMembers(setID string) ([]string, error) {
+	setName := s.nameForMainIPSet(setID)
+	if _, ok := s.setNameToAllMetadata[setName]; !ok {
+		return nil, fmt.Errorf("ipset %s not found", setID)
+	}
+	var members []IPSetMember
+	s.mainSetNameToMembers[setName].Desired().Iter(func(k IPSetMember) {
+		members = append(members, k)
+	})
+	sortMembers(members)
+	strs := make([]string, len(members))
+	for i, m := range members {
+		strs[i] = m.String()
+	}
+	return strs, nil
+}
+
 // ApplyUpdates applies the updates to the dataplane.  Returns a set of programmed IPs in the IPSets included by the
 // ipsetFilter.
+//
+// If the updates still fail once the retries are exhausted, ApplyUpdates panics.
 func (s *IPSets) ApplyUpdates() {
-	success := false
-	retryDelay := 1 * time.Millisecond
-	backOff := func() {
-		s.sleep(retryDelay)
-		retryDelay *= 2
+	if err := s.ApplyUpdatesChecked(); errors.Is(err, ErrIPSetUpdatesFailed) {
+		s.logCxt.WithError(err).Panic("Failed to update IP sets after multiple retries.")
+	}
+}
+
+// ApplyUpdatesChecked is like ApplyUpdates() but, rather than panicking, it returns an error
+// wrapping ErrIPSetUpdatesFailed if the updates still fail once the retries are exhausted,
+// leaving the caller to decide whether to give up.  If the ipset binary isn't installed, it
+// returns ErrIPSetCommandMissing without retrying.  Either way, the updates stay queued so
+// they're applied by a later call.
+func (s *IPSets) ApplyUpdatesChecked() error {
+	if s.suspended {
+		s.logCxt.Debug("IP set updates suspended, skipping apply.")
+		return nil
 	}
 
-	for attempt := 0; attempt < 10; attempt++ {
+	s.forgetExpiredMembers()
+	if err := s.maybeDetectRestoreFlavor(); err != nil {
+		s.reportCommandMissing(err)
+		return err
+	}
+
+	var lastErr error
+	numFailures := 0
+	backOff := func(err error) {
+		lastErr = err
+		numFailures++
+		s.sleep(s.retryConfig.backoff(numFailures))
+	}
+
+	s.maybeCheckCanary()
+
+	// After a failure, we update the IP sets one at a time so that one bad IP set can't block
+	// updates to the others.
+	oneByOne := false
+	success := false
+	for attempt := 0; attempt <= s.retryConfig.MaxRetries; attempt++ {
 		if attempt > 0 {
 			s.logCxt.Info("Retrying after an ipsets update failure...")
 		}
@@ -343,8 +826,12 @@ func (s *IPSets) ApplyUpdates() {
 			s.opReporter.RecordOperation(fmt.Sprint("resync-ipsets-v", s.IPVersionConfig.Family.Version()))
 
 			if err := s.tryResync(); err != nil {
+				if errors.Is(err, ErrIPSetCommandMissing) {
+					s.reportCommandMissing(err)
+					return err
+				}
 				s.logCxt.WithError(err).Warning("Failed to resync with dataplane")
-				backOff()
+				backOff(err)
 				continue
 			}
 			s.resyncRequired = false
@@ -355,12 +842,20 @@ func (s *IPSets) ApplyUpdates() {
 		// and deleting some temp sets might free up some room.
 		s.tryTempIPSetDeletions()
 
-		if err := s.tryUpdates(); err != nil {
+		if err := s.tryUpdates(oneByOne); err != nil {
+			if errors.Is(err, ErrIPSetCommandMissing) {
+				// Our tracking may now be out of sync, resync before we next try.  The IP
+				// sets that we failed to update are still dirty.
+				s.resyncRequired = true
+				s.reportCommandMissing(err)
+				return err
+			}
 			// Update failures may mean that our iptables updates fail.  We need to do an immediate resync.
 			s.logCxt.WithError(err).Warning("Failed to update IP sets. Marking dataplane for resync.")
 			s.resyncRequired = true
 			countNumIPSetErrors.Inc()
-			backOff()
+			oneByOne = true
+			backOff(err)
 			continue
 		}
 
@@ -369,14 +864,29 @@ func (s *IPSets) ApplyUpdates() {
 	}
 	if !success {
 		s.dumpIPSetsToLog()
-		s.logCxt.Panic("Failed to update IP sets after multiple retries.")
+		// Our tracking is suspect after the failures, resync before we next try.
+		s.resyncRequired = true
+		return fmt.Errorf("%w (%d attempts): %v", ErrIPSetUpdatesFailed, numFailures, lastErr)
 	}
+	s.ipsetCommandMissing = false
 	gaugeNumTotalIpsets.Set(float64(s.setNameToProgrammedMetadata.Dataplane().Len()))
+	return nil
 }
 
 // tryResync attempts to bring our state into sync with the dataplane.  It scans the contents of the
 // IP sets in the dataplane and queues up updates to any IP sets that are out-of-sync.
+//
+// The members found in the dataplane are loaded into the member trackers so, after a restart,
+// the first apply only writes the difference between the desired members and those already in
+// the kernel.  An IP set is only rewritten (via a temporary IP set) if its metadata has changed.
 func (s *IPSets) tryResync() (err error) {
+	if s.dryRun {
+		// Dry-run mode mustn't touch the host so we don't read the dataplane; we treat it as
+		// empty instead.
+		s.logCxt.Debug("Dry run: skipping IP sets resync.")
+		return nil
+	}
+
 	// Log the time spent as we exit the function.
 	resyncStart := time.Now()
 	defer func() {
@@ -426,6 +936,7 @@ func (s *IPSets) tryResync() (err error) {
 	err = cmd.Start()
 	if err != nil {
 		s.logCxt.WithError(err).Error("Failed to start 'ipset list'")
+		err = wrapIfCommandMissing(err)
 		return
 	}
 	summaryExecStart.Observe(float64(time.Since(execStartTime).Nanoseconds()) / 1000.0)
@@ -445,6 +956,7 @@ func (s *IPSets) tryResync() (err error) {
 	// Clear the dataplane metadata view, we'll build it back up again as we
 	// scan.
 	s.setNameToProgrammedMetadata.Dataplane().DeleteAll()
+	s.ipSetsNeedingRewrite.Clear()
 	for scanner.Scan() {
 		line := scanner.Text()
 		if debug {
@@ -473,6 +985,7 @@ func (s *IPSets) tryResync() (err error) {
 			meta := dataplaneMetadata{
 				Type: ipSetType,
 			}
+			hashSize, listSetSize := 0, 0
 			for idx, p := range parts {
 				if p == "maxelem" {
 					if idx+1 >= len(parts) {
@@ -487,7 +1000,37 @@ func (s *IPSets) tryResync() (err error) {
 						break
 					}
 					meta.MaxSize = maxElem
-					break
+					continue
+				}
+				if p == "hashsize" {
+					if idx+1 >= len(parts) {
+						log.WithField("line", line).Error(
+							"Failed to parse ipset list Header line, nothing after 'hashsize'.")
+						break
+					}
+					hs, err := strconv.Atoi(parts[idx+1])
+					if err != nil {
+						log.WithError(err).WithField("line", line).Error(
+							"Failed to parse ipset list Header line.")
+						break
+					}
+					hashSize = hs
+					continue
+				}
+				if p == "size" && ipSetType == IPSetTypeListSet {
+					if idx+1 >= len(parts) {
+						log.WithField("line", line).Error(
+							"Failed to parse ipset list Header line, nothing after 'size'.")
+						break
+					}
+					size, err := strconv.Atoi(parts[idx+1])
+					if err != nil {
+						log.WithError(err).WithField("line", line).Error(
+							"Failed to parse ipset list Header line.")
+						break
+					}
+					listSetSize = size
+					continue
 				}
 				if p == "range" {
 					if idx+1 >= len(parts) {
@@ -504,8 +1047,31 @@ func (s *IPSets) tryResync() (err error) {
 					}
 					meta.RangeMin = rMin
 					meta.RangeMax = rMAx
-					break
+					continue
+				}
+				if p == "timeout" {
+					if idx+1 >= len(parts) {
+						log.WithField("line", line).Error(
+							"Failed to parse ipset list Header line, nothing after 'timeout'.")
+						break
+					}
+					timeout, err := strconv.Atoi(parts[idx+1])
+					if err != nil {
+						log.WithError(err).WithField("line", line).Error(
+							"Failed to parse ipset list Header line.")
+						break
+					}
+					meta.Timeout = timeout
+					continue
 				}
+				if p == "counters" {
+					meta.Counters = true
+					continue
+				}
+			}
+			meta.HashSize = s.listedHashSize(ipSetName, hashSize)
+			if ipSetType == IPSetTypeListSet {
+				meta.MaxSize = s.listedListSetSize(ipSetName, listSetSize)
 			}
 			s.setNameToProgrammedMetadata.Dataplane().Set(ipSetName, meta)
 		}
@@ -541,6 +1107,8 @@ func (s *IPSets) tryResync() (err error) {
 			logCxt := s.logCxt.WithField("setName", ipSetName)
 			memberTracker := s.getOrCreateMemberTracker(ipSetName)
 			numExtrasExpected := memberTracker.PendingDeletions().Len()
+			needsRewrite := false
+			dpMeta, _ := s.setNameToProgrammedMetadata.Dataplane().Get(ipSetName)
 			err = memberTracker.Dataplane().ReplaceFromIter(func(f func(k IPSetMember)) error {
 				for scanner.Scan() {
 					line := scanner.Text()
@@ -550,7 +1118,20 @@ func (s *IPSets) tryResync() (err error) {
 					}
 					var canonMember IPSetMember
 					if ipSetType.IsValid() {
-						canonMember = ipSetType.CanonicaliseMember(line)
+						// Ignore any extensions that we don't manage, such as timeouts and
+						// comments, so that they don't cause spurious differences.
+						member, otherExts := canonicaliseListedMember(ipSetType, line)
+						if len(otherExts) > 0 && !needsRewrite {
+							logCxt.WithFields(log.Fields{
+								"member":     line,
+								"extensions": otherExts,
+							}).Info("Found member with unexpected extensions in dataplane, will rewrite IP set.")
+							needsRewrite = true
+						}
+						canonMember = member
+						if dpMeta.Timeout > 0 {
+							s.recordListedTimeout(ipSetName, canonMember, line)
+						}
 					} else {
 						// Unknown type found in dataplane, record it as
 						// a raw string.  Then we'll clean up the IP set
@@ -571,6 +1152,9 @@ func (s *IPSets) tryResync() (err error) {
 				logCxt.WithError(err).Error("Failed to read members from 'ipset list'.")
 				break
 			}
+			if needsRewrite {
+				s.ipSetsNeedingRewrite.Add(ipSetName)
+			}
 
 			if numMissing := memberTracker.PendingUpdates().Len(); numMissing > 0 {
 				logCxt.WithField("numMissing", numMissing).Info(
@@ -605,6 +1189,8 @@ func (s *IPSets) tryResync() (err error) {
 		return
 	}
 
+	s.reportOrphanedIPSets()
+
 	// Mark any IP sets that we didn't see as empty.
 	for name, members := range s.mainSetNameToMembers {
 		if _, ok := s.setNameToProgrammedMetadata.Dataplane().Get(name); ok {
@@ -649,8 +1235,16 @@ func ParseRange(s string) (min int, max int, err error) {
 // 'iptables-restore', 'ipset restore' is not atomic, updates are applied individually.
 // This function updates the set of programmed IPs - that is the IPs that were added or replaced in the IPSets
 // included by the ipsetFilter.
-func (s *IPSets) tryUpdates() error {
+//
+// If oneByOne is set, for example because a previous attempt failed, each IP set is instead
+// updated by its own 'ipset restore' so that a problem with one IP set doesn't prevent the others
+// from being updated.
+func (s *IPSets) tryUpdates(oneByOne bool) error {
 	var dirtyIPSets []string
+	s.ipSetsWithDirtyMembers.Iter(func(setName string) error {
+		s.maybeGrowMaxSize(setName)
+		return nil
+	})
 	s.ipSetsWithDirtyMembers.Iter(func(setName string) error {
 		if _, ok := s.setNameToProgrammedMetadata.Desired().Get(setName); !ok {
 			// Skip deletions and IP sets that aren't needed due to the filter.
@@ -670,23 +1264,90 @@ func (s *IPSets) tryUpdates() error {
 		return nil
 	}
 	s.opReporter.RecordOperation(fmt.Sprint("update-ipsets-", s.IPVersionConfig.Family.Version()))
+	// Sort so that the same updates always produce the same input.
+	sort.Strings(dirtyIPSets)
+	s.orderListSetsLast(dirtyIPSets)
+
+	if !oneByOne || len(dirtyIPSets) == 1 {
+		if err := s.tryRestore(dirtyIPSets); err != nil {
+			return err
+		}
+		// If we get here, the writes were successful, reset the IP sets delta tracking now the
+		// dataplane should be in sync.
+		s.ipSetsWithDirtyMembers.Clear()
+		return nil
+	}
+
+	s.logCxt.WithField("numIPSets", len(dirtyIPSets)).Info("Updating IP sets one at a time.")
+	var firstErr error
+	numFailed := 0
+	for _, setName := range dirtyIPSets {
+		if err := s.tryRestore([]string{setName}); err != nil {
+			// Our tracking for this IP set is now suspect but that only affects this IP set;
+			// carry on with the others and let the resync that our caller does fix it up.
+			s.logCxt.WithError(err).WithField("setName", setName).Warning("Failed to update IP set.")
+			if firstErr == nil {
+				firstErr = err
+			}
+			numFailed++
+			continue
+		}
+		s.ipSetsWithDirtyMembers.Discard(setName)
+	}
+	if firstErr != nil {
+		return fmt.Errorf("failed to update %d of %d IP sets: %w", numFailed, len(dirtyIPSets), firstErr)
+	}
+	return nil
+}
+
+// tryRestore writes the updates for the given IP sets and applies them with a single 'ipset
+// restore'.
+func (s *IPSets) tryRestore(setNames []string) error {
+	for setName := range s.createdIPSets {
+		delete(s.createdIPSets, setName)
+	}
+	s.loadCountersForRewrite(setNames)
 
 	start := time.Now()
+
+	// Generate the whole input up front so that we know its size before we execute the restore.
+	// We also need a copy of the input to dump to the log on failure.
+	defer s.restoreInCopy.Reset()
+	s.pendingApplySummaries = s.pendingApplySummaries[:0]
+	for _, setName := range setNames {
+		// Ask IP set to write its updates to the buffer.  Writes to a bytes.Buffer can't fail.
+		if log.IsLevelEnabled(log.DebugLevel) {
+			log.WithField("setName", setName).Debug("Writing updates to IP set.")
+		}
+		if s.shadowGenerator == nil {
+			_ = s.writeUpdates(setName, &s.restoreInCopy)
+		} else {
+			// Capture the state before writeUpdates updates our tracking, and the lines that
+			// it writes, so that we can compare them with the shadow generator's output.
+			shadowIn := s.shadowInputFor(setName)
+			var setLines bytes.Buffer
+			_ = s.writeUpdates(setName, io.MultiWriter(&s.restoreInCopy, &setLines))
+			s.compareWithShadow(shadowIn, setLines.Bytes())
+		}
+		_ = s.writeSetCommit(&s.restoreInCopy)
+	}
+	_ = s.writeFinalCommit(&s.restoreInCopy)
+	s.recordRestoreInputSize(s.restoreInCopy.Bytes())
+
+	if s.dryRun {
+		s.logDryRun("ipset restore", len(setNames), s.restoreInCopy.String())
+		return nil
+	}
 	// Set up an ipset restore session.
 	countNumIPSetCalls.Inc()
 	cmd := s.newCmd("ipset", "restore")
 	// Get the pipe for stdin.
-	rawStdin, err := cmd.StdinPipe()
+	stdin, err := cmd.StdinPipe()
 	if err != nil {
 		s.logCxt.WithError(err).Error("Failed to create pipe for ipset restore.")
 		return err
 	}
 
-	// "Tee" the data that we write to stdin to a buffer so we can dump it to the log on
-	// failure.
-	stdin := io.MultiWriter(&s.restoreInCopy, rawStdin)
-	defer s.restoreInCopy.Reset()
-
 	// Channel stdout/err to buffers so we can include them in the log on failure.
 	cmd.SetStderr(&s.stderrCopy)
 	defer s.stderrCopy.Reset()
@@ -698,39 +1359,27 @@ func (s *IPSets) tryUpdates() error {
 	err = cmd.Start()
 	if err != nil {
 		s.logCxt.WithError(err).Error("Failed to start ipset restore.")
-		closeErr := rawStdin.Close()
+		closeErr := stdin.Close()
 		if closeErr != nil {
 			s.logCxt.WithError(closeErr).Error(
 				"Error closing stdin while handling start error")
 		}
-		return err
+		return wrapIfCommandMissing(err)
 	}
 	summaryExecStart.Observe(float64(time.Since(startTime).Nanoseconds()) / 1000.0)
 
-	// Ask each dirty IP set to write its updates to the stream.
-	var writeErr error
-	for _, setName := range dirtyIPSets {
-		// Ask IP set to write its updates to the stream.
-		if log.IsLevelEnabled(log.DebugLevel) {
-			log.WithField("setName", setName).Debug("Writing updates to IP set.")
-		}
-		writeErr = s.writeUpdates(setName, stdin)
-		if writeErr != nil {
-			break
-		}
+	// Send the input, then flush and close the pipe, or the command won't terminate.  We need
+	// to close and wait whether we hit a write error or not so we defer the error handling.
+	_, writeErr := stdin.Write(s.restoreInCopy.Bytes())
+	if writeErr != nil {
+		s.logCxt.WithError(writeErr).Error("Failed to write to ipset restore")
 	}
-
-	// Finish off the input, then flush and close the input, or the command won't terminate.
-	// We need to close and wait whether we hit a write error or not so we defer the error
-	// handling.
-	_, commitErr := stdin.Write([]byte("COMMIT\n"))
-	flushErr := rawStdin.Flush()
-	closeErr := rawStdin.Close()
+	flushErr := stdin.Flush()
+	closeErr := stdin.Close()
 	processErr := cmd.Wait()
-	if err = firstNonNilErr(writeErr, commitErr, flushErr, closeErr, processErr); err != nil {
+	if err = firstNonNilErr(writeErr, flushErr, closeErr, processErr); err != nil {
 		s.logCxt.WithFields(log.Fields{
 			"writeErr":   writeErr,
-			"commitErr":  commitErr,
 			"flushErr":   flushErr,
 			"closeErr":   closeErr,
 			"processErr": processErr,
@@ -740,11 +1389,13 @@ func (s *IPSets) tryUpdates() error {
 		}).Warning("Failed to complete ipset restore, IP sets may be out-of-sync.")
 		return fmt.Errorf("failed to write one or more IP set: %v", err)
 	}
-	log.Debugf("Updated %d IPSets in %v", len(dirtyIPSets), time.Since(start))
+	log.Debugf("Updated %d IPSets in %v", len(setNames), time.Since(start))
+	s.logApplySummaries()
 
-	// If we get here, the writes were successful, reset the IP sets delta tracking now the
-	// dataplane should be in sync.
-	s.ipSetsWithDirtyMembers.Clear()
+	// Now the creates have been committed, check that the kernel honoured the maxelem.
+	for setName, requested := range s.createdIPSets {
+		s.verifyIPSetMaxElem(setName, requested)
+	}
 
 	return nil
 }
@@ -766,7 +1417,7 @@ func (s *IPSets) writeUpdates(setName string, w io.Writer) (err error) {
 
 	// If the metadata needs to change then we have to write to a temporary IP
 	// set and swap it into place.
-	needTempIPSet := dpExists && dpMeta != desiredMeta
+	needTempIPSet := dpExists && (dpMeta != desiredMeta || s.ipSetsNeedingRewrite.Contains(setName))
 	// If the IP set doesn't exist yet, we need to create it.
 	needCreate := !dpExists
 
@@ -790,9 +1441,16 @@ func (s *IPSets) writeUpdates(setName string, w io.Writer) (err error) {
 	}
 
 	var targetSet, tempSet string
+	var netAdded, netRemoved int
 	if needTempIPSet {
-		tempSet = s.nextFreeTempIPSetName()
+		tempSet = s.nextFreeTempIPSetName(setName)
 		targetSet = tempSet
+		// Record the temp IP set before we create it so that, if the restore fails part way,
+		// a resync doesn't mistake it for a left-over.
+		s.ourTempIPSets.Add(tempSet)
+		// Every member is written to the temp IP set but, for the summary, we want the net
+		// change to the main IP set.
+		netAdded, netRemoved = members.PendingUpdates().Len(), members.PendingDeletions().Len()
 		// Temp IP set is empty.
 		members.Dataplane().DeleteAll()
 	} else {
@@ -803,47 +1461,79 @@ func (s *IPSets) writeUpdates(setName string, w io.Writer) (err error) {
 
 		switch desiredMeta.Type {
 		case IPSetTypeBitmapPort:
-			writeLine("create %s %s range %d-%d",
-				targetSet, desiredMeta.Type, desiredMeta.RangeMin, desiredMeta.RangeMax)
+			writeLine("create %s %s range %d-%d%s%s",
+				targetSet, desiredMeta.Type, desiredMeta.RangeMin, desiredMeta.RangeMax, timeoutArg(desiredMeta.Timeout),
+				countersArg(desiredMeta.Counters))
+		case IPSetTypeListSet:
+			writeLine("create %s %s%s%s%s",
+				targetSet, desiredMeta.Type, listSetSizeArg(desiredMeta.MaxSize), timeoutArg(desiredMeta.Timeout),
+				countersArg(desiredMeta.Counters))
 		default:
-			writeLine("create %s %s family %s maxelem %d",
-				targetSet, desiredMeta.Type, s.IPVersionConfig.Family, desiredMeta.MaxSize)
+			writeLine("create %s %s family %s%s maxelem %d%s%s",
+				targetSet, desiredMeta.Type, s.IPVersionConfig.Family, hashSizeArg(desiredMeta.HashSize),
+				desiredMeta.MaxSize, timeoutArg(desiredMeta.Timeout), countersArg(desiredMeta.Counters))
+			if s.verifyMaxElem && !s.dryRun {
+				// The new IP set will end up as the main IP set, even if we swap it in.
+				s.createdIPSets[setName] = desiredMeta.MaxSize
+			}
 		}
 
 	}
 	if err != nil {
 		return
 	}
-	members.PendingDeletions().Iter(func(member IPSetMember) deltatracker.IterAction {
-		writeLine("del %s %s --exist", targetSet, member)
+	summary := ipSetApplySummary{setName: setName, mode: applyModeDelta}
+	if needCreate {
+		summary.mode = applyModeCreate
+	} else if needTempIPSet {
+		summary.mode = applyModeRewrite
+	}
+	// Write the deletions and additions in a deterministic order so that the same state always
+	// produces the same restore input.
+	for _, member := range sortedPendingMembers(members.PendingDeletions().Iter) {
+		writeLine("del %s %s %s", targetSet, renderMemberForDel(desiredMeta.Type, member), s.existFlag())
 		if err != nil {
 			// Note, just exiting early here to save a load of no-ops.
 			// If we exit with an error, the dataplane state will be resynced.
-			return deltatracker.IterActionNoOpStopIteration
+			break
 		}
-		return deltatracker.IterActionUpdateDataplane
-	})
-	members.PendingUpdates().Iter(func(member IPSetMember) deltatracker.IterAction {
-		memberStr := member.String()
-		writeLine("add %s %s", targetSet, memberStr)
+		members.Dataplane().Delete(member)
+		summary.removed++
+	}
+	for _, member := range sortedPendingMembers(members.PendingUpdates().Iter) {
+		if !s.memberMatchesFamily(desiredMeta.Type, member) {
+			// Members are filtered by family when they're added so this should be impossible
+			// but a single bad member would fail the whole restore, so we check again here.
+			logCxt.WithFields(log.Fields{
+				"member": member.String(),
+				"family": s.IPVersionConfig.Family,
+			}).Error("Bug: IP set member has the wrong IP family, skipping it.")
+			members.Desired().Delete(member)
+			continue
+		}
+		writeLine("add %s %s%s%s", targetSet, desiredMeta.Type.RenderMember(member),
+			s.carriedCounterArgs(setName, member), s.memberTimeoutArgs(setName, desiredMeta, member))
 		if err != nil {
-			// Note, just exiting early here to save a load of no-ops.
-			// If we exit with an error, the dataplane state will be resynced.
-			return deltatracker.IterActionNoOpStopIteration
+			break
 		}
-		return deltatracker.IterActionUpdateDataplane
-	})
+		members.Dataplane().Add(member)
+		s.recordMemberWritten(setName, desiredMeta, member)
+		summary.added++
+	}
 	if needTempIPSet {
 		writeLine("swap %s %s", setName, targetSet)
+		summary.added, summary.removed = netAdded, netRemoved
 	}
 	if err != nil {
 		return
 	}
+	s.recordApplySummary(summary)
 
 	if needCreate || needTempIPSet {
 		if needTempIPSet {
 			// After the swap, the temp IP set has the _old_ dataplane metadata.
 			s.setNameToProgrammedMetadata.Dataplane().Set(tempSet, dpMeta)
+			s.ipSetsNeedingRewrite.Discard(setName)
 		}
 		// The main IP set now has the correct metadata.
 		s.setNameToProgrammedMetadata.Dataplane().Set(setName, desiredMeta)
@@ -851,13 +1541,51 @@ func (s *IPSets) writeUpdates(setName string, w io.Writer) (err error) {
 	return
 }
 
+// verifyIPSetMaxElem reads back the maxelem of the given IP set from the kernel and records it.
+// If it differs from the maxelem that we requested, the kernel must have capped it, so we log
+// a warning.
+func (s *IPSets) verifyIPSetMaxElem(setName string, requested int) {
+	logCxt := s.logCxt.WithFields(log.Fields{
+		"setName":          setName,
+		"requestedMaxElem": requested,
+	})
+	listing, err := s.listIPSet(setName)
+	if err != nil {
+		logCxt.WithError(err).Warn("Failed to read back IP set to verify its maxelem.")
+		return
+	}
+	actual, err := listing.maxElem()
+	if err != nil {
+		logCxt.WithError(err).Warn("Failed to parse maxelem of IP set.")
+		return
+	}
+	s.setNameToEffectiveMaxSize[setName] = actual
+	if actual != requested {
+		countNumIPSetMaxElemMismatches.Inc()
+		logCxt.WithField("actualMaxElem", actual).Warn(
+			"Kernel created IP set with a different maxelem to the one requested; IP set may fill up.")
+	}
+}
+
+// EffectiveMaxSize returns the maxelem that the kernel reported when we last created the given
+// IP set.  Only available if maxelem verification is enabled.
+func (s *IPSets) EffectiveMaxSize(setID string) (int, bool) {
+	maxSize, ok := s.setNameToEffectiveMaxSize[s.nameForMainIPSet(setID)]
+	return maxSize, ok
+}
+
 // nextFreeTempIPSetName picks a name for a temporary IP set avoiding any that
 // appear to be in use already. Giving each temporary IP set a new name works
 // around the fact that we sometimes see transient failures to remove
 // temporary IP sets.
-func (s *IPSets) nextFreeTempIPSetName() string {
+func (s *IPSets) nextFreeTempIPSetName(mainSetName string) string {
 	for {
-		candidateName := s.IPVersionConfig.NameForTempIPSet(s.nextTempIPSetIdx)
+		var candidateName string
+		if s.tempNameEpoch != "" {
+			candidateName = s.IPVersionConfig.NameForDebugTempIPSet(s.nextTempIPSetIdx, s.tempNameEpoch, mainSetName)
+		} else {
+			candidateName = s.IPVersionConfig.NameForTempIPSet(s.nextTempIPSetIdx)
+		}
 		s.nextTempIPSetIdx++
 		if _, ok := s.setNameToProgrammedMetadata.Dataplane().Get(candidateName); ok {
 			log.WithField("candidate", candidateName).Warning(
@@ -871,7 +1599,48 @@ func (s *IPSets) nextFreeTempIPSetName() string {
 // ApplyDeletions tries to delete any IP sets that are no longer needed.
 // Failures are ignored, deletions will be retried the next time we do a resync.
 func (s *IPSets) ApplyDeletions() bool {
+	numDeletions, err := s.ApplyDeletionsChecked()
+	if err != nil {
+		s.logCxt.WithError(err).Debug("Some IP set deletions failed, will retry after the next resync.")
+	}
+	if numDeletions == 0 {
+		// We had nothing to delete, or we only encountered errors, don't
+		// ask to be rescheduled.
+		return false
+	}
+	// Reschedule if we have sets left to delete.
+	return s.setNameToProgrammedMetadata.Dataplane().Len() > 0
+}
+
+// ApplyDeletionsChecked is like ApplyDeletions() but, rather than whether to reschedule, it
+// returns the number of IP sets that it deleted and an error if any of the deletions failed, so
+// that the caller can log or alert on them.  As for ApplyDeletions(), failed deletions are
+// retried after the next resync.  If the ipset binary isn't installed, it returns
+// ErrIPSetCommandMissing without trying to delete anything.
+func (s *IPSets) ApplyDeletionsChecked() (numDeletions int, err error) {
+	if s.suspended {
+		s.logCxt.Debug("IP set updates suspended, skipping deletions.")
+		return 0, nil
+	}
+	if err := s.maybeDetectRestoreFlavor(); err != nil {
+		s.reportCommandMissing(err)
+		return 0, err
+	}
+	s.pruneDeleteFailures()
+	if s.deletionBatchSize > 0 {
+		numDeletions, err = s.applyBatchedDeletions()
+	} else {
+		numDeletions, err = s.applyDeletionsOneByOne()
+	}
+	// ApplyDeletions() marks the end of the two-phase "apply". Piggyback on that to
+	// update the gauge that records how many IP sets we own.
+	s.gaugeNumIpsets.Set(float64(s.setNameToProgrammedMetadata.Dataplane().Len()))
+	return numDeletions, err
+}
+
+func (s *IPSets) applyDeletionsOneByOne() (int, error) {
 	numDeletions := 0
+	var failures deletionFailures
 	s.setNameToProgrammedMetadata.PendingDeletions().Iter(func(setName string) deltatracker.IterAction {
 		if numDeletions >= MaxIPSetDeletionsPerIteration {
 			// Deleting IP sets is slow (40ms) and serialised in the kernel.  Avoid holding up the main loop
@@ -881,46 +1650,209 @@ func (s *IPSets) ApplyDeletions() bool {
 			return deltatracker.IterActionNoOpStopIteration
 		}
 		meta, _ := s.setNameToProgrammedMetadata.Dataplane().Get(setName)
-		if meta.DeleteFailed {
+		if meta.DeleteFailed || s.deletionAbandoned(setName) {
 			// We previously failed to delete this IP set, skip it until
-			// the next resync.
+			// the next resync (or for good, if we've given up on it).
 			return deltatracker.IterActionNoOp
 		}
 		logCxt := s.logCxt.WithField("setName", setName)
+		s.maybeReportLeftoverIPSet(setName)
 		logCxt.Info("Deleting IP set.")
 		if err := s.deleteIPSet(setName); err != nil {
 			// Note: we used to set the resyncRequired flag on this path but that can lead to excessive retries if
 			// the problem isn't something that we can fix (for example an external app has made a reference to
 			// our IP set).  Instead, wait for the next timed resync.
-			logCxt.WithError(err).Warning("Failed to delete IP set. Will retry on next resync.")
-			meta.DeleteFailed = true
-			s.setNameToProgrammedMetadata.Dataplane().Set(setName, meta)
+			logCxt.WithError(err).Warning("Failed to delete IP set.")
+			s.recordDeleteFailure(setName)
+			failures.add(err)
 			return deltatracker.IterActionNoOp
 		}
 		numDeletions++
-		if _, ok := s.setNameToAllMetadata[setName]; !ok {
-			// IP set is not just filtered out, clean up the members cache.
-			logCxt.Debug("IP set now gone from dataplane, removing from members tracker.")
-			delete(s.mainSetNameToMembers, setName)
-		} else {
-			// We're still tracking this IP set in case it needs to be recreated.
-			// Record that the dataplane is now empty.
-			logCxt.Debug("IP set now gone from dataplane but still " +
-				"tracking its members (it is filtered out).")
-			s.mainSetNameToMembers[setName].Dataplane().DeleteAll()
-		}
+		s.cleanUpDeletedIPSet(setName)
 		return deltatracker.IterActionUpdateDataplane
 	})
-	// ApplyDeletions() marks the end of the two-phase "apply". Piggyback on that to
-	// update the gauge that records how many IP sets we own.
-	numDeletionsPending := s.setNameToProgrammedMetadata.Dataplane().Len()
-	s.gaugeNumIpsets.Set(float64(numDeletionsPending))
-	if numDeletions == 0 {
-		// We had nothing to delete, or we only encountered errors, don't
-		// ask to be rescheduled.
+	return numDeletions, failures.err()
+}
+
+// applyBatchedDeletions destroys up to deletionBatchSize IP sets using a single ipset restore,
+// falling back to destroying them one at a time if the restore fails.
+func (s *IPSets) applyBatchedDeletions() (int, error) {
+	var batch []string
+	s.setNameToProgrammedMetadata.PendingDeletions().Iter(func(setName string) deltatracker.IterAction {
+		if len(batch) >= s.deletionBatchSize {
+			log.Debugf("Reached batch of %d IP set deletions, rate limiting further IP set deletions.", s.deletionBatchSize)
+			return deltatracker.IterActionNoOpStopIteration
+		}
+		meta, _ := s.setNameToProgrammedMetadata.Dataplane().Get(setName)
+		if meta.DeleteFailed || s.deletionAbandoned(setName) {
+			// We previously failed to delete this IP set, skip it until
+			// the next resync (or for good, if we've given up on it).
+			return deltatracker.IterActionNoOp
+		}
+		s.maybeReportLeftoverIPSet(setName)
+		batch = append(batch, setName)
+		return deltatracker.IterActionNoOp
+	})
+	if len(batch) == 0 {
+		return 0, nil
+	}
+
+	var deleted []string
+	var failures deletionFailures
+	if err := s.deleteIPSetsWithRestore(batch); err == nil {
+		deleted = batch
+	} else {
+		// The restore stops at the first failure so some of the IP sets may already be gone;
+		// treat those as deleted when we retry them individually.
+		s.logCxt.WithError(err).Warning("Failed to delete batch of IP sets, falling back to deleting them one by one.")
+		for _, setName := range batch {
+			logCxt := s.logCxt.WithField("setName", setName)
+			if err := s.deleteIPSet(setName); err != nil && !errors.Is(err, errIPSetDoesNotExist) {
+				logCxt.WithError(err).Warning("Failed to delete IP set.")
+				s.recordDeleteFailure(setName)
+				failures.add(err)
+				continue
+			}
+			deleted = append(deleted, setName)
+		}
+	}
+
+	for _, setName := range deleted {
+		s.setNameToProgrammedMetadata.Dataplane().Delete(setName)
+		s.cleanUpDeletedIPSet(setName)
+	}
+	return len(deleted), failures.err()
+}
+
+// deletionFailures accumulates the errors from a round of IP set deletions.
+type deletionFailures struct {
+	num      int
+	firstErr error
+}
+
+func (f *deletionFailures) add(err error) {
+	if f.firstErr == nil {
+		f.firstErr = err
+	}
+	f.num++
+}
+
+// err returns an error summarising the failures, or nil if there were none.
+func (f *deletionFailures) err() error {
+	if f.num == 0 {
+		return nil
+	}
+	return fmt.Errorf("failed to delete %d IP set(s): %w", f.num, f.firstErr)
+}
+
+// deleteIPSetsWithRestore destroys the given IP sets using a single ipset restore.
+func (s *IPSets) deleteIPSetsWithRestore(setNames []string) error {
+	var input bytes.Buffer
+	for _, setName := range setNames {
+		input.WriteString("destroy ")
+		input.WriteString(setName)
+		input.WriteString("\n")
+	}
+	_ = s.writeCommit(&input)
+
+	s.logCxt.WithField("numIPSets", len(setNames)).Info("Deleting batch of IP sets.")
+	if s.dryRun {
+		s.logDryRun("ipset restore", len(setNames), input.String())
+		return nil
+	}
+	countNumIPSetCalls.Inc()
+	cmd := s.newCmd("ipset", "restore")
+	cmd.SetStdin(&input)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		s.logCxt.WithError(err).WithFields(log.Fields{
+			"setNames": setNames,
+			"output":   string(output),
+		}).Warn("Failed to delete batch of IP sets.")
+		return err
+	}
+	s.logCxt.WithField("setNames", setNames).Info("Deleted batch of IP sets")
+	return nil
+}
+
+// maybeReportLeftoverIPSet logs and calls the left-over callback (if any) if the given IP set is
+// one that we don't recognise.
+func (s *IPSets) maybeReportLeftoverIPSet(setName string) {
+	var reason string
+	switch {
+	case s.IPVersionConfig.IsTempIPSetName(setName):
+		if s.ourTempIPSets.Contains(setName) {
+			return
+		}
+		reason = LeftoverReasonTempIPSet
+	case s.isLeftoverIPSet(setName):
+		reason = LeftoverReasonNotExpected
+	default:
+		return
+	}
+	s.logCxt.WithFields(log.Fields{
+		"setName": setName,
+		"reason":  reason,
+	}).Info("Removing left-over IP set.")
+	if s.onLeftoverRemoved != nil {
+		s.onLeftoverRemoved(setName, reason)
+	}
+}
+
+// cleanUpDeletedIPSet updates our member tracking after the given IP set has been deleted from
+// the dataplane.
+func (s *IPSets) cleanUpDeletedIPSet(setName string) {
+	logCxt := s.logCxt.WithField("setName", setName)
+	s.expectedDeletions.Discard(setName)
+	s.ourTempIPSets.Discard(setName)
+	delete(s.setNameToEffectiveMaxSize, setName)
+	if _, ok := s.setNameToAllMetadata[setName]; !ok {
+		// IP set is not just filtered out, clean up the members cache.
+		logCxt.Debug("IP set now gone from dataplane, removing from members tracker.")
+		delete(s.mainSetNameToMembers, setName)
+	} else {
+		// We're still tracking this IP set in case it needs to be recreated.
+		// Record that the dataplane is now empty.
+		logCxt.Debug("IP set now gone from dataplane but still " +
+			"tracking its members (it is filtered out).")
+		s.mainSetNameToMembers[setName].Dataplane().DeleteAll()
+	}
+}
+
+// reportOrphanedIPSets counts the Calico IP sets that the resync found in the dataplane but that
+// we don't expect, before they get cleaned up.  Left-over temporary IP sets indicate that we
+// crashed while rewriting an IP set; left-over main IP sets that we crashed (or were restarted
+// with a different configuration) before deleting an IP set.
+func (s *IPSets) reportOrphanedIPSets() {
+	numTemp, numMain := 0, 0
+	s.setNameToProgrammedMetadata.Dataplane().Iter(func(setName string, _ dataplaneMetadata) {
+		if s.IPVersionConfig.IsTempIPSetName(setName) {
+			numTemp++
+		} else if s.isLeftoverIPSet(setName) {
+			numMain++
+		}
+	})
+	s.gaugeNumTempOrphans.Set(float64(numTemp))
+	s.gaugeNumMainOrphans.Set(float64(numMain))
+	if numTemp == 0 && numMain == 0 {
+		return
+	}
+	s.logCxt.WithFields(log.Fields{
+		"numTempOrphans": numTemp,
+		"numMainOrphans": numMain,
+	}).Info("Resync found left-over IP sets in dataplane.")
+}
+
+// isLeftoverIPSet returns true if the given (main) IP set is one that we found in the dataplane
+// but that we don't recognise; i.e. it is neither one that we're tracking (perhaps filtered
+// out) nor one that we've been asked to remove.
+func (s *IPSets) isLeftoverIPSet(setName string) bool {
+	if s.IPVersionConfig.IsTempIPSetName(setName) {
 		return false
 	}
-	return numDeletionsPending > 0 // Reschedule if we have sets left to delete.
+	if _, ok := s.setNameToAllMetadata[setName]; ok {
+		return false
+	}
+	return !s.expectedDeletions.Contains(setName)
 }
 
 func (s *IPSets) tryTempIPSetDeletions() {
@@ -937,15 +1869,17 @@ func (s *IPSets) tryTempIPSetDeletions() {
 			return deltatracker.IterActionNoOp
 		}
 		meta, _ := s.setNameToProgrammedMetadata.Dataplane().Get(setName)
-		if meta.DeleteFailed {
+		if meta.DeleteFailed || s.deletionAbandoned(setName) {
 			return deltatracker.IterActionNoOp
 		}
 		logCxt := s.logCxt.WithField("setName", setName)
+		s.maybeReportLeftoverIPSet(setName)
 		logCxt.Info("Deleting IP set.")
 		if err := s.deleteIPSet(setName); err != nil {
 			logCxt.WithError(err).Warning("Failed to delete temp IP set. Will retry...")
 			return deltatracker.IterActionNoOp
 		}
+		s.ourTempIPSets.Discard(setName)
 		numDeletions++
 		return deltatracker.IterActionUpdateDataplane
 	})
@@ -953,12 +1887,19 @@ func (s *IPSets) tryTempIPSetDeletions() {
 
 func (s *IPSets) deleteIPSet(setName string) error {
 	s.logCxt.WithField("setName", setName).Info("Deleting IP set.")
+	if s.dryRun {
+		s.logDryRun("ipset destroy "+setName, 1, "")
+		return nil
+	}
 	cmd := s.newCmd("ipset", "destroy", string(setName))
 	if output, err := cmd.CombinedOutput(); err != nil {
 		s.logCxt.WithError(err).WithFields(log.Fields{
 			"setName": setName,
 			"output":  string(output),
 		}).Warn("Failed to delete IP set, may be out-of-sync.")
+		if strings.Contains(string(output), "does not exist") {
+			return fmt.Errorf("%w: %v", errIPSetDoesNotExist, err)
+		}
 		return err
 	}
 	s.logCxt.WithField("setName", setName).Info("Deleted IP set")
@@ -995,7 +1936,7 @@ func (s *IPSets) updateDirtiness(name string) {
 		s.ipSetsWithDirtyMembers.Discard(name)
 		return
 	}
-	if memberTracker.InSync() {
+	if memberTracker.InSync() && !s.ipSetsNeedingRewrite.Contains(name) {
 		s.ipSetsWithDirtyMembers.Discard(name)
 	} else {
 		s.ipSetsWithDirtyMembers.Add(name)
@@ -1020,7 +1961,7 @@ func (s *IPSets) SetFilter(ipSetNames set.Set[string]) {
 }
 
 func (s *IPSets) ipSetNeeded(name string) bool {
-	if s.neededIPSetNames == nil {
+	if s.neededIPSetNames == nil || s.isCanaryIPSet(name) {
 		// We're not filtering down to a "needed" set, so all IP sets are needed.
 		return true
 	}
@@ -15,12 +15,16 @@
 package ipsets_test
 
 import (
+	"errors"
 	"fmt"
 	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	logrustest "github.com/sirupsen/logrus/hooks/test"
 
 	"github.com/projectcalico/calico/felix/ip"
 	. "github.com/projectcalico/calico/felix/ipsets"
@@ -50,11 +54,12 @@ var (
 )
 
 var exampleMembersByType = map[IPSetType][]string{
-	IPSetTypeHashIP:     {"10.0.0.1", "10.0.0.2", "10.0.1.0"},
-	IPSetTypeHashIPPort: {"10.0.0.1,tcp:8080", "10.0.0.1,tcp:8081", "10.0.0.2,udp:1234"},
-	IPSetTypeHashNet:    {"10.0.0.0/24", "10.0.1.0/24", "10.0.2.0/25"},
-	IPSetTypeBitmapPort: {"8080", "80", "443"},
-	IPSetTypeHashNetNet: {"10.0.0.0/24,10.0.0.1/32", "10.0.1.0/24,10.0.0.2/32", "10.0.2.0/25,10.0.0.3/32"},
+	IPSetTypeHashIP:      {"10.0.0.1", "10.0.0.2", "10.0.1.0"},
+	IPSetTypeHashIPPort:  {"10.0.0.1,tcp:8080", "10.0.0.1,tcp:8081", "10.0.0.2,udp:1234"},
+	IPSetTypeHashNet:     {"10.0.0.0/24", "10.0.1.0/24", "10.0.2.0/25"},
+	IPSetTypeBitmapPort:  {"8080", "80", "443"},
+	IPSetTypeHashNetNet:  {"10.0.0.0/24,10.0.0.1/32", "10.0.1.0/24,10.0.0.2/32", "10.0.2.0/25,10.0.0.3/32"},
+	IPSetTypeHashNetPort: {"10.0.0.0/24,tcp:443", "10.0.1.0/24,udp:53", "10.0.2.1/32,tcp:80"},
 }
 
 var _ = Describe("IPSetType", func() {
@@ -248,8 +253,9 @@ var _ = Describe("IP sets dataplane", func() {
 		"cali",
 		rules.AllHistoricIPSetNamePrefixes,
 		rules.LegacyV4IPSetNames,
+		nil,
 	)
-	// v6VersionConf := NewIPVersionConfig(IPFamilyV6, "cali", nil, nil)
+	// v6VersionConf := NewIPVersionConfig(IPFamilyV6, "cali", nil, nil, nil)
 
 	reschedRequested := false
 	apply := func() {
@@ -355,9 +361,393 @@ var _ = Describe("IP sets dataplane", func() {
 			// It shouldn't try to double-delete the temp IP set.
 			Expect(dataplane.TriedToDeleteNonExistent).To(BeFalse())
 		})
+
+		Describe("with a left-over IP set callback", func() {
+			var removedLeftovers map[string]string
+
+			BeforeEach(func() {
+				removedLeftovers = map[string]string{}
+				ipsets = newTestIPSets(
+					dataplane,
+					IPFamilyV4,
+					WithOnLeftoverRemoved(func(setName, reason string) {
+						Expect(dataplane.IPSetMembers).To(HaveKey(setName),
+							"callback should be called before the IP set is deleted")
+						removedLeftovers[setName] = reason
+					}),
+				)
+			})
+
+			It("should call the callback for each left-over IP set with the reason", func() {
+				ipsets.AddOrReplaceIPSet(meta, []string{"10.0.0.1"})
+				apply()
+				apply()
+				Expect(removedLeftovers).To(Equal(map[string]string{
+					v4MainIPSetName2: LeftoverReasonNotExpected,
+					v4TempIPSetName1: LeftoverReasonTempIPSet,
+				}))
+				Expect(dataplane.IPSetMembers).To(Equal(map[string]set.Set[string]{
+					v4MainIPSetName: set.From("10.0.0.1"),
+				}))
+			})
+
+			It("should not call the callback for our own temporary IP sets", func() {
+				ipsets.AddOrReplaceIPSet(meta, []string{"10.0.0.1"})
+				ipsets.AddOrReplaceIPSet(meta2, []string{"10.0.0.3"})
+				apply()
+				apply()
+				removedLeftovers = map[string]string{}
+
+				By("Changing the IP set's type so that it is rewritten via a temp IP set")
+				ipsets.AddOrReplaceIPSet(IPSetMetadata{MaxSize: 1234, SetID: ipSetID, Type: IPSetTypeHashNet}, []string{"10.0.0.0/24"})
+				apply()
+				apply()
+				Expect(removedLeftovers).To(BeEmpty())
+				dataplane.ExpectMembers(map[string][]string{
+					v4MainIPSetName:  {"10.0.0.0/24"},
+					v4MainIPSetName2: {"10.0.0.3"},
+				})
+			})
+
+			It("should not call the callback for an IP set that was explicitly removed", func() {
+				ipsets.AddOrReplaceIPSet(meta, []string{"10.0.0.1"})
+				ipsets.AddOrReplaceIPSet(meta2, []string{"10.0.0.3"})
+				apply()
+				apply()
+				Expect(removedLeftovers).To(Equal(map[string]string{
+					v4TempIPSetName1: LeftoverReasonTempIPSet,
+				}))
+
+				ipsets.RemoveIPSet(ipSetID2)
+				apply()
+				Expect(removedLeftovers).NotTo(HaveKey(v4MainIPSetName2))
+				Expect(dataplane.IPSetMembers).NotTo(HaveKey(v4MainIPSetName2))
+			})
+		})
+	})
+
+	Describe("with orphaned IP sets in place", func() {
+		BeforeEach(func() {
+			dataplane.IPSetMembers = map[string]set.Set[string]{
+				v4MainIPSetName:  set.From("10.0.0.1"),
+				v4MainIPSetName2: set.From("10.0.0.2"),
+				v4MainIPSetName3: set.From("10.0.0.3"),
+				v4TempIPSetName0: set.From("10.0.0.4"),
+				v4TempIPSetName1: set.From("10.0.0.5"),
+				v4TempIPSetName2: set.From("10.0.0.6"),
+			}
+		})
+
+		It("should report the number of orphans of each kind", func() {
+			ipsets.AddOrReplaceIPSet(meta, []string{"10.0.0.1"})
+			apply()
+			Expect(orphanGauge("temp")).To(Equal(3.0))
+			Expect(orphanGauge("main")).To(Equal(2.0))
+
+			By("Reporting no orphans once they've been cleaned up")
+			for reschedRequested {
+				apply()
+			}
+			Expect(dataplane.IPSetMembers).To(HaveLen(1))
+			ipsets.QueueResync()
+			apply()
+			Expect(orphanGauge("temp")).To(BeZero())
+			Expect(orphanGauge("main")).To(BeZero())
+		})
+
+		It("should not count IP sets that were explicitly removed as orphans", func() {
+			ipsets.AddOrReplaceIPSet(meta, []string{"10.0.0.1"})
+			ipsets.AddOrReplaceIPSet(meta2, []string{"10.0.0.2"})
+			apply()
+			Expect(orphanGauge("main")).To(Equal(1.0))
+			for reschedRequested {
+				apply()
+			}
+			Expect(dataplane.IPSetMembers).To(HaveLen(2))
+
+			ipsets.RemoveIPSet(ipSetID2)
+			ipsets.QueueResync()
+			apply()
+			Expect(orphanGauge("main")).To(BeZero())
+			Expect(dataplane.IPSetMembers).NotTo(HaveKey(v4MainIPSetName2))
+		})
+	})
+
+	It("should enumerate IP sets by type", func() {
+		Expect(ipsets.SetsByType(IPSetTypeHashIP)).To(BeEmpty())
+
+		ipsets.AddOrReplaceIPSet(meta, []string{"10.0.0.1"})
+		ipsets.AddOrReplaceIPSet(meta2, []string{"10.0.0.2"})
+		ipsets.AddOrReplaceIPSet(IPSetMetadata{SetID: ipSetID3, Type: IPSetTypeHashNet, MaxSize: 1234}, []string{"10.0.0.0/24"})
+		ipsets.AddOrReplaceIPSet(IPSetMetadata{SetID: ipSetID4, Type: IPSetTypeHashIPPort, MaxSize: 1234}, nil)
+		ipsets.AddOrReplaceIPSet(IPSetMetadata{SetID: ipSetID5, Type: IPSetTypeHashNet, MaxSize: 1234}, nil)
+
+		Expect(ipsets.SetsByType(IPSetTypeHashIP)).To(Equal([]string{ipSetID, ipSetID2}))
+		Expect(ipsets.SetsByType(IPSetTypeHashNet)).To(Equal([]string{ipSetID3, ipSetID5}))
+		Expect(ipsets.SetsByType(IPSetTypeHashIPPort)).To(Equal([]string{ipSetID4}))
+		Expect(ipsets.SetsByType(IPSetTypeBitmapPort)).To(BeEmpty())
+
+		By("Reflecting type changes and removals")
+		ipsets.AddOrReplaceIPSet(IPSetMetadata{SetID: ipSetID2, Type: IPSetTypeHashNet, MaxSize: 1234}, nil)
+		ipsets.RemoveIPSet(ipSetID5)
+		Expect(ipsets.SetsByType(IPSetTypeHashIP)).To(Equal([]string{ipSetID}))
+		Expect(ipsets.SetsByType(IPSetTypeHashNet)).To(Equal([]string{ipSetID2, ipSetID3}))
+	})
+
+	It("should return the sorted desired members, including pending changes", func() {
+		_, err := ipsets.GetMembers(ipSetID)
+		Expect(err).To(HaveOccurred())
+
+		ipsets.AddOrReplaceIPSet(meta, []string{"10.0.0.10", "10.0.0.2", "10.0.0.1"})
+		Expect(ipsets.GetMembers(ipSetID)).To(Equal([]string{"10.0.0.1", "10.0.0.2", "10.0.0.10"}))
+		apply()
+
+		ipsets.AddMembers(ipSetID, []string{"10.0.0.3", "10.0.0.9"})
+		ipsets.RemoveMembers(ipSetID, []string{"10.0.0.2"})
+		Expect(ipsets.GetMembers(ipSetID)).To(Equal([]string{"10.0.0.1", "10.0.0.3", "10.0.0.9", "10.0.0.10"}))
+		dataplane.ExpectMembers(map[string][]string{
+			v4MainIPSetName: {"10.0.0.1", "10.0.0.2", "10.0.0.10"},
+		})
+
+		By("returning a copy")
+		members, err := ipsets.GetMembers(ipSetID)
+		Expect(err).NotTo(HaveOccurred())
+		members[0] = "10.0.0.99"
+		Expect(ipsets.GetMembers(ipSetID)).To(ContainElement("10.0.0.1"))
+
+		By("returning an error once the IP set is removed")
+		ipsets.RemoveIPSet(ipSetID)
+		_, err = ipsets.GetMembers(ipSetID)
+		Expect(err).To(HaveOccurred())
+	})
+
+	Describe("with shadow mode", func() {
+		var (
+			logHook      *logrustest.Hook
+			oldHooks     log.LevelHooks
+			shadowInputs []ShadowInput
+		)
+
+		divergenceWarnings := func() (warnings []*log.Entry) {
+			for _, e := range logHook.AllEntries() {
+				if e.Level == log.WarnLevel && e.Message == "Shadow restore lines diverge from canonical lines." {
+					warnings = append(warnings, e)
+				}
+			}
+			return
+		}
+
+		BeforeEach(func() {
+			oldHooks = log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+			logHook = logrustest.NewGlobal()
+			shadowInputs = nil
+		})
+
+		AfterEach(func() {
+			log.StandardLogger().ReplaceHooks(oldHooks)
+		})
+
+		Describe("with the default generator", func() {
+			BeforeEach(func() {
+				ipsets = newTestIPSets(
+					dataplane,
+					IPFamilyV4,
+					WithShadowMode(nil),
+				)
+			})
+
+			It("should agree with the canonical lines through creates, updates and rewrites", func() {
+				ipsets.AddOrReplaceIPSet(meta, v4Members1And2)
+				apply()
+				ipsets.AddMembers(ipSetID, []string{"10.0.0.3"})
+				ipsets.RemoveMembers(ipSetID, []string{"10.0.0.1"})
+				apply()
+				ipsets.AddOrReplaceIPSet(IPSetMetadata{SetID: ipSetID, Type: IPSetTypeHashNet, MaxSize: 1234},
+					[]string{"10.0.1.0/24"})
+				apply()
+				dataplane.ExpectMembers(map[string][]string{
+					v4MainIPSetName: {"10.0.1.0/24"},
+				})
+				Expect(divergenceWarnings()).To(BeEmpty())
+			})
+		})
+
+		Describe("with a buggy generator", func() {
+			BeforeEach(func() {
+				// Generator that forgets to remove stale members.
+				buggy := func(in ShadowInput) []string {
+					shadowInputs = append(shadowInputs, in)
+					var lines []string
+					if !in.Exists {
+						lines = append(lines, fmt.Sprintf("create %s %s family %s maxelem %d",
+							in.SetName, in.Type, in.Family, in.MaxSize))
+					}
+					for _, m := range in.DesiredMembers {
+						lines = append(lines, fmt.Sprintf("add %s %s", in.SetName, m))
+					}
+					return lines
+				}
+				ipsets = newTestIPSets(
+					dataplane,
+					IPFamilyV4,
+					WithShadowMode(buggy),
+				)
+				ipsets.AddOrReplaceIPSet(meta, v4Members1And2)
+				apply()
+			})
+
+			It("should pass the generator the current and desired state", func() {
+				ipsets.RemoveMembers(ipSetID, []string{"10.0.0.1"})
+				apply()
+				Expect(shadowInputs).To(HaveLen(2))
+				Expect(shadowInputs[0].Exists).To(BeFalse())
+				Expect(shadowInputs[1]).To(Equal(ShadowInput{
+					SetName:          v4MainIPSetName,
+					Family:           IPFamilyV4,
+					Type:             IPSetTypeHashIP,
+					MaxSize:          meta.MaxSize,
+					Exists:           true,
+					DataplaneMembers: v4Members1And2,
+					DesiredMembers:   []string{"10.0.0.2"},
+				}))
+			})
+
+			It("should not report a divergence when the outputs agree", func() {
+				ipsets.AddMembers(ipSetID, []string{"10.0.0.3"})
+				apply()
+				Expect(divergenceWarnings()).To(BeEmpty())
+			})
+
+			It("should log the divergence but only execute the canonical lines", func() {
+				ipsets.RemoveMembers(ipSetID, []string{"10.0.0.1"})
+				apply()
+				warnings := divergenceWarnings()
+				Expect(warnings).To(HaveLen(1))
+				Expect(warnings[0].Data["setName"]).To(Equal(v4MainIPSetName))
+				Expect(warnings[0].Data["onlyShadow"]).To(Equal([]string{"10.0.0.1"}))
+				Expect(warnings[0].Data["onlyCanonical"]).To(BeEmpty())
+				dataplane.ExpectMembers(map[string][]string{
+					v4MainIPSetName: {"10.0.0.2"},
+				})
+			})
+		})
+	})
+
+	Describe("with maxelem verification", func() {
+		BeforeEach(func() {
+			ipsets = newTestIPSets(
+				dataplane,
+				IPFamilyV4,
+				WithMaxElemVerification(),
+			)
+		})
+
+		It("should record the requested maxelem if the kernel honours it", func() {
+			ipsets.AddOrReplaceIPSet(meta, []string{"10.0.0.1"})
+			apply()
+			Expect(dataplane.CmdNames).To(Equal([]string{"version", "list", "restore", "list"}))
+			effective, ok := ipsets.EffectiveMaxSize(ipSetID)
+			Expect(ok).To(BeTrue())
+			Expect(effective).To(Equal(meta.MaxSize))
+		})
+
+		It("should detect that the kernel capped the maxelem", func() {
+			dataplane.MaxElemCap = 1000
+			ipsets.AddOrReplaceIPSet(meta, []string{"10.0.0.1"})
+			apply()
+			effective, ok := ipsets.EffectiveMaxSize(ipSetID)
+			Expect(ok).To(BeTrue())
+			Expect(effective).To(Equal(1000))
+		})
+
+		It("should only verify IP sets that it creates", func() {
+			ipsets.AddOrReplaceIPSet(meta, []string{"10.0.0.1"})
+			apply()
+			dataplane.CmdNames = nil
+			ipsets.AddMembers(ipSetID, []string{"10.0.0.2"})
+			apply()
+			Expect(dataplane.CmdNames).To(Equal([]string{"restore"}))
+		})
+
+		It("should forget the effective maxelem once the IP set is deleted", func() {
+			ipsets.AddOrReplaceIPSet(meta, []string{"10.0.0.1"})
+			apply()
+			ipsets.RemoveIPSet(ipSetID)
+			apply()
+			_, ok := ipsets.EffectiveMaxSize(ipSetID)
+			Expect(ok).To(BeFalse())
+		})
+	})
+
+	Describe("with checked family errors", func() {
+		BeforeEach(func() {
+			ipsets = newTestIPSets(
+				dataplane,
+				IPFamilyV4,
+				WithCheckedFamilyErrors(),
+			)
+		})
+
+		It("should reject a new IP set with a member of the wrong family", func() {
+			err := ipsets.AddOrReplaceIPSetChecked(meta, []string{"10.0.0.1", "feed::1"})
+			Expect(err).To(HaveOccurred())
+			var wfErr *WrongFamilyError
+			Expect(errors.As(err, &wfErr)).To(BeTrue())
+			Expect(wfErr.Members).To(Equal([]string{"feed::1"}))
+			Expect(err.Error()).To(ContainSubstring("feed::1"))
+			apply()
+			Expect(dataplane.IPSetMembers).To(BeEmpty())
+		})
+
+		It("should reject added members of the wrong family and leave the IP set unchanged", func() {
+			Expect(ipsets.AddOrReplaceIPSetChecked(meta, []string{"10.0.0.1"})).To(Succeed())
+			err := ipsets.AddMembersChecked(ipSetID, []string{"10.0.0.2", "feed::1", "feed::2"})
+			Expect(err).To(HaveOccurred())
+			Expect(err.(*WrongFamilyError).Members).To(Equal([]string{"feed::1", "feed::2"}))
+			apply()
+			Expect(dataplane.IPSetMembers).To(Equal(map[string]set.Set[string]{
+				v4MainIPSetName: set.From("10.0.0.1"),
+			}))
+		})
+
+		It("should accept members of the right family", func() {
+			Expect(ipsets.AddOrReplaceIPSetChecked(meta, []string{"10.0.0.1"})).To(Succeed())
+			Expect(ipsets.AddMembersChecked(ipSetID, []string{"10.0.0.2"})).To(Succeed())
+			apply()
+			Expect(dataplane.IPSetMembers).To(Equal(map[string]set.Set[string]{
+				v4MainIPSetName: set.From("10.0.0.1", "10.0.0.2"),
+			}))
+		})
+
+		It("should return an error when adding members to a nonexistent IP set", func() {
+			Expect(ipsets.AddMembersChecked(ipSetID, []string{"10.0.0.2"})).NotTo(Succeed())
+		})
+
+		It("should still drop members of the wrong family passed to the unchecked API", func() {
+			ipsets.AddOrReplaceIPSet(meta, []string{"10.0.0.1", "feed::1"})
+			ipsets.AddMembers(ipSetID, []string{"10.0.0.2", "feed::2"})
+			apply()
+			Expect(dataplane.IPSetMembers).To(Equal(map[string]set.Set[string]{
+				v4MainIPSetName: set.From("10.0.0.1", "10.0.0.2"),
+			}))
+		})
+	})
+
+	It("should drop members of the wrong family by default", func() {
+		Expect(ipsets.AddOrReplaceIPSetChecked(meta, []string{"10.0.0.1", "feed::1"})).To(Succeed())
+		Expect(ipsets.AddMembersChecked(ipSetID, []string{"feed::2", "10.0.0.2"})).To(Succeed())
+		apply()
+		Expect(dataplane.IPSetMembers).To(Equal(map[string]set.Set[string]{
+			v4MainIPSetName: set.From("10.0.0.1", "10.0.0.2"),
+		}))
 	})
 
 	for _, ipSetType := range AllIPSetTypes {
+		if ipSetType == IPSetTypeListSet {
+			// The members of a list:set are passed in as IP set IDs, not as they appear in
+			// the dataplane; see list_set_test.go.
+			continue
+		}
 		dataplaneMeta := setMetadata{
 			Name:   v4MainIPSetName,
 			Family: "inet",
@@ -489,6 +879,125 @@ var _ = Describe("IP sets dataplane", func() {
 		})
 	})
 
+	Describe("clean-up ordering", func() {
+		It("should not reap a left-over IP set that is added between resync and deletion", func() {
+			dataplane.IPSetMembers = map[string]set.Set[string]{
+				v4MainIPSetName2: set.From("10.0.0.2"),
+			}
+			ipsets.AddOrReplaceIPSet(meta, []string{"10.0.0.1"})
+			ipsets.ApplyUpdates()
+
+			ipsets.AddOrReplaceIPSet(meta2, []string{"10.0.0.3"})
+			ipsets.ApplyDeletions()
+			Expect(dataplane.AttemptedDestroys).NotTo(ContainElement(v4MainIPSetName2))
+
+			ipsets.ApplyUpdates()
+			ipsets.ApplyDeletions()
+			dataplane.ExpectMembers(map[string][]string{
+				v4MainIPSetName:  {"10.0.0.1"},
+				v4MainIPSetName2: {"10.0.0.3"},
+			})
+		})
+
+		It("should not reap an IP set that is removed and then re-added before deletion", func() {
+			ipsets.AddOrReplaceIPSet(meta, []string{"10.0.0.1"})
+			ipsets.AddOrReplaceIPSet(meta2, []string{"10.0.0.2"})
+			ipsets.ApplyUpdates()
+			ipsets.ApplyDeletions()
+
+			ipsets.RemoveIPSet(ipSetID2)
+			ipsets.ApplyUpdates()
+			ipsets.AddOrReplaceIPSet(meta2, []string{"10.0.0.3"})
+			ipsets.ApplyDeletions()
+			Expect(dataplane.AttemptedDestroys).NotTo(ContainElement(v4MainIPSetName2))
+
+			ipsets.ApplyUpdates()
+			dataplane.ExpectMembers(map[string][]string{
+				v4MainIPSetName:  {"10.0.0.1"},
+				v4MainIPSetName2: {"10.0.0.3"},
+			})
+		})
+	})
+
+	Describe("with batched deletions and many left-over IP sets in place", func() {
+		const batchSize = 5
+		var leftovers []string
+
+		BeforeEach(func() {
+			ipsets = newTestIPSets(
+				dataplane,
+				IPFamilyV4,
+				WithBatchedDeletions(batchSize),
+			)
+			leftovers = nil
+			for i := 0; i < batchSize+2; i++ {
+				setName := fmt.Sprintf("cali40s:%d", i)
+				dataplane.IPSetMembers[setName] = set.From("10.0.0.1")
+				leftovers = append(leftovers, setName)
+			}
+		})
+
+		It("should delete a batch of IP sets with a single restore", func() {
+			apply()
+			Expect(dataplane.IPSetMembers).To(HaveLen(2))
+			Expect(dataplane.CmdNames).To(Equal([]string{"version", "list", "restore"}))
+			Expect(dataplane.AttemptedDestroys).To(HaveLen(batchSize))
+
+			dataplane.CmdNames = nil
+			apply()
+			Expect(dataplane.IPSetMembers).To(BeEmpty())
+			Expect(dataplane.CmdNames).To(Equal([]string{"restore"}))
+			Expect(dataplane.AttemptedDestroys).To(ConsistOf(leftovers))
+			Expect(dataplane.TriedToDeleteNonExistent).To(BeFalse())
+
+			dataplane.CmdNames = nil
+			apply()
+			Expect(dataplane.CmdNames).To(BeEmpty())
+		})
+
+		It("should fall back to individual deletions if the batch fails", func() {
+			dataplane.FailDestroyNames.Add("cali40s:3")
+			apply()
+			apply()
+
+			By("Deleting all the other IP sets")
+			Expect(dataplane.IPSetMembers).To(Equal(map[string]set.Set[string]{
+				"cali40s:3": set.From("10.0.0.1"),
+			}))
+			Expect(dataplane.CmdNames).To(ContainElement("destroy"))
+
+			By("Not retrying the failed IP set until the next resync")
+			dataplane.AttemptedDestroys = nil
+			apply()
+			Expect(dataplane.AttemptedDestroys).To(BeEmpty())
+
+			By("Deleting it after the next resync once it's no longer in use")
+			dataplane.FailDestroyNames.Discard("cali40s:3")
+			resyncAndApply()
+			Expect(dataplane.IPSetMembers).To(BeEmpty())
+		})
+
+		It("should report the number of IP sets deleted and the failures", func() {
+			for _, setName := range leftovers {
+				dataplane.FailDestroyNames.Add(setName)
+			}
+			Expect(ipsets.ApplyUpdatesChecked()).To(Succeed())
+			numDeletions, err := ipsets.ApplyDeletionsChecked()
+			Expect(numDeletions).To(BeZero())
+			Expect(err).To(MatchError(ContainSubstring(fmt.Sprintf("failed to delete %d IP set(s)", batchSize))))
+
+			By("deleting the IP sets that it hasn't tried yet")
+			dataplane.FailDestroyNames.Clear()
+			numDeletions, err = ipsets.ApplyDeletionsChecked()
+			Expect(numDeletions).To(Equal(2))
+			Expect(err).NotTo(HaveOccurred())
+
+			numDeletions, err = ipsets.ApplyDeletionsChecked()
+			Expect(numDeletions).To(BeZero())
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
 	Describe("with a persistent failure to delete a new temporary IP set", func() {
 		BeforeEach(func() {
 			// writeFullRewrite will only use a temp IP set if the main IP set exists
@@ -964,7 +1473,7 @@ var _ = Describe("IP sets dataplane", func() {
 
 		dataplane.ExpectMembers(map[string][]string{})
 		// Check there were no restore commands.
-		Expect(dataplane.CmdNames).To(ConsistOf("list"))
+		Expect(dataplane.CmdNames).To(ConsistOf("version", "list"))
 	})
 	It("remove set should be retried on next resync", func() {
 		ipsets.AddOrReplaceIPSet(meta, v4Members1And2)
@@ -978,6 +1487,24 @@ var _ = Describe("IP sets dataplane", func() {
 		resyncAndApply()
 		dataplane.ExpectMembers(map[string][]string{})
 	})
+	It("checked remove should report the number of IP sets deleted and the failures", func() {
+		ipsets.AddOrReplaceIPSet(meta, v4Members1And2)
+		apply()
+
+		dataplane.FailNextDestroy = true
+		ipsets.RemoveIPSet(ipSetID)
+		Expect(ipsets.ApplyUpdatesChecked()).To(Succeed())
+		numDeletions, err := ipsets.ApplyDeletionsChecked()
+		Expect(numDeletions).To(BeZero())
+		Expect(err).To(MatchError(ContainSubstring("failed to delete 1 IP set(s)")))
+
+		ipsets.QueueResync()
+		Expect(ipsets.ApplyUpdatesChecked()).To(Succeed())
+		numDeletions, err = ipsets.ApplyDeletionsChecked()
+		Expect(numDeletions).To(Equal(1))
+		Expect(err).NotTo(HaveOccurred())
+		dataplane.ExpectMembers(map[string][]string{})
+	})
 	It("cleanup should remove unknown IP sets", func() {
 		staleSet := set.New[string]()
 		staleSet.Add("10.0.0.1")
@@ -1026,6 +1553,7 @@ var _ = Describe("Standard IPv4 IPVersionConfig", func() {
 		"cali",
 		rules.AllHistoricIPSetNamePrefixes,
 		rules.LegacyV4IPSetNames,
+		nil,
 	)
 	It("should own its own chains", func() {
 		Expect(v4VersionConf.OwnsIPSet("cali40s:abcdef12345_-")).To(BeTrue())
@@ -1055,6 +1583,68 @@ var _ = Describe("Standard IPv4 IPVersionConfig", func() {
 	})
 })
 
+var _ = Describe("IPVersionConfig with excluded IP sets", func() {
+	v4VersionConf := NewIPVersionConfig(
+		IPFamilyV4,
+		"cali",
+		rules.AllHistoricIPSetNamePrefixes,
+		rules.LegacyV4IPSetNames,
+		[]string{"cali40s:theirs", "felix-4-other*"},
+	)
+	It("should not own excluded IP sets", func() {
+		Expect(v4VersionConf.OwnsIPSet("cali40s:theirs")).To(BeFalse())
+		Expect(v4VersionConf.OwnsIPSet("felix-4-other")).To(BeFalse())
+		Expect(v4VersionConf.OwnsIPSet("felix-4-other-tool")).To(BeFalse())
+	})
+	It("should only exclude exact matches for names without a wildcard", func() {
+		Expect(v4VersionConf.OwnsIPSet("cali40s:theirs2")).To(BeTrue())
+		Expect(v4VersionConf.OwnsIPSet("cali40s:their")).To(BeTrue())
+	})
+	It("should still own other IP sets", func() {
+		Expect(v4VersionConf.OwnsIPSet("cali40s:abcdef12345_-")).To(BeTrue())
+		Expect(v4VersionConf.OwnsIPSet("felix-4-foobar")).To(BeTrue())
+	})
+	It("should treat other regexp characters literally", func() {
+		conf := NewIPVersionConfig(IPFamilyV4, "cali", nil, nil, []string{"cali4.theirs"})
+		Expect(conf.OwnsIPSet("cali4.theirs")).To(BeFalse())
+		Expect(conf.OwnsIPSet("cali4xtheirs")).To(BeTrue())
+	})
+})
+
+var _ = Describe("IP set cleanup with excluded IP sets", func() {
+	var dataplane *mockDataplane
+	var ipsets *IPSets
+
+	BeforeEach(func() {
+		dataplane = newMockDataplane()
+		ipsets = NewIPSetsWithShims(
+			NewIPVersionConfig(
+				IPFamilyV4,
+				"cali",
+				rules.AllHistoricIPSetNamePrefixes,
+				rules.LegacyV4IPSetNames,
+				[]string{v4MainIPSetName2},
+			),
+			logutils.NewSummarizer("test loop"),
+			dataplane.newCmd,
+			dataplane.sleep,
+		)
+		dataplane.IPSetMembers[v4MainIPSetName2] = set.From("10.0.0.2")
+		dataplane.IPSetMembers[v4MainIPSetName] = set.From("10.0.0.1")
+	})
+
+	It("should never delete an excluded IP set that matches our prefix", func() {
+		ipsets.ApplyUpdates()
+		ipsets.ApplyDeletions()
+		Expect(dataplane.AttemptedDestroys).To(ConsistOf(v4MainIPSetName))
+
+		ipsets.QueueResync()
+		ipsets.ApplyUpdates()
+		ipsets.ApplyDeletions()
+		dataplane.ExpectMembers(map[string][]string{v4MainIPSetName2: {"10.0.0.2"}})
+	})
+})
+
 var _ = DescribeTable("ParseRange tests",
 	func(input string, expMin, expMax int, errorExpected bool) {
 		rMin, rMax, err := ParseRange(input)
@@ -1074,3 +1664,25 @@ var _ = DescribeTable("ParseRange tests",
 	Entry("FOO-1", "FOO-1", 0, 0, true),
 	Entry("FOO", "FOO", 0, 0, true),
 )
+
+// orphanGauge returns the current value of the IPv4 orphaned IP sets gauge for the given kind.
+func orphanGauge(kind string) float64 {
+	mfs, err := prometheus.DefaultGatherer.Gather()
+	ExpectWithOffset(1, err).NotTo(HaveOccurred())
+	for _, mf := range mfs {
+		if mf.GetName() != "felix_ipsets_orphaned" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			labels := map[string]string{}
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			if labels["ip_version"] == "inet" && labels["kind"] == kind {
+				return m.GetGauge().GetValue()
+			}
+		}
+	}
+	Fail("orphaned IP sets gauge not found")
+	return 0
+}
@@ -0,0 +1,63 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipsets
+
+import (
+	"fmt"
+	"sort"
+)
+
+// The members of a list:set IP set are other IP sets.  Callers pass us the IDs of the member IP
+// sets, just as they would for an IP set referenced from iptables, and we map them to the names
+// of the main IP sets.  A list:set's MaxSize is its "size", the number of IP sets that it can
+// hold; if zero, ipset's default (8) is used.
+
+// listSetMemberName returns the name of the IP set with the given ID, which is how we refer to it
+// as a member of a list:set.
+func (s *IPSets) listSetMemberName(setID string) string {
+	return s.nameForMainIPSet(setID)
+}
+
+// listSetSizeArg returns the "size" argument of an 'ipset create' line for a list:set, or "" to
+// use ipset's default.
+func listSetSizeArg(size int) string {
+	if size <= 0 {
+		return ""
+	}
+	return fmt.Sprintf(" size %d", size)
+}
+
+// listedListSetSize returns the MaxSize to record for a list:set that 'ipset list' reported with
+// the given size.  If we didn't ask for a size, any size is fine.
+func (s *IPSets) listedListSetSize(setName string, listed int) int {
+	desired, ok := s.setNameToProgrammedMetadata.Desired().Get(setName)
+	if !ok || desired.MaxSize <= 0 {
+		return 0
+	}
+	return listed
+}
+
+// orderListSetsLast moves any list:set IP sets to the end of the given IP set names, keeping the
+// order otherwise.  A list:set can only refer to IP sets that already exist, so we need to
+// create its members first.
+func (s *IPSets) orderListSetsLast(setNames []string) {
+	isListSet := func(setName string) bool {
+		meta, _ := s.setNameToProgrammedMetadata.Desired().Get(setName)
+		return meta.Type == IPSetTypeListSet
+	}
+	sort.SliceStable(setNames, func(i, j int) bool {
+		return !isListSet(setNames[i]) && isListSet(setNames[j])
+	})
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipsets
+
+import "strings"
+
+// unmanagedMemberExtensions maps the keywords of the per-member extensions that 'ipset list' may
+// show after a member, and that we never set, to the number of arguments that follow them.  These
+// extensions don't change which packets the member matches, so a member that only differs from
+// the one we want by these extensions is treated as equivalent.  For example,
+//
+//	10.0.0.1 timeout 30 packets 0 bytes 0 comment "x"
+//
+// is equivalent to 10.0.0.1.
+var unmanagedMemberExtensions = map[string]int{
+	"timeout":  1,
+	"packets":  1,
+	"bytes":    1,
+	"comment":  1,
+	"skbmark":  1,
+	"skbprio":  1,
+	"skbqueue": 1,
+}
+
+// splitListedMember splits a member line from 'ipset list' into the member itself and any
+// extensions that follow it that are not in unmanagedMemberExtensions.  Such extensions (for
+// example "nomatch", which inverts the meaning of a hash:net member) change what the member
+// matches, so a member that has them is a genuine mismatch.
+func splitListedMember(line string) (member string, otherExtensions []string) {
+	fields := splitQuotedFields(line)
+	if len(fields) == 0 {
+		return "", nil
+	}
+	member = fields[0]
+	for i := 1; i < len(fields); i++ {
+		numArgs, ok := unmanagedMemberExtensions[fields[i]]
+		if !ok {
+			otherExtensions = append(otherExtensions, fields[i])
+			continue
+		}
+		i += numArgs
+	}
+	return member, otherExtensions
+}
+
+// splitQuotedFields splits the line on spaces, except for spaces inside double quotes (as found
+// in comments).
+func splitQuotedFields(line string) []string {
+	var fields []string
+	var current strings.Builder
+	inQuotes := false
+	for _, c := range line {
+		switch {
+		case c == '"':
+			inQuotes = !inQuotes
+			current.WriteRune(c)
+		case c == ' ' && !inQuotes:
+			if current.Len() > 0 {
+				fields = append(fields, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(c)
+		}
+	}
+	if current.Len() > 0 {
+		fields = append(fields, current.String())
+	}
+	return fields
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipsets
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/projectcalico/calico/felix/deltatracker"
+	"github.com/projectcalico/calico/felix/ip"
+)
+
+// sortMembers sorts IP set members so that the same members always produce the same ipset
+// restore input.  IPs and CIDRs sort numerically, IPv4 before IPv6, with shorter prefixes first
+// for the same address.  Other members sort lexically by their string form.
+func sortMembers(members []IPSetMember) {
+	// Calculate each member's sort key up front; extracting the address in the comparison
+	// function allocates, which makes sorting a large IP set several times slower.
+	keys := make([]memberSortKey, len(members))
+	for i, m := range members {
+		keys[i] = sortKeyFor(m)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].less(&keys[j])
+	})
+	for i := range keys {
+		members[i] = keys[i].member
+	}
+}
+
+// memberSortKey is the sort key for an IP set member.  For an IP or CIDR, addr holds the IP
+// version, the 16-byte address and the prefix length, which compare in the right order as bytes.
+// For other members, str holds the member's string form.
+type memberSortKey struct {
+	isIP   bool
+	addr   [18]byte
+	str    string
+	member IPSetMember
+}
+
+func sortKeyFor(m IPSetMember) memberSortKey {
+	key := memberSortKey{member: m}
+	var addr ip.Addr
+	var prefix uint8
+	switch m := m.(type) {
+	case ip.CIDR:
+		addr, prefix = m.Addr(), m.Prefix()
+	case ip.Addr:
+		addr, prefix = m, uint8(len(m.AsNetIP())*8)
+	default:
+		key.str = m.String()
+		return key
+	}
+	key.isIP = true
+	key.addr[0] = addr.Version()
+	copy(key.addr[1:17], addr.AsNetIP())
+	key.addr[17] = prefix
+	return key
+}
+
+func (k *memberSortKey) less(other *memberSortKey) bool {
+	if k.isIP != other.isIP {
+		// An IP set has a single type of member so this shouldn't happen; sort IPs first.
+		return k.isIP
+	}
+	if k.isIP {
+		return bytes.Compare(k.addr[:], other.addr[:]) < 0
+	}
+	return k.str < other.str
+}
+
+// sortedPendingMembers collects the members from a pending updates or deletions view, in sorted
+// order.
+func sortedPendingMembers(iter func(func(IPSetMember) deltatracker.IterAction)) []IPSetMember {
+	var members []IPSetMember
+	iter(func(member IPSetMember) deltatracker.IterAction {
+		members = append(members, member)
+		return deltatracker.IterActionNoOp
+	})
+	sortMembers(members)
+	return members
+}
@@ -0,0 +1,123 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipsets
+
+import (
+	"fmt"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/projectcalico/calico/libcalico-go/lib/set"
+)
+
+// Resync checks the members of each IP set that we've programmed against the members that are
+// actually in the dataplane, using "ipset list <name>", and marks any IP set that has drifted
+// (for example, because it was edited by hand) as dirty so that the next ApplyUpdates() fixes
+// it.  Unlike the full resync queued by QueueResync(), it only lists our programmed IP sets and
+// it doesn't touch the dataplane, so it is cheap enough to call periodically.
+//
+// Returns the number of IP sets that had drifted.  If an IP set can't be listed, a full resync
+// is queued for the next ApplyUpdates() and the first such error is returned.
+func (s *IPSets) Resync() (numDrifted int, err error) {
+	var setNames []string
+	s.setNameToProgrammedMetadata.Desired().Iter(func(setName string, _ dataplaneMetadata) {
+		if _, ok := s.setNameToProgrammedMetadata.Dataplane().Get(setName); !ok {
+			// Not created yet; the next ApplyUpdates() will write all of its members.
+			return
+		}
+		if s.ipSetsNeedingRewrite.Contains(setName) {
+			// Will be rewritten from scratch anyway.
+			return
+		}
+		setNames = append(setNames, setName)
+	})
+	sort.Strings(setNames)
+
+	for _, setName := range setNames {
+		drifted, listErr := s.resyncMembers(setName)
+		if listErr != nil {
+			s.resyncRequired = true
+			if err == nil {
+				err = fmt.Errorf("failed to list IP set %s: %w", setName, listErr)
+			}
+			continue
+		}
+		if drifted {
+			numDrifted++
+		}
+	}
+	if numDrifted > 0 {
+		s.logCxt.WithField("numDrifted", numDrifted).Warn(
+			"Found IP sets whose members had changed in the dataplane, will fix them.")
+	}
+	return
+}
+
+// resyncMembers lists the given IP set and, if its members in the dataplane differ from those
+// that we think are there, records the actual members and marks the IP set as dirty.
+func (s *IPSets) resyncMembers(setName string) (drifted bool, err error) {
+	listing, err := s.listIPSet(setName)
+	if err != nil {
+		return false, err
+	}
+	logCxt := s.logCxt.WithField("setName", setName)
+
+	actual := set.New[IPSetMember]()
+	needsRewrite := false
+	for _, line := range listing.Members {
+		if !listing.Type.IsValid() {
+			actual.Add(rawIPSetMember(line))
+			continue
+		}
+		member, otherExts := canonicaliseListedMember(listing.Type, line)
+		if len(otherExts) > 0 {
+			needsRewrite = true
+		}
+		actual.Add(member)
+	}
+
+	memberTracker := s.getOrCreateMemberTracker(setName)
+	drifted = needsRewrite || actual.Len() != memberTracker.Dataplane().Len()
+	if !drifted {
+		memberTracker.Dataplane().Iter(func(m IPSetMember) {
+			if !actual.Contains(m) {
+				drifted = true
+			}
+		})
+	}
+	if !drifted {
+		logCxt.Debug("IP set members in sync with dataplane.")
+		return false, nil
+	}
+
+	logCxt.WithFields(log.Fields{
+		"numExpected":  memberTracker.Dataplane().Len(),
+		"numActual":    actual.Len(),
+		"needsRewrite": needsRewrite,
+	}).Info("IP set members have changed in the dataplane.")
+	_ = memberTracker.Dataplane().ReplaceFromIter(func(f func(k IPSetMember)) error {
+		actual.Iter(func(m IPSetMember) error {
+			f(m)
+			return nil
+		})
+		return nil
+	})
+	if needsRewrite {
+		s.ipSetsNeedingRewrite.Add(setName)
+	}
+	s.updateDirtiness(setName)
+	return true, nil
+}
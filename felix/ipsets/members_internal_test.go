@@ -0,0 +1,228 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipsets
+
+import (
+	"bytes"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+	logrustest "github.com/sirupsen/logrus/hooks/test"
+
+	"github.com/projectcalico/calico/felix/logutils"
+)
+
+// These tests are in the ipsets package so that they can inject members that would normally be
+// filtered out before they reach the member trackers.
+var _ = Describe("IP set member family check on write", func() {
+	var (
+		ipsets   *IPSets
+		oldHooks log.LevelHooks
+		logHook  *logrustest.Hook
+	)
+
+	newIPSets := func(family IPFamily) {
+		ipsets = NewIPSetsWithShims(
+			NewIPVersionConfig(family, "cali", nil, nil, nil),
+			logutils.NewSummarizer("test loop"),
+			nil,
+			func(time.Duration) {},
+			WithRestoreFlavor(RestoreFlavorModern),
+		)
+	}
+
+	// injectAndWrite adds the IP set with its valid members, then injects the bad member
+	// directly into the desired members and returns the restore input for the IP set.
+	injectAndWrite := func(meta IPSetMetadata, members []string, bad string) string {
+		ipsets.AddOrReplaceIPSet(meta, members)
+		setName := ipsets.nameForMainIPSet(meta.SetID)
+		badMember := meta.Type.CanonicaliseMember(bad)
+		ipsets.mainSetNameToMembers[setName].Desired().Add(badMember)
+
+		var buf bytes.Buffer
+		Expect(ipsets.writeUpdates(setName, &buf)).To(Succeed())
+		Expect(ipsets.mainSetNameToMembers[setName].Desired().Contains(badMember)).To(BeFalse(),
+			"Bad member should be removed from the desired members")
+		return buf.String()
+	}
+
+	wrongFamilyErrors := func() (members []interface{}) {
+		for _, e := range logHook.AllEntries() {
+			if e.Level == log.ErrorLevel && e.Message == "Bug: IP set member has the wrong IP family, skipping it." {
+				members = append(members, e.Data["member"])
+			}
+		}
+		return
+	}
+
+	BeforeEach(func() {
+		oldHooks = log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+		logHook = logrustest.NewGlobal()
+	})
+
+	AfterEach(func() {
+		log.StandardLogger().ReplaceHooks(oldHooks)
+	})
+
+	It("should skip a v4 member of a v6 hash:ip IP set", func() {
+		newIPSets(IPFamilyV6)
+		input := injectAndWrite(IPSetMetadata{SetID: "s:v6", Type: IPSetTypeHashIP, MaxSize: 1234},
+			[]string{"fd00::1"}, "10.0.0.1")
+		Expect(input).To(Equal(
+			"create cali60s:v6 hash:ip family inet6 maxelem 1234\n" +
+				"add cali60s:v6 fd00::1\n",
+		))
+		Expect(wrongFamilyErrors()).To(ConsistOf("10.0.0.1"))
+	})
+
+	It("should skip a v6 member of a v4 hash:ip,port IP set", func() {
+		newIPSets(IPFamilyV4)
+		input := injectAndWrite(IPSetMetadata{SetID: "s:v4", Type: IPSetTypeHashIPPort, MaxSize: 1234},
+			[]string{"10.0.0.1,tcp:80"}, "fd00::1,tcp:80")
+		Expect(input).To(Equal(
+			"create cali40s:v4 hash:ip,port family inet maxelem 1234\n" +
+				"add cali40s:v4 10.0.0.1,tcp:80\n",
+		))
+		Expect(wrongFamilyErrors()).To(ConsistOf("fd00::1,tcp:80"))
+	})
+
+	It("should not log for members of the right family", func() {
+		newIPSets(IPFamilyV4)
+		ipsets.AddOrReplaceIPSet(IPSetMetadata{SetID: "s:v4", Type: IPSetTypeHashNet, MaxSize: 1234},
+			[]string{"10.0.0.0/24", "10.0.1.1"})
+		var buf bytes.Buffer
+		Expect(ipsets.writeUpdates(ipsets.nameForMainIPSet("s:v4"), &buf)).To(Succeed())
+		Expect(buf.String()).To(ContainSubstring("add cali40s:v4 10.0.0.0/24\n"))
+		Expect(wrongFamilyErrors()).To(BeEmpty())
+	})
+})
+
+// These tests are in the ipsets package so that they can control the clock and look at the
+// restore input for a single IP set.
+var _ = Describe("IP set member expiry", func() {
+	var (
+		ipsets  *IPSets
+		now     time.Time
+		setName string
+	)
+
+	meta := IPSetMetadata{
+		SetID:   "s:expiry",
+		Type:    IPSetTypeHashIP,
+		MaxSize: 1024,
+		Timeout: 10 * time.Minute,
+	}
+
+	// write returns the restore input for the IP set and records that it was applied.
+	write := func() string {
+		var buf bytes.Buffer
+		Expect(ipsets.writeUpdates(setName, &buf)).To(Succeed())
+		return buf.String()
+	}
+
+	BeforeEach(func() {
+		now = time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+		ipsets = NewIPSetsWithShims(
+			NewIPVersionConfig(IPFamilyV4, "cali", nil, nil, nil),
+			logutils.NewSummarizer("test loop"),
+			nil,
+			func(time.Duration) {},
+			WithRestoreFlavor(RestoreFlavorModern),
+		)
+		ipsets.now = func() time.Time { return now }
+		setName = ipsets.nameForMainIPSet(meta.SetID)
+
+		ipsets.AddOrReplaceIPSet(meta, []string{"10.0.0.1"})
+		Expect(ipsets.AddMembersWithTimeout(meta.SetID, []string{"10.0.0.2"}, 5*time.Minute)).To(Succeed())
+		Expect(write()).To(Equal(
+			"create " + setName + " hash:ip family inet maxelem 1024 timeout 600\n" +
+				"add " + setName + " 10.0.0.1\n" +
+				"add " + setName + " 10.0.0.2 timeout 300\n"))
+	})
+
+	It("should preserve the remaining timeouts when rewriting the IP set", func() {
+		now = now.Add(100 * time.Second)
+		ipsets.ipSetsNeedingRewrite.Add(setName)
+		Expect(write()).To(Equal(
+			"create cali4t0 hash:ip family inet maxelem 1024 timeout 600\n" +
+				"add cali4t0 10.0.0.1 timeout 500\n" +
+				"add cali4t0 10.0.0.2 timeout 200\n" +
+				"swap " + setName + " cali4t0\n"))
+
+		// The rewrite doesn't extend the timeouts.
+		now = now.Add(200 * time.Second)
+		ipsets.forgetExpiredMembers()
+		Expect(ipsets.mainSetNameToMembers[setName].Desired().Contains(meta.Type.CanonicaliseMember("10.0.0.2"))).To(BeFalse())
+	})
+
+	It("should forget members once they've timed out", func() {
+		now = now.Add(5 * time.Minute)
+		ipsets.forgetExpiredMembers()
+		members := ipsets.mainSetNameToMembers[setName]
+		Expect(members.Desired().Contains(meta.Type.CanonicaliseMember("10.0.0.1"))).To(BeTrue())
+		Expect(members.Desired().Contains(meta.Type.CanonicaliseMember("10.0.0.2"))).To(BeFalse())
+		Expect(members.InSync()).To(BeTrue(), "Expired member should not be deleted or re-added")
+
+		now = now.Add(5 * time.Minute)
+		ipsets.forgetExpiredMembers()
+		Expect(members.Desired().Contains(meta.Type.CanonicaliseMember("10.0.0.1"))).To(BeFalse())
+		Expect(members.InSync()).To(BeTrue())
+	})
+
+	It("should restart the timeout of a member that is added again", func() {
+		now = now.Add(4 * time.Minute)
+		Expect(ipsets.AddMembersWithTimeout(meta.SetID, []string{"10.0.0.2"}, 5*time.Minute)).To(Succeed())
+		Expect(write()).To(Equal("add " + setName + " 10.0.0.2 timeout 300 --exist\n"))
+
+		now = now.Add(4 * time.Minute)
+		ipsets.forgetExpiredMembers()
+		Expect(ipsets.mainSetNameToMembers[setName].Desired().Contains(meta.Type.CanonicaliseMember("10.0.0.2"))).To(BeTrue())
+	})
+
+	It("should pick up the remaining timeouts of listed members", func() {
+		member := meta.Type.CanonicaliseMember("10.0.0.1")
+		ipsets.recordListedTimeout(setName, member, "10.0.0.1 timeout 30")
+		now = now.Add(30 * time.Second)
+		ipsets.forgetExpiredMembers()
+		Expect(ipsets.mainSetNameToMembers[setName].Desired().Contains(member)).To(BeFalse())
+	})
+})
+
+var _ = DescribeTable("listedMemberTimeout",
+	func(line string, expected int, expectedOK bool) {
+		timeout, ok := listedMemberTimeout(line)
+		Expect(ok).To(Equal(expectedOK))
+		Expect(timeout).To(Equal(expected))
+	},
+	Entry("no extensions", "10.0.0.1", 0, false),
+	Entry("timeout", "10.0.0.1 timeout 30", 30, true),
+	Entry("timeout after comment", `10.0.0.1 comment "a timeout" timeout 5`, 5, true),
+	Entry("bad timeout", "10.0.0.1 timeout x", 0, false),
+	Entry("missing value", "10.0.0.1 timeout", 0, false),
+)
+
+var _ = DescribeTable("timeoutSeconds",
+	func(d time.Duration, expected int) {
+		Expect(timeoutSeconds(d)).To(Equal(expected))
+	},
+	Entry("zero", time.Duration(0), 0),
+	Entry("negative", -time.Second, 0),
+	Entry("whole seconds", 90*time.Second, 90),
+	Entry("rounds up", 1500*time.Millisecond, 2),
+	Entry("capped", MaxTimeout+time.Hour, 2147483),
+)
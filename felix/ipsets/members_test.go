@@ -0,0 +1,782 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipsets_test
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	logrustest "github.com/sirupsen/logrus/hooks/test"
+
+	. "github.com/projectcalico/calico/felix/ipsets"
+	"github.com/projectcalico/calico/libcalico-go/lib/set"
+)
+
+var _ = Describe("IP sets with invalid members", func() {
+	var (
+		dataplane *mockDataplane
+		ipsets    *IPSets
+		mainName  string
+	)
+
+	BeforeEach(func() {
+		dataplane = newMockDataplane()
+		ipsets = newTestIPSets(dataplane, IPFamilyV4)
+		mainName = ipsets.IPVersionConfig.NameForMainIPSet(ipSetID)
+	})
+
+	DescribeTable("dropping the invalid members and writing the valid ones",
+		func(t IPSetType, members []string, expectedLines []string) {
+			meta := IPSetMetadata{MaxSize: 1234, SetID: ipSetID, Type: t}
+			droppedBefore := invalidMembersDropped()
+			ipsets.AddOrReplaceIPSet(meta, members)
+			ipsets.ApplyUpdates()
+
+			expected := []string{"create " + mainName + " " + string(t) + " family inet maxelem 1234"}
+			for _, l := range expectedLines {
+				expected = append(expected, "add "+mainName+" "+l)
+			}
+			expected = append(expected, "COMMIT")
+			Expect(dataplane.LinesExecuted).To(Equal(expected))
+			Expect(invalidMembersDropped() - droppedBefore).To(BeNumerically("==", len(members)-len(expectedLines)))
+
+			By("rejecting the invalid members from the checked methods")
+			var invalidErr *InvalidMembersError
+			err := ipsets.AddOrReplaceIPSetChecked(meta, members)
+			Expect(errors.As(err, &invalidErr)).To(BeTrue(), "Expected an *InvalidMembersError")
+			Expect(invalidErr.Members).To(HaveLen(len(members) - len(expectedLines)))
+		},
+		Entry("hash:ip", IPSetTypeHashIP,
+			[]string{"10.0.0.2", "host.example.com", "10.0.0.1", "", "10.0.0.300"},
+			[]string{"10.0.0.1", "10.0.0.2"}),
+		Entry("hash:net", IPSetTypeHashNet,
+			[]string{"10.0.0.0/24", "host.example.com/24", "10.0.1.0/33", "10.0.2.1", "garbage"},
+			[]string{"10.0.0.0/24", "10.0.2.1/32"}),
+	)
+
+	It("should drop invalid members passed to AddMembers", func() {
+		meta := IPSetMetadata{MaxSize: 1234, SetID: ipSetID, Type: IPSetTypeHashIP}
+		ipsets.AddOrReplaceIPSet(meta, []string{"10.0.0.1"})
+		ipsets.ApplyUpdates()
+		dataplane.LinesExecuted = nil
+
+		ipsets.AddMembers(ipSetID, []string{"host.example.com", "10.0.0.2"})
+		ipsets.ApplyUpdates()
+		Expect(dataplane.LinesExecuted).To(Equal([]string{
+			"add " + mainName + " 10.0.0.2",
+			"COMMIT",
+		}))
+		dataplane.ExpectMembers(map[string][]string{
+			mainName: {"10.0.0.1", "10.0.0.2"},
+		})
+	})
+})
+
+// invalidMembersDropped returns the current value of the dropped invalid members counter.
+func invalidMembersDropped() float64 {
+	mfs, err := prometheus.DefaultGatherer.Gather()
+	ExpectWithOffset(1, err).NotTo(HaveOccurred())
+	for _, mf := range mfs {
+		if mf.GetName() == "felix_ipset_invalid_members_dropped" {
+			return mf.GetMetric()[0].GetCounter().GetValue()
+		}
+	}
+	Fail("dropped invalid members counter not found")
+	return 0
+}
+
+// upperEncoder is a toy encoder for a made-up IP set type, whose members are rendered in upper
+// case.
+type upperEncoder struct{}
+
+type upperMember string
+
+func (m upperMember) String() string {
+	return string(m)
+}
+
+func (upperEncoder) CanonicaliseMember(member string) IPSetMember {
+	return upperMember(strings.ToLower(member))
+}
+
+func (upperEncoder) RenderMember(member IPSetMember) string {
+	return strings.ToUpper(member.String())
+}
+
+func (upperEncoder) ClassifyFamily(member string) IPFamily {
+	return IPFamilyV4
+}
+
+var _ = Describe("IP set member encoders", func() {
+	It("should have an encoder for every IP set type", func() {
+		for _, t := range AllIPSetTypes {
+			_, err := MemberEncoderFor(t)
+			Expect(err).NotTo(HaveOccurred(), "No encoder for "+string(t))
+			Expect(t.IsValid()).To(BeTrue())
+		}
+	})
+
+	DescribeTable("encoding members",
+		func(t IPSetType, member string, rendered string, family IPFamily) {
+			enc, err := MemberEncoderFor(t)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(enc.RenderMember(enc.CanonicaliseMember(member))).To(Equal(rendered))
+			Expect(enc.ClassifyFamily(member)).To(Equal(family))
+
+			// The IPSetType methods should agree with the encoder.
+			Expect(t.RenderMember(t.CanonicaliseMember(member))).To(Equal(rendered))
+			Expect(t.IsMemberIPV6(member)).To(Equal(family == IPFamilyV6))
+		},
+		Entry("hash:ip v4", IPSetTypeHashIP, "10.0.0.1", "10.0.0.1", IPFamilyV4),
+		Entry("hash:ip v4 /32", IPSetTypeHashIP, "10.0.0.1/32", "10.0.0.1", IPFamilyV4),
+		Entry("hash:ip v6", IPSetTypeHashIP, "fd00:0::1", "fd00::1", IPFamilyV6),
+		Entry("hash:ip,port v4", IPSetTypeHashIPPort, "10.0.0.1,TCP:80", "10.0.0.1,tcp:80", IPFamilyV4),
+		Entry("hash:ip,port v6", IPSetTypeHashIPPort, "fd00::1,sctp:8080", "fd00::1,sctp:8080", IPFamilyV6),
+		Entry("hash:net v4", IPSetTypeHashNet, "10.0.0.0/8", "10.0.0.0/8", IPFamilyV4),
+		Entry("hash:net v4 host", IPSetTypeHashNet, "10.0.0.1/32", "10.0.0.1/32", IPFamilyV4),
+		Entry("hash:net v6", IPSetTypeHashNet, "fd00::/64", "fd00::/64", IPFamilyV6),
+		Entry("hash:net,net v4", IPSetTypeHashNetNet, "10.0.0.0/8,11.0.0.1", "10.0.0.0/8,11.0.0.1/32", IPFamilyV4),
+		Entry("hash:net,net v6", IPSetTypeHashNetNet, "fd00::/64,fd01::/64", "fd00::/64,fd01::/64", IPFamilyV6),
+		Entry("hash:net,port v4", IPSetTypeHashNetPort, "10.0.0.1/24,TCP:443", "10.0.0.0/24,tcp:443", IPFamilyV4),
+		Entry("list:set", IPSetTypeListSet, "cali40s:abcd", "cali40s:abcd", IPFamily("")),
+		Entry("hash:net,port v4 host", IPSetTypeHashNetPort, "10.0.0.1,tcp:443", "10.0.0.1/32,tcp:443", IPFamilyV4),
+		Entry("hash:net,port v6", IPSetTypeHashNetPort, "fd00::/64,udp:53", "fd00::/64,udp:53", IPFamilyV6),
+		Entry("bitmap:port", IPSetTypeBitmapPort, "8080", "8080", IPFamilyV4),
+		Entry("bitmap:port with family", IPSetTypeBitmapPort, "v4,8080", "8080", IPFamilyV4),
+	)
+
+	DescribeTable("validating members",
+		func(t IPSetType, member string, valid bool) {
+			if valid {
+				Expect(t.ValidateMember(member)).To(Succeed())
+			} else {
+				Expect(t.ValidateMember(member)).NotTo(Succeed())
+			}
+		},
+		Entry("hash:ip,port v4", IPSetTypeHashIPPort, "10.0.0.1,tcp:80", true),
+		Entry("hash:ip,port v6", IPSetTypeHashIPPort, "fd00::1,udp:53", true),
+		Entry("hash:ip,port max port", IPSetTypeHashIPPort, "10.0.0.1,sctp:65535", true),
+		Entry("hash:ip,port without port", IPSetTypeHashIPPort, "10.0.0.1", false),
+		Entry("hash:ip,port bad IP", IPSetTypeHashIPPort, "10.0.0.300,tcp:80", false),
+		Entry("hash:ip,port negative port", IPSetTypeHashIPPort, "fd00::1,tcp:-1", false),
+		Entry("hash:net,port v4", IPSetTypeHashNetPort, "10.0.0.0/24,tcp:443", true),
+		Entry("hash:net,port v6", IPSetTypeHashNetPort, "fd00::/64,udp:53", true),
+		Entry("hash:net,port without prefix length", IPSetTypeHashNetPort, "10.0.0.0,tcp:443", false),
+		Entry("hash:net,port bad prefix length", IPSetTypeHashNetPort, "fd00::/129,udp:53", false),
+		Entry("hash:net,port without port", IPSetTypeHashNetPort, "10.0.0.0/24", false),
+		Entry("hash:ip v4", IPSetTypeHashIP, "10.0.0.1", true),
+		Entry("hash:ip v6", IPSetTypeHashIP, "fd00::1", true),
+		Entry("hash:ip hostname", IPSetTypeHashIP, "host.example.com", false),
+		Entry("hash:ip empty", IPSetTypeHashIP, "", false),
+		Entry("hash:net v4", IPSetTypeHashNet, "10.0.0.0/24", true),
+		Entry("hash:net v4 IP", IPSetTypeHashNet, "10.0.0.1", true),
+		Entry("hash:net v6", IPSetTypeHashNet, "fd00::/64", true),
+		Entry("hash:net hostname", IPSetTypeHashNet, "host.example.com", false),
+		Entry("hash:net bad prefix length", IPSetTypeHashNet, "10.0.0.0/33", false),
+		Entry("bitmap:port has no validator", IPSetTypeBitmapPort, "8080", true),
+	)
+
+	It("should return a clear error for an unknown type", func() {
+		_, err := MemberEncoderFor(IPSetType("hash:mac"))
+		Expect(err).To(MatchError(`no member encoder registered for IP set type "hash:mac"`))
+		Expect(IPSetType("hash:mac").IsValid()).To(BeFalse())
+	})
+
+	It("should panic with the type if an unknown type's members are used", func() {
+		Expect(func() { IPSetType("hash:mac").CanonicaliseMember("00:11:22:33:44:55") }).To(Panic())
+	})
+
+	It("should support registering an encoder for a new type", func() {
+		t := IPSetType("test:upper")
+		RegisterMemberEncoder(t, upperEncoder{})
+		Expect(t.IsValid()).To(BeTrue())
+		Expect(t.RenderMember(t.CanonicaliseMember("Foo"))).To(Equal("FOO"))
+	})
+})
+
+// These tests load kernel members that carry extensions, such as timeouts and comments, that we
+// never set, as another tool (or an older version of Felix) might have left behind.
+var _ = Describe("IP sets with member extensions in the dataplane", func() {
+	var (
+		dataplane *mockDataplane
+		ipsets    *IPSets
+	)
+
+	seedKernel := func(setType IPSetType, members ...string) {
+		dataplane.IPSetMembers[v4MainIPSetName] = set.FromArray(members)
+		dataplane.IPSetMetadata[v4MainIPSetName] = setMetadata{
+			Name:    v4MainIPSetName,
+			Family:  "inet",
+			Type:    setType,
+			MaxSize: 1234,
+		}
+	}
+
+	apply := func() {
+		ipsets.ApplyUpdates()
+		ipsets.ApplyDeletions()
+	}
+
+	BeforeEach(func() {
+		dataplane = newMockDataplane()
+		ipsets = newTestIPSets(dataplane, IPFamilyV4)
+	})
+
+	DescribeTable("should treat members that only differ by unmanaged extensions as equivalent",
+		func(setType IPSetType, kernelMember, desiredMember string) {
+			seedKernel(setType, kernelMember)
+			ipsets.AddOrReplaceIPSet(IPSetMetadata{
+				MaxSize: 1234,
+				SetID:   ipSetID,
+				Type:    setType,
+			}, []string{desiredMember})
+			apply()
+
+			Expect(dataplane.NumRestoreCalls()).To(BeZero(), "IP set should already be in sync")
+			Expect(dataplane.IPSetMembers[v4MainIPSetName]).To(Equal(set.From(kernelMember)))
+		},
+		Entry("timeout", IPSetTypeHashIP, "10.0.0.1 timeout 30", "10.0.0.1"),
+		Entry("timeout and comment", IPSetTypeHashIP, `10.0.0.1 timeout 30 comment "x"`, "10.0.0.1"),
+		Entry("comment with spaces", IPSetTypeHashIP, `10.0.0.1 comment "a b nomatch"`, "10.0.0.1"),
+		Entry("counters", IPSetTypeHashIP, "10.0.0.1 packets 10 bytes 840", "10.0.0.1"),
+		Entry("skbinfo", IPSetTypeHashIP, "10.0.0.1 skbmark 0x1/0xffffffff skbprio 1:2 skbqueue 3", "10.0.0.1"),
+		Entry("hash:net", IPSetTypeHashNet, "10.0.0.0/24 timeout 0", "10.0.0.0/24"),
+		Entry("hash:ip,port", IPSetTypeHashIPPort, `10.0.0.1,tcp:80 comment "web"`, "10.0.0.1,tcp:80"),
+	)
+
+	It("should still update a member with extensions that genuinely differs", func() {
+		seedKernel(IPSetTypeHashIP, `10.0.0.1 timeout 30 comment "x"`, "10.0.0.9 timeout 30")
+		ipsets.AddOrReplaceIPSet(IPSetMetadata{
+			MaxSize: 1234,
+			SetID:   ipSetID,
+			Type:    IPSetTypeHashIP,
+		}, []string{"10.0.0.1", "10.0.0.2"})
+		apply()
+
+		Expect(dataplane.LinesExecuted).To(Equal([]string{
+			"del " + v4MainIPSetName + " 10.0.0.9 --exist",
+			"add " + v4MainIPSetName + " 10.0.0.2",
+			"COMMIT",
+		}))
+	})
+
+	It("should rewrite an IP set with a member that has an unknown extension", func() {
+		// An extension that we don't know about may change what the member matches.
+		seedKernel(IPSetTypeHashNet, "10.0.0.0/24 newflag", "10.0.1.0/24")
+		ipsets.AddOrReplaceIPSet(IPSetMetadata{
+			MaxSize: 1234,
+			SetID:   ipSetID,
+			Type:    IPSetTypeHashNet,
+		}, []string{"10.0.0.0/24", "10.0.1.0/24"})
+		apply()
+
+		Expect(dataplane.CmdNames).To(ContainElement("restore"))
+		Expect(dataplane.LinesExecuted).To(ContainElement(HavePrefix("swap " + v4MainIPSetName + " ")))
+		dataplane.ExpectMembers(map[string][]string{
+			v4MainIPSetName: {"10.0.0.0/24", "10.0.1.0/24"},
+		})
+
+		By("not rewriting it again once it has been fixed")
+		ipsets.QueueResync()
+		dataplane.LinesExecuted = nil
+		apply()
+		Expect(dataplane.LinesExecuted).To(BeEmpty())
+	})
+
+	It("should replace an unwanted nomatch member in place", func() {
+		seedKernel(IPSetTypeHashNet, "10.0.0.0/24 nomatch", "10.0.1.0/24")
+		ipsets.AddOrReplaceIPSet(IPSetMetadata{
+			MaxSize: 1234,
+			SetID:   ipSetID,
+			Type:    IPSetTypeHashNet,
+		}, []string{"10.0.0.0/24", "10.0.1.0/24"})
+		apply()
+
+		Expect(dataplane.LinesExecuted).To(Equal([]string{
+			"del " + v4MainIPSetName + " 10.0.0.0/24 --exist",
+			"add " + v4MainIPSetName + " 10.0.0.0/24",
+			"COMMIT",
+		}))
+		dataplane.ExpectMembers(map[string][]string{
+			v4MainIPSetName: {"10.0.0.0/24", "10.0.1.0/24"},
+		})
+	})
+})
+
+var _ = Describe("IP sets member order", func() {
+	meta := IPSetMetadata{
+		MaxSize: 1234,
+		SetID:   ipSetID,
+		Type:    IPSetTypeHashNet,
+	}
+
+	// rewrite programs an IP set with the given members into a fresh dataplane and returns the
+	// restore input that was generated.
+	rewrite := func(family IPFamily, meta IPSetMetadata, members []string) string {
+		dataplane := newMockDataplane()
+		ipsets := newTestIPSets(dataplane, family)
+		ipsets.AddOrReplaceIPSet(meta, members)
+		ipsets.ApplyUpdates()
+		return strings.Join(dataplane.LinesExecuted, "\n")
+	}
+
+	reversed := func(in []string) (out []string) {
+		for i := len(in) - 1; i >= 0; i-- {
+			out = append(out, in[i])
+		}
+		return
+	}
+
+	It("should produce byte-identical restore input for the same members", func() {
+		var members []string
+		for i := 0; i < 200; i++ {
+			members = append(members, fmt.Sprintf("10.%d.%d.0/24", i%7, i))
+		}
+		first := rewrite(IPFamilyV4, meta, members)
+		for i := 0; i < 5; i++ {
+			Expect(rewrite(IPFamilyV4, meta, reversed(members))).To(Equal(first))
+		}
+	})
+
+	It("should sort IPv4 members numerically", func() {
+		lines := rewrite(IPFamilyV4, meta, []string{"10.0.0.10", "10.0.0.0/8", "9.0.0.0/8", "10.0.0.2", "10.0.0.0/24"})
+		Expect(lines).To(Equal(strings.Join([]string{
+			"create " + v4MainIPSetName + " hash:net family inet maxelem 1234",
+			"add " + v4MainIPSetName + " 9.0.0.0/8",
+			"add " + v4MainIPSetName + " 10.0.0.0/8",
+			"add " + v4MainIPSetName + " 10.0.0.0/24",
+			"add " + v4MainIPSetName + " 10.0.0.2/32",
+			"add " + v4MainIPSetName + " 10.0.0.10/32",
+			"COMMIT",
+		}, "\n")))
+	})
+
+	It("should sort IPv6 members numerically", func() {
+		v6MainIPSetName := NewIPVersionConfig(IPFamilyV6, "cali", nil, nil, nil).NameForMainIPSet(ipSetID)
+		lines := rewrite(IPFamilyV6, IPSetMetadata{MaxSize: 1234, SetID: ipSetID, Type: IPSetTypeHashIP},
+			[]string{"fd00::10", "fd00::2", "::1"})
+		Expect(lines).To(Equal(strings.Join([]string{
+			"create " + v6MainIPSetName + " hash:ip family inet6 maxelem 1234",
+			"add " + v6MainIPSetName + " ::1",
+			"add " + v6MainIPSetName + " fd00::2",
+			"add " + v6MainIPSetName + " fd00::10",
+			"COMMIT",
+		}, "\n")))
+	})
+
+	It("should sort other members lexically", func() {
+		lines := rewrite(IPFamilyV4, IPSetMetadata{MaxSize: 1234, SetID: ipSetID, Type: IPSetTypeHashIPPort},
+			[]string{"10.0.0.2,udp:53", "10.0.0.10,tcp:80", "10.0.0.2,tcp:53"})
+		Expect(lines).To(Equal(strings.Join([]string{
+			"create " + v4MainIPSetName + " hash:ip,port family inet maxelem 1234",
+			"add " + v4MainIPSetName + " 10.0.0.10,tcp:80",
+			"add " + v4MainIPSetName + " 10.0.0.2,tcp:53",
+			"add " + v4MainIPSetName + " 10.0.0.2,udp:53",
+			"COMMIT",
+		}, "\n")))
+	})
+})
+
+var _ = Describe("IP set member resync", func() {
+	var dataplane *mockDataplane
+	var ipsets *IPSets
+
+	meta := IPSetMetadata{MaxSize: 1234, SetID: ipSetID, Type: IPSetTypeHashIP}
+	meta2 := IPSetMetadata{MaxSize: 1234, SetID: ipSetID2, Type: IPSetTypeHashIP}
+
+	BeforeEach(func() {
+		dataplane = newMockDataplane()
+		ipsets = newTestIPSets(dataplane, IPFamilyV4)
+		ipsets.AddOrReplaceIPSet(meta, []string{"10.0.0.1", "10.0.0.2"})
+		ipsets.AddOrReplaceIPSet(meta2, []string{"10.0.0.3"})
+		ipsets.ApplyUpdates()
+		dataplane.CmdNames = nil
+		dataplane.LinesExecuted = nil
+	})
+
+	It("should find nothing to do if the dataplane is in sync", func() {
+		Expect(ipsets.Resync()).To(Equal(0))
+		Expect(dataplane.CmdNames).To(Equal([]string{"list", "list"}))
+		ipsets.ApplyUpdates()
+		Expect(dataplane.LinesExecuted).To(BeEmpty())
+	})
+
+	It("should fix an IP set whose members were edited by hand", func() {
+		dataplane.IPSetMembers[v4MainIPSetName].Discard("10.0.0.1")
+		dataplane.IPSetMembers[v4MainIPSetName].Add("10.0.0.9")
+
+		Expect(ipsets.Resync()).To(Equal(1))
+		ipsets.ApplyUpdates()
+		Expect(dataplane.LinesExecuted).To(ConsistOf(
+			"add "+v4MainIPSetName+" 10.0.0.1",
+			"del "+v4MainIPSetName+" 10.0.0.9 --exist",
+			"COMMIT",
+		))
+		dataplane.ExpectMembers(map[string][]string{
+			v4MainIPSetName:  {"10.0.0.1", "10.0.0.2"},
+			v4MainIPSetName2: {"10.0.0.3"},
+		})
+		Expect(ipsets.Resync()).To(Equal(0))
+	})
+
+	It("should not list IP sets that haven't been created yet", func() {
+		ipsets.AddOrReplaceIPSet(IPSetMetadata{MaxSize: 1234, SetID: "s:new", Type: IPSetTypeHashIP}, []string{"10.0.0.4"})
+		Expect(ipsets.Resync()).To(Equal(0))
+		Expect(dataplane.CmdNames).To(Equal([]string{"list", "list"}))
+	})
+
+	It("should queue a full resync if an IP set can't be listed", func() {
+		delete(dataplane.IPSetMembers, v4MainIPSetName2)
+
+		numDrifted, err := ipsets.Resync()
+		Expect(err).To(HaveOccurred())
+		Expect(numDrifted).To(Equal(0))
+		ipsets.ApplyUpdates()
+		dataplane.ExpectMembers(map[string][]string{
+			v4MainIPSetName:  {"10.0.0.1", "10.0.0.2"},
+			v4MainIPSetName2: {"10.0.0.3"},
+		})
+	})
+})
+
+var _ = Describe("IP sets overlap check", func() {
+	var (
+		dataplane *mockDataplane
+		ipsets    *IPSets
+		logHook   *logrustest.Hook
+		oldHooks  log.LevelHooks
+	)
+
+	metaNet := IPSetMetadata{
+		MaxSize: 1234,
+		SetID:   ipSetID,
+		Type:    IPSetTypeHashNet,
+	}
+
+	overlapEntries := func() (entries []*log.Entry) {
+		for _, e := range logHook.AllEntries() {
+			if e.Message == "Adding IP set members that are contained within other members." {
+				entries = append(entries, e)
+			}
+		}
+		return
+	}
+
+	overlapErrors := func() (errs []*OverlappingMembersError) {
+		for _, e := range overlapEntries() {
+			errs = append(errs, e.Data[log.ErrorKey].(*OverlappingMembersError))
+		}
+		return
+	}
+
+	BeforeEach(func() {
+		dataplane = newMockDataplane()
+		oldHooks = log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+		logHook = logrustest.NewGlobal()
+	})
+
+	AfterEach(func() {
+		log.StandardLogger().ReplaceHooks(oldHooks)
+	})
+
+	It("should not check for overlaps by default", func() {
+		ipsets = newTestIPSets(dataplane, IPFamilyV4)
+		ipsets.AddOrReplaceIPSet(metaNet, []string{"10.0.0.0/8", "10.1.0.0/16"})
+		Expect(overlapErrors()).To(BeEmpty())
+	})
+
+	Describe("in warn mode", func() {
+		BeforeEach(func() {
+			ipsets = newTestIPSets(dataplane, IPFamilyV4, WithOverlapCheck(OverlapCheckWarn))
+		})
+
+		It("should warn about members that are contained within other members", func() {
+			ipsets.AddOrReplaceIPSet(metaNet, []string{"10.0.0.0/8", "10.1.0.0/16", "10.1.2.3", "192.168.0.0/24"})
+			Expect(overlapErrors()).To(ConsistOf(&OverlappingMembersError{
+				SetID: ipSetID,
+				Overlaps: []MemberOverlap{
+					{Member: "10.1.0.0/16", ContainedIn: "10.0.0.0/8"},
+					{Member: "10.1.2.3/32", ContainedIn: "10.1.0.0/16"},
+				},
+			}))
+			Expect(overlapEntries()[0].Level).To(Equal(log.WarnLevel))
+
+			By("Still adding the members")
+			ipsets.ApplyUpdates()
+			dataplane.ExpectMembers(map[string][]string{
+				v4MainIPSetName: {"10.0.0.0/8", "10.1.0.0/16", "10.1.2.3/32", "192.168.0.0/24"},
+			})
+		})
+
+		It("should not warn about members that don't overlap", func() {
+			ipsets.AddOrReplaceIPSet(metaNet, []string{"10.0.0.0/16", "10.1.0.0/16", "10.2.0.1", "10.2.0.2"})
+			ipsets.AddMembers(ipSetID, []string{"10.3.0.0/16", "10.0.0.0/16"})
+			Expect(overlapErrors()).To(BeEmpty())
+		})
+
+		It("should warn about new members that contain or are contained by existing members", func() {
+			ipsets.AddOrReplaceIPSet(metaNet, []string{"10.1.0.0/16", "192.168.0.0/16"})
+			Expect(overlapErrors()).To(BeEmpty())
+
+			ipsets.AddMembers(ipSetID, []string{"10.0.0.0/8", "192.168.1.1"})
+			Expect(overlapErrors()).To(ConsistOf(&OverlappingMembersError{
+				SetID: ipSetID,
+				Overlaps: []MemberOverlap{
+					{Member: "10.1.0.0/16", ContainedIn: "10.0.0.0/8"},
+					{Member: "192.168.1.1/32", ContainedIn: "192.168.0.0/16"},
+				},
+			}))
+		})
+
+		It("should ignore IP sets that aren't hash:net", func() {
+			ipsets.AddOrReplaceIPSet(IPSetMetadata{SetID: ipSetID, Type: IPSetTypeHashIP, MaxSize: 1234},
+				[]string{"10.0.0.1", "10.0.0.2"})
+			Expect(overlapErrors()).To(BeEmpty())
+		})
+	})
+
+	Describe("in reject mode", func() {
+		BeforeEach(func() {
+			ipsets = newTestIPSets(dataplane, IPFamilyV4, WithOverlapCheck(OverlapCheckReject))
+		})
+
+		It("should reject overlapping members from the checked methods", func() {
+			err := ipsets.AddOrReplaceIPSetChecked(metaNet, []string{"10.0.0.0/8", "10.1.0.0/16"})
+			Expect(err).To(Equal(&OverlappingMembersError{
+				SetID:    ipSetID,
+				Overlaps: []MemberOverlap{{Member: "10.1.0.0/16", ContainedIn: "10.0.0.0/8"}},
+			}))
+			Expect(ipsets.SetsByType(IPSetTypeHashNet)).To(BeEmpty())
+
+			Expect(ipsets.AddOrReplaceIPSetChecked(metaNet, []string{"10.1.0.0/16"})).To(Succeed())
+			err = ipsets.AddMembersChecked(ipSetID, []string{"10.1.2.0/24", "10.2.0.0/16"})
+			Expect(err).To(Equal(&OverlappingMembersError{
+				SetID:    ipSetID,
+				Overlaps: []MemberOverlap{{Member: "10.1.2.0/24", ContainedIn: "10.1.0.0/16"}},
+			}))
+			members, err := ipsets.GetDesiredMembers(ipSetID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(members.Slice()).To(ConsistOf("10.1.0.0/16"))
+
+			Expect(ipsets.AddMembersChecked(ipSetID, []string{"10.2.0.0/16"})).To(Succeed())
+			Expect(overlapErrors()).To(BeEmpty())
+		})
+
+		It("should log, but still add, overlapping members from the unchecked methods", func() {
+			ipsets.AddOrReplaceIPSet(metaNet, []string{"10.0.0.0/8", "10.1.0.0/16"})
+			Expect(overlapEntries()).To(HaveLen(1))
+			Expect(overlapEntries()[0].Level).To(Equal(log.ErrorLevel))
+			members, err := ipsets.GetDesiredMembers(ipSetID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(members.Slice()).To(ConsistOf("10.0.0.0/8", "10.1.0.0/16"))
+		})
+	})
+})
+
+var _ = Describe("Dual-stack IP sets", func() {
+	var (
+		dataplane *mockDataplane
+		dualStack *DualStackIPSets
+		v4Name    string
+		v6Name    string
+	)
+
+	meta := IPSetMetadata{MaxSize: 1234, SetID: ipSetID, Type: IPSetTypeHashNet}
+
+	BeforeEach(func() {
+		dataplane = newMockDataplane()
+		dualStack = NewDualStackIPSets(newTestIPSets(dataplane, IPFamilyV4), newTestIPSets(dataplane, IPFamilyV6))
+		v4Name = dualStack.V4.IPVersionConfig.NameForMainIPSet(ipSetID)
+		v6Name = dualStack.V6.IPVersionConfig.NameForMainIPSet(ipSetID)
+	})
+
+	It("should panic if given IP sets of the wrong families", func() {
+		Expect(func() { NewDualStackIPSets(dualStack.V6, dualStack.V4) }).To(Panic())
+	})
+
+	Describe("after adding an IP set with mixed members", func() {
+		BeforeEach(func() {
+			dualStack.AddOrReplaceIPSet(meta, []string{"10.0.0.1", "fe80::1", "10.1.0.0/16", "fe80::/64"})
+			dualStack.ApplyUpdates()
+		})
+
+		It("should put each member in the IP set of its family", func() {
+			dataplane.ExpectMembers(map[string][]string{
+				v4Name: {"10.0.0.1/32", "10.1.0.0/16"},
+				v6Name: {"fe80::1/128", "fe80::/64"},
+			})
+			Expect(dualStack.GetMembers(ipSetID)).To(Equal([]string{
+				"10.0.0.1/32", "10.1.0.0/16", "fe80::/64", "fe80::1/128",
+			}))
+			members, err := dualStack.GetDesiredMembers(ipSetID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(members.Slice()).To(ConsistOf("10.0.0.1/32", "10.1.0.0/16", "fe80::1/128", "fe80::/64"))
+		})
+
+		It("should route member updates by family", func() {
+			dualStack.AddMembers(ipSetID, []string{"10.0.0.2", "fe80::2"})
+			dualStack.RemoveMembers(ipSetID, []string{"10.0.0.1", "fe80::/64"})
+			dualStack.ApplyUpdates()
+			dataplane.ExpectMembers(map[string][]string{
+				v4Name: {"10.0.0.2/32", "10.1.0.0/16"},
+				v6Name: {"fe80::1/128", "fe80::2/128"},
+			})
+		})
+
+		It("should create an IP set in both families even with members of only one", func() {
+			dualStack.AddOrReplaceIPSet(meta, []string{"10.0.0.1"})
+			dualStack.ApplyUpdates()
+			dataplane.ExpectMembers(map[string][]string{
+				v4Name: {"10.0.0.1/32"},
+				v6Name: {},
+			})
+		})
+
+		It("should remove the IP set from both families", func() {
+			dualStack.RemoveIPSet(ipSetID)
+			dualStack.ApplyUpdates()
+			Expect(dualStack.ApplyDeletions()).To(BeFalse())
+			dataplane.ExpectMembers(map[string][]string{})
+		})
+
+		It("should panic when adding members to an unknown IP set", func() {
+			Expect(func() { dualStack.AddMembers("unknown", []string{"10.0.0.1"}) }).To(Panic())
+		})
+	})
+})
+
+var _ = Describe("NetSetSyncer", func() {
+	var rec *recordingNetSets
+	var syncer *NetSetSyncer
+
+	BeforeEach(func() {
+		rec = &recordingNetSets{}
+		syncer = NewNetSetSyncer(rec, 1234)
+	})
+
+	It("should create the IP set the first time it sees a group", func() {
+		Expect(syncer.Update(ipSetID, []string{"10.0.0.1", "10.1.0.0/16"})).To(Succeed())
+		Expect(rec.Calls).To(Equal([]string{
+			fmt.Sprintf("replace %s hash:net 1234 [10.0.0.1/32 10.1.0.0/16]", ipSetID),
+		}))
+	})
+
+	It("should only send the delta on later updates", func() {
+		Expect(syncer.Update(ipSetID, []string{"10.0.0.1", "10.1.0.0/16"})).To(Succeed())
+		rec.Calls = nil
+
+		Expect(syncer.Update(ipSetID, []string{"10.1.0.0/16", "10.0.0.2/32", "10.0.0.3"})).To(Succeed())
+		Expect(rec.Calls).To(Equal([]string{
+			fmt.Sprintf("remove %s [10.0.0.1/32]", ipSetID),
+			fmt.Sprintf("add %s [10.0.0.2/32 10.0.0.3/32]", ipSetID),
+		}))
+		rec.Calls = nil
+
+		Expect(syncer.Update(ipSetID, []string{"10.1.0.0/16", "10.0.0.2/32"})).To(Succeed())
+		Expect(rec.Calls).To(Equal([]string{
+			fmt.Sprintf("remove %s [10.0.0.3/32]", ipSetID),
+		}))
+	})
+
+	It("should do nothing if the group hasn't changed", func() {
+		Expect(syncer.Update(ipSetID, []string{"10.0.0.1", "10.1.0.0/16"})).To(Succeed())
+		rec.Calls = nil
+		Expect(syncer.Update(ipSetID, []string{"10.1.0.0/16", "10.0.0.1/32"})).To(Succeed())
+		Expect(rec.Calls).To(BeEmpty())
+	})
+
+	It("should reject invalid CIDRs without making changes", func() {
+		Expect(syncer.Update(ipSetID, []string{"10.0.0.1"})).To(Succeed())
+		rec.Calls = nil
+		Expect(syncer.Update(ipSetID, []string{"10.0.0.2", "bogus"})).To(HaveOccurred())
+		Expect(rec.Calls).To(BeEmpty())
+		Expect(syncer.Update(ipSetID, []string{"10.0.0.1"})).To(Succeed())
+		Expect(rec.Calls).To(BeEmpty())
+	})
+
+	It("should remove the IP set and recreate it if the group comes back", func() {
+		Expect(syncer.Update(ipSetID, []string{"10.0.0.1"})).To(Succeed())
+		syncer.Remove(ipSetID)
+		syncer.Remove(ipSetID)
+		Expect(syncer.Update(ipSetID, []string{"10.0.0.1"})).To(Succeed())
+		Expect(rec.Calls).To(Equal([]string{
+			fmt.Sprintf("replace %s hash:net 1234 [10.0.0.1/32]", ipSetID),
+			fmt.Sprintf("delete %s", ipSetID),
+			fmt.Sprintf("replace %s hash:net 1234 [10.0.0.1/32]", ipSetID),
+		}))
+	})
+
+	Describe("with real IP sets", func() {
+		var dataplane *mockDataplane
+		var ipsets *IPSets
+
+		BeforeEach(func() {
+			dataplane = newMockDataplane()
+			ipsets = newTestIPSets(dataplane, IPFamilyV4)
+			syncer = NewNetSetSyncer(ipsets, 1234)
+			Expect(syncer.Update(ipSetID, []string{"10.0.0.1", "10.1.0.0/16"})).To(Succeed())
+			ipsets.ApplyUpdates()
+			dataplane.LinesExecuted = nil
+		})
+
+		It("should program only the changed members", func() {
+			Expect(syncer.Update(ipSetID, []string{"10.1.0.0/16", "10.0.0.2"})).To(Succeed())
+			ipsets.ApplyUpdates()
+			Expect(dataplane.LinesExecuted).To(ConsistOf(
+				"del "+v4MainIPSetName+" 10.0.0.1/32 --exist",
+				"add "+v4MainIPSetName+" 10.0.0.2/32",
+				"COMMIT",
+			))
+			dataplane.ExpectMembers(map[string][]string{
+				v4MainIPSetName: {"10.0.0.2/32", "10.1.0.0/16"},
+			})
+		})
+	})
+})
+
+// recordingNetSets is a NetSetsDataplane that records the calls made to it.
+type recordingNetSets struct {
+	Calls []string
+}
+
+func (r *recordingNetSets) AddOrReplaceIPSet(setMetadata IPSetMetadata, members []string) {
+	r.Calls = append(r.Calls, fmt.Sprintf("replace %s %s %d %v",
+		setMetadata.SetID, setMetadata.Type, setMetadata.MaxSize, sorted(members)))
+}
+
+func (r *recordingNetSets) AddMembers(setID string, newMembers []string) {
+	r.Calls = append(r.Calls, fmt.Sprintf("add %s %v", setID, sorted(newMembers)))
+}
+
+func (r *recordingNetSets) RemoveMembers(setID string, removedMembers []string) {
+	r.Calls = append(r.Calls, fmt.Sprintf("remove %s %v", setID, sorted(removedMembers)))
+}
+
+func (r *recordingNetSets) RemoveIPSet(setID string) {
+	r.Calls = append(r.Calls, fmt.Sprintf("delete %s", setID))
+}
+
+func sorted(members []string) []string {
+	out := append([]string(nil), members...)
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,64 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipsets
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// The kernel limits IP set names to MaxIPSetNameLength characters so the name of the main IP set
+// may be a truncated form of its IP set ID.  The methods below translate between the two using
+// mainSetNameToSetID, which is maintained by AddOrReplaceIPSet and RemoveIPSet, so that names
+// seen in 'ipset list' can be traced back to their IP set IDs.
+
+// SetIDForIPSetName returns the IP set ID of the main IP set with the given kernel name.  Returns
+// false if there is no such IP set; for example, if it has been removed or the name is that of a
+// temporary IP set.
+func (s *IPSets) SetIDForIPSetName(ipSetName string) (string, bool) {
+	setID, ok := s.mainSetNameToSetID[ipSetName]
+	return setID, ok
+}
+
+// IPSetNameForSetID returns the kernel name of the main IP set with the given IP set ID.  Returns
+// false if no such IP set has been added (or it has since been removed).
+func (s *IPSets) IPSetNameForSetID(setID string) (string, bool) {
+	ipSetName := s.nameForMainIPSet(setID)
+	if mappedID, ok := s.mainSetNameToSetID[ipSetName]; !ok || mappedID != setID {
+		return "", false
+	}
+	return ipSetName, true
+}
+
+// IPSetNames returns a copy of the mapping from IP set ID to kernel name for all the IP sets that
+// have been added by AddOrReplaceIPSet (and not subsequently removed).
+func (s *IPSets) IPSetNames() map[string]string {
+	names := make(map[string]string, len(s.mainSetNameToSetID))
+	for ipSetName, setID := range s.mainSetNameToSetID {
+		if s.isCanaryIPSet(ipSetName) {
+			continue
+		}
+		names[setID] = ipSetName
+	}
+	return names
+}
+
+// ExportIPSetNames writes the mapping returned by IPSetNames to w as a JSON object, sorted by IP
+// set ID, so that it can be saved alongside a dump of the dataplane.
+func (s *IPSets) ExportIPSetNames(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s.IPSetNames())
+}
@@ -0,0 +1,122 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipsets
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/projectcalico/calico/felix/ip"
+	"github.com/projectcalico/calico/libcalico-go/lib/set"
+)
+
+// NetSetsDataplane is the subset of the IPSets API that NetSetSyncer uses.  It is implemented by
+// both IPSets and DualStackIPSets.
+type NetSetsDataplane interface {
+	AddOrReplaceIPSet(setMetadata IPSetMetadata, members []string)
+	AddMembers(setID string, newMembers []string)
+	RemoveMembers(setID string, removedMembers []string)
+	RemoveIPSet(setID string)
+}
+
+// NetSetSyncer pushes named groups of CIDRs, such as the contents of GlobalNetworkSets, into
+// hash:net IP sets.  Each call to Update() passes the full desired contents of a group; the
+// syncer works out what has changed since it last pushed the group and only passes the
+// difference to the dataplane.
+//
+// NetSetSyncer is not safe for concurrent use.
+type NetSetSyncer struct {
+	dataplane NetSetsDataplane
+	maxSize   int
+
+	// setIDToPushed contains the canonical CIDRs that we last pushed for each IP set.
+	setIDToPushed map[string]set.Set[string]
+}
+
+func NewNetSetSyncer(dataplane NetSetsDataplane, maxSize int) *NetSetSyncer {
+	return &NetSetSyncer{
+		dataplane:     dataplane,
+		maxSize:       maxSize,
+		setIDToPushed: map[string]set.Set[string]{},
+	}
+}
+
+// Update sets the contents of the named group.  The first time that a group is seen, its IP set
+// is created with AddOrReplaceIPSet(); after that, only the CIDRs that have been added or removed
+// are passed to the dataplane.  CIDRs are compared in canonical form, so "10.0.0.1" and
+// "10.0.0.1/32" are the same.  Returns an error, and makes no changes, if any of the CIDRs are
+// malformed.
+func (s *NetSetSyncer) Update(setID string, cidrs []string) error {
+	desired := set.New[string]()
+	for _, c := range cidrs {
+		cidr, err := ip.ParseCIDROrIP(c)
+		if err != nil {
+			return fmt.Errorf("invalid CIDR %q for IP set %s: %w", c, setID, err)
+		}
+		desired.Add(cidr.String())
+	}
+
+	pushed, ok := s.setIDToPushed[setID]
+	if !ok {
+		log.WithFields(log.Fields{
+			"setID":    setID,
+			"numCIDRs": desired.Len(),
+		}).Debug("New network set, creating IP set.")
+		s.dataplane.AddOrReplaceIPSet(IPSetMetadata{
+			SetID:   setID,
+			Type:    IPSetTypeHashNet,
+			MaxSize: s.maxSize,
+		}, desired.Slice())
+		s.setIDToPushed[setID] = desired
+		return nil
+	}
+
+	var added, removed []string
+	desired.Iter(func(c string) error {
+		if !pushed.Contains(c) {
+			added = append(added, c)
+		}
+		return nil
+	})
+	pushed.Iter(func(c string) error {
+		if !desired.Contains(c) {
+			removed = append(removed, c)
+		}
+		return nil
+	})
+	if len(removed) > 0 {
+		s.dataplane.RemoveMembers(setID, removed)
+	}
+	if len(added) > 0 {
+		s.dataplane.AddMembers(setID, added)
+	}
+	log.WithFields(log.Fields{
+		"setID":      setID,
+		"numAdded":   len(added),
+		"numRemoved": len(removed),
+	}).Debug("Updated network set.")
+	s.setIDToPushed[setID] = desired
+	return nil
+}
+
+// Remove removes the named group's IP set.  It is a no-op if the group isn't known.
+func (s *NetSetSyncer) Remove(setID string) {
+	if _, ok := s.setIDToPushed[setID]; !ok {
+		return
+	}
+	s.dataplane.RemoveIPSet(setID)
+	delete(s.setIDToPushed, setID)
+}
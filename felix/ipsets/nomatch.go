@@ -0,0 +1,220 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipsets
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/projectcalico/calico/felix/ip"
+	"github.com/projectcalico/calico/libcalico-go/lib/set"
+)
+
+// noMatchMember is an exception in a hash:net IP set: addresses in the CIDR don't match the IP
+// set, even if they're within a wider member.  The kernel stores it as a member with the
+// "nomatch" flag, so an IP set can't have both an exception and a normal member for the same
+// CIDR.
+type noMatchMember struct {
+	cidr ip.CIDR
+}
+
+func (m noMatchMember) String() string {
+	return m.cidr.String() + " nomatch"
+}
+
+// AddExceptions adds the given CIDRs to the hash:net IP set as exceptions, which are written as
+// "add <set> <cidr> nomatch".  For example, a member 10.0.0.0/16 with an exception 10.0.1.0/24
+// matches all of 10.0.0.0/16 except 10.0.1.0/24.  Returns an error, and leaves the IP set
+// unchanged, if the IP set isn't a hash:net, if any of the CIDRs are malformed or if any of them
+// are normal members of the IP set.  CIDRs of the wrong IP family are ignored.  The exceptions
+// survive a later AddOrReplaceIPSet() for the same hash:net IP set.
+func (s *IPSets) AddExceptions(setID string, cidrs []string) error {
+	setName := s.nameForMainIPSet(setID)
+	exceptions, err := s.canonicaliseExceptions(setID, cidrs)
+	if err != nil {
+		return err
+	}
+	membersTracker := s.mainSetNameToMembers[setName]
+	var conflicts []string
+	for _, e := range exceptions {
+		if membersTracker.Desired().Contains(e.cidr) {
+			conflicts = append(conflicts, e.cidr.String())
+		}
+	}
+	if len(conflicts) > 0 {
+		return fmt.Errorf("ipset %s: can't add exceptions for CIDRs that are members: %v", setID, conflicts)
+	}
+	if s.setNameToExceptions[setName] == nil {
+		s.setNameToExceptions[setName] = set.New[noMatchMember]()
+	}
+	numChanges := 0
+	for _, e := range exceptions {
+		s.setNameToExceptions[setName].Add(e)
+		if !membersTracker.Desired().Contains(e) {
+			membersTracker.Desired().Add(e)
+			numChanges++
+		}
+	}
+	s.recordChurn(setName, numChanges)
+	s.updateDirtiness(setName)
+	return nil
+}
+
+// RemoveExceptions removes the given exceptions, previously added with AddExceptions(), from the
+// hash:net IP set.
+func (s *IPSets) RemoveExceptions(setID string, cidrs []string) error {
+	setName := s.nameForMainIPSet(setID)
+	exceptions, err := s.canonicaliseExceptions(setID, cidrs)
+	if err != nil {
+		return err
+	}
+	membersTracker := s.mainSetNameToMembers[setName]
+	numChanges := 0
+	for _, e := range exceptions {
+		if s.setNameToExceptions[setName] != nil {
+			s.setNameToExceptions[setName].Discard(e)
+		}
+		if membersTracker.Desired().Contains(e) {
+			membersTracker.Desired().Delete(e)
+			numChanges++
+		}
+	}
+	s.recordChurn(setName, numChanges)
+	s.updateDirtiness(setName)
+	return nil
+}
+
+// canonicaliseExceptions checks that the IP set is a hash:net and that the CIDRs are valid, and
+// returns the exceptions for those of the CIDRs that match our IP family.
+func (s *IPSets) canonicaliseExceptions(setID string, cidrs []string) ([]noMatchMember, error) {
+	setName := s.nameForMainIPSet(setID)
+	setMeta, ok := s.setNameToAllMetadata[setName]
+	if !ok {
+		return nil, fmt.Errorf("ipset %s not found", setID)
+	}
+	if setMeta.Type != IPSetTypeHashNet {
+		return nil, fmt.Errorf("ipset %s is of type %s, only %s IP sets support exceptions",
+			setID, setMeta.Type, IPSetTypeHashNet)
+	}
+	if err := checkMembersValid(setID, setMeta.Type, cidrs); err != nil {
+		return nil, err
+	}
+	if err := s.checkMemberFamilies(setID, setMeta.Type, cidrs); err != nil {
+		return nil, err
+	}
+	var exceptions []noMatchMember
+	s.filterAndCanonicaliseMembers(setMeta.Type, cidrs).Iter(func(m IPSetMember) error {
+		exceptions = append(exceptions, noMatchMember{cidr: m.(ip.CIDR)})
+		return nil
+	})
+	return exceptions, nil
+}
+
+// checkExceptionConflicts returns an error if any of the new members of a hash:net IP set are
+// currently exceptions.
+func (s *IPSets) checkExceptionConflicts(setID string, ipSetType IPSetType, newMembers []string) error {
+	if ipSetType != IPSetTypeHashNet {
+		return nil
+	}
+	desired := s.mainSetNameToMembers[s.nameForMainIPSet(setID)].Desired()
+	var conflicts []string
+	s.filterAndCanonicaliseMembers(ipSetType, newMembers).Iter(func(m IPSetMember) error {
+		if desired.Contains(noMatchMember{cidr: m.(ip.CIDR)}) {
+			conflicts = append(conflicts, m.String())
+		}
+		return nil
+	})
+	if len(conflicts) > 0 {
+		return fmt.Errorf("ipset %s: can't add members for CIDRs that are exceptions: %v", setID, conflicts)
+	}
+	return nil
+}
+
+// dropConflictingExceptions removes any exceptions for the given (new) members of a hash:net IP
+// set, since the kernel can't hold both.  The most recent update wins.
+func (s *IPSets) dropConflictingExceptions(setName string, ipSetType IPSetType, newMembers []IPSetMember) {
+	if ipSetType != IPSetTypeHashNet {
+		return
+	}
+	desired := s.mainSetNameToMembers[setName].Desired()
+	for _, m := range newMembers {
+		cidr, ok := m.(ip.CIDR)
+		if !ok {
+			continue
+		}
+		e := noMatchMember{cidr: cidr}
+		if desired.Contains(e) {
+			s.logCxt.WithFields(log.Fields{
+				"setName": setName,
+				"member":  cidr.String(),
+			}).Warning("Adding IP set member replaces the exception for the same CIDR.")
+			desired.Delete(e)
+			if s.setNameToExceptions[setName] != nil {
+				s.setNameToExceptions[setName].Discard(e)
+			}
+		}
+	}
+}
+
+// mergeExceptions adds the existing exceptions of the IP set to the canonicalised members passed
+// to AddOrReplaceIPSet() so that replacing the IP set doesn't drop them.  As in AddMembers(), a
+// new member replaces the exception for the same CIDR.  If the IP set is no longer a hash:net,
+// its exceptions are discarded.
+func (s *IPSets) mergeExceptions(setName string, ipSetType IPSetType, canonMembers set.Set[IPSetMember]) {
+	exceptions := s.setNameToExceptions[setName]
+	if exceptions == nil {
+		return
+	}
+	if ipSetType != IPSetTypeHashNet {
+		s.logCxt.WithField("setName", setName).Warning(
+			"IP set is no longer a hash:net, discarding its exceptions.")
+		delete(s.setNameToExceptions, setName)
+		return
+	}
+	exceptions.Iter(func(e noMatchMember) error {
+		if canonMembers.Contains(e.cidr) {
+			s.logCxt.WithFields(log.Fields{
+				"setName": setName,
+				"member":  e.cidr.String(),
+			}).Warning("Replacing IP set with a member for the same CIDR as an exception, dropping the exception.")
+			return set.RemoveItem
+		}
+		canonMembers.Add(e)
+		return nil
+	})
+}
+
+// canonicaliseListedMember canonicalises a member line from 'ipset list'.  It ignores the
+// extensions in unmanagedMemberExtensions and returns any others, which mean that the member
+// isn't one that we'd have written.  The "nomatch" flag of a hash:net member is understood,
+// rather than returned, since we write it for exceptions.
+func canonicaliseListedMember(ipSetType IPSetType, line string) (IPSetMember, []string) {
+	member, otherExts := splitListedMember(line)
+	canon := ipSetType.CanonicaliseMember(member)
+	if ipSetType == IPSetTypeHashNet && len(otherExts) == 1 && otherExts[0] == "nomatch" {
+		return noMatchMember{cidr: canon.(ip.CIDR)}, nil
+	}
+	return canon, otherExts
+}
+
+// renderMemberForDel returns the form of a member that "ipset del" expects.  Unlike "add", it
+// doesn't accept the "nomatch" flag.
+func renderMemberForDel(ipSetType IPSetType, member IPSetMember) string {
+	if e, ok := member.(noMatchMember); ok {
+		return e.cidr.String()
+	}
+	return ipSetType.RenderMember(member)
+}
@@ -0,0 +1,167 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipsets
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/projectcalico/calico/felix/deltatracker"
+	"github.com/projectcalico/calico/felix/ip"
+	"github.com/projectcalico/calico/libcalico-go/lib/set"
+)
+
+// OverlapCheckMode controls whether we check hash:net IP sets for members that are contained
+// within another member of the same IP set.  Such members are legal but redundant, and often
+// indicate a mistake.
+type OverlapCheckMode int
+
+const (
+	// OverlapCheckOff disables the check.  This is the default.
+	OverlapCheckOff OverlapCheckMode = iota
+	// OverlapCheckWarn logs contained members at warning level.  The members are still added.
+	OverlapCheckWarn
+	// OverlapCheckReject makes AddMembersChecked and AddOrReplaceIPSetChecked return an
+	// *OverlappingMembersError (and make no changes) if any of the new members are contained
+	// within another member, or contain an existing member.  AddMembers and AddOrReplaceIPSet
+	// log such members at error level, but still add them.
+	OverlapCheckReject
+)
+
+// WithOverlapCheck enables the check for hash:net IP set members that are contained within
+// another member of the same IP set.
+func WithOverlapCheck(mode OverlapCheckMode) IPSetsOpt {
+	return func(s *IPSets) {
+		s.overlapCheckMode = mode
+	}
+}
+
+// MemberOverlap records that Member is contained within ContainedIn.
+type MemberOverlap struct {
+	Member      string
+	ContainedIn string
+}
+
+// OverlappingMembersError is returned, in OverlapCheckReject mode, when members are passed to
+// an IPSets object that are contained within another member of the same IP set.
+type OverlappingMembersError struct {
+	SetID    string
+	Overlaps []MemberOverlap
+}
+
+func (e *OverlappingMembersError) Error() string {
+	return fmt.Sprintf("IP set %s has members that are contained within other members: %v", e.SetID, e.Overlaps)
+}
+
+// checkOverlaps returns an *OverlappingMembersError if overlap checking is enabled and any of the
+// new members are contained within another new or existing member, or contain an existing member.
+// existing may be nil if the new members replace the contents of the IP set.
+func (s *IPSets) checkOverlaps(
+	setID string,
+	ipSetType IPSetType,
+	existing *deltatracker.DesiredSetView[IPSetMember],
+	newMembers []string,
+) error {
+	if s.overlapCheckMode == OverlapCheckOff || ipSetType != IPSetTypeHashNet {
+		return nil
+	}
+	overlaps := findOverlaps(existing, s.filterAndCanonicaliseMembers(ipSetType, newMembers))
+	if len(overlaps) == 0 {
+		return nil
+	}
+	return &OverlappingMembersError{
+		SetID:    setID,
+		Overlaps: overlaps,
+	}
+}
+
+// logOverlaps logs any overlapping members, for the unchecked Add methods.  The members are still
+// added.
+func (s *IPSets) logOverlaps(
+	setID string,
+	ipSetType IPSetType,
+	existing *deltatracker.DesiredSetView[IPSetMember],
+	newMembers []string,
+) {
+	err := s.checkOverlaps(setID, ipSetType, existing, newMembers)
+	if err == nil {
+		return
+	}
+	logCxt := s.logCxt.WithError(err)
+	if s.overlapCheckMode == OverlapCheckReject {
+		logCxt.Error("Adding IP set members that are contained within other members.")
+	} else {
+		logCxt.Warning("Adding IP set members that are contained within other members.")
+	}
+}
+
+// findOverlaps returns the (new or existing) members that are contained within another member,
+// where at least one of the two is a new member.  Each member is reported against the longest
+// prefix that contains it.
+func findOverlaps(existing *deltatracker.DesiredSetView[IPSetMember], newMembers set.Set[IPSetMember]) []MemberOverlap {
+	isMember := func(m IPSetMember) bool {
+		return newMembers.Contains(m) || (existing != nil && existing.Contains(m))
+	}
+	var overlaps []MemberOverlap
+	checkMember := func(m IPSetMember, candidateContainer func(IPSetMember) bool) {
+		cidr, ok := m.(ip.CIDR)
+		if !ok {
+			return
+		}
+		for prefix := int(cidr.Prefix()) - 1; prefix >= 0; prefix-- {
+			container := ip.CIDRFromAddrAndPrefix(cidr.Addr(), prefix)
+			if candidateContainer(container) {
+				overlaps = append(overlaps, MemberOverlap{
+					Member:      cidr.String(),
+					ContainedIn: container.String(),
+				})
+				return
+			}
+		}
+	}
+
+	// New members that are contained within any member.
+	anyNonHost := false
+	newMembers.Iter(func(m IPSetMember) error {
+		checkMember(m, isMember)
+		if cidr, ok := m.(ip.CIDR); ok && cidr.Prefix() < hostPrefixLen(cidr) {
+			anyNonHost = true
+		}
+		return nil
+	})
+	// Existing members that are contained within a new member.  Only a new member that is
+	// wider than a single address can contain another member, so skip the scan if there
+	// are none.
+	if existing != nil && anyNonHost {
+		existing.Iter(func(m IPSetMember) {
+			if newMembers.Contains(m) {
+				return
+			}
+			checkMember(m, newMembers.Contains)
+		})
+	}
+
+	sort.Slice(overlaps, func(i, j int) bool {
+		return overlaps[i].Member < overlaps[j].Member
+	})
+	return overlaps
+}
+
+func hostPrefixLen(cidr ip.CIDR) uint8 {
+	if cidr.Version() == 6 {
+		return 128
+	}
+	return 32
+}
@@ -0,0 +1,113 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipsets
+
+import (
+	log "github.com/sirupsen/logrus"
+)
+
+// ReferencedSetPolicy controls what we do about an IP set that we repeatedly fail to delete.
+// The usual cause is that the IP set is still referenced by an iptables rule, possibly one that
+// belongs to another application, so the deletion will keep failing until that reference is
+// removed.
+type ReferencedSetPolicy string
+
+const (
+	// ReferencedSetPolicyRetry retries the deletion after each resync, indefinitely.  This is
+	// the default.
+	ReferencedSetPolicyRetry ReferencedSetPolicy = "Retry"
+	// ReferencedSetPolicyGiveUp stops trying to delete the IP set once the deletion has failed
+	// the configured number of times, calling the escalation callback.  The IP set is left in
+	// the dataplane; we try again only if it is re-added and then removed.
+	ReferencedSetPolicyGiveUp ReferencedSetPolicy = "GiveUp"
+	// ReferencedSetPolicyEscalate calls the escalation callback once the deletion has failed the
+	// configured number of times, and carries on retrying after each resync.
+	ReferencedSetPolicyEscalate ReferencedSetPolicy = "Escalate"
+)
+
+// WithReferencedSetPolicy sets the policy for IP sets that we repeatedly fail to delete.  With
+// the GiveUp and Escalate policies, once maxAttempts deletions of an IP set have failed, we log
+// an error, increment the felix_ipset_delete_escalations metric and call onEscalate (which may be
+// nil) with the name of the IP set and the number of failed attempts.
+func WithReferencedSetPolicy(policy ReferencedSetPolicy, maxAttempts int, onEscalate func(setName string, attempts int)) IPSetsOpt {
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	return func(s *IPSets) {
+		s.referencedSetPolicy = policy
+		s.referencedSetMaxAttempts = maxAttempts
+		s.onReferencedSetEscalation = onEscalate
+	}
+}
+
+// recordDeleteFailure records that we failed to delete the given IP set, so that we don't retry
+// it until the next resync, and applies the ReferencedSetPolicy.
+func (s *IPSets) recordDeleteFailure(setName string) {
+	meta, _ := s.setNameToProgrammedMetadata.Dataplane().Get(setName)
+	meta.DeleteFailed = true
+	s.setNameToProgrammedMetadata.Dataplane().Set(setName, meta)
+
+	attempts := s.setNameToDeleteFailures[setName] + 1
+	s.setNameToDeleteFailures[setName] = attempts
+	logCxt := s.logCxt.WithFields(log.Fields{
+		"setName":  setName,
+		"attempts": attempts,
+		"policy":   s.referencedSetPolicy,
+	})
+
+	switch s.referencedSetPolicy {
+	case ReferencedSetPolicyGiveUp:
+		if attempts < s.referencedSetMaxAttempts {
+			break
+		}
+		logCxt.Error("Repeatedly failed to delete IP set, giving up.  It may be referenced by " +
+			"another application and will be left in the dataplane.")
+		s.abandonedDeletions.Add(setName)
+		s.escalateDeleteFailure(setName, attempts)
+	case ReferencedSetPolicyEscalate:
+		if attempts != s.referencedSetMaxAttempts {
+			break
+		}
+		logCxt.Error("Repeatedly failed to delete IP set.  It may be referenced by another " +
+			"application.  Will continue to retry on each resync.")
+		s.escalateDeleteFailure(setName, attempts)
+	default:
+		logCxt.Debug("Failed to delete IP set, will retry on next resync.")
+	}
+}
+
+func (s *IPSets) escalateDeleteFailure(setName string, attempts int) {
+	countNumIPSetDeleteEscalations.Inc()
+	if s.onReferencedSetEscalation != nil {
+		s.onReferencedSetEscalation(setName, attempts)
+	}
+}
+
+// deletionAbandoned returns true if we've given up trying to delete the given IP set.
+func (s *IPSets) deletionAbandoned(setName string) bool {
+	return s.abandonedDeletions.Contains(setName)
+}
+
+// pruneDeleteFailures forgets the failed deletions of IP sets that are no longer pending
+// deletion; for example, because they were re-added or removed by someone else.
+func (s *IPSets) pruneDeleteFailures() {
+	for setName := range s.setNameToDeleteFailures {
+		if _, ok := s.setNameToProgrammedMetadata.PendingDeletions().Get(setName); ok {
+			continue
+		}
+		delete(s.setNameToDeleteFailures, setName)
+		s.abandonedDeletions.Discard(setName)
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipsets
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// RestoreFlavor controls the dialect of the input that we generate for 'ipset restore'.
+type RestoreFlavor int
+
+const (
+	// RestoreFlavorAuto detects the flavor from the output of 'ipset version' the first time
+	// that the IPSets object applies updates or deletions.
+	RestoreFlavorAuto RestoreFlavor = iota
+	// RestoreFlavorModern terminates the input with COMMIT and uses the --exist flag.
+	RestoreFlavorModern
+	// RestoreFlavorLegacy omits the COMMIT line and uses the single-dash -exist flag, for older
+	// ipset binaries that reject those.
+	RestoreFlavorLegacy
+)
+
+func (f RestoreFlavor) String() string {
+	switch f {
+	case RestoreFlavorAuto:
+		return "auto"
+	case RestoreFlavorModern:
+		return "modern"
+	case RestoreFlavorLegacy:
+		return "legacy"
+	default:
+		return fmt.Sprintf("RestoreFlavor(%d)", int(f))
+	}
+}
+
+// defaultMinModernIPSetVersion is the oldest ipset version that auto-detection drives with the
+// modern restore flavor, by default.  It is a conservative choice, well after the modern flavor
+// was introduced, rather than the release that introduced it, so that we don't send COMMIT and
+// --exist to an ipset that might reject them.  Use WithModernRestoreMinVersion to change it.
+var defaultMinModernIPSetVersion = [2]int{6, 20}
+
+// ipsetVersionRegexp matches the output of 'ipset version', for example
+// "ipset v7.11, protocol version: 7".
+var ipsetVersionRegexp = regexp.MustCompile(`^ipset v(\d+)\.(\d+)`)
+
+// WithRestoreFlavor forces the dialect of the input that we generate for 'ipset restore',
+// rather than detecting it from the ipset version.
+func WithRestoreFlavor(flavor RestoreFlavor) IPSetsOpt {
+	return func(s *IPSets) {
+		s.restoreFlavor = flavor
+	}
+}
+
+// WithModernRestoreMinVersion sets the oldest ipset version, major.minor, that auto-detection
+// drives with the modern restore flavor; older versions get the legacy flavor.
+func WithModernRestoreMinVersion(major, minor int) IPSetsOpt {
+	return func(s *IPSets) {
+		s.minModernIPSetVersion = [2]int{major, minor}
+	}
+}
+
+// maybeDetectRestoreFlavor detects the restore flavor, if it hasn't been forced or detected
+// already.  It is called before we generate any input for 'ipset restore', rather than when the
+// IPSets object is constructed, so that constructing one doesn't run any commands.  If the ipset
+// binary isn't installed, it returns an error wrapping ErrIPSetCommandMissing and we try again
+// next time.
+func (s *IPSets) maybeDetectRestoreFlavor() error {
+	if s.restoreFlavor != RestoreFlavorAuto {
+		return nil
+	}
+	if s.dryRun {
+		// Don't touch the host; the flavor only affects the logged commands.
+		s.restoreFlavor = RestoreFlavorModern
+		return nil
+	}
+	flavor, err := s.detectRestoreFlavor()
+	if err != nil {
+		return err
+	}
+	s.restoreFlavor = flavor
+	return nil
+}
+
+// detectRestoreFlavor runs 'ipset version' and returns the restore flavor that suits it.  If
+// the version can't be determined, it falls back to the modern flavor.
+func (s *IPSets) detectRestoreFlavor() (RestoreFlavor, error) {
+	output, err := s.newCmd("ipset", "version").Output()
+	if err != nil {
+		if err := wrapIfCommandMissing(err); errors.Is(err, ErrIPSetCommandMissing) {
+			return RestoreFlavorAuto, err
+		}
+		s.logCxt.WithError(err).Warning("Failed to get ipset version, assuming a modern ipset.")
+		return RestoreFlavorModern, nil
+	}
+	flavor, err := restoreFlavorForVersion(string(output), s.minModernIPSetVersion)
+	if err != nil {
+		s.logCxt.WithError(err).Warning("Failed to parse ipset version, assuming a modern ipset.")
+		return RestoreFlavorModern, nil
+	}
+	s.logCxt.WithFields(log.Fields{
+		"version": string(output),
+		"flavor":  flavor,
+	}).Info("Detected ipset restore flavor.")
+	return flavor, nil
+}
+
+// restoreFlavorForVersion returns the restore flavor for the given 'ipset version' output.
+func restoreFlavorForVersion(version string, minModernIPSetVersion [2]int) (RestoreFlavor, error) {
+	m := ipsetVersionRegexp.FindStringSubmatch(version)
+	if m == nil {
+		return RestoreFlavorAuto, fmt.Errorf("unrecognised ipset version %q", version)
+	}
+	major, _ := strconv.Atoi(m[1])
+	minor, _ := strconv.Atoi(m[2])
+	if major < minModernIPSetVersion[0] ||
+		(major == minModernIPSetVersion[0] && minor < minModernIPSetVersion[1]) {
+		return RestoreFlavorLegacy, nil
+	}
+	return RestoreFlavorModern, nil
+}
+
+// existFlag returns the flag that makes an 'ipset restore' line ignore "already added" or
+// "not present" errors.
+func (s *IPSets) existFlag() string {
+	if s.restoreFlavor == RestoreFlavorLegacy {
+		return "-exist"
+	}
+	return "--exist"
+}
+
+// writeCommit finishes off the input to 'ipset restore'.
+func (s *IPSets) writeCommit(w io.Writer) error {
+	if s.restoreFlavor == RestoreFlavorLegacy {
+		return nil
+	}
+	_, err := w.Write([]byte("COMMIT\n"))
+	return err
+}
@@ -0,0 +1,68 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipsets
+
+import (
+	"bytes"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// RestoreInputSize is the size of the input that we generated for an 'ipset restore'.
+type RestoreInputSize struct {
+	Bytes int
+	Lines int
+}
+
+// WithRestoreInputSizeWarning makes the IPSets object log a warning if the input that it
+// generates for an 'ipset restore' is larger than maxBytes.  Very large inputs risk hitting pipe
+// limits or running the ipset binary out of memory, so the warning helps operators to tune how
+// many updates are applied in one go.  Whether or not this option is used, the size of each
+// restore input is logged at debug level and recorded in the felix_ipset_restore_input_bytes and
+// felix_ipset_restore_input_lines metrics.
+func WithRestoreInputSizeWarning(maxBytes int) IPSetsOpt {
+	return func(s *IPSets) {
+		s.restoreInputWarningBytes = maxBytes
+	}
+}
+
+// LastRestoreInputSize returns the size of the input to the most recent 'ipset restore' that
+// applied IP set updates.
+func (s *IPSets) LastRestoreInputSize() RestoreInputSize {
+	return s.lastRestoreInputSize
+}
+
+// recordRestoreInputSize records the size of the given 'ipset restore' input, just before we
+// execute it, and warns if it exceeds the configured threshold.
+func (s *IPSets) recordRestoreInputSize(input []byte) {
+	size := RestoreInputSize{
+		Bytes: len(input),
+		Lines: bytes.Count(input, []byte("\n")),
+	}
+	s.lastRestoreInputSize = size
+	s.gaugeRestoreInputBytes.Set(float64(size.Bytes))
+	s.gaugeRestoreInputLines.Set(float64(size.Lines))
+
+	logCxt := s.logCxt.WithFields(log.Fields{
+		"bytes": size.Bytes,
+		"lines": size.Lines,
+	})
+	if s.restoreInputWarningBytes > 0 && size.Bytes > s.restoreInputWarningBytes {
+		logCxt.WithField("threshold", s.restoreInputWarningBytes).Warning(
+			"ipset restore input is larger than the warning threshold; consider applying fewer IP set updates per restore.")
+		return
+	}
+	logCxt.Debug("Generated ipset restore input.")
+}
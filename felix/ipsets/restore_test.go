@@ -0,0 +1,677 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipsets_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+	logrustest "github.com/sirupsen/logrus/hooks/test"
+
+	. "github.com/projectcalico/calico/felix/ipsets"
+	"github.com/projectcalico/calico/felix/logutils"
+	"github.com/projectcalico/calico/felix/rules"
+	"github.com/projectcalico/calico/libcalico-go/lib/set"
+)
+
+var _ = Describe("IP set restore batching", func() {
+	var (
+		dataplane *mockDataplane
+		ipsets    *IPSets
+	)
+
+	addSets := func() {
+		for _, id := range []string{ipSetID3, ipSetID, ipSetID2} {
+			ipsets.AddOrReplaceIPSet(IPSetMetadata{MaxSize: 1234, SetID: id, Type: IPSetTypeHashIP}, []string{"10.0.0.1"})
+		}
+	}
+
+	expectAllMembers := func(members ...string) {
+		dataplane.ExpectMembers(map[string][]string{
+			v4MainIPSetName:  members,
+			v4MainIPSetName2: members,
+			v4MainIPSetName3: members,
+		})
+	}
+
+	BeforeEach(func() {
+		dataplane = newMockDataplane()
+		ipsets = newTestIPSets(dataplane, IPFamilyV4)
+		// Do the initial resync so that only the restores are left to count.
+		ipsets.ApplyUpdates()
+		dataplane.CmdNames = nil
+	})
+
+	It("should update all the dirty IP sets with a single restore, in order", func() {
+		addSets()
+		ipsets.ApplyUpdates()
+		Expect(dataplane.CmdNames).To(Equal([]string{"restore"}))
+		Expect(dataplane.LinesExecuted).To(Equal([]string{
+			"create " + v4MainIPSetName + " hash:ip family inet maxelem 1234",
+			"add " + v4MainIPSetName + " 10.0.0.1",
+			"create " + v4MainIPSetName2 + " hash:ip family inet maxelem 1234",
+			"add " + v4MainIPSetName2 + " 10.0.0.1",
+			"create " + v4MainIPSetName3 + " hash:ip family inet maxelem 1234",
+			"add " + v4MainIPSetName3 + " 10.0.0.1",
+			"COMMIT",
+		}))
+		expectAllMembers("10.0.0.1")
+	})
+
+	It("should fall back to a restore per IP set if the batch fails", func() {
+		addSets()
+		dataplane.FailIPSetUpdates[v4MainIPSetName2] = 2
+		ipsets.ApplyUpdates()
+
+		Expect(dataplane.CmdNames).To(Equal([]string{
+			// The batch fails part way through, after updating the first IP set.
+			"restore",
+			"list",
+			// The other two are then updated separately; the second one fails again.
+			"restore",
+			"restore",
+			"list",
+			// Only the failed IP set needs a retry.
+			"restore",
+		}))
+		expectAllMembers("10.0.0.1")
+
+		By("having tracked which IP sets were created")
+		dataplane.CmdNames = nil
+		dataplane.LinesExecuted = nil
+		for _, id := range []string{ipSetID, ipSetID2, ipSetID3} {
+			ipsets.AddMembers(id, []string{"10.0.0.2"})
+		}
+		ipsets.ApplyUpdates()
+		Expect(dataplane.CmdNames).To(Equal([]string{"restore"}))
+		Expect(dataplane.LinesExecuted).To(Equal([]string{
+			"add " + v4MainIPSetName + " 10.0.0.2",
+			"add " + v4MainIPSetName2 + " 10.0.0.2",
+			"add " + v4MainIPSetName3 + " 10.0.0.2",
+			"COMMIT",
+		}))
+		expectAllMembers("10.0.0.1", "10.0.0.2")
+	})
+
+	It("should update the other IP sets even if one IP set keeps failing", func() {
+		ipsets.AddOrReplaceIPSet(IPSetMetadata{MaxSize: 1234, SetID: ipSetID, Type: IPSetTypeHashIP}, []string{"10.0.0.1"})
+		ipsets.AddOrReplaceIPSet(IPSetMetadata{MaxSize: 1234, SetID: ipSetID2, Type: IPSetTypeHashIP}, []string{"10.0.0.1"})
+		ipsets.ApplyUpdates()
+		dataplane.FailIPSetUpdates[v4MainIPSetName] = 1000
+
+		ipsets.AddMembers(ipSetID, []string{"10.0.0.2"})
+		ipsets.AddMembers(ipSetID2, []string{"10.0.0.2"})
+		ipsets.AddOrReplaceIPSet(IPSetMetadata{MaxSize: 1234, SetID: ipSetID3, Type: IPSetTypeHashIP}, []string{"10.0.0.2"})
+		Expect(ipsets.ApplyUpdates).To(Panic())
+		dataplane.ExpectMembers(map[string][]string{
+			v4MainIPSetName:  {"10.0.0.1"},
+			v4MainIPSetName2: {"10.0.0.1", "10.0.0.2"},
+			v4MainIPSetName3: {"10.0.0.2"},
+		})
+	})
+})
+
+var _ = Describe("IP sets restore flavor", func() {
+	var (
+		dataplane *mockDataplane
+		ipsets    *IPSets
+	)
+
+	meta := IPSetMetadata{
+		MaxSize: 1234,
+		SetID:   ipSetID,
+		Type:    IPSetTypeHashIP,
+	}
+
+	apply := func() {
+		ipsets.ApplyUpdates()
+		ipsets.ApplyDeletions()
+	}
+
+	// addThenRemove creates an IP set and then removes one of its members, returning the lines
+	// that were sent to ipset restore.
+	addThenRemove := func() []string {
+		ipsets.AddOrReplaceIPSet(meta, []string{"10.0.0.1", "10.0.0.2"})
+		apply()
+		ipsets.RemoveMembers(ipSetID, []string{"10.0.0.1"})
+		apply()
+		dataplane.ExpectMembers(map[string][]string{
+			v4MainIPSetName: {"10.0.0.2"},
+		})
+		return dataplane.LinesExecuted
+	}
+
+	BeforeEach(func() {
+		dataplane = newMockDataplane()
+	})
+
+	It("should generate modern restore input for a modern ipset", func() {
+		ipsets = newTestIPSets(dataplane, IPFamilyV4)
+		Expect(dataplane.CmdNames).To(BeEmpty(), "constructor shouldn't run any commands")
+		lines := addThenRemove()
+		Expect(dataplane.CmdNames[0]).To(Equal("version"))
+		Expect(lines).To(ContainElement("COMMIT"))
+		Expect(lines).To(ContainElement("del " + v4MainIPSetName + " 10.0.0.1 --exist"))
+	})
+
+	It("should generate legacy restore input for a legacy ipset", func() {
+		dataplane.LegacyIPSet = true
+		ipsets = newTestIPSets(dataplane, IPFamilyV4)
+		lines := addThenRemove()
+		Expect(lines).NotTo(ContainElement("COMMIT"))
+		Expect(lines).To(ContainElement("del " + v4MainIPSetName + " 10.0.0.1 -exist"))
+	})
+
+	It("should only check the ipset version once", func() {
+		ipsets = newTestIPSets(dataplane, IPFamilyV4)
+		addThenRemove()
+		numVersionCmds := 0
+		for _, name := range dataplane.CmdNames {
+			if name == "version" {
+				numVersionCmds++
+			}
+		}
+		Expect(numVersionCmds).To(Equal(1))
+	})
+
+	It("should honour a configured minimum version for the modern flavor", func() {
+		dataplane.IPSetVersion = "ipset v6.11, protocol version: 6\n"
+		ipsets = newTestIPSets(dataplane, IPFamilyV4, WithModernRestoreMinVersion(6, 0))
+		lines := addThenRemove()
+		Expect(lines).To(ContainElement("COMMIT"))
+	})
+
+	It("should generate legacy input for batched deletions on a legacy ipset", func() {
+		dataplane.LegacyIPSet = true
+		dataplane.IPSetMembers[v4MainIPSetName2] = set.From("10.0.0.1")
+		dataplane.IPSetMembers[v4MainIPSetName3] = set.From("10.0.0.1")
+		ipsets = newTestIPSets(dataplane, IPFamilyV4, WithBatchedDeletions(10))
+		apply()
+		Expect(dataplane.IPSetMembers).To(BeEmpty())
+		Expect(dataplane.LinesExecuted).To(ConsistOf(
+			"destroy "+v4MainIPSetName2,
+			"destroy "+v4MainIPSetName3,
+		))
+	})
+
+	It("should use the forced flavor without checking the ipset version", func() {
+		dataplane.LegacyIPSet = true
+		ipsets = newTestIPSets(dataplane, IPFamilyV4, WithRestoreFlavor(RestoreFlavorLegacy))
+		Expect(dataplane.CmdNames).To(BeEmpty())
+		lines := addThenRemove()
+		Expect(lines).NotTo(ContainElement("COMMIT"))
+	})
+})
+
+var _ = Describe("IP sets restore input size", func() {
+	var (
+		dataplane *mockDataplane
+		ipsets    *IPSets
+		logHook   *logrustest.Hook
+		oldHooks  log.LevelHooks
+	)
+
+	meta := IPSetMetadata{MaxSize: 1000, SetID: ipSetID, Type: IPSetTypeHashIP}
+
+	members := func(n int) (ips []string) {
+		for i := 0; i < n; i++ {
+			ips = append(ips, fmt.Sprintf("10.0.%d.%d", i/256, i%256))
+		}
+		return
+	}
+
+	// executedInputSize returns the size of the restore input that the mock dataplane executed.
+	executedInputSize := func() RestoreInputSize {
+		size := RestoreInputSize{Lines: len(dataplane.LinesExecuted)}
+		for _, line := range dataplane.LinesExecuted {
+			size.Bytes += len(line) + 1
+		}
+		return size
+	}
+
+	sizeWarnings := func() (entries []*log.Entry) {
+		for _, e := range logHook.AllEntries() {
+			if e.Level == log.WarnLevel && e.Data["threshold"] != nil {
+				entries = append(entries, e)
+			}
+		}
+		return
+	}
+
+	BeforeEach(func() {
+		dataplane = newMockDataplane()
+		oldHooks = log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+		logHook = logrustest.NewGlobal()
+	})
+
+	AfterEach(func() {
+		log.StandardLogger().ReplaceHooks(oldHooks)
+	})
+
+	It("should report the size of the generated restore input", func() {
+		ipsets = newTestIPSets(dataplane, IPFamilyV4)
+		ipsets.ApplyUpdates() // Initial resync.
+		Expect(ipsets.LastRestoreInputSize()).To(Equal(RestoreInputSize{}))
+
+		dataplane.LinesExecuted = nil
+		ipsets.AddOrReplaceIPSet(meta, members(10))
+		ipsets.ApplyUpdates()
+		size := ipsets.LastRestoreInputSize()
+		// create + 10 adds + COMMIT.
+		Expect(size.Lines).To(Equal(12))
+		Expect(size).To(Equal(executedInputSize()))
+
+		By("reporting the size of a subsequent delta")
+		dataplane.LinesExecuted = nil
+		ipsets.AddMembers(ipSetID, []string{"10.1.0.1"})
+		ipsets.RemoveMembers(ipSetID, []string{"10.0.0.0"})
+		ipsets.ApplyUpdates()
+		Expect(ipsets.LastRestoreInputSize().Lines).To(Equal(3))
+		Expect(ipsets.LastRestoreInputSize()).To(Equal(executedInputSize()))
+		dataplane.ExpectMembers(map[string][]string{
+			v4MainIPSetName: append(members(10)[1:], "10.1.0.1"),
+		})
+	})
+
+	It("should not warn by default", func() {
+		ipsets = newTestIPSets(dataplane, IPFamilyV4)
+		ipsets.AddOrReplaceIPSet(meta, members(100))
+		ipsets.ApplyUpdates()
+		Expect(ipsets.LastRestoreInputSize().Bytes).To(BeNumerically(">", 1000))
+		Expect(sizeWarnings()).To(BeEmpty())
+	})
+
+	It("should warn only when the input exceeds the threshold", func() {
+		ipsets = newTestIPSets(dataplane, IPFamilyV4, WithRestoreInputSizeWarning(1000))
+		ipsets.AddOrReplaceIPSet(meta, members(10))
+		ipsets.ApplyUpdates()
+		Expect(ipsets.LastRestoreInputSize().Bytes).To(BeNumerically("<=", 1000))
+		Expect(sizeWarnings()).To(BeEmpty())
+
+		ipsets.AddMembers(ipSetID, members(100))
+		ipsets.ApplyUpdates()
+		size := ipsets.LastRestoreInputSize()
+		Expect(size.Bytes).To(BeNumerically(">", 1000))
+		warnings := sizeWarnings()
+		Expect(warnings).To(HaveLen(1))
+		Expect(warnings[0].Data["bytes"]).To(Equal(size.Bytes))
+		Expect(warnings[0].Data["lines"]).To(Equal(size.Lines))
+		Expect(warnings[0].Data["threshold"]).To(Equal(1000))
+		dataplane.ExpectMembers(map[string][]string{v4MainIPSetName: members(100)})
+	})
+})
+
+var _ = Describe("IP set update retries", func() {
+	var dataplane *mockDataplane
+	var ipsets *IPSets
+	var numRestoreAttempts int
+	var newCmd func(name string, arg ...string) CmdIface
+
+	meta := IPSetMetadata{MaxSize: 1234, SetID: ipSetID, Type: IPSetTypeHashIP}
+
+	BeforeEach(func() {
+		dataplane = newMockDataplane()
+		numRestoreAttempts = 0
+		newCmd = func(name string, arg ...string) CmdIface {
+			if len(arg) > 0 && arg[0] == "restore" {
+				numRestoreAttempts++
+			}
+			return dataplane.newCmd(name, arg...)
+		}
+		ipsets = NewIPSetsWithShims(
+			NewIPVersionConfig(IPFamilyV4, "cali", nil, nil, nil),
+			logutils.NewSummarizer("test loop"),
+			newCmd,
+			dataplane.sleep,
+			WithRetryConfig(RetryConfig{
+				MaxRetries:     3,
+				InitialBackoff: 10 * time.Millisecond,
+				MaxBackoff:     15 * time.Millisecond,
+			}),
+		)
+		ipsets.AddOrReplaceIPSet(meta, []string{"10.0.0.1"})
+	})
+
+	It("should succeed on the third attempt after two failures", func() {
+		dataplane.RestoreOpFailures = []string{"start", "start"}
+		Expect(ipsets.ApplyUpdatesChecked()).To(Succeed())
+		Expect(numRestoreAttempts).To(Equal(3))
+		Expect(dataplane.CumulativeSleep).To(Equal(25 * time.Millisecond))
+		dataplane.ExpectMembers(map[string][]string{v4MainIPSetName: {"10.0.0.1"}})
+	})
+
+	It("should return an error, rather than panicking, once the retries are exhausted", func() {
+		dataplane.FailAllRestores = true
+		err := ipsets.ApplyUpdatesChecked()
+		Expect(errors.Is(err, ErrIPSetUpdatesFailed)).To(BeTrue())
+		Expect(numRestoreAttempts).To(Equal(4))
+		Expect(dataplane.CumulativeSleep).To(Equal(10*time.Millisecond + 3*15*time.Millisecond))
+
+		// The updates should still be queued.
+		dataplane.FailAllRestores = false
+		Expect(ipsets.ApplyUpdatesChecked()).To(Succeed())
+		dataplane.ExpectMembers(map[string][]string{v4MainIPSetName: {"10.0.0.1"}})
+	})
+
+	It("should still panic from ApplyUpdates once the retries are exhausted", func() {
+		dataplane.FailAllRestores = true
+		Expect(ipsets.ApplyUpdates).To(Panic())
+	})
+
+	It("should make a single attempt if retries are disabled", func() {
+		ipsets = NewIPSetsWithShims(
+			NewIPVersionConfig(IPFamilyV4, "cali", nil, nil, nil),
+			logutils.NewSummarizer("test loop"),
+			newCmd,
+			dataplane.sleep,
+			WithRetryConfig(RetryConfig{MaxRetries: -1}),
+		)
+		ipsets.AddOrReplaceIPSet(meta, []string{"10.0.0.1"})
+		dataplane.FailAllRestores = true
+		Expect(ipsets.ApplyUpdatesChecked()).NotTo(Succeed())
+		Expect(numRestoreAttempts).To(Equal(1))
+	})
+})
+
+var _ = Describe("RetryConfig backoff", func() {
+	It("should add jitter without exceeding the maximum backoff", func() {
+		dataplane := newMockDataplane()
+		ipsets := newTestIPSets(
+			dataplane,
+			IPFamilyV4,
+			WithRetryConfig(RetryConfig{
+				MaxRetries:     4,
+				InitialBackoff: 100 * time.Millisecond,
+				MaxBackoff:     300 * time.Millisecond,
+				Jitter:         0.5,
+			}),
+		)
+		dataplane.FailAllLists = true
+		Expect(ipsets.ApplyUpdatesChecked()).NotTo(Succeed())
+		// Without jitter: 100 + 200 + 300 + 300 + 300.
+		Expect(dataplane.CumulativeSleep).To(BeNumerically(">=", 1200*time.Millisecond))
+		Expect(dataplane.CumulativeSleep).To(BeNumerically("<=", 1350*time.Millisecond))
+	})
+})
+
+var _ = Describe("IP sets exec semaphore", func() {
+	const (
+		numIPSets     = 10
+		maxConcurrent = 2
+	)
+
+	var (
+		inFlight    int32
+		maxInFlight int32
+	)
+
+	BeforeEach(func() {
+		inFlight = 0
+		maxInFlight = 0
+	})
+
+	It("should limit the number of concurrent ipset commands", func() {
+		sem := NewExecSemaphore(maxConcurrent)
+		var wg sync.WaitGroup
+		var dataplanes []*mockDataplane
+		for i := 0; i < numIPSets; i++ {
+			// IPSets objects aren't thread safe, so each goroutine gets its own, along
+			// with its own mock dataplane.  Only the semaphore is shared.
+			dataplane := newMockDataplane()
+			dataplanes = append(dataplanes, dataplane)
+			ipsets := NewIPSetsWithShims(
+				NewIPVersionConfig(IPFamilyV4, "cali", rules.AllHistoricIPSetNamePrefixes, rules.LegacyV4IPSetNames, nil),
+				logutils.NewSummarizer("test loop"),
+				func(name string, arg ...string) CmdIface {
+					return &inFlightCmd{
+						CmdIface:    dataplane.newCmd(name, arg...),
+						inFlight:    &inFlight,
+						maxInFlight: &maxInFlight,
+					}
+				},
+				dataplane.sleep,
+				WithExecSemaphore(sem),
+			)
+			ipsets.AddOrReplaceIPSet(IPSetMetadata{
+				MaxSize: 1234,
+				SetID:   ipSetID,
+				Type:    IPSetTypeHashIP,
+			}, v4Members1And2)
+
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				ipsets.ApplyUpdates()
+				ipsets.QueueResync()
+				ipsets.ApplyUpdates()
+			}()
+		}
+		wg.Wait()
+
+		Expect(atomic.LoadInt32(&maxInFlight)).To(BeNumerically(">", 0))
+		Expect(atomic.LoadInt32(&maxInFlight)).To(BeNumerically("<=", maxConcurrent))
+		Expect(atomic.LoadInt32(&inFlight)).To(BeZero())
+		for _, dataplane := range dataplanes {
+			dataplane.ExpectMembers(map[string][]string{
+				v4MainIPSetName: v4Members1And2,
+			})
+		}
+	})
+})
+
+// inFlightCmd wraps a CmdIface and tracks how many commands are running at once.  It sleeps
+// while running to give other goroutines a chance to start their commands.
+type inFlightCmd struct {
+	CmdIface
+	inFlight    *int32
+	maxInFlight *int32
+}
+
+func (c *inFlightCmd) begin() {
+	n := atomic.AddInt32(c.inFlight, 1)
+	for {
+		max := atomic.LoadInt32(c.maxInFlight)
+		if n <= max || atomic.CompareAndSwapInt32(c.maxInFlight, max, n) {
+			break
+		}
+	}
+	time.Sleep(time.Millisecond)
+}
+
+func (c *inFlightCmd) end() {
+	atomic.AddInt32(c.inFlight, -1)
+}
+
+func (c *inFlightCmd) Start() error {
+	c.begin()
+	err := c.CmdIface.Start()
+	if err != nil {
+		c.end()
+	}
+	return err
+}
+
+func (c *inFlightCmd) Wait() error {
+	defer c.end()
+	return c.CmdIface.Wait()
+}
+
+func (c *inFlightCmd) Output() ([]byte, error) {
+	c.begin()
+	defer c.end()
+	return c.CmdIface.Output()
+}
+
+func (c *inFlightCmd) CombinedOutput() ([]byte, error) {
+	c.begin()
+	defer c.end()
+	return c.CmdIface.CombinedOutput()
+}
+
+var _ = Describe("IP sets debug temp names", func() {
+	var (
+		dataplane *mockDataplane
+		ipsets    *IPSets
+	)
+
+	apply := func() {
+		ipsets.ApplyUpdates()
+		ipsets.ApplyDeletions()
+	}
+
+	// rewriteViaTempSets creates an IP set and then changes its metadata n times, forcing a
+	// rewrite via a temporary IP set each time.  It returns the names of the temporary IP sets.
+	rewriteViaTempSets := func(n int) (tempNames []string) {
+		meta := IPSetMetadata{MaxSize: 1000, SetID: ipSetID, Type: IPSetTypeHashIP}
+		ipsets.AddOrReplaceIPSet(meta, []string{"10.0.0.1"})
+		apply()
+		for i := 0; i < n; i++ {
+			dataplane.LinesExecuted = nil
+			meta.MaxSize++
+			ipsets.AddOrReplaceIPSet(meta, []string{"10.0.0.1"})
+			apply()
+			for _, line := range dataplane.LinesExecuted {
+				if strings.HasPrefix(line, "swap ") {
+					tempNames = append(tempNames, strings.Fields(line)[2])
+				}
+			}
+		}
+		dataplane.ExpectMembers(map[string][]string{v4MainIPSetName: {"10.0.0.1"}})
+		return
+	}
+
+	BeforeEach(func() {
+		dataplane = newMockDataplane()
+	})
+
+	It("should use numbered temp names by default", func() {
+		ipsets = newTestIPSets(dataplane, IPFamilyV4)
+		Expect(rewriteViaTempSets(2)).To(Equal([]string{v4TempIPSetName0, v4TempIPSetName1}))
+	})
+
+	Describe("with debug temp names", func() {
+		BeforeEach(func() {
+			ipsets = newTestIPSets(dataplane, IPFamilyV4, WithDebugTempIPSetNames())
+		})
+
+		It("should use a unique, owned temp name for each rewrite", func() {
+			tempNames := rewriteViaTempSets(3)
+			Expect(tempNames).To(HaveLen(3))
+			Expect(set.FromArray(tempNames).Len()).To(Equal(3))
+			for _, name := range tempNames {
+				Expect(len(name)).To(BeNumerically("<=", MaxIPSetNameLength))
+				Expect(name).To(MatchRegexp(`^cali4t[0-9a-z]+-[0-9a-z]+-s:qMt7`))
+				Expect(ipsets.IPVersionConfig.OwnsIPSet(name)).To(BeTrue())
+				Expect(ipsets.IPVersionConfig.IsTempIPSetName(name)).To(BeTrue())
+			}
+		})
+
+		It("should include the main IP set ID in the temp name", func() {
+			name := ipsets.IPVersionConfig.NameForDebugTempIPSet(35, "abc123", v4MainIPSetName)
+			Expect(name).To(Equal(("cali4tz-abc123-" + strings.TrimPrefix(v4MainIPSetName, "cali40"))[:MaxIPSetNameLength]))
+		})
+	})
+
+	It("should clean up left-over debug temp sets from a previous run", func() {
+		dataplane.IPSetMembers["cali4t0-s3k9qz-s:qMt7iLlGDhvLnC"] = set.From("10.0.0.1")
+		ipsets = newTestIPSets(dataplane, IPFamilyV4)
+		apply()
+		Expect(dataplane.IPSetMembers).To(BeEmpty())
+	})
+})
+
+var _ = Describe("IP sets name mapping", func() {
+	const longSetID = "s:this-is-a-long-ip-set-id-that-gets-truncated"
+
+	var (
+		dataplane *mockDataplane
+		ipsets    *IPSets
+	)
+
+	BeforeEach(func() {
+		dataplane = newMockDataplane()
+		ipsets = newTestIPSets(dataplane, IPFamilyV4)
+		ipsets.AddOrReplaceIPSet(IPSetMetadata{MaxSize: 1234, SetID: ipSetID, Type: IPSetTypeHashIP}, []string{"10.0.0.1"})
+		ipsets.AddOrReplaceIPSet(IPSetMetadata{MaxSize: 1234, SetID: longSetID, Type: IPSetTypeHashIP}, []string{"10.0.0.2"})
+		ipsets.ApplyUpdates()
+	})
+
+	It("should translate between IP set IDs and kernel names", func() {
+		longName, ok := ipsets.IPSetNameForSetID(longSetID)
+		Expect(ok).To(BeTrue())
+		Expect(longName).To(HaveLen(MaxIPSetNameLength))
+		Expect(longSetID).To(HavePrefix(StripIPSetNamePrefix(longName)))
+		Expect(dataplane.IPSetMembers).To(HaveKey(longName))
+
+		setID, ok := ipsets.SetIDForIPSetName(longName)
+		Expect(ok).To(BeTrue())
+		Expect(setID).To(Equal(longSetID))
+
+		name, ok := ipsets.IPSetNameForSetID(ipSetID)
+		Expect(ok).To(BeTrue())
+		Expect(name).To(Equal(v4MainIPSetName))
+		setID, ok = ipsets.SetIDForIPSetName(v4MainIPSetName)
+		Expect(ok).To(BeTrue())
+		Expect(setID).To(Equal(ipSetID))
+	})
+
+	It("should not translate unknown names", func() {
+		_, ok := ipsets.SetIDForIPSetName("cali40unknown")
+		Expect(ok).To(BeFalse())
+		_, ok = ipsets.IPSetNameForSetID("unknown")
+		Expect(ok).To(BeFalse())
+	})
+
+	It("should export the mapping", func() {
+		longName, _ := ipsets.IPSetNameForSetID(longSetID)
+		Expect(ipsets.IPSetNames()).To(Equal(map[string]string{
+			ipSetID:   v4MainIPSetName,
+			longSetID: longName,
+		}))
+
+		var buf bytes.Buffer
+		Expect(ipsets.ExportIPSetNames(&buf)).To(Succeed())
+		var exported map[string]string
+		Expect(json.Unmarshal(buf.Bytes(), &exported)).To(Succeed())
+		Expect(exported).To(Equal(ipsets.IPSetNames()))
+	})
+
+	It("should prune the mapping when an IP set is removed", func() {
+		longName, _ := ipsets.IPSetNameForSetID(longSetID)
+		ipsets.RemoveIPSet(longSetID)
+
+		_, ok := ipsets.IPSetNameForSetID(longSetID)
+		Expect(ok).To(BeFalse())
+		_, ok = ipsets.SetIDForIPSetName(longName)
+		Expect(ok).To(BeFalse())
+		Expect(ipsets.IPSetNames()).To(Equal(map[string]string{ipSetID: v4MainIPSetName}))
+
+		By("restoring the mapping if the IP set is re-added")
+		ipsets.AddOrReplaceIPSet(IPSetMetadata{MaxSize: 1234, SetID: longSetID, Type: IPSetTypeHashIP}, nil)
+		setID, ok := ipsets.SetIDForIPSetName(longName)
+		Expect(ok).To(BeTrue())
+		Expect(setID).To(Equal(longSetID))
+	})
+})
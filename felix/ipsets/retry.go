@@ -0,0 +1,87 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipsets
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// ErrIPSetUpdatesFailed is returned by ApplyUpdatesChecked() if it fails to update the dataplane
+// after exhausting its retries.
+var ErrIPSetUpdatesFailed = errors.New("failed to update IP sets after multiple retries")
+
+// RetryConfig controls how ApplyUpdates() retries after a failure to resync with, or update, the
+// dataplane.  The backoff between attempts starts at InitialBackoff and doubles after each
+// failure, up to MaxBackoff.
+type RetryConfig struct {
+	// MaxRetries is the number of times to retry after the first attempt fails.
+	MaxRetries int
+	// InitialBackoff is the backoff after the first failure.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the backoff.
+	MaxBackoff time.Duration
+	// Jitter is the fraction, between 0 and 1, of each backoff that is added at random, so that
+	// several Felix instances that fail at the same time don't retry in lock-step.
+	Jitter float64
+}
+
+// DefaultRetryConfig is the RetryConfig used unless WithRetryConfig() is given.
+var DefaultRetryConfig = RetryConfig{
+	MaxRetries:     9,
+	InitialBackoff: time.Millisecond,
+	MaxBackoff:     time.Second,
+	Jitter:         0.1,
+}
+
+// WithRetryConfig sets the retry count and backoff used by ApplyUpdates().  Out-of-range values
+// are clamped: a negative MaxRetries is treated as 0, a MaxBackoff below InitialBackoff as
+// InitialBackoff, and the Jitter is limited to [0, 1].
+func WithRetryConfig(c RetryConfig) IPSetsOpt {
+	return func(s *IPSets) {
+		if c.MaxRetries < 0 {
+			c.MaxRetries = 0
+		}
+		if c.InitialBackoff <= 0 {
+			c.InitialBackoff = DefaultRetryConfig.InitialBackoff
+		}
+		if c.MaxBackoff < c.InitialBackoff {
+			c.MaxBackoff = c.InitialBackoff
+		}
+		if c.Jitter < 0 {
+			c.Jitter = 0
+		} else if c.Jitter > 1 {
+			c.Jitter = 1
+		}
+		s.retryConfig = c
+	}
+}
+
+// backoff returns the time to sleep after the given number of consecutive failures (starting
+// at 1).
+func (c RetryConfig) backoff(numFailures int) time.Duration {
+	d := c.InitialBackoff
+	for i := 1; i < numFailures && d < c.MaxBackoff; i++ {
+		d *= 2
+	}
+	if c.Jitter > 0 {
+		d += time.Duration(c.Jitter * rand.Float64() * float64(d))
+	}
+	if d > c.MaxBackoff {
+		d = c.MaxBackoff
+	}
+	return d
+}
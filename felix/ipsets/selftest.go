@@ -0,0 +1,125 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipsets
+
+import (
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// IPSetCapability is a feature of the ipset binary (and kernel) that SelfTest can check for.
+type IPSetCapability string
+
+const (
+	CapabilityHashIP      = IPSetCapability(IPSetTypeHashIP)
+	CapabilityHashIPPort  = IPSetCapability(IPSetTypeHashIPPort)
+	CapabilityHashNet     = IPSetCapability(IPSetTypeHashNet)
+	CapabilityHashNetNet  = IPSetCapability(IPSetTypeHashNetNet)
+	CapabilityHashNetPort = IPSetCapability(IPSetTypeHashNetPort)
+	CapabilityBitmapPort  = IPSetCapability(IPSetTypeBitmapPort)
+	// CapabilityComment is support for the "comment" create option, which allows a comment to
+	// be attached to each member.
+	CapabilityComment IPSetCapability = "comment"
+	// CapabilityCounters is support for the "counters" create option, which enables per-member
+	// packet and byte counters.
+	CapabilityCounters IPSetCapability = "counters"
+)
+
+// AllIPSetCapabilities contains all the capabilities that SelfTest can check for.
+var AllIPSetCapabilities = []IPSetCapability{
+	CapabilityHashIP,
+	CapabilityHashIPPort,
+	CapabilityHashNet,
+	CapabilityHashNetNet,
+	CapabilityHashNetPort,
+	CapabilityBitmapPort,
+	CapabilityComment,
+	CapabilityCounters,
+}
+
+// SelfTest checks whether the ipset binary supports each of the given capabilities (or, if none
+// are given, all of AllIPSetCapabilities) by creating, and then destroying, a small probe IP set
+// that uses it.  It returns whether each capability is supported, so that the caller can avoid
+// using unsupported features rather than failing when it programs the dataplane.
+//
+// The probe IP set has a temporary IP set name, so, if we fail to destroy it, it is cleaned up
+// by the next resync.
+func (s *IPSets) SelfTest(capabilities ...IPSetCapability) map[IPSetCapability]bool {
+	if len(capabilities) == 0 {
+		capabilities = AllIPSetCapabilities
+	}
+	probeName := s.IPVersionConfig.tempSetNamePrefix + "selftest"
+	results := map[IPSetCapability]bool{}
+	for _, capability := range capabilities {
+		results[capability] = s.probeCapability(probeName, capability)
+	}
+	s.logCxt.WithField("capabilities", results).Info("Completed ipset self-test.")
+	return results
+}
+
+// probeCapability creates and destroys a probe IP set that uses the given capability, returning
+// whether the create succeeded.
+func (s *IPSets) probeCapability(probeName string, capability IPSetCapability) bool {
+	logCxt := s.logCxt.WithFields(log.Fields{
+		"capability": capability,
+		"probeName":  probeName,
+	})
+	args, ok := s.probeCreateArgs(probeName, capability)
+	if !ok {
+		logCxt.Warning("Unknown ipset capability, treating as unsupported.")
+		return false
+	}
+
+	output, err := s.newCmd("ipset", args...).CombinedOutput()
+	if err != nil && strings.Contains(string(output), "already exists") {
+		// Left over from a previous self-test that failed to clean up.
+		logCxt.Info("Self-test probe IP set already exists, deleting it and retrying.")
+		if err := s.deleteIPSet(probeName); err != nil {
+			logCxt.WithError(err).Warning("Failed to delete left-over self-test probe IP set.")
+			return false
+		}
+		output, err = s.newCmd("ipset", args...).CombinedOutput()
+	}
+	if err != nil {
+		logCxt.WithError(err).WithField("output", string(output)).Warning(
+			"ipset capability is not supported.")
+		return false
+	}
+
+	if err := s.deleteIPSet(probeName); err != nil {
+		logCxt.WithError(err).Warning("Failed to delete self-test probe IP set.")
+	}
+	logCxt.Debug("ipset capability is supported.")
+	return true
+}
+
+// probeCreateArgs returns the arguments for an 'ipset create' command that creates a probe IP
+// set using the given capability.
+func (s *IPSets) probeCreateArgs(probeName string, capability IPSetCapability) ([]string, bool) {
+	hashArgs := func(t IPSetType, extra ...string) []string {
+		return append([]string{"create", probeName, string(t),
+			"family", string(s.IPVersionConfig.Family), "maxelem", "1"}, extra...)
+	}
+	switch capability {
+	case CapabilityHashIP, CapabilityHashIPPort, CapabilityHashNet, CapabilityHashNetNet, CapabilityHashNetPort:
+		return hashArgs(IPSetType(capability)), true
+	case CapabilityBitmapPort:
+		return []string{"create", probeName, string(IPSetTypeBitmapPort), "range", "0-1"}, true
+	case CapabilityComment, CapabilityCounters:
+		return hashArgs(IPSetTypeHashIP, string(capability)), true
+	}
+	return nil, false
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipsets
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// hashSizeArg returns the "hashsize" argument of an 'ipset create' line for the given hash size,
+// or "" to use ipset's default.
+func hashSizeArg(hashSize int) string {
+	if hashSize <= 0 {
+		return ""
+	}
+	return fmt.Sprintf(" hashsize %d", hashSize)
+}
+
+// listedHashSize returns the hash size to record for an IP set that 'ipset list' reported with
+// the given hashsize.  The kernel rounds the hash size up to a power of two and grows it as the
+// IP set fills up, so the listed value rarely matches the one that we asked for.  We only treat
+// it as different (which triggers a rewrite) if it's smaller than the hash size that we want.
+func (s *IPSets) listedHashSize(setName string, listed int) int {
+	desired, ok := s.setNameToProgrammedMetadata.Desired().Get(setName)
+	if !ok || desired.HashSize <= 0 {
+		return 0
+	}
+	if listed < desired.HashSize {
+		return listed
+	}
+	return desired.HashSize
+}
+
+// maybeGrowMaxSize increases the MaxSize of the given hash IP set if it has more desired members
+// than its MaxSize allows; otherwise 'ipset restore' would fail to add them.  The kernel can
+// only set the maxelem of an IP set when it's created so, like any other change to the metadata,
+// this makes us rewrite the IP set via a temporary IP set.
+func (s *IPSets) maybeGrowMaxSize(setName string) {
+	meta, ok := s.setNameToAllMetadata[setName]
+	if !ok || meta.Type == IPSetTypeBitmapPort || meta.MaxSize <= 0 {
+		return
+	}
+	members, ok := s.mainSetNameToMembers[setName]
+	if !ok {
+		return
+	}
+	numMembers := members.Dataplane().Len() + members.PendingUpdates().Len() - members.PendingDeletions().Len()
+	if numMembers <= meta.MaxSize {
+		return
+	}
+	newMaxSize := meta.MaxSize
+	for newMaxSize < numMembers {
+		newMaxSize *= 2
+	}
+	s.logCxt.WithFields(log.Fields{
+		"setName":    setName,
+		"numMembers": numMembers,
+		"oldMaxSize": meta.MaxSize,
+		"newMaxSize": newMaxSize,
+	}).Warning("IP set has more members than its MaxSize, growing it.")
+	meta.MaxSize = newMaxSize
+	s.setNameToAllMetadata[setName] = meta
+	if s.ipSetNeeded(setName) {
+		s.setNameToProgrammedMetadata.Desired().Set(setName, meta)
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipsets
+
+import (
+	"fmt"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/projectcalico/calico/felix/ip"
+)
+
+const (
+	// adviceMinMembers is the number of members at which a hash:ip IP set is large enough to
+	// be worth aggregating.
+	adviceMinMembers = 256
+	// adviceMinChurn is the number of member changes between calls to Advise() at which a
+	// hash:ip IP set churns enough to be worth aggregating, whatever its size.
+	adviceMinChurn = 256
+	// adviceMinMembersPerSubnet is the average number of members per subnet needed for
+	// aggregation to shrink an IP set significantly.
+	adviceMinMembersPerSubnet = 8
+)
+
+// adviceNetmasks contains the netmask that Advise() considers for each IP family.
+var adviceNetmasks = map[IPFamily]int{
+	IPFamilyV4: 24,
+	IPFamilyV6: 64,
+}
+
+// IPSetTypeAdvice is a recommendation, from Advise(), that a hash:ip IP set would be more
+// efficient as a hash:net IP set, aggregating its members into subnets with the given netmask.
+type IPSetTypeAdvice struct {
+	SetID   string
+	Netmask int
+	// Members is the number of members in the IP set.
+	Members int
+	// Subnets is the number of subnets, of size Netmask, that contain the members.
+	Subnets int
+	// Churn is the number of members added and removed since the previous call to Advise().
+	Churn int
+}
+
+func (a IPSetTypeAdvice) String() string {
+	s := fmt.Sprintf("consider netmask %d for set %s; %d members in %d /%ds",
+		a.Netmask, a.SetID, a.Members, a.Subnets, a.Netmask)
+	if a.Churn > 0 {
+		s += fmt.Sprintf(", %d member changes since last check", a.Churn)
+	}
+	return s
+}
+
+// Advise inspects the membership of each hash:ip IP set and recommends aggregating those whose
+// members are concentrated in a few subnets, if they are large or their membership has churned
+// heavily since the previous call.  Aggregation shrinks such IP sets and means that most member
+// changes no longer need an update.  The advice is purely informational: it is logged, counted
+// by the felix_ipsets_type_advice metric and returned, but the IP sets are left unchanged.
+func (s *IPSets) Advise() []IPSetTypeAdvice {
+	netmask := adviceNetmasks[s.IPVersionConfig.Family]
+	var advice []IPSetTypeAdvice
+	for setName, meta := range s.setNameToAllMetadata {
+		if meta.Type != IPSetTypeHashIP || s.isCanaryIPSet(setName) {
+			continue
+		}
+		numMembers := 0
+		subnets := map[ip.CIDR]struct{}{}
+		s.mainSetNameToMembers[setName].Desired().Iter(func(m IPSetMember) {
+			addr, ok := m.(ip.Addr)
+			if !ok {
+				return
+			}
+			numMembers++
+			subnets[ip.CIDRFromAddrAndPrefix(addr, netmask)] = struct{}{}
+		})
+		churn := s.setNameToChurn[setName]
+		if numMembers == 0 || numMembers < adviceMinMembersPerSubnet*len(subnets) {
+			continue
+		}
+		if numMembers < adviceMinMembers && churn < adviceMinChurn {
+			continue
+		}
+		advice = append(advice, IPSetTypeAdvice{
+			SetID:   s.mainSetNameToSetID[setName],
+			Netmask: netmask,
+			Members: numMembers,
+			Subnets: len(subnets),
+			Churn:   churn,
+		})
+	}
+	s.setNameToChurn = map[string]int{}
+
+	sort.Slice(advice, func(i, j int) bool {
+		return advice[i].SetID < advice[j].SetID
+	})
+	for _, a := range advice {
+		s.logCxt.WithFields(log.Fields{
+			"setID":   a.SetID,
+			"netmask": a.Netmask,
+			"members": a.Members,
+			"subnets": a.Subnets,
+			"churn":   a.Churn,
+		}).Info(a.String())
+	}
+	s.gaugeTypeAdvice.Set(float64(len(advice)))
+	return advice
+}
+
+// recordChurn counts member changes to an IP set, for Advise().
+func (s *IPSets) recordChurn(setName string, numChanges int) {
+	if numChanges == 0 {
+		return
+	}
+	s.setNameToChurn[setName] += numChanges
+}
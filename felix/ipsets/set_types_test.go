@@ -0,0 +1,921 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipsets_test
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	log "github.com/sirupsen/logrus"
+	logrustest "github.com/sirupsen/logrus/hooks/test"
+
+	. "github.com/projectcalico/calico/felix/ipsets"
+	"github.com/projectcalico/calico/libcalico-go/lib/set"
+)
+
+var _ = Describe("hash:ip,port IP sets", func() {
+	var (
+		dataplane *mockDataplane
+		ipsets    *IPSets
+		mainName  string
+	)
+
+	meta := IPSetMetadata{
+		MaxSize: 1234,
+		SetID:   "s:ipport",
+		Type:    IPSetTypeHashIPPort,
+	}
+	members := []string{"10.0.0.1,tcp:80", "10.0.0.2,UDP:53", "fd00::1,tcp:80", "fd00::2,sctp:9000"}
+
+	newIPSets := func(family IPFamily) {
+		dataplane = newMockDataplane()
+		ipsets = newTestIPSets(dataplane, family)
+		mainName = ipsets.IPVersionConfig.NameForMainIPSet(meta.SetID)
+	}
+
+	It("should program the v4 members of an IPv4 IP set", func() {
+		newIPSets(IPFamilyV4)
+		ipsets.AddOrReplaceIPSet(meta, members)
+		ipsets.ApplyUpdates()
+		Expect(dataplane.LinesExecuted).To(ConsistOf(
+			"create "+mainName+" hash:ip,port family inet maxelem 1234",
+			"add "+mainName+" 10.0.0.1,tcp:80",
+			"add "+mainName+" 10.0.0.2,udp:53",
+			"COMMIT",
+		))
+		dataplane.ExpectMembers(map[string][]string{
+			mainName: {"10.0.0.1,tcp:80", "10.0.0.2,udp:53"},
+		})
+	})
+
+	It("should program the v6 members of an IPv6 IP set", func() {
+		newIPSets(IPFamilyV6)
+		ipsets.AddOrReplaceIPSet(meta, members)
+		ipsets.ApplyUpdates()
+		Expect(dataplane.LinesExecuted).To(ConsistOf(
+			"create "+mainName+" hash:ip,port family inet6 maxelem 1234",
+			"add "+mainName+" fd00::1,tcp:80",
+			"add "+mainName+" fd00::2,sctp:9000",
+			"COMMIT",
+		))
+
+		ipsets.RemoveMembers(meta.SetID, []string{"fd00::1,tcp:80"})
+		ipsets.AddMembers(meta.SetID, []string{"fd00::3,udp:53", "10.0.0.3,udp:53"})
+		ipsets.ApplyUpdates()
+		dataplane.ExpectMembers(map[string][]string{
+			mainName: {"fd00::2,sctp:9000", "fd00::3,udp:53"},
+		})
+	})
+
+	DescribeTable("rejecting malformed members",
+		func(family IPFamily, bad string) {
+			newIPSets(family)
+			Expect(ipsets.AddOrReplaceIPSetChecked(meta, members)).To(Succeed())
+
+			var invalidErr *InvalidMembersError
+			err := ipsets.AddMembersChecked(meta.SetID, []string{bad})
+			Expect(errors.As(err, &invalidErr)).To(BeTrue(), "Expected an *InvalidMembersError")
+			Expect(invalidErr.SetID).To(Equal(meta.SetID))
+			Expect(invalidErr.Members).To(Equal([]string{bad}))
+
+			err = ipsets.AddOrReplaceIPSetChecked(meta, append([]string{bad}, members...))
+			Expect(errors.As(err, &invalidErr)).To(BeTrue(), "Expected an *InvalidMembersError")
+
+			By("Dropping the member from the unchecked methods, rather than panicking")
+			ipsets.AddMembers(meta.SetID, []string{bad})
+			ipsets.ApplyUpdates()
+			Expect(dataplane.IPSetMembers[mainName].Len()).To(Equal(2))
+		},
+		Entry("v4 IP only", IPFamilyV4, "10.0.0.1"),
+		Entry("v4 missing port", IPFamilyV4, "10.0.0.1,tcp"),
+		Entry("v4 unknown protocol", IPFamilyV4, "10.0.0.1,icmp:80"),
+		Entry("v4 port out of range", IPFamilyV4, "10.0.0.1,tcp:65536"),
+		Entry("v4 CIDR", IPFamilyV4, "10.0.0.0/24,tcp:80"),
+		Entry("v6 IP only", IPFamilyV6, "fd00::1"),
+		Entry("v6 bad port", IPFamilyV6, "fd00::1,udp:http"),
+		Entry("v6 extra field", IPFamilyV6, "fd00::1,tcp:80:81"),
+	)
+})
+
+var _ = Describe("hash:net,port IP sets", func() {
+	var (
+		dataplane *mockDataplane
+		ipsets    *IPSets
+		mainName  string
+	)
+
+	meta := IPSetMetadata{
+		MaxSize: 1234,
+		SetID:   "s:netport",
+		Type:    IPSetTypeHashNetPort,
+	}
+	members := []string{"10.0.0.0/24,tcp:443", "10.1.2.3/16,UDP:53", "fd00::/64,udp:53", "fd00:1::1/128,tcp:443"}
+
+	newIPSets := func(family IPFamily) {
+		dataplane = newMockDataplane()
+		ipsets = newTestIPSets(dataplane, family)
+		mainName = ipsets.IPVersionConfig.NameForMainIPSet(meta.SetID)
+	}
+
+	It("should program the v4 members of an IPv4 IP set", func() {
+		newIPSets(IPFamilyV4)
+		ipsets.AddOrReplaceIPSet(meta, members)
+		ipsets.ApplyUpdates()
+		Expect(dataplane.LinesExecuted).To(Equal([]string{
+			"create " + mainName + " hash:net,port family inet maxelem 1234",
+			"add " + mainName + " 10.0.0.0/24,tcp:443",
+			"add " + mainName + " 10.1.0.0/16,udp:53",
+			"COMMIT",
+		}))
+		dataplane.ExpectMembers(map[string][]string{
+			mainName: {"10.0.0.0/24,tcp:443", "10.1.0.0/16,udp:53"},
+		})
+	})
+
+	It("should program the v6 members of an IPv6 IP set", func() {
+		newIPSets(IPFamilyV6)
+		ipsets.AddOrReplaceIPSet(meta, members)
+		ipsets.ApplyUpdates()
+		Expect(dataplane.LinesExecuted).To(Equal([]string{
+			"create " + mainName + " hash:net,port family inet6 maxelem 1234",
+			"add " + mainName + " fd00:1::1/128,tcp:443",
+			"add " + mainName + " fd00::/64,udp:53",
+			"COMMIT",
+		}))
+
+		ipsets.RemoveMembers(meta.SetID, []string{"fd00::/64,udp:53"})
+		ipsets.AddMembers(meta.SetID, []string{"fd00:2::/48,sctp:9000", "10.0.0.0/24,udp:53"})
+		ipsets.ApplyUpdates()
+		dataplane.ExpectMembers(map[string][]string{
+			mainName: {"fd00:1::1/128,tcp:443", "fd00:2::/48,sctp:9000"},
+		})
+	})
+
+	It("should match host members listed without a prefix length on resync", func() {
+		newIPSets(IPFamilyV4)
+		// 'ipset list' shows full-length networks without the prefix length.
+		dataplane.IPSetMembers[mainName] = set.From("10.0.0.1,tcp:443")
+		dataplane.IPSetMetadata[mainName] = setMetadata{
+			Name:    mainName,
+			Family:  IPFamilyV4,
+			Type:    IPSetTypeHashNetPort,
+			MaxSize: 1234,
+		}
+		ipsets.AddOrReplaceIPSet(meta, []string{"10.0.0.1/32,tcp:443", "10.0.0.0/24,tcp:443"})
+		ipsets.ApplyUpdates()
+		Expect(dataplane.LinesExecuted).To(Equal([]string{
+			"add " + mainName + " 10.0.0.0/24,tcp:443",
+			"COMMIT",
+		}))
+	})
+
+	DescribeTable("rejecting malformed members",
+		func(family IPFamily, bad string) {
+			newIPSets(family)
+			Expect(ipsets.AddOrReplaceIPSetChecked(meta, members)).To(Succeed())
+
+			var invalidErr *InvalidMembersError
+			err := ipsets.AddMembersChecked(meta.SetID, []string{bad})
+			Expect(errors.As(err, &invalidErr)).To(BeTrue(), "Expected an *InvalidMembersError")
+			Expect(invalidErr.SetID).To(Equal(meta.SetID))
+			Expect(invalidErr.Members).To(Equal([]string{bad}))
+
+			err = ipsets.AddOrReplaceIPSetChecked(meta, append([]string{bad}, members...))
+			Expect(errors.As(err, &invalidErr)).To(BeTrue(), "Expected an *InvalidMembersError")
+
+			By("Dropping the member from the unchecked methods, rather than panicking")
+			ipsets.AddMembers(meta.SetID, []string{bad})
+			ipsets.ApplyUpdates()
+			Expect(dataplane.IPSetMembers[mainName].Len()).To(Equal(2))
+		},
+		Entry("v4 no prefix length", IPFamilyV4, "10.0.0.1,tcp:443"),
+		Entry("v4 prefix length too long", IPFamilyV4, "10.0.0.0/33,tcp:443"),
+		Entry("v4 zero prefix length", IPFamilyV4, "0.0.0.0/0,tcp:443"),
+		Entry("v4 CIDR only", IPFamilyV4, "10.0.0.0/24"),
+		Entry("v4 missing port", IPFamilyV4, "10.0.0.0/24,tcp"),
+		Entry("v4 unknown protocol", IPFamilyV4, "10.0.0.0/24,icmp:80"),
+		Entry("v6 no prefix length", IPFamilyV6, "fd00::1,udp:53"),
+		Entry("v6 bad prefix length", IPFamilyV6, "fd00::/x,udp:53"),
+		Entry("v6 port out of range", IPFamilyV6, "fd00::/64,udp:65536"),
+	)
+})
+
+var _ = Describe("list:set IP sets", func() {
+	var (
+		dataplane *mockDataplane
+		ipsets    *IPSets
+		listName  string
+	)
+
+	listMeta := IPSetMetadata{
+		SetID: "s:all",
+		Type:  IPSetTypeListSet,
+	}
+
+	newIPSets := func(family IPFamily) {
+		dataplane = newMockDataplane()
+		ipsets = newTestIPSets(dataplane, family)
+		listName = ipsets.IPVersionConfig.NameForMainIPSet(listMeta.SetID)
+	}
+
+	BeforeEach(func() {
+		newIPSets(IPFamilyV4)
+	})
+
+	addMemberSets := func() {
+		ipsets.AddOrReplaceIPSet(IPSetMetadata{MaxSize: 1234, SetID: ipSetID, Type: IPSetTypeHashIP}, []string{"10.0.0.1"})
+		ipsets.AddOrReplaceIPSet(IPSetMetadata{MaxSize: 1234, SetID: ipSetID2, Type: IPSetTypeHashIP}, []string{"10.0.0.2"})
+	}
+
+	It("should create the list:set after the IP sets that it refers to", func() {
+		// The list:set's name sorts first but it must be created last.
+		Expect(listName < v4MainIPSetName).To(BeTrue())
+		addMemberSets()
+		ipsets.AddOrReplaceIPSet(listMeta, []string{ipSetID, ipSetID2})
+		ipsets.ApplyUpdates()
+		Expect(dataplane.LinesExecuted).To(Equal([]string{
+			"create " + v4MainIPSetName + " hash:ip family inet maxelem 1234",
+			"add " + v4MainIPSetName + " 10.0.0.1",
+			"create " + v4MainIPSetName2 + " hash:ip family inet maxelem 1234",
+			"add " + v4MainIPSetName2 + " 10.0.0.2",
+			"create " + listName + " list:set",
+			"add " + listName + " " + v4MainIPSetName,
+			"add " + listName + " " + v4MainIPSetName2,
+			"COMMIT",
+		}))
+		dataplane.ExpectMembers(map[string][]string{
+			v4MainIPSetName:  {"10.0.0.1"},
+			v4MainIPSetName2: {"10.0.0.2"},
+			listName:         {v4MainIPSetName, v4MainIPSetName2},
+		})
+		Expect(ipsets.GetMembers(listMeta.SetID)).To(Equal([]string{v4MainIPSetName, v4MainIPSetName2}))
+
+		By("not rewriting the list:set on resync")
+		dataplane.LinesExecuted = nil
+		ipsets.QueueResync()
+		ipsets.ApplyUpdates()
+		Expect(dataplane.LinesExecuted).To(BeEmpty())
+
+		By("removing members by IP set ID")
+		ipsets.RemoveMembers(listMeta.SetID, []string{ipSetID})
+		ipsets.ApplyUpdates()
+		Expect(dataplane.LinesExecuted).To(Equal([]string{
+			"del " + listName + " " + v4MainIPSetName + " --exist",
+			"COMMIT",
+		}))
+	})
+
+	It("should rewrite the list:set via a temporary IP set when its size changes", func() {
+		addMemberSets()
+		ipsets.AddOrReplaceIPSet(listMeta, []string{ipSetID, ipSetID2})
+		ipsets.ApplyUpdates()
+		dataplane.LinesExecuted = nil
+
+		bigger := listMeta
+		bigger.MaxSize = 16
+		ipsets.AddOrReplaceIPSet(bigger, []string{ipSetID, ipSetID2})
+		ipsets.ApplyUpdates()
+		Expect(dataplane.LinesExecuted).To(Equal([]string{
+			"create cali4t0 list:set size 16",
+			"add cali4t0 " + v4MainIPSetName,
+			"add cali4t0 " + v4MainIPSetName2,
+			"swap " + listName + " cali4t0",
+			"COMMIT",
+		}))
+		Expect(dataplane.IPSetMetadata[listName].MaxSize).To(Equal(16))
+		ipsets.ApplyDeletions()
+		dataplane.ExpectMembers(map[string][]string{
+			v4MainIPSetName:  {"10.0.0.1"},
+			v4MainIPSetName2: {"10.0.0.2"},
+			listName:         {v4MainIPSetName, v4MainIPSetName2},
+		})
+
+		By("not rewriting the list:set again on resync")
+		dataplane.LinesExecuted = nil
+		ipsets.QueueResync()
+		ipsets.ApplyUpdates()
+		Expect(dataplane.LinesExecuted).To(BeEmpty())
+	})
+
+	It("should not filter the members of a list:set by IP family", func() {
+		newIPSets(IPFamilyV6)
+		v6Name := NewIPVersionConfig(IPFamilyV6, "cali", nil, nil, nil).NameForMainIPSet(ipSetID)
+		ipsets.AddOrReplaceIPSet(IPSetMetadata{MaxSize: 1234, SetID: ipSetID, Type: IPSetTypeHashIP}, []string{"fd00::1"})
+		ipsets.AddOrReplaceIPSet(listMeta, []string{ipSetID})
+		ipsets.ApplyUpdates()
+		dataplane.ExpectMembers(map[string][]string{
+			v6Name:   {"fd00::1"},
+			listName: {v6Name},
+		})
+	})
+})
+
+var _ = Describe("IP set exceptions", func() {
+	var dataplane *mockDataplane
+	var ipsets *IPSets
+
+	meta := IPSetMetadata{MaxSize: 1234, SetID: ipSetID, Type: IPSetTypeHashNet}
+
+	BeforeEach(func() {
+		dataplane = newMockDataplane()
+		ipsets = newTestIPSets(dataplane, IPFamilyV4)
+	})
+
+	It("should write a mix of normal and nomatch members", func() {
+		ipsets.AddOrReplaceIPSet(meta, []string{"10.0.0.0/16", "10.1.0.0/16"})
+		Expect(ipsets.AddExceptions(ipSetID, []string{"10.0.2.0/24", "10.0.1.0/24"})).To(Succeed())
+		ipsets.ApplyUpdates()
+
+		Expect(dataplane.LinesExecuted).To(Equal([]string{
+			"create " + v4MainIPSetName + " hash:net family inet maxelem 1234",
+			"add " + v4MainIPSetName + " 10.0.0.0/16",
+			"add " + v4MainIPSetName + " 10.1.0.0/16",
+			"add " + v4MainIPSetName + " 10.0.1.0/24 nomatch",
+			"add " + v4MainIPSetName + " 10.0.2.0/24 nomatch",
+			"COMMIT",
+		}))
+		dataplane.ExpectMembers(map[string][]string{
+			v4MainIPSetName: {"10.0.0.0/16", "10.0.1.0/24 nomatch", "10.0.2.0/24 nomatch", "10.1.0.0/16"},
+		})
+
+		By("not rewriting the IP set on resync")
+		dataplane.LinesExecuted = nil
+		ipsets.QueueResync()
+		ipsets.ApplyUpdates()
+		Expect(dataplane.LinesExecuted).To(BeEmpty())
+
+		By("deleting an exception without the nomatch flag")
+		Expect(ipsets.RemoveExceptions(ipSetID, []string{"10.0.1.0/24"})).To(Succeed())
+		ipsets.ApplyUpdates()
+		Expect(dataplane.LinesExecuted).To(Equal([]string{
+			"del " + v4MainIPSetName + " 10.0.1.0/24 --exist",
+			"COMMIT",
+		}))
+		dataplane.ExpectMembers(map[string][]string{
+			v4MainIPSetName: {"10.0.0.0/16", "10.0.2.0/24 nomatch", "10.1.0.0/16"},
+		})
+	})
+
+	It("should reject exceptions for other types of IP set", func() {
+		ipsets.AddOrReplaceIPSet(IPSetMetadata{MaxSize: 1234, SetID: ipSetID, Type: IPSetTypeHashIP}, nil)
+		Expect(ipsets.AddExceptions(ipSetID, []string{"10.0.1.0/24"})).NotTo(Succeed())
+	})
+
+	It("should reject exceptions for an unknown IP set", func() {
+		Expect(ipsets.AddExceptions(ipSetID, []string{"10.0.1.0/24"})).NotTo(Succeed())
+	})
+
+	It("should reject malformed exceptions", func() {
+		ipsets.AddOrReplaceIPSet(meta, nil)
+		Expect(ipsets.AddExceptions(ipSetID, []string{"10.0.1.0/24", "foobar"})).NotTo(Succeed())
+		members, err := ipsets.GetMembers(ipSetID)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(members).To(BeEmpty())
+	})
+
+	It("should reject an exception for a CIDR that is a member", func() {
+		ipsets.AddOrReplaceIPSet(meta, []string{"10.0.1.0/24"})
+		Expect(ipsets.AddExceptions(ipSetID, []string{"10.0.2.0/24", "10.0.1.0/24"})).NotTo(Succeed())
+		Expect(ipsets.GetMembers(ipSetID)).To(Equal([]string{"10.0.1.0/24"}))
+	})
+
+	It("should reject a checked member for a CIDR that is an exception", func() {
+		ipsets.AddOrReplaceIPSet(meta, nil)
+		Expect(ipsets.AddExceptions(ipSetID, []string{"10.0.1.0/24"})).To(Succeed())
+		Expect(ipsets.AddMembersChecked(ipSetID, []string{"10.0.1.0/24"})).NotTo(Succeed())
+		Expect(ipsets.GetMembers(ipSetID)).To(Equal([]string{"10.0.1.0/24 nomatch"}))
+	})
+
+	It("should replace an exception with an unchecked member for the same CIDR", func() {
+		ipsets.AddOrReplaceIPSet(meta, nil)
+		Expect(ipsets.AddExceptions(ipSetID, []string{"10.0.1.0/24"})).To(Succeed())
+		ipsets.ApplyUpdates()
+		dataplane.LinesExecuted = nil
+
+		ipsets.AddMembers(ipSetID, []string{"10.0.1.0/24"})
+		ipsets.ApplyUpdates()
+		Expect(dataplane.LinesExecuted).To(Equal([]string{
+			"del " + v4MainIPSetName + " 10.0.1.0/24 --exist",
+			"add " + v4MainIPSetName + " 10.0.1.0/24",
+			"COMMIT",
+		}))
+		dataplane.ExpectMembers(map[string][]string{v4MainIPSetName: {"10.0.1.0/24"}})
+	})
+
+	It("should keep exceptions when the IP set is replaced", func() {
+		ipsets.AddOrReplaceIPSet(meta, []string{"10.0.0.0/16"})
+		Expect(ipsets.AddExceptions(ipSetID, []string{"10.0.1.0/24"})).To(Succeed())
+		ipsets.ApplyUpdates()
+		dataplane.LinesExecuted = nil
+
+		ipsets.AddOrReplaceIPSet(meta, []string{"10.0.0.0/16", "10.1.0.0/16"})
+		ipsets.ApplyUpdates()
+		Expect(dataplane.LinesExecuted).To(Equal([]string{
+			"add " + v4MainIPSetName + " 10.1.0.0/16",
+			"COMMIT",
+		}))
+		dataplane.ExpectMembers(map[string][]string{
+			v4MainIPSetName: {"10.0.0.0/16", "10.0.1.0/24 nomatch", "10.1.0.0/16"},
+		})
+	})
+
+	It("should replace an exception with a member for the same CIDR when the IP set is replaced", func() {
+		ipsets.AddOrReplaceIPSet(meta, []string{"10.0.0.0/16"})
+		Expect(ipsets.AddExceptions(ipSetID, []string{"10.0.1.0/24", "10.0.2.0/24"})).To(Succeed())
+		ipsets.AddOrReplaceIPSet(meta, []string{"10.0.0.0/16", "10.0.1.0/24"})
+		Expect(ipsets.GetMembers(ipSetID)).To(Equal([]string{"10.0.0.0/16", "10.0.1.0/24", "10.0.2.0/24 nomatch"}))
+
+		By("not bringing the exception back on a later replace")
+		ipsets.AddOrReplaceIPSet(meta, []string{"10.0.0.0/16"})
+		Expect(ipsets.GetMembers(ipSetID)).To(Equal([]string{"10.0.0.0/16", "10.0.2.0/24 nomatch"}))
+	})
+
+	It("should drop exceptions when the IP set is replaced with another type or removed", func() {
+		ipsets.AddOrReplaceIPSet(meta, nil)
+		Expect(ipsets.AddExceptions(ipSetID, []string{"10.0.1.0/24"})).To(Succeed())
+		ipsets.AddOrReplaceIPSet(IPSetMetadata{MaxSize: 1234, SetID: ipSetID, Type: IPSetTypeHashIP}, nil)
+		Expect(ipsets.GetMembers(ipSetID)).To(BeEmpty())
+		ipsets.AddOrReplaceIPSet(meta, nil)
+		Expect(ipsets.GetMembers(ipSetID)).To(BeEmpty())
+
+		Expect(ipsets.AddExceptions(ipSetID, []string{"10.0.1.0/24"})).To(Succeed())
+		ipsets.RemoveIPSet(ipSetID)
+		ipsets.AddOrReplaceIPSet(meta, nil)
+		Expect(ipsets.GetMembers(ipSetID)).To(BeEmpty())
+	})
+
+	It("should ignore exceptions of the wrong IP family", func() {
+		ipsets.AddOrReplaceIPSet(meta, nil)
+		Expect(ipsets.AddExceptions(ipSetID, []string{"fe80::/64"})).To(Succeed())
+		Expect(ipsets.GetMembers(ipSetID)).To(BeEmpty())
+	})
+})
+
+var _ = Describe("IP set counters", func() {
+	var dataplane *mockDataplane
+	var ipsets *IPSets
+
+	meta := IPSetMetadata{MaxSize: 1234, SetID: ipSetID, Type: IPSetTypeHashIP, Counters: true}
+
+	BeforeEach(func() {
+		dataplane = newMockDataplane()
+		ipsets = newTestIPSets(dataplane, IPFamilyV4)
+		ipsets.AddOrReplaceIPSet(meta, []string{"10.0.0.1", "10.0.0.2"})
+		ipsets.ApplyUpdates()
+	})
+
+	It("should create the IP set with counters", func() {
+		Expect(dataplane.LinesExecuted).To(ContainElement(
+			"create " + v4MainIPSetName + " hash:ip family inet maxelem 1234 counters"))
+		Expect(dataplane.IPSetMetadata[v4MainIPSetName].Counters).To(BeTrue())
+	})
+
+	It("should not rewrite the IP set on resync", func() {
+		numRestores := dataplane.NumRestoreCalls()
+		ipsets.QueueResync()
+		ipsets.ApplyUpdates()
+		Expect(dataplane.NumRestoreCalls()).To(Equal(numRestores))
+	})
+
+	It("should read back the members' counters", func() {
+		dataplane.IPSetMemberCounters[v4MainIPSetName] = map[string]MemberCounters{
+			"10.0.0.1": {Packets: 10, Bytes: 840},
+		}
+		counters, err := ipsets.GetMemberCounters(ipSetID)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(counters).To(Equal(map[string]MemberCounters{
+			"10.0.0.1": {Packets: 10, Bytes: 840},
+			"10.0.0.2": {},
+		}))
+	})
+
+	It("should return an error for an IP set without counters", func() {
+		ipsets.AddOrReplaceIPSet(IPSetMetadata{MaxSize: 1234, SetID: ipSetID2, Type: IPSetTypeHashIP}, nil)
+		ipsets.ApplyUpdates()
+		_, err := ipsets.GetMemberCounters(ipSetID2)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should return an error for an unknown IP set", func() {
+		_, err := ipsets.GetMemberCounters("unknown")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("should carry the counters over when rewriting the IP set", func() {
+		dataplane.IPSetMemberCounters[v4MainIPSetName] = map[string]MemberCounters{
+			"10.0.0.1": {Packets: 10, Bytes: 840},
+		}
+		dataplane.LinesExecuted = nil
+		resizedMeta := meta
+		resizedMeta.MaxSize = 2345
+		ipsets.AddOrReplaceIPSet(resizedMeta, []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"})
+		ipsets.ApplyUpdates()
+
+		Expect(dataplane.LinesExecuted).To(ContainElements(
+			"create "+v4TempIPSetName0+" hash:ip family inet maxelem 2345 counters",
+			"add "+v4TempIPSetName0+" 10.0.0.1 packets 10 bytes 840",
+			"add "+v4TempIPSetName0+" 10.0.0.2",
+			"swap "+v4MainIPSetName+" "+v4TempIPSetName0,
+		))
+		counters, err := ipsets.GetMemberCounters(ipSetID)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(counters).To(Equal(map[string]MemberCounters{
+			"10.0.0.1": {Packets: 10, Bytes: 840},
+			"10.0.0.2": {},
+			"10.0.0.3": {},
+		}))
+	})
+
+	It("should rewrite the IP set when counters are turned off", func() {
+		dataplane.LinesExecuted = nil
+		noCountersMeta := meta
+		noCountersMeta.Counters = false
+		ipsets.AddOrReplaceIPSet(noCountersMeta, []string{"10.0.0.1", "10.0.0.2"})
+		ipsets.ApplyUpdates()
+
+		Expect(dataplane.LinesExecuted).To(ContainElement(
+			"create " + v4TempIPSetName0 + " hash:ip family inet maxelem 1234"))
+		Expect(dataplane.IPSetMetadata[v4MainIPSetName].Counters).To(BeFalse())
+	})
+})
+
+var _ = Describe("IP set timeouts", func() {
+	var (
+		dataplane *mockDataplane
+		ipsets    *IPSets
+		mainName  string
+	)
+
+	meta := IPSetMetadata{
+		MaxSize: 1234,
+		SetID:   "s:timeout",
+		Type:    IPSetTypeHashIP,
+	}
+	timeoutMeta := meta
+	timeoutMeta.Timeout = 10 * time.Minute
+
+	BeforeEach(func() {
+		dataplane = newMockDataplane()
+		ipsets = newTestIPSets(dataplane, IPFamilyV4)
+		mainName = ipsets.IPVersionConfig.NameForMainIPSet(meta.SetID)
+	})
+
+	It("should generate the same restore input as before for an IP set without a timeout", func() {
+		ipsets.AddOrReplaceIPSet(meta, []string{"10.0.0.1", "10.0.0.2"})
+		ipsets.ApplyUpdates()
+		Expect(dataplane.LinesExecuted).To(Equal([]string{
+			"create " + mainName + " hash:ip family inet maxelem 1234",
+			"add " + mainName + " 10.0.0.1",
+			"add " + mainName + " 10.0.0.2",
+			"COMMIT",
+		}))
+	})
+
+	It("should reject per-member timeouts for an IP set without a timeout", func() {
+		ipsets.AddOrReplaceIPSet(meta, []string{"10.0.0.1"})
+		err := ipsets.AddMembersWithTimeout(meta.SetID, []string{"10.0.0.2"}, time.Minute)
+		Expect(err).To(HaveOccurred())
+		ipsets.ApplyUpdates()
+		dataplane.ExpectMembers(map[string][]string{
+			mainName: {"10.0.0.1"},
+		})
+	})
+
+	It("should create an IP set with a default timeout", func() {
+		ipsets.AddOrReplaceIPSet(timeoutMeta, []string{"10.0.0.1"})
+		ipsets.ApplyUpdates()
+		Expect(dataplane.LinesExecuted).To(Equal([]string{
+			"create " + mainName + " hash:ip family inet maxelem 1234 timeout 600",
+			"add " + mainName + " 10.0.0.1",
+			"COMMIT",
+		}))
+	})
+
+	It("should create a bitmap:port IP set with a default timeout", func() {
+		bitmapMeta := IPSetMetadata{
+			SetID:    meta.SetID,
+			Type:     IPSetTypeBitmapPort,
+			RangeMin: 0,
+			RangeMax: 1024,
+			Timeout:  90 * time.Second,
+		}
+		ipsets.AddOrReplaceIPSet(bitmapMeta, []string{"80"})
+		ipsets.ApplyUpdates()
+		Expect(dataplane.LinesExecuted).To(Equal([]string{
+			"create " + mainName + " bitmap:port range 0-1024 timeout 90",
+			"add " + mainName + " 80",
+			"COMMIT",
+		}))
+	})
+
+	It("should add members with per-member timeouts", func() {
+		ipsets.AddOrReplaceIPSet(timeoutMeta, []string{"10.0.0.1"})
+		ipsets.ApplyUpdates()
+		dataplane.LinesExecuted = nil
+
+		Expect(ipsets.AddMembersWithTimeout(meta.SetID, []string{"10.0.0.2", "10.0.0.3"}, 1500*time.Millisecond)).To(Succeed())
+		ipsets.ApplyUpdates()
+		Expect(dataplane.LinesExecuted).To(Equal([]string{
+			"add " + mainName + " 10.0.0.2 timeout 2",
+			"add " + mainName + " 10.0.0.3 timeout 2",
+			"COMMIT",
+		}))
+		dataplane.ExpectMembers(map[string][]string{
+			mainName: {"10.0.0.1", "10.0.0.2", "10.0.0.3"},
+		})
+	})
+
+	It("should reset the timeout of an existing member", func() {
+		ipsets.AddOrReplaceIPSet(timeoutMeta, []string{"10.0.0.1"})
+		ipsets.ApplyUpdates()
+		dataplane.LinesExecuted = nil
+
+		Expect(ipsets.AddMembersWithTimeout(meta.SetID, []string{"10.0.0.1"}, time.Minute)).To(Succeed())
+		ipsets.ApplyUpdates()
+		Expect(dataplane.LinesExecuted).To(Equal([]string{
+			"add " + mainName + " 10.0.0.1 timeout 60 --exist",
+			"COMMIT",
+		}))
+	})
+
+	It("should not rewrite an IP set with a timeout on resync", func() {
+		ipsets.AddOrReplaceIPSet(timeoutMeta, []string{"10.0.0.1"})
+		Expect(ipsets.AddMembersWithTimeout(meta.SetID, []string{"10.0.0.2"}, time.Minute)).To(Succeed())
+		ipsets.ApplyUpdates()
+		dataplane.LinesExecuted = nil
+
+		ipsets.QueueResync()
+		ipsets.ApplyUpdates()
+		Expect(dataplane.LinesExecuted).To(BeEmpty())
+		dataplane.ExpectMembers(map[string][]string{
+			mainName: {"10.0.0.1", "10.0.0.2"},
+		})
+	})
+
+	It("should rewrite the IP set when its timeout changes", func() {
+		ipsets.AddOrReplaceIPSet(meta, []string{"10.0.0.1"})
+		ipsets.ApplyUpdates()
+		dataplane.LinesExecuted = nil
+
+		ipsets.AddOrReplaceIPSet(timeoutMeta, []string{"10.0.0.1"})
+		ipsets.ApplyUpdates()
+		Expect(dataplane.LinesExecuted).To(Equal([]string{
+			"create cali4t0 hash:ip family inet maxelem 1234 timeout 600",
+			"add cali4t0 10.0.0.1",
+			"swap " + mainName + " cali4t0",
+			"COMMIT",
+		}))
+	})
+})
+
+var _ = Describe("IP set hashsize and maxelem", func() {
+	var (
+		dataplane *mockDataplane
+		ipsets    *IPSets
+		mainName  string
+	)
+
+	meta := IPSetMetadata{
+		MaxSize:  1234,
+		HashSize: 3000,
+		SetID:    "s:size",
+		Type:     IPSetTypeHashIP,
+	}
+
+	BeforeEach(func() {
+		dataplane = newMockDataplane()
+		ipsets = newTestIPSets(dataplane, IPFamilyV4)
+		mainName = ipsets.IPVersionConfig.NameForMainIPSet(meta.SetID)
+	})
+
+	It("should create the IP set with the requested hashsize", func() {
+		ipsets.AddOrReplaceIPSet(meta, []string{"10.0.0.1"})
+		ipsets.ApplyUpdates()
+		Expect(dataplane.LinesExecuted).To(Equal([]string{
+			"create " + mainName + " hash:ip family inet hashsize 3000 maxelem 1234",
+			"add " + mainName + " 10.0.0.1",
+			"COMMIT",
+		}))
+		Expect(dataplane.IPSetMetadata[mainName].HashSize).To(Equal(4096))
+
+		By("not rewriting the IP set on resync, even though the kernel rounded up the hashsize")
+		dataplane.LinesExecuted = nil
+		ipsets.QueueResync()
+		ipsets.ApplyUpdates()
+		Expect(dataplane.LinesExecuted).To(BeEmpty())
+	})
+
+	It("should rewrite the IP set when its hashsize changes", func() {
+		ipsets.AddOrReplaceIPSet(meta, []string{"10.0.0.1"})
+		ipsets.ApplyUpdates()
+		dataplane.LinesExecuted = nil
+
+		bigger := meta
+		bigger.HashSize = 8192
+		ipsets.AddOrReplaceIPSet(bigger, []string{"10.0.0.1"})
+		ipsets.ApplyUpdates()
+		Expect(dataplane.LinesExecuted).To(Equal([]string{
+			"create cali4t0 hash:ip family inet hashsize 8192 maxelem 1234",
+			"add cali4t0 10.0.0.1",
+			"swap " + mainName + " cali4t0",
+			"COMMIT",
+		}))
+	})
+
+	It("should rewrite an existing IP set whose hashsize is smaller than requested", func() {
+		// Created before we asked for a hashsize; the kernel reports its default.
+		dataplane.IPSetMembers[mainName] = set.New[string]()
+		dataplane.IPSetMetadata[mainName] = setMetadata{
+			Name:    mainName,
+			Family:  IPFamilyV4,
+			Type:    IPSetTypeHashIP,
+			MaxSize: 1234,
+		}
+		ipsets.AddOrReplaceIPSet(meta, nil)
+		ipsets.ApplyUpdates()
+		Expect(dataplane.LinesExecuted).To(Equal([]string{
+			"create cali4t0 hash:ip family inet hashsize 3000 maxelem 1234",
+			"swap " + mainName + " cali4t0",
+			"COMMIT",
+		}))
+	})
+
+	It("should grow the maxelem of an IP set with too many members", func() {
+		small := meta
+		small.HashSize = 0
+		small.MaxSize = 2
+		ipsets.AddOrReplaceIPSet(small, []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"})
+		ipsets.ApplyUpdates()
+		Expect(dataplane.LinesExecuted).To(Equal([]string{
+			"create " + mainName + " hash:ip family inet maxelem 4",
+			"add " + mainName + " 10.0.0.1",
+			"add " + mainName + " 10.0.0.2",
+			"add " + mainName + " 10.0.0.3",
+			"COMMIT",
+		}))
+
+		By("not shrinking it again when the IP set is re-added with the same members")
+		dataplane.LinesExecuted = nil
+		ipsets.AddOrReplaceIPSet(small, []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"})
+		ipsets.ApplyUpdates()
+		Expect(dataplane.LinesExecuted).To(BeEmpty())
+
+		By("rewriting the IP set when it needs to grow again")
+		ipsets.AddMembers(small.SetID, []string{"10.0.0.4", "10.0.0.5"})
+		ipsets.ApplyUpdates()
+		Expect(dataplane.LinesExecuted).To(Equal([]string{
+			"create cali4t0 hash:ip family inet maxelem 8",
+			"add cali4t0 10.0.0.1",
+			"add cali4t0 10.0.0.2",
+			"add cali4t0 10.0.0.3",
+			"add cali4t0 10.0.0.4",
+			"add cali4t0 10.0.0.5",
+			"swap " + mainName + " cali4t0",
+			"COMMIT",
+		}))
+		Expect(dataplane.IPSetMetadata[mainName].MaxSize).To(Equal(8))
+		ipsets.ApplyDeletions()
+		dataplane.ExpectMembers(map[string][]string{
+			mainName: {"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4", "10.0.0.5"},
+		})
+	})
+
+	It("should not grow an IP set that fits", func() {
+		small := meta
+		small.HashSize = 0
+		small.MaxSize = 2
+		ipsets.AddOrReplaceIPSet(small, []string{"10.0.0.1", "10.0.0.2"})
+		ipsets.ApplyUpdates()
+		Expect(dataplane.LinesExecuted[0]).To(Equal("create " + mainName + " hash:ip family inet maxelem 2"))
+	})
+})
+
+var _ = Describe("IP set type advice", func() {
+	var (
+		dataplane *mockDataplane
+		ipsets    *IPSets
+		logHook   *logrustest.Hook
+		oldHooks  log.LevelHooks
+	)
+
+	meta := IPSetMetadata{
+		MaxSize: 1234,
+		SetID:   ipSetID,
+		Type:    IPSetTypeHashIP,
+	}
+
+	// addresses returns perSubnet addresses in each of the /24s 10.0.<first>.0 to
+	// 10.0.<first+numSubnets-1>.0.
+	addresses := func(first, numSubnets, perSubnet int) []string {
+		var addrs []string
+		for n := first; n < first+numSubnets; n++ {
+			for h := 1; h <= perSubnet; h++ {
+				addrs = append(addrs, fmt.Sprintf("10.0.%d.%d", n, h))
+			}
+		}
+		return addrs
+	}
+
+	BeforeEach(func() {
+		dataplane = newMockDataplane()
+		oldHooks = log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+		logHook = logrustest.NewGlobal()
+		ipsets = newTestIPSets(dataplane, IPFamilyV4)
+	})
+
+	AfterEach(func() {
+		log.StandardLogger().ReplaceHooks(oldHooks)
+	})
+
+	It("should recommend aggregating a large IP set with members in a few subnets", func() {
+		ipsets.AddOrReplaceIPSet(meta, addresses(0, 3, 200))
+		logHook.Reset()
+
+		Expect(ipsets.Advise()).To(Equal([]IPSetTypeAdvice{{
+			SetID:   ipSetID,
+			Netmask: 24,
+			Members: 600,
+			Subnets: 3,
+			Churn:   600,
+		}}))
+		Expect(logHook.LastEntry()).NotTo(BeNil())
+		Expect(logHook.LastEntry().Message).To(Equal(
+			"consider netmask 24 for set " + ipSetID + "; 600 members in 3 /24s, 600 member changes since last check"))
+
+		By("repeating the advice, without churn, while the IP set stays large")
+		advice := ipsets.Advise()
+		Expect(advice).To(HaveLen(1))
+		Expect(advice[0].Churn).To(BeZero())
+		Expect(advice[0].String()).To(Equal("consider netmask 24 for set " + ipSetID + "; 600 members in 3 /24s"))
+	})
+
+	It("should not recommend aggregating a large IP set with members spread across subnets", func() {
+		ipsets.AddOrReplaceIPSet(meta, addresses(0, 150, 2))
+		Expect(ipsets.Advise()).To(BeEmpty())
+	})
+
+	It("should recommend aggregating a small IP set that churns heavily", func() {
+		ipsets.AddOrReplaceIPSet(meta, addresses(0, 1, 16))
+		Expect(ipsets.Advise()).To(BeEmpty())
+
+		for i := 0; i < 10; i++ {
+			ipsets.RemoveMembers(ipSetID, addresses(0, 1, 16))
+			ipsets.AddMembers(ipSetID, addresses(0, 1, 16))
+		}
+		Expect(ipsets.Advise()).To(Equal([]IPSetTypeAdvice{{
+			SetID:   ipSetID,
+			Netmask: 24,
+			Members: 16,
+			Subnets: 1,
+			Churn:   320,
+		}}))
+
+		By("not repeating the advice once the churn stops")
+		Expect(ipsets.Advise()).To(BeEmpty())
+	})
+
+	It("should not count no-op updates as churn", func() {
+		ipsets.AddOrReplaceIPSet(meta, addresses(0, 1, 16))
+		ipsets.Advise()
+		for i := 0; i < 20; i++ {
+			ipsets.AddOrReplaceIPSet(meta, addresses(0, 1, 16))
+			ipsets.AddMembers(ipSetID, addresses(0, 1, 16))
+			ipsets.RemoveMembers(ipSetID, []string{"10.0.99.1"})
+		}
+		Expect(ipsets.Advise()).To(BeEmpty())
+	})
+
+	It("should ignore IP sets that aren't hash:ip", func() {
+		ipsets.AddOrReplaceIPSet(IPSetMetadata{
+			MaxSize: 1234,
+			SetID:   ipSetID,
+			Type:    IPSetTypeHashNet,
+		}, addresses(0, 3, 200))
+		Expect(ipsets.Advise()).To(BeEmpty())
+	})
+
+	It("should leave the IP sets unchanged", func() {
+		ipsets.AddOrReplaceIPSet(meta, addresses(0, 3, 200))
+		ipsets.ApplyUpdates()
+		dataplane.CmdNames = nil
+		Expect(ipsets.Advise()).To(HaveLen(1))
+		Expect(dataplane.CmdNames).To(BeEmpty())
+		Expect(dataplane.IPSetMetadata[v4MainIPSetName].Type).To(Equal(IPSetTypeHashIP))
+	})
+})
@@ -0,0 +1,198 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipsets
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/projectcalico/calico/libcalico-go/lib/set"
+)
+
+// ShadowInput is the input to a ShadowGenerator: the state of a single IP set that is about to
+// be updated.
+type ShadowInput struct {
+	SetName  string
+	Family   IPFamily
+	Type     IPSetType
+	MaxSize  int
+	RangeMin int
+	RangeMax int
+
+	// Exists is true if the IP set is thought to be in the dataplane already, in which case
+	// DataplaneMembers contains its current members.
+	Exists           bool
+	DataplaneMembers []string
+	DesiredMembers   []string
+}
+
+// ShadowGenerator generates the ipset restore lines that would bring the given IP set in line
+// with its desired state.  In shadow mode, the lines are never executed; they are compared with
+// the lines generated by the canonical code path.
+type ShadowGenerator func(in ShadowInput) []string
+
+// WithShadowMode enables shadow mode.  In shadow mode, for each IP set that we update, we also
+// generate the restore lines using the given generator (or, if nil, a simple generator that
+// rewrites the IP set from scratch) and compare the IP set contents that the two sets of lines
+// would produce.  Any difference is logged.  Only the canonical lines are executed.  This is
+// intended as a safety net when refactoring the restore generation logic.
+func WithShadowMode(gen ShadowGenerator) IPSetsOpt {
+	return func(s *IPSets) {
+		if gen == nil {
+			gen = fullRewriteShadowGenerator
+		}
+		s.shadowGenerator = gen
+	}
+}
+
+// fullRewriteShadowGenerator is the default ShadowGenerator, it flushes (or creates) the IP set
+// and then adds all the desired members.
+func fullRewriteShadowGenerator(in ShadowInput) []string {
+	var lines []string
+	if in.Exists {
+		lines = append(lines, fmt.Sprintf("flush %s", in.SetName))
+	} else if in.Type == IPSetTypeBitmapPort {
+		lines = append(lines, fmt.Sprintf("create %s %s range %d-%d", in.SetName, in.Type, in.RangeMin, in.RangeMax))
+	} else {
+		lines = append(lines, fmt.Sprintf("create %s %s family %s maxelem %d", in.SetName, in.Type, in.Family, in.MaxSize))
+	}
+	for _, m := range in.DesiredMembers {
+		lines = append(lines, fmt.Sprintf("add %s %s", in.SetName, m))
+	}
+	return lines
+}
+
+// shadowInputFor captures the state of the given IP set, before writeUpdates updates our
+// tracking.
+func (s *IPSets) shadowInputFor(setName string) ShadowInput {
+	desiredMeta, _ := s.setNameToProgrammedMetadata.Desired().Get(setName)
+	_, dpExists := s.setNameToProgrammedMetadata.Dataplane().Get(setName)
+	in := ShadowInput{
+		SetName:  setName,
+		Family:   s.IPVersionConfig.Family,
+		Type:     desiredMeta.Type,
+		MaxSize:  desiredMeta.MaxSize,
+		RangeMin: desiredMeta.RangeMin,
+		RangeMax: desiredMeta.RangeMax,
+		Exists:   dpExists,
+	}
+	members := s.mainSetNameToMembers[setName]
+	if dpExists {
+		members.Dataplane().Iter(func(m IPSetMember) {
+			in.DataplaneMembers = append(in.DataplaneMembers, m.String())
+		})
+		sort.Strings(in.DataplaneMembers)
+	}
+	members.Desired().Iter(func(m IPSetMember) {
+		in.DesiredMembers = append(in.DesiredMembers, m.String())
+	})
+	sort.Strings(in.DesiredMembers)
+	return in
+}
+
+// compareWithShadow compares the IP set contents produced by the canonical restore lines with
+// those produced by the shadow generator, and logs any divergence.
+func (s *IPSets) compareWithShadow(in ShadowInput, canonicalInput []byte) {
+	var canonicalLines []string
+	scanner := bufio.NewScanner(bytes.NewReader(canonicalInput))
+	for scanner.Scan() {
+		canonicalLines = append(canonicalLines, scanner.Text())
+	}
+	shadowLines := s.shadowGenerator(in)
+
+	canonical := simulateRestore(in, canonicalLines)
+	shadow := simulateRestore(in, shadowLines)
+
+	var onlyCanonical, onlyShadow []string
+	canonical.Iter(func(m string) error {
+		if !shadow.Contains(m) {
+			onlyCanonical = append(onlyCanonical, m)
+		}
+		return nil
+	})
+	shadow.Iter(func(m string) error {
+		if !canonical.Contains(m) {
+			onlyShadow = append(onlyShadow, m)
+		}
+		return nil
+	})
+	if len(onlyCanonical) == 0 && len(onlyShadow) == 0 {
+		s.logCxt.WithField("setName", in.SetName).Debug("Shadow restore lines agree with canonical lines.")
+		return
+	}
+	sort.Strings(onlyCanonical)
+	sort.Strings(onlyShadow)
+	countNumIPSetShadowDivergences.Inc()
+	s.logCxt.WithFields(log.Fields{
+		"setName":        in.SetName,
+		"onlyCanonical":  onlyCanonical,
+		"onlyShadow":     onlyShadow,
+		"canonicalLines": canonicalLines,
+		"shadowLines":    shadowLines,
+	}).Warn("Shadow restore lines diverge from canonical lines.")
+}
+
+// simulateRestore calculates the members that the given IP set would have after executing the
+// given restore lines, starting from the state in the ShadowInput.  Lines that refer to other IP
+// sets are tracked too so that swaps with temporary IP sets are handled.
+func simulateRestore(in ShadowInput, lines []string) set.Set[string] {
+	canon := func(m string) string {
+		return in.Type.CanonicaliseMember(m).String()
+	}
+	sets := map[string]set.Set[string]{}
+	if in.Exists {
+		members := set.New[string]()
+		for _, m := range in.DataplaneMembers {
+			members.Add(canon(m))
+		}
+		sets[in.SetName] = members
+	}
+	for _, line := range lines {
+		parts := strings.Fields(line)
+		if len(parts) < 2 {
+			continue
+		}
+		name := parts[1]
+		switch parts[0] {
+		case "create":
+			sets[name] = set.New[string]()
+		case "flush":
+			sets[name] = set.New[string]()
+		case "destroy":
+			delete(sets, name)
+		case "add":
+			if sets[name] != nil && len(parts) >= 3 {
+				sets[name].Add(canon(parts[2]))
+			}
+		case "del":
+			if sets[name] != nil && len(parts) >= 3 {
+				sets[name].Discard(canon(parts[2]))
+			}
+		case "swap":
+			if len(parts) >= 3 {
+				sets[name], sets[parts[2]] = sets[parts[2]], sets[name]
+			}
+		}
+	}
+	if result := sets[in.SetName]; result != nil {
+		return result
+	}
+	return set.New[string]()
+}
@@ -0,0 +1,46 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipsets
+
+// Suspend puts the IPSets object into maintenance mode, for example during a coordinated
+// migration of the dataplane.  While suspended, ApplyUpdates() and ApplyDeletions() do nothing,
+// so no ipset commands are issued, but the desired state continues to be tracked; Resume()
+// applies everything that accumulated in the meantime.
+func (s *IPSets) Suspend() {
+	if s.suspended {
+		return
+	}
+	s.logCxt.Info("Suspending IP set updates.")
+	s.suspended = true
+}
+
+// Resume ends maintenance mode (see Suspend()) and applies the updates and deletions that
+// accumulated while suspended.  Like ApplyDeletions(), it returns true if there are deletions
+// left over, which should be applied by a later call to ApplyDeletions().  Does nothing, and
+// returns false, if not suspended.
+func (s *IPSets) Resume() (reschedule bool) {
+	if !s.suspended {
+		return false
+	}
+	s.logCxt.Info("Resuming IP set updates.")
+	s.suspended = false
+	s.ApplyUpdates()
+	return s.ApplyDeletions()
+}
+
+// Suspended returns true if the IPSets object is suspended; see Suspend().
+func (s *IPSets) Suspended() bool {
+	return s.suspended
+}
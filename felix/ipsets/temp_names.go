@@ -0,0 +1,45 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipsets
+
+import (
+	"strconv"
+	"time"
+)
+
+// WithDebugTempIPSetNames makes temporary IP set names easier to correlate with their main IP
+// set when debugging.  Rather than just a counter ("cali4t0"), each temporary IP set name
+// includes the counter, an epoch that is unique to this IPSets object and as much of the main IP
+// set's name as fits, for example "cali4t0-s3k9qz-s:qMt7iLlGDhvLnC".  The names are still
+// recognised as temporary IP sets, by this and other IPSets objects.
+func WithDebugTempIPSetNames() IPSetsOpt {
+	return func(s *IPSets) {
+		s.tempNameEpoch = strconv.FormatInt(time.Now().Unix(), 36)
+	}
+}
+
+// NameForDebugTempIPSet returns the name for the nth temporary IP set, including the given epoch
+// and the ID part of the main IP set that it will be swapped with.  See WithDebugTempIPSetNames.
+func (c IPVersionConfig) NameForDebugTempIPSet(n uint, epoch, mainSetName string) string {
+	setID := mainSetName
+	if len(setID) > len(c.mainSetNamePrefix) {
+		setID = setID[len(c.mainSetNamePrefix):]
+	}
+	return combineAndTrunc(
+		c.tempSetNamePrefix+strconv.FormatUint(uint64(n), 36)+"-"+epoch+"-",
+		setID,
+		MaxIPSetNameLength,
+	)
+}
@@ -0,0 +1,550 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testutils
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/projectcalico/calico/felix/ipsets"
+	"github.com/projectcalico/calico/libcalico-go/lib/set"
+)
+
+// This file contains an in-memory model of the ipset command, for tests that want to assert on
+// the final state of the dataplane rather than on the commands that were executed.  For example:
+//
+//	dataplane := testutils.NewFakeDataplane()
+//	s := ipsets.NewIPSetsWithShims(ipVersionConfig, recorder, dataplane.NewCmd, dataplane.Sleep)
+//	...
+//	Expect(dataplane.Members("cali40s:abcd")).To(ConsistOf("10.0.0.1"))
+//
+// The model applies the same rules as the real ipset command, to the extent that the ipsets
+// package relies on them: a set must exist before it can be updated, members can't be added
+// twice or removed when absent unless the exist flag is given, swapped sets must have the same
+// type and sets that are in use can't be destroyed.  Members are stored as written; they are not
+// canonicalised as the kernel would.
+
+// ErrCommandFailed is returned by a fake command that ipset would have exited non-zero for.  The
+// reason is written to the command's stderr, as ipset would.
+var ErrCommandFailed = errors.New("ipset command failed")
+
+// FakeIPSet is the state of an IP set in a FakeDataplane.
+type FakeIPSet struct {
+	Name     string
+	Type     ipsets.IPSetType
+	Family   ipsets.IPFamily
+	MaxSize  int
+	RangeMin int
+	RangeMax int
+	Members  set.Set[string]
+}
+
+// FakeDataplane is an in-memory model of the IP sets in the kernel, along with a fake ipset
+// command that operates on it.  Its methods are safe for concurrent use.
+type FakeDataplane struct {
+	lock sync.Mutex
+
+	ipSets map[string]*FakeIPSet
+	inUse  set.Set[string]
+
+	// LegacyIPSet makes the fake ipset report an old version, which doesn't support COMMIT in
+	// the input to 'ipset restore'.
+	LegacyIPSet bool
+
+	// CmdNames records the subcommand of each ipset command that was run, for example
+	// "restore" or "list".
+	CmdNames []string
+	// CumulativeSleep is the total time passed to Sleep.
+	CumulativeSleep time.Duration
+}
+
+// NewFakeDataplane returns a FakeDataplane with no IP sets.
+func NewFakeDataplane() *FakeDataplane {
+	return &FakeDataplane{
+		ipSets: map[string]*FakeIPSet{},
+		inUse:  set.New[string](),
+	}
+}
+
+// NewCmd is a command factory, for use with ipsets.NewIPSetsWithShims().  Only the ipset command
+// is supported.
+func (d *FakeDataplane) NewCmd(name string, arg ...string) ipsets.CmdIface {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	subCmd := ""
+	if len(arg) > 0 {
+		subCmd = arg[0]
+	}
+	d.CmdNames = append(d.CmdNames, subCmd)
+	return &fakeCmd{dataplane: d, name: name, args: arg}
+}
+
+// Sleep records the time that the caller would have slept for, for use with
+// ipsets.NewIPSetsWithShims().
+func (d *FakeDataplane) Sleep(t time.Duration) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.CumulativeSleep += t
+}
+
+// Restore executes the given lines as if they were the input to 'ipset restore', stopping at
+// the first line that fails.  It is intended for setting up the dataplane's starting state.
+func (d *FakeDataplane) Restore(lines ...string) error {
+	var stderr bytes.Buffer
+	return d.restore(strings.NewReader(strings.Join(lines, "\n")), &stderr)
+}
+
+// SetInUse marks the IP set as referenced by another part of the dataplane, or not, so that
+// attempts to destroy it fail.
+func (d *FakeDataplane) SetInUse(name string, inUse bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if inUse {
+		d.inUse.Add(name)
+	} else {
+		d.inUse.Discard(name)
+	}
+}
+
+// SetNames returns the names of the IP sets in the dataplane, in sorted order.
+func (d *FakeDataplane) SetNames() []string {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.sortedNames()
+}
+
+// Exists returns true if the IP set is in the dataplane.
+func (d *FakeDataplane) Exists(name string) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	_, ok := d.ipSets[name]
+	return ok
+}
+
+// IPSet returns a copy of the IP set, or nil if it is not in the dataplane.
+func (d *FakeDataplane) IPSet(name string) *FakeIPSet {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	ipSet, ok := d.ipSets[name]
+	if !ok {
+		return nil
+	}
+	cp := *ipSet
+	cp.Members = ipSet.Members.Copy()
+	return &cp
+}
+
+// Members returns the members of the IP set in sorted order, or nil if it is not in the
+// dataplane.
+func (d *FakeDataplane) Members(name string) []string {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	ipSet, ok := d.ipSets[name]
+	if !ok {
+		return nil
+	}
+	return sortedMembers(ipSet)
+}
+
+// Contents returns the members of every IP set in the dataplane, keyed by IP set name.  An empty
+// IP set has an empty, rather than nil, slice of members.
+func (d *FakeDataplane) Contents() map[string][]string {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	contents := map[string][]string{}
+	for name, ipSet := range d.ipSets {
+		contents[name] = sortedMembers(ipSet)
+	}
+	return contents
+}
+
+func (d *FakeDataplane) sortedNames() []string {
+	names := make([]string, 0, len(d.ipSets))
+	for name := range d.ipSets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func sortedMembers(ipSet *FakeIPSet) []string {
+	members := make([]string, 0, ipSet.Members.Len())
+	ipSet.Members.Iter(func(m string) error {
+		members = append(members, m)
+		return nil
+	})
+	sort.Strings(members)
+	return members
+}
+
+// restore executes the input to 'ipset restore', writing the reason for any failure to stderr.
+// Like ipset, it is not transactional: the lines before a failed line remain applied.
+func (d *FakeDataplane) restore(r io.Reader, stderr io.Writer) error {
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if err := d.execLine(strings.Fields(line)); err != nil {
+			_, _ = fmt.Fprintf(stderr, "%s: Error in line %d: %v\n", d.versionString(), lineNum, err)
+			return ErrCommandFailed
+		}
+	}
+	return scanner.Err()
+}
+
+// execLine executes a single ipset command, given as its subcommand and arguments.
+func (d *FakeDataplane) execLine(parts []string) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	// Strip the exist flag, which can appear anywhere after the subcommand.
+	exist := false
+	args := parts[:0:0]
+	for _, p := range parts[1:] {
+		if p == "-exist" || p == "--exist" {
+			exist = true
+			continue
+		}
+		args = append(args, p)
+	}
+	logCxt := log.WithFields(log.Fields{"cmd": parts[0], "args": args})
+	logCxt.Debug("Fake ipset executing command")
+
+	switch parts[0] {
+	case "create":
+		return d.create(args, exist)
+	case "add", "del":
+		if len(args) != 2 {
+			return fmt.Errorf("%s needs a set name and a member", parts[0])
+		}
+		ipSet, err := d.lookUp(args[0])
+		if err != nil {
+			return err
+		}
+		if parts[0] == "add" {
+			if ipSet.Members.Contains(args[1]) && !exist {
+				return fmt.Errorf("Element cannot be added to the set: it's already added")
+			}
+			ipSet.Members.Add(args[1])
+		} else {
+			if !ipSet.Members.Contains(args[1]) && !exist {
+				return fmt.Errorf("Element cannot be deleted from the set: it's not added")
+			}
+			ipSet.Members.Discard(args[1])
+		}
+	case "swap":
+		if len(args) != 2 {
+			return fmt.Errorf("swap needs two set names")
+		}
+		ipSet1, err := d.lookUp(args[0])
+		if err != nil {
+			return err
+		}
+		ipSet2, err := d.lookUp(args[1])
+		if err != nil {
+			return err
+		}
+		if ipSet1.Type != ipSet2.Type || ipSet1.Family != ipSet2.Family {
+			return fmt.Errorf("The sets cannot be swapped: their type does not match")
+		}
+		ipSet1.Name, ipSet2.Name = ipSet2.Name, ipSet1.Name
+		d.ipSets[ipSet1.Name], d.ipSets[ipSet2.Name] = ipSet1, ipSet2
+	case "flush", "destroy":
+		names := args
+		if len(names) == 0 {
+			// With no set name, the command applies to every IP set.
+			names = d.sortedNames()
+		}
+		for _, name := range names {
+			ipSet, err := d.lookUp(name)
+			if err != nil {
+				return err
+			}
+			if parts[0] == "flush" {
+				ipSet.Members = set.New[string]()
+				continue
+			}
+			if d.inUse.Contains(name) {
+				return fmt.Errorf("Set cannot be destroyed: it is in use by a kernel component")
+			}
+			delete(d.ipSets, name)
+		}
+	case "COMMIT":
+		if d.LegacyIPSet {
+			return fmt.Errorf("Unknown command COMMIT")
+		}
+	default:
+		return fmt.Errorf("Unknown command %s", parts[0])
+	}
+	return nil
+}
+
+// create creates an IP set from the arguments to 'ipset create'.  Options that don't affect the
+// model, such as hashsize, are ignored.
+func (d *FakeDataplane) create(args []string, exist bool) error {
+	if len(args) < 2 {
+		return fmt.Errorf("create needs a set name and a type")
+	}
+	ipSet := &FakeIPSet{
+		Name:    args[0],
+		Type:    ipsets.IPSetType(args[1]),
+		Members: set.New[string](),
+	}
+	if len(ipSet.Name) > ipsets.MaxIPSetNameLength {
+		return fmt.Errorf("Syntax error: setname '%s' is longer than %d characters", ipSet.Name, ipsets.MaxIPSetNameLength)
+	}
+	if !ipSet.Type.IsValid() {
+		return fmt.Errorf("Syntax error: typename '%s' is unknown", ipSet.Type)
+	}
+	if ipSet.Type != ipsets.IPSetTypeBitmapPort {
+		ipSet.Family = ipsets.IPFamilyV4
+	}
+	for i := 2; i < len(args); i++ {
+		opt := args[i]
+		switch opt {
+		case "family", "maxelem", "range", "hashsize", "timeout", "netmask":
+			if i+1 >= len(args) {
+				return fmt.Errorf("Syntax error: option %s needs a value", opt)
+			}
+			i++
+		default:
+			// Flag-style options such as "comment" and "counters".
+			continue
+		}
+		var err error
+		switch opt {
+		case "family":
+			ipSet.Family = ipsets.IPFamily(args[i])
+			if !ipSet.Family.IsValid() {
+				err = fmt.Errorf("Syntax error: unknown family %s", args[i])
+			}
+		case "maxelem":
+			ipSet.MaxSize, err = strconv.Atoi(args[i])
+		case "range":
+			ipSet.RangeMin, ipSet.RangeMax, err = ipsets.ParseRange(args[i])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if existing, ok := d.ipSets[ipSet.Name]; ok {
+		// With the exist flag, re-creating an identical IP set is a no-op.
+		if exist && existing.Type == ipSet.Type && existing.Family == ipSet.Family &&
+			existing.MaxSize == ipSet.MaxSize && existing.RangeMin == ipSet.RangeMin &&
+			existing.RangeMax == ipSet.RangeMax {
+			return nil
+		}
+		return fmt.Errorf("Set cannot be created: set with the same name already exists")
+	}
+	d.ipSets[ipSet.Name] = ipSet
+	return nil
+}
+
+func (d *FakeDataplane) lookUp(name string) (*FakeIPSet, error) {
+	ipSet, ok := d.ipSets[name]
+	if !ok {
+		return nil, fmt.Errorf("The set with the given name does not exist")
+	}
+	return ipSet, nil
+}
+
+// list writes the output of 'ipset list', for the given IP set or, if name is empty, every IP set.
+func (d *FakeDataplane) list(name string, stdout, stderr io.Writer) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	names := []string{name}
+	if name == "" {
+		names = d.sortedNames()
+	} else if _, err := d.lookUp(name); err != nil {
+		_, _ = fmt.Fprintf(stderr, "%s: %v\n", d.versionString(), err)
+		return ErrCommandFailed
+	}
+	for i, name := range names {
+		ipSet := d.ipSets[name]
+		if i > 0 {
+			_, _ = fmt.Fprint(stdout, "\n")
+		}
+		_, _ = fmt.Fprintf(stdout, "Name: %s\nType: %s\nRevision: 4\n", ipSet.Name, ipSet.Type)
+		if ipSet.Type == ipsets.IPSetTypeBitmapPort {
+			_, _ = fmt.Fprintf(stdout, "Header: range %d-%d\n", ipSet.RangeMin, ipSet.RangeMax)
+		} else {
+			_, _ = fmt.Fprintf(stdout, "Header: family %s hashsize 1024 maxelem %d\n", ipSet.Family, ipSet.MaxSize)
+		}
+		_, _ = fmt.Fprintf(stdout, "Size in memory: 1024\nReferences: 0\nNumber of entries: %d\nMembers:\n",
+			ipSet.Members.Len())
+		for _, m := range sortedMembers(ipSet) {
+			_, _ = fmt.Fprintf(stdout, "%s\n", m)
+		}
+	}
+	return nil
+}
+
+func (d *FakeDataplane) versionString() string {
+	if d.LegacyIPSet {
+		return "ipset v6.11"
+	}
+	return "ipset v7.11"
+}
+
+// fakeCmd is a single ipset command run against a FakeDataplane.  The command runs in the
+// background between Start() and Wait(), so that the caller can stream its input and output
+// through pipes.
+type fakeCmd struct {
+	dataplane *FakeDataplane
+	name      string
+	args      []string
+
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+
+	stdoutPipe *io.PipeWriter
+	resultC    chan error
+}
+
+func (c *fakeCmd) StdinPipe() (ipsets.WriteCloserFlusher, error) {
+	pipeR, pipeW := io.Pipe()
+	c.stdin = pipeR
+	return &ipsets.BufferedCloser{
+		BufWriter: bufio.NewWriter(pipeW),
+		Closer:    pipeW,
+	}, nil
+}
+
+func (c *fakeCmd) StdoutPipe() (io.ReadCloser, error) {
+	pipeR, pipeW := io.Pipe()
+	c.stdout = pipeW
+	c.stdoutPipe = pipeW
+	return pipeR, nil
+}
+
+func (c *fakeCmd) SetStdin(r io.Reader) {
+	c.stdin = r
+}
+
+func (c *fakeCmd) SetStdout(w io.Writer) {
+	c.stdout = w
+}
+
+func (c *fakeCmd) SetStderr(w io.Writer) {
+	c.stderr = w
+}
+
+func (c *fakeCmd) Start() error {
+	if c.resultC != nil {
+		return errors.New("command already started")
+	}
+	c.resultC = make(chan error, 1)
+	go func() {
+		err := c.run()
+		if c.stdoutPipe != nil {
+			_ = c.stdoutPipe.Close()
+		}
+		if pipeR, ok := c.stdin.(*io.PipeReader); ok {
+			// Like a process that has exited, stop accepting input.
+			_ = pipeR.CloseWithError(io.ErrClosedPipe)
+		}
+		c.resultC <- err
+	}()
+	return nil
+}
+
+func (c *fakeCmd) Wait() error {
+	if c.resultC == nil {
+		return errors.New("command not started")
+	}
+	return <-c.resultC
+}
+
+func (c *fakeCmd) Output() ([]byte, error) {
+	var stdout bytes.Buffer
+	c.stdout = &stdout
+	if err := c.Start(); err != nil {
+		return nil, err
+	}
+	err := c.Wait()
+	return stdout.Bytes(), err
+}
+
+func (c *fakeCmd) CombinedOutput() ([]byte, error) {
+	var output bytes.Buffer
+	c.stdout = &output
+	c.stderr = &output
+	if err := c.Start(); err != nil {
+		return nil, err
+	}
+	err := c.Wait()
+	return output.Bytes(), err
+}
+
+// run executes the command, as the ipset binary would.
+func (c *fakeCmd) run() error {
+	stdout, stderr := c.stdout, c.stderr
+	if stdout == nil {
+		stdout = io.Discard
+	}
+	if stderr == nil {
+		stderr = io.Discard
+	}
+	if c.name != "ipset" {
+		_, _ = fmt.Fprintf(stderr, "%s: command not found\n", c.name)
+		return ErrCommandFailed
+	}
+	if len(c.args) == 0 {
+		_, _ = fmt.Fprintf(stderr, "%s: No command specified\n", c.dataplane.versionString())
+		return ErrCommandFailed
+	}
+
+	switch c.args[0] {
+	case "restore":
+		if c.stdin == nil {
+			return nil
+		}
+		return c.dataplane.restore(c.stdin, stderr)
+	case "list":
+		name := ""
+		if len(c.args) > 1 {
+			name = c.args[1]
+		}
+		return c.dataplane.list(name, stdout, stderr)
+	case "version":
+		protocol := "7"
+		if c.dataplane.LegacyIPSet {
+			protocol = "6"
+		}
+		_, _ = fmt.Fprintf(stdout, "%s, protocol version: %s\n", c.dataplane.versionString(), protocol)
+		return nil
+	default:
+		if err := c.dataplane.execLine(c.args); err != nil {
+			_, _ = fmt.Fprintf(stderr, "%s: %v\n", c.dataplane.versionString(), err)
+			return ErrCommandFailed
+		}
+		return nil
+	}
+}
@@ -0,0 +1,256 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package testutils_test
+
+import (
+	"bytes"
+	"io"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/projectcalico/calico/felix/ipsets"
+	. "github.com/projectcalico/calico/felix/ipsets/testutils"
+	"github.com/projectcalico/calico/felix/logutils"
+	"github.com/projectcalico/calico/felix/rules"
+)
+
+var _ = Describe("FakeDataplane", func() {
+	var dataplane *FakeDataplane
+
+	BeforeEach(func() {
+		dataplane = NewFakeDataplane()
+	})
+
+	// run runs a single ipset command and returns its combined output.
+	run := func(args ...string) (string, error) {
+		out, err := dataplane.NewCmd("ipset", args...).CombinedOutput()
+		return string(out), err
+	}
+
+	It("should create IP sets with their metadata", func() {
+		Expect(dataplane.Restore(
+			"create cali40a hash:ip family inet maxelem 1024",
+			"create cali40b bitmap:port range 0-1024",
+		)).To(Succeed())
+		Expect(dataplane.SetNames()).To(Equal([]string{"cali40a", "cali40b"}))
+		Expect(dataplane.IPSet("cali40a")).To(Equal(&FakeIPSet{
+			Name:    "cali40a",
+			Type:    ipsets.IPSetTypeHashIP,
+			Family:  ipsets.IPFamilyV4,
+			MaxSize: 1024,
+			Members: dataplane.IPSet("cali40a").Members,
+		}))
+		Expect(dataplane.IPSet("cali40b").RangeMax).To(Equal(1024))
+		Expect(dataplane.Members("cali40a")).To(BeEmpty())
+		Expect(dataplane.Members("cali40a")).NotTo(BeNil())
+	})
+
+	It("should reject a duplicate create unless it is an identical create with the exist flag", func() {
+		Expect(dataplane.Restore("create cali40a hash:ip family inet maxelem 1024")).To(Succeed())
+		out, err := run("create", "cali40a", "hash:ip", "family", "inet", "maxelem", "1024")
+		Expect(err).To(Equal(ErrCommandFailed))
+		Expect(out).To(ContainSubstring("already exists"))
+		_, err = run("create", "cali40a", "hash:ip", "family", "inet", "maxelem", "1024", "--exist")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = run("create", "cali40a", "hash:net", "family", "inet", "maxelem", "1024", "--exist")
+		Expect(err).To(Equal(ErrCommandFailed))
+	})
+
+	It("should reject unknown types and over-long names", func() {
+		Expect(dataplane.Restore("create cali40a hash:foo family inet")).NotTo(Succeed())
+		Expect(dataplane.Restore("create " + strings.Repeat("x", 32) + " hash:ip")).NotTo(Succeed())
+		Expect(dataplane.SetNames()).To(BeEmpty())
+	})
+
+	It("should add and delete members, honouring the exist flag", func() {
+		Expect(dataplane.Restore(
+			"create cali40a hash:ip family inet maxelem 1024",
+			"add cali40a 10.0.0.1",
+			"add cali40a 10.0.0.2",
+			"add cali40a 10.0.0.2 --exist",
+			"del cali40a 10.0.0.1",
+			"del cali40a 10.0.0.3 --exist",
+		)).To(Succeed())
+		Expect(dataplane.Members("cali40a")).To(Equal([]string{"10.0.0.2"}))
+
+		out, err := run("add", "cali40a", "10.0.0.2")
+		Expect(err).To(Equal(ErrCommandFailed))
+		Expect(out).To(ContainSubstring("it's already added"))
+		out, err = run("del", "cali40a", "10.0.0.1")
+		Expect(err).To(Equal(ErrCommandFailed))
+		Expect(out).To(ContainSubstring("it's not added"))
+		out, err = run("add", "cali40z", "10.0.0.1")
+		Expect(err).To(Equal(ErrCommandFailed))
+		Expect(out).To(ContainSubstring("does not exist"))
+	})
+
+	It("should swap IP sets of the same type", func() {
+		Expect(dataplane.Restore(
+			"create cali40a hash:ip family inet maxelem 1024",
+			"add cali40a 10.0.0.1",
+			"create cali4ta hash:ip family inet maxelem 2048",
+			"add cali4ta 10.0.0.2",
+			"swap cali40a cali4ta",
+		)).To(Succeed())
+		Expect(dataplane.Contents()).To(Equal(map[string][]string{
+			"cali40a": {"10.0.0.2"},
+			"cali4ta": {"10.0.0.1"},
+		}))
+		Expect(dataplane.IPSet("cali40a").Name).To(Equal("cali40a"))
+		Expect(dataplane.IPSet("cali40a").MaxSize).To(Equal(2048))
+	})
+
+	It("should refuse to swap IP sets of different types or that don't exist", func() {
+		Expect(dataplane.Restore(
+			"create cali40a hash:ip family inet",
+			"create cali40b hash:net family inet",
+		)).To(Succeed())
+		out, err := run("swap", "cali40a", "cali40b")
+		Expect(err).To(Equal(ErrCommandFailed))
+		Expect(out).To(ContainSubstring("type does not match"))
+		_, err = run("swap", "cali40a", "cali40z")
+		Expect(err).To(Equal(ErrCommandFailed))
+	})
+
+	It("should flush one or all IP sets", func() {
+		Expect(dataplane.Restore(
+			"create cali40a hash:ip family inet",
+			"add cali40a 10.0.0.1",
+			"create cali40b hash:ip family inet",
+			"add cali40b 10.0.0.2",
+			"flush cali40a",
+		)).To(Succeed())
+		Expect(dataplane.Contents()).To(Equal(map[string][]string{
+			"cali40a": {},
+			"cali40b": {"10.0.0.2"},
+		}))
+		_, err := run("flush")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(dataplane.Contents()).To(Equal(map[string][]string{
+			"cali40a": {},
+			"cali40b": {},
+		}))
+	})
+
+	It("should destroy one or all IP sets, except those in use", func() {
+		Expect(dataplane.Restore(
+			"create cali40a hash:ip family inet",
+			"create cali40b hash:ip family inet",
+			"create cali40c hash:ip family inet",
+			"destroy cali40a",
+		)).To(Succeed())
+		Expect(dataplane.Exists("cali40a")).To(BeFalse())
+		Expect(dataplane.IPSet("cali40a")).To(BeNil())
+		Expect(dataplane.Members("cali40a")).To(BeNil())
+
+		out, err := run("destroy", "cali40a")
+		Expect(err).To(Equal(ErrCommandFailed))
+		Expect(out).To(ContainSubstring("does not exist"))
+
+		dataplane.SetInUse("cali40b", true)
+		out, err = run("destroy", "cali40b")
+		Expect(err).To(Equal(ErrCommandFailed))
+		Expect(out).To(ContainSubstring("in use"))
+		dataplane.SetInUse("cali40b", false)
+
+		_, err = run("destroy")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(dataplane.SetNames()).To(BeEmpty())
+	})
+
+	It("should apply the lines before a failed restore line, like ipset", func() {
+		var stderr bytes.Buffer
+		cmd := dataplane.NewCmd("ipset", "restore")
+		cmd.SetStdin(strings.NewReader("create cali40a hash:ip family inet\nadd cali40a 10.0.0.1\nadd cali40z 10.0.0.2\nadd cali40a 10.0.0.3\n"))
+		cmd.SetStderr(&stderr)
+		Expect(cmd.Start()).To(Succeed())
+		Expect(cmd.Wait()).To(Equal(ErrCommandFailed))
+		Expect(stderr.String()).To(Equal("ipset v7.11: Error in line 3: The set with the given name does not exist\n"))
+		Expect(dataplane.Contents()).To(Equal(map[string][]string{"cali40a": {"10.0.0.1"}}))
+	})
+
+	It("should only accept COMMIT from a modern ipset", func() {
+		Expect(dataplane.Restore("create cali40a hash:ip family inet", "COMMIT")).To(Succeed())
+		out, err := dataplane.NewCmd("ipset", "version").Output()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(out)).To(HavePrefix("ipset v7"))
+
+		dataplane.LegacyIPSet = true
+		Expect(dataplane.Restore("create cali40b hash:ip family inet", "COMMIT")).NotTo(Succeed())
+		out, err = dataplane.NewCmd("ipset", "version").Output()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(out)).To(HavePrefix("ipset v6"))
+	})
+
+	It("should stream restore input and list output through pipes", func() {
+		cmd := dataplane.NewCmd("ipset", "restore")
+		stdin, err := cmd.StdinPipe()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cmd.Start()).To(Succeed())
+		_, err = stdin.Write([]byte("create cali40a hash:ip family inet maxelem 1024\nadd cali40a 10.0.0.1\nCOMMIT\n"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stdin.Flush()).To(Succeed())
+		Expect(stdin.Close()).To(Succeed())
+		Expect(cmd.Wait()).To(Succeed())
+
+		cmd = dataplane.NewCmd("ipset", "list")
+		stdout, err := cmd.StdoutPipe()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cmd.Start()).To(Succeed())
+		out, err := io.ReadAll(stdout)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(cmd.Wait()).To(Succeed())
+		Expect(string(out)).To(ContainSubstring("Name: cali40a\nType: hash:ip\n"))
+		Expect(string(out)).To(ContainSubstring("Header: family inet hashsize 1024 maxelem 1024\n"))
+		Expect(string(out)).To(HaveSuffix("Members:\n10.0.0.1\n"))
+		Expect(dataplane.CmdNames).To(Equal([]string{"restore", "list"}))
+	})
+
+	It("should fail to list an IP set that doesn't exist", func() {
+		_, err := dataplane.NewCmd("ipset", "list", "cali40z").Output()
+		Expect(err).To(Equal(ErrCommandFailed))
+	})
+
+	It("should model the dataplane programmed by IPSets", func() {
+		ipVersionConfig := ipsets.NewIPVersionConfig(
+			ipsets.IPFamilyV4, "cali", rules.AllHistoricIPSetNamePrefixes, rules.LegacyV4IPSetNames, nil)
+		mainName := ipVersionConfig.NameForMainIPSet("s:qMt7iLlGDhvLnCjM0l9nzxb")
+		Expect(dataplane.Restore("create cali40s:stale hash:ip family inet maxelem 1024")).To(Succeed())
+
+		s := ipsets.NewIPSetsWithShims(ipVersionConfig, logutils.NewSummarizer("test loop"), dataplane.NewCmd, dataplane.Sleep)
+		s.AddOrReplaceIPSet(ipsets.IPSetMetadata{
+			SetID:   "s:qMt7iLlGDhvLnCjM0l9nzxb",
+			Type:    ipsets.IPSetTypeHashIP,
+			MaxSize: 1024,
+		}, []string{"10.0.0.1", "10.0.0.2"})
+		s.ApplyUpdates()
+		s.ApplyDeletions()
+		Expect(dataplane.Contents()).To(Equal(map[string][]string{
+			mainName: {"10.0.0.1", "10.0.0.2"},
+		}))
+
+		s.RemoveMembers("s:qMt7iLlGDhvLnCjM0l9nzxb", []string{"10.0.0.1"})
+		s.AddMembers("s:qMt7iLlGDhvLnCjM0l9nzxb", []string{"10.0.0.3"})
+		s.ApplyUpdates()
+		Expect(dataplane.Members(mainName)).To(Equal([]string{"10.0.0.2", "10.0.0.3"}))
+
+		s.RemoveIPSet("s:qMt7iLlGDhvLnCjM0l9nzxb")
+		s.ApplyUpdates()
+		s.ApplyDeletions()
+		Expect(dataplane.SetNames()).To(BeEmpty())
+	})
+})
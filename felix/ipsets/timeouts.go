@@ -0,0 +1,244 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ipsets
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// MaxTimeout is the largest timeout that ipset supports, for an IP set or a member.  Longer
+// timeouts are capped.
+const MaxTimeout = 2147483 * time.Second
+
+// memberTimeout records the timeout of a member of an IP set that has a default timeout.
+//
+// The kernel removes such members when they time out, without telling us.  To avoid adding them
+// back, we record when each member will expire and then forget it, as if it had been removed;
+// see forgetExpiredMembers().  Knowing when members expire also lets us carry over their
+// remaining timeouts when we rewrite the IP set via a temporary IP set.
+type memberTimeout struct {
+	// timeout is the per-member timeout requested via AddMembersWithTimeout(), in seconds, or
+	// 0 for the IP set's default timeout.
+	timeout int
+	// expiry is when the kernel will remove the member, or zero if we haven't written the
+	// member yet.
+	expiry time.Time
+	// refresh is set if the member is to be re-added to the IP set, with -exist, to reset its
+	// timeout.
+	refresh bool
+}
+
+// AddMembersWithTimeout is as AddMembersChecked but the given members time out after the given
+// timeout, rather than after the IP set's default timeout.  The IP set must have been created
+// with a Timeout because ipset doesn't support per-member timeouts otherwise.  Members that are
+// already in the IP set have their timeouts reset.
+//
+// Once a member times out, the kernel removes it from the IP set and we forget about it; it is
+// not added back on a resync.
+func (s *IPSets) AddMembersWithTimeout(setID string, newMembers []string, timeout time.Duration) error {
+	setName := s.nameForMainIPSet(setID)
+	setMeta, ok := s.setNameToAllMetadata[setName]
+	if !ok {
+		return fmt.Errorf("ipset %s not found", setID)
+	}
+	if setMeta.Timeout == 0 {
+		return fmt.Errorf("ipset %s was created without a timeout, members can't have timeouts", setID)
+	}
+	if timeout <= 0 {
+		return fmt.Errorf("invalid timeout %v for members of ipset %s", timeout, setID)
+	}
+	if err := checkMembersValid(setID, setMeta.Type, newMembers); err != nil {
+		return err
+	}
+	if err := s.checkMemberFamilies(setID, setMeta.Type, newMembers); err != nil {
+		return err
+	}
+
+	timeoutSecs := timeoutSeconds(timeout)
+	membersTracker := s.mainSetNameToMembers[setName]
+	s.filterAndCanonicaliseMembers(setMeta.Type, newMembers).Iter(func(member IPSetMember) error {
+		mt := &memberTimeout{timeout: timeoutSecs}
+		if membersTracker.Dataplane().Contains(member) {
+			// Already programmed; forget that it's in the dataplane so that we add it again.
+			membersTracker.Dataplane().Delete(member)
+			mt.refresh = true
+		}
+		s.memberTimeouts(setName)[member] = mt
+		return nil
+	})
+	s.addMembers(setName, setMeta, newMembers)
+	return nil
+}
+
+func (s *IPSets) memberTimeouts(setName string) map[IPSetMember]*memberTimeout {
+	timeouts := s.setNameToMemberTimeouts[setName]
+	if timeouts == nil {
+		timeouts = map[IPSetMember]*memberTimeout{}
+		s.setNameToMemberTimeouts[setName] = timeouts
+	}
+	return timeouts
+}
+
+// memberTimeoutArgs returns the arguments to append to the "add" line for the given member.  It
+// returns "" for IP sets without a timeout, so their input is unchanged.
+func (s *IPSets) memberTimeoutArgs(setName string, meta dataplaneMetadata, member IPSetMember) string {
+	if meta.Timeout == 0 {
+		return ""
+	}
+	mt := s.setNameToMemberTimeouts[setName][member]
+	switch {
+	case mt == nil:
+		// New member with the default timeout.
+		return ""
+	case mt.refresh:
+		return fmt.Sprintf(" timeout %d %s", mt.timeout, s.existFlag())
+	case !mt.expiry.IsZero():
+		// The member has been written before, so we're rewriting the IP set or the member went
+		// missing.  Either way, keep the timeout that it had.
+		return timeoutArg(remainingSeconds(mt.expiry, s.now()))
+	default:
+		return timeoutArg(mt.timeout)
+	}
+}
+
+// recordMemberWritten records when a member that we've just added will expire.  It is a no-op
+// for IP sets without a timeout.
+func (s *IPSets) recordMemberWritten(setName string, meta dataplaneMetadata, member IPSetMember) {
+	if meta.Timeout == 0 {
+		return
+	}
+	timeouts := s.memberTimeouts(setName)
+	mt := timeouts[member]
+	if mt == nil {
+		mt = &memberTimeout{}
+		timeouts[member] = mt
+	}
+	if !mt.expiry.IsZero() && !mt.refresh {
+		// Re-written with its remaining timeout.
+		return
+	}
+	timeout := mt.timeout
+	if timeout == 0 {
+		timeout = meta.Timeout
+	}
+	mt.expiry = s.now().Add(time.Duration(timeout) * time.Second)
+	mt.refresh = false
+}
+
+// recordListedTimeout records the remaining timeout of a member that resync found in the
+// dataplane, so that we know when it'll expire even if we didn't add it (for example, because
+// we were restarted).
+func (s *IPSets) recordListedTimeout(setName string, member IPSetMember, line string) {
+	remaining, ok := listedMemberTimeout(line)
+	if !ok || remaining == 0 {
+		// A timeout of 0 means that the member never expires.
+		return
+	}
+	timeouts := s.memberTimeouts(setName)
+	mt := timeouts[member]
+	if mt == nil {
+		mt = &memberTimeout{}
+		timeouts[member] = mt
+	}
+	if mt.refresh {
+		// We're about to reset the timeout anyway.
+		return
+	}
+	mt.expiry = s.now().Add(time.Duration(remaining) * time.Second)
+}
+
+// forgetExpiredMembers removes the members that the kernel has timed out from both sides of the
+// member trackers, so that we neither add them back nor try to delete them.  It also discards the
+// timeouts of members that are no longer wanted.
+func (s *IPSets) forgetExpiredMembers() {
+	now := s.now()
+	for setName, timeouts := range s.setNameToMemberTimeouts {
+		membersTracker := s.mainSetNameToMembers[setName]
+		if membersTracker == nil || s.setNameToAllMetadata[setName].Timeout == 0 {
+			delete(s.setNameToMemberTimeouts, setName)
+			continue
+		}
+		numExpired := 0
+		for member, mt := range timeouts {
+			if !membersTracker.Desired().Contains(member) {
+				delete(timeouts, member)
+				continue
+			}
+			if mt.expiry.IsZero() || mt.refresh || now.Before(mt.expiry) {
+				continue
+			}
+			membersTracker.Desired().Delete(member)
+			membersTracker.Dataplane().Delete(member)
+			delete(timeouts, member)
+			numExpired++
+		}
+		if numExpired > 0 {
+			s.logCxt.WithFields(log.Fields{
+				"setName":    setName,
+				"numExpired": numExpired,
+			}).Debug("Forgot IP set members that have timed out.")
+		}
+	}
+}
+
+// listedMemberTimeout returns the remaining timeout, in seconds, of a member line from 'ipset
+// list', such as "10.0.0.1 timeout 30".
+func listedMemberTimeout(line string) (int, bool) {
+	fields := splitQuotedFields(line)
+	for i := 1; i+1 < len(fields); i++ {
+		if fields[i] != "timeout" {
+			continue
+		}
+		timeout, err := strconv.Atoi(fields[i+1])
+		if err != nil {
+			return 0, false
+		}
+		return timeout, true
+	}
+	return 0, false
+}
+
+// timeoutSeconds converts a timeout to whole seconds, as used by ipset, rounding up.
+func timeoutSeconds(d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+	if d > MaxTimeout {
+		log.WithField("timeout", d).Warning("IP set timeout too long, capping it.")
+		d = MaxTimeout
+	}
+	return int((d + time.Second - 1) / time.Second)
+}
+
+// remainingSeconds returns the number of whole seconds until the expiry, rounding up.  It returns
+// at least 1 because a timeout of 0 means "never" to ipset.
+func remainingSeconds(expiry, now time.Time) int {
+	if secs := timeoutSeconds(expiry.Sub(now)); secs > 0 {
+		return secs
+	}
+	return 1
+}
+
+// timeoutArg returns the "timeout" argument for the given timeout in seconds, or "" if it is 0.
+func timeoutArg(timeoutSecs int) string {
+	if timeoutSecs == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" timeout %d", timeoutSecs)
+}
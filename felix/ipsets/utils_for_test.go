@@ -31,6 +31,8 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	. "github.com/projectcalico/calico/felix/ipsets"
+	"github.com/projectcalico/calico/felix/logutils"
+	"github.com/projectcalico/calico/felix/rules"
 	"github.com/projectcalico/calico/libcalico-go/lib/set"
 )
 
@@ -41,11 +43,30 @@ var (
 	permanentFailure = errors.New("Simulated permanent failure")
 )
 
+// newTestIPSets returns an IPSets for the given IP family, with the usual name prefixes, that runs
+// its ipset commands against the given mock dataplane.
+func newTestIPSets(dataplane *mockDataplane, family IPFamily, opts ...IPSetsOpt) *IPSets {
+	var legacyNames []string
+	if family == IPFamilyV4 {
+		legacyNames = rules.LegacyV4IPSetNames
+	}
+	return NewIPSetsWithShims(
+		NewIPVersionConfig(family, "cali", rules.AllHistoricIPSetNamePrefixes, legacyNames, nil),
+		logutils.NewSummarizer("test loop"),
+		dataplane.newCmd,
+		dataplane.sleep,
+		opts...,
+	)
+}
+
 func newMockDataplane() *mockDataplane {
 	return &mockDataplane{
-		IPSetMembers:     make(map[string]set.Set[string]),
-		IPSetMetadata:    make(map[string]setMetadata),
-		FailDestroyNames: set.New[string](),
+		IPSetMembers:          make(map[string]set.Set[string]),
+		IPSetMetadata:         make(map[string]setMetadata),
+		FailDestroyNames:      set.New[string](),
+		UnsupportedCreateArgs: set.New[string](),
+		FailIPSetUpdates:      map[string]int{},
+		IPSetMemberCounters:   map[string]map[string]MemberCounters{},
 	}
 }
 
@@ -60,6 +81,27 @@ type mockDataplane struct {
 	RestoreOpFailures []string
 	FailNextDestroy   bool
 	FailDestroyNames  set.Set[string]
+	// MaxElemCap, if non-zero, caps the maxelem of IP sets created by restore, as the kernel
+	// may do.
+	MaxElemCap int
+	// LegacyIPSet makes the mock dataplane report an old ipset version and expect the legacy
+	// 'ipset restore' dialect (no COMMIT and -exist rather than --exist).
+	LegacyIPSet bool
+	// IPSetVersion, if non-empty, overrides the output of 'ipset version'.
+	IPSetVersion string
+	// CommandMissing makes every ipset command fail to start, as if the ipset binary wasn't
+	// installed.  NumMissingCmds counts the commands that failed this way.
+	CommandMissing bool
+	NumMissingCmds int
+	// UnsupportedCreateArgs contains the IP set types and create options that the mock ipset
+	// binary rejects in an 'ipset create' command.
+	UnsupportedCreateArgs set.Set[string]
+	// FailIPSetUpdates maps IP set names to the number of times that restore lines for that IP
+	// set should fail.
+	FailIPSetUpdates map[string]int
+	// IPSetMemberCounters contains the non-zero counters of the members of IP sets that have
+	// counters.
+	IPSetMemberCounters map[string]map[string]MemberCounters
 
 	// Record when various (expected) error cases are hit.
 	TriedToDeleteNonExistent bool
@@ -89,6 +131,10 @@ func (d *mockDataplane) newCmd(name string, arg ...string) CmdIface {
 	if name != "ipset" {
 		Fail("Unknown command: " + name)
 	}
+	if d.CommandMissing {
+		d.NumMissingCmds++
+		return missingCmd{name: name}
+	}
 
 	var cmd CmdIface
 
@@ -100,6 +146,17 @@ func (d *mockDataplane) newCmd(name string, arg ...string) CmdIface {
 			Dataplane: d,
 			resultC:   make(chan error),
 		}
+	case "version":
+		Expect(len(arg)).To(Equal(1))
+		cmd = &versionCmd{
+			Dataplane: d,
+		}
+	case "create":
+		Expect(len(arg)).To(BeNumerically(">=", 3))
+		cmd = &createCmd{
+			Dataplane: d,
+			Args:      arg[1:],
+		}
 	case "destroy":
 		Expect(len(arg)).To(Equal(2))
 		name := arg[1]
@@ -108,11 +165,16 @@ func (d *mockDataplane) newCmd(name string, arg ...string) CmdIface {
 			SetName:   name,
 		}
 	case "list":
-		Expect(len(arg)).To(Equal(1))
-		cmd = &listCmd{
+		Expect(len(arg)).To(BeNumerically("<=", 2))
+		lc := &listCmd{
 			Dataplane: d,
 			resultC:   make(chan error),
 		}
+		if len(arg) == 2 {
+			// Listing a single IP set.
+			lc.SetName = arg[1]
+		}
+		cmd = lc
 	default:
 		Fail(fmt.Sprintf("Unexpected command %v", arg))
 	}
@@ -123,6 +185,27 @@ func (d *mockDataplane) newCmd(name string, arg ...string) CmdIface {
 	return cmd
 }
 
+// missingCmd fails to start, as exec.Cmd does if the binary isn't installed.
+type missingCmd struct {
+	name string
+}
+
+func (c missingCmd) err() error {
+	return &exec.Error{Name: c.name, Err: exec.ErrNotFound}
+}
+
+func (c missingCmd) StdinPipe() (WriteCloserFlusher, error) {
+	return &BufferedCloser{BufWriter: nil, Closer: io.NopCloser(nil)}, nil
+}
+func (c missingCmd) StdoutPipe() (io.ReadCloser, error) { return io.NopCloser(nil), nil }
+func (c missingCmd) SetStdin(io.Reader)                 {}
+func (c missingCmd) SetStdout(io.Writer)                {}
+func (c missingCmd) SetStderr(io.Writer)                {}
+func (c missingCmd) Start() error                       { return c.err() }
+func (c missingCmd) Wait() error                        { return c.err() }
+func (c missingCmd) Output() ([]byte, error)            { return nil, c.err() }
+func (c missingCmd) CombinedOutput() ([]byte, error)    { return nil, c.err() }
+
 func (d *mockDataplane) NumRestoreCalls() int {
 	return d.numRestoreCalls
 }
@@ -241,8 +324,8 @@ func (c *restoreCmd) main() {
 
 	defer func() {
 		log.WithField("procResult", result).Info("restore command main is exiting")
-		if c.Stdin != nil && result != nil {
-			c.Stdin.(io.Closer).Close()
+		if closer, ok := c.Stdin.(io.Closer); ok && result != nil {
+			closer.Close()
 		}
 		c.resultC <- result
 	}()
@@ -283,8 +366,18 @@ func (c *restoreCmd) main() {
 			"subCmd":  subCmd,
 		}).Info("Mock dataplane, analysing ipset restore line")
 		c.Dataplane.LinesExecuted = append(c.Dataplane.LinesExecuted, line)
+		if len(parts) > 1 && c.Dataplane.FailIPSetUpdates[parts[1]] > 0 {
+			log.WithField("setName", parts[1]).Warn("Simulating failure to update IP set")
+			c.Dataplane.FailIPSetUpdates[parts[1]]--
+			_, _ = c.Stderr.Write([]byte("simulated failure"))
+			result = &exec.ExitError{}
+			return
+		}
 		if subCmd != "COMMIT" {
-			Expect(commitSeen).To(BeFalse())
+			// Only the final COMMIT counts; there may be others between the IP sets.
+			commitSeen = false
+		} else {
+			Expect(c.Dataplane.LegacyIPSet).To(BeFalse(), "legacy ipset doesn't support COMMIT")
 		}
 		switch subCmd {
 		case "create":
@@ -296,9 +389,30 @@ func (c *restoreCmd) main() {
 			Expect(ipSetType.IsValid()).To(BeTrue(), "Invalid IP set type: "+parts[2])
 
 			var meta setMetadata
-			if ipSetType == IPSetTypeBitmapPort {
+			counters := parts[len(parts)-1] == "counters"
+			if counters {
+				parts = parts[:len(parts)-1]
+			}
+			if ipSetType == IPSetTypeListSet {
+				// create cali4t0 list:set [size 8] [timeout 60]
+				if len(parts) > 4 && parts[3] == "size" {
+					size, err := strconv.Atoi(parts[4])
+					Expect(err).NotTo(HaveOccurred())
+					meta.MaxSize = size
+					parts = append(parts[:3:3], parts[5:]...)
+				}
+				parts = parseTimeoutArg(parts, 3, &meta.Timeout)
+				Expect(parts).To(HaveLen(3))
+				meta = setMetadata{
+					Name:    name,
+					MaxSize: meta.MaxSize,
+					Type:    ipSetType,
+					Timeout: meta.Timeout,
+				}
+			} else if ipSetType == IPSetTypeBitmapPort {
 				// Has no "family".
-				// create cali4t0 bitmap:port range 10-1024
+				// create cali4t0 bitmap:port range 10-1024 [timeout 60]
+				parts = parseTimeoutArg(parts, 5, &meta.Timeout)
 				Expect(parts).To(HaveLen(5))
 				Expect(parts[3]).To(Equal("range"))
 				rMin, rMax, err := ParseRange(parts[4])
@@ -308,8 +422,22 @@ func (c *restoreCmd) main() {
 					RangeMin: rMin,
 					RangeMax: rMax,
 					Type:     ipSetType,
+					Timeout:  meta.Timeout,
 				}
 			} else {
+				// create cali4t0 hash:ip family inet [hashsize 1024] maxelem 1234 [timeout 60]
+				if len(parts) > 6 && parts[5] == "hashsize" {
+					hashSize, err := strconv.Atoi(parts[6])
+					Expect(err).NotTo(HaveOccurred())
+					Expect(hashSize).To(BeNumerically(">", 0))
+					// The kernel rounds the hash size up to a power of two.
+					meta.HashSize = 1
+					for meta.HashSize < hashSize {
+						meta.HashSize *= 2
+					}
+					parts = append(parts[:5:5], parts[7:]...)
+				}
+				parts = parseTimeoutArg(parts, 7, &meta.Timeout)
 				Expect(parts).To(HaveLen(7))
 				Expect(parts[3]).To(Equal("family"))
 				ipFamily := IPFamily(parts[4])
@@ -318,13 +446,19 @@ func (c *restoreCmd) main() {
 				Expect(parts[5]).To(Equal("maxelem"))
 				maxElem, err := strconv.Atoi(parts[6])
 				Expect(err).NotTo(HaveOccurred())
+				if c.Dataplane.MaxElemCap > 0 && maxElem > c.Dataplane.MaxElemCap {
+					maxElem = c.Dataplane.MaxElemCap
+				}
 				meta = setMetadata{
-					Name:    name,
-					Family:  ipFamily,
-					MaxSize: maxElem,
-					Type:    ipSetType,
+					Name:     name,
+					Family:   ipFamily,
+					MaxSize:  maxElem,
+					HashSize: meta.HashSize,
+					Type:     ipSetType,
+					Timeout:  meta.Timeout,
 				}
 			}
+			meta.Counters = counters
 			log.WithField("setMetadata", meta).Info("Set created")
 
 			if _, ok := c.Dataplane.IPSetMembers[name]; ok {
@@ -350,18 +484,53 @@ func (c *restoreCmd) main() {
 				return
 			}
 			delete(c.Dataplane.IPSetMembers, name)
+			delete(c.Dataplane.IPSetMemberCounters, name)
 			log.WithField("setName", name).Info("Set destroyed")
 		case "add":
+			// With a timeout, the add may also have an exist flag to reset the timeout of an
+			// existing member.
+			exist := len(parts) > 3 && strings.HasSuffix(parts[len(parts)-1], "-exist")
+			if exist {
+				parts = parts[:len(parts)-1]
+			}
+			// The kernel stores an exception as a member with the nomatch flag; we store it as
+			// "<cidr> nomatch", which is how 'ipset list' shows it.
+			noMatch := len(parts) > 3 && parts[3] == "nomatch"
+			if noMatch {
+				parts = append(parts[:3:3], parts[4:]...)
+			}
+			var counters MemberCounters
+			parts = parseCountersArgs(parts, 3, &counters)
+			var timeout int
+			parts = parseTimeoutArg(parts, 3, &timeout)
 			Expect(len(parts)).To(Equal(3))
 			name := parts[1]
 			newMember := parts[2]
+			otherForm := newMember + " nomatch"
+			if noMatch {
+				Expect(c.Dataplane.IPSetMetadata[name].Type).To(Equal(IPSetTypeHashNet),
+					"nomatch on IP set that isn't hash:net")
+				newMember, otherForm = otherForm, newMember
+			}
 			logCxt := log.WithField("setName", name)
 			if currentMembers, ok := c.Dataplane.IPSetMembers[name]; !ok {
 				_, _ = c.Stderr.Write([]byte("set doesn't exist"))
 				result = &exec.ExitError{}
 				return
 			} else {
-				if currentMembers.Contains(newMember) {
+				if timeout > 0 {
+					Expect(c.Dataplane.IPSetMetadata[name].Timeout).NotTo(BeZero(),
+						"member timeout on IP set without timeout support")
+				}
+				if c.Dataplane.IPSetMetadata[name].Type == IPSetTypeListSet {
+					if _, ok := c.Dataplane.IPSetMembers[newMember]; !ok {
+						logCxt.WithField("member", newMember).Warn("Add of non-existent IP set to list:set")
+						_, _ = c.Stderr.Write([]byte("set to be added doesn't exist"))
+						result = &exec.ExitError{}
+						return
+					}
+				}
+				if (currentMembers.Contains(newMember) || currentMembers.Contains(otherForm)) && !exist {
 					c.Dataplane.TriedToAddExistent = true
 					logCxt.Warn("Add of existing member")
 					_, _ = c.Stderr.Write([]byte("member already exists"))
@@ -369,24 +538,37 @@ func (c *restoreCmd) main() {
 					return
 				}
 				currentMembers.Add(newMember)
+				if counters != (MemberCounters{}) {
+					Expect(c.Dataplane.IPSetMetadata[name].Counters).To(BeTrue(),
+						"member counters on IP set without counters")
+					if c.Dataplane.IPSetMemberCounters[name] == nil {
+						c.Dataplane.IPSetMemberCounters[name] = map[string]MemberCounters{}
+					}
+					c.Dataplane.IPSetMemberCounters[name][newMember] = counters
+				}
 				logCxt.WithField("member", newMember).Info("Member added")
 			}
 		case "del":
 			Expect(len(parts)).To(Equal(4))
 			name := parts[1]
 			newMember := parts[2]
-			Expect(parts[3]).To(Equal("--exist"))
+			if c.Dataplane.LegacyIPSet {
+				Expect(parts[3]).To(Equal("-exist"))
+			} else {
+				Expect(parts[3]).To(Equal("--exist"))
+			}
 			logCxt := log.WithField("setName", name)
 			if currentMembers, ok := c.Dataplane.IPSetMembers[name]; !ok {
 				_, _ = c.Stderr.Write([]byte("set doesn't exist"))
 				result = &exec.ExitError{}
 				return
 			} else {
-				existing := currentMembers.Contains(newMember)
+				existing := currentMembers.Contains(newMember) || currentMembers.Contains(newMember+" nomatch")
 				if !existing {
 					c.Dataplane.TriedToDeleteNonExistent = true
 				}
 				currentMembers.Discard(newMember)
+				currentMembers.Discard(newMember + " nomatch")
 				logCxt.WithFields(log.Fields{
 					"member":        newMember,
 					"existedBefore": existing},
@@ -425,6 +607,11 @@ func (c *restoreCmd) main() {
 				meta2 := c.Dataplane.IPSetMetadata[name2]
 				c.Dataplane.IPSetMetadata[name1] = meta2
 				c.Dataplane.IPSetMetadata[name2] = meta1
+
+				counters1 := c.Dataplane.IPSetMemberCounters[name1]
+				counters2 := c.Dataplane.IPSetMemberCounters[name2]
+				c.Dataplane.IPSetMemberCounters[name1] = counters2
+				c.Dataplane.IPSetMemberCounters[name2] = counters1
 			}
 		case "COMMIT":
 			commitSeen = true
@@ -432,7 +619,7 @@ func (c *restoreCmd) main() {
 			Fail("Unknown action: " + line)
 		}
 	}
-	Expect(commitSeen).To(BeTrue())
+	Expect(commitSeen).To(Equal(!c.Dataplane.LegacyIPSet))
 
 	if c.Dataplane.popRestoreFailure("post-update") {
 		result = transientFailure
@@ -440,9 +627,15 @@ func (c *restoreCmd) main() {
 	}
 }
 
-func (d *restoreCmd) CombinedOutput() ([]byte, error) {
-	Fail("Not implemented")
-	return nil, errors.New("Not implemented")
+func (c *restoreCmd) CombinedOutput() ([]byte, error) {
+	var output bytes.Buffer
+	c.Stdout = &output
+	c.Stderr = &output
+	if err := c.Start(); err != nil {
+		return nil, err
+	}
+	err := c.Wait()
+	return output.Bytes(), err
 }
 
 type setMetadata struct {
@@ -450,8 +643,38 @@ type setMetadata struct {
 	Family   IPFamily
 	Type     IPSetType
 	MaxSize  int
+	HashSize int
 	RangeMin int
 	RangeMax int
+	Timeout  int
+	Counters bool
+}
+
+// parseTimeoutArg parses the "timeout N" that may follow the numArgs arguments of a restore
+// line, storing N in timeout and returning the line without it.
+func parseTimeoutArg(parts []string, numArgs int, timeout *int) []string {
+	if len(parts) != numArgs+2 || parts[numArgs] != "timeout" {
+		return parts
+	}
+	t, err := strconv.Atoi(parts[numArgs+1])
+	Expect(err).NotTo(HaveOccurred())
+	Expect(t).To(BeNumerically(">", 0))
+	*timeout = t
+	return parts[:numArgs]
+}
+
+// parseCountersArgs parses the "packets P bytes B" that may follow the numArgs arguments of a
+// restore line, storing them in counters and returning the line without them.
+func parseCountersArgs(parts []string, numArgs int, counters *MemberCounters) []string {
+	if len(parts) < numArgs+4 || parts[numArgs] != "packets" || parts[numArgs+2] != "bytes" {
+		return parts
+	}
+	var err error
+	counters.Packets, err = strconv.ParseUint(parts[numArgs+1], 10, 64)
+	Expect(err).NotTo(HaveOccurred())
+	counters.Bytes, err = strconv.ParseUint(parts[numArgs+3], 10, 64)
+	Expect(err).NotTo(HaveOccurred())
+	return append(parts[:numArgs:numArgs], parts[numArgs+4:]...)
 }
 
 type destroyCmd struct {
@@ -653,6 +876,10 @@ func (c *listCmd) Output() ([]byte, error) {
 	}
 	go c.main()
 	_, err = io.Copy(&buf, pipe)
+	resultErr := <-c.resultC
+	if err == nil {
+		err = resultErr
+	}
 	return buf.Bytes(), err
 }
 
@@ -697,8 +924,19 @@ func (c *listCmd) main() {
 		return
 	}
 
+	if c.SetName != "" {
+		if _, ok := c.Dataplane.IPSetMembers[c.SetName]; !ok {
+			log.WithField("setName", c.SetName).Info("Listing a non-existent IP set")
+			result = &exec.ExitError{}
+			return
+		}
+	}
+
 	first := true
 	for setName, members := range c.Dataplane.IPSetMembers {
+		if c.SetName != "" && setName != c.SetName {
+			continue
+		}
 		if !first {
 			fmt.Fprint(c.Stdout, "\n")
 		}
@@ -714,19 +952,156 @@ func (c *listCmd) main() {
 			}
 		}
 		fmt.Fprintf(c.Stdout, "Type: %s\n", meta.Type)
-		if meta.Type == IPSetTypeBitmapPort {
-			fmt.Fprintf(c.Stdout, "Header: family %s range %d-%d\n", meta.Family, meta.RangeMin, meta.RangeMax)
+		timeoutSuffix := ""
+		if meta.Timeout > 0 {
+			timeoutSuffix = fmt.Sprintf(" timeout %d", meta.Timeout)
+		}
+		countersSuffix := ""
+		if meta.Counters {
+			countersSuffix = " counters"
+		}
+		if meta.Type == IPSetTypeListSet {
+			size := meta.MaxSize
+			if size == 0 {
+				size = 8
+			}
+			fmt.Fprintf(c.Stdout, "Header: size %d%s%s\n", size, timeoutSuffix, countersSuffix)
+		} else if meta.Type == IPSetTypeBitmapPort {
+			fmt.Fprintf(c.Stdout, "Header: family %s range %d-%d%s%s\n", meta.Family, meta.RangeMin, meta.RangeMax, timeoutSuffix, countersSuffix)
 		} else if meta.Type == "unknown:type" {
 			fmt.Fprintf(c.Stdout, "Header: floop\n")
 		} else {
-			fmt.Fprintf(c.Stdout, "Header: family %s hashsize 1024 maxelem %d\n", meta.Family, meta.MaxSize)
+			hashSize := meta.HashSize
+			if hashSize == 0 {
+				hashSize = 1024
+			}
+			fmt.Fprintf(c.Stdout, "Header: family %s hashsize %d maxelem %d%s%s\n", meta.Family, hashSize, meta.MaxSize, timeoutSuffix, countersSuffix)
 		}
 		fmt.Fprint(c.Stdout, "Field: foobar\n") // Dummy field, should get ignored.
 		fmt.Fprint(c.Stdout, "Members:\n")
 		members.Iter(func(member string) error {
-			fmt.Fprintf(c.Stdout, "%s\n", member)
+			// The mock doesn't track per-member timeouts; report the default.
+			memberCounters := ""
+			if meta.Counters {
+				mc := c.Dataplane.IPSetMemberCounters[setName][member]
+				memberCounters = fmt.Sprintf(" packets %d bytes %d", mc.Packets, mc.Bytes)
+			}
+			fmt.Fprintf(c.Stdout, "%s%s%s\n", member, timeoutSuffix, memberCounters)
 			return nil
 		})
 		first = false
 	}
 }
+
+type versionCmd struct {
+	Dataplane *mockDataplane
+}
+
+func (c *versionCmd) SetStdin(_ io.Reader) {
+	Fail("versionCmd expects no input")
+}
+
+func (c *versionCmd) SetStderr(r io.Writer) {
+	Fail("not implemented")
+}
+
+func (c *versionCmd) SetStdout(r io.Writer) {
+	Fail("not implemented")
+}
+
+func (c *versionCmd) StdinPipe() (WriteCloserFlusher, error) {
+	Fail("Not implemented")
+	return nil, errors.New("Not implemented")
+}
+
+func (c *versionCmd) StdoutPipe() (io.ReadCloser, error) {
+	Fail("Not implemented")
+	return nil, errors.New("Not implemented")
+}
+
+func (c *versionCmd) Start() error {
+	Fail("Not implemented")
+	return errors.New("Not implemented")
+}
+
+func (c *versionCmd) Wait() error {
+	Fail("Not implemented")
+	return errors.New("Not implemented")
+}
+
+func (c *versionCmd) Output() ([]byte, error) {
+	if c.Dataplane.IPSetVersion != "" {
+		return []byte(c.Dataplane.IPSetVersion), nil
+	}
+	if c.Dataplane.LegacyIPSet {
+		return []byte("ipset v6.11, protocol version: 6\n"), nil
+	}
+	return []byte("ipset v7.11, protocol version: 7\n"), nil
+}
+
+func (c *versionCmd) CombinedOutput() ([]byte, error) {
+	return c.Output()
+}
+
+// createCmd simulates 'ipset create', as used by the self-test.
+type createCmd struct {
+	Dataplane *mockDataplane
+	Args      []string
+}
+
+func (c *createCmd) SetStdin(_ io.Reader) {
+	Fail("createCmd expects no input")
+}
+
+func (c *createCmd) SetStderr(r io.Writer) {
+	Fail("not implemented")
+}
+
+func (c *createCmd) SetStdout(r io.Writer) {
+	Fail("not implemented")
+}
+
+func (c *createCmd) StdinPipe() (WriteCloserFlusher, error) {
+	Fail("Not implemented")
+	return nil, errors.New("Not implemented")
+}
+
+func (c *createCmd) StdoutPipe() (io.ReadCloser, error) {
+	Fail("Not implemented")
+	return nil, errors.New("Not implemented")
+}
+
+func (c *createCmd) Start() error {
+	Fail("Not implemented")
+	return errors.New("Not implemented")
+}
+
+func (c *createCmd) Wait() error {
+	Fail("Not implemented")
+	return errors.New("Not implemented")
+}
+
+func (c *createCmd) Output() ([]byte, error) {
+	Fail("Not implemented")
+	return nil, errors.New("Not implemented")
+}
+
+func (c *createCmd) CombinedOutput() ([]byte, error) {
+	name, ipSetType := c.Args[0], IPSetType(c.Args[1])
+	Expect(len(name)).To(BeNumerically("<=", MaxIPSetNameLength))
+	Expect(ipSetType.IsValid()).To(BeTrue(), "Invalid IP set type: "+c.Args[1])
+	if c.Dataplane.UnsupportedCreateArgs.Contains(string(ipSetType)) {
+		return []byte(fmt.Sprintf("ipset v7.11: Syntax error: typename '%s' is unknown\n", ipSetType)), &exec.ExitError{}
+	}
+	for _, arg := range c.Args[2:] {
+		if c.Dataplane.UnsupportedCreateArgs.Contains(arg) {
+			return []byte(fmt.Sprintf("ipset v7.11: Unknown argument: `%s'\n", arg)), &exec.ExitError{}
+		}
+	}
+	if _, ok := c.Dataplane.IPSetMembers[name]; ok {
+		return []byte("ipset v7.11: Set cannot be created: set with the same name already exists\n"), &exec.ExitError{}
+	}
+	c.Dataplane.IPSetMembers[name] = set.New[string]()
+	c.Dataplane.IPSetMetadata[name] = setMetadata{Name: name, Type: ipSetType}
+	return nil, nil
+}
@@ -33,8 +33,8 @@ var _ = Describe("NAT", func() {
 	var rrConfigNormal = Config{
 		IPIPEnabled:          true,
 		IPIPTunnelAddress:    nil,
-		IPSetConfigV4:        ipsets.NewIPVersionConfig(ipsets.IPFamilyV4, "cali", nil, nil),
-		IPSetConfigV6:        ipsets.NewIPVersionConfig(ipsets.IPFamilyV6, "cali", nil, nil),
+		IPSetConfigV4:        ipsets.NewIPVersionConfig(ipsets.IPFamilyV4, "cali", nil, nil, nil),
+		IPSetConfigV6:        ipsets.NewIPVersionConfig(ipsets.IPFamilyV6, "cali", nil, nil, nil),
 		IptablesMarkAccept:   0x8,
 		IptablesMarkPass:     0x10,
 		IptablesMarkScratch0: 0x20,
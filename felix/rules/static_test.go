@@ -90,8 +90,8 @@ var _ = Describe("Static", func() {
 			BeforeEach(func() {
 				conf = Config{
 					WorkloadIfacePrefixes: []string{"cali"},
-					IPSetConfigV4:         ipsets.NewIPVersionConfig(ipsets.IPFamilyV4, "cali", nil, nil),
-					IPSetConfigV6:         ipsets.NewIPVersionConfig(ipsets.IPFamilyV6, "cali", nil, nil),
+					IPSetConfigV4:         ipsets.NewIPVersionConfig(ipsets.IPFamilyV4, "cali", nil, nil, nil),
+					IPSetConfigV6:         ipsets.NewIPVersionConfig(ipsets.IPFamilyV6, "cali", nil, nil, nil),
 					FailsafeInboundHostPorts: []config.ProtoPort{
 						{Net: "0.0.0.0/0", Protocol: "tcp", Port: 22},
 						{Net: "10.0.0.0/24", Protocol: "tcp", Port: 1022},
@@ -617,8 +617,8 @@ var _ = Describe("Static", func() {
 					WorkloadIfacePrefixes:       []string{"cali"},
 					IPIPEnabled:                 true,
 					IPIPTunnelAddress:           net.ParseIP("10.0.0.1"),
-					IPSetConfigV4:               ipsets.NewIPVersionConfig(ipsets.IPFamilyV4, "cali", nil, nil),
-					IPSetConfigV6:               ipsets.NewIPVersionConfig(ipsets.IPFamilyV6, "cali", nil, nil),
+					IPSetConfigV4:               ipsets.NewIPVersionConfig(ipsets.IPFamilyV4, "cali", nil, nil, nil),
+					IPSetConfigV6:               ipsets.NewIPVersionConfig(ipsets.IPFamilyV6, "cali", nil, nil, nil),
 					IptablesMarkAccept:          0x10,
 					IptablesMarkPass:            0x20,
 					IptablesMarkScratch0:        0x40,
@@ -1057,8 +1057,8 @@ var _ = Describe("Static", func() {
 		BeforeEach(func() {
 			conf = Config{
 				WorkloadIfacePrefixes:       []string{"cali"},
-				IPSetConfigV4:               ipsets.NewIPVersionConfig(ipsets.IPFamilyV4, "cali", nil, nil),
-				IPSetConfigV6:               ipsets.NewIPVersionConfig(ipsets.IPFamilyV6, "cali", nil, nil),
+				IPSetConfigV4:               ipsets.NewIPVersionConfig(ipsets.IPFamilyV4, "cali", nil, nil, nil),
+				IPSetConfigV6:               ipsets.NewIPVersionConfig(ipsets.IPFamilyV6, "cali", nil, nil, nil),
 				IptablesMarkAccept:          0x10,
 				IptablesMarkPass:            0x20,
 				IptablesMarkScratch0:        0x40,
@@ -1152,8 +1152,8 @@ var _ = Describe("Static", func() {
 		BeforeEach(func() {
 			conf = Config{
 				WorkloadIfacePrefixes:        []string{"tap"},
-				IPSetConfigV4:                ipsets.NewIPVersionConfig(ipsets.IPFamilyV4, "cali", nil, nil),
-				IPSetConfigV6:                ipsets.NewIPVersionConfig(ipsets.IPFamilyV6, "cali", nil, nil),
+				IPSetConfigV4:                ipsets.NewIPVersionConfig(ipsets.IPFamilyV4, "cali", nil, nil, nil),
+				IPSetConfigV6:                ipsets.NewIPVersionConfig(ipsets.IPFamilyV6, "cali", nil, nil, nil),
 				OpenStackSpecialCasesEnabled: true,
 				OpenStackMetadataIP:          net.ParseIP("10.0.0.1"),
 				OpenStackMetadataPort:        1234,
@@ -1255,8 +1255,8 @@ var _ = Describe("Static", func() {
 		BeforeEach(func() {
 			conf = Config{
 				WorkloadIfacePrefixes:        []string{"tap"},
-				IPSetConfigV4:                ipsets.NewIPVersionConfig(ipsets.IPFamilyV4, "cali", nil, nil),
-				IPSetConfigV6:                ipsets.NewIPVersionConfig(ipsets.IPFamilyV6, "cali", nil, nil),
+				IPSetConfigV4:                ipsets.NewIPVersionConfig(ipsets.IPFamilyV4, "cali", nil, nil, nil),
+				IPSetConfigV6:                ipsets.NewIPVersionConfig(ipsets.IPFamilyV6, "cali", nil, nil, nil),
 				OpenStackSpecialCasesEnabled: true,
 				OpenStackMetadataIP:          net.ParseIP("10.0.0.1"),
 				OpenStackMetadataPort:        1234,
@@ -1327,8 +1327,8 @@ var _ = Describe("Static", func() {
 		BeforeEach(func() {
 			conf = Config{
 				WorkloadIfacePrefixes:       []string{"cali"},
-				IPSetConfigV4:               ipsets.NewIPVersionConfig(ipsets.IPFamilyV4, "cali", nil, nil),
-				IPSetConfigV6:               ipsets.NewIPVersionConfig(ipsets.IPFamilyV6, "cali", nil, nil),
+				IPSetConfigV4:               ipsets.NewIPVersionConfig(ipsets.IPFamilyV4, "cali", nil, nil, nil),
+				IPSetConfigV6:               ipsets.NewIPVersionConfig(ipsets.IPFamilyV6, "cali", nil, nil, nil),
 				IptablesMarkAccept:          0x10,
 				IptablesMarkPass:            0x20,
 				IptablesMarkScratch0:        0x40,
@@ -1434,8 +1434,8 @@ var _ = Describe("Static", func() {
 				BeforeEach(func() {
 					conf = Config{
 						WorkloadIfacePrefixes:       []string{"cali"},
-						IPSetConfigV4:               ipsets.NewIPVersionConfig(ipsets.IPFamilyV4, "cali", nil, nil),
-						IPSetConfigV6:               ipsets.NewIPVersionConfig(ipsets.IPFamilyV6, "cali", nil, nil),
+						IPSetConfigV4:               ipsets.NewIPVersionConfig(ipsets.IPFamilyV4, "cali", nil, nil, nil),
+						IPSetConfigV6:               ipsets.NewIPVersionConfig(ipsets.IPFamilyV6, "cali", nil, nil, nil),
 						IptablesMarkAccept:          0x10,
 						IptablesMarkPass:            0x20,
 						IptablesMarkScratch0:        0x40,
@@ -19,6 +19,7 @@ import (
 	"strings"
 	"sync"
 
+	"github.com/projectcalico/calico/libcalico-go/lib/apiconfig"
 	apiv3 "github.com/projectcalico/calico/libcalico-go/lib/apis/v3"
 	bapi "github.com/projectcalico/calico/libcalico-go/lib/backend/api"
 	"github.com/projectcalico/calico/libcalico-go/lib/backend/model"
@@ -161,6 +162,20 @@ func (f *FakeCalicoClient) EnsureInitialized(ctx context.Context, calicoVersion
 	panic("not implemented")
 }
 
+// BackendType returns the type of datastore that the client is connected to.
+func (f *FakeCalicoClient) BackendType() apiconfig.DatastoreType {
+	panic("not implemented")
+}
+
+// Capabilities returns the optional operations that are supported by the datastore.
+func (f *FakeCalicoClient) Capabilities() clientv3.Capabilities {
+	panic("not implemented")
+}
+
+func (f *FakeCalicoClient) Txn() *clientv3.Txn {
+	panic("not implemented")
+}
+
 // fakeNodeClient implements the clientv3 NodeInterface for testing purposes.
 type fakeNodeClient struct {
 	sync.Mutex
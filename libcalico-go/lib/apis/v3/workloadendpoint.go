@@ -24,6 +24,10 @@ import (
 const (
 	KindWorkloadEndpoint     = "WorkloadEndpoint"
 	KindWorkloadEndpointList = "WorkloadEndpointList"
+
+	// AnnotationReserved is set on a placeholder WorkloadEndpoint that has been reserved (but not
+	// yet finalized) by the client's Reserve method.
+	AnnotationReserved = "projectcalico.org/reserved"
 )
 
 // +genclient
@@ -0,0 +1,52 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v3
+
+import (
+	"fmt"
+
+	"github.com/projectcalico/calico/libcalico-go/lib/names"
+)
+
+// ParseWorkloadEndpointName splits a fully qualified WorkloadEndpoint name into its node,
+// orchestrator, pod and endpoint, undoing the escaping of dashes within each of them.  The pod
+// is only set for Kubernetes endpoints; for other orchestrators, such as cni, it is empty.  See
+// names.WorkloadEndpointIdentifiers for the naming format.
+func ParseWorkloadEndpointName(name string) (node, orchestrator, pod, endpoint string, err error) {
+	ids, err := names.ParseWorkloadEndpointName(name)
+	if err != nil {
+		return "", "", "", "", err
+	}
+	if ids.Orchestrator == "" || ids.Endpoint == "" {
+		// A prefix, such as "node-k8s-pod-", rather than a complete name.
+		return "", "", "", "", fmt.Errorf("Cannot parse %s: not a complete WorkloadEndpoint name", name)
+	}
+	return ids.Node, ids.Orchestrator, ids.Pod, ids.Endpoint, nil
+}
+
+// BuildWorkloadEndpointName returns the name of the WorkloadEndpoint with the given spec,
+// escaping any dashes within each of its identifiers.  It returns an error if the spec is
+// missing an identifier that the name of an endpoint for its orchestrator requires.
+func BuildWorkloadEndpointName(spec WorkloadEndpointSpec) (string, error) {
+	ids := names.WorkloadEndpointIdentifiers{
+		Node:         spec.Node,
+		Orchestrator: spec.Orchestrator,
+		Endpoint:     spec.Endpoint,
+		Workload:     spec.Workload,
+		Pod:          spec.Pod,
+		ContainerID:  spec.ContainerID,
+	}
+	return ids.CalculateWorkloadEndpointName(false)
+}
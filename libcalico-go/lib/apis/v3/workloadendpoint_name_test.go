@@ -0,0 +1,101 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v3_test
+
+import (
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	libapiv3 "github.com/projectcalico/calico/libcalico-go/lib/apis/v3"
+)
+
+var _ = DescribeTable("WorkloadEndpoint name round trip",
+	func(spec libapiv3.WorkloadEndpointSpec, expectedName string) {
+		name, err := libapiv3.BuildWorkloadEndpointName(spec)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(name).To(Equal(expectedName))
+
+		node, orchestrator, pod, endpoint, err := libapiv3.ParseWorkloadEndpointName(name)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(node).To(Equal(spec.Node))
+		Expect(orchestrator).To(Equal(spec.Orchestrator))
+		Expect(pod).To(Equal(spec.Pod))
+		Expect(endpoint).To(Equal(spec.Endpoint))
+	},
+	Entry("k8s", libapiv3.WorkloadEndpointSpec{
+		Node:         "node",
+		Orchestrator: "k8s",
+		Pod:          "pod",
+		Endpoint:     "eth0",
+	}, "node-k8s-pod-eth0"),
+	Entry("k8s with dashes in the node", libapiv3.WorkloadEndpointSpec{
+		Node:         "node-1",
+		Orchestrator: "k8s",
+		Pod:          "pod",
+		Endpoint:     "eth0",
+	}, "node--1-k8s-pod-eth0"),
+	Entry("k8s with dashes in every identifier", libapiv3.WorkloadEndpointSpec{
+		Node:         "node-1",
+		Orchestrator: "k8s",
+		Pod:          "my-pod-abc",
+		Endpoint:     "eth-0",
+	}, "node--1-k8s-my--pod--abc-eth--0"),
+	Entry("k8s with consecutive dashes", libapiv3.WorkloadEndpointSpec{
+		Node:         "node--1",
+		Orchestrator: "k8s",
+		Pod:          "pod",
+		Endpoint:     "eth0",
+	}, "node----1-k8s-pod-eth0"),
+	Entry("cni, which has no pod", libapiv3.WorkloadEndpointSpec{
+		Node:         "node-1",
+		Orchestrator: "cni",
+		ContainerID:  "abc-def",
+		Endpoint:     "eth0",
+	}, "node--1-cni-abc--def-eth0"),
+	Entry("libnetwork", libapiv3.WorkloadEndpointSpec{
+		Node:         "node",
+		Orchestrator: "libnetwork",
+		Endpoint:     "ep-1",
+	}, "node-libnetwork-libnetwork-ep--1"),
+)
+
+var _ = DescribeTable("WorkloadEndpoint name construction errors",
+	func(spec libapiv3.WorkloadEndpointSpec) {
+		name, err := libapiv3.BuildWorkloadEndpointName(spec)
+		Expect(err).To(HaveOccurred())
+		Expect(name).To(Equal(""))
+	},
+	Entry("missing node", libapiv3.WorkloadEndpointSpec{Orchestrator: "k8s", Pod: "pod", Endpoint: "eth0"}),
+	Entry("missing k8s pod", libapiv3.WorkloadEndpointSpec{Node: "node", Orchestrator: "k8s", Endpoint: "eth0"}),
+	Entry("missing cni container ID", libapiv3.WorkloadEndpointSpec{Node: "node", Orchestrator: "cni", Endpoint: "eth0"}),
+	Entry("identifier with a leading dash", libapiv3.WorkloadEndpointSpec{
+		Node:         "node",
+		Orchestrator: "k8s",
+		Pod:          "-pod",
+		Endpoint:     "eth0",
+	}),
+)
+
+var _ = DescribeTable("WorkloadEndpoint name parsing errors",
+	func(name string) {
+		_, _, _, _, err := libapiv3.ParseWorkloadEndpointName(name)
+		Expect(err).To(HaveOccurred())
+	},
+	Entry("empty name", ""),
+	Entry("node only", "node"),
+	Entry("prefix without an endpoint", "node--1-k8s-pod-"),
+	Entry("legacy k8s name with unescaped dashes", "node-1-k8s-pod-1-eth0"),
+	Entry("too many segments", "node-k8s-pod-eth0-extra"),
+)
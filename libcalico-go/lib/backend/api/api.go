@@ -124,6 +124,58 @@ type Client interface {
 	//Close()
 }
 
+// BatchDeleter is an optional interface, implemented by backend clients that can delete several
+// objects in a single transaction.
+type BatchDeleter interface {
+	// DeleteKVPs removes all the objects specified by the KVPairs, or none of them.  Each
+	// KVPair must contain revision information, and the delete only succeeds if every revision
+	// is still current.  If the delete fails because an object has changed, or no longer
+	// exists, an ErrorResourceUpdateConflict or ErrorResourceDoesNotExist is returned for
+	// that object.  On success, returns the deleted objects, in the same order.
+	DeleteKVPs(ctx context.Context, objects []*model.KVPair) ([]*model.KVPair, error)
+}
+
+// TxnOpType is the type of an operation in a transaction.
+type TxnOpType int
+
+const (
+	TxnOpCreate TxnOpType = iota
+	TxnOpUpdate
+	TxnOpDelete
+)
+
+// TxnOp is an operation in a transaction.  For a create or update, the KVPair holds the object
+// to write; an update must contain revision information.  For a delete, only the key and
+// (optionally) the revision are used.
+type TxnOp struct {
+	Type   TxnOpType
+	KVPair *model.KVPair
+}
+
+// Transactor is an optional interface, implemented by backend clients that can apply several
+// creates, updates and deletes, possibly of different kinds of object, in a single transaction.
+type Transactor interface {
+	// Txn applies all of the operations, or none of them.  Each operation has the same
+	// preconditions as the corresponding single-object method: a create fails if the object
+	// exists, an update (or a delete with revision information) if the revision is no longer
+	// current, and an update or delete if the object doesn't exist.  If an operation's
+	// precondition fails, an ErrorTransactionFailed is returned, holding the index of that
+	// operation and an ErrorResourceAlreadyExists, ErrorResourceUpdateConflict or
+	// ErrorResourceDoesNotExist.  On success, returns the results in the same order as the
+	// operations: the created or updated objects with their new revisions, and the deleted
+	// objects.
+	Txn(ctx context.Context, ops []TxnOp) ([]*model.KVPair, error)
+}
+
+// RevisionReader is an optional interface, implemented by backend clients that can return the
+// current revision of the datastore without reading the objects themselves.
+type RevisionReader interface {
+	// LatestRevision returns the current revision of the objects that match the list options:
+	// the revision that a List of them would return if it were made now, and from which a
+	// Watch of them can be started.
+	LatestRevision(ctx context.Context, list model.ListInterface) (string, error)
+}
+
 type Syncer interface {
 	// Starts the Syncer.  May start a background goroutine.
 	Start()
@@ -195,6 +247,7 @@ const (
 	WatchModified WatchEventType = "MODIFIED"
 	WatchDeleted  WatchEventType = "DELETED"
 	WatchError    WatchEventType = "ERROR"
+	WatchBookmark WatchEventType = "BOOKMARK"
 )
 
 // Event represents a single event to a watched resource.
@@ -202,16 +255,27 @@ type WatchEvent struct {
 	Type WatchEventType
 
 	// Old is:
-	// * If Type is Added or Error: nil
+	// * If Type is Added, Error or Bookmark: nil
 	// * If Type is Modified or Deleted: the previous state of the object
 	// New is:
 	//  * If Type is Added or Modified: the new state of the object.
-	//  * If Type is Deleted or Error: nil
+	//  * If Type is Deleted, Error or Bookmark: nil
 	Old *model.KVPair
 	New *model.KVPair
 
 	// The error, if EventType is Error.
 	Error error
+
+	// The revision that the watch has reached, if EventType is Bookmark.
+	Revision string
+
+	// Expired is true if EventType is Deleted and the entry was deleted because its TTL
+	// expired, rather than by an explicit delete.  Only set by datastores that support TTLs.
+	// The etcdv3 datastore removes the TTL from an entry before explicitly deleting it, which
+	// is seen as a modification of the entry.  That is a separate write from the delete, so if
+	// the client deleting the entry stops between the two, the entry is left without a TTL and
+	// is never deleted by expiry.
+	Expired bool
 }
 
 // FakeWatcher is inspired by apimachinery (watch) FakeWatcher
@@ -34,7 +34,14 @@ func convertListResponse(ekv *mvccpb.KeyValue, l model.ListInterface) *model.KVP
 	log.WithField("etcdv3-etcdKey", string(ekv.Key)).Debug("Processing etcdv3 entry")
 	if k := l.KeyFromDefaultPath(string(ekv.Key)); k != nil {
 		log.WithField("model-etcdKey", k).Debug("Key is valid and converted to model-etcdKey")
-		if v, err := model.ParseValue(k, ekv.Value); err == nil {
+		parse := model.ParseValue
+		if rlo, ok := l.(model.ResourceListOptions); ok && len(rlo.Projection) > 0 {
+			// Only decode the fields that were asked for.
+			parse = func(k model.Key, v []byte) (interface{}, error) {
+				return model.ParseProjectedValue(k, v, rlo.Projection)
+			}
+		}
+		if v, err := parse(k, ekv.Value); err == nil {
 			log.Debug("Value is valid - return KVPair with parsed value")
 			return &model.KVPair{Key: k, Value: v, Revision: strconv.FormatInt(ekv.ModRevision, 10)}
 		}
@@ -24,6 +24,7 @@ import (
 	"time"
 
 	log "github.com/sirupsen/logrus"
+	"go.etcd.io/etcd/api/v3/etcdserverpb"
 	"go.etcd.io/etcd/client/pkg/v3/srv"
 	"go.etcd.io/etcd/client/pkg/v3/transport"
 	clientv3 "go.etcd.io/etcd/client/v3"
@@ -33,6 +34,7 @@ import (
 	apiv3 "github.com/projectcalico/api/pkg/apis/projectcalico/v3"
 
 	"github.com/projectcalico/calico/libcalico-go/lib/apiconfig"
+	libapiv3 "github.com/projectcalico/calico/libcalico-go/lib/apis/v3"
 	"github.com/projectcalico/calico/libcalico-go/lib/backend/api"
 	"github.com/projectcalico/calico/libcalico-go/lib/backend/model"
 	cerrors "github.com/projectcalico/calico/libcalico-go/lib/errors"
@@ -49,6 +51,10 @@ var (
 const (
 	profilesKey            = "/calico/resources/v3/projectcalico.org/profiles/"
 	defaultAllowProfileKey = "/calico/resources/v3/projectcalico.org/profiles/projectcalico-default-allow"
+
+	// maxDeleteAttempts bounds how many times commitDeletes removes leases from keys that keep
+	// being given a new one before it gives up.
+	maxDeleteAttempts = 5
 )
 
 type etcdV3Client struct {
@@ -204,7 +210,11 @@ func (c *etcdV3Client) Create(ctx context.Context, d *model.KVPair) (*model.KVPa
 func (c *etcdV3Client) Update(ctx context.Context, d *model.KVPair) (*model.KVPair, error) {
 	logCxt := log.WithFields(log.Fields{"model-etcdKey": d.Key, "value": d.Value, "ttl": d.TTL, "rev": d.Revision})
 	logCxt.Debug("Processing Update request")
-	key, value, err := getKeyValueStrings(d)
+	kvp, err := c.keepCreationTimestamp(ctx, d)
+	if err != nil {
+		return nil, err
+	}
+	key, value, err := getKeyValueStrings(kvp)
 	if err != nil {
 		return nil, err
 	}
@@ -259,6 +269,46 @@ func (c *etcdV3Client) Update(ctx context.Context, d *model.KVPair) (*model.KVPa
 	return d, nil
 }
 
+// keepCreationTimestamp returns the entry to write for an Update of d.  A WorkloadEndpoint's
+// creation timestamp is assigned on Create and can't be changed, so if d is a WorkloadEndpoint
+// then the returned entry has the stored timestamp.  The update is conditional on the revision
+// that d updates, so reading the stored entry first doesn't add a race: if it has changed since,
+// the update fails anyway.
+func (c *etcdV3Client) keepCreationTimestamp(ctx context.Context, d *model.KVPair) (*model.KVPair, error) {
+	wep, ok := d.Value.(*libapiv3.WorkloadEndpoint)
+	if !ok {
+		return d, nil
+	}
+	key, err := model.KeyToDefaultPath(d.Key)
+	if err != nil {
+		return nil, cerrors.ErrorDatastoreError{Err: err, Identifier: d.Key}
+	}
+	resp, err := c.etcdClient.Get(ctx, key)
+	if err != nil {
+		log.WithError(err).WithField("etcdv3-etcdKey", key).Warning("Failed to get stored WorkloadEndpoint")
+		return nil, cerrors.ErrorDatastoreError{Err: err}
+	}
+	if len(resp.Kvs) == 0 || strconv.FormatInt(resp.Kvs[0].ModRevision, 10) != d.Revision {
+		// The update will fail, and report why.
+		return d, nil
+	}
+	stored, err := etcdToKVPair(d.Key, resp.Kvs[0])
+	if err != nil {
+		// Nothing to keep; the update replaces an unparseable entry.
+		log.WithError(err).WithField("etcdv3-etcdKey", key).Debug("Unable to parse stored entry")
+		return d, nil
+	}
+	storedWEP, ok := stored.Value.(*libapiv3.WorkloadEndpoint)
+	if !ok || storedWEP.CreationTimestamp.Equal(&wep.CreationTimestamp) {
+		return d, nil
+	}
+	kept := *wep
+	kept.CreationTimestamp = storedWEP.CreationTimestamp
+	out := *d
+	out.Value = &kept
+	return &out, nil
+}
+
 // TODO Remove once we get rid of the v1 client.  Apply should no longer be supported
 // at least in it's current guise.  Apply will need to be handled further up the stack
 // by performing a Get/Create or Update to ensure we don't lose certain read-only Metadata.
@@ -315,15 +365,17 @@ func (c *etcdV3Client) Delete(ctx context.Context, k model.Key, revision string)
 		conds = append(conds, clientv3.Compare(clientv3.ModRevision(key), "=", rev))
 	}
 
+	revConds := map[string]int{}
+	if len(conds) != 0 {
+		revConds[key] = 0
+	}
+
 	// Perform the delete transaction - note that this is an exact delete, not a prefix delete.
 	logCxt.Debug("Performing etcdv3 transaction for Delete request")
-	txnResp, err := c.etcdClient.Txn(ctx).If(
-		conds...,
-	).Then(
-		clientv3.OpDelete(key, clientv3.WithPrevKV()),
-	).Else(
-		clientv3.OpGet(key),
-	).Commit()
+	txnResp, thenResps, err := c.commitDeletes(ctx, conds, revConds, []string{key},
+		[]clientv3.Op{clientv3.OpDelete(key, clientv3.WithPrevKV())},
+		[]clientv3.Op{clientv3.OpGet(key)},
+	)
 	if err != nil {
 		logCxt.WithError(err).Warning("Delete failed")
 		return nil, cerrors.ErrorDatastoreError{Err: err, Identifier: k}
@@ -347,7 +399,7 @@ func (c *etcdV3Client) Delete(ctx context.Context, k model.Key, revision string)
 	}
 
 	// The delete response should have succeeded since the Get response did.
-	delResp := txnResp.Responses[0].GetResponseDeleteRange()
+	delResp := thenResps[0].GetResponseDeleteRange()
 	if delResp.Deleted == 0 {
 		logCxt.Debug("Delete transaction failed due to resource not existing")
 		return nil, cerrors.ErrorResourceDoesNotExist{Identifier: k}
@@ -359,6 +411,199 @@ func (c *etcdV3Client) Delete(ctx context.Context, k model.Key, revision string)
 	return previousValue, nil
 }
 
+// DeleteKVPs deletes all of the given entries in a single transaction, or none of them if any
+// of their revisions is no longer current.
+func (c *etcdV3Client) DeleteKVPs(ctx context.Context, kvps []*model.KVPair) ([]*model.KVPair, error) {
+	logCxt := log.WithField("numKVPs", len(kvps))
+	logCxt.Debug("Processing DeleteKVPs request")
+	keys := make([]string, len(kvps))
+	var conds []clientv3.Cmp
+	var deletes, gets []clientv3.Op
+	for i, kvp := range kvps {
+		key, err := model.KeyToDefaultDeletePath(kvp.Key)
+		if err != nil {
+			return nil, err
+		}
+		rev, err := parseRevision(kvp.Revision)
+		if err != nil {
+			return nil, err
+		}
+		keys[i] = key
+		conds = append(conds, clientv3.Compare(clientv3.ModRevision(key), "=", rev))
+		deletes = append(deletes, clientv3.OpDelete(key, clientv3.WithPrevKV()))
+		gets = append(gets, clientv3.OpGet(key))
+	}
+
+	revConds := map[string]int{}
+	for i, key := range keys {
+		revConds[key] = i
+	}
+
+	logCxt.Debug("Performing etcdv3 transaction for DeleteKVPs request")
+	txnResp, thenResps, err := c.commitDeletes(ctx, conds, revConds, keys, deletes, gets)
+	if err != nil {
+		logCxt.WithError(err).Warning("DeleteKVPs failed")
+		return nil, cerrors.ErrorDatastoreError{Err: err}
+	}
+
+	if !txnResp.Succeeded {
+		// Find the first entry that failed the revision check.
+		for i, kvp := range kvps {
+			getResp := txnResp.Responses[i].GetResponseRange()
+			if len(getResp.Kvs) == 0 {
+				logCxt.WithField("etcdv3-etcdKey", keys[i]).Debug("DeleteKVPs failed due to resource not existing")
+				return nil, cerrors.ErrorResourceDoesNotExist{Identifier: kvp.Key}
+			}
+			if strconv.FormatInt(getResp.Kvs[0].ModRevision, 10) != kvp.Revision {
+				logCxt.WithField("etcdv3-etcdKey", keys[i]).Debug("DeleteKVPs failed due to resource update conflict")
+				return nil, cerrors.ErrorResourceUpdateConflict{Identifier: kvp.Key}
+			}
+		}
+		return nil, cerrors.ErrorDatastoreError{Err: errors.New("delete transaction failed")}
+	}
+
+	deleted := make([]*model.KVPair, len(kvps))
+	for i, kvp := range kvps {
+		// Parse the deleted value.  Don't propagate the error in this case since the
+		// delete did succeed.
+		delResp := thenResps[i].GetResponseDeleteRange()
+		if len(delResp.PrevKvs) > 0 {
+			deleted[i], _ = etcdToKVPair(kvp.Key, delResp.PrevKvs[0])
+		}
+	}
+	return deleted, nil
+}
+
+// Txn applies all of the given operations in a single transaction, or none of them if any of
+// their preconditions fails.
+func (c *etcdV3Client) Txn(ctx context.Context, ops []api.TxnOp) ([]*model.KVPair, error) {
+	logCxt := log.WithField("numOps", len(ops))
+	logCxt.Debug("Processing Txn request")
+	keys := make([]string, len(ops))
+	values := make([]string, len(ops))
+	seen := map[string]int{}
+	revConds := map[string]int{}
+	var deleteKeys []string
+	var conds []clientv3.Cmp
+	var thens, elses []clientv3.Op
+	for i, op := range ops {
+		var key string
+		var err error
+		switch op.Type {
+		case api.TxnOpDelete:
+			key, err = model.KeyToDefaultDeletePath(op.KVPair.Key)
+		case api.TxnOpUpdate:
+			// As for Update, keep a WorkloadEndpoint's creation timestamp.
+			var kvp *model.KVPair
+			if kvp, err = c.keepCreationTimestamp(ctx, op.KVPair); err == nil {
+				key, values[i], err = getKeyValueStrings(kvp)
+			}
+		default:
+			key, values[i], err = getKeyValueStrings(op.KVPair)
+		}
+		if err != nil {
+			return nil, cerrors.ErrorTransactionFailed{Index: i, Err: err}
+		}
+		// etcd rejects a transaction that writes the same key more than once.
+		if j, ok := seen[key]; ok {
+			return nil, cerrors.ErrorTransactionFailed{
+				Index: i,
+				Err:   fmt.Errorf("operation writes %v, which is also written by operation %d", op.KVPair.Key, j),
+			}
+		}
+		seen[key] = i
+		keys[i] = key
+
+		switch op.Type {
+		case api.TxnOpCreate:
+			putOpts, err := c.getTTLOption(ctx, op.KVPair)
+			if err != nil {
+				return nil, cerrors.ErrorTransactionFailed{Index: i, Err: err}
+			}
+			conds = append(conds, clientv3.Compare(clientv3.Version(key), "=", 0))
+			thens = append(thens, clientv3.OpPut(key, values[i], putOpts...))
+		case api.TxnOpUpdate:
+			putOpts, err := c.getTTLOption(ctx, op.KVPair)
+			if err != nil {
+				return nil, cerrors.ErrorTransactionFailed{Index: i, Err: err}
+			}
+			rev, err := parseRevision(op.KVPair.Revision)
+			if err != nil {
+				return nil, cerrors.ErrorTransactionFailed{Index: i, Err: err}
+			}
+			conds = append(conds, clientv3.Compare(clientv3.ModRevision(key), "=", rev))
+			thens = append(thens, clientv3.OpPut(key, values[i], putOpts...))
+		case api.TxnOpDelete:
+			if len(op.KVPair.Revision) != 0 {
+				rev, err := parseRevision(op.KVPair.Revision)
+				if err != nil {
+					return nil, cerrors.ErrorTransactionFailed{Index: i, Err: err}
+				}
+				revConds[key] = len(conds)
+				conds = append(conds, clientv3.Compare(clientv3.ModRevision(key), "=", rev))
+			} else {
+				conds = append(conds, clientv3.Compare(clientv3.Version(key), ">", 0))
+			}
+			thens = append(thens, clientv3.OpDelete(key, clientv3.WithPrevKV()))
+			deleteKeys = append(deleteKeys, key)
+		default:
+			return nil, cerrors.ErrorTransactionFailed{Index: i, Err: fmt.Errorf("unknown operation type %v", op.Type)}
+		}
+		elses = append(elses, clientv3.OpGet(key))
+	}
+
+	logCxt.Debug("Performing etcdv3 transaction for Txn request")
+	txnResp, thenResps, err := c.commitDeletes(ctx, conds, revConds, deleteKeys, thens, elses)
+	if err != nil {
+		logCxt.WithError(err).Warning("Txn failed")
+		return nil, cerrors.ErrorDatastoreError{Err: err}
+	}
+
+	if !txnResp.Succeeded {
+		// Find the first operation whose precondition failed.
+		for i, op := range ops {
+			getResp := txnResp.Responses[i].GetResponseRange()
+			exists := len(getResp.Kvs) != 0
+			var opErr error
+			switch {
+			case op.Type == api.TxnOpCreate && exists:
+				opErr = cerrors.ErrorResourceAlreadyExists{Identifier: op.KVPair.Key}
+			case op.Type != api.TxnOpCreate && !exists:
+				opErr = cerrors.ErrorResourceDoesNotExist{Identifier: op.KVPair.Key}
+			case op.Type != api.TxnOpCreate && len(op.KVPair.Revision) != 0 &&
+				strconv.FormatInt(getResp.Kvs[0].ModRevision, 10) != op.KVPair.Revision:
+				opErr = cerrors.ErrorResourceUpdateConflict{Identifier: op.KVPair.Key}
+			default:
+				continue
+			}
+			logCxt.WithError(opErr).WithField("etcdv3-etcdKey", keys[i]).Debug("Txn failed due to failed precondition")
+			return nil, cerrors.ErrorTransactionFailed{Index: i, Err: opErr}
+		}
+		return nil, cerrors.ErrorDatastoreError{Err: errors.New("transaction failed")}
+	}
+
+	results := make([]*model.KVPair, len(ops))
+	revision := strconv.FormatInt(txnResp.Header.Revision, 10)
+	for i, op := range ops {
+		if op.Type == api.TxnOpDelete {
+			// Parse the deleted value.  Don't propagate the error in this case since the
+			// delete did succeed.
+			delResp := thenResps[i].GetResponseDeleteRange()
+			if len(delResp.PrevKvs) > 0 {
+				results[i], _ = etcdToKVPair(op.KVPair.Key, delResp.PrevKvs[0])
+			}
+			continue
+		}
+		v, err := model.ParseValue(op.KVPair.Key, []byte(values[i]))
+		cerrors.PanicIfErrored(err, "Unexpected error parsing stored datastore entry: %v", values[i])
+		out := *op.KVPair
+		out.Value = v
+		out.Revision = revision
+		results[i] = &out
+	}
+	return results, nil
+}
+
 // Get an entry from the datastore.  This errors if the entry does not exist.
 func (c *etcdV3Client) Get(ctx context.Context, k model.Key, revision string) (*model.KVPair, error) {
 	logCxt := log.WithFields(log.Fields{"model-etcdKey": k, "rev": revision})
@@ -410,6 +655,28 @@ func (c *etcdV3Client) List(ctx context.Context, l model.ListInterface, revision
 	key, ops := calculateListKeyAndOptions(logCxt, l)
 	logCxt = logCxt.WithField("etcdv3-etcdKey", key)
 
+	// A paged List gets at most the limit of keys, from the key after the last one of the
+	// previous page to the end of the prefix.  Exact gets aren't paged.
+	rlo, paged := l.(model.ResourceListOptions)
+	paged = paged && rlo.Limit > 0 && len(ops) != 0
+	start := key
+	if paged {
+		end := clientv3.GetPrefixRangeEnd(key)
+		if rlo.Continue != "" {
+			if rlo.Continue <= key || rlo.Continue >= end {
+				return nil, cerrors.ErrorValidation{
+					ErroredFields: []cerrors.ErroredField{{
+						Name:   "Continue",
+						Value:  rlo.Continue,
+						Reason: "continue token is not for this list",
+					}},
+				}
+			}
+			start = rlo.Continue
+		}
+		ops = append(ops, clientv3.WithRange(end), clientv3.WithLimit(rlo.Limit))
+	}
+
 	// We may also need to perform a get based on a particular revision.
 	if len(revision) != 0 {
 		rev, err := parseRevision(revision)
@@ -420,7 +687,7 @@ func (c *etcdV3Client) List(ctx context.Context, l model.ListInterface, revision
 	}
 
 	logCxt.Debug("Calling Get on etcdv3 client")
-	resp, err := c.etcdClient.Get(ctx, key, ops...)
+	resp, err := c.etcdClient.Get(ctx, start, ops...)
 	if err != nil {
 		logCxt.WithError(err).Debug("Error returned from etcdv3 client")
 		return nil, cerrors.ErrorDatastoreError{Err: err}
@@ -442,10 +709,32 @@ func (c *etcdV3Client) List(ctx context.Context, l model.ListInterface, revision
 		list = append(list, resources.DefaultAllowProfile())
 	}
 
-	return &model.KVPairList{
+	kvps := &model.KVPairList{
 		KVPairs:  list,
 		Revision: strconv.FormatInt(resp.Header.Revision, 10),
-	}, nil
+	}
+	if paged && resp.More {
+		// The next page starts just after the last key of this one.  The count is of all the
+		// keys in the range, not just those returned.
+		remaining := resp.Count - int64(len(resp.Kvs))
+		kvps.Continue = string(resp.Kvs[len(resp.Kvs)-1].Key) + "\x00"
+		kvps.RemainingItemCount = &remaining
+	}
+	return kvps, nil
+}
+
+// LatestRevision implements the api.RevisionReader interface.  The etcd revision is global, so
+// it is read from the header of a Get that only counts the matching keys.
+func (c *etcdV3Client) LatestRevision(ctx context.Context, l model.ListInterface) (string, error) {
+	logCxt := log.WithField("list-interface", l)
+	logCxt.Debug("Processing LatestRevision request")
+	key, ops := calculateListKeyAndOptions(logCxt, l)
+	resp, err := c.etcdClient.Get(ctx, key, append(ops, clientv3.WithCountOnly())...)
+	if err != nil {
+		logCxt.WithError(err).Debug("Error returned from etcdv3 client")
+		return "", cerrors.ErrorDatastoreError{Err: err}
+	}
+	return strconv.FormatInt(resp.Header.Revision, 10), nil
 }
 
 func calculateListKeyAndOptions(logCxt *log.Entry, l model.ListInterface) (string, []clientv3.OpOption) {
@@ -529,6 +818,65 @@ func (c *etcdV3Client) getTTLOption(ctx context.Context, d *model.KVPair) ([]cli
 	return putOpts, nil
 }
 
+// commitDeletes commits a transaction whose success operations, thens, include deletes of
+// deleteKeys.  If any of those keys has a lease, the transaction instead removes the leases
+// (keeping the values), and is then tried again with the conditions on the keys' revisions, given
+// by their index in revConds, updated to the revision that removed the leases.  An explicit
+// delete is then the deletion of a key without a lease, which watchers can tell apart from etcd
+// deleting the key when its lease expires: see deletedByExpiry.  Returns the transaction response
+// and, if it succeeded, the responses to thens.
+//
+// Removing the leases is a separate write from the delete, so watchers see a Modified event for
+// each key that had a lease, and a client that stops between the two writes leaves those keys in
+// place without a lease, so they no longer expire.
+func (c *etcdV3Client) commitDeletes(
+	ctx context.Context,
+	conds []clientv3.Cmp,
+	revConds map[string]int,
+	deleteKeys []string,
+	thens, elses []clientv3.Op,
+) (*clientv3.TxnResponse, []*etcdserverpb.ResponseOp, error) {
+	var noLeases []clientv3.Cmp
+	var detaches []clientv3.Op
+	for _, key := range deleteKeys {
+		noLeases = append(noLeases, clientv3.Compare(clientv3.LeaseValue(key), "=", 0))
+		detaches = append(detaches, clientv3.OpTxn(
+			[]clientv3.Cmp{clientv3.Compare(clientv3.LeaseValue(key), "!=", 0)},
+			[]clientv3.Op{clientv3.OpPut(key, "", clientv3.WithIgnoreValue())},
+			nil,
+		))
+	}
+	conds = append([]clientv3.Cmp(nil), conds...)
+	for attempt := 0; attempt < maxDeleteAttempts; attempt++ {
+		txnResp, err := c.etcdClient.Txn(ctx).If(
+			conds...,
+		).Then(
+			clientv3.OpTxn(noLeases, thens, detaches),
+		).Else(
+			elses...,
+		).Commit()
+		if err != nil || !txnResp.Succeeded {
+			return txnResp, nil, err
+		}
+		resp := txnResp.Responses[0].GetResponseTxn()
+		if resp.Succeeded {
+			return txnResp, resp.Responses, nil
+		}
+		for i, key := range deleteKeys {
+			if !resp.Responses[i].GetResponseTxn().Succeeded {
+				continue
+			}
+			log.WithField("etcdv3-etcdKey", key).Debug("Removed lease before deleting key")
+			if j, ok := revConds[key]; ok {
+				conds[j] = clientv3.Compare(clientv3.ModRevision(key), "=", txnResp.Header.Revision)
+			}
+		}
+	}
+	return nil, nil, cerrors.ErrorDatastoreError{
+		Err: fmt.Errorf("keys were given a new lease on each of %d attempts to delete them", maxDeleteAttempts),
+	}
+}
+
 // getKeyValueStrings returns the etcdv3 etcdKey and serialized value calculated from the
 // KVPair.
 func getKeyValueStrings(d *model.KVPair) (string, string, error) {
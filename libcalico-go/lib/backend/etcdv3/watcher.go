@@ -18,6 +18,7 @@ import (
 	"context"
 	"strconv"
 	"sync/atomic"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	clientv3 "go.etcd.io/etcd/client/v3"
@@ -28,6 +29,10 @@ import (
 
 const (
 	resultsBufSize = 100
+
+	// defaultBookmarkInterval is how often a watch that allows bookmarks sends them, if the
+	// interval isn't specified.
+	defaultBookmarkInterval = time.Minute
 )
 
 // Watch entries in the datastore matching the resources specified by the ListInterface.
@@ -114,13 +119,21 @@ func (wc *watcher) watchLoop() {
 		wc.sendAddedEvents(kvps)
 	}
 
+	bookmarkInterval, bookmarks := wc.bookmarkInterval()
 	opts = append(opts, clientv3.WithRev(wc.initialRev+1), clientv3.WithPrevKV())
+	if bookmarks {
+		opts = append(opts, clientv3.WithProgressNotify())
+	}
 	logCxt = logCxt.WithFields(log.Fields{
 		"etcdv3-etcdKey": key,
 		"rev":            wc.initialRev,
 	})
 	logCxt.Debug("Starting etcdv3 watch")
 	wch := wc.client.etcdClient.Watch(wc.ctx, key, opts...)
+	if bookmarks {
+		go wc.requestProgressLoop(bookmarkInterval)
+	}
+	lastRev := wc.initialRev
 	for wres := range wch {
 		if wres.Err() != nil {
 			// A watch channel error is a terminating event, so exit the loop.
@@ -129,11 +142,30 @@ func (wc *watcher) watchLoop() {
 			wc.sendError(err)
 			return
 		}
+		if wres.IsProgressNotify() {
+			// All events up to the header revision have been delivered.  Progress
+			// notifications are shared by all the watches on the client's stream, so
+			// we may receive them even if we didn't ask for bookmarks.
+			if bookmarks && wres.Header.Revision >= lastRev {
+				lastRev = wres.Header.Revision
+				wc.sendEvent(&api.WatchEvent{
+					Type:     api.WatchBookmark,
+					Revision: strconv.FormatInt(lastRev, 10),
+				})
+			}
+			continue
+		}
+		if len(wres.Events) > 0 {
+			lastRev = wres.Events[len(wres.Events)-1].Kv.ModRevision
+		}
 		for _, e := range wres.Events {
 			// Convert the etcdv3 event to the equivalent Watcher event.  An error
 			// parsing the event is returned as an error, but don't exit the watcher as
 			// restarting the watcher is unlikely to fix the conversion error.
 			if ae, err := convertWatchEvent(e, wc.list); ae != nil {
+				if ae.Type == api.WatchDeleted {
+					ae.Expired = deletedByExpiry(e)
+				}
 				wc.sendEvent(ae)
 			} else if err != nil {
 				wc.sendError(err)
@@ -145,6 +177,44 @@ func (wc *watcher) watchLoop() {
 	log.Warn("etcdv3 watch channel closed")
 }
 
+// bookmarkInterval returns how often to send bookmarks, and whether they were requested.
+func (wc *watcher) bookmarkInterval() (time.Duration, bool) {
+	rlo, ok := wc.list.(model.ResourceListOptions)
+	if !ok || !rlo.AllowWatchBookmarks {
+		return 0, false
+	}
+	if rlo.WatchBookmarkInterval > 0 {
+		return rlo.WatchBookmarkInterval, true
+	}
+	return defaultBookmarkInterval, true
+}
+
+// requestProgressLoop asks etcd for a progress notification every interval, until the watcher
+// is stopped.  etcd sends progress notifications of its own accord too, but only every ten
+// minutes.
+func (wc *watcher) requestProgressLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			// The notification is sent on the watch stream for this context.
+			if err := wc.client.etcdClient.RequestProgress(wc.ctx); err != nil && wc.ctx.Err() == nil {
+				log.WithError(err).Debug("Failed to request watch progress")
+			}
+		case <-wc.ctx.Done():
+			return
+		}
+	}
+}
+
+// deletedByExpiry returns true if the deleted key was removed because its lease expired.  An
+// explicit delete removes a key's lease before deleting it (see commitDeletes), so a key that
+// still had a lease when it was deleted was deleted by etcd revoking the lease.
+func deletedByExpiry(e *clientv3.Event) bool {
+	return e.PrevKv != nil && e.PrevKv.Lease != 0
+}
+
 // listCurrent retrieves the existing entries.
 func (wc *watcher) listCurrent() (*model.KVPairList, error) {
 	log.Info("Performing initial list with no revision")
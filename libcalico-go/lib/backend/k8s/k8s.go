@@ -707,6 +707,29 @@ func (c *KubeClient) Watch(ctx context.Context, l model.ListInterface, revision
 	return client.Watch(ctx, l, revision)
 }
 
+// LatestRevision implements the api.RevisionReader interface.  Kubernetes resource versions are
+// only comparable between objects of the same type, so the revision is read by the resource
+// client if it can, or by listing the objects otherwise.
+func (c *KubeClient) LatestRevision(ctx context.Context, l model.ListInterface) (string, error) {
+	log.Debugf("Performing 'LatestRevision' for %+v %v", l, reflect.TypeOf(l))
+	client := c.getResourceClientFromList(l)
+	if client == nil {
+		log.Debug("Attempt to 'LatestRevision' using kubernetes backend is not supported.")
+		return "", cerrors.ErrorOperationNotSupported{
+			Identifier: l,
+			Operation:  "LatestRevision",
+		}
+	}
+	if reader, ok := client.(api.RevisionReader); ok {
+		return reader.LatestRevision(ctx, l)
+	}
+	kvps, err := client.List(ctx, l, "")
+	if err != nil {
+		return "", err
+	}
+	return kvps.Revision, nil
+}
+
 func (c *KubeClient) getReadyStatus(ctx context.Context, k model.ReadyFlagKey, revision string) (*model.KVPair, error) {
 	return &model.KVPair{Key: k, Value: true}, nil
 }
@@ -310,11 +310,14 @@ func (c *customK8sResourceClient) List(ctx context.Context, list model.ListInter
 		}
 	}
 
-	// If it is a namespaced resource, then we'll need the namespace.
-	namespace := list.(model.ResourceListOptions).Namespace
+	// If it is a namespaced resource, then we'll need the namespace.  The labels of a custom
+	// resource are those of the Calico resource, so the API server can filter by them.
+	rlo := list.(model.ResourceListOptions)
+	namespace := rlo.Namespace
 
 	// listFunc performs a list with the given options.
 	listFunc := func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+		opts.LabelSelector = rlo.LabelSelector
 		out := reflect.New(c.k8sListType).Interface().(ResourceList)
 		err := c.restClient.Get().
 			NamespaceIfScoped(namespace, c.namespaced).
@@ -344,11 +347,11 @@ func (c *customK8sResourceClient) List(ctx context.Context, list model.ListInter
 
 func (c *customK8sResourceClient) Watch(ctx context.Context, list model.ListInterface, revision string) (api.WatchInterface, error) {
 	// Build watch options to pass to k8s.
-	opts := metav1.ListOptions{ResourceVersion: revision, Watch: true, AllowWatchBookmarks: false}
 	rlo, ok := list.(model.ResourceListOptions)
 	if !ok {
 		return nil, fmt.Errorf("ListInterface is not a ResourceListOptions: %s", list)
 	}
+	opts := metav1.ListOptions{ResourceVersion: revision, Watch: true, AllowWatchBookmarks: rlo.AllowWatchBookmarks}
 	fieldSelector := fields.Everything()
 	if len(rlo.Name) != 0 {
 		// We've been asked to watch a specific custom resource.
@@ -50,10 +50,60 @@ func pagedList(
 	}
 	logCtx := log.WithField("pagedList", isPaged)
 	logCtx.Debug("List() call completed, convert results")
+	return toKVPairList(logCtx, list, result, toKVPs)
+}
 
+// listPage lists a single page of at most list.Limit resources, starting from list.Continue,
+// against the Kubernetes API using the given information.  The revision is only used for the
+// first page; the Kubernetes continue token includes the revision of the first page.
+func listPage(
+	ctx context.Context,
+	log *logrus.Entry,
+	revision string,
+	list model.ResourceListOptions,
+	toKVPs func(Resource) ([]*model.KVPair, error),
+	listFunc func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error),
+) (
+	*model.KVPairList,
+	error,
+) {
+	opts := metav1.ListOptions{Limit: list.Limit, Continue: list.Continue}
+	if list.Continue == "" && revision != "" {
+		opts.ResourceVersion = revision
+		opts.ResourceVersionMatch = metav1.ResourceVersionMatchNotOlderThan
+	}
+	result, err := listFunc(ctx, opts)
+	if err != nil {
+		return nil, K8sErrorToCalico(err, list)
+	}
+	kvps, err := toKVPairList(log, list, result, toKVPs)
+	if err != nil {
+		return nil, err
+	}
+	m, err := meta.ListAccessor(result)
+	if err != nil {
+		return nil, err
+	}
+	kvps.Continue = m.GetContinue()
+	if kvps.Continue != "" {
+		kvps.RemainingItemCount = m.GetRemainingItemCount()
+	}
+	return kvps, nil
+}
+
+// toKVPairList converts the resources of a Kubernetes list to KVPairs.
+func toKVPairList(
+	logCtx *logrus.Entry,
+	list model.ListInterface,
+	result runtime.Object,
+	toKVPs func(Resource) ([]*model.KVPair, error),
+) (
+	*model.KVPairList,
+	error,
+) {
 	// For each item in the response, convert it to a KVPair and add it to the list.
 	kvps := []*model.KVPair{}
-	err = meta.EachListItem(result, func(obj runtime.Object) error {
+	err := meta.EachListItem(result, func(obj runtime.Object) error {
 		res := obj.(Resource)
 		result, err := toKVPs(res)
 		if err != nil {
@@ -21,6 +21,7 @@ import (
 
 	"github.com/sirupsen/logrus"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	kwatch "k8s.io/apimachinery/pkg/watch"
 
 	"github.com/projectcalico/calico/libcalico-go/lib/backend/api"
@@ -146,6 +147,18 @@ func (crw *k8sWatcherConverter) convertEvent(kevent kwatch.Event) []*api.WatchEv
 			Type:  api.WatchError,
 			Error: apierrors.FromObject(kevent.Object),
 		}}
+	case kwatch.Bookmark:
+		// A bookmark only carries the resource version that the watch has reached.  The API
+		// server decides how often to send them.
+		obj, err := meta.Accessor(kevent.Object)
+		if err != nil {
+			crw.logCxt.WithError(err).Warning("Error getting the resource version from a Kubernetes bookmark")
+			return nil
+		}
+		return []*api.WatchEvent{{
+			Type:     api.WatchBookmark,
+			Revision: obj.GetResourceVersion(),
+		}}
 	case kwatch.Deleted:
 		fallthrough
 	case kwatch.Added:
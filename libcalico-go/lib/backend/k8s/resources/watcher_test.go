@@ -23,6 +23,7 @@ import (
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	kwatch "k8s.io/apimachinery/pkg/watch"
 )
 
@@ -49,12 +50,23 @@ var _ = Describe("Resources watcher ", func() {
 
 		It("should return error WatchEvent with unexpected kwatch event type", func() {
 			events := kwc.convertEvent(kwatch.Event{
-				Type: kwatch.Bookmark,
+				Type: kwatch.EventType("UNKNOWN"),
 			})
 			Expect(events).To(HaveLen(1))
 			Expect(events[0].Type).To(Equal(api.WatchError))
 		})
 
+		It("should return a bookmark WatchEvent with kwatch Bookmark event type", func() {
+			events := kwc.convertEvent(kwatch.Event{
+				Type:   kwatch.Bookmark,
+				Object: &apiv3.Profile{ObjectMeta: metav1.ObjectMeta{ResourceVersion: "1234"}},
+			})
+			Expect(events).To(Equal([]*api.WatchEvent{{
+				Type:     api.WatchBookmark,
+				Revision: "1234",
+			}}))
+		})
+
 		It("should return add events with kwatch Added event type", func() {
 			kwc.converter = func(r Resource) ([]*model.KVPair, error) {
 				return []*model.KVPair{
@@ -322,27 +322,86 @@ func (c *WorkloadEndpointClient) list(ctx context.Context, list model.ResourceLi
 		if !c.converter.IsValidCalicoWorkloadEndpoint(pod) {
 			return nil, nil
 		}
-		return c.converter.PodToWorkloadEndpoints(pod)
+		kvps, err := c.converter.PodToWorkloadEndpoints(pod)
+		if err != nil || len(list.Projection) == 0 {
+			return kvps, err
+		}
+		// The WorkloadEndpoints are built from the whole Pod, so we can only drop the fields
+		// that weren't asked for once they have been converted.
+		for _, kvp := range kvps {
+			if kvp.Value, err = model.ProjectResource(kvp.Value, list.Projection); err != nil {
+				return nil, err
+			}
+		}
+		return kvps, nil
+	}
+
+	// If only the endpoints on one node are wanted, only list the pods on that node.
+	podFieldSelector, err := podFieldSelectorForWorkloadEndpoints(list.FieldSelector)
+	if err != nil {
+		return nil, err
 	}
 
 	// Perform a paginated list of pods, executing the conversion function on each.
 	listFunc := func(ctx context.Context, opts metav1.ListOptions) (runtime.Object, error) {
+		opts.FieldSelector = podFieldSelector
 		return c.clientSet.CoreV1().Pods(list.Namespace).List(ctx, opts)
 	}
+	if list.Limit > 0 {
+		return listPage(ctx, logContext, revision, list, convertFunc, listFunc)
+	}
 	return pagedList(ctx, logContext, revision, list, convertFunc, listFunc)
 }
 
+// podFieldSelectorForWorkloadEndpoints returns the Pod field selector to list the Pods for the
+// WorkloadEndpoints that match the given WorkloadEndpoint field selector.  Only the node can be
+// selected by; the caller filters the WorkloadEndpoints by the other fields.
+func podFieldSelectorForWorkloadEndpoints(wepFieldSelector string) (string, error) {
+	if wepFieldSelector == "" {
+		return "", nil
+	}
+	sel, err := fields.ParseSelector(wepFieldSelector)
+	if err != nil {
+		return "", err
+	}
+	if node, ok := sel.RequiresExactMatch("spec.node"); ok {
+		return fields.OneTermEqualSelector("spec.nodeName", node).String(), nil
+	}
+	return "", nil
+}
+
+// LatestRevision implements the api.RevisionReader interface.  The revision is read from a List
+// of at most one Pod.
+func (c *WorkloadEndpointClient) LatestRevision(ctx context.Context, list model.ListInterface) (string, error) {
+	rlo, ok := list.(model.ResourceListOptions)
+	if !ok {
+		return "", fmt.Errorf("ListInterface is not a ResourceListOptions: %s", list)
+	}
+	pods, err := c.clientSet.CoreV1().Pods(rlo.Namespace).List(ctx, metav1.ListOptions{Limit: 1})
+	if err != nil {
+		return "", K8sErrorToCalico(err, list)
+	}
+	return pods.ResourceVersion, nil
+}
+
 func (c *WorkloadEndpointClient) EnsureInitialized() error {
 	return nil
 }
 
 func (c *WorkloadEndpointClient) Watch(ctx context.Context, list model.ListInterface, revision string) (api.WatchInterface, error) {
 	// Build watch options to pass to k8s.
-	opts := metav1.ListOptions{ResourceVersion: revision, Watch: true, AllowWatchBookmarks: false}
 	rlo, ok := list.(model.ResourceListOptions)
 	if !ok {
 		return nil, fmt.Errorf("ListInterface is not a ResourceListOptions: %s", list)
 	}
+	opts := metav1.ListOptions{ResourceVersion: revision, Watch: true, AllowWatchBookmarks: rlo.AllowWatchBookmarks}
+
+	// If only the endpoints on one node are wanted, only watch the pods on that node.
+	podFieldSelector, err := podFieldSelectorForWorkloadEndpoints(rlo.FieldSelector)
+	if err != nil {
+		return nil, err
+	}
+	opts.FieldSelector = podFieldSelector
 	if len(rlo.Name) != 0 {
 		if len(rlo.Namespace) == 0 {
 			return nil, errors.New("cannot watch a specific WorkloadEndpoint without a namespace")
@@ -353,7 +412,12 @@ func (c *WorkloadEndpointClient) Watch(ctx context.Context, list model.ListInter
 			return nil, err
 		}
 		log.WithField("name", wepids.Pod).Debug("Watching a single workloadendpoint")
-		opts.FieldSelector = fields.OneTermEqualSelector("metadata.name", wepids.Pod).String()
+		nameSelector := fields.OneTermEqualSelector("metadata.name", wepids.Pod)
+		if podFieldSelector == "" {
+			opts.FieldSelector = nameSelector.String()
+		} else {
+			opts.FieldSelector = fields.AndSelectors(nameSelector, fields.ParseSelectorOrDie(podFieldSelector)).String()
+		}
 	}
 
 	ns := rlo.Namespace
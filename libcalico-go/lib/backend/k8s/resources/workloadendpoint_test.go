@@ -40,7 +40,9 @@ import (
 	k8sapi "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
 )
 
 var _ = Describe("WorkloadEndpointClient", func() {
@@ -620,8 +622,82 @@ var _ = Describe("WorkloadEndpointClient", func() {
 				)
 			})
 		})
+		Context("a field selector is specified", func() {
+			listPodFieldSelector := func(wepFieldSelector string) (string, error) {
+				k8sClient := fake.NewSimpleClientset()
+				var podFieldSelector string
+				k8sClient.PrependReactor("list", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+					podFieldSelector = action.(k8stesting.ListAction).GetListRestrictions().Fields.String()
+					return false, nil, nil
+				})
+				wepClient := resources.NewWorkloadEndpointClient(k8sClient)
+				_, err := wepClient.List(ctx, model.ResourceListOptions{
+					Kind:          libapiv3.KindWorkloadEndpoint,
+					FieldSelector: wepFieldSelector,
+				}, "")
+				return podFieldSelector, err
+			}
+
+			It("lists only the pods on the selected node", func() {
+				podFieldSelector, err := listPodFieldSelector("spec.orchestrator=k8s,spec.node=test-node")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(podFieldSelector).To(Equal("spec.nodeName=test-node"))
+			})
+
+			It("lists all pods if the node is not selected", func() {
+				podFieldSelector, err := listPodFieldSelector("spec.node!=test-node")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(podFieldSelector).To(BeEmpty())
+			})
+		})
 	})
 	Describe("Watch", func() {
+		Context("a field selector is specified", func() {
+			watchPodFieldSelector := func(list model.ResourceListOptions) (string, error) {
+				k8sClient := fake.NewSimpleClientset()
+				var podFieldSelector string
+				k8sClient.PrependWatchReactor("pods", func(action k8stesting.Action) (bool, watch.Interface, error) {
+					podFieldSelector = action.(k8stesting.WatchAction).GetWatchRestrictions().Fields.String()
+					return false, nil, nil
+				})
+				wepClient := resources.NewWorkloadEndpointClient(k8sClient)
+				w, err := wepClient.Watch(ctx, list, "")
+				if w != nil {
+					w.Stop()
+				}
+				return podFieldSelector, err
+			}
+
+			It("watches only the pods on the selected node", func() {
+				podFieldSelector, err := watchPodFieldSelector(model.ResourceListOptions{
+					Kind:          libapiv3.KindWorkloadEndpoint,
+					FieldSelector: "spec.orchestrator=k8s,spec.node=test-node",
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(podFieldSelector).To(Equal("spec.nodeName=test-node"))
+			})
+
+			It("combines the node with the name of a single WorkloadEndpoint", func() {
+				podFieldSelector, err := watchPodFieldSelector(model.ResourceListOptions{
+					Kind:          libapiv3.KindWorkloadEndpoint,
+					Name:          "test--node-k8s-simplePod-eth0",
+					Namespace:     "testNamespace",
+					FieldSelector: "spec.node=test-node",
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(podFieldSelector).To(Equal("metadata.name=simplePod,spec.nodeName=test-node"))
+			})
+
+			It("watches all pods if the node is not selected", func() {
+				podFieldSelector, err := watchPodFieldSelector(model.ResourceListOptions{
+					Kind:          libapiv3.KindWorkloadEndpoint,
+					FieldSelector: "spec.node!=test-node",
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(podFieldSelector).To(BeEmpty())
+			})
+		})
+
 		Context("Pod added", func() {
 			It("returns a single event containing the Pod's WorkloadEndpoint", func() {
 				testWatchWorkloadEndpoints([]*k8sapi.Pod{
@@ -102,6 +102,12 @@ type KVPair struct {
 type KVPairList struct {
 	KVPairs  []*KVPair
 	Revision string
+
+	// Continue is set by a List with a Limit if there are more entries, to list the next page.
+	Continue string
+	// RemainingItemCount is set along with Continue, if the backend knows it, to the number of
+	// entries after the page.
+	RemainingItemCount *int64
 }
 
 // KeyToDefaultPath converts one of the Keys from this package into a unique
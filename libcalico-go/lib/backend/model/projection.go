@@ -0,0 +1,113 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/projectcalico/calico/libcalico-go/lib/errors"
+)
+
+// projectedField is a field of a resource's metadata or spec named in a projection; see
+// ResourceListOptions.Projection.
+type projectedField struct {
+	// part is the resource's struct field that has the field, ObjectMeta or Spec.
+	part string
+	// jsonName is the name of the field in the serialized resource.
+	jsonName string
+}
+
+// projectedFieldOf looks up the named metadata or spec field of the given resource type.
+func projectedFieldOf(t reflect.Type, name string) (projectedField, error) {
+	for _, part := range []string{"ObjectMeta", "Spec"} {
+		p, ok := t.FieldByName(part)
+		if !ok {
+			continue
+		}
+		if f, ok := p.Type.FieldByName(name); ok {
+			return projectedField{part: part, jsonName: strings.Split(f.Tag.Get("json"), ",")[0]}, nil
+		}
+	}
+	return projectedField{}, errors.ErrorValidation{
+		ErroredFields: []errors.ErroredField{{
+			Name:   "Projection",
+			Value:  name,
+			Reason: fmt.Sprintf("unknown %s field", t.Name()),
+		}},
+	}
+}
+
+// ProjectResource returns a copy of the given resource, which must be a pointer to a resource
+// struct, with only its TypeMeta and the named metadata and spec fields populated.
+func ProjectResource(r interface{}, fields []string) (interface{}, error) {
+	in := reflect.ValueOf(r).Elem()
+	out := reflect.New(in.Type()).Elem()
+	out.FieldByName("TypeMeta").Set(in.FieldByName("TypeMeta"))
+	for _, name := range fields {
+		f, err := projectedFieldOf(in.Type(), name)
+		if err != nil {
+			return nil, err
+		}
+		out.FieldByName(f.part).FieldByName(name).Set(in.FieldByName(f.part).FieldByName(name))
+	}
+	return out.Addr().Interface(), nil
+}
+
+// ParseProjectedValue is like ParseValue for the value of a resource but only decodes the named
+// metadata and spec fields, leaving the rest of the resource empty.  The other fields are
+// skipped over without being decoded.
+func ParseProjectedValue(key Key, rawData []byte, fields []string) (interface{}, error) {
+	valueType, err := key.valueType()
+	if err != nil {
+		return nil, err
+	}
+	type serializedResource struct {
+		Kind       string                     `json:"kind,omitempty"`
+		APIVersion string                     `json:"apiVersion,omitempty"`
+		Metadata   map[string]json.RawMessage `json:"metadata,omitempty"`
+		Spec       map[string]json.RawMessage `json:"spec,omitempty"`
+	}
+	var in serializedResource
+	if err := json.Unmarshal(rawData, &in); err != nil {
+		return nil, err
+	}
+	out := serializedResource{
+		Kind:       in.Kind,
+		APIVersion: in.APIVersion,
+		Metadata:   map[string]json.RawMessage{},
+		Spec:       map[string]json.RawMessage{},
+	}
+	for _, name := range fields {
+		f, err := projectedFieldOf(valueType, name)
+		if err != nil {
+			return nil, err
+		}
+		inPart, outPart := in.Metadata, out.Metadata
+		if f.part == "Spec" {
+			inPart, outPart = in.Spec, out.Spec
+		}
+		if v, ok := inPart[f.jsonName]; ok {
+			outPart[f.jsonName] = v
+		}
+	}
+	projected, err := json.Marshal(out)
+	if err != nil {
+		return nil, err
+	}
+	return ParseValue(key, projected)
+}
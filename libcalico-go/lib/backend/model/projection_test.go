@@ -0,0 +1,74 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package model_test
+
+import (
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	libapiv3 "github.com/projectcalico/calico/libcalico-go/lib/apis/v3"
+	. "github.com/projectcalico/calico/libcalico-go/lib/backend/model"
+	"github.com/projectcalico/calico/libcalico-go/lib/errors"
+)
+
+var _ = Describe("Projection", func() {
+	key := ResourceKey{Kind: libapiv3.KindWorkloadEndpoint, Namespace: "ns1", Name: "wep1"}
+	wep := &libapiv3.WorkloadEndpoint{
+		TypeMeta: metav1.TypeMeta{Kind: libapiv3.KindWorkloadEndpoint, APIVersion: "projectcalico.org/v3"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "wep1",
+			Namespace: "ns1",
+			Labels:    map[string]string{"app": "web"},
+		},
+		Spec: libapiv3.WorkloadEndpointSpec{
+			Node:          "node1",
+			InterfaceName: "cali1234",
+			IPNetworks:    []string{"10.0.0.1/32"},
+		},
+	}
+	projected := &libapiv3.WorkloadEndpoint{
+		TypeMeta:   wep.TypeMeta,
+		ObjectMeta: metav1.ObjectMeta{Name: "wep1"},
+		Spec: libapiv3.WorkloadEndpointSpec{
+			Node:       "node1",
+			IPNetworks: []string{"10.0.0.1/32"},
+		},
+	}
+	fields := []string{"Name", "Node", "IPNetworks"}
+
+	It("should mask a resource to the given fields", func() {
+		out, err := ProjectResource(wep, fields)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(out).To(Equal(projected))
+	})
+
+	It("should only decode the given fields of a value", func() {
+		raw, err := json.Marshal(wep)
+		Expect(err).NotTo(HaveOccurred())
+		out, err := ParseProjectedValue(key, raw, fields)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(out).To(Equal(projected))
+	})
+
+	It("should reject unknown fields", func() {
+		_, err := ProjectResource(wep, []string{"Name", "Bogus"})
+		Expect(err).To(BeAssignableToTypeOf(errors.ErrorValidation{}))
+		_, err = ParseProjectedValue(key, []byte("{}"), []string{"Bogus"})
+		Expect(err).To(BeAssignableToTypeOf(errors.ErrorValidation{}))
+	})
+})
@@ -19,6 +19,7 @@ import (
 	"reflect"
 	"regexp"
 	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
@@ -207,6 +208,11 @@ func (key ResourceKey) valueType() (reflect.Type, error) {
 	return ri.typeOf, nil
 }
 
+// ResourceIdentity implements errors.ResourceIdentifier.
+func (key ResourceKey) ResourceIdentity() (kind, namespace, name string) {
+	return key.Kind, key.Namespace, key.Name
+}
+
 func (key ResourceKey) String() string {
 	if namespace.IsNamespaced(key.Kind) {
 		return fmt.Sprintf("%s(%s/%s)", key.Kind, key.Namespace, key.Name)
@@ -223,6 +229,33 @@ type ResourceListOptions struct {
 	Kind string
 	// Whether the name is prefix rather than the full name.
 	Prefix bool
+	// An optional Kubernetes label selector.  Backends that can filter by label do so; others
+	// ignore it, so callers must still check the labels of the returned resources.
+	LabelSelector string
+	// An optional Kubernetes field selector, with the fields named as in the Calico resource
+	// (e.g. "spec.node").  Backends that can filter by some of the fields do so; others ignore
+	// it, so callers must still check the fields of the returned resources.
+	FieldSelector string
+	// Whether a Watch should send WatchBookmark events.  Backends that don't support bookmarks
+	// ignore it.
+	AllowWatchBookmarks bool
+	// How often a Watch sends WatchBookmark events, if the backend decides.  If zero, the
+	// backend's default is used.
+	WatchBookmarkInterval time.Duration
+	// If non-zero, the maximum number of entries that a List returns.  If there are more, the
+	// Continue field of the KVPairList is set.  In the Kubernetes datastore, the limit applies
+	// to the underlying resources, so a Pod with more than one WorkloadEndpoint can take a page
+	// over the limit.  Only supported for WorkloadEndpoints; ignored by Watch.
+	Limit int64
+	// The Continue field of the KVPairList from the previous page of a List with a Limit, to
+	// list the next page.
+	Continue string
+	// If non-empty, the metadata and spec fields to populate in each listed resource, named as
+	// in the Go structs (e.g. "Name", "Labels", "Node"); the other fields are left empty.  The
+	// etcdv3 datastore only decodes the named fields.  The Kubernetes datastore builds each
+	// WorkloadEndpoint from the whole Pod and then drops the other fields.  Only supported for
+	// WorkloadEndpoints; ignored by Watch.
+	Projection []string
 }
 
 // If the Kind, Namespace and Name are specified, but the Name is a prefix then the
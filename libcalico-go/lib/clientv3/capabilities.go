@@ -0,0 +1,47 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clientv3
+
+import (
+	"github.com/projectcalico/calico/libcalico-go/lib/apiconfig"
+	bapi "github.com/projectcalico/calico/libcalico-go/lib/backend/api"
+)
+
+// Capabilities reports which optional operations are supported by the datastore that a client
+// is connected to.
+type Capabilities struct {
+	// WorkloadEndpointCreate is true if WorkloadEndpoints can be created through the client.
+	// In Kubernetes datastore mode, WorkloadEndpoints are derived from pods, so they can't be
+	// created.
+	WorkloadEndpointCreate bool
+
+	// Transactions is true if the datastore can apply several writes atomically; see Txn.
+	Transactions bool
+}
+
+// BackendType returns the type of datastore that the client is connected to.
+func (c client) BackendType() apiconfig.DatastoreType {
+	return c.config.Spec.DatastoreType
+}
+
+// Capabilities returns the optional operations that are supported by the datastore that the
+// client is connected to.
+func (c client) Capabilities() Capabilities {
+	_, isTransactor := c.backend.(bapi.Transactor)
+	return Capabilities{
+		WorkloadEndpointCreate: c.BackendType() != apiconfig.Kubernetes,
+		Transactions:           isTransactor,
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clientv3_test
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	libapiv3 "github.com/projectcalico/calico/libcalico-go/lib/apis/v3"
+
+	"github.com/projectcalico/calico/libcalico-go/lib/apiconfig"
+	"github.com/projectcalico/calico/libcalico-go/lib/backend"
+	"github.com/projectcalico/calico/libcalico-go/lib/clientv3"
+	"github.com/projectcalico/calico/libcalico-go/lib/options"
+	"github.com/projectcalico/calico/libcalico-go/lib/testutils"
+)
+
+var _ = testutils.E2eDatastoreDescribe("Client capabilities tests", testutils.DatastoreAll, func(config apiconfig.CalicoAPIConfig) {
+
+	ctx := context.Background()
+
+	var c clientv3.Interface
+
+	BeforeEach(func() {
+		var err error
+		c, err = clientv3.New(config)
+		Expect(err).NotTo(HaveOccurred())
+
+		be, err := backend.NewClient(config)
+		Expect(err).NotTo(HaveOccurred())
+		be.Clean()
+	})
+
+	It("should report the configured datastore type", func() {
+		Expect(c.BackendType()).To(Equal(config.Spec.DatastoreType))
+	})
+
+	It("should report whether WorkloadEndpoints can be created", func() {
+		Expect(c.Capabilities().WorkloadEndpointCreate).To(Equal(config.Spec.DatastoreType == apiconfig.EtcdV3))
+	})
+
+	It("should report a WorkloadEndpoint Create capability that matches the behaviour of Create", func() {
+		_, err := c.WorkloadEndpoints().Create(ctx, &libapiv3.WorkloadEndpoint{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "namespace-1", Name: "node--1-k8s-abcdef-eth0"},
+			Spec: libapiv3.WorkloadEndpointSpec{
+				Node:          "node-1",
+				Orchestrator:  "k8s",
+				Pod:           "abcdef",
+				ContainerID:   "a12345a",
+				Endpoint:      "eth0",
+				InterfaceName: "cali09123",
+			},
+		}, options.SetOptions{})
+		if c.Capabilities().WorkloadEndpointCreate {
+			Expect(err).NotTo(HaveOccurred())
+		} else {
+			Expect(err).To(HaveOccurred())
+		}
+	})
+})
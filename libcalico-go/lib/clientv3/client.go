@@ -55,20 +55,79 @@ type client struct {
 
 	// The resources client used internally.
 	resources resourceInterface
+
+	// Whether WorkloadEndpoint names that predate the current name encoding are tolerated
+	// on existing resources.
+	legacyNames bool
+
+	// Optional read replica configuration, or backend client, that reads are directed at.
+	readReplicaConfig *apiconfig.CalicoAPIConfig
+	readBackend       bapi.Client
+
+	// Hooks called for each WorkloadEndpoint mutation.
+	wepHooks []WorkloadEndpointHooks
+
+	// Optional rate limiters for WorkloadEndpoint reads and writes.
+	wepReadLimiter  *rateLimiter
+	wepWriteLimiter *rateLimiter
+}
+
+// Option is an optional configuration for a client created by New.
+type Option func(*client)
+
+// WithLegacyNames relaxes the WorkloadEndpoint name-structure validation for existing
+// resources (i.e. on Update) so that endpoints whose names predate the current
+// <node>-<orchestrator>-<workload>-<endpoint> encoding can still be modified during an
+// upgrade.  Newly created WorkloadEndpoints must still use the current name format, unless
+// options.SetOptions.AllowLegacyName is set on the Create.
+func WithLegacyNames() Option {
+	return func(c *client) {
+		c.legacyNames = true
+	}
+}
+
+// WithReadReplica directs resource reads (Get, List and Watch) at a datastore configured by the
+// given config, for example a read replica, while writes continue to go to the primary
+// datastore.  Replicas may lag the primary, so a read may not reflect a write that was just
+// made through this client, and resource versions returned by a read may be older than those
+// returned by the preceding write.  Use the Consistent field of GetOptions or ListOptions to
+// force a read to be served by the primary.
+func WithReadReplica(config apiconfig.CalicoAPIConfig) Option {
+	return func(c *client) {
+		c.readReplicaConfig = &config
+	}
+}
+
+// WithReadBackend is as WithReadReplica but it takes an already-constructed backend client.
+func WithReadBackend(be bapi.Client) Option {
+	return func(c *client) {
+		c.readBackend = be
+	}
 }
 
 // New returns a connected client. The ClientConfig can either be created explicitly,
 // or can be loaded from a config file or environment variables using the LoadClientConfig() function.
-func New(config apiconfig.CalicoAPIConfig) (Interface, error) {
+func New(config apiconfig.CalicoAPIConfig, opts ...Option) (Interface, error) {
 	be, err := backend.NewClient(config)
 	if err != nil {
 		return nil, err
 	}
-	return client{
-		config:    config,
-		backend:   be,
-		resources: &resources{backend: be},
-	}, nil
+	c := client{
+		config:  config,
+		backend: be,
+	}
+	for _, o := range opts {
+		o(&c)
+	}
+	if c.readBackend == nil && c.readReplicaConfig != nil {
+		log.Info("Directing reads at read replica")
+		c.readBackend, err = backend.NewClient(*c.readReplicaConfig)
+		if err != nil {
+			return nil, err
+		}
+	}
+	c.resources = &resources{backend: be, readBackend: c.readBackend}
+	return c, nil
 }
 
 // NewFromEnv loads the config from ENV variables and returns a connected client.
@@ -0,0 +1,87 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clientv3
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/fields"
+
+	libapiv3 "github.com/projectcalico/calico/libcalico-go/lib/apis/v3"
+	"github.com/projectcalico/calico/libcalico-go/lib/errors"
+)
+
+// selectableFields are the fields that a FieldSelector may select each kind of resource by, and
+// how to get each field's value from a resource of that kind.
+var selectableFields = map[string]map[string]func(resource) string{
+	libapiv3.KindWorkloadEndpoint: {
+		"metadata.name":      func(r resource) string { return r.GetObjectMeta().GetName() },
+		"metadata.namespace": func(r resource) string { return r.GetObjectMeta().GetNamespace() },
+		"spec.node":          func(r resource) string { return r.(*libapiv3.WorkloadEndpoint).Spec.Node },
+		"spec.orchestrator":  func(r resource) string { return r.(*libapiv3.WorkloadEndpoint).Spec.Orchestrator },
+	},
+}
+
+// fieldSelector is a parsed FieldSelector list option.
+type fieldSelector struct {
+	fields.Selector
+	getters map[string]func(resource) string
+}
+
+// Evaluate returns true if the fields of the given resource match the selector.
+func (s fieldSelector) Evaluate(res resource) bool {
+	set := fields.Set{}
+	for field, get := range s.getters {
+		set[field] = get(res)
+	}
+	return s.Matches(set)
+}
+
+// parseFieldSelector parses the FieldSelector list option for a List of the given kind.  The
+// selector uses the Kubernetes field selector syntax (e.g. "spec.node=node-1,spec.orchestrator!=k8s"),
+// and may only use the selectable fields of the kind.
+func parseFieldSelector(kind, s string) (*fieldSelector, error) {
+	invalid := func(reason string) error {
+		return errors.ErrorValidation{
+			ErroredFields: []errors.ErroredField{{
+				Name:   "FieldSelector",
+				Value:  s,
+				Reason: reason,
+			}},
+		}
+	}
+	getters, ok := selectableFields[kind]
+	if !ok {
+		return nil, invalid(fmt.Sprintf("field selectors are not supported for %s", kind))
+	}
+	sel, err := fields.ParseSelector(s)
+	if err != nil {
+		return nil, invalid(fmt.Sprintf("invalid selector: %v", err))
+	}
+	for _, req := range sel.Requirements() {
+		if _, ok := getters[req.Field]; !ok {
+			var supported []string
+			for field := range getters {
+				supported = append(supported, field)
+			}
+			sort.Strings(supported)
+			return nil, invalid(fmt.Sprintf("field %q is not selectable for %s, the selectable fields are: %s",
+				req.Field, kind, strings.Join(supported, ", ")))
+		}
+	}
+	return &fieldSelector{Selector: sel, getters: getters}, nil
+}
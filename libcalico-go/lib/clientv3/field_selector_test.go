@@ -0,0 +1,121 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clientv3
+
+import (
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	apiv3 "github.com/projectcalico/api/pkg/apis/projectcalico/v3"
+
+	libapiv3 "github.com/projectcalico/calico/libcalico-go/lib/apis/v3"
+	bapi "github.com/projectcalico/calico/libcalico-go/lib/backend/api"
+	"github.com/projectcalico/calico/libcalico-go/lib/backend/model"
+	"github.com/projectcalico/calico/libcalico-go/lib/errors"
+)
+
+var _ = DescribeTable("FieldSelector parsing",
+	func(s string, matches, nonMatches []libapiv3.WorkloadEndpoint) {
+		sel, err := parseFieldSelector(libapiv3.KindWorkloadEndpoint, s)
+		Expect(err).NotTo(HaveOccurred())
+		for i := range matches {
+			Expect(sel.Evaluate(&matches[i])).To(BeTrue(), "Expected %v to match", matches[i])
+		}
+		for i := range nonMatches {
+			Expect(sel.Evaluate(&nonMatches[i])).To(BeFalse(), "Expected %v not to match", nonMatches[i])
+		}
+	},
+	Entry("node", "spec.node=node-1",
+		[]libapiv3.WorkloadEndpoint{{Spec: libapiv3.WorkloadEndpointSpec{Node: "node-1"}}},
+		[]libapiv3.WorkloadEndpoint{{Spec: libapiv3.WorkloadEndpointSpec{Node: "node-2"}}, {}}),
+	Entry("node and orchestrator", "spec.node==node-1,spec.orchestrator=k8s",
+		[]libapiv3.WorkloadEndpoint{{Spec: libapiv3.WorkloadEndpointSpec{Node: "node-1", Orchestrator: "k8s"}}},
+		[]libapiv3.WorkloadEndpoint{
+			{Spec: libapiv3.WorkloadEndpointSpec{Node: "node-1", Orchestrator: "cni"}},
+			{Spec: libapiv3.WorkloadEndpointSpec{Node: "node-2", Orchestrator: "k8s"}},
+		}),
+	Entry("orchestrator inequality", "spec.orchestrator!=k8s",
+		[]libapiv3.WorkloadEndpoint{{Spec: libapiv3.WorkloadEndpointSpec{Orchestrator: "cni"}}, {}},
+		[]libapiv3.WorkloadEndpoint{{Spec: libapiv3.WorkloadEndpointSpec{Orchestrator: "k8s"}}}),
+	Entry("metadata", "metadata.namespace=ns1,metadata.name=wep1",
+		[]libapiv3.WorkloadEndpoint{{ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "wep1"}}},
+		[]libapiv3.WorkloadEndpoint{
+			{ObjectMeta: metav1.ObjectMeta{Namespace: "ns2", Name: "wep1"}},
+			{ObjectMeta: metav1.ObjectMeta{Namespace: "ns1", Name: "wep2"}},
+		}),
+)
+
+var _ = DescribeTable("FieldSelector parsing errors",
+	func(kind, s, reason string) {
+		_, err := parseFieldSelector(kind, s)
+		Expect(err).To(BeAssignableToTypeOf(errors.ErrorValidation{}))
+		fields := err.(errors.ErrorValidation).ErroredFields
+		Expect(fields).To(HaveLen(1))
+		Expect(fields[0].Name).To(Equal("FieldSelector"))
+		Expect(fields[0].Reason).To(ContainSubstring(reason))
+	},
+	Entry("unsupported field", libapiv3.KindWorkloadEndpoint, "spec.node=node-1,spec.pod=pod1",
+		`field "spec.pod" is not selectable for WorkloadEndpoint, the selectable fields are: `+
+			"metadata.name, metadata.namespace, spec.node, spec.orchestrator"),
+	Entry("unsupported kind", apiv3.KindHostEndpoint, "spec.node=node-1",
+		"field selectors are not supported for HostEndpoint"),
+	Entry("invalid syntax", libapiv3.KindWorkloadEndpoint, "spec.node",
+		"invalid selector"),
+)
+
+var _ = DescribeTable("FieldSelector watch event filtering",
+	func(event bapi.WatchEvent, expectedType bapi.WatchEventType, expectSent bool) {
+		sel, err := parseFieldSelector(libapiv3.KindWorkloadEndpoint, "spec.node=node-1")
+		Expect(err).NotTo(HaveOccurred())
+		w := &watcher{fieldSelector: sel}
+		filtered, sent := w.filterEvent(event)
+		Expect(sent).To(Equal(expectSent))
+		if sent {
+			Expect(filtered.Type).To(Equal(expectedType))
+			if expectedType == bapi.WatchAdded {
+				Expect(filtered.Old).To(BeNil())
+			}
+			if expectedType == bapi.WatchDeleted {
+				Expect(filtered.New).To(BeNil())
+			}
+		}
+	},
+	Entry("added on the node", bapi.WatchEvent{Type: bapi.WatchAdded, New: wepOnNode("node-1")}, bapi.WatchAdded, true),
+	Entry("added on another node", bapi.WatchEvent{Type: bapi.WatchAdded, New: wepOnNode("node-2")}, bapi.WatchAdded, false),
+	Entry("deleted from the node", bapi.WatchEvent{Type: bapi.WatchDeleted, Old: wepOnNode("node-1")}, bapi.WatchDeleted, true),
+	Entry("deleted from another node", bapi.WatchEvent{Type: bapi.WatchDeleted, Old: wepOnNode("node-2")}, bapi.WatchDeleted, false),
+	Entry("deleted without the old value", bapi.WatchEvent{Type: bapi.WatchDeleted}, bapi.WatchDeleted, true),
+	Entry("modified on the node",
+		bapi.WatchEvent{Type: bapi.WatchModified, Old: wepOnNode("node-1"), New: wepOnNode("node-1")}, bapi.WatchModified, true),
+	Entry("modified on another node",
+		bapi.WatchEvent{Type: bapi.WatchModified, Old: wepOnNode("node-2"), New: wepOnNode("node-2")}, bapi.WatchModified, false),
+	Entry("moved onto the node",
+		bapi.WatchEvent{Type: bapi.WatchModified, Old: wepOnNode("node-2"), New: wepOnNode("node-1")}, bapi.WatchAdded, true),
+	Entry("moved off the node",
+		bapi.WatchEvent{Type: bapi.WatchModified, Old: wepOnNode("node-1"), New: wepOnNode("node-2")}, bapi.WatchDeleted, true),
+	Entry("modified without the old value",
+		bapi.WatchEvent{Type: bapi.WatchModified, New: wepOnNode("node-1")}, bapi.WatchModified, true),
+	Entry("error", bapi.WatchEvent{Type: bapi.WatchError, Error: errors.ErrorDatastoreError{}}, bapi.WatchError, true),
+	Entry("bookmark", bapi.WatchEvent{Type: bapi.WatchBookmark, Revision: "10"}, bapi.WatchBookmark, true),
+)
+
+// wepOnNode returns a KVPair for a WorkloadEndpoint on the node.
+func wepOnNode(node string) *model.KVPair {
+	return &model.KVPair{
+		Key:   model.ResourceKey{Kind: libapiv3.KindWorkloadEndpoint, Namespace: "ns", Name: "wep"},
+		Value: &libapiv3.WorkloadEndpoint{Spec: libapiv3.WorkloadEndpointSpec{Node: node}},
+	}
+}
@@ -17,6 +17,7 @@ package clientv3
 import (
 	"context"
 
+	"github.com/projectcalico/calico/libcalico-go/lib/apiconfig"
 	"github.com/projectcalico/calico/libcalico-go/lib/ipam"
 )
 
@@ -49,6 +50,18 @@ type Interface interface {
 	// method and so a general consumer of this API can assume that the datastore
 	// is already initialized.
 	EnsureInitialized(ctx context.Context, calicoVersion, clusterType string) error
+
+	// BackendType returns the type of datastore that the client is connected to.
+	BackendType() apiconfig.DatastoreType
+
+	// Capabilities returns the optional operations that are supported by the datastore that
+	// the client is connected to, so that a consumer can check whether an operation is
+	// supported rather than failing at runtime.
+	Capabilities() Capabilities
+
+	// Txn returns a new transaction, which applies several creates, updates and deletes
+	// atomically.
+	Txn() *Txn
 }
 
 type NodesClient interface {
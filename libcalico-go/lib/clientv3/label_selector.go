@@ -0,0 +1,62 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clientv3
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/projectcalico/calico/libcalico-go/lib/errors"
+	"github.com/projectcalico/calico/libcalico-go/lib/selector"
+)
+
+// labelSelector is a parsed LabelSelector list option.
+type labelSelector interface {
+	// Evaluate returns true if the given labels match the selector.
+	Evaluate(labels map[string]string) bool
+}
+
+// k8sLabelSelector is a LabelSelector in the Kubernetes label selector syntax.  The Kubernetes
+// datastore can apply these when listing, so we pass them on to the backend.
+type k8sLabelSelector struct {
+	labels.Selector
+}
+
+func (s k8sLabelSelector) Evaluate(l map[string]string) bool {
+	return s.Matches(labels.Set(l))
+}
+
+// parseLabelSelector parses the LabelSelector list option.  The selector uses the Kubernetes label
+// selector syntax (e.g. "app=web,tier in (a,b),!evicted").  For compatibility, a Calico selector
+// expression (e.g. "app == 'web' && has(tier)") is also accepted; the two syntaxes don't overlap,
+// since Calico selectors require quoted values.
+func parseLabelSelector(s string) (labelSelector, error) {
+	k8sSel, k8sErr := labels.Parse(s)
+	if k8sErr == nil {
+		return k8sLabelSelector{k8sSel}, nil
+	}
+	sel, err := selector.Parse(s)
+	if err != nil {
+		return nil, errors.ErrorValidation{
+			ErroredFields: []errors.ErroredField{{
+				Name:   "LabelSelector",
+				Value:  s,
+				Reason: fmt.Sprintf("invalid selector: %v", k8sErr),
+			}},
+		}
+	}
+	return sel, nil
+}
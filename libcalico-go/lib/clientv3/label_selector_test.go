@@ -0,0 +1,65 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clientv3
+
+import (
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/projectcalico/calico/libcalico-go/lib/errors"
+)
+
+var _ = DescribeTable("LabelSelector parsing",
+	func(s string, expectK8s bool, matches, nonMatches []map[string]string) {
+		sel, err := parseLabelSelector(s)
+		Expect(err).NotTo(HaveOccurred())
+		_, isK8s := sel.(k8sLabelSelector)
+		Expect(isK8s).To(Equal(expectK8s))
+		for _, l := range matches {
+			Expect(sel.Evaluate(l)).To(BeTrue(), "Expected %v to match", l)
+		}
+		for _, l := range nonMatches {
+			Expect(sel.Evaluate(l)).To(BeFalse(), "Expected %v not to match", l)
+		}
+	},
+	Entry("k8s equality", "app=web", true,
+		[]map[string]string{{"app": "web"}, {"app": "web", "tier": "a"}},
+		[]map[string]string{{"app": "db"}, nil}),
+	Entry("k8s inequality", "app!=web", true,
+		[]map[string]string{{"app": "db"}, nil},
+		[]map[string]string{{"app": "web"}}),
+	Entry("k8s set-based", "tier in (a,b),!evicted", true,
+		[]map[string]string{{"tier": "a"}, {"tier": "b", "app": "web"}},
+		[]map[string]string{{"tier": "c"}, {"tier": "a", "evicted": "true"}, nil}),
+	Entry("k8s exists", "evicted", true,
+		[]map[string]string{{"evicted": "true"}, {"evicted": ""}},
+		[]map[string]string{{"app": "web"}, nil}),
+	Entry("Calico", "app == 'web' && has(tier)", false,
+		[]map[string]string{{"app": "web", "tier": "a"}},
+		[]map[string]string{{"app": "web"}, {"tier": "a"}}),
+	Entry("Calico all()", "all()", false,
+		[]map[string]string{{"app": "web"}, nil},
+		nil),
+)
+
+var _ = DescribeTable("LabelSelector parsing errors",
+	func(s string) {
+		_, err := parseLabelSelector(s)
+		Expect(err).To(BeAssignableToTypeOf(errors.ErrorValidation{}))
+	},
+	Entry("unterminated set", "tier in (a,b"),
+	Entry("unquoted Calico value", "app == web && has(tier)"),
+	Entry("invalid k8s value", "app=-web"),
+)
@@ -16,6 +16,7 @@ package clientv3
 
 import (
 	"context"
+	"errors"
 	"sync/atomic"
 
 	log "github.com/sirupsen/logrus"
@@ -27,6 +28,7 @@ import (
 
 	apiv3 "github.com/projectcalico/api/pkg/apis/projectcalico/v3"
 
+	libapiv3 "github.com/projectcalico/calico/libcalico-go/lib/apis/v3"
 	bapi "github.com/projectcalico/calico/libcalico-go/lib/backend/api"
 	"github.com/projectcalico/calico/libcalico-go/lib/backend/model"
 	cerrors "github.com/projectcalico/calico/libcalico-go/lib/errors"
@@ -56,27 +58,68 @@ type resourceList interface {
 // resourceInterface has methods to work with generic resource types.
 type resourceInterface interface {
 	Create(ctx context.Context, opts options.SetOptions, kind string, in resource) (resource, error)
+	PrepareCreate(kind string, in resource) error
 	Update(ctx context.Context, opts options.SetOptions, kind string, in resource) (resource, error)
+	ValidateUpdate(kind string, in resource) error
 	Delete(ctx context.Context, opts options.DeleteOptions, kind, ns, name string) (resource, error)
+	DeleteBatch(ctx context.Context, kind string, in []resource) ([]resource, error)
+	Txn(ctx context.Context, ops []txnOp) ([]resource, error)
 	Get(ctx context.Context, opts options.GetOptions, kind, ns, name string) (resource, error)
 	List(ctx context.Context, opts options.ListOptions, kind, listkind string, inout resourceList) error
 	Watch(ctx context.Context, opts options.ListOptions, kind string, converter watcherConverter) (watch.Interface, error)
+
+	// list and watch are List and Watch without the validation of the options, for callers
+	// that validate the options that they were given and then derive the options to use.
+	list(ctx context.Context, opts options.ListOptions, kind, listkind string, inout resourceList) error
+	watch(ctx context.Context, opts options.ListOptions, kind string, converter watcherConverter) (watch.Interface, error)
 }
 
 // resources implements resourceInterface.
 type resources struct {
 	backend bapi.Client
+
+	// Optional backend client that Get, List and Watch are directed at, unless the
+	// Consistent option is set.  If nil, all operations use backend.
+	readBackend bapi.Client
+}
+
+// readBackendFor returns the backend client that should be used for a read, taking into
+// account whether the read needs to be consistent with prior writes.
+func (c *resources) readBackendFor(consistent bool) bapi.Client {
+	if c.readBackend == nil || consistent {
+		return c.backend
+	}
+	return c.readBackend
 }
 
 // Create creates a resource in the backend datastore.
 func (c *resources) Create(ctx context.Context, opts options.SetOptions, kind string, in resource) (resource, error) {
+	if err := checkNotDryRun(opts, "Create", kind, in); err != nil {
+		return nil, err
+	}
+	if err := c.PrepareCreate(kind, in); err != nil {
+		return nil, err
+	}
+
+	// Convert the resource to a KVPair and pass that to the backend datastore, converting
+	// the response (if we get one) back to a resource.
+	kvp, err := c.backend.Create(ctx, c.resourceToKVPair(opts, kind, in))
+	if kvp != nil {
+		return c.kvPairToResource(kvp), err
+	}
+	return nil, err
+}
+
+// PrepareCreate validates a resource that is about to be created, and fills in the fields that
+// are assigned on creation.
+func (c *resources) PrepareCreate(kind string, in resource) error {
 	// Resource must have a Name.  Currently we do not support GenerateName.
 	if len(in.GetObjectMeta().GetName()) == 0 {
 		var generateNameMessage string
 		if len(in.GetObjectMeta().GetGenerateName()) != 0 {
 			generateNameMessage = " (GenerateName is not supported)"
 		}
-		return nil, cerrors.ErrorValidation{
+		return cerrors.ErrorValidation{
 			ErroredFields: []cerrors.ErroredField{{
 				Name:   "Metadata.Name",
 				Reason: "field must be set for a Create request" + generateNameMessage,
@@ -88,7 +131,7 @@ func (c *resources) Create(ctx context.Context, opts options.SetOptions, kind st
 	// A ResourceVersion should never be specified on a Create.
 	if len(in.GetObjectMeta().GetResourceVersion()) != 0 {
 		logWithResource(in).Info("Rejecting Create request with non-empty resource version")
-		return nil, cerrors.ErrorValidation{
+		return cerrors.ErrorValidation{
 			ErroredFields: []cerrors.ErroredField{{
 				Name:   "Metadata.ResourceVersion",
 				Reason: "field must not be set for a Create request",
@@ -97,7 +140,7 @@ func (c *resources) Create(ctx context.Context, opts options.SetOptions, kind st
 		}
 	}
 	if err := c.checkNamespace(in.GetObjectMeta().GetNamespace(), kind); err != nil {
-		return nil, err
+		return err
 	}
 
 	// Add in the UID and creation timestamp for the resource if needed.
@@ -108,50 +151,16 @@ func (c *resources) Create(ctx context.Context, opts options.SetOptions, kind st
 	if in.GetObjectMeta().GetUID() == "" {
 		in.GetObjectMeta().SetUID(uuid.NewUUID())
 	}
-
-	// Convert the resource to a KVPair and pass that to the backend datastore, converting
-	// the response (if we get one) back to a resource.
-	kvp, err := c.backend.Create(ctx, c.resourceToKVPair(opts, kind, in))
-	if kvp != nil {
-		return c.kvPairToResource(kvp), err
-	}
-	return nil, err
+	return nil
 }
 
 // Update updates a resource in the backend datastore.
 func (c *resources) Update(ctx context.Context, opts options.SetOptions, kind string, in resource) (resource, error) {
-	// A ResourceVersion should always be specified on an Update.
-	if len(in.GetObjectMeta().GetResourceVersion()) == 0 {
-		logWithResource(in).Info("Rejecting Update request with empty resource version")
-		return nil, cerrors.ErrorValidation{
-			ErroredFields: []cerrors.ErroredField{{
-				Name:   "Metadata.ResourceVersion",
-				Reason: "field must be set for an Update request",
-				Value:  in.GetObjectMeta().GetResourceVersion(),
-			}},
-		}
-	}
-	if err := c.checkNamespace(in.GetObjectMeta().GetNamespace(), kind); err != nil {
+	if err := checkNotDryRun(opts, "Update", kind, in); err != nil {
 		return nil, err
 	}
-	creationTimestamp := in.GetObjectMeta().GetCreationTimestamp()
-	if creationTimestamp.IsZero() {
-		return nil, cerrors.ErrorValidation{
-			ErroredFields: []cerrors.ErroredField{{
-				Name:   "Metadata.CreationTimestamp",
-				Reason: "field must be set for an Update request",
-				Value:  in.GetObjectMeta().GetCreationTimestamp(),
-			}},
-		}
-	}
-	if in.GetObjectMeta().GetUID() == "" {
-		return nil, cerrors.ErrorValidation{
-			ErroredFields: []cerrors.ErroredField{{
-				Name:   "Metadata.UID",
-				Reason: "field must be set for an Update request",
-				Value:  in.GetObjectMeta().GetUID(),
-			}},
-		}
+	if err := c.ValidateUpdate(kind, in); err != nil {
+		return nil, err
 	}
 
 	// Convert the resource to a KVPair and pass that to the backend datastore, converting
@@ -163,6 +172,23 @@ func (c *resources) Update(ctx context.Context, opts options.SetOptions, kind st
 	return nil, err
 }
 
+// checkNotDryRun rejects the DryRun option, which is only handled by the WorkloadEndpoint client,
+// rather than let a write that the caller expected to be a dry run go through.
+func checkNotDryRun(opts options.SetOptions, operation, kind string, in resource) error {
+	if !opts.DryRun {
+		return nil
+	}
+	return cerrors.ErrorOperationNotSupported{
+		Operation: operation,
+		Identifier: model.ResourceKey{
+			Kind:      kind,
+			Namespace: in.GetObjectMeta().GetNamespace(),
+			Name:      in.GetObjectMeta().GetName(),
+		},
+		Reason: "dry run is only supported for WorkloadEndpoints",
+	}
+}
+
 // Delete deletes a resource from the backend datastore.
 func (c *resources) Delete(ctx context.Context, opts options.DeleteOptions, kind, ns, name string) (resource, error) {
 	if err := c.checkNamespace(ns, kind); err != nil {
@@ -187,6 +213,113 @@ func (c *resources) Delete(ctx context.Context, opts options.DeleteOptions, kind
 	return nil, err
 }
 
+// errBatchDeleteUnsupported is returned by DeleteBatch if the backend datastore can't delete
+// several resources in a single transaction.
+var errBatchDeleteUnsupported = errors.New("backend datastore does not support batch deletes")
+
+// DeleteBatch deletes the given resources in a single transaction: either all are deleted, or, if
+// any of their resource versions is no longer current, none are.  Returns
+// errBatchDeleteUnsupported if the backend datastore doesn't support this.
+func (c *resources) DeleteBatch(ctx context.Context, kind string, in []resource) ([]resource, error) {
+	batchDeleter, ok := c.backend.(bapi.BatchDeleter)
+	if !ok {
+		return nil, errBatchDeleteUnsupported
+	}
+	kvps := make([]*model.KVPair, len(in))
+	for i, res := range in {
+		if err := c.checkNamespace(res.GetObjectMeta().GetNamespace(), kind); err != nil {
+			return nil, err
+		}
+		kvps[i] = &model.KVPair{
+			Key: model.ResourceKey{
+				Kind:      kind,
+				Name:      res.GetObjectMeta().GetName(),
+				Namespace: res.GetObjectMeta().GetNamespace(),
+			},
+			Revision: res.GetObjectMeta().GetResourceVersion(),
+		}
+	}
+	deleted, err := batchDeleter.DeleteKVPs(ctx, kvps)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]resource, 0, len(deleted))
+	for _, kvp := range deleted {
+		if kvp != nil {
+			out = append(out, c.kvPairToResource(kvp))
+		}
+	}
+	return out, nil
+}
+
+// txnOp is a create, update or delete of a resource in a transaction.  Creates and updates use
+// res; deletes use namespace, name and, optionally, resourceVersion.
+type txnOp struct {
+	opType          bapi.TxnOpType
+	kind            string
+	res             resource
+	setOpts         options.SetOptions
+	namespace, name string
+	resourceVersion string
+}
+
+// Txn applies the given operations in a single transaction: either all are applied, or none
+// are.  Returns the created or updated resources, as stored, and the deleted resources, in the
+// same order as the operations.  If an operation fails, returns an ErrorTransactionFailed that
+// identifies it.  Returns an ErrorOperationNotSupported if the backend datastore doesn't
+// support transactions.
+func (c *resources) Txn(ctx context.Context, ops []txnOp) ([]resource, error) {
+	transactor, ok := c.backend.(bapi.Transactor)
+	if !ok {
+		return nil, cerrors.ErrorOperationNotSupported{
+			Operation:  "Txn",
+			Identifier: "datastore",
+			Reason:     "the datastore does not support transactions",
+		}
+	}
+	bops := make([]bapi.TxnOp, len(ops))
+	for i, op := range ops {
+		var err error
+		switch op.opType {
+		case bapi.TxnOpCreate:
+			err = c.PrepareCreate(op.kind, op.res)
+		case bapi.TxnOpUpdate:
+			err = c.ValidateUpdate(op.kind, op.res)
+		case bapi.TxnOpDelete:
+			err = c.checkNamespace(op.namespace, op.kind)
+		}
+		if err != nil {
+			return nil, cerrors.ErrorTransactionFailed{Index: i, Err: err}
+		}
+		if op.opType == bapi.TxnOpDelete {
+			bops[i] = bapi.TxnOp{
+				Type: op.opType,
+				KVPair: &model.KVPair{
+					Key: model.ResourceKey{
+						Kind:      op.kind,
+						Name:      op.name,
+						Namespace: op.namespace,
+					},
+					Revision: op.resourceVersion,
+				},
+			}
+			continue
+		}
+		bops[i] = bapi.TxnOp{Type: op.opType, KVPair: c.resourceToKVPair(op.setOpts, op.kind, op.res)}
+	}
+	kvps, err := transactor.Txn(ctx, bops)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]resource, len(kvps))
+	for i, kvp := range kvps {
+		if kvp != nil {
+			out[i] = c.kvPairToResource(kvp)
+		}
+	}
+	return out, nil
+}
+
 // Get gets a resource from the backend datastore.
 func (c *resources) Get(ctx context.Context, opts options.GetOptions, kind, ns, name string) (resource, error) {
 	if err := c.checkNamespace(ns, kind); err != nil {
@@ -197,7 +330,7 @@ func (c *resources) Get(ctx context.Context, opts options.GetOptions, kind, ns,
 		Name:      name,
 		Namespace: ns,
 	}
-	kvp, err := c.backend.Get(ctx, key, opts.ResourceVersion)
+	kvp, err := c.readBackendFor(opts.Consistent).Get(ctx, key, opts.ResourceVersion)
 	if err != nil {
 		return nil, err
 	}
@@ -207,6 +340,13 @@ func (c *resources) Get(ctx context.Context, opts options.GetOptions, kind, ns,
 
 // List lists a resource from the backend datastore.
 func (c *resources) List(ctx context.Context, opts options.ListOptions, kind, listKind string, listObj resourceList) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	return c.list(ctx, opts, kind, listKind, listObj)
+}
+
+func (c *resources) list(ctx context.Context, opts options.ListOptions, kind, listKind string, listObj resourceList) error {
 	list := model.ResourceListOptions{
 		Kind:      kind,
 		Name:      opts.Name,
@@ -214,24 +354,76 @@ func (c *resources) List(ctx context.Context, opts options.ListOptions, kind, li
 		Prefix:    opts.Prefix,
 	}
 
+	// Pass a Kubernetes label selector on to the backend, which may filter by it.  We filter
+	// the results ourselves below, because not all backends do.
+	var sel labelSelector
+	if opts.LabelSelector != "" {
+		var err error
+		if sel, err = parseLabelSelector(opts.LabelSelector); err != nil {
+			return err
+		}
+		if k8sSel, ok := sel.(k8sLabelSelector); ok {
+			list.LabelSelector = k8sSel.String()
+		}
+	}
+
+	// Likewise for a field selector.
+	var fieldSel *fieldSelector
+	if opts.FieldSelector != "" {
+		var err error
+		if fieldSel, err = parseFieldSelector(kind, opts.FieldSelector); err != nil {
+			return err
+		}
+		list.FieldSelector = fieldSel.String()
+	}
+
+	// The backends only page and project WorkloadEndpoints.  Pages are filtered by the
+	// selectors below, so a page may have fewer than the limit.
+	if kind == libapiv3.KindWorkloadEndpoint {
+		list.Limit = opts.Limit
+		list.Continue = opts.Continue
+		list.Projection = backendProjection(opts.Projection, sel, fieldSel)
+	} else if opts.Limit != 0 || opts.Continue != "" {
+		return cerrors.ErrorOperationNotSupported{
+			Operation:  "List",
+			Identifier: kind,
+			Reason:     "Limit and Continue are only supported for WorkloadEndpoints",
+		}
+	}
+
 	// Query the backend.
-	kvps, err := c.backend.List(ctx, list, opts.ResourceVersion)
-	if err != nil {
+	if err := ctx.Err(); err != nil {
 		return err
 	}
+	kvps, err := c.readBackendFor(opts.Consistent).List(ctx, list, opts.ResourceVersion)
+	if err != nil {
+		return contextError(ctx, err)
+	}
 
 	// Convert the slice of KVPairs to a slice of Objects.
 	resources := []runtime.Object{}
 	for _, kvp := range kvps.KVPairs {
-		resources = append(resources, c.kvPairToResource(kvp))
+		res := c.kvPairToResource(kvp)
+		if sel != nil && !sel.Evaluate(res.GetObjectMeta().GetLabels()) {
+			continue
+		}
+		if fieldSel != nil && !fieldSel.Evaluate(res) {
+			continue
+		}
+		resources = append(resources, res)
 	}
 	err = meta.SetList(listObj, resources)
 	if err != nil {
 		return err
 	}
 
-	// Finally, set the resource version and api group version of the list object.
+	// Finally, set the resource version, continue token and api group version of the list
+	// object.  The backend's count of the remaining items doesn't account for our filtering.
 	listObj.GetListMeta().SetResourceVersion(kvps.Revision)
+	listObj.GetListMeta().SetContinue(kvps.Continue)
+	if sel == nil && fieldSel == nil {
+		listObj.GetListMeta().SetRemainingItemCount(kvps.RemainingItemCount)
+	}
 	listObj.GetObjectKind().SetGroupVersionKind(schema.GroupVersionKind{
 		Group:   apiv3.Group,
 		Version: apiv3.VersionCurrent,
@@ -243,31 +435,99 @@ func (c *resources) List(ctx context.Context, opts options.ListOptions, kind, li
 
 // Watch watches a specific resource or resource type.
 func (c *resources) Watch(ctx context.Context, opts options.ListOptions, kind string, converter watcherConverter) (watch.Interface, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	return c.watch(ctx, opts, kind, converter)
+}
+
+func (c *resources) watch(ctx context.Context, opts options.ListOptions, kind string, converter watcherConverter) (watch.Interface, error) {
 	list := model.ResourceListOptions{
-		Kind:      kind,
-		Name:      opts.Name,
-		Namespace: opts.Namespace,
+		Kind:                  kind,
+		Name:                  opts.Name,
+		Namespace:             opts.Namespace,
+		AllowWatchBookmarks:   opts.AllowWatchBookmarks,
+		WatchBookmarkInterval: opts.WatchBookmarkInterval,
+	}
+
+	// As for List, pass a field selector on to the backend, which may filter by it, and filter
+	// the events ourselves.
+	var fieldSel *fieldSelector
+	if opts.FieldSelector != "" {
+		var err error
+		if fieldSel, err = parseFieldSelector(kind, opts.FieldSelector); err != nil {
+			return nil, err
+		}
+		list.FieldSelector = fieldSel.String()
 	}
 
 	// Create the backend watcher.  We need to process the results to add revision data etc.
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 	ctx, cancel := context.WithCancel(ctx)
-	backend, err := c.backend.Watch(ctx, list, opts.ResourceVersion)
+	be := c.readBackendFor(opts.Consistent)
+	backend, err := be.Watch(ctx, list, opts.ResourceVersion)
 	if err != nil {
 		cancel()
-		return nil, err
+		return nil, contextError(ctx, err)
+	}
+	if opts.Reconnect {
+		backend = newReconnectingWatcher(ctx, be, list, opts.ResourceVersion, backend)
 	}
 	w := &watcher{
-		results:   make(chan watch.Event, 100),
-		client:    c,
-		cancel:    cancel,
-		context:   ctx,
-		backend:   backend,
-		converter: converter,
+		results:        make(chan watch.Event, watchBufferSize(opts)),
+		client:         c,
+		cancel:         cancel,
+		context:        ctx,
+		backend:        backend,
+		converter:      converter,
+		fieldSelector:  fieldSel,
+		overflowPolicy: opts.WatchOverflowPolicy,
 	}
 	go w.run()
 	return w, nil
 }
 
+// ValidateUpdate performs the checks that Update makes on a resource before passing it to the
+// backend datastore.
+func (c *resources) ValidateUpdate(kind string, in resource) error {
+	// A ResourceVersion should always be specified on an Update.
+	if len(in.GetObjectMeta().GetResourceVersion()) == 0 {
+		logWithResource(in).Info("Rejecting Update request with empty resource version")
+		return cerrors.ErrorValidation{
+			ErroredFields: []cerrors.ErroredField{{
+				Name:   "Metadata.ResourceVersion",
+				Reason: "field must be set for an Update request",
+				Value:  in.GetObjectMeta().GetResourceVersion(),
+			}},
+		}
+	}
+	if err := c.checkNamespace(in.GetObjectMeta().GetNamespace(), kind); err != nil {
+		return err
+	}
+	creationTimestamp := in.GetObjectMeta().GetCreationTimestamp()
+	if creationTimestamp.IsZero() {
+		return cerrors.ErrorValidation{
+			ErroredFields: []cerrors.ErroredField{{
+				Name:   "Metadata.CreationTimestamp",
+				Reason: "field must be set for an Update request",
+				Value:  in.GetObjectMeta().GetCreationTimestamp(),
+			}},
+		}
+	}
+	if in.GetObjectMeta().GetUID() == "" {
+		return cerrors.ErrorValidation{
+			ErroredFields: []cerrors.ErroredField{{
+				Name:   "Metadata.UID",
+				Reason: "field must be set for an Update request",
+				Value:  in.GetObjectMeta().GetUID(),
+			}},
+		}
+	}
+	return nil
+}
+
 // resourceToKVPair converts the resource to a KVPair that can be consumed by the
 // backend datastore client.
 func (c *resources) resourceToKVPair(opts options.SetOptions, kind string, in resource) *model.KVPair {
@@ -297,6 +557,24 @@ func (c *resources) resourceToKVPair(opts options.SetOptions, kind string, in re
 	}
 }
 
+// backendProjection returns the WorkloadEndpoint fields to ask the backend for, to populate the
+// given fields and to evaluate the given selectors.  Callers of List mask the listed resources to
+// the fields that they asked for.
+func backendProjection(fields []string, sel labelSelector, fieldSel *fieldSelector) []string {
+	if len(fields) == 0 {
+		return nil
+	}
+	fields = append([]string(nil), fields...)
+	if sel != nil {
+		fields = append(fields, "Labels")
+	}
+	if fieldSel != nil {
+		// The selectable fields of a WorkloadEndpoint; see selectableFields.
+		fields = append(fields, "Name", "Namespace", "Node", "Orchestrator")
+	}
+	return fields
+}
+
 // kvPairToResource converts a KVPair returned by the backend datastore client to a
 // resource.
 func (c *resources) kvPairToResource(kvp *model.KVPair) resource {
@@ -334,6 +612,11 @@ type watcher struct {
 	client     *resources
 	terminated uint32
 	converter  watcherConverter
+
+	// fieldSelector, if set, filters the events that are sent.
+	fieldSelector *fieldSelector
+
+	overflowPolicy options.WatchOverflowPolicy
 }
 
 func (w *watcher) Stop() {
@@ -357,22 +640,47 @@ func (w *watcher) run() {
 		case event, ok := <-w.backend.ResultChan():
 			if !ok {
 				log.Debug("Watcher results channel closed by remote")
+				w.sendDeadlineExceeded()
 				return
 			}
-			e := w.convertEvent(event)
-			select {
-			case w.results <- e:
-			case <-w.context.Done():
-				log.Info("Process backend watcher done event during watch event in main client")
+			if event, ok = w.filterEvent(event); !ok {
+				continue
+			}
+			if !w.send(w.convertEvent(event)) {
 				return
 			}
 		case <-w.context.Done(): // user cancel
 			log.Info("Process backend watcher done event in main client")
+			w.sendDeadlineExceeded()
 			return
 		}
 	}
 }
 
+// sendDeadlineExceeded sends an Error event if the watch is ending because the deadline of the
+// caller's context has passed, rather than because the watcher was stopped, so that the consumer
+// can tell the two apart.  If the consumer's buffer is full, the oldest event is dropped to make
+// room, since the watch is ending and the consumer must resync anyway.
+func (w *watcher) sendDeadlineExceeded() {
+	if !errors.Is(w.context.Err(), context.DeadlineExceeded) {
+		return
+	}
+	e := watch.Event{Type: watch.Error, Error: context.DeadlineExceeded}
+	select {
+	case w.results <- e:
+		return
+	default:
+	}
+	// The consumer may be reading concurrently, in which case there is already room.
+	select {
+	case <-w.results:
+		log.Warning("Watch buffer full when the deadline passed, dropped the oldest event")
+	default:
+	}
+	// We're the only sender, so there is now room and this send can't block.
+	w.results <- e
+}
+
 // terminate all resources associated with this watcher.
 func (w *watcher) terminate() {
 	log.Info("Terminating main client watcher loop")
@@ -381,6 +689,50 @@ func (w *watcher) terminate() {
 	atomic.AddUint32(&w.terminated, 1)
 }
 
+// filterEvent applies the field selector, if any, to a backend watch event.  Returns false if
+// the event should be dropped.  A modification that moves a resource into or out of the
+// selection is returned as an Added or Deleted event, so that the consumer sees the same set of
+// resources as it would by listing them.  An event for a resource that can't be checked, for
+// example a deletion without the old value, is passed on.
+func (w *watcher) filterEvent(event bapi.WatchEvent) (bapi.WatchEvent, bool) {
+	if w.fieldSelector == nil {
+		return event, true
+	}
+	matches := func(kvp *model.KVPair) bool {
+		if kvp == nil {
+			return false
+		}
+		res, ok := kvp.Value.(resource)
+		return !ok || w.fieldSelector.Evaluate(res)
+	}
+	switch event.Type {
+	case bapi.WatchAdded:
+		return event, matches(event.New)
+	case bapi.WatchDeleted:
+		return event, event.Old == nil || matches(event.Old)
+	case bapi.WatchModified:
+		newMatches := matches(event.New)
+		if event.Old == nil {
+			return event, newMatches
+		}
+		oldMatches := matches(event.Old)
+		switch {
+		case oldMatches && newMatches:
+			return event, true
+		case newMatches:
+			event.Type = bapi.WatchAdded
+			event.Old = nil
+			return event, true
+		case oldMatches:
+			event.Type = bapi.WatchDeleted
+			event.New = nil
+			return event, true
+		}
+		return event, false
+	}
+	return event, true
+}
+
 // convertEvent converts a backend watch event into a client watch event.
 func (w *watcher) convertEvent(backendEvent bapi.WatchEvent) watch.Event {
 	apiEvent := watch.Event{
@@ -393,8 +745,12 @@ func (w *watcher) convertEvent(backendEvent bapi.WatchEvent) watch.Event {
 		apiEvent.Type = watch.Added
 	case bapi.WatchDeleted:
 		apiEvent.Type = watch.Deleted
+		apiEvent.Expired = backendEvent.Expired
 	case bapi.WatchModified:
 		apiEvent.Type = watch.Modified
+	case bapi.WatchBookmark:
+		apiEvent.Type = watch.Bookmark
+		apiEvent.ResourceVersion = backendEvent.Revision
 	}
 
 	if backendEvent.Old != nil {
@@ -407,7 +763,7 @@ func (w *watcher) convertEvent(backendEvent bapi.WatchEvent) watch.Event {
 	if backendEvent.New != nil {
 		res := w.client.kvPairToResource(backendEvent.New)
 		if w.converter != nil {
-			apiEvent.Object = w.converter.Convert(res)
+			res = w.converter.Convert(res)
 		}
 		apiEvent.Object = res
 	}
@@ -424,6 +780,15 @@ func (w *watcher) hasTerminated() bool {
 	return t && bt
 }
 
+// contextError returns the error of the context, if it is done, in place of an error from the
+// backend, which may have wrapped the context's error or replaced it with one of its own.
+func contextError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
 // logWithResource returns a logrus entry with key resource attributes included.
 func logWithResource(res resource) *log.Entry {
 	return log.WithFields(log.Fields{
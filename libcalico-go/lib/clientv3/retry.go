@@ -0,0 +1,77 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clientv3
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+
+	cerrors "github.com/projectcalico/calico/libcalico-go/lib/errors"
+)
+
+// IsUpdateConflict returns true if the error is, or wraps, an errors.ErrorResourceUpdateConflict:
+// that is, a write failed because the resource was modified after it was read.  Kubernetes
+// conflict errors, which the Kubernetes datastore normally converts, are also recognised.
+func IsUpdateConflict(err error) bool {
+	if err == nil {
+		return false
+	}
+	var conflict cerrors.ErrorResourceUpdateConflict
+	if errors.As(err, &conflict) {
+		return true
+	}
+	var conflictPtr *cerrors.ErrorResourceUpdateConflict
+	if errors.As(err, &conflictPtr) {
+		return true
+	}
+	return kerrors.IsConflict(err)
+}
+
+// RetryOnConflict calls fn, which should read a resource, modify it and write it back, until it
+// returns something other than an update conflict (see IsUpdateConflict), or it has been called
+// attempts times; it returns the error from the last call.  fn must re-read the resource on each
+// call, so that its write is based on the latest revision.  RetryOnConflict waits for backoff
+// before the first retry, and doubles the wait for each further retry.  If the context is done
+// while waiting, it returns the context's error.
+func RetryOnConflict(ctx context.Context, attempts int, backoff time.Duration, fn func() error) error {
+	if attempts < 1 {
+		attempts = 1
+	}
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if !IsUpdateConflict(err) || attempt >= attempts {
+			return err
+		}
+		log.WithError(err).WithField("attempt", attempt).Info("Update conflict, retrying")
+		if backoff <= 0 {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			continue
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
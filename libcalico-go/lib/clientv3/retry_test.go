@@ -0,0 +1,147 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clientv3
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	apiv3 "github.com/projectcalico/api/pkg/apis/projectcalico/v3"
+
+	"github.com/projectcalico/calico/libcalico-go/lib/backend/model"
+	"github.com/projectcalico/calico/libcalico-go/lib/errors"
+)
+
+var _ = Describe("Retry on conflict", func() {
+	conflict := errors.ErrorResourceUpdateConflict{
+		Identifier: model.ResourceKey{Kind: apiv3.KindProfile, Name: "profile"},
+	}
+
+	DescribeTable("IsUpdateConflict",
+		func(err error, expected bool) {
+			Expect(IsUpdateConflict(err)).To(Equal(expected))
+		},
+		Entry("nil", nil, false),
+		Entry("update conflict", conflict, true),
+		Entry("pointer to update conflict", &conflict, true),
+		Entry("wrapped update conflict", fmt.Errorf("updating: %w", conflict), true),
+		Entry("Kubernetes conflict", kerrors.NewConflict(schema.GroupResource{}, "profile", fmt.Errorf("modified")), true),
+		Entry("other datastore error", errors.ErrorResourceDoesNotExist{Identifier: conflict.Identifier}, false),
+		Entry("error with a conflict-like message", fmt.Errorf("update conflict"), false),
+	)
+
+	// store simulates a datastore with a single resource that is concurrently updated by
+	// someone else the first time it is written.
+	type store struct {
+		revision    int
+		value       string
+		concurrent  bool
+		writes      int
+		readsBefore []int
+	}
+	update := func(s *store, value string) func() error {
+		return func() error {
+			read := s.revision
+			s.readsBefore = append(s.readsBefore, read)
+			if !s.concurrent {
+				s.concurrent = true
+				s.revision++
+				s.value = "theirs"
+			}
+			if read != s.revision {
+				return errors.ErrorResourceUpdateConflict{
+					Identifier: model.ResourceKey{Kind: apiv3.KindProfile, Name: strconv.Itoa(read)},
+				}
+			}
+			s.writes++
+			s.revision++
+			s.value = value
+			return nil
+		}
+	}
+
+	It("should succeed on the second attempt after a conflicting update", func() {
+		s := &store{}
+		err := RetryOnConflict(context.Background(), 3, 0, update(s, "ours"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(s.value).To(Equal("ours"))
+		Expect(s.writes).To(Equal(1))
+		Expect(s.readsBefore).To(Equal([]int{0, 1}))
+	})
+
+	It("should return the conflict once the attempts are used up", func() {
+		calls := 0
+		err := RetryOnConflict(context.Background(), 3, 0, func() error {
+			calls++
+			return conflict
+		})
+		Expect(err).To(Equal(conflict))
+		Expect(calls).To(Equal(3))
+	})
+
+	It("should call the function once if attempts is not positive", func() {
+		calls := 0
+		err := RetryOnConflict(context.Background(), 0, 0, func() error {
+			calls++
+			return conflict
+		})
+		Expect(err).To(Equal(conflict))
+		Expect(calls).To(Equal(1))
+	})
+
+	It("should not retry other errors", func() {
+		calls := 0
+		other := errors.ErrorResourceDoesNotExist{Identifier: conflict.Identifier}
+		err := RetryOnConflict(context.Background(), 3, 0, func() error {
+			calls++
+			return other
+		})
+		Expect(err).To(Equal(other))
+		Expect(calls).To(Equal(1))
+	})
+
+	It("should back off between attempts", func() {
+		calls := 0
+		start := time.Now()
+		err := RetryOnConflict(context.Background(), 3, 10*time.Millisecond, func() error {
+			calls++
+			return conflict
+		})
+		Expect(err).To(Equal(conflict))
+		Expect(calls).To(Equal(3))
+		// 10ms, then 20ms.
+		Expect(time.Since(start)).To(BeNumerically(">=", 30*time.Millisecond))
+	})
+
+	It("should stop waiting when the context is done", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		calls := 0
+		err := RetryOnConflict(ctx, 3, time.Hour, func() error {
+			calls++
+			cancel()
+			return conflict
+		})
+		Expect(err).To(Equal(context.Canceled))
+		Expect(calls).To(Equal(1))
+	})
+})
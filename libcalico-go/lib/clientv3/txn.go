@@ -0,0 +1,222 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clientv3
+
+import (
+	"context"
+
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	libapiv3 "github.com/projectcalico/calico/libcalico-go/lib/apis/v3"
+	bapi "github.com/projectcalico/calico/libcalico-go/lib/backend/api"
+	"github.com/projectcalico/calico/libcalico-go/lib/errors"
+	"github.com/projectcalico/calico/libcalico-go/lib/options"
+	validator "github.com/projectcalico/calico/libcalico-go/lib/validator/v3"
+)
+
+// TxnResource is a resource that can be created or updated in a transaction.  All Calico
+// resources implement it.
+type TxnResource interface {
+	runtime.Object
+	v1.ObjectMetaAccessor
+}
+
+// TxnResult is the result of one operation in a transaction.
+type TxnResult struct {
+	// Object is the created or updated resource, as stored, or the deleted resource.  It is nil
+	// if the transaction failed.
+	Object runtime.Object
+	// Err is the reason that the operation failed.  If a transaction fails, only the operation
+	// that caused the failure has an error.
+	Err error
+}
+
+// Txn accumulates creates, updates and deletes of WorkloadEndpoints and other resources and
+// commits them in a single datastore transaction: either all of them are applied or, if any of
+// them fails, none are.  Create a Txn with Interface.Txn(); a Txn is not safe for concurrent use.
+//
+// WorkloadEndpoints are validated and defaulted exactly as by the WorkloadEndpoint client, and
+// its hooks are called once the transaction has been committed.  Other resources are validated,
+// but not defaulted by their kind's client, so they must be complete.
+//
+// Transactions are only supported by the etcdv3 datastore; see Capabilities.
+type Txn struct {
+	client client
+	ops    []txnOp
+}
+
+// Txn returns a new, empty, transaction.
+func (c client) Txn() *Txn {
+	return &Txn{client: c}
+}
+
+// CreateWorkloadEndpoint adds a create of the WorkloadEndpoint to the transaction.
+func (t *Txn) CreateWorkloadEndpoint(res *libapiv3.WorkloadEndpoint, opts options.SetOptions) *Txn {
+	return t.Create(libapiv3.KindWorkloadEndpoint, res, opts)
+}
+
+// UpdateWorkloadEndpoint adds an update of the WorkloadEndpoint to the transaction.  As with
+// Update, the update is conditional on the WorkloadEndpoint's resource version.
+func (t *Txn) UpdateWorkloadEndpoint(res *libapiv3.WorkloadEndpoint, opts options.SetOptions) *Txn {
+	return t.Update(libapiv3.KindWorkloadEndpoint, res, opts)
+}
+
+// DeleteWorkloadEndpoint adds a delete of the WorkloadEndpoint to the transaction.  If
+// opts.ResourceVersion is set, the delete is conditional on it.
+func (t *Txn) DeleteWorkloadEndpoint(namespace, name string, opts options.DeleteOptions) *Txn {
+	return t.Delete(libapiv3.KindWorkloadEndpoint, namespace, name, opts)
+}
+
+// Create adds a create of a resource of the given kind to the transaction.
+func (t *Txn) Create(kind string, res TxnResource, opts options.SetOptions) *Txn {
+	t.ops = append(t.ops, txnOp{opType: bapi.TxnOpCreate, kind: kind, res: res, setOpts: opts})
+	return t
+}
+
+// Update adds an update of a resource of the given kind to the transaction.  The update is
+// conditional on the resource's resource version.
+func (t *Txn) Update(kind string, res TxnResource, opts options.SetOptions) *Txn {
+	t.ops = append(t.ops, txnOp{opType: bapi.TxnOpUpdate, kind: kind, res: res, setOpts: opts})
+	return t
+}
+
+// Delete adds a delete of a resource of the given kind to the transaction.  If
+// opts.ResourceVersion is set, the delete is conditional on it.
+func (t *Txn) Delete(kind, namespace, name string, opts options.DeleteOptions) *Txn {
+	t.ops = append(t.ops, txnOp{
+		opType:          bapi.TxnOpDelete,
+		kind:            kind,
+		namespace:       namespace,
+		name:            name,
+		resourceVersion: opts.ResourceVersion,
+	})
+	return t
+}
+
+// Commit applies the operations in the transaction.  Returns a result for each operation, in
+// the order they were added.  If any operation fails, none are applied and an
+// ErrorTransactionFailed is returned, identifying the operation that failed; that operation's
+// result holds the reason.  The resources passed to the transaction are not modified.
+func (t *Txn) Commit(ctx context.Context) ([]TxnResult, error) {
+	results := make([]TxnResult, len(t.ops))
+	if len(t.ops) == 0 {
+		return results, nil
+	}
+	if !t.client.Capabilities().Transactions {
+		return nil, errors.ErrorOperationNotSupported{
+			Operation:  "Txn",
+			Identifier: t.client.BackendType(),
+			Reason:     "the datastore does not support transactions",
+		}
+	}
+	fail := func(i int, err error) ([]TxnResult, error) {
+		if txnErr, ok := err.(errors.ErrorTransactionFailed); ok {
+			i, err = txnErr.Index, txnErr.Err
+		}
+		results[i].Err = err
+		return results, errors.ErrorTransactionFailed{Index: i, Err: err}
+	}
+
+	// Validate and default a copy of each resource.  For WorkloadEndpoint updates, we also
+	// need the stored WorkloadEndpoint for the hooks.
+	weps := workloadEndpoints{client: t.client}
+	ops := make([]txnOp, len(t.ops))
+	befores := make([]*libapiv3.WorkloadEndpoint, len(t.ops))
+	warnings := make([]wepWarnings, len(t.ops))
+	numWEPOps := 0
+	for i, op := range t.ops {
+		if op.opType != bapi.TxnOpDelete && op.res == nil {
+			return fail(i, errors.ErrorValidation{
+				ErroredFields: []errors.ErroredField{{Name: "Resource", Reason: "resource must not be nil"}},
+			})
+		}
+		if op.kind == libapiv3.KindWorkloadEndpoint {
+			numWEPOps++
+		}
+		var err error
+		switch {
+		case op.kind == libapiv3.KindWorkloadEndpoint && op.opType == bapi.TxnOpCreate:
+			wep, ok := op.res.(*libapiv3.WorkloadEndpoint)
+			if !ok {
+				return fail(i, errors.ErrorValidation{
+					ErroredFields: []errors.ErroredField{{Name: "Resource", Reason: "resource is not a WorkloadEndpoint"}},
+				})
+			}
+			op.res, err = weps.prepareCreate(wep, op.setOpts, &warnings[i])
+		case op.kind == libapiv3.KindWorkloadEndpoint && op.opType == bapi.TxnOpUpdate:
+			wep, ok := op.res.(*libapiv3.WorkloadEndpoint)
+			if !ok {
+				return fail(i, errors.ErrorValidation{
+					ErroredFields: []errors.ErroredField{{Name: "Resource", Reason: "resource is not a WorkloadEndpoint"}},
+				})
+			}
+			if wep, err = weps.prepareUpdate(wep, op.setOpts, &warnings[i]); err == nil {
+				// The update is conditional on the revision of the stored
+				// WorkloadEndpoint so it is also the "before" state for the hooks.
+				befores[i], err = weps.getForUpdateHooks(ctx, wep)
+				op.res = wep
+			}
+		case op.opType != bapi.TxnOpDelete:
+			op.res = op.res.DeepCopyObject().(resource)
+			err = validator.Validate(op.res)
+		}
+		if err != nil {
+			return fail(i, err)
+		}
+		ops[i] = op
+	}
+
+	if numWEPOps > 0 {
+		if err := t.client.wepWriteLimiter.wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+	out, err := t.client.resources.Txn(ctx, ops)
+	if err != nil {
+		if _, ok := err.(errors.ErrorTransactionFailed); ok {
+			return fail(0, err)
+		}
+		return nil, err
+	}
+	log.WithFields(log.Fields{
+		"numOps":    len(ops),
+		"numWEPOps": numWEPOps,
+	}).Debug("Committed transaction")
+
+	for i, op := range ops {
+		results[i].Object = out[i]
+		wep, ok := out[i].(*libapiv3.WorkloadEndpoint)
+		if !ok || op.kind != libapiv3.KindWorkloadEndpoint {
+			continue
+		}
+		if op.opType == bapi.TxnOpUpdate {
+			checkCreationTimestampKept(op.res.(*libapiv3.WorkloadEndpoint), wep, &warnings[i])
+		}
+		warnings[i].deliver(op.setOpts)
+		switch op.opType {
+		case bapi.TxnOpCreate:
+			weps.runCreateHooks(ctx, wep)
+		case bapi.TxnOpUpdate:
+			if befores[i] != nil {
+				weps.runUpdateHooks(ctx, befores[i], wep)
+			}
+		case bapi.TxnOpDelete:
+			weps.runDeleteHooks(ctx, wep)
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,222 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clientv3_test
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	apiv3 "github.com/projectcalico/api/pkg/apis/projectcalico/v3"
+
+	"github.com/projectcalico/calico/libcalico-go/lib/apiconfig"
+	libapiv3 "github.com/projectcalico/calico/libcalico-go/lib/apis/v3"
+	"github.com/projectcalico/calico/libcalico-go/lib/backend"
+	"github.com/projectcalico/calico/libcalico-go/lib/clientv3"
+	"github.com/projectcalico/calico/libcalico-go/lib/errors"
+	"github.com/projectcalico/calico/libcalico-go/lib/options"
+	"github.com/projectcalico/calico/libcalico-go/lib/testutils"
+)
+
+var _ = testutils.E2eDatastoreDescribe("Transaction tests", testutils.DatastoreEtcdV3, func(config apiconfig.CalicoAPIConfig) {
+
+	ctx := context.Background()
+	namespace := "namespace-1"
+
+	var c clientv3.Interface
+
+	wep := func(cid string) *libapiv3.WorkloadEndpoint {
+		return &libapiv3.WorkloadEndpoint{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "node--2-cni-" + cid + "-eth0"},
+			Spec: libapiv3.WorkloadEndpointSpec{
+				Node:          "node-2",
+				Orchestrator:  "cni",
+				ContainerID:   cid,
+				Endpoint:      "eth0",
+				InterfaceName: "cali" + cid,
+			},
+		}
+	}
+
+	netSet := func(name string) *apiv3.NetworkSet {
+		return &apiv3.NetworkSet{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+			Spec:       apiv3.NetworkSetSpec{Nets: []string{"10.0.0.0/24"}},
+		}
+	}
+
+	expectWEPExists := func(cid string, exists bool) {
+		_, err := c.WorkloadEndpoints().Get(ctx, namespace, wep(cid).Name, options.GetOptions{})
+		if exists {
+			ExpectWithOffset(1, err).NotTo(HaveOccurred())
+		} else {
+			ExpectWithOffset(1, err).To(BeAssignableToTypeOf(errors.ErrorResourceDoesNotExist{}))
+		}
+	}
+
+	// existing and toDelete are created before each test.
+	var existing, toDelete *libapiv3.WorkloadEndpoint
+
+	BeforeEach(func() {
+		var err error
+		c, err = clientv3.New(config)
+		Expect(err).NotTo(HaveOccurred())
+
+		be, err := backend.NewClient(config)
+		Expect(err).NotTo(HaveOccurred())
+		be.Clean()
+
+		existing, err = c.WorkloadEndpoints().Create(ctx, wep("existing"), options.SetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		toDelete, err = c.WorkloadEndpoints().Create(ctx, wep("todelete"), options.SetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should report that transactions are supported", func() {
+		Expect(c.Capabilities().Transactions).To(BeTrue())
+	})
+
+	It("should apply all the operations of a valid transaction", func() {
+		update := existing.DeepCopy()
+		update.Labels = map[string]string{"updated": "true"}
+
+		results, err := c.Txn().
+			CreateWorkloadEndpoint(wep("new"), options.SetOptions{}).
+			UpdateWorkloadEndpoint(update, options.SetOptions{}).
+			DeleteWorkloadEndpoint(namespace, toDelete.Name, options.DeleteOptions{ResourceVersion: toDelete.ResourceVersion}).
+			Create(apiv3.KindNetworkSet, netSet("related"), options.SetOptions{}).
+			Commit(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(results).To(HaveLen(4))
+		for _, r := range results {
+			Expect(r.Err).NotTo(HaveOccurred())
+			Expect(r.Object).NotTo(BeNil())
+		}
+
+		created := results[0].Object.(*libapiv3.WorkloadEndpoint)
+		Expect(created.Name).To(Equal(wep("new").Name))
+		Expect(created.ResourceVersion).NotTo(BeEmpty())
+		Expect(created.CreationTimestamp.IsZero()).To(BeFalse())
+		Expect(results[1].Object.(*libapiv3.WorkloadEndpoint).Labels).To(HaveKeyWithValue("updated", "true"))
+		Expect(results[2].Object.(*libapiv3.WorkloadEndpoint).Name).To(Equal(toDelete.Name))
+		Expect(results[3].Object.(*apiv3.NetworkSet).Name).To(Equal("related"))
+
+		By("checking the datastore")
+		expectWEPExists("new", true)
+		expectWEPExists("todelete", false)
+		stored, err := c.WorkloadEndpoints().Get(ctx, namespace, existing.Name, options.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stored.Labels).To(HaveKeyWithValue("updated", "true"))
+		_, err = c.NetworkSets().Get(ctx, namespace, "related", options.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("should apply nothing if one operation fails", func() {
+		_, err := c.WorkloadEndpoints().Create(ctx, wep("other"), options.SetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		update := existing.DeepCopy()
+		update.Labels = map[string]string{"updated": "true"}
+
+		results, err := c.Txn().
+			CreateWorkloadEndpoint(wep("new"), options.SetOptions{}).
+			UpdateWorkloadEndpoint(update, options.SetOptions{}).
+			DeleteWorkloadEndpoint(namespace, toDelete.Name, options.DeleteOptions{}).
+			Create(apiv3.KindNetworkSet, netSet("related"), options.SetOptions{}).
+			// Fails because the WorkloadEndpoint already exists.
+			CreateWorkloadEndpoint(wep("other"), options.SetOptions{}).
+			Commit(ctx)
+		Expect(err).To(BeAssignableToTypeOf(errors.ErrorTransactionFailed{}))
+		Expect(err.(errors.ErrorTransactionFailed).Index).To(Equal(4))
+		Expect(results).To(HaveLen(5))
+		Expect(results[4].Err).To(BeAssignableToTypeOf(errors.ErrorResourceAlreadyExists{}))
+		for _, r := range results[:4] {
+			Expect(r.Err).NotTo(HaveOccurred())
+			Expect(r.Object).To(BeNil())
+		}
+
+		By("checking that there is no partial state")
+		expectWEPExists("new", false)
+		expectWEPExists("todelete", true)
+		stored, err := c.WorkloadEndpoints().Get(ctx, namespace, existing.Name, options.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(stored.ResourceVersion).To(Equal(existing.ResourceVersion))
+		_, err = c.NetworkSets().Get(ctx, namespace, "related", options.GetOptions{})
+		Expect(err).To(BeAssignableToTypeOf(errors.ErrorResourceDoesNotExist{}))
+	})
+
+	It("should fail if an update has a stale resource version", func() {
+		stale := existing.DeepCopy()
+		_, err := c.WorkloadEndpoints().Update(ctx, existing, options.SetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+
+		results, err := c.Txn().
+			CreateWorkloadEndpoint(wep("new"), options.SetOptions{}).
+			UpdateWorkloadEndpoint(stale, options.SetOptions{}).
+			Commit(ctx)
+		Expect(err).To(Equal(errors.ErrorTransactionFailed{Index: 1, Err: results[1].Err}))
+		Expect(results[1].Err).To(BeAssignableToTypeOf(errors.ErrorResourceUpdateConflict{}))
+		expectWEPExists("new", false)
+	})
+
+	It("should fail if a delete is of a WorkloadEndpoint that doesn't exist", func() {
+		results, err := c.Txn().
+			CreateWorkloadEndpoint(wep("new"), options.SetOptions{}).
+			DeleteWorkloadEndpoint(namespace, wep("missing").Name, options.DeleteOptions{}).
+			Commit(ctx)
+		Expect(err).To(BeAssignableToTypeOf(errors.ErrorTransactionFailed{}))
+		Expect(results[1].Err).To(BeAssignableToTypeOf(errors.ErrorResourceDoesNotExist{}))
+		expectWEPExists("new", false)
+	})
+
+	It("should validate the resources before writing anything", func() {
+		invalid := wep("invalid")
+		invalid.Spec.InterfaceName = "interface-name-too-long"
+
+		results, err := c.Txn().
+			DeleteWorkloadEndpoint(namespace, toDelete.Name, options.DeleteOptions{}).
+			CreateWorkloadEndpoint(invalid, options.SetOptions{}).
+			Commit(ctx)
+		Expect(err).To(BeAssignableToTypeOf(errors.ErrorTransactionFailed{}))
+		Expect(err.(errors.ErrorTransactionFailed).Index).To(Equal(1))
+		Expect(results[1].Err).To(BeAssignableToTypeOf(errors.ErrorValidation{}))
+		expectWEPExists("todelete", true)
+	})
+
+	It("should reject a transaction that writes the same resource twice", func() {
+		_, err := c.Txn().
+			CreateWorkloadEndpoint(wep("new"), options.SetOptions{}).
+			DeleteWorkloadEndpoint(namespace, wep("new").Name, options.DeleteOptions{}).
+			Commit(ctx)
+		Expect(err).To(BeAssignableToTypeOf(errors.ErrorTransactionFailed{}))
+		Expect(err.(errors.ErrorTransactionFailed).Index).To(Equal(1))
+		expectWEPExists("new", false)
+	})
+
+	It("should not modify the resources passed to it", func() {
+		in := wep("new")
+		_, err := c.Txn().CreateWorkloadEndpoint(in, options.SetOptions{}).Commit(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(in.ResourceVersion).To(BeEmpty())
+		Expect(in.CreationTimestamp.IsZero()).To(BeTrue())
+	})
+
+	It("should commit an empty transaction", func() {
+		results, err := c.Txn().Commit(ctx)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(results).To(BeEmpty())
+	})
+})
@@ -0,0 +1,90 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clientv3
+
+import (
+	log "github.com/sirupsen/logrus"
+
+	cerrors "github.com/projectcalico/calico/libcalico-go/lib/errors"
+	"github.com/projectcalico/calico/libcalico-go/lib/options"
+	"github.com/projectcalico/calico/libcalico-go/lib/watch"
+)
+
+// DefaultWatchBufferSize is the number of undelivered events that a Watch buffers for its consumer
+// if the WatchBufferSize option is not set.
+const DefaultWatchBufferSize = 100
+
+// watchBufferSize returns the size of the buffer to use for a Watch with the given options.
+func watchBufferSize(opts options.ListOptions) int {
+	if opts.WatchBufferSize == 0 {
+		return DefaultWatchBufferSize
+	}
+	return opts.WatchBufferSize
+}
+
+// send delivers an event to the consumer, applying the watcher's overflow policy if the buffer
+// is full.  It returns false if the watcher should stop.
+func (w *watcher) send(e watch.Event) bool {
+	select {
+	case w.results <- e:
+		return true
+	default:
+	}
+
+	switch w.overflowPolicy {
+	case options.WatchOverflowDropOldest:
+		// Drop events until there is room for the Error event and the new event.  The consumer
+		// may be reading concurrently, so stop dropping as soon as the buffer is empty.
+		dropped := 0
+	drop:
+		for dropped < 2 {
+			select {
+			case <-w.results:
+				dropped++
+			default:
+				break drop
+			}
+		}
+		log.WithField("dropped", dropped).Warning("Watch buffer overflowed, dropped oldest events")
+		// We're the only sender, so there is now room for both events and these sends can't
+		// block.
+		if dropped > 0 {
+			w.results <- watch.Event{
+				Type:  watch.Error,
+				Error: cerrors.ErrorWatchOverflow{BufferSize: cap(w.results), Dropped: dropped},
+			}
+		}
+		w.results <- e
+		return true
+	case options.WatchOverflowClose:
+		log.Warning("Watch buffer overflowed, closing watch")
+		select {
+		case w.results <- watch.Event{
+			Type:  watch.Error,
+			Error: cerrors.ErrorWatchOverflow{BufferSize: cap(w.results), Closed: true},
+		}:
+		case <-w.context.Done():
+		}
+		return false
+	default:
+		select {
+		case w.results <- e:
+			return true
+		case <-w.context.Done():
+			log.Info("Process backend watcher done event during watch event in main client")
+			return false
+		}
+	}
+}
@@ -0,0 +1,227 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clientv3
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+
+	bapi "github.com/projectcalico/calico/libcalico-go/lib/backend/api"
+	"github.com/projectcalico/calico/libcalico-go/lib/backend/model"
+)
+
+const (
+	// reconnectMinBackoff and reconnectMaxBackoff bound the wait between failed attempts to
+	// re-establish a watch.
+	reconnectMinBackoff = 100 * time.Millisecond
+	reconnectMaxBackoff = 10 * time.Second
+)
+
+// reconnectingWatcher is a backend watcher that re-establishes the underlying backend watch,
+// from the revision of the last event that it passed on, when the watch fails with a transient
+// error or is closed by the datastore.  Transient errors are not passed on.  If the revision is
+// no longer available, the error is passed on and the watcher terminates, since the consumer
+// must relist.
+type reconnectingWatcher struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	backend bapi.Client
+	list    model.ListInterface
+	results chan bapi.WatchEvent
+
+	// inner is the current underlying watcher, and revision is the revision of the last
+	// event passed on.  Both are only accessed from the run() goroutine.
+	inner    bapi.WatchInterface
+	revision string
+
+	terminated uint32
+}
+
+// newReconnectingWatcher wraps the backend watcher, which was started at the given revision
+// with the given backend and list options, in a reconnectingWatcher.
+func newReconnectingWatcher(
+	ctx context.Context, backend bapi.Client, list model.ListInterface, revision string, inner bapi.WatchInterface,
+) *reconnectingWatcher {
+	ctx, cancel := context.WithCancel(ctx)
+	rw := &reconnectingWatcher{
+		ctx:      ctx,
+		cancel:   cancel,
+		backend:  backend,
+		list:     list,
+		results:  make(chan bapi.WatchEvent, DefaultWatchBufferSize),
+		inner:    inner,
+		revision: revision,
+	}
+	go rw.run()
+	return rw
+}
+
+func (rw *reconnectingWatcher) Stop() {
+	rw.cancel()
+}
+
+func (rw *reconnectingWatcher) ResultChan() <-chan bapi.WatchEvent {
+	return rw.results
+}
+
+func (rw *reconnectingWatcher) HasTerminated() bool {
+	return atomic.LoadUint32(&rw.terminated) != 0
+}
+
+func (rw *reconnectingWatcher) run() {
+	defer func() {
+		rw.inner.Stop()
+		close(rw.results)
+		atomic.StoreUint32(&rw.terminated, 1)
+	}()
+	defer rw.cancel()
+
+	for rw.forward() && rw.reconnect() {
+		log.WithFields(log.Fields{
+			"list":     rw.list,
+			"revision": rw.revision,
+		}).Info("Reconnected watch")
+	}
+}
+
+// forward passes events from the underlying watcher to the consumer, tracking the revision of
+// each.  Returns true if the underlying watch failed and we should reconnect, or false if the
+// watcher should terminate.
+func (rw *reconnectingWatcher) forward() bool {
+	// passedError is set if the last event was an error that we passed on, in which case the
+	// underlying watcher may have terminated because of it.
+	passedError := false
+	for {
+		select {
+		case e, ok := <-rw.inner.ResultChan():
+			if !ok {
+				if rw.ctx.Err() != nil || passedError {
+					return false
+				}
+				log.WithField("list", rw.list).Info("Watch closed by the datastore, reconnecting")
+				return true
+			}
+			if e.Type == bapi.WatchError && isTransientWatchError(e.Error) {
+				if rw.ctx.Err() != nil {
+					// The error was caused by our own context, e.g. its deadline passing.
+					return false
+				}
+				log.WithError(e.Error).WithField("list", rw.list).Info("Transient watch error, reconnecting")
+				return true
+			}
+			passedError = e.Type == bapi.WatchError
+			if rev := backendEventRevision(e); rev != "" {
+				rw.revision = rev
+			}
+			if !rw.send(e) {
+				return false
+			}
+		case <-rw.ctx.Done():
+			return false
+		}
+	}
+}
+
+// reconnect replaces the underlying watcher with a new watch from the last revision, retrying
+// with backoff while that fails with a transient error.  Returns false if the watcher should
+// terminate.
+func (rw *reconnectingWatcher) reconnect() bool {
+	rw.inner.Stop()
+	backoff := reconnectMinBackoff
+	for {
+		inner, err := rw.backend.Watch(rw.ctx, rw.list, rw.revision)
+		if err == nil {
+			rw.inner = inner
+			return true
+		}
+		if rw.ctx.Err() != nil {
+			return false
+		}
+		if !isTransientWatchError(err) {
+			rw.send(bapi.WatchEvent{Type: bapi.WatchError, Error: err})
+			return false
+		}
+		log.WithError(err).WithField("backoff", backoff).Info("Failed to reconnect watch, retrying")
+		select {
+		case <-time.After(backoff):
+		case <-rw.ctx.Done():
+			return false
+		}
+		if backoff *= 2; backoff > reconnectMaxBackoff {
+			backoff = reconnectMaxBackoff
+		}
+	}
+}
+
+// send delivers the event to the consumer.  Returns false if the watcher was stopped first.
+func (rw *reconnectingWatcher) send(e bapi.WatchEvent) bool {
+	select {
+	case rw.results <- e:
+		return true
+	case <-rw.ctx.Done():
+		return false
+	}
+}
+
+// backendEventRevision returns the revision of a backend watch event, or "" if it has none.
+func backendEventRevision(e bapi.WatchEvent) string {
+	switch {
+	case e.Type == bapi.WatchBookmark:
+		return e.Revision
+	case e.New != nil:
+		return e.New.Revision
+	case e.Old != nil:
+		return e.Old.Revision
+	}
+	return ""
+}
+
+// isTransientWatchError returns true if the watch error is caused by a problem with the
+// connection to the datastore, so that the watch can be re-established from the same revision.
+// A revision that is no longer available is not transient.
+func isTransientWatchError(err error) bool {
+	if err == nil || isWatchCursorTooOld(err) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) || utilnet.IsProbableEOF(err) ||
+		utilnet.IsConnectionReset(err) || utilnet.IsConnectionRefused(err) {
+		return true
+	}
+	if kerrors.IsServerTimeout(err) || kerrors.IsTimeout(err) || kerrors.IsTooManyRequests(err) ||
+		kerrors.IsServiceUnavailable(err) {
+		return true
+	}
+	var code codes.Code
+	var etcdErr rpctypes.EtcdError
+	if errors.As(err, &etcdErr) {
+		code = etcdErr.Code()
+	} else {
+		code = status.Code(err)
+	}
+	switch code {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Aborted:
+		return true
+	}
+	return false
+}
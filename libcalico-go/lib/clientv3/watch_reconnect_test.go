@@ -0,0 +1,258 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clientv3
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	libapiv3 "github.com/projectcalico/calico/libcalico-go/lib/apis/v3"
+	bapi "github.com/projectcalico/calico/libcalico-go/lib/backend/api"
+	"github.com/projectcalico/calico/libcalico-go/lib/backend/model"
+	"github.com/projectcalico/calico/libcalico-go/lib/errors"
+	"github.com/projectcalico/calico/libcalico-go/lib/options"
+	"github.com/projectcalico/calico/libcalico-go/lib/watch"
+)
+
+var _ = Describe("Reconnecting watch", func() {
+	var (
+		be       *droppingBackend
+		res      *resources
+		watchers []watch.Interface
+	)
+
+	BeforeEach(func() {
+		be = &droppingBackend{}
+		res = &resources{backend: be}
+		watchers = nil
+	})
+
+	AfterEach(func() {
+		for _, w := range watchers {
+			w.Stop()
+		}
+	})
+
+	startWatch := func(opts options.ListOptions) watch.Interface {
+		w, err := res.Watch(context.Background(), opts, libapiv3.KindWorkloadEndpoint, nil)
+		Expect(err).NotTo(HaveOccurred())
+		watchers = append(watchers, w)
+		return w
+	}
+
+	nextEvent := func(w watch.Interface) watch.Event {
+		var e watch.Event
+		EventuallyWithOffset(1, w.ResultChan(), 5*time.Second).Should(Receive(&e))
+		return e
+	}
+
+	expectAdded := func(w watch.Interface, revs ...int) {
+		for _, rev := range revs {
+			e := nextEvent(w)
+			ExpectWithOffset(1, e.Type).To(Equal(watch.Added), fmt.Sprintf("event: %+v", e))
+			ExpectWithOffset(1, e.Object.(*libapiv3.WorkloadEndpoint).ResourceVersion).To(Equal(strconv.Itoa(rev)))
+		}
+	}
+
+	It("should continue delivering events after the connection drops mid-stream", func() {
+		w := startWatch(options.ListOptions{ResourceVersion: "10", Reconnect: true})
+
+		be.Watcher(0).Add(11)
+		be.Watcher(0).Add(12)
+		expectAdded(w, 11, 12)
+
+		By("Dropping the connection with an error")
+		be.Watcher(0).Fail(status.Error(codes.Unavailable, "connection lost"))
+		be.Watcher(1).Add(13)
+		expectAdded(w, 13)
+
+		By("Dropping the connection without an error")
+		be.Watcher(1).Close()
+		be.Watcher(2).Add(14)
+		expectAdded(w, 14)
+
+		Expect(be.Revisions()).To(Equal([]string{"10", "12", "13"}))
+		Consistently(w.ResultChan()).ShouldNot(Receive())
+	})
+
+	It("should retry with backoff while the datastore is unavailable", func() {
+		w := startWatch(options.ListOptions{ResourceVersion: "10", Reconnect: true})
+		be.Watcher(0).Add(11)
+		expectAdded(w, 11)
+
+		be.FailWatches(2, rpctypes.ErrGRPCNoLeader)
+		be.Watcher(0).Fail(io.EOF)
+		be.Watcher(1).Add(12)
+		expectAdded(w, 12)
+		Expect(be.Revisions()).To(Equal([]string{"10", "11", "11", "11"}))
+	})
+
+	It("should send an Error and terminate if the revision has been compacted", func() {
+		w := startWatch(options.ListOptions{ResourceVersion: "10", Reconnect: true})
+		be.Watcher(0).Add(11)
+		expectAdded(w, 11)
+
+		be.Watcher(0).Fail(status.Error(codes.Unavailable, "connection lost"))
+		be.Watcher(1).Fail(rpctypes.ErrCompacted)
+		e := nextEvent(w)
+		Expect(e.Type).To(Equal(watch.Error))
+		Expect(e.Error).To(Equal(rpctypes.ErrCompacted))
+		Eventually(w.ResultChan()).Should(BeClosed())
+		Expect(be.Revisions()).To(Equal([]string{"10", "11"}))
+	})
+
+	It("should pass on other errors without reconnecting", func() {
+		w := startWatch(options.ListOptions{ResourceVersion: "10", Reconnect: true})
+		parseErr := errors.ErrorParsingDatastoreEntry{RawKey: "key", Err: fmt.Errorf("bad value")}
+		be.Watcher(0).Fail(parseErr)
+		e := nextEvent(w)
+		Expect(e.Type).To(Equal(watch.Error))
+		Expect(e.Error).To(Equal(parseErr))
+		Eventually(w.ResultChan()).Should(BeClosed())
+		Expect(be.Revisions()).To(Equal([]string{"10"}))
+	})
+
+	It("should not reconnect unless Reconnect is set", func() {
+		w := startWatch(options.ListOptions{ResourceVersion: "10"})
+		be.Watcher(0).Fail(status.Error(codes.Unavailable, "connection lost"))
+		e := nextEvent(w)
+		Expect(e.Type).To(Equal(watch.Error))
+		Eventually(w.ResultChan()).Should(BeClosed())
+		Expect(be.Revisions()).To(Equal([]string{"10"}))
+	})
+
+	DescribeTable("isTransientWatchError",
+		func(err error, expected bool) {
+			Expect(isTransientWatchError(err)).To(Equal(expected))
+		},
+		Entry("nil", nil, false),
+		Entry("etcd unavailable", status.Error(codes.Unavailable, "unavailable"), true),
+		Entry("etcd no leader", rpctypes.ErrNoLeader, true),
+		Entry("etcd compacted", rpctypes.ErrCompacted, false),
+		Entry("EOF", io.EOF, true),
+		Entry("deadline exceeded", context.DeadlineExceeded, true),
+		Entry("canceled", context.Canceled, false),
+		Entry("Kubernetes server timeout", kerrors.NewServerTimeout(schema.GroupResource{}, "watch", 1), true),
+		Entry("Kubernetes resource expired", kerrors.NewResourceExpired("too old"), false),
+		Entry("Kubernetes gone", kerrors.NewGone("gone"), false),
+		Entry("parse error", errors.ErrorParsingDatastoreEntry{Err: fmt.Errorf("bad value")}, false),
+	)
+})
+
+// droppingBackend is a backend client whose watches are driven by the test, so that it can drop
+// their connections mid-stream.
+type droppingBackend struct {
+	bapi.Client
+	lock      sync.Mutex
+	watchers  []*droppingWatcher
+	revisions []string
+	failures  int
+	failErr   error
+}
+
+func (b *droppingBackend) Watch(ctx context.Context, list model.ListInterface, revision string) (bapi.WatchInterface, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.revisions = append(b.revisions, revision)
+	if b.failures > 0 {
+		b.failures--
+		return nil, b.failErr
+	}
+	w := &droppingWatcher{results: make(chan bapi.WatchEvent, 10)}
+	b.watchers = append(b.watchers, w)
+	return w, nil
+}
+
+// FailWatches makes the next n calls to Watch fail with the error.
+func (b *droppingBackend) FailWatches(n int, err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.failures = n
+	b.failErr = err
+}
+
+// Watcher waits for the i'th watcher to be created and returns it.
+func (b *droppingBackend) Watcher(i int) *droppingWatcher {
+	EventuallyWithOffset(1, func() int {
+		b.lock.Lock()
+		defer b.lock.Unlock()
+		return len(b.watchers)
+	}, 5*time.Second, 10*time.Millisecond).Should(BeNumerically(">", i))
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.watchers[i]
+}
+
+// Revisions returns the revisions that each call to Watch started from.
+func (b *droppingBackend) Revisions() []string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return append([]string(nil), b.revisions...)
+}
+
+// droppingWatcher is the backend watcher returned by droppingBackend.
+type droppingWatcher struct {
+	results chan bapi.WatchEvent
+	once    sync.Once
+}
+
+// Add sends an Added event for a WorkloadEndpoint at the revision.
+func (w *droppingWatcher) Add(rev int) {
+	w.results <- bapi.WatchEvent{
+		Type: bapi.WatchAdded,
+		New: &model.KVPair{
+			Key: model.ResourceKey{Kind: libapiv3.KindWorkloadEndpoint, Namespace: "ns", Name: strconv.Itoa(rev)},
+			Value: &libapiv3.WorkloadEndpoint{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: strconv.Itoa(rev)},
+			},
+			Revision: strconv.Itoa(rev),
+		},
+	}
+}
+
+// Fail sends an Error event and closes the watcher, as the backends do for terminating errors.
+func (w *droppingWatcher) Fail(err error) {
+	w.results <- bapi.WatchEvent{Type: bapi.WatchError, Error: err}
+	w.Close()
+}
+
+// Close closes the watcher, as though the datastore closed the connection.
+func (w *droppingWatcher) Close() {
+	w.once.Do(func() { close(w.results) })
+}
+
+func (w *droppingWatcher) Stop() {}
+
+func (w *droppingWatcher) ResultChan() <-chan bapi.WatchEvent {
+	return w.results
+}
+
+func (w *droppingWatcher) HasTerminated() bool {
+	return false
+}
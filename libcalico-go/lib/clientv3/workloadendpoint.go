@@ -17,13 +17,21 @@ package clientv3
 import (
 	"context"
 	"fmt"
+	"io"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 
 	apiv3 "github.com/projectcalico/api/pkg/apis/projectcalico/v3"
 
 	libapiv3 "github.com/projectcalico/calico/libcalico-go/lib/apis/v3"
+	"github.com/projectcalico/calico/libcalico-go/lib/backend/model"
 	"github.com/projectcalico/calico/libcalico-go/lib/errors"
 	"github.com/projectcalico/calico/libcalico-go/lib/names"
 	"github.com/projectcalico/calico/libcalico-go/lib/options"
+	"github.com/projectcalico/calico/libcalico-go/lib/set"
 	validator "github.com/projectcalico/calico/libcalico-go/lib/validator/v3"
 	"github.com/projectcalico/calico/libcalico-go/lib/watch"
 )
@@ -31,57 +39,108 @@ import (
 // WorkloadEndpointInterface has methods to work with WorkloadEndpoint resources.
 type WorkloadEndpointInterface interface {
 	Create(ctx context.Context, res *libapiv3.WorkloadEndpoint, opts options.SetOptions) (*libapiv3.WorkloadEndpoint, error)
+	CreateMany(ctx context.Context, res []*libapiv3.WorkloadEndpoint, opts options.SetOptions) ([]WorkloadEndpointCreateResult, error)
 	Update(ctx context.Context, res *libapiv3.WorkloadEndpoint, opts options.SetOptions) (*libapiv3.WorkloadEndpoint, error)
+	Patch(ctx context.Context, namespace, name string, pt types.PatchType, data []byte, opts options.PatchOptions) (*libapiv3.WorkloadEndpoint, error)
 	Delete(ctx context.Context, namespace, name string, opts options.DeleteOptions) (*libapiv3.WorkloadEndpoint, error)
 	Get(ctx context.Context, namespace, name string, opts options.GetOptions) (*libapiv3.WorkloadEndpoint, error)
 	List(ctx context.Context, opts options.ListOptions) (*libapiv3.WorkloadEndpointList, error)
 	Watch(ctx context.Context, opts options.ListOptions) (watch.Interface, error)
+	WatchBatched(ctx context.Context, opts options.ListOptions, interval time.Duration) (watch.BatchedInterface, error)
+	LatestResourceVersion(ctx context.Context) (string, error)
+	DiffRevisions(ctx context.Context, namespace, name, rvA, rvB string) (*WorkloadEndpointDiff, error)
+	VerifyCache(ctx context.Context, cache *WorkloadEndpointCache, opts options.ListOptions) (*WorkloadEndpointCacheDivergence, error)
+	DryRunUpdate(ctx context.Context, res *libapiv3.WorkloadEndpoint, opts options.SetOptions) (*libapiv3.WorkloadEndpoint, *WorkloadEndpointDiff, error)
+	DeleteCollection(ctx context.Context, listOpts options.ListOptions, deleteOpts options.DeleteOptions) ([]libapiv3.WorkloadEndpoint, error)
+	DeleteIf(ctx context.Context, namespace, name string, pred func(*libapiv3.WorkloadEndpoint) bool, opts options.DeleteOptions) (*libapiv3.WorkloadEndpoint, error)
+	Reserve(ctx context.Context, namespace, name string, opts options.SetOptions) (*libapiv3.WorkloadEndpoint, error)
+	WatchWithCursor(ctx context.Context, opts options.ListOptions, store WatchCursorStore) (watch.Interface, error)
+	WatchWithRelist(ctx context.Context, opts options.ListOptions) (watch.Interface, error)
+	WatchNamespaceOrdered(ctx context.Context, namespaces []string, opts options.ListOptions, reorderWindow time.Duration) (watch.Interface, error)
+	AddIP(ctx context.Context, namespace, name, ip string, opts options.SetOptions) (*libapiv3.WorkloadEndpoint, error)
+	RemoveIP(ctx context.Context, namespace, name, ip string, opts options.SetOptions) (*libapiv3.WorkloadEndpoint, error)
+	SetLabelIfAbsent(ctx context.Context, namespace, name, key, value string, opts options.SetOptions) (*libapiv3.WorkloadEndpoint, bool, error)
+	ClearLabel(ctx context.Context, namespace, name, key, expectedValue string, opts options.SetOptions) (*libapiv3.WorkloadEndpoint, bool, error)
+	SetAnnotation(ctx context.Context, namespace, name, key, value string, opts options.SetOptions) (*libapiv3.WorkloadEndpoint, error)
+	GetAnnotation(ctx context.Context, namespace, name, key string) (string, bool, error)
+	RemoveAnnotation(ctx context.Context, namespace, name, key string, opts options.SetOptions) (*libapiv3.WorkloadEndpoint, error)
+	FindDangling(ctx context.Context, isAlive func(names.WorkloadEndpointIdentifiers) bool, opts options.ListOptions) ([]*libapiv3.WorkloadEndpoint, error)
+	ExportAll(ctx context.Context, w io.Writer) error
+	ImportAll(ctx context.Context, r io.Reader, opts WorkloadEndpointImportOptions) ([]WorkloadEndpointImportResult, error)
+	Migrate(ctx context.Context, opts WorkloadEndpointMigrateOptions) ([]WorkloadEndpointMigrateResult, error)
 }
 
+const (
+	// DefaultReservationTTL is the time after which a reserved WorkloadEndpoint expires if it
+	// has not been finalized and no TTL was specified.
+	DefaultReservationTTL = 60 * time.Second
+
+	// reservedInterfaceName is the placeholder interface name of a reserved WorkloadEndpoint.
+	reservedInterfaceName = "reserved"
+)
+
 // workloadEndpoints implements WorkloadEndpointInterface
 type workloadEndpoints struct {
 	client client
 }
 
 // Create takes the representation of a WorkloadEndpoint and creates it.  Returns the stored
-// representation of the WorkloadEndpoint, and an error, if there is any.
+// representation of the WorkloadEndpoint, and an error, if there is any.  If opts.DryRun is set,
+// nothing is written and the WorkloadEndpoint that would be stored is returned.
 func (r workloadEndpoints) Create(ctx context.Context, res *libapiv3.WorkloadEndpoint, opts options.SetOptions) (*libapiv3.WorkloadEndpoint, error) {
-	if res != nil {
-		// Since we're about to default some fields, take a (shallow) copy of the input data
-		// before we do so.
-		resCopy := *res
-		res = &resCopy
+	if opts.DryRun {
+		return r.dryRunCreate(ctx, res, opts)
 	}
-	if err := r.assignOrValidateName(res); err != nil {
+	var warnings wepWarnings
+	res, err := r.prepareCreate(res, opts, &warnings)
+	if err != nil {
 		return nil, err
-	} else if err := validator.Validate(res); err != nil {
+	}
+	if err := r.client.wepWriteLimiter.wait(ctx); err != nil {
 		return nil, err
 	}
-	r.updateLabelsForStorage(res)
 	out, err := r.client.resources.Create(ctx, opts, libapiv3.KindWorkloadEndpoint, res)
 	if out != nil {
+		if err == nil {
+			warnings.deliver(opts)
+			r.runCreateHooks(ctx, out.(*libapiv3.WorkloadEndpoint))
+		}
 		return out.(*libapiv3.WorkloadEndpoint), err
 	}
 	return nil, err
 }
 
 // Update takes the representation of a WorkloadEndpoint and updates it. Returns the stored
-// representation of the WorkloadEndpoint, and an error, if there is any.
+// representation of the WorkloadEndpoint, and an error, if there is any.  If opts.DryRun is set,
+// nothing is written and the WorkloadEndpoint that would be stored is returned; see DryRunUpdate.
 func (r workloadEndpoints) Update(ctx context.Context, res *libapiv3.WorkloadEndpoint, opts options.SetOptions) (*libapiv3.WorkloadEndpoint, error) {
-	if res != nil {
-		// Since we're about to default some fields, take a (shallow) copy of the input data
-		// before we do so.
-		resCopy := *res
-		res = &resCopy
+	if opts.DryRun {
+		out, _, err := r.DryRunUpdate(ctx, res, opts)
+		return out, err
 	}
-	if err := r.assignOrValidateName(res); err != nil {
+	var warnings wepWarnings
+	res, err := r.prepareUpdate(res, opts, &warnings)
+	if err != nil {
 		return nil, err
-	} else if err := validator.Validate(res); err != nil {
+	}
+	if err := r.client.wepWriteLimiter.wait(ctx); err != nil {
+		return nil, err
+	}
+	// The stored WorkloadEndpoint is also the "before" state for the hooks; the update is
+	// conditional on its revision so it can't have changed in between.
+	before, err := r.getForUpdateHooks(ctx, res)
+	if err != nil {
 		return nil, err
 	}
-	r.updateLabelsForStorage(res)
 	out, err := r.client.resources.Update(ctx, opts, libapiv3.KindWorkloadEndpoint, res)
 	if out != nil {
+		if err == nil {
+			checkCreationTimestampKept(res, out.(*libapiv3.WorkloadEndpoint), &warnings)
+			warnings.deliver(opts)
+		}
+		if err == nil && before != nil {
+			r.runUpdateHooks(ctx, before, out.(*libapiv3.WorkloadEndpoint))
+		}
 		return out.(*libapiv3.WorkloadEndpoint), err
 	}
 	return nil, err
@@ -89,8 +148,14 @@ func (r workloadEndpoints) Update(ctx context.Context, res *libapiv3.WorkloadEnd
 
 // Delete takes name of the WorkloadEndpoint and deletes it. Returns an error if one occurs.
 func (r workloadEndpoints) Delete(ctx context.Context, namespace, name string, opts options.DeleteOptions) (*libapiv3.WorkloadEndpoint, error) {
+	if err := r.client.wepWriteLimiter.wait(ctx); err != nil {
+		return nil, err
+	}
 	out, err := r.client.resources.Delete(ctx, opts, libapiv3.KindWorkloadEndpoint, namespace, name)
 	if out != nil {
+		if err == nil {
+			r.runDeleteHooks(ctx, out.(*libapiv3.WorkloadEndpoint))
+		}
 		return out.(*libapiv3.WorkloadEndpoint), err
 	}
 	return nil, err
@@ -99,6 +164,9 @@ func (r workloadEndpoints) Delete(ctx context.Context, namespace, name string, o
 // Get takes name of the WorkloadEndpoint, and returns the corresponding WorkloadEndpoint object,
 // and an error if there is any.
 func (r workloadEndpoints) Get(ctx context.Context, namespace, name string, opts options.GetOptions) (*libapiv3.WorkloadEndpoint, error) {
+	if err := r.client.wepReadLimiter.wait(ctx); err != nil {
+		return nil, err
+	}
 	out, err := r.client.resources.Get(ctx, opts, libapiv3.KindWorkloadEndpoint, namespace, name)
 	if out != nil {
 		return out.(*libapiv3.WorkloadEndpoint), err
@@ -108,32 +176,326 @@ func (r workloadEndpoints) Get(ctx context.Context, namespace, name string, opts
 
 // List returns the list of WorkloadEndpoint objects that match the supplied options.
 func (r workloadEndpoints) List(ctx context.Context, opts options.ListOptions) (*libapiv3.WorkloadEndpointList, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	listOpts := opts
+	listOpts.Names = nil
+	if len(opts.Projection) > 0 {
+		// Check the fields up front, and also ask for the fields that we filter by below.
+		if _, err := projectWorkloadEndpoint(&libapiv3.WorkloadEndpoint{}, opts.Projection); err != nil {
+			return nil, err
+		}
+		listOpts.Projection = append([]string(nil), opts.Projection...)
+		if len(opts.Names) > 0 {
+			listOpts.Projection = append(listOpts.Projection, "Name")
+		}
+		if opts.Reserved != options.ReservedInclude {
+			listOpts.Projection = append(listOpts.Projection, "Annotations")
+		}
+	}
+	var token *listContinueToken
+	if opts.Continue != "" {
+		t, err := decodeListContinueToken(opts.Continue)
+		if err != nil {
+			return nil, err
+		}
+		token = &t
+		listOpts.ResourceVersion = t.ResourceVersion
+		listOpts.Continue = t.Continue
+	}
+	// Check the context before waiting for the rate limiter, which would otherwise use up a
+	// token for a List that cannot be made.
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := r.client.wepReadLimiter.wait(ctx); err != nil {
+		return nil, err
+	}
 	res := &libapiv3.WorkloadEndpointList{}
-	if err := r.client.resources.List(ctx, opts, libapiv3.KindWorkloadEndpoint, libapiv3.KindWorkloadEndpointList, res); err != nil {
+	if err := r.client.resources.list(ctx, listOpts, libapiv3.KindWorkloadEndpoint, libapiv3.KindWorkloadEndpointList, res); err != nil {
 		return nil, err
 	}
+	if len(opts.Names) > 0 {
+		names := set.FromArray(opts.Names)
+		filtered := res.Items[:0]
+		for _, wep := range res.Items {
+			if names.Contains(wep.Name) {
+				filtered = append(filtered, wep)
+			}
+		}
+		res.Items = filtered
+	}
+	if opts.Reserved != options.ReservedInclude {
+		filtered := res.Items[:0]
+		for _, wep := range res.Items {
+			if IsReserved(&wep) == (opts.Reserved == options.ReservedOnly) {
+				filtered = append(filtered, wep)
+			}
+		}
+		res.Items = filtered
+	}
+	if res.Continue != "" {
+		rev := res.ResourceVersion
+		if token != nil {
+			rev = token.ResourceVersion
+		}
+		res.Continue = listContinueToken{ResourceVersion: rev, Continue: res.Continue}.encode()
+		if len(opts.Names) > 0 || opts.Reserved != options.ReservedInclude {
+			// The backend's count doesn't account for our filtering.
+			res.RemainingItemCount = nil
+		}
+	}
+	if len(opts.Projection) > 0 {
+		for i := range res.Items {
+			projected, err := projectWorkloadEndpoint(&res.Items[i], opts.Projection)
+			if err != nil {
+				return nil, err
+			}
+			res.Items[i] = *projected
+		}
+	}
 	return res, nil
 }
 
 // Watch returns a watch.Interface that watches the NetworkPolicies that match the
-// supplied options.
+// supplied options.  If opts.Names is set, only the events for WorkloadEndpoints with those
+// names are delivered.
 func (r workloadEndpoints) Watch(ctx context.Context, opts options.ListOptions) (watch.Interface, error) {
-	return r.client.resources.Watch(ctx, opts, libapiv3.KindWorkloadEndpoint, nil)
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	converter, err := newProjectionConverter(opts.WatchProjection)
+	if err != nil {
+		return nil, err
+	}
+	if len(opts.Names) > 0 {
+		return r.watchNames(ctx, opts, converter)
+	}
+	return r.client.resources.watch(ctx, opts, libapiv3.KindWorkloadEndpoint, converter)
+}
+
+// WatchBatched returns a watch.BatchedInterface that watches the WorkloadEndpoints that match the
+// supplied options, delivering the events received during each interval as a single batch.
+func (r workloadEndpoints) WatchBatched(ctx context.Context, opts options.ListOptions, interval time.Duration) (watch.BatchedInterface, error) {
+	w, err := r.Watch(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return watch.NewBatched(w, interval), nil
+}
+
+// Reserve claims the WorkloadEndpoint name by creating a placeholder WorkloadEndpoint, with the
+// primary identifiers parsed from the name and the AnnotationReserved annotation set.  The
+// reservation is finalized by updating the WorkloadEndpoint with its real spec.  A reservation
+// that is not finalized expires after the TTL in the options, or DefaultReservationTTL if that
+// is not set.  Expiry relies on the datastore supporting TTLs.
+func (r workloadEndpoints) Reserve(ctx context.Context, namespace, name string, opts options.SetOptions) (*libapiv3.WorkloadEndpoint, error) {
+	wepids, err := names.ParseWorkloadEndpointName(name)
+	if err != nil {
+		return nil, errors.ErrorValidation{
+			ErroredFields: []errors.ErroredField{{
+				Name:   "Name",
+				Value:  name,
+				Reason: fmt.Sprintf("the WorkloadEndpoint name cannot be reserved: %v", err),
+			}},
+		}
+	}
+	if opts.TTL == 0 {
+		opts.TTL = DefaultReservationTTL
+	}
+	log.WithFields(log.Fields{
+		"namespace": namespace,
+		"name":      name,
+		"ttl":       opts.TTL,
+	}).Debug("Reserving WorkloadEndpoint name")
+	return r.Create(ctx, &libapiv3.WorkloadEndpoint{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   namespace,
+			Name:        name,
+			Annotations: map[string]string{libapiv3.AnnotationReserved: "true"},
+		},
+		Spec: libapiv3.WorkloadEndpointSpec{
+			Node:          wepids.Node,
+			Orchestrator:  wepids.Orchestrator,
+			Workload:      wepids.Workload,
+			Pod:           wepids.Pod,
+			ContainerID:   wepids.ContainerID,
+			Endpoint:      wepids.Endpoint,
+			InterfaceName: reservedInterfaceName,
+		},
+	}, opts)
+}
+
+// IsReserved returns true if the WorkloadEndpoint is a reservation that has not been finalized.
+func IsReserved(wep *libapiv3.WorkloadEndpoint) bool {
+	_, ok := wep.Annotations[libapiv3.AnnotationReserved]
+	return ok
+}
+
+// finalizeReservation removes the reserved annotation, if present, so that an Update finalizes
+// a reserved WorkloadEndpoint.  Since the Update is made without the reservation's TTL, the
+// WorkloadEndpoint no longer expires.
+func (r workloadEndpoints) finalizeReservation(res *libapiv3.WorkloadEndpoint) {
+	if !IsReserved(res) {
+		return
+	}
+	log.WithFields(log.Fields{
+		"namespace": res.Namespace,
+		"name":      res.Name,
+	}).Debug("Finalizing reserved WorkloadEndpoint")
+	annotations := map[string]string{}
+	for k, v := range res.Annotations {
+		if k != libapiv3.AnnotationReserved {
+			annotations[k] = v
+		}
+	}
+	res.Annotations = annotations
+}
+
+// prepareCreate validates a WorkloadEndpoint that is about to be created, adding any warnings
+// about it to warnings, and returns a copy of
+// it, defaulted for storage.  If the WorkloadEndpoint has no interface name, the copy is given
+// the DefaultInterfaceName.  A name that does not match the Spec is rejected unless
+// opts.AllowLegacyName is set.
+func (r workloadEndpoints) prepareCreate(res *libapiv3.WorkloadEndpoint, opts options.SetOptions, warnings *wepWarnings) (*libapiv3.WorkloadEndpoint, error) {
+	if res != nil {
+		// Since we're about to default some fields, take a (shallow) copy of the input data
+		// before we do so.
+		resCopy := *res
+		res = &resCopy
+	}
+	if err := r.assignOrValidateName(res, opts.AllowLegacyName, warnings); err != nil {
+		return nil, err
+	}
+	defaultInterfaceName(res)
+	if err := validator.Validate(res); err != nil {
+		return nil, err
+	}
+	checkWarnings(res, warnings)
+	r.updateLabelsForStorage(res)
+	// The creation timestamp is always assigned by the datastore client, rather than trusting
+	// the caller, so that it can be relied upon for ordering.
+	if !res.CreationTimestamp.IsZero() {
+		warnings.add("Metadata.CreationTimestamp is assigned by the datastore, the supplied value was ignored")
+	}
+	res.CreationTimestamp = metav1.Time{}
+	return res, nil
+}
+
+// prepareUpdate is as prepareCreate, for a WorkloadEndpoint that is about to be updated.  A name
+// that does not match the Spec is also allowed if the client was created WithLegacyNames.
+func (r workloadEndpoints) prepareUpdate(res *libapiv3.WorkloadEndpoint, opts options.SetOptions, warnings *wepWarnings) (*libapiv3.WorkloadEndpoint, error) {
+	if res != nil {
+		// Since we're about to default some fields, take a (shallow) copy of the input data
+		// before we do so.
+		resCopy := *res
+		res = &resCopy
+	}
+	if err := r.assignOrValidateName(res, r.client.legacyNames || opts.AllowLegacyName, warnings); err != nil {
+		return nil, err
+	} else if err := validator.Validate(res); err != nil {
+		return nil, err
+	}
+	checkWarnings(res, warnings)
+	r.updateLabelsForStorage(res)
+	r.finalizeReservation(res)
+	return res, nil
+}
+
+// getForUpdateHooks gets the stored WorkloadEndpoint that res updates, to pass to the update
+// hooks as the "before" state.  Returns nil (and no error) if there are no hooks, so that an
+// Update without hooks is a single write, or if there is no stored WorkloadEndpoint, in which case
+// the update fails as usual.
+func (r workloadEndpoints) getForUpdateHooks(ctx context.Context, res *libapiv3.WorkloadEndpoint) (*libapiv3.WorkloadEndpoint, error) {
+	if len(r.client.wepHooks) == 0 {
+		return nil, nil
+	}
+	stored, err := r.Get(ctx, res.Namespace, res.Name, options.GetOptions{Consistent: true})
+	if _, ok := err.(errors.ErrorResourceDoesNotExist); ok {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return stored, nil
+}
+
+// checkCreationTimestampKept adds a warning if an update of res supplied a creation timestamp
+// and a different one was stored.  The timestamp is assigned on Create and can't be changed, so
+// the datastore keeps the stored one: the etcdv3 backend copies it into the update, and in KDD it
+// is owned by the API server.
+func checkCreationTimestampKept(res, out *libapiv3.WorkloadEndpoint, warnings *wepWarnings) {
+	if res.CreationTimestamp.IsZero() || out.CreationTimestamp.Equal(&res.CreationTimestamp) {
+		return
+	}
+	log.WithFields(log.Fields{
+		"namespace": res.Namespace,
+		"name":      res.Name,
+		"requested": res.CreationTimestamp,
+		"stored":    out.CreationTimestamp,
+	}).Debug("Ignored change to WorkloadEndpoint creation timestamp")
+	warnings.add("Metadata.CreationTimestamp can't be changed, the supplied value was ignored")
+}
+
+// projectWorkloadEndpoint returns a copy of the WorkloadEndpoint with only the TypeMeta and the
+// given metadata and spec fields populated.
+func projectWorkloadEndpoint(wep *libapiv3.WorkloadEndpoint, fields []string) (*libapiv3.WorkloadEndpoint, error) {
+	projected, err := model.ProjectResource(wep, fields)
+	if err != nil {
+		return nil, err
+	}
+	return projected.(*libapiv3.WorkloadEndpoint), nil
+}
+
+// projectionConverter trims the WorkloadEndpoints in watch events to the given fields; see
+// options.ListOptions.WatchProjection.
+type projectionConverter struct {
+	fields []string
+}
+
+// newProjectionConverter returns a converter that trims watch events to the given fields, or nil
+// if there are none.
+func newProjectionConverter(fields []string) (watcherConverter, error) {
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	// Check the fields up front, so that the conversion of each event can't fail.
+	if _, err := projectWorkloadEndpoint(&libapiv3.WorkloadEndpoint{}, fields); err != nil {
+		return nil, err
+	}
+	return projectionConverter{fields: fields}, nil
+}
+
+func (pc projectionConverter) Convert(r resource) resource {
+	projected, err := projectWorkloadEndpoint(r.(*libapiv3.WorkloadEndpoint), pc.fields)
+	if err != nil {
+		log.WithError(err).Panic("Failed to project WorkloadEndpoint")
+	}
+	return projected
+}
+
+// rejectWatchProjection returns an error if the WatchProjection option is set, for the watches
+// that need the fields of each event.
+func rejectWatchProjection(opts options.ListOptions, operation string) error {
+	if len(opts.WatchProjection) == 0 {
+		return nil
+	}
+	return errors.ErrorValidation{
+		ErroredFields: []errors.ErroredField{{
+			Name:   "WatchProjection",
+			Value:  opts.WatchProjection,
+			Reason: fmt.Sprintf("not supported by %s", operation),
+		}},
+	}
 }
 
 // assignOrValidateName either assigns the name calculated from the Spec fields, or validates
-// the name against the spec fields.
-func (r workloadEndpoints) assignOrValidateName(res *libapiv3.WorkloadEndpoint) error {
+// the name against the spec fields.  If allowLegacy is true, a name that does not match the
+// spec fields is tolerated, with a warning, since it may have been constructed using a legacy
+// name format.
+func (r workloadEndpoints) assignOrValidateName(res *libapiv3.WorkloadEndpoint, allowLegacy bool, warnings *wepWarnings) error {
 	// Validate the workload endpoint indices and the name match.
-	wepids := names.WorkloadEndpointIdentifiers{
-		Node:         res.Spec.Node,
-		Orchestrator: res.Spec.Orchestrator,
-		Endpoint:     res.Spec.Endpoint,
-		Workload:     res.Spec.Workload,
-		Pod:          res.Spec.Pod,
-		ContainerID:  res.Spec.ContainerID,
-	}
-	expectedName, err := wepids.CalculateWorkloadEndpointName(false)
+	expectedName, err := libapiv3.BuildWorkloadEndpointName(res.Spec)
 	if err != nil {
 		return err
 	}
@@ -143,6 +505,14 @@ func (r workloadEndpoints) assignOrValidateName(res *libapiv3.WorkloadEndpoint)
 		return nil
 	}
 	if res.Name != expectedName {
+		if allowLegacy {
+			log.WithFields(log.Fields{
+				"name":         res.Name,
+				"expectedName": expectedName,
+			}).Debug("Allowing WorkloadEndpoint with legacy name format")
+			warnings.add("the name %s does not match the primary identifiers in the Spec, the canonical name is %s", res.Name, expectedName)
+			return nil
+		}
 		return errors.ErrorValidation{
 			ErroredFields: []errors.ErroredField{{
 				Name:   "Name",
@@ -0,0 +1,97 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clientv3
+
+import (
+	"context"
+	"strings"
+
+	k8svalidation "k8s.io/apimachinery/pkg/util/validation"
+
+	libapiv3 "github.com/projectcalico/calico/libcalico-go/lib/apis/v3"
+	"github.com/projectcalico/calico/libcalico-go/lib/errors"
+	"github.com/projectcalico/calico/libcalico-go/lib/options"
+)
+
+// SetAnnotation sets the annotation on the WorkloadEndpoint, overwriting any existing value.
+// Only the annotation is modified, using an update that is conditional on the revision that was
+// read, so concurrent changes to other annotations, labels or the spec are not lost.  The key
+// must be a valid Kubernetes annotation key; the total size of the annotations is validated by
+// the update.  Returns the resulting WorkloadEndpoint.
+func (r workloadEndpoints) SetAnnotation(ctx context.Context, namespace, name, key, value string, opts options.SetOptions) (*libapiv3.WorkloadEndpoint, error) {
+	if err := validateAnnotationKey(key); err != nil {
+		return nil, err
+	}
+	wep, _, err := r.modifyAnnotations(ctx, namespace, name, opts, func(annotations map[string]string) (bool, bool) {
+		if current, ok := annotations[key]; ok && current == value {
+			return true, false
+		}
+		annotations[key] = value
+		return true, true
+	})
+	return wep, err
+}
+
+// GetAnnotation returns the value of the annotation on the WorkloadEndpoint, read from the
+// primary datastore, and whether the annotation is set.
+func (r workloadEndpoints) GetAnnotation(ctx context.Context, namespace, name, key string) (string, bool, error) {
+	wep, err := r.Get(ctx, namespace, name, options.GetOptions{Consistent: true})
+	if err != nil {
+		return "", false, err
+	}
+	value, ok := wep.Annotations[key]
+	return value, ok, nil
+}
+
+// RemoveAnnotation removes the annotation from the WorkloadEndpoint, if it is set.  As with
+// SetAnnotation, only the annotation is modified.  Returns the resulting WorkloadEndpoint.
+func (r workloadEndpoints) RemoveAnnotation(ctx context.Context, namespace, name, key string, opts options.SetOptions) (*libapiv3.WorkloadEndpoint, error) {
+	if err := validateAnnotationKey(key); err != nil {
+		return nil, err
+	}
+	wep, _, err := r.modifyAnnotations(ctx, namespace, name, opts, func(annotations map[string]string) (bool, bool) {
+		if _, ok := annotations[key]; !ok {
+			return true, false
+		}
+		delete(annotations, key)
+		return true, true
+	})
+	return wep, err
+}
+
+// modifyAnnotations is as modifyLabels, for the WorkloadEndpoint's annotations.
+func (r workloadEndpoints) modifyAnnotations(
+	ctx context.Context, namespace, name string, opts options.SetOptions,
+	modify func(annotations map[string]string) (applied, changed bool),
+) (*libapiv3.WorkloadEndpoint, bool, error) {
+	return r.modifyMetadataMap(ctx, namespace, name, opts, "annotations",
+		func(wep *libapiv3.WorkloadEndpoint) *map[string]string { return &wep.Annotations }, modify)
+}
+
+// validateAnnotationKey checks that the key is a valid annotation key, using the same rules as
+// the resource validator.
+func validateAnnotationKey(key string) error {
+	errs := k8svalidation.IsQualifiedName(strings.ToLower(key))
+	if len(errs) == 0 {
+		return nil
+	}
+	return errors.ErrorValidation{
+		ErroredFields: []errors.ErroredField{{
+			Name:   "Annotations",
+			Value:  key,
+			Reason: strings.Join(errs, "; "),
+		}},
+	}
+}
@@ -0,0 +1,159 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clientv3
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	bapi "github.com/projectcalico/calico/libcalico-go/lib/backend/api"
+	"github.com/projectcalico/calico/libcalico-go/lib/backend/model"
+	"github.com/projectcalico/calico/libcalico-go/lib/errors"
+	"github.com/projectcalico/calico/libcalico-go/lib/options"
+	"github.com/projectcalico/calico/libcalico-go/lib/watch"
+)
+
+var _ = Describe("WorkloadEndpoint List and Watch context handling", func() {
+	var (
+		be   *hungBackend
+		weps workloadEndpoints
+	)
+
+	BeforeEach(func() {
+		be = &hungBackend{}
+		weps = workloadEndpoints{client: client{resources: &resources{backend: be}}}
+	})
+
+	cancelled := func() context.Context {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		return ctx
+	}
+
+	expired := func() (context.Context, context.CancelFunc) {
+		return context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	}
+
+	It("should return the context error from List without calling the backend", func() {
+		ctx, cancel := expired()
+		defer cancel()
+		start := time.Now()
+		_, err := weps.List(cancelled(), options.ListOptions{})
+		Expect(err).To(Equal(context.Canceled))
+		_, err = weps.List(ctx, options.ListOptions{})
+		Expect(err).To(Equal(context.DeadlineExceeded))
+		Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+		Expect(be.Calls()).To(BeZero())
+	})
+
+	It("should return the context error from Watch without calling the backend", func() {
+		ctx, cancel := expired()
+		defer cancel()
+		start := time.Now()
+		_, err := weps.Watch(cancelled(), options.ListOptions{})
+		Expect(err).To(Equal(context.Canceled))
+		_, err = weps.Watch(ctx, options.ListOptions{Names: []string{"a", "b"}})
+		Expect(err).To(Equal(context.DeadlineExceeded))
+		Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+		Expect(be.Calls()).To(BeZero())
+	})
+
+	It("should return DeadlineExceeded, not the backend's error, when a List times out", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		_, err := weps.List(ctx, options.ListOptions{})
+		Expect(err).To(Equal(context.DeadlineExceeded))
+		Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+		Expect(be.Calls()).To(Equal(1))
+	})
+
+	It("should send a DeadlineExceeded Error when a Watch times out", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		w, err := weps.Watch(ctx, options.ListOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		var e watch.Event
+		Eventually(w.ResultChan(), time.Second).Should(Receive(&e))
+		Expect(e.Type).To(Equal(watch.Error))
+		Expect(e.Error).To(Equal(context.DeadlineExceeded))
+		Eventually(w.ResultChan()).Should(BeClosed())
+	})
+
+	It("should make room for the DeadlineExceeded Error when the buffer is full", func() {
+		ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+		defer cancel()
+		w := &watcher{context: ctx, results: make(chan watch.Event, 2)}
+		w.results <- watch.Event{Type: watch.Added}
+		w.results <- watch.Event{Type: watch.Modified}
+		w.sendDeadlineExceeded()
+		Expect(<-w.results).To(Equal(watch.Event{Type: watch.Modified}))
+		Expect(<-w.results).To(Equal(watch.Event{Type: watch.Error, Error: context.DeadlineExceeded}))
+	})
+
+	It("should not send an Error when a Watch is stopped", func() {
+		w, err := weps.Watch(context.Background(), options.ListOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		w.Stop()
+		Eventually(w.ResultChan()).Should(BeClosed())
+	})
+})
+
+// hungBackend is a backend client for a datastore that never responds: its List blocks until the
+// context is done and then fails with a timeout of its own, and its watches never send an event.
+type hungBackend struct {
+	bapi.Client
+	calls int32
+}
+
+func (b *hungBackend) List(ctx context.Context, list model.ListInterface, revision string) (*model.KVPairList, error) {
+	atomic.AddInt32(&b.calls, 1)
+	<-ctx.Done()
+	return nil, errors.ErrorDatastoreError{Err: fmt.Errorf("etcdserver: request timed out")}
+}
+
+func (b *hungBackend) Watch(ctx context.Context, list model.ListInterface, revision string) (bapi.WatchInterface, error) {
+	atomic.AddInt32(&b.calls, 1)
+	w := &hungWatcher{results: make(chan bapi.WatchEvent)}
+	go func() {
+		<-ctx.Done()
+		close(w.results)
+	}()
+	return w, nil
+}
+
+func (b *hungBackend) Calls() int {
+	return int(atomic.LoadInt32(&b.calls))
+}
+
+// hungWatcher is the backend watcher returned by hungBackend.
+type hungWatcher struct {
+	results chan bapi.WatchEvent
+}
+
+func (w *hungWatcher) Stop() {}
+
+func (w *hungWatcher) ResultChan() <-chan bapi.WatchEvent {
+	return w.results
+}
+
+func (w *hungWatcher) HasTerminated() bool {
+	return false
+}
@@ -0,0 +1,180 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clientv3
+
+import (
+	"context"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+
+	libapiv3 "github.com/projectcalico/calico/libcalico-go/lib/apis/v3"
+	bapi "github.com/projectcalico/calico/libcalico-go/lib/backend/api"
+	"github.com/projectcalico/calico/libcalico-go/lib/backend/model"
+	"github.com/projectcalico/calico/libcalico-go/lib/errors"
+	"github.com/projectcalico/calico/libcalico-go/lib/options"
+)
+
+// createManyBatchSize is the maximum number of WorkloadEndpoints that CreateMany creates in a
+// single transaction.  etcd limits a transaction to 128 operations by default.
+const createManyBatchSize = 100
+
+// WorkloadEndpointCreateResult is the result of creating one of the WorkloadEndpoints passed to
+// CreateMany.
+type WorkloadEndpointCreateResult struct {
+	// Object is the stored WorkloadEndpoint, or nil if it was not created.
+	Object *libapiv3.WorkloadEndpoint
+	// Error is the reason that the WorkloadEndpoint was not created.
+	Error error
+}
+
+// CreateMany creates each of the WorkloadEndpoints, as Create does, in as few datastore round
+// trips as possible.  It returns a result for each WorkloadEndpoint, in the order they were
+// passed.
+//
+// If the datastore supports transactions, the WorkloadEndpoints are created in batches of at most
+// createManyBatchSize, each in a single transaction.  If one of the batch can't be created (for
+// example, because it already exists), it is removed from the batch and the rest of the batch is
+// retried.  If a batch fails for another reason, or the datastore doesn't support transactions,
+// or opts.DryRun is set, the WorkloadEndpoints are created one at a time.
+//
+// The create is best-effort: a WorkloadEndpoint that can't be created doesn't stop the others
+// from being created, and those that were created are not removed.  If any WorkloadEndpoint
+// wasn't created, an errors.ErrorAggregate holding the error for each is returned along with the
+// results.  A WorkloadEndpoint with the same namespace and name as an earlier one in the input
+// fails with ErrorResourceAlreadyExists.
+func (r workloadEndpoints) CreateMany(
+	ctx context.Context, in []*libapiv3.WorkloadEndpoint, opts options.SetOptions,
+) ([]WorkloadEndpointCreateResult, error) {
+	results := make([]WorkloadEndpointCreateResult, len(in))
+
+	// Check all of the WorkloadEndpoints up front, so that an invalid one doesn't cost a
+	// transaction.
+	var pending []int
+	prepared := make([]*libapiv3.WorkloadEndpoint, len(in))
+	warnings := make([]wepWarnings, len(in))
+	seen := map[string]int{}
+	for i, wep := range in {
+		if wep == nil {
+			results[i].Error = errors.ErrorValidation{
+				ErroredFields: []errors.ErroredField{{Name: "Resource", Reason: "resource must not be nil"}},
+			}
+			continue
+		}
+		var err error
+		if prepared[i], err = r.prepareCreate(wep, opts, &warnings[i]); err != nil {
+			results[i].Error = err
+			continue
+		}
+		key := relistKey(prepared[i])
+		if j, ok := seen[key]; ok {
+			results[i].Error = errors.ErrorResourceAlreadyExists{
+				Err: fmt.Errorf("the WorkloadEndpoint is also at index %d of the input", j),
+				Identifier: model.ResourceKey{
+					Kind:      libapiv3.KindWorkloadEndpoint,
+					Namespace: prepared[i].Namespace,
+					Name:      prepared[i].Name,
+				},
+			}
+			continue
+		}
+		seen[key] = i
+		pending = append(pending, i)
+	}
+
+	batchSize := createManyBatchSize
+	if opts.DryRun || !r.client.Capabilities().Transactions {
+		batchSize = 1
+	}
+	for start := 0; start < len(pending); start += batchSize {
+		end := start + batchSize
+		if end > len(pending) {
+			end = len(pending)
+		}
+		batch := pending[start:end]
+		if batchSize > 1 {
+			batch = r.createBatch(ctx, batch, prepared, warnings, opts, results)
+		}
+		for _, i := range batch {
+			if err := ctx.Err(); err != nil {
+				results[i].Error = err
+				continue
+			}
+			results[i].Object, results[i].Error = r.Create(ctx, in[i], opts)
+		}
+	}
+
+	var errs []error
+	for i := range results {
+		if results[i].Error != nil {
+			results[i].Object = nil
+			errs = append(errs, results[i].Error)
+		}
+	}
+	log.WithFields(log.Fields{
+		"numRequested": len(in),
+		"numCreated":   len(in) - len(errs),
+		"numFailed":    len(errs),
+	}).Info("Created WorkloadEndpoints")
+	if len(errs) > 0 {
+		return results, errors.ErrorAggregate{Errors: errs}
+	}
+	return results, nil
+}
+
+// createBatch creates the prepared WorkloadEndpoints at the given indexes in a single transaction,
+// recording the results.  If a WorkloadEndpoint fails, its error is recorded and the transaction
+// is retried without it.  Returns the indexes of the WorkloadEndpoints that should be created one
+// at a time, because the transaction failed for another reason.
+func (r workloadEndpoints) createBatch(
+	ctx context.Context,
+	batch []int,
+	prepared []*libapiv3.WorkloadEndpoint,
+	warnings []wepWarnings,
+	opts options.SetOptions,
+	results []WorkloadEndpointCreateResult,
+) []int {
+	batch = append([]int(nil), batch...)
+	for len(batch) > 0 {
+		if err := r.client.wepWriteLimiter.wait(ctx); err != nil {
+			return batch
+		}
+		ops := make([]txnOp, len(batch))
+		for j, i := range batch {
+			ops[j] = txnOp{opType: bapi.TxnOpCreate, kind: libapiv3.KindWorkloadEndpoint, res: prepared[i], setOpts: opts}
+		}
+		out, err := r.client.resources.Txn(ctx, ops)
+		if txnErr, ok := err.(errors.ErrorTransactionFailed); ok && txnErr.Index < len(batch) {
+			i := batch[txnErr.Index]
+			log.WithError(txnErr.Err).WithFields(log.Fields{
+				"namespace": prepared[i].Namespace,
+				"name":      prepared[i].Name,
+			}).Debug("Failed to create WorkloadEndpoint, retrying the batch without it")
+			results[i].Error = txnErr.Err
+			batch = append(batch[:txnErr.Index], batch[txnErr.Index+1:]...)
+			continue
+		} else if err != nil {
+			log.WithError(err).Warning("Failed to create batch of WorkloadEndpoints, creating them individually")
+			return batch
+		}
+		for j, i := range batch {
+			results[i].Object = out[j].(*libapiv3.WorkloadEndpoint)
+			warnings[i].deliver(opts)
+			r.runCreateHooks(ctx, results[i].Object)
+		}
+		return nil
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clientv3
+
+import (
+	"context"
+
+	log "github.com/sirupsen/logrus"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+
+	"github.com/projectcalico/calico/libcalico-go/lib/options"
+	"github.com/projectcalico/calico/libcalico-go/lib/watch"
+)
+
+// WatchCursorStore is a durable store for the ResourceVersion that a cursor-stable watch has
+// reached.  It is implemented by the caller, for example using a local file, so that the cursor
+// survives restarts of the consumer and of the backend.
+type WatchCursorStore interface {
+	// LoadCursor returns the stored cursor, or "" if no cursor has been stored.
+	LoadCursor(ctx context.Context) (string, error)
+	// StoreCursor durably records the cursor.
+	StoreCursor(ctx context.Context, cursor string) error
+}
+
+// WatchWithCursor returns a watch.Interface that watches the WorkloadEndpoints that match the
+// supplied options, resuming from the cursor in the supplied store.  As each event is received
+// by the consumer, the cursor is advanced to its ResourceVersion, so a subsequent call resumes
+// after the last event that was received.  Delivery is at-least-once: after a restart, the
+// events since the last stored cursor may be redelivered.
+//
+// If there is no stored cursor, the WorkloadEndpoints are listed and an Added event is sent for
+// each one before watching from the revision of the list.  The same relist is performed if the
+// stored cursor is too old to resume from (for example, because the datastore has been
+// compacted).  Note that deletions between the stored cursor and the relist are not reported.
+func (r workloadEndpoints) WatchWithCursor(ctx context.Context, opts options.ListOptions, store WatchCursorStore) (watch.Interface, error) {
+	if err := rejectWatchProjection(opts, "WatchWithCursor"); err != nil {
+		return nil, err
+	}
+	cursor, err := store.LoadCursor(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	cw := &cursorWatcher{
+		ctx:     ctx,
+		cancel:  cancel,
+		wepIf:   r,
+		opts:    opts,
+		store:   store,
+		results: make(chan watch.Event, 100),
+	}
+	if cursor != "" {
+		log.WithField("cursor", cursor).Info("Resuming WorkloadEndpoint watch from stored cursor")
+		cw.opts.ResourceVersion = cursor
+		if cw.inner, err = r.Watch(ctx, cw.opts); err != nil {
+			cancel()
+			return nil, err
+		}
+		cw.resuming = true
+	}
+	go cw.run()
+	return cw, nil
+}
+
+// cursorWatcher implements watch.Interface, recording the ResourceVersion of each event that it
+// delivers in the WatchCursorStore.
+type cursorWatcher struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wepIf   workloadEndpoints
+	opts    options.ListOptions
+	store   WatchCursorStore
+	results chan watch.Event
+
+	// inner is the current underlying watcher; it is only accessed from the run() goroutine
+	// once that has started.
+	inner watch.Interface
+	// resuming is true if inner was started from a stored cursor and hasn't yet delivered an
+	// event.
+	resuming bool
+}
+
+func (cw *cursorWatcher) Stop() {
+	cw.cancel()
+}
+
+func (cw *cursorWatcher) ResultChan() <-chan watch.Event {
+	return cw.results
+}
+
+func (cw *cursorWatcher) run() {
+	defer close(cw.results)
+	defer cw.cancel()
+
+	if cw.inner == nil && !cw.relist() {
+		return
+	}
+	for {
+		select {
+		case e, ok := <-cw.inner.ResultChan():
+			if !ok {
+				log.Debug("Underlying WorkloadEndpoint watcher terminated")
+				return
+			}
+			if e.Type == watch.Error && cw.resuming && isWatchCursorTooOld(e.Error) {
+				log.WithError(e.Error).Info("Stored watch cursor is too old, relisting WorkloadEndpoints")
+				cw.inner.Stop()
+				if !cw.relist() {
+					return
+				}
+				continue
+			}
+			cw.resuming = false
+			if !cw.send(e) {
+				return
+			}
+			cw.storeCursor(eventResourceVersion(e))
+		case <-cw.ctx.Done():
+			return
+		}
+	}
+}
+
+// relist lists the current WorkloadEndpoints, sends an Added event for each, stores the list
+// revision as the cursor and then starts watching from that revision.  Returns false if the
+// watcher should terminate.
+func (cw *cursorWatcher) relist() bool {
+	opts := cw.opts
+	opts.ResourceVersion = ""
+	list, err := cw.wepIf.List(cw.ctx, opts)
+	if err != nil {
+		cw.send(watch.Event{Type: watch.Error, Error: err})
+		return false
+	}
+	for i := range list.Items {
+		if !cw.send(watch.Event{Type: watch.Added, Object: &list.Items[i]}) {
+			return false
+		}
+	}
+	cw.storeCursor(list.ResourceVersion)
+
+	opts.ResourceVersion = list.ResourceVersion
+	if cw.inner, err = cw.wepIf.Watch(cw.ctx, opts); err != nil {
+		cw.send(watch.Event{Type: watch.Error, Error: err})
+		return false
+	}
+	cw.resuming = false
+	return true
+}
+
+// send delivers the event to the consumer.  Returns false if the watcher was stopped first.
+func (cw *cursorWatcher) send(e watch.Event) bool {
+	select {
+	case cw.results <- e:
+		return true
+	case <-cw.ctx.Done():
+		return false
+	}
+}
+
+// storeCursor stores the cursor, if non-empty.  A failure to store the cursor is not fatal; it
+// just means that more events will be redelivered after a restart.
+func (cw *cursorWatcher) storeCursor(cursor string) {
+	if cursor == "" {
+		return
+	}
+	if err := cw.store.StoreCursor(cw.ctx, cursor); err != nil {
+		log.WithError(err).WithField("cursor", cursor).Warning("Failed to store watch cursor")
+	}
+}
+
+// eventResourceVersion returns the ResourceVersion to use as the cursor after the given event.
+// Deletion events only carry the deleted object, whose revision precedes the deletion (and may
+// precede the current cursor), so they don't advance the cursor; a resumed watch redelivers them.
+// Bookmark events (if requested in the options) advance the cursor even if nothing has changed.
+func eventResourceVersion(e watch.Event) string {
+	switch e.Type {
+	case watch.Deleted:
+		return ""
+	case watch.Bookmark:
+		return e.ResourceVersion
+	}
+	if res, ok := e.Object.(resource); ok {
+		return res.GetObjectMeta().GetResourceVersion()
+	}
+	return ""
+}
+
+// isWatchCursorTooOld returns true if the error indicates that the watch revision is no longer
+// available in the datastore.
+func isWatchCursorTooOld(err error) bool {
+	return err == rpctypes.ErrCompacted || kerrors.IsResourceExpired(err) || kerrors.IsGone(err)
+}
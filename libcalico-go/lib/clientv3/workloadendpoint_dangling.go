@@ -0,0 +1,63 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clientv3
+
+import (
+	"context"
+
+	log "github.com/sirupsen/logrus"
+
+	libapiv3 "github.com/projectcalico/calico/libcalico-go/lib/apis/v3"
+	"github.com/projectcalico/calico/libcalico-go/lib/names"
+	"github.com/projectcalico/calico/libcalico-go/lib/options"
+)
+
+// FindDangling lists the WorkloadEndpoints that match the supplied options and returns those
+// whose backing pod or container no longer exists, so that they can be garbage collected.  The
+// client can't know whether a workload exists, so isAlive is called with the identifiers parsed
+// from each WorkloadEndpoint's name and should return false if the workload has gone.
+//
+// A WorkloadEndpoint whose name can't be parsed is never returned: we can't tell what it
+// belongs to, so it isn't safe to treat it as dangling.  The WorkloadEndpoints are returned as
+// listed, with their resource versions, so that the caller can delete them conditionally in case
+// they've been recreated since.
+func (r workloadEndpoints) FindDangling(
+	ctx context.Context, isAlive func(names.WorkloadEndpointIdentifiers) bool, opts options.ListOptions,
+) ([]*libapiv3.WorkloadEndpoint, error) {
+	list, err := r.List(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	var dangling []*libapiv3.WorkloadEndpoint
+	for i := range list.Items {
+		wep := &list.Items[i]
+		logCxt := log.WithFields(log.Fields{"namespace": wep.Namespace, "name": wep.Name})
+		ids, err := names.ParseWorkloadEndpointName(wep.Name)
+		if err != nil {
+			logCxt.WithError(err).Warn("Unable to parse WorkloadEndpoint name, not checking whether it is dangling")
+			continue
+		}
+		if isAlive(ids) {
+			continue
+		}
+		logCxt.Debug("WorkloadEndpoint has no backing workload")
+		dangling = append(dangling, wep)
+	}
+	log.WithFields(log.Fields{
+		"numListed":   len(list.Items),
+		"numDangling": len(dangling),
+	}).Info("Checked for dangling WorkloadEndpoints")
+	return dangling, nil
+}
@@ -0,0 +1,230 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clientv3
+
+import (
+	"context"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+
+	libapiv3 "github.com/projectcalico/calico/libcalico-go/lib/apis/v3"
+	"github.com/projectcalico/calico/libcalico-go/lib/backend/model"
+	"github.com/projectcalico/calico/libcalico-go/lib/errors"
+	"github.com/projectcalico/calico/libcalico-go/lib/options"
+)
+
+const (
+	// deleteCollectionBatchSize is the maximum number of WorkloadEndpoints that DeleteCollection
+	// deletes in a single transaction.  This is within etcd's default limit of 128 operations
+	// per transaction.
+	deleteCollectionBatchSize = 100
+
+	// deleteCollectionRetries is the number of times that DeleteCollection retries the delete of
+	// a WorkloadEndpoint that was modified after it was listed.
+	deleteCollectionRetries = 5
+)
+
+// DeleteCollection deletes the WorkloadEndpoints that match the supplied list options, which must
+// include at least one of a Namespace, Name (or prefix), Names or LabelSelector; use the
+// LabelSelector "all()" to delete all WorkloadEndpoints.  It returns the WorkloadEndpoints that
+// were deleted.
+//
+// The matching WorkloadEndpoints are deleted in batches of at most deleteCollectionBatchSize, each
+// in a single transaction that only succeeds if none of the batch has been modified since it was
+// listed.  If a batch fails (or the datastore doesn't support batch deletes), its
+// WorkloadEndpoints are deleted one at a time; a WorkloadEndpoint that was modified since it was
+// listed is re-checked against the selector, and only deleted if it still matches.
+//
+// The delete is best-effort: if a WorkloadEndpoint can't be deleted, DeleteCollection carries on
+// with the others, and returns the WorkloadEndpoints that were deleted along with an
+// errors.ErrorAggregate holding the error for each WorkloadEndpoint that wasn't.  The delete
+// options are checked, but the ResourceVersion and UID preconditions are not supported; each
+// WorkloadEndpoint is deleted at the revision it was listed at.
+func (r workloadEndpoints) DeleteCollection(
+	ctx context.Context, listOpts options.ListOptions, deleteOpts options.DeleteOptions,
+) ([]libapiv3.WorkloadEndpoint, error) {
+	if err := validateDeleteCollectionOptions(listOpts, deleteOpts); err != nil {
+		return nil, err
+	}
+	var sel labelSelector
+	if listOpts.LabelSelector != "" {
+		var err error
+		if sel, err = parseLabelSelector(listOpts.LabelSelector); err != nil {
+			return nil, err
+		}
+	}
+
+	// We need all of the full WorkloadEndpoints, including their resource versions, from the
+	// primary datastore.
+	listOpts.Consistent = true
+	listOpts.ResourceVersion = ""
+	listOpts.Projection = nil
+	listOpts.Limit = 0
+	listOpts.Continue = ""
+	list, err := r.List(ctx, listOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	var deleted []libapiv3.WorkloadEndpoint
+	var errs []error
+	for start := 0; start < len(list.Items) && ctx.Err() == nil; start += deleteCollectionBatchSize {
+		end := start + deleteCollectionBatchSize
+		if end > len(list.Items) {
+			end = len(list.Items)
+		}
+		batch := list.Items[start:end]
+
+		out, err := r.deleteBatch(ctx, batch)
+		if err == nil {
+			deleted = append(deleted, out...)
+			continue
+		}
+		switch err.(type) {
+		case errors.ErrorResourceUpdateConflict, errors.ErrorResourceDoesNotExist:
+			log.WithError(err).Debug("WorkloadEndpoints changed since listed, deleting them individually")
+		default:
+			if err != errBatchDeleteUnsupported {
+				log.WithError(err).Warning("Failed to delete batch of WorkloadEndpoints, deleting them individually")
+			}
+		}
+		for i := range batch {
+			if ctx.Err() != nil {
+				break
+			}
+			wep, err := r.deleteIfMatches(ctx, sel, &batch[i])
+			if err != nil {
+				log.WithError(err).WithFields(log.Fields{
+					"namespace": batch[i].Namespace,
+					"name":      batch[i].Name,
+				}).Warning("Failed to delete WorkloadEndpoint, continuing with the others")
+				errs = append(errs, err)
+				continue
+			}
+			if wep != nil {
+				deleted = append(deleted, *wep)
+			}
+		}
+	}
+	if ctx.Err() != nil {
+		errs = append(errs, ctx.Err())
+	}
+
+	log.WithFields(log.Fields{
+		"namespace":  listOpts.Namespace,
+		"name":       listOpts.Name,
+		"selector":   listOpts.LabelSelector,
+		"numMatched": len(list.Items),
+		"numDeleted": len(deleted),
+		"numFailed":  len(errs),
+	}).Info("Deleted collection of WorkloadEndpoints")
+	if len(errs) > 0 {
+		return deleted, errors.ErrorAggregate{Errors: errs}
+	}
+	return deleted, nil
+}
+
+// validateDeleteCollectionOptions checks that the options for DeleteCollection restrict the
+// WorkloadEndpoints to delete, and don't include unsupported preconditions.
+func validateDeleteCollectionOptions(listOpts options.ListOptions, deleteOpts options.DeleteOptions) error {
+	if err := deleteOpts.Validate(); err != nil {
+		return err
+	}
+	var fields []errors.ErroredField
+	if listOpts.Namespace == "" && listOpts.Name == "" && len(listOpts.Names) == 0 && listOpts.LabelSelector == "" {
+		fields = append(fields, errors.ErroredField{
+			Name:   "LabelSelector",
+			Value:  listOpts.LabelSelector,
+			Reason: `a Namespace, Name, Names or LabelSelector is required, use the LabelSelector "all()" to delete all WorkloadEndpoints`,
+		})
+	}
+	if deleteOpts.ResourceVersion != "" {
+		fields = append(fields, errors.ErroredField{
+			Name:   "ResourceVersion",
+			Value:  deleteOpts.ResourceVersion,
+			Reason: "not supported by DeleteCollection",
+		})
+	}
+	if deleteOpts.UID != nil {
+		fields = append(fields, errors.ErroredField{
+			Name:   "UID",
+			Value:  *deleteOpts.UID,
+			Reason: "not supported by DeleteCollection",
+		})
+	}
+	if len(fields) > 0 {
+		return errors.ErrorValidation{ErroredFields: fields}
+	}
+	return nil
+}
+
+// deleteBatch deletes the given WorkloadEndpoints in a single transaction.
+func (r workloadEndpoints) deleteBatch(ctx context.Context, weps []libapiv3.WorkloadEndpoint) ([]libapiv3.WorkloadEndpoint, error) {
+	if err := r.client.wepWriteLimiter.wait(ctx); err != nil {
+		return nil, err
+	}
+	in := make([]resource, len(weps))
+	for i := range weps {
+		in[i] = &weps[i]
+	}
+	out, err := r.client.resources.DeleteBatch(ctx, libapiv3.KindWorkloadEndpoint, in)
+	if err != nil {
+		return nil, err
+	}
+	deleted := make([]libapiv3.WorkloadEndpoint, len(out))
+	for i, res := range out {
+		deleted[i] = *res.(*libapiv3.WorkloadEndpoint)
+		r.runDeleteHooks(ctx, &deleted[i])
+	}
+	return deleted, nil
+}
+
+// deleteIfMatches deletes the WorkloadEndpoint if it hasn't been modified since it was listed.  If
+// it has, it is deleted only if it still matches the selector (if any).  Returns the deleted
+// WorkloadEndpoint, or nil if it no longer exists or no longer matches.
+func (r workloadEndpoints) deleteIfMatches(ctx context.Context, sel labelSelector, wep *libapiv3.WorkloadEndpoint) (*libapiv3.WorkloadEndpoint, error) {
+	for attempt := 0; attempt < deleteCollectionRetries; attempt++ {
+		out, err := r.Delete(ctx, wep.Namespace, wep.Name, options.DeleteOptions{ResourceVersion: wep.ResourceVersion})
+		switch err.(type) {
+		case nil:
+			return out, nil
+		case errors.ErrorResourceDoesNotExist:
+			return nil, nil
+		case errors.ErrorResourceUpdateConflict:
+			// Modified since we read it, check whether it still matches.
+		default:
+			return nil, err
+		}
+		wep, err = r.Get(ctx, wep.Namespace, wep.Name, options.GetOptions{Consistent: true})
+		if _, ok := err.(errors.ErrorResourceDoesNotExist); ok {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if sel != nil && !sel.Evaluate(wep.Labels) {
+			log.WithFields(log.Fields{
+				"namespace": wep.Namespace,
+				"name":      wep.Name,
+			}).Debug("WorkloadEndpoint no longer matches selector, not deleting it")
+			return nil, nil
+		}
+	}
+	return nil, errors.ErrorResourceUpdateConflict{
+		Err: fmt.Errorf("failed to delete WorkloadEndpoint %s/%s after %d attempts: it is being modified concurrently",
+			wep.Namespace, wep.Name, deleteCollectionRetries),
+		Identifier: model.ResourceKey{Kind: libapiv3.KindWorkloadEndpoint, Namespace: wep.Namespace, Name: wep.Name},
+	}
+}
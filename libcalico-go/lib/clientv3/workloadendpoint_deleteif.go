@@ -0,0 +1,68 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clientv3
+
+import (
+	"context"
+
+	log "github.com/sirupsen/logrus"
+
+	libapiv3 "github.com/projectcalico/calico/libcalico-go/lib/apis/v3"
+	"github.com/projectcalico/calico/libcalico-go/lib/backend/model"
+	"github.com/projectcalico/calico/libcalico-go/lib/errors"
+	"github.com/projectcalico/calico/libcalico-go/lib/options"
+)
+
+// deleteIfRetries is the number of times that DeleteIf re-evaluates its predicate after a
+// conflict with a concurrent write.
+const deleteIfRetries = 5
+
+// DeleteIf deletes the WorkloadEndpoint, but only if the predicate returns true for its current
+// state; for example, to delete an endpoint only if it is still owned by the same container.  The
+// delete is conditional on the revision that the predicate was evaluated against, so the
+// WorkloadEndpoint can't be modified between the check and the delete; if it is, the predicate is
+// re-evaluated against the new state.  If opts.ResourceVersion is set, the current revision must
+// also match it.
+//
+// Returns the deleted WorkloadEndpoint, or an errors.ErrorPreconditionFailed if the predicate
+// returned false, in which case the WorkloadEndpoint is not modified.
+func (r workloadEndpoints) DeleteIf(
+	ctx context.Context, namespace, name string,
+	pred func(*libapiv3.WorkloadEndpoint) bool, opts options.DeleteOptions,
+) (*libapiv3.WorkloadEndpoint, error) {
+	key := model.ResourceKey{Kind: libapiv3.KindWorkloadEndpoint, Namespace: namespace, Name: name}
+	logCxt := log.WithFields(log.Fields{"namespace": namespace, "name": name})
+	for attempt := 0; ; attempt++ {
+		wep, err := r.Get(ctx, namespace, name, options.GetOptions{Consistent: true})
+		if err != nil {
+			return nil, err
+		}
+		if opts.ResourceVersion != "" && opts.ResourceVersion != wep.ResourceVersion {
+			return nil, errors.ErrorResourceUpdateConflict{Identifier: key}
+		}
+		// Pass a copy so that the predicate can't affect what we return.
+		if !pred(wep.DeepCopy()) {
+			logCxt.Debug("Delete predicate returned false, not deleting")
+			return nil, errors.ErrorPreconditionFailed{Identifier: key, Reason: "delete predicate returned false"}
+		}
+		out, err := r.Delete(ctx, namespace, name, options.DeleteOptions{ResourceVersion: wep.ResourceVersion})
+		if _, ok := err.(errors.ErrorResourceUpdateConflict); ok && opts.ResourceVersion == "" && attempt < deleteIfRetries {
+			// Someone else updated the WorkloadEndpoint; re-check the predicate.
+			logCxt.WithError(err).Info("Conflict while deleting WorkloadEndpoint, retrying")
+			continue
+		}
+		return out, err
+	}
+}
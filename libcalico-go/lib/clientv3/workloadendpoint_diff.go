@@ -0,0 +1,173 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clientv3
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	libapiv3 "github.com/projectcalico/calico/libcalico-go/lib/apis/v3"
+	"github.com/projectcalico/calico/libcalico-go/lib/backend/model"
+	"github.com/projectcalico/calico/libcalico-go/lib/errors"
+	"github.com/projectcalico/calico/libcalico-go/lib/options"
+	"github.com/projectcalico/calico/libcalico-go/lib/set"
+)
+
+// WorkloadEndpointDiff is a field-level diff between two revisions of the same WorkloadEndpoint.
+type WorkloadEndpointDiff struct {
+	Namespace string
+	Name      string
+	RevisionA string
+	RevisionB string
+
+	// ExistsA and ExistsB indicate whether the WorkloadEndpoint existed at each revision.  A
+	// revision at which the WorkloadEndpoint did not exist is diffed as an empty endpoint.
+	ExistsA bool
+	ExistsB bool
+
+	// ChangedSpecFields contains the names of the Spec fields that differ between the two
+	// revisions, in the order that they are declared in WorkloadEndpointSpec.
+	ChangedSpecFields []string
+
+	// AddedIPNetworks and RemovedIPNetworks contain the IP networks that are in revision B
+	// but not in A, and vice versa.
+	AddedIPNetworks   []string
+	RemovedIPNetworks []string
+
+	// AddedLabels contains the labels that are in revision B but not in A, with their values
+	// in B.  RemovedLabels contains the labels that are in A but not in B, with their values
+	// in A.  ChangedLabels contains the labels that are in both but with different values,
+	// with their values in B.
+	AddedLabels   map[string]string
+	RemovedLabels map[string]string
+	ChangedLabels map[string]string
+}
+
+// IsEmpty returns true if there are no differences between the two revisions.
+func (d *WorkloadEndpointDiff) IsEmpty() bool {
+	return d.ExistsA == d.ExistsB &&
+		len(d.ChangedSpecFields) == 0 &&
+		len(d.AddedLabels) == 0 &&
+		len(d.RemovedLabels) == 0 &&
+		len(d.ChangedLabels) == 0
+}
+
+// DiffRevisions gets the WorkloadEndpoint at the two supplied resource versions and returns a
+// field-level diff between them.  If the WorkloadEndpoint did not exist at one of the revisions
+// (for example, because the revision predates its creation) then that revision is treated as an
+// empty WorkloadEndpoint.  An error is returned if it didn't exist at either revision.
+func (r workloadEndpoints) DiffRevisions(ctx context.Context, namespace, name, rvA, rvB string) (*WorkloadEndpointDiff, error) {
+	wepA, err := r.getRevision(ctx, namespace, name, rvA)
+	if err != nil {
+		return nil, err
+	}
+	wepB, err := r.getRevision(ctx, namespace, name, rvB)
+	if err != nil {
+		return nil, err
+	}
+	if wepA == nil && wepB == nil {
+		return nil, errors.ErrorResourceDoesNotExist{
+			Err: fmt.Errorf("resource does not exist at revision %s or %s", rvA, rvB),
+			Identifier: model.ResourceKey{
+				Kind:      libapiv3.KindWorkloadEndpoint,
+				Namespace: namespace,
+				Name:      name,
+			},
+		}
+	}
+
+	diff := &WorkloadEndpointDiff{
+		Namespace: namespace,
+		Name:      name,
+		RevisionA: rvA,
+		RevisionB: rvB,
+		ExistsA:   wepA != nil,
+		ExistsB:   wepB != nil,
+	}
+	diffWorkloadEndpoints(wepA, wepB, diff)
+	return diff, nil
+}
+
+// diffWorkloadEndpoints fills in the field-level differences between wepA and wepB.  Either may
+// be nil, in which case it is diffed as an empty WorkloadEndpoint.
+func diffWorkloadEndpoints(wepA, wepB *libapiv3.WorkloadEndpoint, diff *WorkloadEndpointDiff) {
+	if wepA == nil {
+		wepA = &libapiv3.WorkloadEndpoint{}
+	}
+	if wepB == nil {
+		wepB = &libapiv3.WorkloadEndpoint{}
+	}
+
+	// Compare the Spec field by field.
+	specA := reflect.ValueOf(wepA.Spec)
+	specB := reflect.ValueOf(wepB.Spec)
+	specType := specA.Type()
+	for i := 0; i < specType.NumField(); i++ {
+		if !reflect.DeepEqual(specA.Field(i).Interface(), specB.Field(i).Interface()) {
+			diff.ChangedSpecFields = append(diff.ChangedSpecFields, specType.Field(i).Name)
+		}
+	}
+
+	// Compare the IP networks.
+	netsA := set.FromArray(wepA.Spec.IPNetworks)
+	netsB := set.FromArray(wepB.Spec.IPNetworks)
+	for _, n := range wepB.Spec.IPNetworks {
+		if !netsA.Contains(n) {
+			diff.AddedIPNetworks = append(diff.AddedIPNetworks, n)
+		}
+	}
+	for _, n := range wepA.Spec.IPNetworks {
+		if !netsB.Contains(n) {
+			diff.RemovedIPNetworks = append(diff.RemovedIPNetworks, n)
+		}
+	}
+
+	// Compare the labels.
+	for k, vB := range wepB.Labels {
+		if vA, ok := wepA.Labels[k]; !ok {
+			if diff.AddedLabels == nil {
+				diff.AddedLabels = map[string]string{}
+			}
+			diff.AddedLabels[k] = vB
+		} else if vA != vB {
+			if diff.ChangedLabels == nil {
+				diff.ChangedLabels = map[string]string{}
+			}
+			diff.ChangedLabels[k] = vB
+		}
+	}
+	for k, vA := range wepA.Labels {
+		if _, ok := wepB.Labels[k]; !ok {
+			if diff.RemovedLabels == nil {
+				diff.RemovedLabels = map[string]string{}
+			}
+			diff.RemovedLabels[k] = vA
+		}
+	}
+}
+
+// getRevision gets the WorkloadEndpoint at the specified revision, returning nil (and no error)
+// if it did not exist at that revision.
+func (r workloadEndpoints) getRevision(ctx context.Context, namespace, name, rv string) (*libapiv3.WorkloadEndpoint, error) {
+	wep, err := r.Get(ctx, namespace, name, options.GetOptions{ResourceVersion: rv})
+	if err != nil {
+		if _, ok := err.(errors.ErrorResourceDoesNotExist); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return wep, nil
+}
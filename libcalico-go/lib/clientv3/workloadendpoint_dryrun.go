@@ -0,0 +1,128 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clientv3
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	apiv3 "github.com/projectcalico/api/pkg/apis/projectcalico/v3"
+
+	libapiv3 "github.com/projectcalico/calico/libcalico-go/lib/apis/v3"
+	"github.com/projectcalico/calico/libcalico-go/lib/backend/model"
+	"github.com/projectcalico/calico/libcalico-go/lib/errors"
+	"github.com/projectcalico/calico/libcalico-go/lib/options"
+	validator "github.com/projectcalico/calico/libcalico-go/lib/validator/v3"
+)
+
+// DryRunUpdate performs all the validation of an Update, and checks the resource against the
+// stored WorkloadEndpoint, without writing anything.  It returns the WorkloadEndpoint that the
+// Update would write, and a diff from the stored WorkloadEndpoint to that one (with RevisionA set
+// to the stored revision and RevisionB empty).  Validation errors, and the errors for a missing
+// WorkloadEndpoint or a resource version conflict, are the same as a real Update would return.
+// As with a real Update, the stored WorkloadEndpoint is returned along with an update conflict
+// error.
+func (r workloadEndpoints) DryRunUpdate(ctx context.Context, res *libapiv3.WorkloadEndpoint, opts options.SetOptions) (*libapiv3.WorkloadEndpoint, *WorkloadEndpointDiff, error) {
+	if res != nil {
+		resCopy := *res
+		res = &resCopy
+	}
+	var warnings wepWarnings
+	if err := r.assignOrValidateName(res, r.client.legacyNames || opts.AllowLegacyName, &warnings); err != nil {
+		return nil, nil, err
+	} else if err := validator.Validate(res); err != nil {
+		return nil, nil, err
+	}
+	checkWarnings(res, &warnings)
+	r.updateLabelsForStorage(res)
+	r.finalizeReservation(res)
+	if err := r.client.resources.ValidateUpdate(libapiv3.KindWorkloadEndpoint, res); err != nil {
+		return nil, nil, err
+	}
+	stored, err := r.Get(ctx, res.Namespace, res.Name, options.GetOptions{Consistent: true})
+	if err != nil {
+		return nil, nil, err
+	}
+	key := model.ResourceKey{
+		Kind:      libapiv3.KindWorkloadEndpoint,
+		Namespace: res.Namespace,
+		Name:      res.Name,
+	}
+	if stored.ResourceVersion != res.ResourceVersion {
+		return stored, nil, errors.ErrorResourceUpdateConflict{Identifier: key}
+	}
+	// As with a real Update, the stored creation timestamp is kept.
+	checkCreationTimestampKept(res, stored, &warnings)
+	res.CreationTimestamp = stored.CreationTimestamp
+
+	// Fill in the fields that the datastore would, as it does for a real Update.
+	res.SetSelfLink("")
+	res.GetObjectKind().SetGroupVersionKind(schema.GroupVersionKind{
+		Group:   apiv3.Group,
+		Version: apiv3.VersionCurrent,
+		Kind:    libapiv3.KindWorkloadEndpoint,
+	})
+
+	diff := &WorkloadEndpointDiff{
+		Namespace: res.Namespace,
+		Name:      res.Name,
+		RevisionA: stored.ResourceVersion,
+		ExistsA:   true,
+		ExistsB:   true,
+	}
+	diffWorkloadEndpoints(stored, res, diff)
+	warnings.deliver(opts)
+	return res, diff, nil
+}
+
+// dryRunCreate performs all the validation of a Create, and checks that the WorkloadEndpoint does
+// not already exist, without writing anything.  It returns the WorkloadEndpoint that the Create
+// would write, with an empty resource version since none has been assigned.  Errors are the same
+// as a real Create would return; as with a real Create, the stored WorkloadEndpoint is returned
+// along with an already exists error.
+func (r workloadEndpoints) dryRunCreate(ctx context.Context, res *libapiv3.WorkloadEndpoint, opts options.SetOptions) (*libapiv3.WorkloadEndpoint, error) {
+	var warnings wepWarnings
+	res, err := r.prepareCreate(res, opts, &warnings)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.client.resources.PrepareCreate(libapiv3.KindWorkloadEndpoint, res); err != nil {
+		return nil, err
+	}
+
+	stored, err := r.Get(ctx, res.Namespace, res.Name, options.GetOptions{Consistent: true})
+	if err == nil {
+		return stored, errors.ErrorResourceAlreadyExists{
+			Identifier: model.ResourceKey{
+				Kind:      libapiv3.KindWorkloadEndpoint,
+				Namespace: res.Namespace,
+				Name:      res.Name,
+			},
+		}
+	} else if _, ok := err.(errors.ErrorResourceDoesNotExist); !ok {
+		return nil, err
+	}
+
+	// Fill in the fields that the datastore would, as it does for a real Create.
+	res.SetSelfLink("")
+	res.GetObjectKind().SetGroupVersionKind(schema.GroupVersionKind{
+		Group:   apiv3.Group,
+		Version: apiv3.VersionCurrent,
+		Kind:    libapiv3.KindWorkloadEndpoint,
+	})
+	warnings.deliver(opts)
+	return res, nil
+}
@@ -15,14 +15,25 @@
 package clientv3_test
 
 import (
+	"bytes"
+	goerrors "errors"
+	"sort"
 	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 
 	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 
 	apiv3 "github.com/projectcalico/api/pkg/apis/projectcalico/v3"
 	"github.com/projectcalico/api/pkg/lib/numorstring"
@@ -30,7 +41,11 @@ import (
 	"github.com/projectcalico/calico/libcalico-go/lib/apiconfig"
 	libapiv3 "github.com/projectcalico/calico/libcalico-go/lib/apis/v3"
 	"github.com/projectcalico/calico/libcalico-go/lib/backend"
+	bapi "github.com/projectcalico/calico/libcalico-go/lib/backend/api"
+	"github.com/projectcalico/calico/libcalico-go/lib/backend/model"
 	"github.com/projectcalico/calico/libcalico-go/lib/clientv3"
+	"github.com/projectcalico/calico/libcalico-go/lib/errors"
+	"github.com/projectcalico/calico/libcalico-go/lib/names"
 	"github.com/projectcalico/calico/libcalico-go/lib/options"
 	"github.com/projectcalico/calico/libcalico-go/lib/testutils"
 	"github.com/projectcalico/calico/libcalico-go/lib/watch"
@@ -147,6 +162,11 @@ var _ = testutils.E2eDatastoreDescribe("WorkloadEndpoint tests", testutils.Datas
 			}, options.SetOptions{})
 			Expect(outError).To(HaveOccurred())
 			Expect(outError.Error()).To(Equal("resource already exists: WorkloadEndpoint(" + namespace1 + "/" + name1 + ")"))
+			var alreadyExists errors.ErrorResourceAlreadyExists
+			Expect(goerrors.As(outError, &alreadyExists)).To(BeTrue())
+			Expect(alreadyExists.Kind()).To(Equal(libapiv3.KindWorkloadEndpoint))
+			Expect(alreadyExists.Namespace()).To(Equal(namespace1))
+			Expect(alreadyExists.Name()).To(Equal(name1))
 
 			By("Getting WorkloadEndpoint (name1) and comparing the output against spec1_1")
 			res, outError := c.WorkloadEndpoints().Get(ctx, namespace1, name1, options.GetOptions{})
@@ -160,6 +180,12 @@ var _ = testutils.E2eDatastoreDescribe("WorkloadEndpoint tests", testutils.Datas
 			_, outError = c.WorkloadEndpoints().Get(ctx, namespace2, name2, options.GetOptions{})
 			Expect(outError).To(HaveOccurred())
 			Expect(outError.Error()).To(ContainSubstring("resource does not exist: WorkloadEndpoint(" + namespace2 + "/" + name2 + ") with error:"))
+			var doesNotExist errors.ErrorResourceDoesNotExist
+			Expect(goerrors.As(outError, &doesNotExist)).To(BeTrue())
+			Expect(doesNotExist.Kind()).To(Equal(libapiv3.KindWorkloadEndpoint))
+			Expect(doesNotExist.Namespace()).To(Equal(namespace2))
+			Expect(doesNotExist.Name()).To(Equal(name2))
+			Expect(goerrors.Is(outError, errors.ErrorResourceDoesNotExist{})).To(BeTrue())
 
 			By("Listing all the WorkloadEndpoints in namespace1, expecting a single result with name1/spec1_1")
 			outList, outError := c.WorkloadEndpoints().List(ctx, options.ListOptions{Namespace: namespace1})
@@ -244,6 +270,12 @@ var _ = testutils.E2eDatastoreDescribe("WorkloadEndpoint tests", testutils.Datas
 			_, outError = c.WorkloadEndpoints().Update(ctx, res1, options.SetOptions{})
 			Expect(outError).To(HaveOccurred())
 			Expect(outError.Error()).To(Equal("update conflict: WorkloadEndpoint(" + namespace1 + "/" + name1 + ")"))
+			var conflict errors.ErrorResourceUpdateConflict
+			Expect(goerrors.As(outError, &conflict)).To(BeTrue())
+			Expect(conflict.Kind()).To(Equal(libapiv3.KindWorkloadEndpoint))
+			Expect(conflict.Namespace()).To(Equal(namespace1))
+			Expect(conflict.Name()).To(Equal(name1))
+			Expect(clientv3.IsUpdateConflict(outError)).To(BeTrue())
 
 			By("Getting WorkloadEndpoint (name1) with the original resource version and comparing the output against spec1_1")
 			res, outError = c.WorkloadEndpoints().Get(ctx, namespace1, name1, options.GetOptions{ResourceVersion: rv1_1})
@@ -663,4 +695,4137 @@ var _ = testutils.E2eDatastoreDescribe("WorkloadEndpoint tests", testutils.Datas
 			Expect(err).To(HaveOccurred())
 		})
 	})
+
+	Describe("WorkloadEndpoint legacy name compatibility", func() {
+		legacyName := "node-1-k8s-pod-eth0"
+		legacySpec := libapiv3.WorkloadEndpointSpec{
+			Node:          "node-1",
+			Orchestrator:  "k8s",
+			Pod:           "pod",
+			Endpoint:      "eth0",
+			InterfaceName: "cali1234",
+		}
+
+		var be bapi.Client
+
+		BeforeEach(func() {
+			var err error
+			be, err = backend.NewClient(config)
+			Expect(err).NotTo(HaveOccurred())
+			be.Clean()
+
+			By("Seeding a WorkloadEndpoint using a legacy (unescaped) name directly in the backend")
+			_, err = be.Create(ctx, &model.KVPair{
+				Key: model.ResourceKey{
+					Kind:      libapiv3.KindWorkloadEndpoint,
+					Namespace: namespace1,
+					Name:      legacyName,
+				},
+				Value: &libapiv3.WorkloadEndpoint{
+					TypeMeta: metav1.TypeMeta{
+						Kind:       libapiv3.KindWorkloadEndpoint,
+						APIVersion: apiv3.GroupVersionCurrent,
+					},
+					ObjectMeta: metav1.ObjectMeta{
+						Namespace:         namespace1,
+						Name:              legacyName,
+						CreationTimestamp: metav1.Now(),
+						UID:               "legacy-wep-uid",
+					},
+					Spec: legacySpec,
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should reject updates of legacy names by default", func() {
+			c, err := clientv3.New(config)
+			Expect(err).NotTo(HaveOccurred())
+
+			wep, err := c.WorkloadEndpoints().Get(ctx, namespace1, legacyName, options.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			wep.Spec.InterfaceName = "cali5678"
+			_, err = c.WorkloadEndpoints().Update(ctx, wep, options.SetOptions{})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("does not match the primary identifiers"))
+		})
+
+		It("should allow updates of legacy names with LegacyNames enabled", func() {
+			c, err := clientv3.New(config, clientv3.WithLegacyNames())
+			Expect(err).NotTo(HaveOccurred())
+
+			wep, err := c.WorkloadEndpoints().Get(ctx, namespace1, legacyName, options.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			wep.Spec.InterfaceName = "cali5678"
+			wep, err = c.WorkloadEndpoints().Update(ctx, wep, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(wep.Name).To(Equal(legacyName))
+			Expect(wep.Spec.InterfaceName).To(Equal("cali5678"))
+		})
+
+		It("should still reject creates of malformed names with LegacyNames enabled", func() {
+			c, err := clientv3.New(config, clientv3.WithLegacyNames())
+			Expect(err).NotTo(HaveOccurred())
+
+			spec := legacySpec
+			spec.Pod = "pod2"
+			_, err = c.WorkloadEndpoints().Create(ctx, &libapiv3.WorkloadEndpoint{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace1, Name: "node-1-k8s-pod2-eth0"},
+				Spec:       spec,
+			}, options.SetOptions{})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("does not match the primary identifiers"))
+		})
+
+		It("should reject creates of names that do not match the Spec with a Name field error", func() {
+			c, err := clientv3.New(config)
+			Expect(err).NotTo(HaveOccurred())
+
+			spec := legacySpec
+			spec.Pod = "pod2"
+			_, err = c.WorkloadEndpoints().Create(ctx, &libapiv3.WorkloadEndpoint{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace1, Name: "node--1-k8s-pod3-eth0"},
+				Spec:       spec,
+			}, options.SetOptions{})
+			Expect(err).To(BeAssignableToTypeOf(errors.ErrorValidation{}))
+			fields := err.(errors.ErrorValidation).ErroredFields
+			Expect(fields).To(HaveLen(1))
+			Expect(fields[0].Name).To(Equal("Name"))
+			Expect(fields[0].Value).To(Equal("node--1-k8s-pod3-eth0"))
+			Expect(fields[0].Reason).To(ContainSubstring("expected name node--1-k8s-pod2-eth0"))
+
+			By("creating it with the name built from the Spec")
+			wep, err := c.WorkloadEndpoints().Create(ctx, &libapiv3.WorkloadEndpoint{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace1, Name: "node--1-k8s-pod2-eth0"},
+				Spec:       spec,
+			}, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(wep.Name).To(Equal("node--1-k8s-pod2-eth0"))
+		})
+
+		It("should allow creates and updates of names that do not match the Spec with AllowLegacyName", func() {
+			c, err := clientv3.New(config)
+			Expect(err).NotTo(HaveOccurred())
+
+			var warnings []string
+			opts := options.SetOptions{
+				AllowLegacyName: true,
+				WarningHandler:  func(w string) { warnings = append(warnings, w) },
+			}
+			spec := legacySpec
+			spec.Pod = "pod2"
+			wep, err := c.WorkloadEndpoints().Create(ctx, &libapiv3.WorkloadEndpoint{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace1, Name: "node-1-k8s-pod2-eth0"},
+				Spec:       spec,
+			}, opts)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(wep.Name).To(Equal("node-1-k8s-pod2-eth0"))
+			Expect(warnings).To(ConsistOf(ContainSubstring("the canonical name is node--1-k8s-pod2-eth0")))
+
+			wep, err = c.WorkloadEndpoints().Get(ctx, namespace1, legacyName, options.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			wep.Spec.InterfaceName = "cali5678"
+			wep, err = c.WorkloadEndpoints().Update(ctx, wep, opts)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(wep.Name).To(Equal(legacyName))
+		})
+	})
+
+	Describe("WorkloadEndpoint batched watch functionality", func() {
+		interval := 200 * time.Millisecond
+		roundSize := 5
+		numRounds := 4
+
+		It("should deliver events in order, in batches no larger than the events produced per interval", func() {
+			c, err := clientv3.New(config)
+			Expect(err).NotTo(HaveOccurred())
+
+			be, err := backend.NewClient(config)
+			Expect(err).NotTo(HaveOccurred())
+			be.Clean()
+
+			bw, err := c.WorkloadEndpoints().WatchBatched(ctx, options.ListOptions{}, interval)
+			Expect(err).NotTo(HaveOccurred())
+			defer bw.Stop()
+
+			// Consume the batches as they are delivered so that the watcher is never blocked
+			// on the consumer.
+			batches := make(chan []watch.Event, 100)
+			go func() {
+				defer GinkgoRecover()
+				for batch := range bw.ResultChan() {
+					batches <- batch
+				}
+				close(batches)
+			}()
+
+			By("Creating and then modifying WorkloadEndpoints in rounds separated by several intervals")
+			var expected []string
+			for r := 0; r < numRounds; r++ {
+				for i := 0; i < roundSize; i++ {
+					pod := fmt.Sprintf("pod%d", r*roundSize+i)
+					wep, err := c.WorkloadEndpoints().Create(ctx, &libapiv3.WorkloadEndpoint{
+						ObjectMeta: metav1.ObjectMeta{Namespace: namespace1},
+						Spec: libapiv3.WorkloadEndpointSpec{
+							Node:          "node-1",
+							Orchestrator:  "k8s",
+							Pod:           pod,
+							Endpoint:      "eth0",
+							InterfaceName: "cali1234",
+						},
+					}, options.SetOptions{})
+					Expect(err).NotTo(HaveOccurred())
+					expected = append(expected, string(watch.Added)+"/"+wep.Name)
+
+					if i%2 == 1 {
+						wep.Spec.InterfaceName = "cali5678"
+						_, err = c.WorkloadEndpoints().Update(ctx, wep, options.SetOptions{})
+						Expect(err).NotTo(HaveOccurred())
+						expected = append(expected, string(watch.Modified)+"/"+wep.Name)
+					}
+				}
+				time.Sleep(3 * interval)
+			}
+			maxPerRound := roundSize + roundSize/2
+
+			By("Checking the events arrive in the correct order and in correctly sized batches")
+			var received []string
+			numBatches := 0
+			for len(received) < len(expected) {
+				var batch []watch.Event
+				Eventually(batches, 5*time.Second).Should(Receive(&batch))
+				Expect(batch).NotTo(BeEmpty())
+				Expect(len(batch)).To(BeNumerically("<=", maxPerRound))
+				for _, e := range batch {
+					Expect(e.Error).NotTo(HaveOccurred())
+					received = append(received, string(e.Type)+"/"+e.Object.(*libapiv3.WorkloadEndpoint).Name)
+				}
+				numBatches++
+			}
+			Expect(received).To(Equal(expected))
+			Expect(numBatches).To(BeNumerically(">=", numRounds))
+			Consistently(batches, 2*interval).ShouldNot(Receive())
+
+			By("Stopping the watcher and checking the result channel is closed")
+			bw.Stop()
+			Eventually(batches).Should(BeClosed())
+		})
+	})
+
+	Describe("WorkloadEndpoint revision diffs", func() {
+		It("should report exactly the fields that changed between two revisions", func() {
+			c, err := clientv3.New(config)
+			Expect(err).NotTo(HaveOccurred())
+
+			be, err := backend.NewClient(config)
+			Expect(err).NotTo(HaveOccurred())
+			be.Clean()
+
+			By("Getting a revision that predates creation")
+			list, err := c.WorkloadEndpoints().List(ctx, options.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			rv0 := list.ResourceVersion
+
+			By("Creating a WorkloadEndpoint")
+			wep, err := c.WorkloadEndpoints().Create(ctx, &libapiv3.WorkloadEndpoint{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: namespace1,
+					Labels:    map[string]string{"app": "frontend", "tier": "web", "zone": "a"},
+				},
+				Spec: libapiv3.WorkloadEndpointSpec{
+					Node:          "node-1",
+					Orchestrator:  "k8s",
+					Pod:           "pod",
+					Endpoint:      "eth0",
+					InterfaceName: "cali1234",
+					IPNetworks:    []string{"10.0.0.1/32", "10.0.0.2/32"},
+				},
+			}, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			rv1 := wep.ResourceVersion
+
+			By("Updating the interface name, IP networks and labels")
+			wep.Spec.InterfaceName = "cali5678"
+			wep.Spec.IPNetworks = []string{"10.0.0.2/32", "10.0.0.3/32"}
+			wep.Labels["app"] = "backend"
+			wep.Labels["version"] = "v2"
+			delete(wep.Labels, "zone")
+			wep, err = c.WorkloadEndpoints().Update(ctx, wep, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			rv2 := wep.ResourceVersion
+
+			By("Diffing the two revisions")
+			diff, err := c.WorkloadEndpoints().DiffRevisions(ctx, namespace1, wep.Name, rv1, rv2)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(diff.ExistsA).To(BeTrue())
+			Expect(diff.ExistsB).To(BeTrue())
+			Expect(diff.ChangedSpecFields).To(Equal([]string{"IPNetworks", "InterfaceName"}))
+			Expect(diff.AddedIPNetworks).To(Equal([]string{"10.0.0.3/32"}))
+			Expect(diff.RemovedIPNetworks).To(Equal([]string{"10.0.0.1/32"}))
+			Expect(diff.AddedLabels).To(Equal(map[string]string{"version": "v2"}))
+			Expect(diff.RemovedLabels).To(Equal(map[string]string{"zone": "a"}))
+			Expect(diff.ChangedLabels).To(Equal(map[string]string{"app": "backend"}))
+			Expect(diff.IsEmpty()).To(BeFalse())
+
+			By("Diffing a revision against itself")
+			diff, err = c.WorkloadEndpoints().DiffRevisions(ctx, namespace1, wep.Name, rv2, rv2)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(diff.IsEmpty()).To(BeTrue())
+
+			By("Diffing against a revision that predates creation")
+			diff, err = c.WorkloadEndpoints().DiffRevisions(ctx, namespace1, wep.Name, rv0, rv1)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(diff.ExistsA).To(BeFalse())
+			Expect(diff.ExistsB).To(BeTrue())
+			Expect(diff.ChangedSpecFields).To(Equal([]string{
+				"Orchestrator", "Node", "Pod", "Endpoint", "IPNetworks", "InterfaceName",
+			}))
+			Expect(diff.AddedIPNetworks).To(Equal([]string{"10.0.0.1/32", "10.0.0.2/32"}))
+			Expect(diff.RemovedIPNetworks).To(BeEmpty())
+			Expect(diff.AddedLabels).To(HaveKeyWithValue("app", "frontend"))
+			Expect(diff.RemovedLabels).To(BeEmpty())
+
+			By("Diffing revisions at which the WorkloadEndpoint did not exist")
+			_, err = c.WorkloadEndpoints().DiffRevisions(ctx, namespace1, "node-1-k8s-other-eth0", rv1, rv2)
+			Expect(err).To(BeAssignableToTypeOf(errors.ErrorResourceDoesNotExist{}))
+		})
+	})
+
+	Describe("WorkloadEndpoint read replica", func() {
+		It("should direct reads at the replica and writes at the primary", func() {
+			be, err := backend.NewClient(config)
+			Expect(err).NotTo(HaveOccurred())
+			be.Clean()
+
+			replica := &countingBackend{Client: be}
+			c, err := clientv3.New(config, clientv3.WithReadBackend(replica))
+			Expect(err).NotTo(HaveOccurred())
+
+			By("Creating a WorkloadEndpoint")
+			wep, err := c.WorkloadEndpoints().Create(ctx, &libapiv3.WorkloadEndpoint{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace1},
+				Spec:       spec1_1,
+			}, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(replica.writes).To(BeZero())
+
+			By("Getting, listing and watching using the replica")
+			_, err = c.WorkloadEndpoints().Get(ctx, namespace1, wep.Name, options.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(replica.gets).To(Equal(1))
+			list, err := c.WorkloadEndpoints().List(ctx, options.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(list.Items).To(HaveLen(1))
+			Expect(replica.lists).To(Equal(1))
+			w, err := c.WorkloadEndpoints().Watch(ctx, options.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			w.Stop()
+			Expect(replica.watches).To(Equal(1))
+
+			By("Getting, listing and watching using the primary for consistent reads")
+			_, err = c.WorkloadEndpoints().Get(ctx, namespace1, wep.Name, options.GetOptions{Consistent: true})
+			Expect(err).NotTo(HaveOccurred())
+			_, err = c.WorkloadEndpoints().List(ctx, options.ListOptions{Consistent: true})
+			Expect(err).NotTo(HaveOccurred())
+			w, err = c.WorkloadEndpoints().Watch(ctx, options.ListOptions{Consistent: true})
+			Expect(err).NotTo(HaveOccurred())
+			w.Stop()
+			Expect(replica.gets).To(Equal(1))
+			Expect(replica.lists).To(Equal(1))
+			Expect(replica.watches).To(Equal(1))
+
+			By("Updating and deleting the WorkloadEndpoint using the primary")
+			wep.Spec.InterfaceName = "cali5678"
+			wep, err = c.WorkloadEndpoints().Update(ctx, wep, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			_, err = c.WorkloadEndpoints().Delete(ctx, namespace1, wep.Name, options.DeleteOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(replica.writes).To(BeZero())
+		})
+
+		It("should fail to create a client with an invalid read replica config", func() {
+			badConfig := config
+			badConfig.Spec.DatastoreType = "not-a-datastore"
+			_, err := clientv3.New(config, clientv3.WithReadReplica(badConfig))
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("WorkloadEndpoint name reservation", func() {
+		var c clientv3.Interface
+		reservedName := "node--1-k8s-pod--1-eth0"
+		ttl := 2 * time.Second
+
+		BeforeEach(func() {
+			var err error
+			c, err = clientv3.New(config)
+			Expect(err).NotTo(HaveOccurred())
+
+			be, err := backend.NewClient(config)
+			Expect(err).NotTo(HaveOccurred())
+			be.Clean()
+		})
+
+		It("should reserve a name, exclude it from filtered lists and finalize it with an update", func() {
+			By("Reserving the name")
+			wep, err := c.WorkloadEndpoints().Reserve(ctx, namespace1, reservedName, options.SetOptions{TTL: ttl})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(clientv3.IsReserved(wep)).To(BeTrue())
+			Expect(wep.Spec.Node).To(Equal("node-1"))
+			Expect(wep.Spec.Pod).To(Equal("pod-1"))
+			Expect(wep.Spec.Endpoint).To(Equal("eth0"))
+
+			By("Failing to reserve the same name again")
+			_, err = c.WorkloadEndpoints().Reserve(ctx, namespace1, reservedName, options.SetOptions{TTL: ttl})
+			Expect(err).To(BeAssignableToTypeOf(errors.ErrorResourceAlreadyExists{}))
+
+			By("Creating a second, normal, WorkloadEndpoint")
+			_, err = c.WorkloadEndpoints().Create(ctx, &libapiv3.WorkloadEndpoint{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace1, Name: name1},
+				Spec:       spec1_1,
+			}, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			By("Filtering the reserved WorkloadEndpoint in List")
+			list, err := c.WorkloadEndpoints().List(ctx, options.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(list.Items).To(HaveLen(2))
+			list, err = c.WorkloadEndpoints().List(ctx, options.ListOptions{Reserved: options.ReservedExclude})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(list.Items).To(HaveLen(1))
+			Expect(list.Items[0].Name).To(Equal(name1))
+			list, err = c.WorkloadEndpoints().List(ctx, options.ListOptions{Reserved: options.ReservedOnly})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(list.Items).To(HaveLen(1))
+			Expect(list.Items[0].Name).To(Equal(reservedName))
+
+			By("Finalizing the reservation with the real spec")
+			wep.Spec.InterfaceName = "cali1234"
+			wep.Spec.IPNetworks = []string{"10.0.0.1/32"}
+			wep, err = c.WorkloadEndpoints().Update(ctx, wep, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(clientv3.IsReserved(wep)).To(BeFalse())
+
+			By("Checking the finalized WorkloadEndpoint does not expire")
+			time.Sleep(2 * ttl)
+			wep, err = c.WorkloadEndpoints().Get(ctx, namespace1, reservedName, options.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(clientv3.IsReserved(wep)).To(BeFalse())
+			Expect(wep.Spec.InterfaceName).To(Equal("cali1234"))
+		})
+
+		It("should mark the delete event of an expired WorkloadEndpoint as expired", func() {
+			By("Creating a WorkloadEndpoint with a short TTL and one with a long TTL")
+			outRes1, err := c.WorkloadEndpoints().Create(ctx, &libapiv3.WorkloadEndpoint{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace1, Name: name1},
+				Spec:       spec1_1,
+			}, options.SetOptions{TTL: ttl})
+			Expect(err).NotTo(HaveOccurred())
+			outRes2, err := c.WorkloadEndpoints().Create(ctx, &libapiv3.WorkloadEndpoint{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace2, Name: name2},
+				Spec:       spec2_1,
+			}, options.SetOptions{TTL: 10 * ttl})
+			Expect(err).NotTo(HaveOccurred())
+
+			w, err := c.WorkloadEndpoints().Watch(ctx, options.ListOptions{ResourceVersion: outRes2.ResourceVersion})
+			Expect(err).NotTo(HaveOccurred())
+			testWatcher := testutils.NewTestResourceWatch(config.Spec.DatastoreType, w)
+			defer testWatcher.Stop()
+
+			By("Explicitly deleting the WorkloadEndpoint whose TTL hasn't expired")
+			_, err = c.WorkloadEndpoints().Delete(ctx, namespace2, name2, options.DeleteOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			By("Waiting for the other WorkloadEndpoint to expire")
+			Eventually(func() error {
+				_, err := c.WorkloadEndpoints().Get(ctx, namespace1, name1, options.GetOptions{})
+				return err
+			}, 10*time.Second, 200*time.Millisecond).Should(BeAssignableToTypeOf(errors.ErrorResourceDoesNotExist{}))
+
+			// The explicit delete removes the lease first, which is seen as a modification.
+			testWatcher.ExpectEvents(libapiv3.KindWorkloadEndpoint, []watch.Event{
+				{
+					Type:   watch.Modified,
+					Object: outRes2,
+				},
+				{
+					Type:     watch.Deleted,
+					Previous: outRes2,
+				},
+				{
+					Type:     watch.Deleted,
+					Previous: outRes1,
+					Expired:  true,
+				},
+			})
+		})
+
+		It("should not mark an explicit delete as expired when the watch sees it after the TTL", func() {
+			By("Creating a WorkloadEndpoint with a short TTL and explicitly deleting it")
+			outRes1, err := c.WorkloadEndpoints().Create(ctx, &libapiv3.WorkloadEndpoint{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace1, Name: name1},
+				Spec:       spec1_1,
+			}, options.SetOptions{TTL: ttl})
+			Expect(err).NotTo(HaveOccurred())
+			_, err = c.WorkloadEndpoints().Delete(ctx, namespace1, name1, options.DeleteOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			By("Waiting for the TTL to pass before watching from before the delete")
+			time.Sleep(2 * ttl)
+			w, err := c.WorkloadEndpoints().Watch(ctx, options.ListOptions{ResourceVersion: outRes1.ResourceVersion})
+			Expect(err).NotTo(HaveOccurred())
+			testWatcher := testutils.NewTestResourceWatch(config.Spec.DatastoreType, w)
+			defer testWatcher.Stop()
+
+			testWatcher.ExpectEvents(libapiv3.KindWorkloadEndpoint, []watch.Event{
+				{
+					Type:   watch.Modified,
+					Object: outRes1,
+				},
+				{
+					Type:     watch.Deleted,
+					Previous: outRes1,
+				},
+			})
+		})
+
+		It("should expire a reservation that is never finalized", func() {
+			_, err := c.WorkloadEndpoints().Reserve(ctx, namespace1, reservedName, options.SetOptions{TTL: ttl})
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(func() error {
+				_, err := c.WorkloadEndpoints().Get(ctx, namespace1, reservedName, options.GetOptions{})
+				return err
+			}, 10*time.Second, 200*time.Millisecond).Should(BeAssignableToTypeOf(errors.ErrorResourceDoesNotExist{}))
+		})
+
+		It("should reject a name that cannot be parsed", func() {
+			_, err := c.WorkloadEndpoints().Reserve(ctx, namespace1, "node-1-k8s-pod-1-eth0", options.SetOptions{})
+			Expect(err).To(BeAssignableToTypeOf(errors.ErrorValidation{}))
+		})
+	})
+
+	Describe("WorkloadEndpoint list projection", func() {
+		var c clientv3.Interface
+
+		BeforeEach(func() {
+			var err error
+			c, err = clientv3.New(config)
+			Expect(err).NotTo(HaveOccurred())
+
+			be, err := backend.NewClient(config)
+			Expect(err).NotTo(HaveOccurred())
+			be.Clean()
+
+			spec := spec1_1
+			spec.IPNetworks = []string{"10.0.0.1/32"}
+			_, err = c.WorkloadEndpoints().Create(ctx, &libapiv3.WorkloadEndpoint{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace1, Name: name1, Labels: map[string]string{"app": "web"}},
+				Spec:       spec,
+			}, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			_, err = c.WorkloadEndpoints().Create(ctx, &libapiv3.WorkloadEndpoint{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace2, Name: name2},
+				Spec:       spec2_1,
+			}, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should only populate the requested fields", func() {
+			list, err := c.WorkloadEndpoints().List(ctx, options.ListOptions{
+				Namespace:  namespace1,
+				Projection: []string{"Name", "Node", "IPNetworks"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(list.Items).To(HaveLen(1))
+			Expect(list.Items[0]).To(Equal(libapiv3.WorkloadEndpoint{
+				TypeMeta: metav1.TypeMeta{
+					Kind:       libapiv3.KindWorkloadEndpoint,
+					APIVersion: apiv3.GroupVersionCurrent,
+				},
+				ObjectMeta: metav1.ObjectMeta{Name: name1},
+				Spec: libapiv3.WorkloadEndpointSpec{
+					Node:       "node-1",
+					IPNetworks: []string{"10.0.0.1/32"},
+				},
+			}))
+		})
+
+		It("should not affect full lists", func() {
+			list, err := c.WorkloadEndpoints().List(ctx, options.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(list.Items).To(HaveLen(2))
+			for _, wep := range list.Items {
+				Expect(wep.Namespace).NotTo(BeEmpty())
+				Expect(wep.ResourceVersion).NotTo(BeEmpty())
+				Expect(wep.Labels).NotTo(BeEmpty())
+				Expect(wep.Spec.InterfaceName).NotTo(BeEmpty())
+			}
+		})
+
+		It("should reject unknown fields", func() {
+			_, err := c.WorkloadEndpoints().List(ctx, options.ListOptions{Projection: []string{"Name", "Bogus"}})
+			Expect(err).To(BeAssignableToTypeOf(errors.ErrorValidation{}))
+		})
+	})
+
+	Describe("WorkloadEndpoint cursor-stable watch", func() {
+		var (
+			c     clientv3.Interface
+			store *memCursorStore
+		)
+
+		createWEP := func(c clientv3.Interface, namespace, name string, spec libapiv3.WorkloadEndpointSpec) *libapiv3.WorkloadEndpoint {
+			wep, err := c.WorkloadEndpoints().Create(ctx, &libapiv3.WorkloadEndpoint{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+				Spec:       spec,
+			}, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			return wep
+		}
+
+		nextEvent := func(w watch.Interface) watch.Event {
+			var e watch.Event
+			EventuallyWithOffset(1, w.ResultChan(), 5*time.Second).Should(Receive(&e))
+			return e
+		}
+
+		BeforeEach(func() {
+			var err error
+			c, err = clientv3.New(config)
+			Expect(err).NotTo(HaveOccurred())
+
+			be, err := backend.NewClient(config)
+			Expect(err).NotTo(HaveOccurred())
+			be.Clean()
+
+			store = &memCursorStore{}
+			createWEP(c, namespace1, name1, spec1_1)
+		})
+
+		It("should list and then watch if there is no stored cursor", func() {
+			w, err := c.WorkloadEndpoints().WatchWithCursor(ctx, options.ListOptions{}, store)
+			Expect(err).NotTo(HaveOccurred())
+			defer w.Stop()
+
+			e := nextEvent(w)
+			Expect(e.Type).To(Equal(watch.Added))
+			Expect(e.Object.(*libapiv3.WorkloadEndpoint).Name).To(Equal(name1))
+			Eventually(store.Cursor).ShouldNot(BeEmpty())
+
+			wep2 := createWEP(c, namespace2, name2, spec2_1)
+			e = nextEvent(w)
+			Expect(e.Type).To(Equal(watch.Added))
+			Expect(e.Object.(*libapiv3.WorkloadEndpoint).Name).To(Equal(name2))
+			Eventually(store.Cursor).Should(Equal(wep2.ResourceVersion))
+		})
+
+		It("should resume from the stored cursor after a restart", func() {
+			w, err := c.WorkloadEndpoints().WatchWithCursor(ctx, options.ListOptions{}, store)
+			Expect(err).NotTo(HaveOccurred())
+			nextEvent(w)
+			Eventually(store.Cursor).ShouldNot(BeEmpty())
+			w.Stop()
+
+			// Make changes while the consumer is down, then restart it with a new client.
+			wep2 := createWEP(c, namespace2, name2, spec2_1)
+			_, err = c.WorkloadEndpoints().Delete(ctx, namespace1, name1, options.DeleteOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			c, err = clientv3.New(config)
+			Expect(err).NotTo(HaveOccurred())
+
+			w, err = c.WorkloadEndpoints().WatchWithCursor(ctx, options.ListOptions{}, store)
+			Expect(err).NotTo(HaveOccurred())
+			defer w.Stop()
+
+			e := nextEvent(w)
+			Expect(e.Type).To(Equal(watch.Added))
+			Expect(e.Object.(*libapiv3.WorkloadEndpoint).Name).To(Equal(name2))
+			e = nextEvent(w)
+			Expect(e.Type).To(Equal(watch.Deleted))
+			Expect(e.Previous.(*libapiv3.WorkloadEndpoint).Name).To(Equal(name1))
+			Consistently(w.ResultChan()).ShouldNot(Receive())
+			Expect(store.Cursor()).To(Equal(wep2.ResourceVersion))
+		})
+
+		It("should relist if the stored cursor has been compacted", func() {
+			list, err := c.WorkloadEndpoints().List(ctx, options.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(store.StoreCursor(ctx, list.ResourceVersion)).To(Succeed())
+
+			createWEP(c, namespace2, name2, spec2_1)
+			list, err = c.WorkloadEndpoints().List(ctx, options.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			// Simulate compaction up to the current revision, without compacting the shared
+			// datastore.
+			be, err := backend.NewClient(config)
+			Expect(err).NotTo(HaveOccurred())
+			compacted := &compactedBackend{Client: be}
+			compacted.compactRev, err = strconv.ParseInt(list.ResourceVersion, 10, 64)
+			Expect(err).NotTo(HaveOccurred())
+			c, err = clientv3.New(config, clientv3.WithReadBackend(compacted))
+			Expect(err).NotTo(HaveOccurred())
+
+			w, err := c.WorkloadEndpoints().WatchWithCursor(ctx, options.ListOptions{}, store)
+			Expect(err).NotTo(HaveOccurred())
+			defer w.Stop()
+
+			var names []string
+			for i := 0; i < 2; i++ {
+				e := nextEvent(w)
+				Expect(e.Type).To(Equal(watch.Added))
+				names = append(names, e.Object.(*libapiv3.WorkloadEndpoint).Name)
+			}
+			Expect(names).To(ConsistOf(name1, name2))
+			Eventually(store.Cursor).Should(Equal(list.ResourceVersion))
+		})
+	})
+
+	Describe("WorkloadEndpoint relist-aware watch", func() {
+		var (
+			c   clientv3.Interface
+			gap *gapBackend
+		)
+
+		nextEvent := func(w watch.Interface) watch.Event {
+			var e watch.Event
+			EventuallyWithOffset(1, w.ResultChan(), 5*time.Second).Should(Receive(&e))
+			return e
+		}
+
+		eventKey := func(e watch.Event) string {
+			obj := e.Object
+			if e.Type == watch.Deleted {
+				obj = e.Previous
+			}
+			wep := obj.(*libapiv3.WorkloadEndpoint)
+			return fmt.Sprintf("%s %s/%s", e.Type, wep.Namespace, wep.Name)
+		}
+
+		BeforeEach(func() {
+			be, err := backend.NewClient(config)
+			Expect(err).NotTo(HaveOccurred())
+			be.Clean()
+
+			gap = &gapBackend{Client: be}
+			c, err = clientv3.New(config, clientv3.WithReadBackend(gap))
+			Expect(err).NotTo(HaveOccurred())
+
+			for _, wep := range []*libapiv3.WorkloadEndpoint{
+				{ObjectMeta: metav1.ObjectMeta{Namespace: namespace1, Name: name1}, Spec: spec1_1},
+				{ObjectMeta: metav1.ObjectMeta{Namespace: namespace2, Name: name2}, Spec: spec2_1},
+			} {
+				_, err = c.WorkloadEndpoints().Create(ctx, wep, options.SetOptions{})
+				Expect(err).NotTo(HaveOccurred())
+			}
+		})
+
+		It("should send synthetic events for changes made during a watch gap", func() {
+			w, err := c.WorkloadEndpoints().WatchWithRelist(ctx, options.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			defer w.Stop()
+
+			var keys []string
+			for i := 0; i < 2; i++ {
+				keys = append(keys, eventKey(nextEvent(w)))
+			}
+			Expect(keys).To(ConsistOf(
+				"ADDED "+namespace1+"/"+name1,
+				"ADDED "+namespace2+"/"+name2,
+			))
+			Eventually(gap.NumWatchers).Should(Equal(1))
+
+			By("Making changes while the watch is dropping events")
+			gap.StartGap()
+			wep1, err := c.WorkloadEndpoints().Get(ctx, namespace1, name1, options.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			wep1.Spec = spec1_2
+			_, err = c.WorkloadEndpoints().Update(ctx, wep1, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			_, err = c.WorkloadEndpoints().Delete(ctx, namespace2, name2, options.DeleteOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			_, err = c.WorkloadEndpoints().Create(ctx, &libapiv3.WorkloadEndpoint{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace1, Name: name2},
+				Spec:       spec2_1,
+			}, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Consistently(w.ResultChan()).ShouldNot(Receive())
+
+			By("Failing the watch so that the watcher relists")
+			gap.EndGap(rpctypes.ErrCompacted)
+			keys = nil
+			var deleted watch.Event
+			for i := 0; i < 3; i++ {
+				e := nextEvent(w)
+				if e.Type == watch.Deleted {
+					deleted = e
+				}
+				keys = append(keys, eventKey(e))
+			}
+			Expect(keys).To(ConsistOf(
+				"MODIFIED "+namespace1+"/"+name1,
+				"ADDED "+namespace1+"/"+name2,
+				"DELETED "+namespace2+"/"+name2,
+			))
+			Expect(deleted.Previous.(*libapiv3.WorkloadEndpoint).Spec).To(Equal(spec2_1))
+			Consistently(w.ResultChan()).ShouldNot(Receive())
+
+			By("Continuing to watch after the relist")
+			_, err = c.WorkloadEndpoints().Delete(ctx, namespace1, name1, options.DeleteOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(eventKey(nextEvent(w))).To(Equal("DELETED " + namespace1 + "/" + name1))
+		})
+
+		It("should not send events for objects that are unchanged across a gap", func() {
+			w, err := c.WorkloadEndpoints().WatchWithRelist(ctx, options.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			defer w.Stop()
+
+			nextEvent(w)
+			nextEvent(w)
+			Eventually(gap.NumWatchers).Should(Equal(1))
+
+			gap.StartGap()
+			gap.EndGap(rpctypes.ErrCompacted)
+			Eventually(gap.NumWatchers).Should(Equal(1))
+			Consistently(w.ResultChan()).ShouldNot(Receive())
+		})
+	})
+
+	Describe("WorkloadEndpoint AddIP and RemoveIP", func() {
+		var (
+			c   clientv3.Interface
+			wep *libapiv3.WorkloadEndpoint
+		)
+
+		BeforeEach(func() {
+			var err error
+			c, err = clientv3.New(config)
+			Expect(err).NotTo(HaveOccurred())
+
+			be, err := backend.NewClient(config)
+			Expect(err).NotTo(HaveOccurred())
+			be.Clean()
+
+			wep, err = c.WorkloadEndpoints().Create(ctx, &libapiv3.WorkloadEndpoint{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace1, Name: name1, Labels: map[string]string{"app": "foo"}},
+				Spec:       spec1_1,
+			}, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should add an IP", func() {
+			out, err := c.WorkloadEndpoints().AddIP(ctx, namespace1, name1, "10.0.0.1", options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(out.Spec.IPNetworks).To(Equal([]string{"10.0.0.1/32"}))
+			Expect(out.ResourceVersion).NotTo(Equal(wep.ResourceVersion))
+
+			out, err = c.WorkloadEndpoints().AddIP(ctx, namespace1, name1, "fd00::1/128", options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(out.Spec.IPNetworks).To(Equal([]string{"10.0.0.1/32", "fd00::1/128"}))
+
+			By("Leaving the rest of the WorkloadEndpoint unchanged")
+			out, err = c.WorkloadEndpoints().Get(ctx, namespace1, name1, options.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(out.Labels).To(Equal(wep.Labels))
+			out.Spec.IPNetworks = nil
+			Expect(out.Spec).To(Equal(wep.Spec))
+		})
+
+		It("should treat re-adding an existing IP as a no-op", func() {
+			added, err := c.WorkloadEndpoints().AddIP(ctx, namespace1, name1, "10.0.0.1", options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			out, err := c.WorkloadEndpoints().AddIP(ctx, namespace1, name1, "10.0.0.1/32", options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(out.Spec.IPNetworks).To(Equal([]string{"10.0.0.1/32"}))
+			Expect(out.ResourceVersion).To(Equal(added.ResourceVersion))
+		})
+
+		It("should remove an IP", func() {
+			_, err := c.WorkloadEndpoints().AddIP(ctx, namespace1, name1, "10.0.0.1", options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			_, err = c.WorkloadEndpoints().AddIP(ctx, namespace1, name1, "10.0.0.2", options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			out, err := c.WorkloadEndpoints().RemoveIP(ctx, namespace1, name1, "10.0.0.1/32", options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(out.Spec.IPNetworks).To(Equal([]string{"10.0.0.2/32"}))
+
+			out, err = c.WorkloadEndpoints().RemoveIP(ctx, namespace1, name1, "10.0.0.2", options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(out.Spec.IPNetworks).To(BeEmpty())
+		})
+
+		It("should treat removing an absent IP as a no-op", func() {
+			added, err := c.WorkloadEndpoints().AddIP(ctx, namespace1, name1, "10.0.0.1", options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			out, err := c.WorkloadEndpoints().RemoveIP(ctx, namespace1, name1, "10.0.0.2", options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(out.Spec.IPNetworks).To(Equal([]string{"10.0.0.1/32"}))
+			Expect(out.ResourceVersion).To(Equal(added.ResourceVersion))
+		})
+
+		It("should reject invalid IPs", func() {
+			for _, ip := range []string{"", "foo", "10.0.0.0/24", "fd00::/64"} {
+				_, err := c.WorkloadEndpoints().AddIP(ctx, namespace1, name1, ip, options.SetOptions{})
+				Expect(err).To(BeAssignableToTypeOf(errors.ErrorValidation{}), "ip: %q", ip)
+				_, err = c.WorkloadEndpoints().RemoveIP(ctx, namespace1, name1, ip, options.SetOptions{})
+				Expect(err).To(BeAssignableToTypeOf(errors.ErrorValidation{}), "ip: %q", ip)
+			}
+		})
+
+		It("should return an error if the WorkloadEndpoint does not exist", func() {
+			_, err := c.WorkloadEndpoints().AddIP(ctx, namespace2, name1, "10.0.0.1", options.SetOptions{})
+			Expect(err).To(BeAssignableToTypeOf(errors.ErrorResourceDoesNotExist{}))
+		})
+	})
+
+	Describe("WorkloadEndpoint export and import", func() {
+		var (
+			c        clientv3.Interface
+			be       bapi.Client
+			original []libapiv3.WorkloadEndpoint
+		)
+
+		BeforeEach(func() {
+			var err error
+			c, err = clientv3.New(config)
+			Expect(err).NotTo(HaveOccurred())
+
+			be, err = backend.NewClient(config)
+			Expect(err).NotTo(HaveOccurred())
+			be.Clean()
+
+			spec1 := spec1_1
+			spec1.IPNetworks = []string{"10.0.0.1/32", "fd00::1/128"}
+			spec2 := spec2_1
+			spec2.IPNetworks = []string{"10.0.0.2/32"}
+			original = nil
+			for _, wep := range []*libapiv3.WorkloadEndpoint{
+				{ObjectMeta: metav1.ObjectMeta{Namespace: namespace1, Name: name1, Labels: map[string]string{"app": "foo"}}, Spec: spec1},
+				{ObjectMeta: metav1.ObjectMeta{Namespace: namespace1, Name: name2, Labels: map[string]string{"app": "bar"}}, Spec: spec2},
+			} {
+				out, err := c.WorkloadEndpoints().Create(ctx, wep, options.SetOptions{})
+				Expect(err).NotTo(HaveOccurred())
+				original = append(original, *out)
+			}
+		})
+
+		export := func() []byte {
+			var buf bytes.Buffer
+			Expect(c.WorkloadEndpoints().ExportAll(ctx, &buf)).To(Succeed())
+			return buf.Bytes()
+		}
+
+		It("should round-trip the WorkloadEndpoints through a clean datastore", func() {
+			data := export()
+			Expect(export()).To(Equal(data), "export should be stable")
+
+			be.Clean()
+			list, err := c.WorkloadEndpoints().List(ctx, options.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(list.Items).To(BeEmpty())
+
+			results, err := c.WorkloadEndpoints().ImportAll(ctx, bytes.NewReader(data), clientv3.WorkloadEndpointImportOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(results).To(ConsistOf(
+				clientv3.WorkloadEndpointImportResult{Namespace: namespace1, Name: name1, Action: clientv3.WorkloadEndpointImportCreated},
+				clientv3.WorkloadEndpointImportResult{Namespace: namespace1, Name: name2, Action: clientv3.WorkloadEndpointImportCreated},
+			))
+
+			for _, orig := range original {
+				imported, err := c.WorkloadEndpoints().Get(ctx, orig.Namespace, orig.Name, options.GetOptions{})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(imported.Labels).To(Equal(orig.Labels))
+				Expect(imported.Spec).To(Equal(orig.Spec))
+				Expect(imported.Spec.IPNetworks).NotTo(BeEmpty())
+				Expect(imported.UID).To(Equal(orig.UID))
+				Expect(imported.ResourceVersion).NotTo(BeEmpty())
+			}
+		})
+
+		It("should report per-item failures for existing WorkloadEndpoints unless upserting", func() {
+			data := export()
+			_, err := c.WorkloadEndpoints().Delete(ctx, namespace1, name2, options.DeleteOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			results, err := c.WorkloadEndpoints().ImportAll(ctx, bytes.NewReader(data), clientv3.WorkloadEndpointImportOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(results).To(HaveLen(2))
+			Expect(results[0].Name).To(Equal(name1))
+			Expect(results[0].Action).To(Equal(clientv3.WorkloadEndpointImportFailed))
+			Expect(results[0].Error).To(BeAssignableToTypeOf(errors.ErrorResourceAlreadyExists{}))
+			Expect(results[1].Name).To(Equal(name2))
+			Expect(results[1].Action).To(Equal(clientv3.WorkloadEndpointImportCreated))
+
+			By("Upserting")
+			wep1, err := c.WorkloadEndpoints().Get(ctx, namespace1, name1, options.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			wep1.Labels = map[string]string{"app": "changed"}
+			_, err = c.WorkloadEndpoints().Update(ctx, wep1, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			results, err = c.WorkloadEndpoints().ImportAll(ctx, bytes.NewReader(data), clientv3.WorkloadEndpointImportOptions{Upsert: true})
+			Expect(err).NotTo(HaveOccurred())
+			for _, result := range results {
+				Expect(result.Action).To(Equal(clientv3.WorkloadEndpointImportUpdated))
+				Expect(result.Error).NotTo(HaveOccurred())
+			}
+			wep1, err = c.WorkloadEndpoints().Get(ctx, namespace1, name1, options.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(wep1.Labels).To(Equal(original[0].Labels))
+		})
+
+		It("should reject an export with an unsupported format version", func() {
+			data := bytes.Replace(export(), []byte(`"formatVersion":1`), []byte(`"formatVersion":2`), 1)
+			_, err := c.WorkloadEndpoints().ImportAll(ctx, bytes.NewReader(data), clientv3.WorkloadEndpointImportOptions{})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("WorkloadEndpoint multi-name watch", func() {
+		var c clientv3.Interface
+
+		wepName := func(cid string) string {
+			return "node--2-cni-" + cid + "-eth0"
+		}
+
+		createWEP := func(namespace, cid string) {
+			spec := spec2_1
+			spec.ContainerID = cid
+			_, err := c.WorkloadEndpoints().Create(ctx, &libapiv3.WorkloadEndpoint{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: wepName(cid)},
+				Spec:       spec,
+			}, options.SetOptions{})
+			ExpectWithOffset(1, err).NotTo(HaveOccurred())
+		}
+
+		updateWEP := func(namespace, cid string) {
+			wep, err := c.WorkloadEndpoints().Get(ctx, namespace, wepName(cid), options.GetOptions{})
+			ExpectWithOffset(1, err).NotTo(HaveOccurred())
+			wep.Spec.InterfaceName = "caliupdated"
+			_, err = c.WorkloadEndpoints().Update(ctx, wep, options.SetOptions{})
+			ExpectWithOffset(1, err).NotTo(HaveOccurred())
+		}
+
+		deleteWEP := func(namespace, cid string) {
+			_, err := c.WorkloadEndpoints().Delete(ctx, namespace, wepName(cid), options.DeleteOptions{})
+			ExpectWithOffset(1, err).NotTo(HaveOccurred())
+		}
+
+		eventKey := func(e watch.Event) string {
+			obj := e.Object
+			if e.Type == watch.Deleted {
+				obj = e.Previous
+			}
+			wep := obj.(*libapiv3.WorkloadEndpoint)
+			return fmt.Sprintf("%s %s/%s", e.Type, wep.Namespace, wep.Name)
+		}
+
+		// receiveKeys receives n events and then checks that no more are delivered.
+		receiveKeys := func(w watch.Interface, n int) []string {
+			var keys []string
+			for i := 0; i < n; i++ {
+				var e watch.Event
+				EventuallyWithOffset(1, w.ResultChan(), 5*time.Second).Should(Receive(&e))
+				keys = append(keys, eventKey(e))
+			}
+			ConsistentlyWithOffset(1, w.ResultChan()).ShouldNot(Receive())
+			return keys
+		}
+
+		BeforeEach(func() {
+			var err error
+			c, err = clientv3.New(config)
+			Expect(err).NotTo(HaveOccurred())
+
+			be, err := backend.NewClient(config)
+			Expect(err).NotTo(HaveOccurred())
+			be.Clean()
+
+			for _, cid := range []string{"c1", "c2", "c3", "c4"} {
+				createWEP(namespace1, cid)
+			}
+			createWEP(namespace2, "c1")
+		})
+
+		It("should only deliver events for the named WorkloadEndpoints", func() {
+			w, err := c.WorkloadEndpoints().Watch(ctx, options.ListOptions{
+				Namespace: namespace1,
+				Names:     []string{wepName("c1"), wepName("c2"), wepName("c5")},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			defer w.Stop()
+
+			By("Receiving a snapshot of only the named WorkloadEndpoints that exist")
+			Expect(receiveKeys(w, 2)).To(ConsistOf(
+				"ADDED "+namespace1+"/"+wepName("c1"),
+				"ADDED "+namespace1+"/"+wepName("c2"),
+			))
+
+			By("Changing WorkloadEndpoints that aren't named")
+			updateWEP(namespace1, "c3")
+			deleteWEP(namespace1, "c4")
+			createWEP(namespace1, "c6")
+			updateWEP(namespace2, "c1")
+			Consistently(w.ResultChan()).ShouldNot(Receive())
+
+			By("Changing the named WorkloadEndpoints")
+			updateWEP(namespace1, "c1")
+			createWEP(namespace1, "c5")
+			deleteWEP(namespace1, "c2")
+			Expect(receiveKeys(w, 3)).To(Equal([]string{
+				"MODIFIED " + namespace1 + "/" + wepName("c1"),
+				"ADDED " + namespace1 + "/" + wepName("c5"),
+				"DELETED " + namespace1 + "/" + wepName("c2"),
+			}))
+		})
+
+		It("should watch the named WorkloadEndpoints across all namespaces", func() {
+			w, err := c.WorkloadEndpoints().Watch(ctx, options.ListOptions{
+				Names: []string{wepName("c1"), wepName("c3"), wepName("c5")},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			defer w.Stop()
+
+			Expect(receiveKeys(w, 3)).To(ConsistOf(
+				"ADDED "+namespace1+"/"+wepName("c1"),
+				"ADDED "+namespace1+"/"+wepName("c3"),
+				"ADDED "+namespace2+"/"+wepName("c1"),
+			))
+
+			updateWEP(namespace1, "c2")
+			updateWEP(namespace2, "c1")
+			Expect(receiveKeys(w, 1)).To(Equal([]string{"MODIFIED " + namespace2 + "/" + wepName("c1")}))
+		})
+
+		It("should watch a single named WorkloadEndpoint", func() {
+			w, err := c.WorkloadEndpoints().Watch(ctx, options.ListOptions{
+				Namespace: namespace1,
+				Names:     []string{wepName("c2")},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			defer w.Stop()
+
+			Expect(receiveKeys(w, 1)).To(Equal([]string{"ADDED " + namespace1 + "/" + wepName("c2")}))
+			updateWEP(namespace1, "c1")
+			deleteWEP(namespace1, "c2")
+			Expect(receiveKeys(w, 1)).To(Equal([]string{"DELETED " + namespace1 + "/" + wepName("c2")}))
+		})
+
+		It("should list only the named WorkloadEndpoints", func() {
+			list, err := c.WorkloadEndpoints().List(ctx, options.ListOptions{
+				Namespace: namespace1,
+				Names:     []string{wepName("c1"), wepName("c3"), wepName("c5")},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			var names []string
+			for _, wep := range list.Items {
+				names = append(names, wep.Name)
+			}
+			Expect(names).To(ConsistOf(wepName("c1"), wepName("c3")))
+		})
+
+		It("should reject Names combined with Name", func() {
+			_, err := c.WorkloadEndpoints().Watch(ctx, options.ListOptions{
+				Namespace: namespace1,
+				Name:      wepName("c1"),
+				Names:     []string{wepName("c2")},
+			})
+			Expect(err).To(BeAssignableToTypeOf(errors.ErrorValidation{}))
+		})
+	})
+
+	Describe("WorkloadEndpoint dry-run update", func() {
+		var (
+			c      clientv3.Interface
+			stored *libapiv3.WorkloadEndpoint
+		)
+
+		BeforeEach(func() {
+			var err error
+			c, err = clientv3.New(config)
+			Expect(err).NotTo(HaveOccurred())
+
+			be, err := backend.NewClient(config)
+			Expect(err).NotTo(HaveOccurred())
+			be.Clean()
+
+			spec := spec1_1
+			spec.IPNetworks = []string{"10.0.0.1/32"}
+			stored, err = c.WorkloadEndpoints().Create(ctx, &libapiv3.WorkloadEndpoint{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace1, Name: name1, Labels: map[string]string{"app": "foo", "tier": "web"}},
+				Spec:       spec,
+			}, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		expectUnchanged := func() {
+			wep, err := c.WorkloadEndpoints().Get(ctx, namespace1, name1, options.GetOptions{})
+			ExpectWithOffset(1, err).NotTo(HaveOccurred())
+			ExpectWithOffset(1, wep).To(Equal(stored))
+		}
+
+		It("should report the diff without writing", func() {
+			wep := stored.DeepCopy()
+			wep.Spec.InterfaceName = "cali12345"
+			wep.Spec.IPNetworks = []string{"10.0.0.2/32"}
+			wep.Labels = map[string]string{"app": "bar", "env": "prod"}
+
+			out, diff, err := c.WorkloadEndpoints().DryRunUpdate(ctx, wep, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(out.Spec).To(Equal(wep.Spec))
+			Expect(out.ResourceVersion).To(Equal(stored.ResourceVersion))
+			Expect(out.Labels).To(HaveKeyWithValue("env", "prod"))
+			Expect(diff.RevisionA).To(Equal(stored.ResourceVersion))
+			Expect(diff.ChangedSpecFields).To(Equal([]string{"IPNetworks", "InterfaceName"}))
+			Expect(diff.AddedIPNetworks).To(Equal([]string{"10.0.0.2/32"}))
+			Expect(diff.RemovedIPNetworks).To(Equal([]string{"10.0.0.1/32"}))
+			Expect(diff.AddedLabels).To(Equal(map[string]string{"env": "prod"}))
+			Expect(diff.RemovedLabels).To(Equal(map[string]string{"tier": "web"}))
+			Expect(diff.ChangedLabels).To(Equal(map[string]string{"app": "bar"}))
+			expectUnchanged()
+
+			By("Returning the would-be result from Update with the DryRun option")
+			out2, err := c.WorkloadEndpoints().Update(ctx, wep, options.SetOptions{DryRun: true})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(out2).To(Equal(out))
+			expectUnchanged()
+
+			By("Reporting an empty diff for an unchanged WorkloadEndpoint")
+			_, diff, err = c.WorkloadEndpoints().DryRunUpdate(ctx, stored.DeepCopy(), options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(diff.IsEmpty()).To(BeTrue())
+		})
+
+		It("should return the same validation errors as a real update", func() {
+			wep := stored.DeepCopy()
+			wep.Spec.InterfaceName = "bad/name"
+			_, err := c.WorkloadEndpoints().Update(ctx, wep, options.SetOptions{DryRun: true})
+			Expect(err).To(BeAssignableToTypeOf(errors.ErrorValidation{}))
+			_, realErr := c.WorkloadEndpoints().Update(ctx, wep, options.SetOptions{})
+			Expect(err).To(Equal(realErr))
+			expectUnchanged()
+
+			wep = stored.DeepCopy()
+			wep.ResourceVersion = ""
+			_, _, err = c.WorkloadEndpoints().DryRunUpdate(ctx, wep, options.SetOptions{})
+			Expect(err).To(BeAssignableToTypeOf(errors.ErrorValidation{}))
+			_, realErr = c.WorkloadEndpoints().Update(ctx, wep, options.SetOptions{})
+			Expect(err).To(Equal(realErr))
+		})
+
+		It("should return the same conflict and not-found errors as a real update", func() {
+			wep := stored.DeepCopy()
+			wep.Spec.InterfaceName = "cali12345"
+			_, err := c.WorkloadEndpoints().Update(ctx, wep.DeepCopy(), options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			By("Dry-running an update with a stale resource version")
+			out, _, err := c.WorkloadEndpoints().DryRunUpdate(ctx, wep.DeepCopy(), options.SetOptions{})
+			Expect(err).To(BeAssignableToTypeOf(errors.ErrorResourceUpdateConflict{}))
+			Expect(out.Spec.InterfaceName).To(Equal("cali12345"))
+			_, realErr := c.WorkloadEndpoints().Update(ctx, wep.DeepCopy(), options.SetOptions{})
+			Expect(err).To(Equal(realErr))
+
+			By("Dry-running an update of a deleted WorkloadEndpoint")
+			_, err = c.WorkloadEndpoints().Delete(ctx, namespace1, name1, options.DeleteOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			_, _, err = c.WorkloadEndpoints().DryRunUpdate(ctx, wep.DeepCopy(), options.SetOptions{})
+			Expect(err).To(BeAssignableToTypeOf(errors.ErrorResourceDoesNotExist{}))
+			_, realErr = c.WorkloadEndpoints().Update(ctx, wep.DeepCopy(), options.SetOptions{})
+			Expect(err).To(Equal(realErr))
+		})
+	})
+
+	Describe("WorkloadEndpoint dry-run create", func() {
+		var c clientv3.Interface
+
+		BeforeEach(func() {
+			var err error
+			c, err = clientv3.New(config)
+			Expect(err).NotTo(HaveOccurred())
+
+			be, err := backend.NewClient(config)
+			Expect(err).NotTo(HaveOccurred())
+			be.Clean()
+		})
+
+		newWEP := func() *libapiv3.WorkloadEndpoint {
+			return &libapiv3.WorkloadEndpoint{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace1, Name: name1},
+				Spec:       spec1_1,
+			}
+		}
+
+		expectNoWEPs := func() {
+			list, err := c.WorkloadEndpoints().List(ctx, options.ListOptions{})
+			ExpectWithOffset(1, err).NotTo(HaveOccurred())
+			ExpectWithOffset(1, list.Items).To(BeEmpty())
+		}
+
+		It("should return the WorkloadEndpoint that would be created without writing it", func() {
+			w, err := c.WorkloadEndpoints().Watch(ctx, options.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			testWatcher := testutils.NewTestResourceWatch(config.Spec.DatastoreType, w)
+			defer testWatcher.Stop()
+
+			wep := newWEP()
+			wepCopy := wep.DeepCopy()
+			out, err := c.WorkloadEndpoints().Create(ctx, wep, options.SetOptions{DryRun: true})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(wep).To(Equal(wepCopy), "Create() unexpectedly modified input")
+			Expect(out.Namespace).To(Equal(namespace1))
+			Expect(out.Name).To(Equal(name1))
+			Expect(out.Spec).To(Equal(spec1_1))
+			Expect(out.ResourceVersion).To(BeEmpty())
+			Expect(out.UID).NotTo(BeEmpty())
+			Expect(out.CreationTimestamp.IsZero()).To(BeFalse())
+			Expect(out.Labels[apiv3.LabelOrchestrator]).To(Equal(spec1_1.Orchestrator))
+			Expect(out.Labels[apiv3.LabelNamespace]).To(Equal(namespace1))
+			expectNoWEPs()
+
+			By("Creating the WorkloadEndpoint for real, which should be the only watch event")
+			created, err := c.WorkloadEndpoints().Create(ctx, newWEP(), options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			testWatcher.ExpectEvents(libapiv3.KindWorkloadEndpoint, []watch.Event{
+				{
+					Type:   watch.Added,
+					Object: created,
+				},
+			})
+		})
+
+		It("should return the same validation errors as a real create", func() {
+			wep := newWEP()
+			wep.Spec.InterfaceName = "bad/name"
+			_, err := c.WorkloadEndpoints().Create(ctx, wep, options.SetOptions{DryRun: true})
+			Expect(err).To(BeAssignableToTypeOf(errors.ErrorValidation{}))
+			_, realErr := c.WorkloadEndpoints().Create(ctx, wep, options.SetOptions{})
+			Expect(err).To(Equal(realErr))
+			expectNoWEPs()
+
+			wep = newWEP()
+			wep.ResourceVersion = "1234"
+			_, err = c.WorkloadEndpoints().Create(ctx, wep, options.SetOptions{DryRun: true})
+			Expect(err).To(BeAssignableToTypeOf(errors.ErrorValidation{}))
+			_, realErr = c.WorkloadEndpoints().Create(ctx, wep, options.SetOptions{})
+			Expect(err).To(Equal(realErr))
+			expectNoWEPs()
+		})
+
+		It("should return the same error as a real create if the WorkloadEndpoint exists", func() {
+			stored, err := c.WorkloadEndpoints().Create(ctx, newWEP(), options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			out, err := c.WorkloadEndpoints().Create(ctx, newWEP(), options.SetOptions{DryRun: true})
+			Expect(err).To(BeAssignableToTypeOf(errors.ErrorResourceAlreadyExists{}))
+			Expect(out).To(Equal(stored))
+			_, realErr := c.WorkloadEndpoints().Create(ctx, newWEP(), options.SetOptions{})
+			Expect(err).To(Equal(realErr))
+		})
+
+		It("should reject a dry run for other resource kinds without writing", func() {
+			_, err := c.Profiles().Create(ctx, &apiv3.Profile{
+				ObjectMeta: metav1.ObjectMeta{Name: "profile1"},
+			}, options.SetOptions{DryRun: true})
+			Expect(err).To(BeAssignableToTypeOf(errors.ErrorOperationNotSupported{}))
+			_, err = c.Profiles().Get(ctx, "profile1", options.GetOptions{})
+			Expect(err).To(BeAssignableToTypeOf(errors.ErrorResourceDoesNotExist{}))
+		})
+	})
+
+	Describe("WorkloadEndpoint creation timestamps", func() {
+		var c clientv3.Interface
+
+		wepName := func(cid string) string {
+			return "node--2-cni-" + cid + "-eth0"
+		}
+
+		newWEP := func(cid string) *libapiv3.WorkloadEndpoint {
+			spec := spec2_1
+			spec.ContainerID = cid
+			return &libapiv3.WorkloadEndpoint{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace1, Name: wepName(cid)},
+				Spec:       spec,
+			}
+		}
+
+		BeforeEach(func() {
+			var err error
+			c, err = clientv3.New(config)
+			Expect(err).NotTo(HaveOccurred())
+
+			be, err := backend.NewClient(config)
+			Expect(err).NotTo(HaveOccurred())
+			be.Clean()
+		})
+
+		It("should assign the creation timestamp on create and preserve it across updates", func() {
+			start := time.Now().Add(-time.Second)
+			wep := newWEP("c1")
+			wep.CreationTimestamp = metav1.NewTime(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC))
+			created, err := c.WorkloadEndpoints().Create(ctx, wep, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(created.CreationTimestamp.Time).To(BeTemporally(">=", start))
+			Expect(created.CreationTimestamp.Time).To(BeTemporally("<=", time.Now()))
+
+			By("Updating with a different creation timestamp")
+			update := created.DeepCopy()
+			update.Spec.InterfaceName = "caliupdated"
+			update.CreationTimestamp = metav1.NewTime(created.CreationTimestamp.Add(time.Hour))
+			updated, err := c.WorkloadEndpoints().Update(ctx, update, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(updated.Spec.InterfaceName).To(Equal("caliupdated"))
+			Expect(updated.CreationTimestamp).To(Equal(created.CreationTimestamp))
+
+			got, err := c.WorkloadEndpoints().Get(ctx, namespace1, wepName("c1"), options.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(got.CreationTimestamp).To(Equal(created.CreationTimestamp))
+
+			By("Dry-running an update with a different creation timestamp")
+			update = got.DeepCopy()
+			update.CreationTimestamp = metav1.NewTime(created.CreationTimestamp.Add(-time.Hour))
+			out, diff, err := c.WorkloadEndpoints().DryRunUpdate(ctx, update, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(out.CreationTimestamp).To(Equal(created.CreationTimestamp))
+			Expect(diff.IsEmpty()).To(BeTrue())
+		})
+
+		It("should allow WorkloadEndpoints to be sorted and filtered by creation time", func() {
+			// Creation timestamps have a resolution of one second.
+			cids := []string{"c3", "c1", "c2"}
+			var created []*libapiv3.WorkloadEndpoint
+			for i, cid := range cids {
+				if i > 0 {
+					time.Sleep(1100 * time.Millisecond)
+				}
+				wep, err := c.WorkloadEndpoints().Create(ctx, newWEP(cid), options.SetOptions{})
+				Expect(err).NotTo(HaveOccurred())
+				created = append(created, wep)
+			}
+
+			list, err := c.WorkloadEndpoints().List(ctx, options.ListOptions{Namespace: namespace1})
+			Expect(err).NotTo(HaveOccurred())
+			sort.Slice(list.Items, func(i, j int) bool {
+				return list.Items[i].CreationTimestamp.Before(&list.Items[j].CreationTimestamp)
+			})
+			var names []string
+			for _, wep := range list.Items {
+				names = append(names, wep.Name)
+			}
+			Expect(names).To(Equal([]string{wepName("c3"), wepName("c1"), wepName("c2")}))
+
+			By("Filtering the WorkloadEndpoints created before the last one")
+			cutoff := created[2].CreationTimestamp
+			var older []string
+			for _, wep := range list.Items {
+				if wep.CreationTimestamp.Before(&cutoff) {
+					older = append(older, wep.Name)
+				}
+			}
+			Expect(older).To(Equal([]string{wepName("c3"), wepName("c1")}))
+		})
+	})
+
+	Describe("WorkloadEndpoint DeleteCollection", func() {
+		var c clientv3.Interface
+
+		wepName := func(cid string) string {
+			return "node--2-cni-" + cid + "-eth0"
+		}
+
+		createWEP := func(cid string, labels map[string]string) {
+			spec := spec2_1
+			spec.ContainerID = cid
+			_, err := c.WorkloadEndpoints().Create(ctx, &libapiv3.WorkloadEndpoint{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace1, Name: wepName(cid), Labels: labels},
+				Spec:       spec,
+			}, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		listedNames := func(weps []libapiv3.WorkloadEndpoint) []string {
+			var names []string
+			for _, wep := range weps {
+				names = append(names, wep.Name)
+			}
+			sort.Strings(names)
+			return names
+		}
+
+		BeforeEach(func() {
+			var err error
+			c, err = clientv3.New(config)
+			Expect(err).NotTo(HaveOccurred())
+
+			be, err := backend.NewClient(config)
+			Expect(err).NotTo(HaveOccurred())
+			be.Clean()
+		})
+
+		It("should delete only the WorkloadEndpoints that match the selector", func() {
+			createWEP("evicted1", map[string]string{"evicted": "true"})
+			createWEP("evicted2", map[string]string{"evicted": "true", "app": "web"})
+			createWEP("running", map[string]string{"evicted": "false"})
+			createWEP("unlabeled", nil)
+
+			By("Listing with the selector")
+			list, err := c.WorkloadEndpoints().List(ctx, options.ListOptions{LabelSelector: "evicted == 'true'"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(listedNames(list.Items)).To(Equal([]string{wepName("evicted1"), wepName("evicted2")}))
+
+			By("Deleting the collection")
+			deleted, err := c.WorkloadEndpoints().DeleteCollection(ctx, options.ListOptions{LabelSelector: "evicted == 'true'"}, options.DeleteOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(listedNames(deleted)).To(Equal([]string{wepName("evicted1"), wepName("evicted2")}))
+
+			list, err = c.WorkloadEndpoints().List(ctx, options.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(listedNames(list.Items)).To(Equal([]string{wepName("running"), wepName("unlabeled")}))
+
+			By("Deleting the collection again")
+			deleted, err = c.WorkloadEndpoints().DeleteCollection(ctx, options.ListOptions{LabelSelector: "evicted == 'true'"}, options.DeleteOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(deleted).To(BeEmpty())
+		})
+
+		It("should honor the namespace in the list options", func() {
+			createWEP("evicted1", map[string]string{"evicted": "true"})
+
+			deleted, err := c.WorkloadEndpoints().DeleteCollection(ctx, options.ListOptions{
+				Namespace:     namespace2,
+				LabelSelector: "has(evicted)",
+			}, options.DeleteOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(deleted).To(BeEmpty())
+
+			_, err = c.WorkloadEndpoints().Get(ctx, namespace1, wepName("evicted1"), options.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should delete matches in multiple batches", func() {
+			var expected []string
+			for i := 0; i < 130; i++ {
+				cid := fmt.Sprintf("batch%d", i)
+				createWEP(cid, map[string]string{"evicted": "true"})
+				expected = append(expected, wepName(cid))
+			}
+			createWEP("running", map[string]string{"evicted": "false"})
+			sort.Strings(expected)
+
+			deleted, err := c.WorkloadEndpoints().DeleteCollection(ctx, options.ListOptions{LabelSelector: "evicted == 'true'"}, options.DeleteOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(listedNames(deleted)).To(Equal(expected))
+
+			list, err := c.WorkloadEndpoints().List(ctx, options.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(listedNames(list.Items)).To(Equal([]string{wepName("running")}))
+		})
+
+		It("should delete all the WorkloadEndpoints with a name prefix, across namespaces", func() {
+			createWEP("aaaa", nil)
+			createWEP("bbbb", map[string]string{"app": "web"})
+			for _, cid := range []string{"cccc", "dddd"} {
+				spec := spec2_1
+				spec.ContainerID = cid
+				_, err := c.WorkloadEndpoints().Create(ctx, &libapiv3.WorkloadEndpoint{
+					ObjectMeta: metav1.ObjectMeta{Namespace: namespace2, Name: wepName(cid)},
+					Spec:       spec,
+				}, options.SetOptions{})
+				Expect(err).NotTo(HaveOccurred())
+			}
+			// A WorkloadEndpoint on another node, which doesn't match the prefix.
+			_, err := c.WorkloadEndpoints().Create(ctx, &libapiv3.WorkloadEndpoint{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace1, Name: name1},
+				Spec:       spec1_1,
+			}, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			deleted, err := c.WorkloadEndpoints().DeleteCollection(ctx,
+				options.ListOptions{Name: "node--2-", Prefix: true}, options.DeleteOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(listedNames(deleted)).To(Equal([]string{
+				wepName("aaaa"), wepName("bbbb"), wepName("cccc"), wepName("dddd"),
+			}))
+
+			list, err := c.WorkloadEndpoints().List(ctx, options.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(listedNames(list.Items)).To(Equal([]string{name1}))
+		})
+
+		It("should delete all the WorkloadEndpoints in a namespace", func() {
+			createWEP("aaaa", nil)
+			spec := spec2_1
+			spec.ContainerID = "bbbb"
+			_, err := c.WorkloadEndpoints().Create(ctx, &libapiv3.WorkloadEndpoint{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace2, Name: wepName("bbbb")},
+				Spec:       spec,
+			}, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			deleted, err := c.WorkloadEndpoints().DeleteCollection(ctx,
+				options.ListOptions{Namespace: namespace2}, options.DeleteOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(listedNames(deleted)).To(Equal([]string{wepName("bbbb")}))
+
+			_, err = c.WorkloadEndpoints().Get(ctx, namespace1, wepName("aaaa"), options.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should carry on after failing to delete a WorkloadEndpoint and aggregate the errors", func() {
+			for _, cid := range []string{"aaaa", "bbbb", "cccc"} {
+				createWEP(cid, nil)
+			}
+			limited, err := clientv3.New(config, clientv3.WithWorkloadEndpointRateLimits(
+				clientv3.RateLimit{}, clientv3.RateLimit{QPS: 0.001, Burst: 1}))
+			Expect(err).NotTo(HaveOccurred())
+			// Use up the only write token, so that every delete is rejected.
+			_, err = limited.WorkloadEndpoints().Delete(ctx, namespace1, wepName("missing"), options.DeleteOptions{})
+			Expect(err).To(BeAssignableToTypeOf(errors.ErrorResourceDoesNotExist{}))
+
+			deleted, err := limited.WorkloadEndpoints().DeleteCollection(ctx,
+				options.ListOptions{Namespace: namespace1}, options.DeleteOptions{})
+			Expect(deleted).To(BeEmpty())
+			Expect(err).To(BeAssignableToTypeOf(errors.ErrorAggregate{}))
+			aggErr := err.(errors.ErrorAggregate)
+			Expect(aggErr.Errors).To(HaveLen(3))
+			for _, e := range aggErr.Errors {
+				Expect(e).To(BeAssignableToTypeOf(errors.ErrorRateLimited{}))
+			}
+
+			list, err := c.WorkloadEndpoints().List(ctx, options.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(list.Items).To(HaveLen(3))
+		})
+
+		It("should reject a missing or invalid selector", func() {
+			createWEP("unlabeled", nil)
+
+			_, err := c.WorkloadEndpoints().DeleteCollection(ctx, options.ListOptions{}, options.DeleteOptions{})
+			Expect(err).To(BeAssignableToTypeOf(errors.ErrorValidation{}))
+
+			_, err = c.WorkloadEndpoints().DeleteCollection(ctx, options.ListOptions{LabelSelector: "evicted in (true"}, options.DeleteOptions{})
+			Expect(err).To(BeAssignableToTypeOf(errors.ErrorValidation{}))
+
+			_, err = c.WorkloadEndpoints().DeleteCollection(ctx, options.ListOptions{LabelSelector: "all()"},
+				options.DeleteOptions{ResourceVersion: "1234"})
+			Expect(err).To(BeAssignableToTypeOf(errors.ErrorValidation{}))
+
+			_, err = c.WorkloadEndpoints().List(ctx, options.ListOptions{LabelSelector: "evicted in (true"})
+			Expect(err).To(BeAssignableToTypeOf(errors.ErrorValidation{}))
+
+			_, err = c.WorkloadEndpoints().Get(ctx, namespace1, wepName("unlabeled"), options.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	Describe("WorkloadEndpoint List with a label selector", func() {
+		var c clientv3.Interface
+
+		wepName := func(cid string) string {
+			return "node--2-cni-" + cid + "-eth0"
+		}
+
+		listedNames := func(list *libapiv3.WorkloadEndpointList) []string {
+			var names []string
+			for _, wep := range list.Items {
+				names = append(names, wep.Name)
+			}
+			sort.Strings(names)
+			return names
+		}
+
+		BeforeEach(func() {
+			var err error
+			c, err = clientv3.New(config)
+			Expect(err).NotTo(HaveOccurred())
+
+			be, err := backend.NewClient(config)
+			Expect(err).NotTo(HaveOccurred())
+			be.Clean()
+
+			for cid, labels := range map[string]map[string]string{
+				"web1":      {"app": "web", "tier": "frontend"},
+				"web2":      {"app": "web", "tier": "frontend", "canary": "true"},
+				"db":        {"app": "db", "tier": "backend"},
+				"cache":     {"app": "cache", "tier": "backend"},
+				"unlabeled": nil,
+			} {
+				spec := spec2_1
+				spec.ContainerID = cid
+				_, err := c.WorkloadEndpoints().Create(ctx, &libapiv3.WorkloadEndpoint{
+					ObjectMeta: metav1.ObjectMeta{Namespace: namespace1, Name: wepName(cid), Labels: labels},
+					Spec:       spec,
+				}, options.SetOptions{})
+				Expect(err).NotTo(HaveOccurred())
+			}
+		})
+
+		DescribeTable("should list only the WorkloadEndpoints that match the selector",
+			func(selector string, expectedCIDs []string) {
+				var expected []string
+				for _, cid := range expectedCIDs {
+					expected = append(expected, wepName(cid))
+				}
+				sort.Strings(expected)
+
+				list, err := c.WorkloadEndpoints().List(ctx, options.ListOptions{LabelSelector: selector})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(listedNames(list)).To(Equal(expected))
+			},
+			Entry("equality", "app=web", []string{"web1", "web2"}),
+			Entry("double equals", "tier==backend", []string{"db", "cache"}),
+			Entry("inequality", "app!=web", []string{"db", "cache", "unlabeled"}),
+			Entry("multiple requirements", "app=web,!canary", []string{"web1"}),
+			Entry("set-based in", "app in (db,cache)", []string{"db", "cache"}),
+			Entry("set-based notin", "app notin (web,db)", []string{"cache", "unlabeled"}),
+			Entry("exists", "canary", []string{"web2"}),
+			Entry("no matches", "app=none", nil),
+			Entry("Calico selector", "tier == 'backend' && app != 'db'", []string{"cache"}),
+		)
+
+		It("should combine the selector with the other list options", func() {
+			list, err := c.WorkloadEndpoints().List(ctx, options.ListOptions{
+				LabelSelector: "tier=frontend",
+				Names:         []string{wepName("web1"), wepName("db")},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(listedNames(list)).To(Equal([]string{wepName("web1")}))
+
+			list, err = c.WorkloadEndpoints().List(ctx, options.ListOptions{
+				LabelSelector: "tier=frontend",
+				Namespace:     namespace2,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(list.Items).To(BeEmpty())
+		})
+	})
+
+	Describe("WorkloadEndpoint List with a field selector", func() {
+		var (
+			c       clientv3.Interface
+			created map[string]string
+		)
+
+		BeforeEach(func() {
+			var err error
+			c, err = clientv3.New(config)
+			Expect(err).NotTo(HaveOccurred())
+
+			be, err := backend.NewClient(config)
+			Expect(err).NotTo(HaveOccurred())
+			be.Clean()
+
+			// Endpoints on node-1 and node-2, in both namespaces, keyed by "<namespace>/<pod>".
+			created = map[string]string{}
+			for _, ns := range []string{namespace1, namespace2} {
+				for _, node := range []string{"node-1", "node-2"} {
+					for _, orch := range []string{"k8s", "cni"} {
+						spec := spec1_1
+						spec.Node = node
+						spec.Orchestrator = orch
+						spec.Pod = ""
+						spec.ContainerID = ""
+						if orch == "k8s" {
+							spec.Pod = "pod-" + node
+						} else {
+							spec.ContainerID = "cid-" + node
+						}
+						wep, err := c.WorkloadEndpoints().Create(ctx, &libapiv3.WorkloadEndpoint{
+							ObjectMeta: metav1.ObjectMeta{Namespace: ns},
+							Spec:       spec,
+						}, options.SetOptions{})
+						Expect(err).NotTo(HaveOccurred())
+						created[ns+"/"+wep.Name] = node + "/" + orch
+					}
+				}
+			}
+		})
+
+		listed := func(list *libapiv3.WorkloadEndpointList) []string {
+			var keys []string
+			for _, wep := range list.Items {
+				keys = append(keys, wep.Namespace+"/"+wep.Name)
+			}
+			sort.Strings(keys)
+			return keys
+		}
+
+		expected := func(match func(node, orch string) bool) []string {
+			var keys []string
+			for key, nodeOrch := range created {
+				parts := strings.SplitN(nodeOrch, "/", 2)
+				if match(parts[0], parts[1]) {
+					keys = append(keys, key)
+				}
+			}
+			sort.Strings(keys)
+			return keys
+		}
+
+		It("should list only the WorkloadEndpoints on the selected node, across all namespaces", func() {
+			list, err := c.WorkloadEndpoints().List(ctx, options.ListOptions{FieldSelector: "spec.node=node-1"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(list.Items).To(HaveLen(4))
+			Expect(listed(list)).To(Equal(expected(func(node, _ string) bool { return node == "node-1" })))
+		})
+
+		It("should combine field selector requirements and the other list options", func() {
+			list, err := c.WorkloadEndpoints().List(ctx, options.ListOptions{FieldSelector: "spec.node=node-2,spec.orchestrator!=k8s"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(listed(list)).To(Equal(expected(func(node, orch string) bool { return node == "node-2" && orch != "k8s" })))
+
+			list, err = c.WorkloadEndpoints().List(ctx, options.ListOptions{
+				FieldSelector: "spec.orchestrator=k8s",
+				Namespace:     namespace2,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(list.Items).To(HaveLen(2))
+			for _, wep := range list.Items {
+				Expect(wep.Namespace).To(Equal(namespace2))
+				Expect(wep.Spec.Orchestrator).To(Equal("k8s"))
+			}
+		})
+
+		It("should reject unsupported fields", func() {
+			_, err := c.WorkloadEndpoints().List(ctx, options.ListOptions{FieldSelector: "spec.interfaceName=cali09123"})
+			Expect(err).To(BeAssignableToTypeOf(errors.ErrorValidation{}))
+			Expect(err.Error()).To(ContainSubstring(`field "spec.interfaceName" is not selectable for WorkloadEndpoint`))
+		})
+
+		It("should reject field selectors for other resource kinds", func() {
+			_, err := c.HostEndpoints().List(ctx, options.ListOptions{FieldSelector: "spec.node=node-1"})
+			Expect(err).To(BeAssignableToTypeOf(errors.ErrorValidation{}))
+		})
+	})
+
+	Describe("WorkloadEndpoint Watch with a field selector", func() {
+		var c clientv3.Interface
+
+		BeforeEach(func() {
+			var err error
+			c, err = clientv3.New(config)
+			Expect(err).NotTo(HaveOccurred())
+
+			be, err := backend.NewClient(config)
+			Expect(err).NotTo(HaveOccurred())
+			be.Clean()
+		})
+
+		// createOnNode creates a WorkloadEndpoint for the container on the node.
+		createOnNode := func(ns, node, cid string) *libapiv3.WorkloadEndpoint {
+			spec := spec2_1
+			spec.Node = node
+			spec.ContainerID = cid
+			wep, err := c.WorkloadEndpoints().Create(ctx, &libapiv3.WorkloadEndpoint{
+				ObjectMeta: metav1.ObjectMeta{Namespace: ns},
+				Spec:       spec,
+			}, options.SetOptions{})
+			ExpectWithOffset(1, err).NotTo(HaveOccurred())
+			return wep
+		}
+
+		// nextEvents receives the next n events from the watcher, then checks that no more
+		// arrive, and returns the type, namespace and name of each.
+		nextEvents := func(w watch.Interface, n int) []string {
+			var events []string
+			for len(events) < n {
+				var e watch.Event
+				EventuallyWithOffset(1, w.ResultChan(), 5*time.Second).Should(Receive(&e))
+				obj := e.Object
+				if obj == nil {
+					obj = e.Previous
+				}
+				wep := obj.(*libapiv3.WorkloadEndpoint)
+				ExpectWithOffset(1, wep.Spec.Node).To(Equal("node-1"))
+				events = append(events, fmt.Sprintf("%s %s/%s", e.Type, wep.Namespace, wep.Spec.ContainerID))
+			}
+			ConsistentlyWithOffset(1, w.ResultChan(), 200*time.Millisecond).ShouldNot(Receive())
+			return events
+		}
+
+		It("should only report the WorkloadEndpoints on the selected node, across all namespaces", func() {
+			createOnNode(namespace1, "node-1", "a1")
+			a2 := createOnNode(namespace1, "node-2", "a2")
+			b1 := createOnNode(namespace2, "node-1", "b1")
+			createOnNode(namespace2, "node-2", "b2")
+
+			By("filtering the initial snapshot")
+			w, err := c.WorkloadEndpoints().Watch(ctx, options.ListOptions{FieldSelector: "spec.node=node-1"})
+			Expect(err).NotTo(HaveOccurred())
+			defer w.Stop()
+			events := nextEvents(w, 2)
+			sort.Strings(events)
+			Expect(events).To(Equal([]string{"ADDED namespace-1/a1", "ADDED namespace-2/b1"}))
+
+			By("filtering subsequent changes")
+			createOnNode(namespace2, "node-2", "c2")
+			c1 := createOnNode(namespace1, "node-1", "c1")
+			c1.Spec.InterfaceName = "cali5678"
+			_, err = c.WorkloadEndpoints().Update(ctx, c1, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			for _, wep := range []*libapiv3.WorkloadEndpoint{a2, b1} {
+				_, err = c.WorkloadEndpoints().Delete(ctx, wep.Namespace, wep.Name, options.DeleteOptions{})
+				Expect(err).NotTo(HaveOccurred())
+			}
+			Expect(nextEvents(w, 3)).To(Equal([]string{
+				"ADDED namespace-1/c1",
+				"MODIFIED namespace-1/c1",
+				"DELETED namespace-2/b1",
+			}))
+		})
+
+		It("should filter the events of a watch from a revision", func() {
+			list, err := c.WorkloadEndpoints().List(ctx, options.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			createOnNode(namespace1, "node-2", "a2")
+			createOnNode(namespace1, "node-1", "a1")
+
+			w, err := c.WorkloadEndpoints().Watch(ctx, options.ListOptions{
+				FieldSelector:   "spec.node=node-1",
+				ResourceVersion: list.ResourceVersion,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			defer w.Stop()
+			Expect(nextEvents(w, 1)).To(Equal([]string{"ADDED namespace-1/a1"}))
+		})
+
+		It("should reject unsupported fields", func() {
+			_, err := c.WorkloadEndpoints().Watch(ctx, options.ListOptions{FieldSelector: "spec.interfaceName=cali09123"})
+			Expect(err).To(BeAssignableToTypeOf(errors.ErrorValidation{}))
+		})
+	})
+
+	Describe("WorkloadEndpoint List pagination", func() {
+		var c clientv3.Interface
+		var expected []string
+
+		BeforeEach(func() {
+			var err error
+			c, err = clientv3.New(config)
+			Expect(err).NotTo(HaveOccurred())
+
+			be, err := backend.NewClient(config)
+			Expect(err).NotTo(HaveOccurred())
+			be.Clean()
+
+			// 25 WorkloadEndpoints, split between two namespaces.
+			expected = nil
+			for i := 0; i < 25; i++ {
+				ns := namespace1
+				if i%2 == 1 {
+					ns = namespace2
+				}
+				spec := spec2_1
+				spec.ContainerID = fmt.Sprintf("page%02d", i)
+				name := "node--2-cni-" + spec.ContainerID + "-eth0"
+				_, err := c.WorkloadEndpoints().Create(ctx, &libapiv3.WorkloadEndpoint{
+					ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: name},
+					Spec:       spec,
+				}, options.SetOptions{})
+				Expect(err).NotTo(HaveOccurred())
+				expected = append(expected, ns+"/"+name)
+			}
+			sort.Strings(expected)
+		})
+
+		It("should page through the WorkloadEndpoints without dropping or duplicating any", func() {
+			var listed []string
+			var pageSizes []int
+			var remaining []int64
+			opts := options.ListOptions{Limit: 10}
+			for {
+				list, err := c.WorkloadEndpoints().List(ctx, opts)
+				Expect(err).NotTo(HaveOccurred())
+				pageSizes = append(pageSizes, len(list.Items))
+				for _, wep := range list.Items {
+					listed = append(listed, wep.Namespace+"/"+wep.Name)
+				}
+				if list.Continue == "" {
+					Expect(list.RemainingItemCount).To(BeNil())
+					break
+				}
+				Expect(list.RemainingItemCount).NotTo(BeNil())
+				remaining = append(remaining, *list.RemainingItemCount)
+				Expect(len(pageSizes)).To(BeNumerically("<", 3), "Too many pages")
+				opts.Continue = list.Continue
+			}
+			Expect(pageSizes).To(Equal([]int{10, 10, 5}))
+			Expect(remaining).To(Equal([]int64{15, 5}))
+			Expect(listed).To(Equal(expected))
+		})
+
+		It("should resume after the previous page when WorkloadEndpoints are deleted between pages", func() {
+			list, err := c.WorkloadEndpoints().List(ctx, options.ListOptions{Limit: 10})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(list.Items).To(HaveLen(10))
+
+			// Deleting a WorkloadEndpoint from the first page doesn't affect the next one.
+			_, err = c.WorkloadEndpoints().Delete(ctx, list.Items[0].Namespace, list.Items[0].Name, options.DeleteOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			next, err := c.WorkloadEndpoints().List(ctx, options.ListOptions{Limit: 10, Continue: list.Continue})
+			Expect(err).NotTo(HaveOccurred())
+			var listed []string
+			for _, wep := range next.Items {
+				listed = append(listed, wep.Namespace+"/"+wep.Name)
+			}
+			Expect(listed).To(Equal(expected[10:20]))
+		})
+
+		It("should reject an invalid continue token or limit", func() {
+			_, err := c.WorkloadEndpoints().List(ctx, options.ListOptions{Limit: 10, Continue: "garbage"})
+			Expect(err).To(BeAssignableToTypeOf(errors.ErrorValidation{}))
+
+			_, err = c.WorkloadEndpoints().List(ctx, options.ListOptions{Limit: -1})
+			Expect(err).To(BeAssignableToTypeOf(errors.ErrorValidation{}))
+		})
+
+		It("should reject a limit or continue token when listing other kinds", func() {
+			_, err := c.HostEndpoints().List(ctx, options.ListOptions{Limit: 10})
+			Expect(err).To(BeAssignableToTypeOf(errors.ErrorOperationNotSupported{}))
+
+			_, err = c.HostEndpoints().List(ctx, options.ListOptions{Continue: "token"})
+			Expect(err).To(BeAssignableToTypeOf(errors.ErrorOperationNotSupported{}))
+		})
+	})
+
+	Describe("WorkloadEndpoint label test-and-set", func() {
+		var c clientv3.Interface
+
+		BeforeEach(func() {
+			var err error
+			c, err = clientv3.New(config)
+			Expect(err).NotTo(HaveOccurred())
+
+			be, err := backend.NewClient(config)
+			Expect(err).NotTo(HaveOccurred())
+			be.Clean()
+
+			_, err = c.WorkloadEndpoints().Create(ctx, &libapiv3.WorkloadEndpoint{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace1, Name: name1, Labels: map[string]string{"app": "foo"}},
+				Spec:       spec1_1,
+			}, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should set an absent label", func() {
+			out, applied, err := c.WorkloadEndpoints().SetLabelIfAbsent(ctx, namespace1, name1, "lock", "holder-a", options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(applied).To(BeTrue())
+			Expect(out.Labels).To(HaveKeyWithValue("lock", "holder-a"))
+			Expect(out.Labels).To(HaveKeyWithValue("app", "foo"))
+
+			By("setting it again with the same value")
+			again, applied, err := c.WorkloadEndpoints().SetLabelIfAbsent(ctx, namespace1, name1, "lock", "holder-a", options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(applied).To(BeTrue())
+			Expect(again.ResourceVersion).To(Equal(out.ResourceVersion))
+		})
+
+		It("should not overwrite a label that is already present", func() {
+			_, applied, err := c.WorkloadEndpoints().SetLabelIfAbsent(ctx, namespace1, name1, "lock", "holder-a", options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(applied).To(BeTrue())
+
+			out, applied, err := c.WorkloadEndpoints().SetLabelIfAbsent(ctx, namespace1, name1, "lock", "holder-b", options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(applied).To(BeFalse())
+			Expect(out.Labels).To(HaveKeyWithValue("lock", "holder-a"))
+
+			got, err := c.WorkloadEndpoints().Get(ctx, namespace1, name1, options.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(got.Labels).To(HaveKeyWithValue("lock", "holder-a"))
+		})
+
+		It("should let exactly one of several concurrent callers claim the label", func() {
+			var wg sync.WaitGroup
+			var numApplied int32
+			for i := 0; i < 5; i++ {
+				wg.Add(1)
+				go func(holder string) {
+					defer GinkgoRecover()
+					defer wg.Done()
+					_, applied, err := c.WorkloadEndpoints().SetLabelIfAbsent(ctx, namespace1, name1, "lock", holder, options.SetOptions{})
+					Expect(err).NotTo(HaveOccurred())
+					if applied {
+						atomic.AddInt32(&numApplied, 1)
+					}
+				}(fmt.Sprintf("holder-%d", i))
+			}
+			wg.Wait()
+			Expect(numApplied).To(BeEquivalentTo(1))
+		})
+
+		It("should clear a label only if it has the expected value", func() {
+			_, _, err := c.WorkloadEndpoints().SetLabelIfAbsent(ctx, namespace1, name1, "lock", "holder-a", options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			out, cleared, err := c.WorkloadEndpoints().ClearLabel(ctx, namespace1, name1, "lock", "holder-b", options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cleared).To(BeFalse())
+			Expect(out.Labels).To(HaveKeyWithValue("lock", "holder-a"))
+
+			out, cleared, err = c.WorkloadEndpoints().ClearLabel(ctx, namespace1, name1, "lock", "holder-a", options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cleared).To(BeTrue())
+			Expect(out.Labels).NotTo(HaveKey("lock"))
+			Expect(out.Labels).To(HaveKeyWithValue("app", "foo"))
+
+			By("clearing an absent label")
+			_, cleared, err = c.WorkloadEndpoints().ClearLabel(ctx, namespace1, name1, "lock", "", options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cleared).To(BeTrue())
+
+			By("claiming the released label")
+			_, applied, err := c.WorkloadEndpoints().SetLabelIfAbsent(ctx, namespace1, name1, "lock", "holder-b", options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(applied).To(BeTrue())
+		})
+
+		It("should clear a label with any value if no value is expected", func() {
+			_, _, err := c.WorkloadEndpoints().SetLabelIfAbsent(ctx, namespace1, name1, "lock", "holder-a", options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			out, cleared, err := c.WorkloadEndpoints().ClearLabel(ctx, namespace1, name1, "lock", "", options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cleared).To(BeTrue())
+			Expect(out.Labels).NotTo(HaveKey("lock"))
+		})
+
+		It("should reject an empty key and report a missing WorkloadEndpoint", func() {
+			_, _, err := c.WorkloadEndpoints().SetLabelIfAbsent(ctx, namespace1, name1, "", "holder-a", options.SetOptions{})
+			Expect(err).To(BeAssignableToTypeOf(errors.ErrorValidation{}))
+
+			_, _, err = c.WorkloadEndpoints().SetLabelIfAbsent(ctx, namespace1, name2, "lock", "holder-a", options.SetOptions{})
+			Expect(err).To(BeAssignableToTypeOf(errors.ErrorResourceDoesNotExist{}))
+		})
+	})
+
+	Describe("WorkloadEndpoint cache verification", func() {
+		var (
+			c     clientv3.Interface
+			cache *clientv3.WorkloadEndpointCache
+			w     watch.Interface
+			name3 = "node--2-cni-c3-eth0"
+		)
+
+		receive := func(n int) (events []watch.Event) {
+			for len(events) < n {
+				select {
+				case e := <-w.ResultChan():
+					Expect(e.Type).NotTo(Equal(watch.Error))
+					events = append(events, e)
+				case <-time.After(5 * time.Second):
+					Fail(fmt.Sprintf("Timed out waiting for watch events, got %v", events))
+				}
+			}
+			return
+		}
+
+		BeforeEach(func() {
+			var err error
+			c, err = clientv3.New(config)
+			Expect(err).NotTo(HaveOccurred())
+
+			be, err := backend.NewClient(config)
+			Expect(err).NotTo(HaveOccurred())
+			be.Clean()
+
+			_, err = c.WorkloadEndpoints().Create(ctx, &libapiv3.WorkloadEndpoint{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace1, Name: name1, Labels: map[string]string{"app": "foo"}},
+				Spec:       spec1_1,
+			}, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			_, err = c.WorkloadEndpoints().Create(ctx, &libapiv3.WorkloadEndpoint{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace2, Name: name2},
+				Spec:       spec2_1,
+			}, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			list, err := c.WorkloadEndpoints().List(ctx, options.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			cache = clientv3.NewWorkloadEndpointCache(list)
+			Expect(cache.Len()).To(Equal(2))
+			w, err = c.WorkloadEndpoints().Watch(ctx, options.ListOptions{ResourceVersion: list.ResourceVersion})
+			Expect(err).NotTo(HaveOccurred())
+
+			By("Creating, updating and deleting WorkloadEndpoints")
+			spec := spec2_1
+			spec.ContainerID = "c3"
+			_, err = c.WorkloadEndpoints().Create(ctx, &libapiv3.WorkloadEndpoint{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace1, Name: name3},
+				Spec:       spec,
+			}, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			wep, err := c.WorkloadEndpoints().Get(ctx, namespace1, name1, options.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			wep.Labels["app"] = "bar"
+			_, err = c.WorkloadEndpoints().Update(ctx, wep, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			_, err = c.WorkloadEndpoints().Delete(ctx, namespace2, name2, options.DeleteOptions{})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			w.Stop()
+		})
+
+		It("should report no divergence for a cache maintained from the watch", func() {
+			for _, e := range receive(3) {
+				cache.Apply(e)
+			}
+			Expect(cache.Len()).To(Equal(2))
+			Expect(cache.Get(namespace1, name1).Labels).To(HaveKeyWithValue("app", "bar"))
+			Expect(cache.Get(namespace2, name2)).To(BeNil())
+
+			div, err := c.WorkloadEndpoints().VerifyCache(ctx, cache, options.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(div.IsEmpty()).To(BeTrue(), fmt.Sprintf("Unexpected divergence: %+v", div))
+		})
+
+		It("should verify the cache at each step of the watch", func() {
+			for _, e := range receive(3) {
+				cache.Apply(e)
+				div, err := c.WorkloadEndpoints().VerifyCache(ctx, cache, options.ListOptions{})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(div.IsEmpty()).To(BeTrue(), fmt.Sprintf("Unexpected divergence after %v: %+v", e.Type, div))
+			}
+		})
+
+		It("should detect missing, extra and stale WorkloadEndpoints", func() {
+			events := receive(3)
+			Expect(events[0].Type).To(Equal(watch.Added))
+			Expect(events[1].Type).To(Equal(watch.Modified))
+			Expect(events[2].Type).To(Equal(watch.Deleted))
+
+			By("Skipping the Added event")
+			cache.Apply(events[1])
+
+			// The bogus events claim the revision of the Modified event so they must be applied
+			// before the (later) deletion.
+			By("Applying a bogus Added event")
+			ghost := events[0].Object.(*libapiv3.WorkloadEndpoint).DeepCopy()
+			ghost.Name = "node--2-cni-ghost-eth0"
+			ghost.ResourceVersion = cache.ResourceVersion()
+			cache.Apply(watch.Event{Type: watch.Added, Object: ghost})
+
+			By("Applying a bogus Modified event")
+			stale := events[1].Object.(*libapiv3.WorkloadEndpoint).DeepCopy()
+			stale.Labels["app"] = "baz"
+			cache.Apply(watch.Event{Type: watch.Modified, Previous: events[1].Object, Object: stale})
+			cache.Apply(events[2])
+
+			div, err := c.WorkloadEndpoints().VerifyCache(ctx, cache, options.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(div.IsEmpty()).To(BeFalse())
+			Expect(div.ResourceVersion).To(Equal(cache.ResourceVersion()))
+			Expect(div.Missing).To(Equal([]string{namespace1 + "/" + name3}))
+			Expect(div.Extra).To(Equal([]string{namespace1 + "/node--2-cni-ghost-eth0"}))
+			Expect(div.Stale).To(HaveLen(1))
+			Expect(div.Stale[0].Name).To(Equal(name1))
+			Expect(div.Stale[0].ChangedLabels).To(Equal(map[string]string{"app": "bar"}))
+		})
+
+		It("should detect a missed deletion", func() {
+			events := receive(3)
+			cache.Apply(events[0])
+			cache.Apply(events[1])
+
+			div, err := c.WorkloadEndpoints().VerifyCache(ctx, cache, options.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(div.IsEmpty()).To(BeTrue(), "deletion is after the cache's revision")
+
+			By("Applying an update after the deletion")
+			wep, err := c.WorkloadEndpoints().Get(ctx, namespace1, name3, options.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			wep.Labels = map[string]string{"app": "qux"}
+			_, err = c.WorkloadEndpoints().Update(ctx, wep, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			cache.Apply(receive(1)[0])
+
+			div, err = c.WorkloadEndpoints().VerifyCache(ctx, cache, options.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(div.Extra).To(Equal([]string{namespace2 + "/" + name2}))
+			Expect(div.Missing).To(BeEmpty())
+			Expect(div.Stale).To(BeEmpty())
+		})
+	})
+
+	Describe("WorkloadEndpoint lifecycle hooks", func() {
+		var (
+			c     clientv3.Interface
+			hooks *recordingHooks
+		)
+
+		BeforeEach(func() {
+			hooks = &recordingHooks{}
+			var err error
+			c, err = clientv3.New(config, clientv3.WithWorkloadEndpointHooks(hooks))
+			Expect(err).NotTo(HaveOccurred())
+
+			be, err := backend.NewClient(config)
+			Expect(err).NotTo(HaveOccurred())
+			be.Clean()
+		})
+
+		It("should call each hook exactly once with the before and after state", func() {
+			created, err := c.WorkloadEndpoints().Create(ctx, &libapiv3.WorkloadEndpoint{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace1, Name: name1, Labels: map[string]string{"app": "foo"}},
+				Spec:       spec1_1,
+			}, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(hooks.calls).To(Equal([]hookCall{{Op: "create", After: created}}))
+
+			hooks.calls = nil
+			update := created.DeepCopy()
+			update.Labels["app"] = "bar"
+			updated, err := c.WorkloadEndpoints().Update(ctx, update, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(hooks.calls).To(Equal([]hookCall{{Op: "update", Before: created, After: updated}}))
+
+			hooks.calls = nil
+			deleted, err := c.WorkloadEndpoints().Delete(ctx, namespace1, name1, options.DeleteOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(hooks.calls).To(Equal([]hookCall{{Op: "delete", Before: deleted}}))
+			Expect(deleted.Labels).To(HaveKeyWithValue("app", "bar"))
+		})
+
+		It("should call the update hook for helper methods that update", func() {
+			created, err := c.WorkloadEndpoints().Create(ctx, &libapiv3.WorkloadEndpoint{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace1, Name: name1},
+				Spec:       spec1_1,
+			}, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			hooks.calls = nil
+			updated, err := c.WorkloadEndpoints().AddIP(ctx, namespace1, name1, "10.0.0.100", options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(hooks.calls).To(HaveLen(1))
+			Expect(hooks.calls[0].Op).To(Equal("update"))
+			Expect(hooks.calls[0].Before.Spec.IPNetworks).To(Equal(created.Spec.IPNetworks))
+			Expect(hooks.calls[0].After).To(Equal(updated))
+		})
+
+		It("should not call hooks for failed or dry-run operations", func() {
+			created, err := c.WorkloadEndpoints().Create(ctx, &libapiv3.WorkloadEndpoint{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace1, Name: name1},
+				Spec:       spec1_1,
+			}, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			hooks.calls = nil
+
+			_, err = c.WorkloadEndpoints().Create(ctx, created, options.SetOptions{})
+			Expect(err).To(HaveOccurred())
+
+			update := created.DeepCopy()
+			update.Labels = map[string]string{"app": "bar"}
+			_, err = c.WorkloadEndpoints().Update(ctx, update, options.SetOptions{DryRun: true})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = c.WorkloadEndpoints().Update(ctx, update, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			hooks.calls = nil
+			_, err = c.WorkloadEndpoints().Update(ctx, update, options.SetOptions{})
+			Expect(err).To(BeAssignableToTypeOf(errors.ErrorResourceUpdateConflict{}))
+
+			_, err = c.WorkloadEndpoints().Delete(ctx, namespace2, name2, options.DeleteOptions{})
+			Expect(err).To(HaveOccurred())
+			Expect(hooks.calls).To(BeEmpty())
+		})
+
+		It("should call the delete hook for each WorkloadEndpoint deleted by DeleteCollection", func() {
+			for _, cid := range []string{"c1", "c2", "c3"} {
+				spec := spec2_1
+				spec.ContainerID = cid
+				_, err := c.WorkloadEndpoints().Create(ctx, &libapiv3.WorkloadEndpoint{
+					ObjectMeta: metav1.ObjectMeta{Namespace: namespace1, Name: "node--2-cni-" + cid + "-eth0"},
+					Spec:       spec,
+				}, options.SetOptions{})
+				Expect(err).NotTo(HaveOccurred())
+			}
+			hooks.calls = nil
+
+			deleted, err := c.WorkloadEndpoints().DeleteCollection(ctx, options.ListOptions{LabelSelector: "all()"}, options.DeleteOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(deleted).To(HaveLen(3))
+			var expected []hookCall
+			for i := range deleted {
+				expected = append(expected, hookCall{Op: "delete", Before: &deleted[i]})
+			}
+			Expect(hooks.calls).To(ConsistOf(expected))
+		})
+	})
+
+	Describe("WorkloadEndpoint rate limits", func() {
+		var be bapi.Client
+
+		BeforeEach(func() {
+			var err error
+			be, err = backend.NewClient(config)
+			Expect(err).NotTo(HaveOccurred())
+			be.Clean()
+		})
+
+		newClient := func(reads, writes clientv3.RateLimit) clientv3.Interface {
+			c, err := clientv3.New(config, clientv3.WithWorkloadEndpointRateLimits(reads, writes))
+			Expect(err).NotTo(HaveOccurred())
+			return c
+		}
+
+		createWEP := func(c clientv3.Interface, cid string) error {
+			spec := spec2_1
+			spec.ContainerID = cid
+			_, err := c.WorkloadEndpoints().Create(ctx, &libapiv3.WorkloadEndpoint{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace2, Name: "node--2-cni-" + cid + "-eth0"},
+				Spec:       spec,
+			}, options.SetOptions{})
+			return err
+		}
+
+		It("should reject writes beyond the burst and leave reads unlimited", func() {
+			c := newClient(clientv3.RateLimit{}, clientv3.RateLimit{QPS: 0.01, Burst: 2})
+			Expect(createWEP(c, "aaaa")).To(Succeed())
+			Expect(createWEP(c, "bbbb")).To(Succeed())
+
+			err := createWEP(c, "cccc")
+			Expect(err).To(BeAssignableToTypeOf(errors.ErrorRateLimited{}))
+			Expect(err.Error()).To(ContainSubstring("WorkloadEndpoint write rate limited"))
+			_, err = c.WorkloadEndpoints().Delete(ctx, namespace2, "node--2-cni-aaaa-eth0", options.DeleteOptions{})
+			Expect(err).To(BeAssignableToTypeOf(errors.ErrorRateLimited{}))
+
+			for i := 0; i < 10; i++ {
+				list, err := c.WorkloadEndpoints().List(ctx, options.ListOptions{})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(list.Items).To(HaveLen(2))
+			}
+		})
+
+		It("should reject reads beyond the burst and leave writes unlimited", func() {
+			c := newClient(clientv3.RateLimit{QPS: 0.01, Burst: 3}, clientv3.RateLimit{})
+			for i := 0; i < 3; i++ {
+				_, err := c.WorkloadEndpoints().List(ctx, options.ListOptions{})
+				Expect(err).NotTo(HaveOccurred())
+			}
+			_, err := c.WorkloadEndpoints().List(ctx, options.ListOptions{})
+			Expect(err).To(BeAssignableToTypeOf(errors.ErrorRateLimited{}))
+			_, err = c.WorkloadEndpoints().Get(ctx, namespace2, "node--2-cni-aaaa-eth0", options.GetOptions{})
+			Expect(err).To(BeAssignableToTypeOf(errors.ErrorRateLimited{}))
+
+			for _, cid := range []string{"aaaa", "bbbb", "cccc", "dddd"} {
+				Expect(createWEP(c, cid)).To(Succeed())
+			}
+		})
+
+		It("should throttle blocking writes to the configured rate", func() {
+			c := newClient(clientv3.RateLimit{}, clientv3.RateLimit{QPS: 20, Burst: 1, Block: true})
+			start := time.Now()
+			for _, cid := range []string{"aaaa", "bbbb", "cccc", "dddd", "eeee"} {
+				Expect(createWEP(c, cid)).To(Succeed())
+			}
+			// The first write uses the burst; the remaining four each wait 50ms for a token.
+			Expect(time.Since(start)).To(BeNumerically(">=", 190*time.Millisecond))
+
+			list, err := c.WorkloadEndpoints().List(ctx, options.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(list.Items).To(HaveLen(5))
+		})
+
+		It("should fail a blocking write if its context expires before it is allowed", func() {
+			c := newClient(clientv3.RateLimit{}, clientv3.RateLimit{QPS: 0.01, Burst: 1, Block: true})
+			Expect(createWEP(c, "aaaa")).To(Succeed())
+
+			shortCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+			defer cancel()
+			_, err := c.WorkloadEndpoints().Delete(shortCtx, namespace2, "node--2-cni-aaaa-eth0", options.DeleteOptions{})
+			Expect(err).To(HaveOccurred())
+
+			_, err = c.WorkloadEndpoints().Get(ctx, namespace2, "node--2-cni-aaaa-eth0", options.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
+	Describe("WorkloadEndpoint DeleteIf", func() {
+		var c clientv3.Interface
+
+		BeforeEach(func() {
+			var err error
+			c, err = clientv3.New(config)
+			Expect(err).NotTo(HaveOccurred())
+
+			be, err := backend.NewClient(config)
+			Expect(err).NotTo(HaveOccurred())
+			be.Clean()
+		})
+
+		createWEP := func() *libapiv3.WorkloadEndpoint {
+			wep, err := c.WorkloadEndpoints().Create(ctx, &libapiv3.WorkloadEndpoint{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace2, Name: name2},
+				Spec:       spec2_1,
+			}, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			return wep
+		}
+
+		ownedBy := func(containerID string) func(*libapiv3.WorkloadEndpoint) bool {
+			return func(wep *libapiv3.WorkloadEndpoint) bool {
+				return wep.Spec.ContainerID == containerID
+			}
+		}
+
+		It("should delete the WorkloadEndpoint if the predicate returns true", func() {
+			created := createWEP()
+			deleted, err := c.WorkloadEndpoints().DeleteIf(ctx, namespace2, name2, ownedBy(spec2_1.ContainerID), options.DeleteOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(deleted.UID).To(Equal(created.UID))
+
+			_, err = c.WorkloadEndpoints().Get(ctx, namespace2, name2, options.GetOptions{})
+			Expect(err).To(BeAssignableToTypeOf(errors.ErrorResourceDoesNotExist{}))
+		})
+
+		It("should not delete the WorkloadEndpoint if the predicate returns false", func() {
+			created := createWEP()
+			_, err := c.WorkloadEndpoints().DeleteIf(ctx, namespace2, name2, ownedBy("another-container"), options.DeleteOptions{})
+			Expect(err).To(BeAssignableToTypeOf(errors.ErrorPreconditionFailed{}))
+
+			current, err := c.WorkloadEndpoints().Get(ctx, namespace2, name2, options.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(current.ResourceVersion).To(Equal(created.ResourceVersion))
+		})
+
+		It("should return an error if the WorkloadEndpoint does not exist", func() {
+			_, err := c.WorkloadEndpoints().DeleteIf(ctx, namespace2, name2, ownedBy(spec2_1.ContainerID), options.DeleteOptions{})
+			Expect(err).To(BeAssignableToTypeOf(errors.ErrorResourceDoesNotExist{}))
+		})
+
+		It("should not delete if the revision doesn't match the requested resource version", func() {
+			created := createWEP()
+			updated := created.DeepCopy()
+			updated.Labels = map[string]string{"app": "foo"}
+			_, err := c.WorkloadEndpoints().Update(ctx, updated, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = c.WorkloadEndpoints().DeleteIf(ctx, namespace2, name2, ownedBy(spec2_1.ContainerID),
+				options.DeleteOptions{ResourceVersion: created.ResourceVersion})
+			Expect(err).To(BeAssignableToTypeOf(errors.ErrorResourceUpdateConflict{}))
+
+			_, err = c.WorkloadEndpoints().Get(ctx, namespace2, name2, options.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should re-evaluate the predicate if the WorkloadEndpoint is modified after it is checked", func() {
+			created := createWEP()
+			created.Labels = map[string]string{"owner": "a"}
+			_, err := c.WorkloadEndpoints().Update(ctx, created, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			calls := 0
+			pred := func(wep *libapiv3.WorkloadEndpoint) bool {
+				calls++
+				if calls == 1 {
+					// Simulate a new owner taking over the endpoint between our check and
+					// the delete.
+					takeover := wep.DeepCopy()
+					takeover.Labels["owner"] = "b"
+					_, err := c.WorkloadEndpoints().Update(ctx, takeover, options.SetOptions{})
+					Expect(err).NotTo(HaveOccurred())
+				}
+				return wep.Labels["owner"] == "a"
+			}
+			_, err = c.WorkloadEndpoints().DeleteIf(ctx, namespace2, name2, pred, options.DeleteOptions{})
+			Expect(err).To(BeAssignableToTypeOf(errors.ErrorPreconditionFailed{}))
+			Expect(calls).To(Equal(2))
+
+			current, err := c.WorkloadEndpoints().Get(ctx, namespace2, name2, options.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(current.Labels).To(HaveKeyWithValue("owner", "b"))
+		})
+
+		It("should delete after a concurrent modification that still satisfies the predicate", func() {
+			createWEP()
+			calls := 0
+			pred := func(wep *libapiv3.WorkloadEndpoint) bool {
+				calls++
+				if calls == 1 {
+					relabelled := wep.DeepCopy()
+					relabelled.Labels = map[string]string{"app": "foo"}
+					_, err := c.WorkloadEndpoints().Update(ctx, relabelled, options.SetOptions{})
+					Expect(err).NotTo(HaveOccurred())
+				}
+				return wep.Spec.ContainerID == spec2_1.ContainerID
+			}
+			deleted, err := c.WorkloadEndpoints().DeleteIf(ctx, namespace2, name2, pred, options.DeleteOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(calls).To(Equal(2))
+			Expect(deleted.Labels).To(HaveKeyWithValue("app", "foo"))
+		})
+	})
+
+	Describe("WorkloadEndpoint Patch", func() {
+		var c clientv3.Interface
+
+		BeforeEach(func() {
+			var err error
+			c, err = clientv3.New(config)
+			Expect(err).NotTo(HaveOccurred())
+
+			be, err := backend.NewClient(config)
+			Expect(err).NotTo(HaveOccurred())
+			be.Clean()
+		})
+
+		createWEP := func() *libapiv3.WorkloadEndpoint {
+			wep, err := c.WorkloadEndpoints().Create(ctx, &libapiv3.WorkloadEndpoint{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: namespace1,
+					Name:      name1,
+					Labels:    map[string]string{"app": "web"},
+				},
+				Spec: spec1_1,
+			}, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			return wep
+		}
+
+		DescribeTable("should patch a single spec field and leave the other fields untouched",
+			func(pt types.PatchType) {
+				created := createWEP()
+				patched, err := c.WorkloadEndpoints().Patch(ctx, namespace1, name1, pt,
+					[]byte(`{"spec":{"interfaceName":"cali5678"}}`), options.PatchOptions{})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(patched.Spec.InterfaceName).To(Equal("cali5678"))
+				Expect(patched.ResourceVersion).NotTo(Equal(created.ResourceVersion))
+
+				current, err := c.WorkloadEndpoints().Get(ctx, namespace1, name1, options.GetOptions{})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(current.ResourceVersion).To(Equal(patched.ResourceVersion))
+				expectedSpec := spec1_1
+				expectedSpec.InterfaceName = "cali5678"
+				Expect(current.Spec).To(Equal(expectedSpec))
+				Expect(current.Labels).To(Equal(created.Labels))
+				Expect(current.UID).To(Equal(created.UID))
+				Expect(current.CreationTimestamp).To(Equal(created.CreationTimestamp))
+			},
+			Entry("JSON merge patch", types.MergePatchType),
+			Entry("strategic merge patch", types.StrategicMergePatchType),
+		)
+
+		It("should patch the labels", func() {
+			createWEP()
+			patched, err := c.WorkloadEndpoints().Patch(ctx, namespace1, name1, types.MergePatchType,
+				[]byte(`{"metadata":{"labels":{"app":null,"tier":"frontend"}}}`), options.PatchOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(patched.Labels).To(HaveKeyWithValue("tier", "frontend"))
+			Expect(patched.Labels).NotTo(HaveKey("app"))
+			Expect(patched.Spec).To(Equal(spec1_1))
+		})
+
+		It("should patch if the revision matches the requested resource version", func() {
+			created := createWEP()
+			patched, err := c.WorkloadEndpoints().Patch(ctx, namespace1, name1, types.MergePatchType,
+				[]byte(`{"spec":{"interfaceName":"cali5678"}}`), options.PatchOptions{ResourceVersion: created.ResourceVersion})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(patched.Spec.InterfaceName).To(Equal("cali5678"))
+		})
+
+		It("should not patch if the revision doesn't match the requested resource version", func() {
+			created := createWEP()
+			updated := created.DeepCopy()
+			updated.Labels = map[string]string{"app": "foo"}
+			updated, err := c.WorkloadEndpoints().Update(ctx, updated, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = c.WorkloadEndpoints().Patch(ctx, namespace1, name1, types.MergePatchType,
+				[]byte(`{"spec":{"interfaceName":"cali5678"}}`), options.PatchOptions{ResourceVersion: created.ResourceVersion})
+			Expect(err).To(BeAssignableToTypeOf(errors.ErrorResourceUpdateConflict{}))
+			Expect(err.Error()).To(Equal(errors.ErrorResourceUpdateConflict{
+				Identifier: model.ResourceKey{Kind: libapiv3.KindWorkloadEndpoint, Namespace: namespace1, Name: name1},
+			}.Error()))
+
+			current, err := c.WorkloadEndpoints().Get(ctx, namespace1, name1, options.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(current.ResourceVersion).To(Equal(updated.ResourceVersion))
+			Expect(current.Spec.InterfaceName).To(Equal(spec1_1.InterfaceName))
+		})
+
+		It("should reject invalid patches without modifying the WorkloadEndpoint", func() {
+			created := createWEP()
+			_, err := c.WorkloadEndpoints().Patch(ctx, namespace1, name1, types.MergePatchType,
+				[]byte(`{"metadata":{"name":"other"}}`), options.PatchOptions{})
+			Expect(err).To(BeAssignableToTypeOf(errors.ErrorValidation{}))
+
+			_, err = c.WorkloadEndpoints().Patch(ctx, namespace1, name1, types.JSONPatchType,
+				[]byte(`[{"op":"remove","path":"/spec/interfaceName"}]`), options.PatchOptions{})
+			Expect(err).To(BeAssignableToTypeOf(errors.ErrorOperationNotSupported{}))
+
+			current, err := c.WorkloadEndpoints().Get(ctx, namespace1, name1, options.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(current.ResourceVersion).To(Equal(created.ResourceVersion))
+		})
+
+		It("should return an error if the WorkloadEndpoint does not exist", func() {
+			_, err := c.WorkloadEndpoints().Patch(ctx, namespace1, name1, types.MergePatchType,
+				[]byte(`{"spec":{"interfaceName":"cali5678"}}`), options.PatchOptions{})
+			Expect(err).To(BeAssignableToTypeOf(errors.ErrorResourceDoesNotExist{}))
+		})
+	})
+
+	Describe("WorkloadEndpoint namespace-ordered watch", func() {
+		var c clientv3.Interface
+
+		BeforeEach(func() {
+			var err error
+			c, err = clientv3.New(config)
+			Expect(err).NotTo(HaveOccurred())
+
+			be, err := backend.NewClient(config)
+			Expect(err).NotTo(HaveOccurred())
+			be.Clean()
+		})
+
+		It("should deliver each namespace's events in resource version order", func() {
+			w, err := c.WorkloadEndpoints().WatchNamespaceOrdered(ctx, []string{namespace1, namespace2}, options.ListOptions{}, 20*time.Millisecond)
+			Expect(err).NotTo(HaveOccurred())
+			defer w.Stop()
+
+			// Interleave creates, updates and deletes across the two namespaces, from
+			// concurrent writers.
+			const numPerNamespace = 10
+			var wg sync.WaitGroup
+			for _, ns := range []string{namespace1, namespace2} {
+				wg.Add(1)
+				go func(ns string) {
+					defer GinkgoRecover()
+					defer wg.Done()
+					for i := 0; i < numPerNamespace; i++ {
+						cid := fmt.Sprintf("c%d", i)
+						spec := spec2_1
+						spec.ContainerID = cid
+						name := "node--2-cni-" + cid + "-eth0"
+						wep, err := c.WorkloadEndpoints().Create(ctx, &libapiv3.WorkloadEndpoint{
+							ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: name},
+							Spec:       spec,
+						}, options.SetOptions{})
+						Expect(err).NotTo(HaveOccurred())
+						wep.Labels["step"] = "updated"
+						_, err = c.WorkloadEndpoints().Update(ctx, wep, options.SetOptions{})
+						Expect(err).NotTo(HaveOccurred())
+						if i%2 == 0 {
+							_, err = c.WorkloadEndpoints().Delete(ctx, ns, name, options.DeleteOptions{})
+							Expect(err).NotTo(HaveOccurred())
+						}
+					}
+				}(ns)
+			}
+			wg.Wait()
+
+			// Added and Modified events must have strictly increasing resource versions
+			// within each namespace.  A Deleted event carries the last state of the
+			// WorkloadEndpoint, so it must follow the event for that state.
+			revsByNamespace := map[string][]int{}
+			lastRev := map[string]int{}
+			numDeletes := 0
+			expectedUpdates := 2 * numPerNamespace * 2
+			expectedDeletes := 2 * numPerNamespace / 2
+			for len(revsByNamespace[namespace1])+len(revsByNamespace[namespace2])+numDeletes < expectedUpdates+expectedDeletes {
+				var e watch.Event
+				Eventually(w.ResultChan(), "5s").Should(Receive(&e))
+				Expect(e.Type).NotTo(Equal(watch.Error))
+				if e.Type == watch.Deleted {
+					wep := e.Previous.(*libapiv3.WorkloadEndpoint)
+					rev, err := strconv.Atoi(wep.ResourceVersion)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(rev).To(BeNumerically("<=", lastRev[wep.Namespace]), "Delete delivered before the WorkloadEndpoint's last update")
+					numDeletes++
+					continue
+				}
+				wep := e.Object.(*libapiv3.WorkloadEndpoint)
+				rev, err := strconv.Atoi(wep.ResourceVersion)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(rev).To(BeNumerically(">", lastRev[wep.Namespace]), "Events out of order for namespace "+wep.Namespace)
+				lastRev[wep.Namespace] = rev
+				revsByNamespace[wep.Namespace] = append(revsByNamespace[wep.Namespace], rev)
+			}
+			Consistently(w.ResultChan(), "100ms").ShouldNot(Receive())
+
+			Expect(revsByNamespace[namespace1]).To(HaveLen(expectedUpdates / 2))
+			Expect(revsByNamespace[namespace2]).To(HaveLen(expectedUpdates / 2))
+			Expect(numDeletes).To(Equal(expectedDeletes))
+		})
+
+		It("should only watch the requested namespaces", func() {
+			w, err := c.WorkloadEndpoints().WatchNamespaceOrdered(ctx, []string{namespace1}, options.ListOptions{}, 10*time.Millisecond)
+			Expect(err).NotTo(HaveOccurred())
+			defer w.Stop()
+
+			_, err = c.WorkloadEndpoints().Create(ctx, &libapiv3.WorkloadEndpoint{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace2, Name: name2},
+				Spec:       spec2_1,
+			}, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			created, err := c.WorkloadEndpoints().Create(ctx, &libapiv3.WorkloadEndpoint{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace1, Name: name1},
+				Spec:       spec1_1,
+			}, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			var e watch.Event
+			Eventually(w.ResultChan(), "5s").Should(Receive(&e))
+			Expect(e.Type).To(Equal(watch.Added))
+			Expect(e.Object.(*libapiv3.WorkloadEndpoint).ResourceVersion).To(Equal(created.ResourceVersion))
+			Consistently(w.ResultChan(), "100ms").ShouldNot(Receive())
+		})
+	})
+
+	Describe("WorkloadEndpoint annotations", func() {
+		var c clientv3.Interface
+
+		BeforeEach(func() {
+			var err error
+			c, err = clientv3.New(config)
+			Expect(err).NotTo(HaveOccurred())
+
+			be, err := backend.NewClient(config)
+			Expect(err).NotTo(HaveOccurred())
+			be.Clean()
+
+			_, err = c.WorkloadEndpoints().Create(ctx, &libapiv3.WorkloadEndpoint{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace1, Name: name1, Labels: map[string]string{"app": "foo"}},
+				Spec:       spec1_1,
+			}, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should set, overwrite and remove an annotation", func() {
+			out, err := c.WorkloadEndpoints().SetAnnotation(ctx, namespace1, name1, "example.com/owner", "a", options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(out.Annotations).To(HaveKeyWithValue("example.com/owner", "a"))
+			Expect(out.Labels).To(HaveKeyWithValue("app", "foo"))
+
+			value, ok, err := c.WorkloadEndpoints().GetAnnotation(ctx, namespace1, name1, "example.com/owner")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ok).To(BeTrue())
+			Expect(value).To(Equal("a"))
+
+			By("setting the same value again")
+			again, err := c.WorkloadEndpoints().SetAnnotation(ctx, namespace1, name1, "example.com/owner", "a", options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(again.ResourceVersion).To(Equal(out.ResourceVersion))
+
+			By("overwriting it")
+			out, err = c.WorkloadEndpoints().SetAnnotation(ctx, namespace1, name1, "example.com/owner", "b", options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(out.Annotations).To(HaveKeyWithValue("example.com/owner", "b"))
+
+			By("removing it")
+			out, err = c.WorkloadEndpoints().RemoveAnnotation(ctx, namespace1, name1, "example.com/owner", options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(out.Annotations).NotTo(HaveKey("example.com/owner"))
+			_, ok, err = c.WorkloadEndpoints().GetAnnotation(ctx, namespace1, name1, "example.com/owner")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ok).To(BeFalse())
+
+			By("removing it again")
+			_, err = c.WorkloadEndpoints().RemoveAnnotation(ctx, namespace1, name1, "example.com/owner", options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("should preserve annotations across spec updates", func() {
+			_, err := c.WorkloadEndpoints().SetAnnotation(ctx, namespace1, name1, "note", "keep-me", options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			wep, err := c.WorkloadEndpoints().Get(ctx, namespace1, name1, options.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			wep.Spec.Ports = []libapiv3.WorkloadEndpointPort{{Name: "http", Protocol: numorstring.ProtocolFromString("TCP"), Port: 80}}
+			_, err = c.WorkloadEndpoints().Update(ctx, wep, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			value, ok, err := c.WorkloadEndpoints().GetAnnotation(ctx, namespace1, name1, "note")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ok).To(BeTrue())
+			Expect(value).To(Equal("keep-me"))
+		})
+
+		It("should reject invalid annotations", func() {
+			_, err := c.WorkloadEndpoints().SetAnnotation(ctx, namespace1, name1, "not a/valid/key", "a", options.SetOptions{})
+			Expect(err).To(BeAssignableToTypeOf(errors.ErrorValidation{}))
+			_, err = c.WorkloadEndpoints().RemoveAnnotation(ctx, namespace1, name1, "", options.SetOptions{})
+			Expect(err).To(BeAssignableToTypeOf(errors.ErrorValidation{}))
+
+			By("setting a value that is too large")
+			_, err = c.WorkloadEndpoints().SetAnnotation(ctx, namespace1, name1, "big", strings.Repeat("x", 300*1024), options.SetOptions{})
+			Expect(err).To(HaveOccurred())
+			_, ok, err := c.WorkloadEndpoints().GetAnnotation(ctx, namespace1, name1, "big")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ok).To(BeFalse())
+		})
+
+		It("should not lose concurrent updates to different annotations", func() {
+			const numWriters = 5
+			var wg sync.WaitGroup
+			errs := make([]error, numWriters)
+			for i := 0; i < numWriters; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer GinkgoRecover()
+					defer wg.Done()
+					_, errs[i] = c.WorkloadEndpoints().SetAnnotation(ctx, namespace1, name1, "writer-"+strconv.Itoa(i), "done", options.SetOptions{})
+				}(i)
+			}
+			wg.Wait()
+			for _, err := range errs {
+				Expect(err).NotTo(HaveOccurred())
+			}
+
+			wep, err := c.WorkloadEndpoints().Get(ctx, namespace1, name1, options.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			for i := 0; i < numWriters; i++ {
+				Expect(wep.Annotations).To(HaveKeyWithValue("writer-"+strconv.Itoa(i), "done"))
+			}
+			Expect(wep.Labels).To(HaveKeyWithValue("app", "foo"))
+		})
+	})
+
+	Describe("WorkloadEndpoint dangling endpoint detection", func() {
+		var c clientv3.Interface
+
+		wepName := func(cid string) string {
+			return "node--2-cni-" + cid + "-eth0"
+		}
+
+		createWEP := func(namespace, cid string) {
+			spec := spec2_1
+			spec.ContainerID = cid
+			_, err := c.WorkloadEndpoints().Create(ctx, &libapiv3.WorkloadEndpoint{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: wepName(cid)},
+				Spec:       spec,
+			}, options.SetOptions{})
+			ExpectWithOffset(1, err).NotTo(HaveOccurred())
+		}
+
+		// isAlive treats the k8s pod and the containers in alive as running.
+		alive := map[string]bool{}
+		var checked []names.WorkloadEndpointIdentifiers
+		isAlive := func(ids names.WorkloadEndpointIdentifiers) bool {
+			checked = append(checked, ids)
+			if ids.Orchestrator == "k8s" {
+				return ids.Pod == "abcdef"
+			}
+			return alive[ids.ContainerID]
+		}
+
+		BeforeEach(func() {
+			var err error
+			c, err = clientv3.New(config)
+			Expect(err).NotTo(HaveOccurred())
+
+			be, err := backend.NewClient(config)
+			Expect(err).NotTo(HaveOccurred())
+			be.Clean()
+
+			_, err = c.WorkloadEndpoints().Create(ctx, &libapiv3.WorkloadEndpoint{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace1, Name: name1},
+				Spec:       spec1_1,
+			}, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			createWEP(namespace1, "live1")
+			createWEP(namespace1, "dead1")
+			createWEP(namespace2, "dead2")
+
+			alive = map[string]bool{"live1": true}
+			checked = nil
+		})
+
+		It("should return only the endpoints whose workload has gone", func() {
+			dangling, err := c.WorkloadEndpoints().FindDangling(ctx, isAlive, options.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			var found []string
+			for _, wep := range dangling {
+				Expect(wep.ResourceVersion).NotTo(BeEmpty())
+				found = append(found, wep.Namespace+"/"+wep.Name)
+			}
+			Expect(found).To(ConsistOf(namespace1+"/"+wepName("dead1"), namespace2+"/"+wepName("dead2")))
+
+			By("passing the parsed identifiers to the callback")
+			Expect(checked).To(HaveLen(4))
+			Expect(checked).To(ContainElement(names.WorkloadEndpointIdentifiers{
+				Node:         "node-1",
+				Orchestrator: "k8s",
+				Pod:          "abcdef",
+				Endpoint:     "eth0",
+			}))
+			Expect(checked).To(ContainElement(names.WorkloadEndpointIdentifiers{
+				Node:         "node-2",
+				Orchestrator: "cni",
+				ContainerID:  "dead1",
+				Endpoint:     "eth0",
+			}))
+		})
+
+		It("should only check the endpoints that match the list options", func() {
+			dangling, err := c.WorkloadEndpoints().FindDangling(ctx, isAlive, options.ListOptions{Namespace: namespace2})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dangling).To(HaveLen(1))
+			Expect(dangling[0].Name).To(Equal(wepName("dead2")))
+			Expect(checked).To(HaveLen(1))
+		})
+
+		It("should return nothing if all the workloads are alive", func() {
+			alive["dead1"] = true
+			alive["dead2"] = true
+			dangling, err := c.WorkloadEndpoints().FindDangling(ctx, isAlive, options.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(dangling).To(BeEmpty())
+		})
+	})
+
+	Describe("WorkloadEndpoint watch buffer overflow policies", func() {
+		var c clientv3.Interface
+
+		BeforeEach(func() {
+			var err error
+			c, err = clientv3.New(config)
+			Expect(err).NotTo(HaveOccurred())
+
+			be, err := backend.NewClient(config)
+			Expect(err).NotTo(HaveOccurred())
+			be.Clean()
+		})
+
+		// createBurst creates n WorkloadEndpoints, numbered from first, as fast as possible,
+		// returning their names.
+		createBurst := func(first, n int) []string {
+			var created []string
+			for i := first; i < first+n; i++ {
+				cid := fmt.Sprintf("burst%d", i)
+				spec := spec2_1
+				spec.ContainerID = cid
+				wep, err := c.WorkloadEndpoints().Create(ctx, &libapiv3.WorkloadEndpoint{
+					ObjectMeta: metav1.ObjectMeta{Namespace: namespace2, Name: "node--2-cni-" + cid + "-eth0"},
+					Spec:       spec,
+				}, options.SetOptions{})
+				Expect(err).NotTo(HaveOccurred())
+				created = append(created, wep.Name)
+			}
+			return created
+		}
+
+		// drain reads events until the channel is closed or no event arrives for a while.
+		drain := func(w watch.Interface) (events []watch.Event, closed bool) {
+			for {
+				select {
+				case e, ok := <-w.ResultChan():
+					if !ok {
+						return events, true
+					}
+					events = append(events, e)
+				case <-time.After(time.Second):
+					return events, false
+				}
+			}
+		}
+
+		It("should reject invalid buffer options", func() {
+			for _, opts := range []options.ListOptions{
+				{WatchBufferSize: -1},
+				{WatchOverflowPolicy: "Sometimes"},
+				{WatchBufferSize: 1, WatchOverflowPolicy: options.WatchOverflowDropOldest},
+			} {
+				_, err := c.WorkloadEndpoints().Watch(ctx, opts)
+				Expect(err).To(BeAssignableToTypeOf(errors.ErrorValidation{}), fmt.Sprintf("options: %+v", opts))
+			}
+		})
+
+		It("should deliver every event to a slow consumer with the Block policy", func() {
+			w, err := c.WorkloadEndpoints().Watch(ctx, options.ListOptions{
+				Namespace:       namespace2,
+				WatchBufferSize: 2,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			defer w.Stop()
+
+			created := createBurst(0, 10)
+			time.Sleep(500 * time.Millisecond)
+			Expect(len(w.ResultChan())).To(Equal(2))
+
+			events, closed := drain(w)
+			Expect(closed).To(BeFalse())
+			var received []string
+			for _, e := range events {
+				Expect(e.Type).To(Equal(watch.Added))
+				received = append(received, e.Object.(*libapiv3.WorkloadEndpoint).Name)
+			}
+			Expect(received).To(Equal(created))
+		})
+
+		It("should drop the oldest events and send an Error event with the DropOldest policy", func() {
+			w, err := c.WorkloadEndpoints().Watch(ctx, options.ListOptions{
+				Namespace:           namespace2,
+				WatchBufferSize:     4,
+				WatchOverflowPolicy: options.WatchOverflowDropOldest,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			defer w.Stop()
+
+			created := createBurst(0, 10)
+			time.Sleep(500 * time.Millisecond)
+
+			events, closed := drain(w)
+			Expect(closed).To(BeFalse())
+			Expect(len(events)).To(BeNumerically("<=", 4))
+
+			By("Checking an Error event reports the dropped events")
+			var overflow errors.ErrorWatchOverflow
+			var received []string
+			for _, e := range events {
+				if e.Type == watch.Error {
+					Expect(e.Error).To(BeAssignableToTypeOf(errors.ErrorWatchOverflow{}))
+					overflow = e.Error.(errors.ErrorWatchOverflow)
+					continue
+				}
+				received = append(received, e.Object.(*libapiv3.WorkloadEndpoint).Name)
+			}
+			Expect(overflow.BufferSize).To(Equal(4))
+			Expect(overflow.Dropped).To(BeNumerically(">", 0))
+			Expect(overflow.Closed).To(BeFalse())
+
+			By("Checking the newest event was delivered last")
+			Expect(events[len(events)-1].Type).To(Equal(watch.Added))
+			Expect(received[len(received)-1]).To(Equal(created[len(created)-1]))
+
+			By("Checking the watch is still running")
+			created = createBurst(10, 1)
+			var e watch.Event
+			Eventually(w.ResultChan(), 5*time.Second).Should(Receive(&e))
+			Expect(e.Type).To(Equal(watch.Added))
+			Expect(e.Object.(*libapiv3.WorkloadEndpoint).Name).To(Equal(created[0]))
+		})
+
+		It("should deliver the buffered events then an Error event and close with the Close policy", func() {
+			w, err := c.WorkloadEndpoints().Watch(ctx, options.ListOptions{
+				Namespace:           namespace2,
+				WatchBufferSize:     2,
+				WatchOverflowPolicy: options.WatchOverflowClose,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			defer w.Stop()
+
+			created := createBurst(0, 5)
+			time.Sleep(500 * time.Millisecond)
+
+			events, closed := drain(w)
+			Expect(closed).To(BeTrue())
+			Expect(events).To(HaveLen(3))
+			Expect(events[0].Object.(*libapiv3.WorkloadEndpoint).Name).To(Equal(created[0]))
+			Expect(events[1].Object.(*libapiv3.WorkloadEndpoint).Name).To(Equal(created[1]))
+			Expect(events[2].Type).To(Equal(watch.Error))
+			Expect(events[2].Error).To(Equal(errors.ErrorWatchOverflow{BufferSize: 2, Closed: true}))
+		})
+	})
+
+	Describe("WorkloadEndpoint watch projection", func() {
+		var c clientv3.Interface
+
+		BeforeEach(func() {
+			var err error
+			c, err = clientv3.New(config)
+			Expect(err).NotTo(HaveOccurred())
+
+			be, err := backend.NewClient(config)
+			Expect(err).NotTo(HaveOccurred())
+			be.Clean()
+		})
+
+		// nextEvents receives n events from the watcher.
+		nextEvents := func(w watch.Interface, n int) []watch.Event {
+			events := make([]watch.Event, n)
+			for i := range events {
+				EventuallyWithOffset(1, w.ResultChan(), 5*time.Second).Should(Receive(&events[i]))
+			}
+			return events
+		}
+
+		// expectTrimmed checks that obj is the full object reduced to the Name, ResourceVersion
+		// and Node.
+		expectTrimmed := func(obj, full runtime.Object) {
+			if full == nil {
+				ExpectWithOffset(1, obj).To(BeNil())
+				return
+			}
+			wep := full.(*libapiv3.WorkloadEndpoint)
+			ExpectWithOffset(1, obj).To(Equal(&libapiv3.WorkloadEndpoint{
+				TypeMeta:   wep.TypeMeta,
+				ObjectMeta: metav1.ObjectMeta{Name: wep.Name, ResourceVersion: wep.ResourceVersion},
+				Spec:       libapiv3.WorkloadEndpointSpec{Node: wep.Spec.Node},
+			}))
+		}
+
+		It("should trim the objects of Added, Modified and Deleted events without changing the events", func() {
+			full, err := c.WorkloadEndpoints().Watch(ctx, options.ListOptions{Namespace: namespace1})
+			Expect(err).NotTo(HaveOccurred())
+			defer full.Stop()
+			trim, err := c.WorkloadEndpoints().Watch(ctx, options.ListOptions{
+				Namespace:       namespace1,
+				WatchProjection: []string{"Name", "ResourceVersion", "Node"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			defer trim.Stop()
+
+			By("Creating, updating and deleting a WorkloadEndpoint")
+			wep, err := c.WorkloadEndpoints().Create(ctx, &libapiv3.WorkloadEndpoint{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace1, Name: name1},
+				Spec:       spec1_1,
+			}, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			wep.Spec = spec1_2
+			wep, err = c.WorkloadEndpoints().Update(ctx, wep, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			_, err = c.WorkloadEndpoints().Delete(ctx, namespace1, name1, options.DeleteOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			By("Checking the trimmed events match the full events, with only the projected fields")
+			fullEvents := nextEvents(full, 3)
+			trimEvents := nextEvents(trim, 3)
+			Expect([]watch.EventType{trimEvents[0].Type, trimEvents[1].Type, trimEvents[2].Type}).To(Equal(
+				[]watch.EventType{watch.Added, watch.Modified, watch.Deleted}))
+			for i := range fullEvents {
+				Expect(trimEvents[i].Type).To(Equal(fullEvents[i].Type))
+				Expect(trimEvents[i].Error).NotTo(HaveOccurred())
+				expectTrimmed(trimEvents[i].Object, fullEvents[i].Object)
+				expectTrimmed(trimEvents[i].Previous, fullEvents[i].Previous)
+			}
+			Expect(trimEvents[2].Previous).NotTo(BeNil())
+		})
+
+		It("should filter by name before trimming a watch of named WorkloadEndpoints", func() {
+			w, err := c.WorkloadEndpoints().Watch(ctx, options.ListOptions{
+				Namespace:       namespace1,
+				Names:           []string{name1, "node--2-cni-absent-eth0"},
+				WatchProjection: []string{"Node"},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			defer w.Stop()
+
+			spec := spec2_1
+			spec.ContainerID = "other"
+			_, err = c.WorkloadEndpoints().Create(ctx, &libapiv3.WorkloadEndpoint{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace1, Name: "node--2-cni-other-eth0"},
+				Spec:       spec,
+			}, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			_, err = c.WorkloadEndpoints().Create(ctx, &libapiv3.WorkloadEndpoint{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace1, Name: name1},
+				Spec:       spec1_1,
+			}, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			e := nextEvents(w, 1)[0]
+			Expect(e.Type).To(Equal(watch.Added))
+			Expect(e.Object).To(Equal(&libapiv3.WorkloadEndpoint{
+				TypeMeta: metav1.TypeMeta{
+					Kind:       libapiv3.KindWorkloadEndpoint,
+					APIVersion: apiv3.GroupVersionCurrent,
+				},
+				Spec: libapiv3.WorkloadEndpointSpec{Node: "node-1"},
+			}))
+			Consistently(w.ResultChan(), 500*time.Millisecond).ShouldNot(Receive())
+		})
+
+		It("should reject unknown fields", func() {
+			_, err := c.WorkloadEndpoints().Watch(ctx, options.ListOptions{WatchProjection: []string{"Name", "Bogus"}})
+			Expect(err).To(BeAssignableToTypeOf(errors.ErrorValidation{}))
+		})
+
+		It("should be rejected by the watches that need the full events", func() {
+			opts := options.ListOptions{WatchProjection: []string{"Name"}}
+			_, err := c.WorkloadEndpoints().WatchWithCursor(ctx, opts, &memCursorStore{})
+			Expect(err).To(BeAssignableToTypeOf(errors.ErrorValidation{}))
+			_, err = c.WorkloadEndpoints().WatchNamespaceOrdered(ctx, []string{namespace1}, opts, time.Millisecond)
+			Expect(err).To(BeAssignableToTypeOf(errors.ErrorValidation{}))
+		})
+	})
+
+	Describe("WorkloadEndpoint warnings", func() {
+		var c clientv3.Interface
+		var warnings []string
+		var opts options.SetOptions
+
+		BeforeEach(func() {
+			var err error
+			c, err = clientv3.New(config)
+			Expect(err).NotTo(HaveOccurred())
+
+			be, err := backend.NewClient(config)
+			Expect(err).NotTo(HaveOccurred())
+			be.Clean()
+
+			warnings = nil
+			opts = options.SetOptions{WarningHandler: func(w string) { warnings = append(warnings, w) }}
+		})
+
+		It("should create a WorkloadEndpoint with a deprecated orchestrator and return a warning", func() {
+			out, err := c.WorkloadEndpoints().Create(ctx, &libapiv3.WorkloadEndpoint{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace1, Name: "node--1-libnetwork-libnetwork-eth0"},
+				Spec: libapiv3.WorkloadEndpointSpec{
+					Node:          "node-1",
+					Orchestrator:  "libnetwork",
+					Endpoint:      "eth0",
+					InterfaceName: "cali01234",
+				},
+			}, opts)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(out.Spec.Orchestrator).To(Equal("libnetwork"))
+			Expect(warnings).To(Equal([]string{"Spec.Orchestrator: the libnetwork orchestrator is deprecated"}))
+		})
+
+		It("should warn about ignored and overridden fields", func() {
+			spec := spec1_1
+			spec.Workload = "ignored"
+			_, err := c.WorkloadEndpoints().Create(ctx, &libapiv3.WorkloadEndpoint{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: namespace1,
+					Name:      name1,
+					Labels:    map[string]string{apiv3.LabelNamespace: "other"},
+				},
+				Spec: spec,
+			}, opts)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warnings).To(ConsistOf(
+				"Spec.Workload is ignored for the k8s orchestrator",
+				fmt.Sprintf("label %s=\"other\" was replaced with %q", apiv3.LabelNamespace, namespace1),
+			))
+		})
+
+		It("should warn when an Update tries to change the creation timestamp", func() {
+			out, err := c.WorkloadEndpoints().Create(ctx, &libapiv3.WorkloadEndpoint{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace1, Name: name1},
+				Spec:       spec1_1,
+			}, opts)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warnings).To(BeEmpty())
+
+			created := out.CreationTimestamp
+			out.CreationTimestamp = metav1.NewTime(out.CreationTimestamp.Add(-time.Hour))
+			out, err = c.WorkloadEndpoints().Update(ctx, out, opts)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(out.CreationTimestamp.Equal(&created)).To(BeTrue())
+			Expect(warnings).To(Equal([]string{"Metadata.CreationTimestamp can't be changed, the supplied value was ignored"}))
+
+			out, err = c.WorkloadEndpoints().Get(ctx, namespace1, name1, options.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(out.CreationTimestamp.Equal(&created)).To(BeTrue())
+		})
+
+		It("should warn when a transaction update tries to change the creation timestamp", func() {
+			out, err := c.WorkloadEndpoints().Create(ctx, &libapiv3.WorkloadEndpoint{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace1, Name: name1},
+				Spec:       spec1_1,
+			}, opts)
+			Expect(err).NotTo(HaveOccurred())
+
+			created := out.CreationTimestamp
+			out.CreationTimestamp = metav1.NewTime(out.CreationTimestamp.Add(-time.Hour))
+			results, err := c.Txn().UpdateWorkloadEndpoint(out, opts).Commit(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			updated := results[0].Object.(*libapiv3.WorkloadEndpoint)
+			Expect(updated.CreationTimestamp.Equal(&created)).To(BeTrue())
+			Expect(warnings).To(Equal([]string{"Metadata.CreationTimestamp can't be changed, the supplied value was ignored"}))
+
+			out, err = c.WorkloadEndpoints().Get(ctx, namespace1, name1, options.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(out.CreationTimestamp.Equal(&created)).To(BeTrue())
+			Expect(out.ResourceVersion).To(Equal(updated.ResourceVersion))
+		})
+
+		It("should not return warnings for a failed write", func() {
+			wep := &libapiv3.WorkloadEndpoint{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace1, Name: "node--1-libnetwork-libnetwork-eth0"},
+				Spec: libapiv3.WorkloadEndpointSpec{
+					Node:          "node-1",
+					Orchestrator:  "libnetwork",
+					Endpoint:      "eth0",
+					InterfaceName: "cali01234",
+				},
+			}
+			_, err := c.WorkloadEndpoints().Create(ctx, wep, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = c.WorkloadEndpoints().Create(ctx, wep, opts)
+			Expect(err).To(BeAssignableToTypeOf(errors.ErrorResourceAlreadyExists{}))
+			Expect(warnings).To(BeEmpty())
+		})
+
+		It("should return the warnings for each operation of a committed transaction", func() {
+			var warnings2 []string
+			_, err := c.Txn().
+				CreateWorkloadEndpoint(&libapiv3.WorkloadEndpoint{
+					ObjectMeta: metav1.ObjectMeta{Namespace: namespace1, Name: "node--1-libnetwork-libnetwork-eth0"},
+					Spec: libapiv3.WorkloadEndpointSpec{
+						Node:          "node-1",
+						Orchestrator:  "libnetwork",
+						Endpoint:      "eth0",
+						InterfaceName: "cali01234",
+					},
+				}, opts).
+				CreateWorkloadEndpoint(&libapiv3.WorkloadEndpoint{
+					ObjectMeta: metav1.ObjectMeta{Namespace: namespace2, Name: name2},
+					Spec:       spec2_1,
+				}, options.SetOptions{WarningHandler: func(w string) { warnings2 = append(warnings2, w) }}).
+				Commit(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(warnings).To(Equal([]string{"Spec.Orchestrator: the libnetwork orchestrator is deprecated"}))
+			Expect(warnings2).To(BeEmpty())
+		})
+	})
+
+	Describe("WorkloadEndpoint migration", func() {
+		var c clientv3.Interface
+		var be bapi.Client
+
+		// The legacy WorkloadEndpoints: one whose name predates dash escaping, one whose pod
+		// is only recorded in the Workload field, one whose container ID is only recorded in
+		// the Workload field (but which already has the current name), and one that can't be
+		// converted.
+		legacyName := "node-1-k8s-pod-eth0"
+		legacySpec := libapiv3.WorkloadEndpointSpec{
+			Node:          "node-1",
+			Orchestrator:  "k8s",
+			Pod:           "pod",
+			Endpoint:      "eth0",
+			InterfaceName: "cali1234",
+		}
+		legacyWorkloadName := "node-2-k8s-namespace-1.pod2-eth0"
+		legacyWorkloadSpec := libapiv3.WorkloadEndpointSpec{
+			Node:          "node-2",
+			Orchestrator:  "k8s",
+			Workload:      "namespace-1.pod2",
+			Endpoint:      "eth0",
+			InterfaceName: "cali2345",
+		}
+		legacyCNISpec := libapiv3.WorkloadEndpointSpec{
+			Node:          "node-2",
+			Orchestrator:  "cni",
+			Workload:      "a232323a",
+			Endpoint:      "eth0",
+			InterfaceName: "cali3456",
+		}
+		unconvertibleName := "node-3-k8s-unknown-eth0"
+		unconvertibleSpec := libapiv3.WorkloadEndpointSpec{
+			Node:          "node-3",
+			Orchestrator:  "k8s",
+			Endpoint:      "eth0",
+			InterfaceName: "cali4567",
+		}
+
+		seed := func(namespace, name string, spec libapiv3.WorkloadEndpointSpec) {
+			_, err := be.Create(ctx, &model.KVPair{
+				Key: model.ResourceKey{
+					Kind:      libapiv3.KindWorkloadEndpoint,
+					Namespace: namespace,
+					Name:      name,
+				},
+				Value: &libapiv3.WorkloadEndpoint{
+					TypeMeta: metav1.TypeMeta{
+						Kind:       libapiv3.KindWorkloadEndpoint,
+						APIVersion: apiv3.GroupVersionCurrent,
+					},
+					ObjectMeta: metav1.ObjectMeta{
+						Namespace:         namespace,
+						Name:              name,
+						Labels:            map[string]string{"app": "legacy"},
+						CreationTimestamp: metav1.Now(),
+						UID:               types.UID("uid-" + name),
+					},
+					Spec: spec,
+				},
+			})
+			ExpectWithOffset(1, err).NotTo(HaveOccurred())
+		}
+
+		// expectWEP checks that the WorkloadEndpoint exists with the given Spec and returns it.
+		expectWEP := func(namespace, name string, spec libapiv3.WorkloadEndpointSpec) *libapiv3.WorkloadEndpoint {
+			wep, err := c.WorkloadEndpoints().Get(ctx, namespace, name, options.GetOptions{})
+			ExpectWithOffset(1, err).NotTo(HaveOccurred())
+			ExpectWithOffset(1, wep.Spec).To(Equal(spec))
+			ExpectWithOffset(1, wep.Labels).To(HaveKeyWithValue("app", "legacy"))
+			return wep
+		}
+
+		expectNoWEP := func(namespace, name string) {
+			_, err := c.WorkloadEndpoints().Get(ctx, namespace, name, options.GetOptions{})
+			ExpectWithOffset(1, err).To(BeAssignableToTypeOf(errors.ErrorResourceDoesNotExist{}))
+		}
+
+		migratedSpec := legacySpec
+		migratedWorkloadSpec := legacyWorkloadSpec
+		migratedWorkloadSpec.Workload = ""
+		migratedWorkloadSpec.Pod = "pod2"
+		migratedCNISpec := legacyCNISpec
+		migratedCNISpec.Workload = ""
+		migratedCNISpec.ContainerID = "a232323a"
+
+		BeforeEach(func() {
+			var err error
+			c, err = clientv3.New(config)
+			Expect(err).NotTo(HaveOccurred())
+			be, err = backend.NewClient(config)
+			Expect(err).NotTo(HaveOccurred())
+			be.Clean()
+
+			seed(namespace1, legacyName, legacySpec)
+			seed(namespace1, legacyWorkloadName, legacyWorkloadSpec)
+			seed(namespace2, name2, legacyCNISpec)
+			seed(namespace2, unconvertibleName, unconvertibleSpec)
+			_, err = c.WorkloadEndpoints().Create(ctx, &libapiv3.WorkloadEndpoint{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace1, Name: name1},
+				Spec:       spec1_1,
+			}, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		// withoutErrors returns the results with the errors removed, for comparison.
+		withoutErrors := func(results []clientv3.WorkloadEndpointMigrateResult) []clientv3.WorkloadEndpointMigrateResult {
+			out := make([]clientv3.WorkloadEndpointMigrateResult, len(results))
+			for i, r := range results {
+				if r.Action == clientv3.WorkloadEndpointMigrateFailed {
+					ExpectWithOffset(1, r.Error).To(HaveOccurred())
+				} else {
+					ExpectWithOffset(1, r.Error).NotTo(HaveOccurred())
+				}
+				r.Error = nil
+				out[i] = r
+			}
+			return out
+		}
+
+		It("should convert legacy WorkloadEndpoints and be idempotent", func() {
+			results, err := c.WorkloadEndpoints().Migrate(ctx, clientv3.WorkloadEndpointMigrateOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(withoutErrors(results)).To(Equal([]clientv3.WorkloadEndpointMigrateResult{
+				{Namespace: namespace1, Name: legacyName, NewName: "node--1-k8s-pod-eth0", Action: clientv3.WorkloadEndpointMigrateCreated},
+				{Namespace: namespace1, Name: legacyWorkloadName, NewName: "node--2-k8s-pod2-eth0", Action: clientv3.WorkloadEndpointMigrateCreated},
+				{Namespace: namespace2, Name: name2, NewName: name2, Action: clientv3.WorkloadEndpointMigrateUpdated},
+				{Namespace: namespace2, Name: unconvertibleName, Action: clientv3.WorkloadEndpointMigrateFailed},
+			}))
+
+			expectWEP(namespace1, "node--1-k8s-pod-eth0", migratedSpec)
+			expectWEP(namespace1, "node--2-k8s-pod2-eth0", migratedWorkloadSpec)
+			cni := expectWEP(namespace2, name2, migratedCNISpec)
+			Expect(cni.UID).To(Equal(types.UID("uid-" + name2)))
+
+			By("Leaving the legacy WorkloadEndpoints in place")
+			expectWEP(namespace1, legacyName, legacySpec)
+			expectWEP(namespace1, legacyWorkloadName, legacyWorkloadSpec)
+
+			By("Migrating again without writing anything")
+			before, err := c.WorkloadEndpoints().List(ctx, options.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			results, err = c.WorkloadEndpoints().Migrate(ctx, clientv3.WorkloadEndpointMigrateOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(withoutErrors(results)).To(Equal([]clientv3.WorkloadEndpointMigrateResult{
+				{Namespace: namespace1, Name: legacyName, NewName: "node--1-k8s-pod-eth0", Action: clientv3.WorkloadEndpointMigrateAlreadyMigrated},
+				{Namespace: namespace1, Name: legacyWorkloadName, NewName: "node--2-k8s-pod2-eth0", Action: clientv3.WorkloadEndpointMigrateAlreadyMigrated},
+				{Namespace: namespace2, Name: unconvertibleName, Action: clientv3.WorkloadEndpointMigrateFailed},
+			}))
+			after, err := c.WorkloadEndpoints().List(ctx, options.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(after.Items).To(ConsistOf(before.Items))
+		})
+
+		It("should delete the legacy WorkloadEndpoints if requested", func() {
+			opts := clientv3.WorkloadEndpointMigrateOptions{DeleteLegacy: true}
+			results, err := c.WorkloadEndpoints().Migrate(ctx, opts)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(withoutErrors(results)).To(Equal([]clientv3.WorkloadEndpointMigrateResult{
+				{Namespace: namespace1, Name: legacyName, NewName: "node--1-k8s-pod-eth0", Action: clientv3.WorkloadEndpointMigrateCreated, LegacyDeleted: true},
+				{Namespace: namespace1, Name: legacyWorkloadName, NewName: "node--2-k8s-pod2-eth0", Action: clientv3.WorkloadEndpointMigrateCreated, LegacyDeleted: true},
+				{Namespace: namespace2, Name: name2, NewName: name2, Action: clientv3.WorkloadEndpointMigrateUpdated},
+				{Namespace: namespace2, Name: unconvertibleName, Action: clientv3.WorkloadEndpointMigrateFailed},
+			}))
+			expectNoWEP(namespace1, legacyName)
+			expectNoWEP(namespace1, legacyWorkloadName)
+			expectWEP(namespace1, "node--1-k8s-pod-eth0", migratedSpec)
+			expectWEP(namespace1, "node--2-k8s-pod2-eth0", migratedWorkloadSpec)
+			expectWEP(namespace2, unconvertibleName, unconvertibleSpec)
+
+			By("Finding nothing more to migrate")
+			results, err = c.WorkloadEndpoints().Migrate(ctx, opts)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(withoutErrors(results)).To(Equal([]clientv3.WorkloadEndpointMigrateResult{
+				{Namespace: namespace2, Name: unconvertibleName, Action: clientv3.WorkloadEndpointMigrateFailed},
+			}))
+		})
+
+		It("should resume a migration that was interrupted after writing a replacement", func() {
+			_, err := c.WorkloadEndpoints().Create(ctx, &libapiv3.WorkloadEndpoint{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace1, Name: "node--1-k8s-pod-eth0"},
+				Spec:       migratedSpec,
+			}, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			results, err := c.WorkloadEndpoints().Migrate(ctx, clientv3.WorkloadEndpointMigrateOptions{Namespace: namespace1, DeleteLegacy: true})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(withoutErrors(results)).To(Equal([]clientv3.WorkloadEndpointMigrateResult{
+				{Namespace: namespace1, Name: legacyName, NewName: "node--1-k8s-pod-eth0", Action: clientv3.WorkloadEndpointMigrateAlreadyMigrated, LegacyDeleted: true},
+				{Namespace: namespace1, Name: legacyWorkloadName, NewName: "node--2-k8s-pod2-eth0", Action: clientv3.WorkloadEndpointMigrateCreated, LegacyDeleted: true},
+			}))
+			expectNoWEP(namespace1, legacyName)
+
+			By("Leaving the other namespaces alone")
+			expectWEP(namespace2, name2, legacyCNISpec)
+		})
+
+		It("should not replace a different WorkloadEndpoint that already has the new name", func() {
+			spec := migratedSpec
+			spec.InterfaceName = "cali9999"
+			_, err := c.WorkloadEndpoints().Create(ctx, &libapiv3.WorkloadEndpoint{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: namespace1,
+					Name:      "node--1-k8s-pod-eth0",
+					Labels:    map[string]string{"app": "legacy"},
+				},
+				Spec: spec,
+			}, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			results, err := c.WorkloadEndpoints().Migrate(ctx, clientv3.WorkloadEndpointMigrateOptions{Namespace: namespace1, DeleteLegacy: true})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(results).To(HaveLen(2))
+			Expect(results[0].Action).To(Equal(clientv3.WorkloadEndpointMigrateFailed))
+			Expect(results[0].Error).To(MatchError(ContainSubstring("already exists")))
+			Expect(results[0].Error).To(BeAssignableToTypeOf(errors.ErrorResourceAlreadyExists{}))
+			Expect(results[0].LegacyDeleted).To(BeFalse())
+			expectWEP(namespace1, legacyName, legacySpec)
+			expectWEP(namespace1, "node--1-k8s-pod-eth0", spec)
+		})
+	})
+
+	Describe("WorkloadEndpoint watch bookmarks", func() {
+		var c clientv3.Interface
+
+		BeforeEach(func() {
+			var err error
+			c, err = clientv3.New(config)
+			Expect(err).NotTo(HaveOccurred())
+
+			be, err := backend.NewClient(config)
+			Expect(err).NotTo(HaveOccurred())
+			be.Clean()
+		})
+
+		parseRevision := func(rv string) int64 {
+			rev, err := strconv.ParseInt(rv, 10, 64)
+			Expect(err).NotTo(HaveOccurred())
+			return rev
+		}
+
+		It("should send bookmarks with non-decreasing revisions while nothing changes", func() {
+			_, err := c.WorkloadEndpoints().Create(ctx, &libapiv3.WorkloadEndpoint{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace1, Name: name1},
+				Spec:       spec1_1,
+			}, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			list, err := c.WorkloadEndpoints().List(ctx, options.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			start := parseRevision(list.ResourceVersion)
+
+			w, err := c.WorkloadEndpoints().Watch(ctx, options.ListOptions{
+				ResourceVersion:       list.ResourceVersion,
+				AllowWatchBookmarks:   true,
+				WatchBookmarkInterval: 200 * time.Millisecond,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			testWatcher := testutils.NewTestResourceWatch(config.Spec.DatastoreType, w)
+			defer testWatcher.Stop()
+
+			By("Receiving bookmarks, and no other events, without making any changes")
+			last := start
+			for i := 0; i < 3; i++ {
+				rev := parseRevision(testWatcher.ExpectBookmark(5 * time.Second))
+				Expect(rev).To(BeNumerically(">=", last))
+				last = rev
+			}
+
+			By("Receiving a later revision after an unrelated resource changes")
+			_, err = c.Profiles().Create(ctx, &apiv3.Profile{
+				ObjectMeta: metav1.ObjectMeta{Name: "bookmark-profile"},
+			}, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Eventually(func() int64 {
+				return parseRevision(testWatcher.ExpectBookmark(5 * time.Second))
+			}, 5*time.Second).Should(BeNumerically(">", last))
+		})
+
+		It("should not send bookmarks unless they are allowed", func() {
+			w, err := c.WorkloadEndpoints().Watch(ctx, options.ListOptions{WatchBookmarkInterval: 100 * time.Millisecond})
+			Expect(err).NotTo(HaveOccurred())
+			testWatcher := testutils.NewTestResourceWatch(config.Spec.DatastoreType, w)
+			defer testWatcher.Stop()
+
+			time.Sleep(500 * time.Millisecond)
+			testWatcher.ExpectEvents(libapiv3.KindWorkloadEndpoint, []watch.Event{})
+		})
+
+		It("should reject a negative bookmark interval", func() {
+			_, err := c.WorkloadEndpoints().Watch(ctx, options.ListOptions{
+				AllowWatchBookmarks:   true,
+				WatchBookmarkInterval: -time.Second,
+			})
+			Expect(err).To(BeAssignableToTypeOf(errors.ErrorValidation{}))
+		})
+	})
+
+	Describe("WorkloadEndpoint CreateMany", func() {
+		var c clientv3.Interface
+
+		BeforeEach(func() {
+			var err error
+			c, err = clientv3.New(config)
+			Expect(err).NotTo(HaveOccurred())
+
+			be, err := backend.NewClient(config)
+			Expect(err).NotTo(HaveOccurred())
+			be.Clean()
+		})
+
+		// newWEP returns a new WorkloadEndpoint in namespace2 for the container.
+		newWEP := func(cid string) *libapiv3.WorkloadEndpoint {
+			spec := spec2_1
+			spec.ContainerID = cid
+			return &libapiv3.WorkloadEndpoint{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace2, Name: "node--2-cni-" + cid + "-eth0"},
+				Spec:       spec,
+			}
+		}
+
+		listNames := func() []string {
+			list, err := c.WorkloadEndpoints().List(ctx, options.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			var names []string
+			for _, wep := range list.Items {
+				names = append(names, wep.Name)
+			}
+			sort.Strings(names)
+			return names
+		}
+
+		It("should create all of the WorkloadEndpoints", func() {
+			in := []*libapiv3.WorkloadEndpoint{newWEP("c1"), newWEP("c2"), newWEP("c3")}
+			results, err := c.WorkloadEndpoints().CreateMany(ctx, in, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(results).To(HaveLen(3))
+			for i, result := range results {
+				Expect(result.Error).NotTo(HaveOccurred())
+				Expect(result.Object).To(MatchResource(libapiv3.KindWorkloadEndpoint, namespace2, in[i].Name, in[i].Spec))
+				Expect(result.Object.ResourceVersion).NotTo(BeEmpty())
+				Expect(result.Object.CreationTimestamp.IsZero()).To(BeFalse())
+			}
+			Expect(listNames()).To(Equal([]string{"node--2-cni-c1-eth0", "node--2-cni-c2-eth0", "node--2-cni-c3-eth0"}))
+		})
+
+		It("should create the rest of a batch where one WorkloadEndpoint already exists", func() {
+			existing, err := c.WorkloadEndpoints().Create(ctx, newWEP("c2"), options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			dup := newWEP("c2")
+			dup.Spec.InterfaceName = "cali9999"
+			in := []*libapiv3.WorkloadEndpoint{newWEP("c1"), dup, newWEP("c3")}
+			results, err := c.WorkloadEndpoints().CreateMany(ctx, in, options.SetOptions{})
+
+			By("Reporting the failure of the duplicate")
+			Expect(err).To(BeAssignableToTypeOf(errors.ErrorAggregate{}))
+			Expect(err.(errors.ErrorAggregate).Errors).To(HaveLen(1))
+			Expect(results).To(HaveLen(3))
+			Expect(results[1].Object).To(BeNil())
+			var exists errors.ErrorResourceAlreadyExists
+			Expect(goerrors.As(results[1].Error, &exists)).To(BeTrue())
+			Expect(exists.Name()).To(Equal(dup.Name))
+
+			By("Creating the others, in input order")
+			for _, i := range []int{0, 2} {
+				Expect(results[i].Error).NotTo(HaveOccurred())
+				Expect(results[i].Object).To(MatchResource(libapiv3.KindWorkloadEndpoint, namespace2, in[i].Name, in[i].Spec))
+			}
+			Expect(listNames()).To(Equal([]string{"node--2-cni-c1-eth0", "node--2-cni-c2-eth0", "node--2-cni-c3-eth0"}))
+
+			By("Leaving the existing WorkloadEndpoint unchanged")
+			wep, err := c.WorkloadEndpoints().Get(ctx, namespace2, dup.Name, options.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(wep.ResourceVersion).To(Equal(existing.ResourceVersion))
+			Expect(wep.Spec.InterfaceName).To(Equal(existing.Spec.InterfaceName))
+		})
+
+		It("should reject a WorkloadEndpoint that is repeated in the batch", func() {
+			in := []*libapiv3.WorkloadEndpoint{newWEP("c1"), newWEP("c2"), newWEP("c1")}
+			results, err := c.WorkloadEndpoints().CreateMany(ctx, in, options.SetOptions{})
+			Expect(err).To(BeAssignableToTypeOf(errors.ErrorAggregate{}))
+			Expect(results[0].Error).NotTo(HaveOccurred())
+			Expect(results[1].Error).NotTo(HaveOccurred())
+			Expect(results[2].Error).To(BeAssignableToTypeOf(errors.ErrorResourceAlreadyExists{}))
+			Expect(goerrors.Unwrap(results[2].Error)).To(MatchError(ContainSubstring("index 0")))
+			Expect(listNames()).To(Equal([]string{"node--2-cni-c1-eth0", "node--2-cni-c2-eth0"}))
+		})
+
+		It("should create the valid WorkloadEndpoints of a batch with an invalid one", func() {
+			invalid := newWEP("c2")
+			invalid.Spec.InterfaceName = "not a valid interface name"
+			in := []*libapiv3.WorkloadEndpoint{newWEP("c1"), invalid, nil, newWEP("c3")}
+			results, err := c.WorkloadEndpoints().CreateMany(ctx, in, options.SetOptions{})
+			Expect(err).To(BeAssignableToTypeOf(errors.ErrorAggregate{}))
+			Expect(err.(errors.ErrorAggregate).Errors).To(HaveLen(2))
+			Expect(results[1].Error).To(BeAssignableToTypeOf(errors.ErrorValidation{}))
+			Expect(results[2].Error).To(BeAssignableToTypeOf(errors.ErrorValidation{}))
+			Expect(listNames()).To(Equal([]string{"node--2-cni-c1-eth0", "node--2-cni-c3-eth0"}))
+		})
+
+		It("should create more WorkloadEndpoints than fit in a single transaction", func() {
+			var in []*libapiv3.WorkloadEndpoint
+			for i := 0; i < 150; i++ {
+				in = append(in, newWEP(fmt.Sprintf("bulk%d", i)))
+			}
+			in[120] = newWEP("bulk0")
+			results, err := c.WorkloadEndpoints().CreateMany(ctx, in, options.SetOptions{})
+			Expect(err).To(BeAssignableToTypeOf(errors.ErrorAggregate{}))
+			for i, result := range results {
+				if i == 120 {
+					Expect(result.Error).To(BeAssignableToTypeOf(errors.ErrorResourceAlreadyExists{}))
+					continue
+				}
+				Expect(result.Error).NotTo(HaveOccurred())
+				Expect(result.Object.Name).To(Equal(in[i].Name))
+			}
+			Expect(listNames()).To(HaveLen(149))
+		})
+	})
+	Describe("WorkloadEndpoint default interface name", func() {
+		var c clientv3.Interface
+
+		BeforeEach(func() {
+			var err error
+			c, err = clientv3.New(config)
+			Expect(err).NotTo(HaveOccurred())
+
+			be, err := backend.NewClient(config)
+			Expect(err).NotTo(HaveOccurred())
+			be.Clean()
+		})
+
+		It("should store the same derived interface name each time the WorkloadEndpoint is created", func() {
+			spec := spec1_1
+			spec.InterfaceName = ""
+			wep := &libapiv3.WorkloadEndpoint{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace1, Name: name1},
+				Spec:       spec,
+			}
+			expected := clientv3.DefaultInterfaceName(namespace1, name1)
+			Expect(expected).To(HaveLen(15))
+
+			created, err := c.WorkloadEndpoints().Create(ctx, wep, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(created.Spec.InterfaceName).To(Equal(expected))
+			Expect(wep.Spec.InterfaceName).To(BeEmpty())
+
+			got, err := c.WorkloadEndpoints().Get(ctx, namespace1, name1, options.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(got.Spec.InterfaceName).To(Equal(expected))
+
+			By("retrying the create after deleting the WorkloadEndpoint")
+			_, err = c.WorkloadEndpoints().Delete(ctx, namespace1, name1, options.DeleteOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			created, err = c.WorkloadEndpoints().Create(ctx, wep, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(created.Spec.InterfaceName).To(Equal(expected))
+		})
+
+		It("should leave an explicitly provided interface name untouched", func() {
+			created, err := c.WorkloadEndpoints().Create(ctx, &libapiv3.WorkloadEndpoint{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace1, Name: name1},
+				Spec:       spec1_1,
+			}, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(created.Spec.InterfaceName).To(Equal(spec1_1.InterfaceName))
+		})
+	})
+
+	Describe("WorkloadEndpoint LatestResourceVersion", func() {
+		var c clientv3.Interface
+
+		BeforeEach(func() {
+			var err error
+			c, err = clientv3.New(config)
+			Expect(err).NotTo(HaveOccurred())
+
+			be, err := backend.NewClient(config)
+			Expect(err).NotTo(HaveOccurred())
+			be.Clean()
+		})
+
+		latest := func() int64 {
+			rv, err := c.WorkloadEndpoints().LatestResourceVersion(ctx)
+			ExpectWithOffset(1, err).NotTo(HaveOccurred())
+			rev, err := strconv.ParseInt(rv, 10, 64)
+			ExpectWithOffset(1, err).NotTo(HaveOccurred())
+			return rev
+		}
+
+		It("should increase after a Create", func() {
+			before := latest()
+			wep, err := c.WorkloadEndpoints().Create(ctx, &libapiv3.WorkloadEndpoint{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace1, Name: name1},
+				Spec:       spec1_1,
+			}, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			after := latest()
+			Expect(after).To(BeNumerically(">", before))
+			Expect(strconv.FormatInt(after, 10)).To(Equal(wep.ResourceVersion))
+
+			list, err := c.WorkloadEndpoints().List(ctx, options.ListOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(list.ResourceVersion).To(Equal(wep.ResourceVersion))
+		})
+
+		It("should start a Watch that only reports later changes", func() {
+			_, err := c.WorkloadEndpoints().Create(ctx, &libapiv3.WorkloadEndpoint{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace1, Name: name1},
+				Spec:       spec1_1,
+			}, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			rv, err := c.WorkloadEndpoints().LatestResourceVersion(ctx)
+			Expect(err).NotTo(HaveOccurred())
+			w, err := c.WorkloadEndpoints().Watch(ctx, options.ListOptions{ResourceVersion: rv})
+			Expect(err).NotTo(HaveOccurred())
+			defer w.Stop()
+
+			wep2, err := c.WorkloadEndpoints().Create(ctx, &libapiv3.WorkloadEndpoint{
+				ObjectMeta: metav1.ObjectMeta{Namespace: namespace2, Name: name2},
+				Spec:       spec2_1,
+			}, options.SetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			var e watch.Event
+			Eventually(w.ResultChan(), 5*time.Second).Should(Receive(&e))
+			Expect(e.Type).To(Equal(watch.Added))
+			Expect(e.Object.(*libapiv3.WorkloadEndpoint).Name).To(Equal(wep2.Name))
+			Consistently(w.ResultChan(), 200*time.Millisecond).ShouldNot(Receive())
+		})
+	})
 })
+
+// countingBackend wraps a backend client and counts the operations made against it.
+type countingBackend struct {
+	bapi.Client
+	gets, lists, watches, writes int
+}
+
+func (b *countingBackend) Get(ctx context.Context, key model.Key, revision string) (*model.KVPair, error) {
+	b.gets++
+	return b.Client.Get(ctx, key, revision)
+}
+
+func (b *countingBackend) List(ctx context.Context, list model.ListInterface, revision string) (*model.KVPairList, error) {
+	b.lists++
+	return b.Client.List(ctx, list, revision)
+}
+
+func (b *countingBackend) Watch(ctx context.Context, list model.ListInterface, revision string) (bapi.WatchInterface, error) {
+	b.watches++
+	return b.Client.Watch(ctx, list, revision)
+}
+
+func (b *countingBackend) Create(ctx context.Context, kvp *model.KVPair) (*model.KVPair, error) {
+	b.writes++
+	return b.Client.Create(ctx, kvp)
+}
+
+func (b *countingBackend) Update(ctx context.Context, kvp *model.KVPair) (*model.KVPair, error) {
+	b.writes++
+	return b.Client.Update(ctx, kvp)
+}
+
+func (b *countingBackend) Apply(ctx context.Context, kvp *model.KVPair) (*model.KVPair, error) {
+	b.writes++
+	return b.Client.Apply(ctx, kvp)
+}
+
+func (b *countingBackend) Delete(ctx context.Context, key model.Key, revision string) (*model.KVPair, error) {
+	b.writes++
+	return b.Client.Delete(ctx, key, revision)
+}
+
+func (b *countingBackend) DeleteKVP(ctx context.Context, kvp *model.KVPair) (*model.KVPair, error) {
+	b.writes++
+	return b.Client.DeleteKVP(ctx, kvp)
+}
+
+// memCursorStore is an in-memory clientv3.WatchCursorStore.
+type memCursorStore struct {
+	lock   sync.Mutex
+	cursor string
+}
+
+func (s *memCursorStore) LoadCursor(ctx context.Context) (string, error) {
+	return s.Cursor(), nil
+}
+
+func (s *memCursorStore) StoreCursor(ctx context.Context, cursor string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.cursor = cursor
+	return nil
+}
+
+func (s *memCursorStore) Cursor() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.cursor
+}
+
+// compactedBackend wraps a backend client and fails watches that resume from a revision before
+// compactRev, as etcd does for compacted revisions.
+type compactedBackend struct {
+	bapi.Client
+	compactRev int64
+}
+
+func (b *compactedBackend) Watch(ctx context.Context, list model.ListInterface, revision string) (bapi.WatchInterface, error) {
+	if rev, err := strconv.ParseInt(revision, 10, 64); err == nil && rev < b.compactRev {
+		return newErrorWatcher(rpctypes.ErrCompacted), nil
+	}
+	return b.Client.Watch(ctx, list, revision)
+}
+
+// errorWatcher is a backend watcher that sends a single error and then terminates.
+type errorWatcher struct {
+	results chan bapi.WatchEvent
+}
+
+func newErrorWatcher(err error) *errorWatcher {
+	w := &errorWatcher{results: make(chan bapi.WatchEvent, 1)}
+	w.results <- bapi.WatchEvent{Type: bapi.WatchError, Error: err}
+	close(w.results)
+	return w
+}
+
+func (w *errorWatcher) Stop() {}
+
+func (w *errorWatcher) ResultChan() <-chan bapi.WatchEvent {
+	return w.results
+}
+
+func (w *errorWatcher) HasTerminated() bool {
+	return true
+}
+
+// gapBackend wraps a backend client so that tests can simulate a gap in its watches: between
+// StartGap and EndGap, the watchers silently drop events; EndGap then fails them with the given
+// error, as etcd would if the watch fell too far behind.
+type gapBackend struct {
+	bapi.Client
+	lock     sync.Mutex
+	watchers []*gapWatcher
+}
+
+func (b *gapBackend) Watch(ctx context.Context, list model.ListInterface, revision string) (bapi.WatchInterface, error) {
+	inner, err := b.Client.Watch(ctx, list, revision)
+	if err != nil {
+		return nil, err
+	}
+	w := &gapWatcher{
+		inner:   inner,
+		results: make(chan bapi.WatchEvent, 100),
+		failC:   make(chan error, 1),
+	}
+	go w.run()
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.watchers = append(b.watchers, w)
+	return w, nil
+}
+
+func (b *gapBackend) NumWatchers() int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return len(b.watchers)
+}
+
+func (b *gapBackend) StartGap() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for _, w := range b.watchers {
+		atomic.StoreInt32(&w.dropping, 1)
+	}
+}
+
+func (b *gapBackend) EndGap(err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for _, w := range b.watchers {
+		w.failC <- err
+	}
+	b.watchers = nil
+}
+
+// gapWatcher is the backend watcher returned by gapBackend.
+type gapWatcher struct {
+	inner    bapi.WatchInterface
+	results  chan bapi.WatchEvent
+	failC    chan error
+	dropping int32
+}
+
+func (w *gapWatcher) run() {
+	defer close(w.results)
+	for {
+		select {
+		case e, ok := <-w.inner.ResultChan():
+			if !ok {
+				return
+			}
+			if atomic.LoadInt32(&w.dropping) == 0 {
+				w.results <- e
+			}
+		case err := <-w.failC:
+			w.inner.Stop()
+			w.results <- bapi.WatchEvent{Type: bapi.WatchError, Error: err}
+			return
+		}
+	}
+}
+
+func (w *gapWatcher) Stop() {
+	w.inner.Stop()
+}
+
+func (w *gapWatcher) ResultChan() <-chan bapi.WatchEvent {
+	return w.results
+}
+
+func (w *gapWatcher) HasTerminated() bool {
+	return w.inner.HasTerminated()
+}
+
+// hookCall records a call to one of the recordingHooks.
+type hookCall struct {
+	Op            string
+	Before, After *libapiv3.WorkloadEndpoint
+}
+
+// recordingHooks is a clientv3.WorkloadEndpointHooks that records the calls made to it.
+type recordingHooks struct {
+	calls []hookCall
+}
+
+func (h *recordingHooks) OnCreate(_ context.Context, created *libapiv3.WorkloadEndpoint) {
+	h.calls = append(h.calls, hookCall{Op: "create", After: created})
+}
+
+func (h *recordingHooks) OnUpdate(_ context.Context, before, after *libapiv3.WorkloadEndpoint) {
+	h.calls = append(h.calls, hookCall{Op: "update", Before: before, After: after})
+}
+
+func (h *recordingHooks) OnDelete(_ context.Context, deleted *libapiv3.WorkloadEndpoint) {
+	h.calls = append(h.calls, hookCall{Op: "delete", Before: deleted})
+}
@@ -0,0 +1,168 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clientv3
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+
+	apiv3 "github.com/projectcalico/api/pkg/apis/projectcalico/v3"
+
+	libapiv3 "github.com/projectcalico/calico/libcalico-go/lib/apis/v3"
+	"github.com/projectcalico/calico/libcalico-go/lib/errors"
+	"github.com/projectcalico/calico/libcalico-go/lib/options"
+)
+
+const (
+	// WorkloadEndpointExportKind is the kind recorded in the header of a WorkloadEndpoint export.
+	WorkloadEndpointExportKind = "WorkloadEndpointExport"
+	// WorkloadEndpointExportVersion is the version of the export format written by ExportAll.
+	// ImportAll rejects exports with a different version.
+	WorkloadEndpointExportVersion = 1
+)
+
+// workloadEndpointExportHeader is the first JSON value of an export.  It is followed by one
+// JSON-encoded WorkloadEndpoint per line.
+type workloadEndpointExportHeader struct {
+	Kind          string `json:"kind"`
+	FormatVersion int    `json:"formatVersion"`
+}
+
+// WorkloadEndpointImportOptions are the options for ImportAll.
+type WorkloadEndpointImportOptions struct {
+	// Upsert makes ImportAll update WorkloadEndpoints that already exist, rather than
+	// reporting an error for them.
+	Upsert bool
+}
+
+// WorkloadEndpointImportAction is the action that ImportAll took for a WorkloadEndpoint.
+type WorkloadEndpointImportAction string
+
+const (
+	WorkloadEndpointImportCreated WorkloadEndpointImportAction = "Created"
+	WorkloadEndpointImportUpdated WorkloadEndpointImportAction = "Updated"
+	WorkloadEndpointImportFailed  WorkloadEndpointImportAction = "Failed"
+)
+
+// WorkloadEndpointImportResult is the result of importing a single WorkloadEndpoint.
+type WorkloadEndpointImportResult struct {
+	Namespace string
+	Name      string
+	Action    WorkloadEndpointImportAction
+	// Error is set if the Action is WorkloadEndpointImportFailed.
+	Error error
+}
+
+// ExportAll writes all WorkloadEndpoints, in all namespaces, to w in a versioned format that can
+// be read by ImportAll.  The WorkloadEndpoints are read from the primary datastore and written in
+// namespace/name order, so exporting the same data always produces the same output.  Reserved
+// WorkloadEndpoints are not exported.
+func (r workloadEndpoints) ExportAll(ctx context.Context, w io.Writer) error {
+	list, err := r.List(ctx, options.ListOptions{Consistent: true, Reserved: options.ReservedExclude})
+	if err != nil {
+		return err
+	}
+	sort.Slice(list.Items, func(i, j int) bool {
+		a, b := list.Items[i], list.Items[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(workloadEndpointExportHeader{
+		Kind:          WorkloadEndpointExportKind,
+		FormatVersion: WorkloadEndpointExportVersion,
+	}); err != nil {
+		return err
+	}
+	for i := range list.Items {
+		wep := &list.Items[i]
+		wep.APIVersion = apiv3.GroupVersionCurrent
+		wep.Kind = libapiv3.KindWorkloadEndpoint
+		if err := enc.Encode(wep); err != nil {
+			return err
+		}
+	}
+	log.WithField("numWorkloadEndpoints", len(list.Items)).Info("Exported WorkloadEndpoints")
+	return nil
+}
+
+// ImportAll reads WorkloadEndpoints written by ExportAll from rd and creates each of them,
+// stripping the exported ResourceVersion.  As for any Create, the created WorkloadEndpoints are
+// given a new creation timestamp.  If opts.Upsert is set, WorkloadEndpoints that already exist
+// are updated instead.  A result is returned for each WorkloadEndpoint in the export; a
+// failure to import one WorkloadEndpoint does not stop the import of the rest.  An error is
+// only returned if the export itself can't be read, in which case the results cover the
+// WorkloadEndpoints that were read before the error.
+func (r workloadEndpoints) ImportAll(ctx context.Context, rd io.Reader, opts WorkloadEndpointImportOptions) ([]WorkloadEndpointImportResult, error) {
+	dec := json.NewDecoder(rd)
+	var header workloadEndpointExportHeader
+	if err := dec.Decode(&header); err != nil {
+		return nil, fmt.Errorf("failed to read WorkloadEndpoint export header: %w", err)
+	}
+	if header.Kind != WorkloadEndpointExportKind || header.FormatVersion != WorkloadEndpointExportVersion {
+		return nil, fmt.Errorf("unsupported WorkloadEndpoint export: kind %q, format version %d",
+			header.Kind, header.FormatVersion)
+	}
+
+	var results []WorkloadEndpointImportResult
+	for {
+		wep := &libapiv3.WorkloadEndpoint{}
+		if err := dec.Decode(wep); err == io.EOF {
+			break
+		} else if err != nil {
+			return results, fmt.Errorf("failed to read WorkloadEndpoint %d of export: %w", len(results)+1, err)
+		}
+		wep.ResourceVersion = ""
+		results = append(results, r.importOne(ctx, wep, opts))
+	}
+	log.WithField("numWorkloadEndpoints", len(results)).Info("Imported WorkloadEndpoints")
+	return results, nil
+}
+
+// importOne creates (or, for an upsert, updates) a single imported WorkloadEndpoint.
+func (r workloadEndpoints) importOne(ctx context.Context, wep *libapiv3.WorkloadEndpoint, opts WorkloadEndpointImportOptions) WorkloadEndpointImportResult {
+	result := WorkloadEndpointImportResult{
+		Namespace: wep.Namespace,
+		Name:      wep.Name,
+		Action:    WorkloadEndpointImportCreated,
+	}
+	_, err := r.Create(ctx, wep, options.SetOptions{})
+	if _, ok := err.(errors.ErrorResourceAlreadyExists); ok && opts.Upsert {
+		result.Action = WorkloadEndpointImportUpdated
+		var existing *libapiv3.WorkloadEndpoint
+		existing, err = r.Get(ctx, wep.Namespace, wep.Name, options.GetOptions{Consistent: true})
+		if err == nil {
+			wep.ResourceVersion = existing.ResourceVersion
+			_, err = r.Update(ctx, wep, options.SetOptions{})
+		}
+	}
+	if err != nil {
+		log.WithError(err).WithFields(log.Fields{
+			"namespace": wep.Namespace,
+			"name":      wep.Name,
+		}).Warning("Failed to import WorkloadEndpoint")
+		result.Action = WorkloadEndpointImportFailed
+		result.Error = err
+	}
+	return result
+}
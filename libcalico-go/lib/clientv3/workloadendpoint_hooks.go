@@ -0,0 +1,68 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clientv3
+
+import (
+	"context"
+
+	libapiv3 "github.com/projectcalico/calico/libcalico-go/lib/apis/v3"
+)
+
+// WorkloadEndpointHooks receives a callback for each WorkloadEndpoint that is created, updated or
+// deleted through the client.  Unlike a watch, which may miss events (for example, across a
+// compaction), the hooks are called synchronously by the mutating operation, after the datastore
+// write succeeds and before the operation returns, so an in-process component (such as IPAM) is
+// guaranteed to see every mutation made through this client.  Mutations made by other clients
+// are not reported.
+//
+// The hooks are passed copies of the WorkloadEndpoints, and they are not called for failed or
+// dry-run operations.  Since the write has already been made, the hooks can't fail the operation;
+// they should be quick, as they delay the caller.
+type WorkloadEndpointHooks interface {
+	// OnCreate is called with the stored WorkloadEndpoint after it has been created.
+	OnCreate(ctx context.Context, created *libapiv3.WorkloadEndpoint)
+	// OnUpdate is called with the stored WorkloadEndpoint before and after an update.
+	OnUpdate(ctx context.Context, before, after *libapiv3.WorkloadEndpoint)
+	// OnDelete is called with the last stored state of a WorkloadEndpoint after it has been
+	// deleted.
+	OnDelete(ctx context.Context, deleted *libapiv3.WorkloadEndpoint)
+}
+
+// WithWorkloadEndpointHooks registers hooks that are called for each WorkloadEndpoint mutation
+// made through the client; see WorkloadEndpointHooks.  Hooks are called in the order that they
+// are registered.
+func WithWorkloadEndpointHooks(hooks ...WorkloadEndpointHooks) Option {
+	return func(c *client) {
+		c.wepHooks = append(c.wepHooks, hooks...)
+	}
+}
+
+func (r workloadEndpoints) runCreateHooks(ctx context.Context, created *libapiv3.WorkloadEndpoint) {
+	for _, h := range r.client.wepHooks {
+		h.OnCreate(ctx, created.DeepCopy())
+	}
+}
+
+func (r workloadEndpoints) runUpdateHooks(ctx context.Context, before, after *libapiv3.WorkloadEndpoint) {
+	for _, h := range r.client.wepHooks {
+		h.OnUpdate(ctx, before.DeepCopy(), after.DeepCopy())
+	}
+}
+
+func (r workloadEndpoints) runDeleteHooks(ctx context.Context, deleted *libapiv3.WorkloadEndpoint) {
+	for _, h := range r.client.wepHooks {
+		h.OnDelete(ctx, deleted.DeepCopy())
+	}
+}
@@ -0,0 +1,57 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clientv3
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+
+	log "github.com/sirupsen/logrus"
+
+	libapiv3 "github.com/projectcalico/calico/libcalico-go/lib/apis/v3"
+)
+
+const (
+	// DefaultInterfacePrefix is the prefix of the interface name that is derived for a
+	// WorkloadEndpoint created without one.
+	DefaultInterfacePrefix = "cali"
+
+	// maxInterfaceNameLen is the longest interface name that Linux allows: IFNAMSIZ is 16,
+	// including the terminating NUL.
+	maxInterfaceNameLen = 15
+)
+
+// DefaultInterfaceName returns the interface name that is derived for a WorkloadEndpoint created
+// without one: DefaultInterfacePrefix followed by as many hex digits of the SHA-1 hash of the
+// WorkloadEndpoint's namespace and name as fit in maxInterfaceNameLen.  The same namespace and name
+// always give the same interface name, so a retried Create stores the same name.
+func DefaultInterfaceName(namespace, name string) string {
+	h := sha1.Sum([]byte(namespace + "." + name))
+	return DefaultInterfacePrefix + hex.EncodeToString(h[:])[:maxInterfaceNameLen-len(DefaultInterfacePrefix)]
+}
+
+// defaultInterfaceName sets the interface name of a WorkloadEndpoint that is about to be created,
+// if it has none.  The name must already be assigned.
+func defaultInterfaceName(res *libapiv3.WorkloadEndpoint) {
+	if res.Spec.InterfaceName != "" {
+		return
+	}
+	res.Spec.InterfaceName = DefaultInterfaceName(res.Namespace, res.Name)
+	log.WithFields(log.Fields{
+		"namespace":     res.Namespace,
+		"name":          res.Name,
+		"interfaceName": res.Spec.InterfaceName,
+	}).Debug("Derived WorkloadEndpoint interface name")
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clientv3
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	libapiv3 "github.com/projectcalico/calico/libcalico-go/lib/apis/v3"
+	"github.com/projectcalico/calico/libcalico-go/lib/options"
+)
+
+var _ = Describe("WorkloadEndpoint default interface name", func() {
+	var weps workloadEndpoints
+
+	BeforeEach(func() {
+		weps = workloadEndpoints{}
+	})
+
+	newWEP := func(namespace, pod, ifaceName string) *libapiv3.WorkloadEndpoint {
+		return &libapiv3.WorkloadEndpoint{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace},
+			Spec: libapiv3.WorkloadEndpointSpec{
+				Node:          "node1",
+				Orchestrator:  "k8s",
+				Pod:           pod,
+				Endpoint:      "eth0",
+				InterfaceName: ifaceName,
+			},
+		}
+	}
+
+	prepare := func(wep *libapiv3.WorkloadEndpoint) *libapiv3.WorkloadEndpoint {
+		var warnings wepWarnings
+		prepared, err := weps.prepareCreate(wep, options.SetOptions{}, &warnings)
+		ExpectWithOffset(1, err).NotTo(HaveOccurred())
+		return prepared
+	}
+
+	It("should derive a name that fits IFNAMSIZ when none is given", func() {
+		wep := newWEP("namespace1", "pod1", "")
+		prepared := prepare(wep)
+		Expect(prepared.Spec.InterfaceName).To(Equal(DefaultInterfaceName("namespace1", prepared.Name)))
+		Expect(prepared.Spec.InterfaceName).To(HavePrefix(DefaultInterfacePrefix))
+		Expect(prepared.Spec.InterfaceName).To(HaveLen(maxInterfaceNameLen))
+
+		By("not modifying the caller's WorkloadEndpoint")
+		Expect(wep.Spec.InterfaceName).To(BeEmpty())
+	})
+
+	It("should derive the same name each time", func() {
+		first := prepare(newWEP("namespace1", "pod1", ""))
+		second := prepare(newWEP("namespace1", "pod1", ""))
+		Expect(second.Spec.InterfaceName).To(Equal(first.Spec.InterfaceName))
+		Expect(DefaultInterfaceName("namespace1", "name1")).To(Equal("cali9544b99dcbc"))
+	})
+
+	It("should derive different names for different WorkloadEndpoints", func() {
+		names := map[string]bool{}
+		for _, wep := range []*libapiv3.WorkloadEndpoint{
+			newWEP("namespace1", "pod1", ""),
+			newWEP("namespace1", "pod2", ""),
+			newWEP("namespace2", "pod1", ""),
+		} {
+			names[prepare(wep).Spec.InterfaceName] = true
+		}
+		Expect(names).To(HaveLen(3))
+	})
+
+	It("should leave an explicitly provided name untouched", func() {
+		Expect(prepare(newWEP("namespace1", "pod1", "eth1")).Spec.InterfaceName).To(Equal("eth1"))
+	})
+})
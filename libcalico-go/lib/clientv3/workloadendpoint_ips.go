@@ -0,0 +1,130 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clientv3
+
+import (
+	"context"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+
+	libapiv3 "github.com/projectcalico/calico/libcalico-go/lib/apis/v3"
+	"github.com/projectcalico/calico/libcalico-go/lib/errors"
+	cnet "github.com/projectcalico/calico/libcalico-go/lib/net"
+	"github.com/projectcalico/calico/libcalico-go/lib/options"
+)
+
+// ipNetworkUpdateRetries is the number of times that AddIP and RemoveIP retry their
+// conditional update after a conflict with a concurrent write.
+const ipNetworkUpdateRetries = 5
+
+// AddIP adds the IP address to the IPNetworks of the WorkloadEndpoint.  The IP may be given as
+// an address or as a single-address CIDR.  Only IPNetworks is modified, using an update that is
+// conditional on the revision that was read, so concurrent changes to the WorkloadEndpoint are
+// not lost.  Adding an IP that is already present is a no-op.  Returns the resulting
+// WorkloadEndpoint.
+func (r workloadEndpoints) AddIP(ctx context.Context, namespace, name, ip string, opts options.SetOptions) (*libapiv3.WorkloadEndpoint, error) {
+	ipNet, err := parseWorkloadEndpointIP(ip)
+	if err != nil {
+		return nil, err
+	}
+	return r.modifyIPNetworks(ctx, namespace, name, opts, func(ipNetworks []string) ([]string, bool) {
+		for _, existing := range ipNetworks {
+			if sameIPNetwork(existing, ipNet) {
+				return ipNetworks, false
+			}
+		}
+		return append(ipNetworks, ipNet.String()), true
+	})
+}
+
+// RemoveIP removes the IP address from the IPNetworks of the WorkloadEndpoint.  The IP may be
+// given as an address or as a single-address CIDR.  Only IPNetworks is modified, using an update
+// that is conditional on the revision that was read.  Removing an IP that is not present is a
+// no-op.  Returns the resulting WorkloadEndpoint.
+func (r workloadEndpoints) RemoveIP(ctx context.Context, namespace, name, ip string, opts options.SetOptions) (*libapiv3.WorkloadEndpoint, error) {
+	ipNet, err := parseWorkloadEndpointIP(ip)
+	if err != nil {
+		return nil, err
+	}
+	return r.modifyIPNetworks(ctx, namespace, name, opts, func(ipNetworks []string) ([]string, bool) {
+		var remaining []string
+		for _, existing := range ipNetworks {
+			if !sameIPNetwork(existing, ipNet) {
+				remaining = append(remaining, existing)
+			}
+		}
+		return remaining, len(remaining) != len(ipNetworks)
+	})
+}
+
+// modifyIPNetworks reads the WorkloadEndpoint, applies the modification to a copy of its
+// IPNetworks and, if the modification made a change, writes it back with a conditional update,
+// retrying on conflict.
+func (r workloadEndpoints) modifyIPNetworks(
+	ctx context.Context, namespace, name string, opts options.SetOptions,
+	modify func(ipNetworks []string) ([]string, bool),
+) (*libapiv3.WorkloadEndpoint, error) {
+	logCxt := log.WithFields(log.Fields{"namespace": namespace, "name": name})
+	var out *libapiv3.WorkloadEndpoint
+	err := RetryOnConflict(ctx, ipNetworkUpdateRetries+1, 0, func() error {
+		wep, err := r.Get(ctx, namespace, name, options.GetOptions{Consistent: true})
+		if err != nil {
+			return err
+		}
+		ipNetworks, changed := modify(append([]string(nil), wep.Spec.IPNetworks...))
+		if !changed {
+			logCxt.Debug("IPNetworks already up to date")
+			out = wep
+			return nil
+		}
+		wep.Spec.IPNetworks = ipNetworks
+		out, err = r.Update(ctx, wep, opts)
+		return err
+	})
+	return out, err
+}
+
+// parseWorkloadEndpointIP parses an IP address or single-address CIDR, returning it as a
+// single-address CIDR.
+func parseWorkloadEndpointIP(ip string) (*cnet.IPNet, error) {
+	_, ipNet, err := cnet.ParseCIDROrIP(ip)
+	if err != nil {
+		return nil, ipValidationError(ip, "invalid IP address")
+	}
+	if ipNet.Version() != 4 && ipNet.Version() != 6 {
+		return nil, ipValidationError(ip, "IP address is neither IPv4 nor IPv6")
+	}
+	if ones, bits := ipNet.Mask.Size(); ones != bits {
+		return nil, ipValidationError(ip, fmt.Sprintf("IP network contains multiple addresses, expected a /%d", bits))
+	}
+	return ipNet, nil
+}
+
+func ipValidationError(ip, reason string) error {
+	return errors.ErrorValidation{
+		ErroredFields: []errors.ErroredField{{
+			Name:   "IP",
+			Value:  ip,
+			Reason: reason,
+		}},
+	}
+}
+
+// sameIPNetwork returns true if the IPNetworks entry is the same network as ipNet.
+func sameIPNetwork(entry string, ipNet *cnet.IPNet) bool {
+	_, existing, err := cnet.ParseCIDROrIP(entry)
+	return err == nil && existing.String() == ipNet.String()
+}
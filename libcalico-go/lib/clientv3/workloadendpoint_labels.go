@@ -0,0 +1,131 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clientv3
+
+import (
+	"context"
+
+	log "github.com/sirupsen/logrus"
+
+	libapiv3 "github.com/projectcalico/calico/libcalico-go/lib/apis/v3"
+	"github.com/projectcalico/calico/libcalico-go/lib/errors"
+	"github.com/projectcalico/calico/libcalico-go/lib/options"
+)
+
+// metadataUpdateRetries is the number of times that the label and annotation helpers retry their
+// conditional update after a conflict with a concurrent write.
+const metadataUpdateRetries = 5
+
+// SetLabelIfAbsent sets the label on the WorkloadEndpoint, but only if the label is currently
+// unset or already has the given value; this allows a label to be used as a lock, where the value
+// identifies the holder.  Only the label is modified, using an update that is conditional on the
+// revision that was read, so two callers can never both claim the label.  Returns the resulting
+// WorkloadEndpoint and whether the label now has the given value.  If the label has a different
+// value, the WorkloadEndpoint is returned unchanged along with false.
+func (r workloadEndpoints) SetLabelIfAbsent(ctx context.Context, namespace, name, key, value string, opts options.SetOptions) (*libapiv3.WorkloadEndpoint, bool, error) {
+	if key == "" {
+		return nil, false, labelValidationError(key, "label key must not be empty")
+	}
+	return r.modifyLabels(ctx, namespace, name, opts, func(labels map[string]string) (bool, bool) {
+		current, ok := labels[key]
+		if ok {
+			// Already held, either by the caller or by someone else.
+			return current == value, false
+		}
+		labels[key] = value
+		return true, true
+	})
+}
+
+// ClearLabel removes the label from the WorkloadEndpoint, but only if expectedValue is empty or
+// the label currently has that value; this allows the holder of a label-based lock to release it
+// without releasing someone else's claim.  Only the label is modified, using an update that is
+// conditional on the revision that was read.  Returns the resulting WorkloadEndpoint and whether
+// the label is now unset.
+func (r workloadEndpoints) ClearLabel(ctx context.Context, namespace, name, key, expectedValue string, opts options.SetOptions) (*libapiv3.WorkloadEndpoint, bool, error) {
+	if key == "" {
+		return nil, false, labelValidationError(key, "label key must not be empty")
+	}
+	return r.modifyLabels(ctx, namespace, name, opts, func(labels map[string]string) (bool, bool) {
+		current, ok := labels[key]
+		if !ok {
+			return true, false
+		}
+		if expectedValue != "" && current != expectedValue {
+			return false, false
+		}
+		delete(labels, key)
+		return true, true
+	})
+}
+
+// modifyLabels reads the WorkloadEndpoint, applies the modification to a copy of its labels and,
+// if the modification made a change, writes it back with a conditional update, retrying on
+// conflict.  The modification returns whether its condition was met and whether it changed the
+// labels.
+func (r workloadEndpoints) modifyLabels(
+	ctx context.Context, namespace, name string, opts options.SetOptions,
+	modify func(labels map[string]string) (applied, changed bool),
+) (*libapiv3.WorkloadEndpoint, bool, error) {
+	return r.modifyMetadataMap(ctx, namespace, name, opts, "labels",
+		func(wep *libapiv3.WorkloadEndpoint) *map[string]string { return &wep.Labels }, modify)
+}
+
+// modifyMetadataMap is as modifyLabels, for the map returned by field, which is described by
+// fieldName in logs.
+func (r workloadEndpoints) modifyMetadataMap(
+	ctx context.Context, namespace, name string, opts options.SetOptions,
+	fieldName string, field func(wep *libapiv3.WorkloadEndpoint) *map[string]string,
+	modify func(m map[string]string) (applied, changed bool),
+) (*libapiv3.WorkloadEndpoint, bool, error) {
+	logCxt := log.WithFields(log.Fields{"namespace": namespace, "name": name, "field": fieldName})
+	var out *libapiv3.WorkloadEndpoint
+	var applied bool
+	// On a conflict, someone else updated the WorkloadEndpoint, so we re-check the condition.
+	err := RetryOnConflict(ctx, metadataUpdateRetries+1, 0, func() error {
+		wep, err := r.Get(ctx, namespace, name, options.GetOptions{Consistent: true})
+		if err != nil {
+			return err
+		}
+		m := make(map[string]string, len(*field(wep))+1)
+		for k, v := range *field(wep) {
+			m[k] = v
+		}
+		var changed bool
+		applied, changed = modify(m)
+		if !changed {
+			logCxt.WithField("applied", applied).Debug("Metadata not changed")
+			out = wep
+			return nil
+		}
+		*field(wep) = m
+		out, err = r.Update(ctx, wep, opts)
+		return err
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return out, applied, nil
+}
+
+func labelValidationError(key, reason string) error {
+	return errors.ErrorValidation{
+		ErroredFields: []errors.ErroredField{{
+			Name:   "Labels",
+			Value:  key,
+			Reason: reason,
+		}},
+	}
+}
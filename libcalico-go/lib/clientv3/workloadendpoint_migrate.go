@@ -0,0 +1,226 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clientv3
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	libapiv3 "github.com/projectcalico/calico/libcalico-go/lib/apis/v3"
+	"github.com/projectcalico/calico/libcalico-go/lib/backend/model"
+	"github.com/projectcalico/calico/libcalico-go/lib/errors"
+	"github.com/projectcalico/calico/libcalico-go/lib/names"
+	"github.com/projectcalico/calico/libcalico-go/lib/options"
+)
+
+// WorkloadEndpointMigrateOptions are the options for Migrate.
+type WorkloadEndpointMigrateOptions struct {
+	// Namespace restricts the migration to a single namespace.  If empty, WorkloadEndpoints in
+	// all namespaces are migrated.
+	Namespace string
+
+	// DeleteLegacy makes Migrate delete each legacy WorkloadEndpoint once its replacement has
+	// been written.  Otherwise the legacy WorkloadEndpoints are left in place, for example so
+	// that they can be removed once the components that use them have been upgraded.
+	DeleteLegacy bool
+}
+
+// WorkloadEndpointMigrateAction is the action that Migrate took for a legacy WorkloadEndpoint.
+type WorkloadEndpointMigrateAction string
+
+const (
+	// WorkloadEndpointMigrateCreated means that the replacement WorkloadEndpoint was created
+	// under the current name.
+	WorkloadEndpointMigrateCreated WorkloadEndpointMigrateAction = "Created"
+	// WorkloadEndpointMigrateUpdated means that the WorkloadEndpoint already had the current
+	// name, so its Spec was converted in place.
+	WorkloadEndpointMigrateUpdated WorkloadEndpointMigrateAction = "Updated"
+	// WorkloadEndpointMigrateAlreadyMigrated means that the replacement WorkloadEndpoint had
+	// already been written, for example by an earlier Migrate that was interrupted.
+	WorkloadEndpointMigrateAlreadyMigrated WorkloadEndpointMigrateAction = "AlreadyMigrated"
+	WorkloadEndpointMigrateFailed          WorkloadEndpointMigrateAction = "Failed"
+)
+
+// WorkloadEndpointMigrateResult is the result of migrating a single legacy WorkloadEndpoint.
+type WorkloadEndpointMigrateResult struct {
+	Namespace string
+	// Name is the name of the legacy WorkloadEndpoint and NewName the name of its replacement,
+	// which is empty if it could not be calculated.
+	Name    string
+	NewName string
+	Action  WorkloadEndpointMigrateAction
+	// LegacyDeleted is true if the legacy WorkloadEndpoint was deleted.
+	LegacyDeleted bool
+	// Error is set if the Action is WorkloadEndpointMigrateFailed.
+	Error error
+}
+
+// Migrate converts the WorkloadEndpoints that are stored in a legacy form to the current form.
+// A WorkloadEndpoint is in a legacy form if its Spec uses the Workload field in place of the Pod
+// (for the k8s orchestrator) or ContainerID (for the cni orchestrator), or if its name doesn't
+// match the name calculated from its Spec, for example because it predates the escaping of
+// dashes.  The converted WorkloadEndpoint is created under the calculated name, or updated in
+// place if the name is unchanged, and if opts.DeleteLegacy is set the legacy WorkloadEndpoint is
+// then deleted.
+//
+// Migrate is idempotent and can be resumed after it is interrupted: a replacement that already
+// exists with the converted Spec is not written again.  A result is returned for each legacy
+// WorkloadEndpoint, in namespace/name order; a failure to migrate one WorkloadEndpoint does not
+// stop the migration of the rest.  An error is only returned if the WorkloadEndpoints can't be
+// listed.
+func (r workloadEndpoints) Migrate(ctx context.Context, opts WorkloadEndpointMigrateOptions) ([]WorkloadEndpointMigrateResult, error) {
+	list, err := r.List(ctx, options.ListOptions{
+		Namespace:  opts.Namespace,
+		Consistent: true,
+		Reserved:   options.ReservedExclude,
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(list.Items, func(i, j int) bool {
+		a, b := list.Items[i], list.Items[j]
+		if a.Namespace != b.Namespace {
+			return a.Namespace < b.Namespace
+		}
+		return a.Name < b.Name
+	})
+
+	var results []WorkloadEndpointMigrateResult
+	for i := range list.Items {
+		legacy := &list.Items[i]
+		migrated, err := migratedWorkloadEndpoint(legacy)
+		if err == nil && migrated.Name == legacy.Name && reflect.DeepEqual(migrated.Spec, legacy.Spec) {
+			// Already in the current form.
+			continue
+		}
+		result := WorkloadEndpointMigrateResult{
+			Namespace: legacy.Namespace,
+			Name:      legacy.Name,
+		}
+		if err == nil {
+			result.NewName = migrated.Name
+			err = r.migrateOne(ctx, legacy, migrated, opts, &result)
+		}
+		if err != nil {
+			log.WithError(err).WithFields(log.Fields{
+				"namespace": legacy.Namespace,
+				"name":      legacy.Name,
+			}).Warning("Failed to migrate WorkloadEndpoint")
+			result.Action = WorkloadEndpointMigrateFailed
+			result.Error = err
+		}
+		results = append(results, result)
+	}
+	log.WithField("numWorkloadEndpoints", len(results)).Info("Migrated legacy WorkloadEndpoints")
+	return results, nil
+}
+
+// migrateOne writes the migrated form of a legacy WorkloadEndpoint and, if required, deletes the
+// legacy WorkloadEndpoint.  It records the action it took in the result.
+func (r workloadEndpoints) migrateOne(
+	ctx context.Context, legacy, migrated *libapiv3.WorkloadEndpoint, opts WorkloadEndpointMigrateOptions, result *WorkloadEndpointMigrateResult,
+) error {
+	if migrated.Name == legacy.Name {
+		// Only the Spec needs converting, which we can do in place.
+		result.Action = WorkloadEndpointMigrateUpdated
+		migrated.ResourceVersion = legacy.ResourceVersion
+		migrated.UID = legacy.UID
+		migrated.CreationTimestamp = legacy.CreationTimestamp
+		_, err := r.Update(ctx, migrated, options.SetOptions{})
+		return err
+	}
+
+	result.Action = WorkloadEndpointMigrateCreated
+	_, err := r.Create(ctx, migrated, options.SetOptions{})
+	if _, ok := err.(errors.ErrorResourceAlreadyExists); ok {
+		existing, getErr := r.Get(ctx, migrated.Namespace, migrated.Name, options.GetOptions{Consistent: true})
+		if getErr != nil {
+			return getErr
+		}
+		if !reflect.DeepEqual(existing.Spec, migrated.Spec) {
+			return errors.ErrorResourceAlreadyExists{
+				Err: fmt.Errorf("a different WorkloadEndpoint already exists with the name %s", migrated.Name),
+				Identifier: model.ResourceKey{
+					Kind:      libapiv3.KindWorkloadEndpoint,
+					Namespace: migrated.Namespace,
+					Name:      migrated.Name,
+				},
+			}
+		}
+		result.Action = WorkloadEndpointMigrateAlreadyMigrated
+		err = nil
+	}
+	if err != nil || !opts.DeleteLegacy {
+		return err
+	}
+
+	// Only delete the legacy WorkloadEndpoint if it hasn't changed since we converted it.
+	_, err = r.Delete(ctx, legacy.Namespace, legacy.Name, options.DeleteOptions{ResourceVersion: legacy.ResourceVersion})
+	if _, ok := err.(errors.ErrorResourceDoesNotExist); ok {
+		err = nil
+	}
+	result.LegacyDeleted = err == nil
+	return err
+}
+
+// migratedWorkloadEndpoint returns the current form of the given WorkloadEndpoint, without a
+// ResourceVersion, UID or creation timestamp.  The name is calculated from the converted Spec.
+func migratedWorkloadEndpoint(legacy *libapiv3.WorkloadEndpoint) (*libapiv3.WorkloadEndpoint, error) {
+	meta := legacy.ObjectMeta.DeepCopy()
+	migrated := libapiv3.NewWorkloadEndpoint()
+	migrated.ObjectMeta = metav1.ObjectMeta{
+		Namespace:   legacy.Namespace,
+		Labels:      meta.Labels,
+		Annotations: meta.Annotations,
+	}
+	legacy.Spec.DeepCopyInto(&migrated.Spec)
+
+	// Older versions identified k8s and cni workloads by the Workload field; for k8s, in the form
+	// <namespace>.<pod>.
+	spec := &migrated.Spec
+	switch spec.Orchestrator {
+	case "k8s":
+		if spec.Pod == "" && spec.Workload != "" {
+			spec.Pod = strings.TrimPrefix(spec.Workload, legacy.Namespace+".")
+			spec.Workload = ""
+		}
+	case "cni":
+		if spec.ContainerID == "" && spec.Workload != "" {
+			spec.ContainerID = spec.Workload
+			spec.Workload = ""
+		}
+	}
+
+	wepids := names.WorkloadEndpointIdentifiers{
+		Node:         spec.Node,
+		Orchestrator: spec.Orchestrator,
+		Endpoint:     spec.Endpoint,
+		Workload:     spec.Workload,
+		Pod:          spec.Pod,
+		ContainerID:  spec.ContainerID,
+	}
+	name, err := wepids.CalculateWorkloadEndpointName(false)
+	if err != nil {
+		return nil, err
+	}
+	migrated.Name = name
+	return migrated, nil
+}
@@ -0,0 +1,122 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clientv3
+
+import (
+	"context"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	libapiv3 "github.com/projectcalico/calico/libcalico-go/lib/apis/v3"
+	"github.com/projectcalico/calico/libcalico-go/lib/options"
+	"github.com/projectcalico/calico/libcalico-go/lib/set"
+	"github.com/projectcalico/calico/libcalico-go/lib/watch"
+)
+
+// narrowNamesOptions returns the options for the narrowest underlying watch that covers all of
+// opts.Names.  If there is only one name, and a namespace is given, that is a watch of the exact
+// name; otherwise it is a watch of the namespace (or all namespaces), and the events must be
+// filtered.
+func narrowNamesOptions(opts options.ListOptions) options.ListOptions {
+	narrowed := opts
+	narrowed.Names = nil
+	if len(opts.Names) == 1 && opts.Namespace != "" {
+		narrowed.Name = opts.Names[0]
+	}
+	return narrowed
+}
+
+// watchNames watches just the WorkloadEndpoints named in opts.Names.  The initial snapshot, if
+// any, includes only the named WorkloadEndpoints that exist.
+//
+// The events are filtered by name before they are trimmed by the converter, if any, since the
+// projection need not include the name.
+func (r workloadEndpoints) watchNames(ctx context.Context, opts options.ListOptions, converter watcherConverter) (watch.Interface, error) {
+	narrowed := narrowNamesOptions(opts)
+	log.WithFields(log.Fields{
+		"namespace": opts.Namespace,
+		"names":     opts.Names,
+		"watchName": narrowed.Name,
+	}).Debug("Watching named WorkloadEndpoints")
+	inner, err := r.client.resources.watch(ctx, narrowed, libapiv3.KindWorkloadEndpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	// The inner watcher does the buffering, so that the buffer size and overflow policy in the
+	// options apply.
+	nw := &namesWatcher{
+		inner:     inner,
+		names:     set.FromArray(opts.Names),
+		converter: converter,
+		results:   make(chan watch.Event),
+		done:      make(chan struct{}),
+	}
+	go nw.run()
+	return nw, nil
+}
+
+// namesWatcher implements watch.Interface, passing on only the events for WorkloadEndpoints
+// with one of the given names.  Error and Bookmark events are always passed on.
+type namesWatcher struct {
+	inner     watch.Interface
+	names     set.Set[string]
+	converter watcherConverter
+	results   chan watch.Event
+	done      chan struct{}
+	stopOnce  sync.Once
+}
+
+func (nw *namesWatcher) Stop() {
+	nw.stopOnce.Do(func() {
+		close(nw.done)
+		nw.inner.Stop()
+	})
+}
+
+func (nw *namesWatcher) ResultChan() <-chan watch.Event {
+	return nw.results
+}
+
+func (nw *namesWatcher) run() {
+	defer close(nw.results)
+	for e := range nw.inner.ResultChan() {
+		if e.Type != watch.Error && e.Type != watch.Bookmark && !nw.matches(e.Object) && !nw.matches(e.Previous) {
+			continue
+		}
+		if nw.converter != nil {
+			e.Object = nw.convert(e.Object)
+			e.Previous = nw.convert(e.Previous)
+		}
+		select {
+		case nw.results <- e:
+		case <-nw.done:
+			return
+		}
+	}
+}
+
+func (nw *namesWatcher) convert(obj runtime.Object) runtime.Object {
+	if obj == nil {
+		return nil
+	}
+	return nw.converter.Convert(obj.(resource))
+}
+
+func (nw *namesWatcher) matches(obj runtime.Object) bool {
+	wep, ok := obj.(*libapiv3.WorkloadEndpoint)
+	return ok && nw.names.Contains(wep.Name)
+}
@@ -0,0 +1,279 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clientv3
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	libapiv3 "github.com/projectcalico/calico/libcalico-go/lib/apis/v3"
+	"github.com/projectcalico/calico/libcalico-go/lib/options"
+	"github.com/projectcalico/calico/libcalico-go/lib/watch"
+)
+
+// WatchNamespaceOrdered returns a watch.Interface that watches the WorkloadEndpoints that match the
+// supplied options in each of the given namespaces (or in opts.Namespace if namespaces is empty),
+// fanning in one underlying watch per namespace.  The events for each namespace are delivered in
+// strictly increasing resource version order; there is no ordering guarantee between
+// namespaces.
+//
+// To achieve this, each event is held in a per-namespace buffer for reorderWindow before it is
+// delivered, so that any event with a lower resource version that arrives in the meantime can be
+// delivered first.  This adds up to reorderWindow of latency to every event.  An event that
+// arrives more than reorderWindow late, after an event with a higher resource version in the same
+// namespace has been delivered, can't be delivered in order, so it is dropped with a warning, as
+// is an exact duplicate.
+//
+// The datastore doesn't report the revision of a delete (a Deleted event carries the last state
+// of the WorkloadEndpoint, with its resource version), so Deleted events aren't buffered.
+// Instead, a Deleted event is delivered as soon as it arrives, after any events that are buffered
+// for its namespace; since each namespace has a single underlying watch, those events must have
+// happened before the delete.
+//
+// Error events are delivered immediately.  Bookmark events are not delivered, since each
+// underlying watch reaches a resource version independently of the others.  The watcher
+// terminates when all of the underlying watches have terminated.
+func (r workloadEndpoints) WatchNamespaceOrdered(
+	ctx context.Context, namespaces []string, opts options.ListOptions, reorderWindow time.Duration,
+) (watch.Interface, error) {
+	if err := rejectWatchProjection(opts, "WatchNamespaceOrdered"); err != nil {
+		return nil, err
+	}
+	if len(namespaces) == 0 {
+		namespaces = []string{opts.Namespace}
+	}
+	var inners []watch.Interface
+	for _, ns := range namespaces {
+		nsOpts := opts
+		nsOpts.Namespace = ns
+		inner, err := r.Watch(ctx, nsOpts)
+		if err != nil {
+			for _, w := range inners {
+				w.Stop()
+			}
+			return nil, err
+		}
+		inners = append(inners, inner)
+	}
+	return newNamespaceOrderedWatcher(inners, reorderWindow), nil
+}
+
+// orderedEvent is an event waiting in a namespace's reorder buffer.
+type orderedEvent struct {
+	event    watch.Event
+	rev      uint64
+	received time.Time
+}
+
+// namespaceOrderedWatcher implements watch.Interface, fanning in the underlying watchers and
+// reordering the events within each namespace.
+type namespaceOrderedWatcher struct {
+	inners  []watch.Interface
+	window  time.Duration
+	merged  chan watch.Event
+	results chan watch.Event
+	done    chan struct{}
+
+	stopOnce sync.Once
+
+	// pending holds the buffered events for each namespace, sorted by resource version, and
+	// lastRev the resource version of the last event delivered for each namespace.  Both are
+	// only accessed from the run() goroutine.
+	pending map[string][]orderedEvent
+	lastRev map[string]uint64
+}
+
+func newNamespaceOrderedWatcher(inners []watch.Interface, window time.Duration) *namespaceOrderedWatcher {
+	ow := &namespaceOrderedWatcher{
+		inners:  inners,
+		window:  window,
+		merged:  make(chan watch.Event, 100),
+		results: make(chan watch.Event, 100),
+		done:    make(chan struct{}),
+		pending: map[string][]orderedEvent{},
+		lastRev: map[string]uint64{},
+	}
+	var wg sync.WaitGroup
+	for _, inner := range inners {
+		wg.Add(1)
+		go ow.fanIn(inner, &wg)
+	}
+	go func() {
+		wg.Wait()
+		close(ow.merged)
+	}()
+	go ow.run()
+	return ow
+}
+
+func (ow *namespaceOrderedWatcher) Stop() {
+	ow.stopOnce.Do(func() {
+		close(ow.done)
+		for _, inner := range ow.inners {
+			inner.Stop()
+		}
+	})
+}
+
+func (ow *namespaceOrderedWatcher) ResultChan() <-chan watch.Event {
+	return ow.results
+}
+
+func (ow *namespaceOrderedWatcher) fanIn(inner watch.Interface, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for e := range inner.ResultChan() {
+		select {
+		case ow.merged <- e:
+		case <-ow.done:
+			return
+		}
+	}
+}
+
+func (ow *namespaceOrderedWatcher) run() {
+	defer close(ow.results)
+
+	// Check for due events a few times per window, so that events aren't held for much longer
+	// than the window.
+	tickInterval := ow.window / 4
+	if tickInterval < time.Millisecond {
+		tickInterval = time.Millisecond
+	}
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case e, ok := <-ow.merged:
+			if !ok {
+				// All the underlying watches have finished, deliver everything that's left.
+				ow.flush(time.Time{})
+				return
+			}
+			if !ow.add(e) {
+				return
+			}
+		case <-ticker.C:
+		case <-ow.done:
+			return
+		}
+		if !ow.flush(time.Now()) {
+			return
+		}
+	}
+}
+
+// add buffers the event, or delivers it immediately if it can't be ordered.  Returns false if the
+// watcher was stopped.
+func (ow *namespaceOrderedWatcher) add(e watch.Event) bool {
+	if e.Type == watch.Bookmark {
+		return true
+	}
+	wep, rev, ok := orderingKey(e)
+	if !ok {
+		return ow.send(e)
+	}
+	ns := wep.Namespace
+	if e.Type == watch.Deleted {
+		return ow.flushNamespace(ns, time.Time{}) && ow.send(e)
+	}
+	if last, ok := ow.lastRev[ns]; ok && rev <= last {
+		log.WithFields(log.Fields{
+			"namespace":       ns,
+			"name":            wep.Name,
+			"resourceVersion": rev,
+			"lastDelivered":   last,
+		}).Warn("WorkloadEndpoint event arrived after a later event was delivered, dropping it")
+		return true
+	}
+	buf := ow.pending[ns]
+	i := sort.Search(len(buf), func(i int) bool { return buf[i].rev >= rev })
+	if i < len(buf) && buf[i].rev == rev {
+		log.WithFields(log.Fields{"namespace": ns, "resourceVersion": rev}).Debug("Dropping duplicate event")
+		return true
+	}
+	buf = append(buf, orderedEvent{})
+	copy(buf[i+1:], buf[i:])
+	buf[i] = orderedEvent{event: e, rev: rev, received: time.Now()}
+	ow.pending[ns] = buf
+	return true
+}
+
+// flush delivers, for each namespace, the buffered events that have been held for the reorder
+// window, in resource version order.  An event that isn't yet due holds back any later events in
+// the same namespace.  If now is zero, all buffered events are delivered.  Returns false if the
+// watcher was stopped.
+func (ow *namespaceOrderedWatcher) flush(now time.Time) bool {
+	for ns := range ow.pending {
+		if !ow.flushNamespace(ns, now) {
+			return false
+		}
+	}
+	return true
+}
+
+// flushNamespace is as flush, for a single namespace.
+func (ow *namespaceOrderedWatcher) flushNamespace(ns string, now time.Time) bool {
+	buf := ow.pending[ns]
+	n := 0
+	for ; n < len(buf); n++ {
+		if !now.IsZero() && now.Sub(buf[n].received) < ow.window {
+			break
+		}
+		if !ow.send(buf[n].event) {
+			return false
+		}
+		ow.lastRev[ns] = buf[n].rev
+	}
+	if n == len(buf) {
+		delete(ow.pending, ns)
+	} else {
+		ow.pending[ns] = buf[n:]
+	}
+	return true
+}
+
+// send delivers the event to the consumer.  Returns false if the watcher was stopped first.
+func (ow *namespaceOrderedWatcher) send(e watch.Event) bool {
+	select {
+	case ow.results <- e:
+		return true
+	case <-ow.done:
+		return false
+	}
+}
+
+// orderingKey returns the WorkloadEndpoint and resource version that the event is ordered by.
+// Returns false if the event can't be ordered, for example because it is an error.
+func orderingKey(e watch.Event) (*libapiv3.WorkloadEndpoint, uint64, bool) {
+	obj := e.Object
+	if e.Type == watch.Deleted {
+		obj = e.Previous
+	}
+	wep, ok := obj.(*libapiv3.WorkloadEndpoint)
+	if !ok || wep == nil {
+		return nil, 0, false
+	}
+	rev, err := strconv.ParseUint(wep.ResourceVersion, 10, 64)
+	if err != nil {
+		return nil, 0, false
+	}
+	return wep, rev, true
+}
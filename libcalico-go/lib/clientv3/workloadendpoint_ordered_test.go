@@ -0,0 +1,168 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clientv3
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	libapiv3 "github.com/projectcalico/calico/libcalico-go/lib/apis/v3"
+	"github.com/projectcalico/calico/libcalico-go/lib/watch"
+)
+
+// chanWatcher is a watch.Interface whose events are fed in by the test.
+type chanWatcher struct {
+	c        chan watch.Event
+	stopOnce sync.Once
+}
+
+func newChanWatcher() *chanWatcher {
+	return &chanWatcher{c: make(chan watch.Event, 100)}
+}
+
+func (w *chanWatcher) Stop() {
+	w.stopOnce.Do(func() { close(w.c) })
+}
+
+func (w *chanWatcher) ResultChan() <-chan watch.Event {
+	return w.c
+}
+
+var _ = Describe("Namespace-ordered WorkloadEndpoint watcher", func() {
+	const window = 50 * time.Millisecond
+
+	var (
+		inner1, inner2 *chanWatcher
+		ow             *namespaceOrderedWatcher
+	)
+
+	event := func(t watch.EventType, ns string, rev int) watch.Event {
+		wep := &libapiv3.WorkloadEndpoint{ObjectMeta: metav1.ObjectMeta{
+			Namespace:       ns,
+			Name:            "wep-" + strconv.Itoa(rev),
+			ResourceVersion: strconv.Itoa(rev),
+		}}
+		if t == watch.Deleted {
+			return watch.Event{Type: t, Previous: wep}
+		}
+		return watch.Event{Type: t, Object: wep}
+	}
+
+	// received drains the watcher until it terminates and returns the resource versions of the
+	// events for each namespace, in delivery order.
+	received := func() map[string][]string {
+		revs := map[string][]string{}
+		for e := range ow.ResultChan() {
+			wep, _, ok := orderingKey(e)
+			Expect(ok).To(BeTrue())
+			revs[wep.Namespace] = append(revs[wep.Namespace], wep.ResourceVersion)
+		}
+		return revs
+	}
+
+	BeforeEach(func() {
+		inner1 = newChanWatcher()
+		inner2 = newChanWatcher()
+		ow = newNamespaceOrderedWatcher([]watch.Interface{inner1, inner2}, window)
+	})
+
+	AfterEach(func() {
+		ow.Stop()
+	})
+
+	It("should reorder events within the window", func() {
+		inner1.c <- event(watch.Added, "ns1", 3)
+		inner2.c <- event(watch.Added, "ns2", 4)
+		inner1.c <- event(watch.Added, "ns1", 1)
+		inner2.c <- event(watch.Modified, "ns2", 2)
+		inner1.c <- event(watch.Modified, "ns1", 2)
+		inner1.Stop()
+		inner2.Stop()
+
+		Expect(received()).To(Equal(map[string][]string{
+			"ns1": {"1", "2", "3"},
+			"ns2": {"2", "4"},
+		}))
+	})
+
+	It("should deliver a Deleted event after the buffered events for its namespace", func() {
+		inner1.c <- event(watch.Added, "ns1", 3)
+		inner1.c <- event(watch.Added, "ns1", 1)
+		inner2.c <- event(watch.Added, "ns2", 2)
+		// The deleted WorkloadEndpoint was last written at revision 3.
+		inner1.c <- event(watch.Deleted, "ns1", 3)
+
+		var e watch.Event
+		for _, expected := range []watch.EventType{watch.Added, watch.Added, watch.Deleted} {
+			Eventually(ow.ResultChan(), window/2, time.Millisecond).Should(Receive(&e))
+			Expect(e.Type).To(Equal(expected))
+		}
+		// The other namespace's event is still held back.
+		Expect(ow.ResultChan()).NotTo(Receive())
+		Eventually(ow.ResultChan()).Should(Receive(&e))
+		Expect(e.Object.(*libapiv3.WorkloadEndpoint).Namespace).To(Equal("ns2"))
+	})
+
+	It("should hold events for the window before delivering them", func() {
+		start := time.Now()
+		inner1.c <- event(watch.Added, "ns1", 1)
+		var e watch.Event
+		Eventually(ow.ResultChan()).Should(Receive(&e))
+		Expect(time.Since(start)).To(BeNumerically(">=", window))
+		Expect(e.Object.(*libapiv3.WorkloadEndpoint).ResourceVersion).To(Equal("1"))
+	})
+
+	It("should drop events that arrive after a later event was delivered", func() {
+		inner1.c <- event(watch.Added, "ns1", 5)
+		Eventually(ow.ResultChan()).Should(Receive())
+
+		inner1.c <- event(watch.Added, "ns1", 4)
+		inner1.c <- event(watch.Modified, "ns1", 5)
+		inner1.c <- event(watch.Modified, "ns1", 6)
+		// A lower revision in another namespace is unaffected.
+		inner2.c <- event(watch.Added, "ns2", 1)
+		inner1.Stop()
+		inner2.Stop()
+
+		Expect(received()).To(Equal(map[string][]string{
+			"ns1": {"6"},
+			"ns2": {"1"},
+		}))
+	})
+
+	It("should deliver error events immediately", func() {
+		inner1.c <- event(watch.Added, "ns1", 1)
+		inner2.c <- watch.Event{Type: watch.Error, Error: errors.New("watch failed")}
+
+		var e watch.Event
+		Eventually(ow.ResultChan(), window/2, time.Millisecond).Should(Receive(&e))
+		Expect(e.Type).To(Equal(watch.Error))
+		Eventually(ow.ResultChan()).Should(Receive(&e))
+		Expect(e.Type).To(Equal(watch.Added))
+	})
+
+	It("should stop the underlying watchers when stopped", func() {
+		ow.Stop()
+		Eventually(ow.ResultChan()).Should(BeClosed())
+		Expect(inner1.c).To(BeClosed())
+		Expect(inner2.c).To(BeClosed())
+	})
+})
@@ -0,0 +1,62 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clientv3
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/projectcalico/calico/libcalico-go/lib/errors"
+)
+
+// listContinueToken is the decoded form of the Continue token of a paged WorkloadEndpoint List.
+// It holds the backend's token for the next page, which the backend lists in its own stable
+// order, and the resource version of the first page, which every page is listed at so that, where
+// the datastore supports it, the pages are a consistent snapshot.
+type listContinueToken struct {
+	ResourceVersion string `json:"rv"`
+	Continue        string `json:"continue"`
+}
+
+func (t listContinueToken) encode() string {
+	data, err := json.Marshal(t)
+	if err != nil {
+		// Can't happen; the token only contains strings.
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeListContinueToken(s string) (listContinueToken, error) {
+	var t listContinueToken
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err == nil {
+		err = json.Unmarshal(data, &t)
+	}
+	if err == nil && t.Continue == "" {
+		err = fmt.Errorf("missing continue")
+	}
+	if err != nil {
+		return listContinueToken{}, errors.ErrorValidation{
+			ErroredFields: []errors.ErroredField{{
+				Name:   "Continue",
+				Value:  s,
+				Reason: fmt.Sprintf("invalid continue token: %v", err),
+			}},
+		}
+	}
+	return t, nil
+}
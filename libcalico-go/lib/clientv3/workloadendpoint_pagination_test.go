@@ -0,0 +1,42 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clientv3
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	"github.com/projectcalico/calico/libcalico-go/lib/errors"
+)
+
+var _ = Describe("WorkloadEndpoint List continue tokens", func() {
+	It("should decode an encoded token", func() {
+		token := listContinueToken{ResourceVersion: "1234", Continue: "/calico/resources/v3/projectcalico.org/workloadendpoints/ns-b/node-k8s-pod05-eth0\x00"}
+		decoded, err := decodeListContinueToken(token.encode())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(decoded).To(Equal(token))
+	})
+
+	DescribeTable("should reject invalid continue tokens",
+		func(token string) {
+			_, err := decodeListContinueToken(token)
+			Expect(err).To(BeAssignableToTypeOf(errors.ErrorValidation{}))
+		},
+		Entry("not base64", "!!!"),
+		Entry("not JSON", "bm90IGpzb24"),
+		Entry("no backend token", listContinueToken{ResourceVersion: "1"}.encode()),
+	)
+})
@@ -0,0 +1,118 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clientv3
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+
+	libapiv3 "github.com/projectcalico/calico/libcalico-go/lib/apis/v3"
+	"github.com/projectcalico/calico/libcalico-go/lib/backend/model"
+	"github.com/projectcalico/calico/libcalico-go/lib/errors"
+	"github.com/projectcalico/calico/libcalico-go/lib/options"
+)
+
+// patchRetries is the number of times that Patch re-applies the patch after a conflict with a
+// concurrent write.
+const patchRetries = 5
+
+// Patch applies a JSON merge patch (types.MergePatchType) or a strategic merge patch
+// (types.StrategicMergePatchType) to the stored WorkloadEndpoint, and returns the patched
+// WorkloadEndpoint with its new resource version.  Only the fields in the patch are changed.
+//
+// The patched WorkloadEndpoint is written with an update that is conditional on the revision the
+// patch was applied to; if that revision is modified concurrently, the patch is re-applied to
+// the new revision.  If opts.ResourceVersion is set, the stored revision must match it, and an
+// errors.ErrorResourceUpdateConflict is returned if it doesn't.
+func (r workloadEndpoints) Patch(
+	ctx context.Context, namespace, name string, pt types.PatchType, data []byte, opts options.PatchOptions,
+) (*libapiv3.WorkloadEndpoint, error) {
+	key := model.ResourceKey{Kind: libapiv3.KindWorkloadEndpoint, Namespace: namespace, Name: name}
+	if pt != types.MergePatchType && pt != types.StrategicMergePatchType {
+		return nil, errors.ErrorOperationNotSupported{
+			Operation:  "Patch",
+			Identifier: key,
+			Reason:     fmt.Sprintf("unsupported patch type %q", pt),
+		}
+	}
+	logCxt := log.WithFields(log.Fields{"namespace": namespace, "name": name, "patchType": pt})
+	for attempt := 0; ; attempt++ {
+		wep, err := r.Get(ctx, namespace, name, options.GetOptions{Consistent: true})
+		if err != nil {
+			return nil, err
+		}
+		if opts.ResourceVersion != "" && opts.ResourceVersion != wep.ResourceVersion {
+			return nil, errors.ErrorResourceUpdateConflict{Identifier: key}
+		}
+		patched, err := applyWorkloadEndpointPatch(wep, pt, data)
+		if err != nil {
+			return nil, err
+		}
+		out, err := r.Update(ctx, patched, options.SetOptions{WarningHandler: opts.WarningHandler})
+		if _, ok := err.(errors.ErrorResourceUpdateConflict); ok && opts.ResourceVersion == "" && attempt < patchRetries {
+			// Someone else updated the WorkloadEndpoint; re-apply the patch to the new revision.
+			logCxt.WithError(err).Info("Conflict while patching WorkloadEndpoint, retrying")
+			continue
+		}
+		return out, err
+	}
+}
+
+// applyWorkloadEndpointPatch returns a copy of the WorkloadEndpoint with the patch applied.  The
+// patch may not change the identity of the WorkloadEndpoint, and the copy keeps the resource
+// version of the original, so that writing it is conditional on that revision.
+func applyWorkloadEndpointPatch(wep *libapiv3.WorkloadEndpoint, pt types.PatchType, data []byte) (*libapiv3.WorkloadEndpoint, error) {
+	original, err := json.Marshal(wep)
+	if err != nil {
+		return nil, err
+	}
+	var patchedJSON []byte
+	switch pt {
+	case types.MergePatchType:
+		patchedJSON, err = jsonpatch.MergePatch(original, data)
+	case types.StrategicMergePatchType:
+		patchedJSON, err = strategicpatch.StrategicMergePatch(original, data, libapiv3.WorkloadEndpoint{})
+	default:
+		err = fmt.Errorf("unsupported patch type %q", pt)
+	}
+	if err != nil {
+		return nil, patchValidationError(data, fmt.Sprintf("invalid patch: %v", err))
+	}
+	patched := &libapiv3.WorkloadEndpoint{}
+	if err := json.Unmarshal(patchedJSON, patched); err != nil {
+		return nil, patchValidationError(data, fmt.Sprintf("patched WorkloadEndpoint is invalid: %v", err))
+	}
+	if patched.Name != wep.Name || patched.Namespace != wep.Namespace {
+		return nil, patchValidationError(data, "patch must not change the name or namespace")
+	}
+	patched.ResourceVersion = wep.ResourceVersion
+	return patched, nil
+}
+
+func patchValidationError(data []byte, reason string) error {
+	return errors.ErrorValidation{
+		ErroredFields: []errors.ErroredField{{
+			Name:   "Patch",
+			Value:  string(data),
+			Reason: reason,
+		}},
+	}
+}
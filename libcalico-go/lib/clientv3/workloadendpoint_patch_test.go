@@ -0,0 +1,98 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clientv3
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	libapiv3 "github.com/projectcalico/calico/libcalico-go/lib/apis/v3"
+	"github.com/projectcalico/calico/libcalico-go/lib/errors"
+)
+
+var _ = Describe("WorkloadEndpoint patching", func() {
+	var wep *libapiv3.WorkloadEndpoint
+
+	BeforeEach(func() {
+		wep = libapiv3.NewWorkloadEndpoint()
+		wep.ObjectMeta = metav1.ObjectMeta{
+			Namespace:       "ns",
+			Name:            "node-k8s-pod-eth0",
+			ResourceVersion: "1234",
+			Labels:          map[string]string{"app": "web", "tier": "frontend"},
+		}
+		wep.Spec = libapiv3.WorkloadEndpointSpec{
+			Node:          "node",
+			Orchestrator:  "k8s",
+			Pod:           "pod",
+			Endpoint:      "eth0",
+			InterfaceName: "cali1234",
+			IPNetworks:    []string{"10.0.0.1/32"},
+			Profiles:      []string{"kns.ns"},
+		}
+	})
+
+	DescribeTable("should change only the patched fields",
+		func(pt types.PatchType) {
+			patched, err := applyWorkloadEndpointPatch(wep, pt, []byte(`{"spec":{"interfaceName":"cali5678"}}`))
+			Expect(err).NotTo(HaveOccurred())
+
+			expected := wep.DeepCopy()
+			expected.Spec.InterfaceName = "cali5678"
+			Expect(patched).To(Equal(expected))
+			Expect(wep.Spec.InterfaceName).To(Equal("cali1234"), "Original WorkloadEndpoint was modified")
+		},
+		Entry("JSON merge patch", types.MergePatchType),
+		Entry("strategic merge patch", types.StrategicMergePatchType),
+	)
+
+	DescribeTable("should merge and remove labels",
+		func(pt types.PatchType) {
+			patched, err := applyWorkloadEndpointPatch(wep, pt, []byte(`{"metadata":{"labels":{"tier":null,"canary":"true"}}}`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(patched.Labels).To(Equal(map[string]string{"app": "web", "canary": "true"}))
+			Expect(patched.Spec).To(Equal(wep.Spec))
+		},
+		Entry("JSON merge patch", types.MergePatchType),
+		Entry("strategic merge patch", types.StrategicMergePatchType),
+	)
+
+	It("should replace lists", func() {
+		patched, err := applyWorkloadEndpointPatch(wep, types.MergePatchType, []byte(`{"spec":{"ipNetworks":["10.0.0.2/32"]}}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(patched.Spec.IPNetworks).To(Equal([]string{"10.0.0.2/32"}))
+	})
+
+	It("should keep the resource version that the patch was applied to", func() {
+		patched, err := applyWorkloadEndpointPatch(wep, types.MergePatchType, []byte(`{"metadata":{"resourceVersion":"1"}}`))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(patched.ResourceVersion).To(Equal("1234"))
+	})
+
+	DescribeTable("should reject invalid patches",
+		func(pt types.PatchType, data string) {
+			_, err := applyWorkloadEndpointPatch(wep, pt, []byte(data))
+			Expect(err).To(BeAssignableToTypeOf(errors.ErrorValidation{}))
+		},
+		Entry("malformed JSON merge patch", types.MergePatchType, `{"spec":`),
+		Entry("malformed strategic merge patch", types.StrategicMergePatchType, `{"spec":`),
+		Entry("wrong field type", types.MergePatchType, `{"spec":{"ipNetworks":"10.0.0.2/32"}}`),
+		Entry("name change", types.MergePatchType, `{"metadata":{"name":"other"}}`),
+		Entry("namespace change", types.StrategicMergePatchType, `{"metadata":{"namespace":"other"}}`),
+	)
+})
@@ -0,0 +1,84 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clientv3
+
+import (
+	"context"
+
+	"golang.org/x/time/rate"
+
+	"github.com/projectcalico/calico/libcalico-go/lib/errors"
+)
+
+// RateLimit configures a client-side token bucket rate limiter.
+type RateLimit struct {
+	// QPS is the sustained rate of requests per second.  Zero disables the limit.
+	QPS float64
+	// Burst is the maximum number of requests that may be made at once, above the sustained
+	// rate.  It is treated as 1 if it is less than 1.
+	Burst int
+	// Block, if set, makes a request that exceeds the limit wait until it is allowed (or its
+	// context is done).  Otherwise, the request fails with an errors.ErrorRateLimited.
+	Block bool
+}
+
+// WithWorkloadEndpointRateLimits throttles the requests that the client's WorkloadEndpoint
+// interface makes to the datastore, to protect the datastore from a misbehaving caller.  reads
+// limits Get and List; writes limits Create, Update and Delete, and each transaction made by
+// DeleteCollection.  Operations that are built on these (such as AddIP) are limited by the
+// requests that they make.  Watches are not limited.
+func WithWorkloadEndpointRateLimits(reads, writes RateLimit) Option {
+	return func(c *client) {
+		c.wepReadLimiter = newRateLimiter("read", reads)
+		c.wepWriteLimiter = newRateLimiter("write", writes)
+	}
+}
+
+// rateLimiter applies a RateLimit.  A nil rateLimiter allows all requests.
+type rateLimiter struct {
+	operation string
+	config    RateLimit
+	limiter   *rate.Limiter
+}
+
+func newRateLimiter(operation string, config RateLimit) *rateLimiter {
+	if config.QPS <= 0 {
+		return nil
+	}
+	if config.Burst < 1 {
+		config.Burst = 1
+	}
+	return &rateLimiter{
+		operation: operation,
+		config:    config,
+		limiter:   rate.NewLimiter(rate.Limit(config.QPS), config.Burst),
+	}
+}
+
+// wait returns nil if a request may proceed.  If the limit is exceeded, it either waits until the
+// request may proceed, returning an error if the context is done first, or returns an
+// errors.ErrorRateLimited, depending on the configuration.
+func (l *rateLimiter) wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	if l.config.Block {
+		return l.limiter.Wait(ctx)
+	}
+	if !l.limiter.Allow() {
+		return errors.ErrorRateLimited{Operation: "WorkloadEndpoint " + l.operation, QPS: l.config.QPS}
+	}
+	return nil
+}
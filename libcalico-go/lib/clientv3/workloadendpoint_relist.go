@@ -0,0 +1,193 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clientv3
+
+import (
+	"context"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+
+	libapiv3 "github.com/projectcalico/calico/libcalico-go/lib/apis/v3"
+	"github.com/projectcalico/calico/libcalico-go/lib/options"
+	"github.com/projectcalico/calico/libcalico-go/lib/watch"
+)
+
+// WatchWithRelist returns a watch.Interface that watches the WorkloadEndpoints that match the
+// supplied options and survives gaps in the underlying watch.  It starts by listing the
+// WorkloadEndpoints and sending an Added event for each one.  If the underlying watch fails
+// (for example, because its revision has been compacted), the WorkloadEndpoints are relisted and
+// the new list is compared with the last-known state: an Added or Modified event is sent for each
+// WorkloadEndpoint that is new or has changed, and a synthetic Deleted event is sent for each
+// WorkloadEndpoint that has disappeared.  The consumer therefore reaches a consistent state
+// after any gap without having to relist itself.
+//
+// Errors from the underlying watch are not passed to the consumer; the watcher only terminates
+// (after sending an Error event) if a relist fails, or if it is stopped.
+func (r workloadEndpoints) WatchWithRelist(ctx context.Context, opts options.ListOptions) (watch.Interface, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	rw := &relistWatcher{
+		ctx:     ctx,
+		cancel:  cancel,
+		wepIf:   r,
+		opts:    opts,
+		results: make(chan watch.Event, 100),
+		known:   map[string]*libapiv3.WorkloadEndpoint{},
+	}
+	go rw.run()
+	return rw, nil
+}
+
+// relistWatcher implements watch.Interface, tracking the WorkloadEndpoints that it has reported
+// so that it can synthesize events when it relists.
+type relistWatcher struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wepIf   workloadEndpoints
+	opts    options.ListOptions
+	results chan watch.Event
+
+	// inner is the current underlying watcher.  inner and known are only accessed from the
+	// run() goroutine.
+	inner watch.Interface
+	// known contains the last-reported state of each WorkloadEndpoint, keyed by
+	// namespace/name.
+	known map[string]*libapiv3.WorkloadEndpoint
+}
+
+func (rw *relistWatcher) Stop() {
+	rw.cancel()
+}
+
+func (rw *relistWatcher) ResultChan() <-chan watch.Event {
+	return rw.results
+}
+
+func (rw *relistWatcher) run() {
+	defer close(rw.results)
+	defer rw.cancel()
+
+	for rw.relist() && rw.forward() {
+		log.Info("WorkloadEndpoint watch interrupted, relisting")
+	}
+}
+
+// forward passes events from the underlying watcher to the consumer, tracking the state of each
+// WorkloadEndpoint.  Returns true if the underlying watch failed and we should relist, or false
+// if the watcher should terminate.
+func (rw *relistWatcher) forward() bool {
+	for {
+		select {
+		case e, ok := <-rw.inner.ResultChan():
+			if !ok {
+				return rw.ctx.Err() == nil
+			}
+			if e.Type == watch.Error {
+				log.WithError(e.Error).Info("Error from underlying WorkloadEndpoint watch")
+				rw.inner.Stop()
+				return true
+			}
+			rw.track(e)
+			if !rw.send(e) {
+				return false
+			}
+		case <-rw.ctx.Done():
+			return false
+		}
+	}
+}
+
+// track updates the last-known state according to the event.
+func (rw *relistWatcher) track(e watch.Event) {
+	switch e.Type {
+	case watch.Added, watch.Modified:
+		if wep, ok := e.Object.(*libapiv3.WorkloadEndpoint); ok {
+			rw.known[relistKey(wep)] = wep
+		}
+	case watch.Deleted:
+		if wep, ok := e.Previous.(*libapiv3.WorkloadEndpoint); ok {
+			delete(rw.known, relistKey(wep))
+		}
+	}
+}
+
+// relist lists the current WorkloadEndpoints, sends the events needed to bring the consumer from
+// the last-known state to the listed state and then starts watching from the list revision.
+// Returns false if the watcher should terminate.
+func (rw *relistWatcher) relist() bool {
+	opts := rw.opts
+	opts.ResourceVersion = ""
+	list, err := rw.wepIf.List(rw.ctx, opts)
+	if err != nil {
+		rw.send(watch.Event{Type: watch.Error, Error: err})
+		return false
+	}
+
+	listed := map[string]*libapiv3.WorkloadEndpoint{}
+	for i := range list.Items {
+		wep := &list.Items[i]
+		key := relistKey(wep)
+		listed[key] = wep
+		old, ok := rw.known[key]
+		var e watch.Event
+		switch {
+		case !ok:
+			e = watch.Event{Type: watch.Added, Object: wep}
+		case old.ResourceVersion != wep.ResourceVersion:
+			e = watch.Event{Type: watch.Modified, Previous: old, Object: wep}
+		default:
+			continue
+		}
+		if !rw.send(e) {
+			return false
+		}
+	}
+
+	var deleted []string
+	for key := range rw.known {
+		if _, ok := listed[key]; !ok {
+			deleted = append(deleted, key)
+		}
+	}
+	sort.Strings(deleted)
+	for _, key := range deleted {
+		log.WithField("key", key).Debug("WorkloadEndpoint deleted during watch gap")
+		if !rw.send(watch.Event{Type: watch.Deleted, Previous: rw.known[key]}) {
+			return false
+		}
+	}
+	rw.known = listed
+
+	opts.ResourceVersion = list.ResourceVersion
+	if rw.inner, err = rw.wepIf.Watch(rw.ctx, opts); err != nil {
+		rw.send(watch.Event{Type: watch.Error, Error: err})
+		return false
+	}
+	return true
+}
+
+// send delivers the event to the consumer.  Returns false if the watcher was stopped first.
+func (rw *relistWatcher) send(e watch.Event) bool {
+	select {
+	case rw.results <- e:
+		return true
+	case <-rw.ctx.Done():
+		return false
+	}
+}
+
+func relistKey(wep *libapiv3.WorkloadEndpoint) string {
+	return wep.Namespace + "/" + wep.Name
+}
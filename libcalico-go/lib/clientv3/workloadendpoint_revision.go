@@ -0,0 +1,46 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clientv3
+
+import (
+	"context"
+
+	libapiv3 "github.com/projectcalico/calico/libcalico-go/lib/apis/v3"
+	bapi "github.com/projectcalico/calico/libcalico-go/lib/backend/api"
+	"github.com/projectcalico/calico/libcalico-go/lib/backend/model"
+)
+
+// LatestResourceVersion returns the current resource version of the WorkloadEndpoints: the
+// resource version that a List would return if it were made now.  A Watch from it reports only
+// changes made after this call.  If the datastore supports it, the resource version is read
+// without reading any WorkloadEndpoints; otherwise, they are listed.
+func (r workloadEndpoints) LatestResourceVersion(ctx context.Context) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	if err := r.client.wepReadLimiter.wait(ctx); err != nil {
+		return "", err
+	}
+	list := model.ResourceListOptions{Kind: libapiv3.KindWorkloadEndpoint}
+	if reader, ok := r.client.backend.(bapi.RevisionReader); ok {
+		rev, err := reader.LatestRevision(ctx, list)
+		return rev, contextError(ctx, err)
+	}
+	kvps, err := r.client.backend.List(ctx, list, "")
+	if err != nil {
+		return "", contextError(ctx, err)
+	}
+	return kvps.Revision, nil
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clientv3
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	libapiv3 "github.com/projectcalico/calico/libcalico-go/lib/apis/v3"
+	bapi "github.com/projectcalico/calico/libcalico-go/lib/backend/api"
+	"github.com/projectcalico/calico/libcalico-go/lib/backend/model"
+)
+
+var _ = Describe("WorkloadEndpoint LatestResourceVersion", func() {
+	newWEPs := func(be bapi.Client) workloadEndpoints {
+		return workloadEndpoints{client: client{backend: be, resources: &resources{backend: be}}}
+	}
+
+	It("should read the revision without listing if the backend supports it", func() {
+		be := &revisionReaderBackend{listingBackend: listingBackend{revision: "42"}}
+		rv, err := newWEPs(be).LatestResourceVersion(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rv).To(Equal("42"))
+		Expect(be.lists).To(BeZero())
+		Expect(be.list).To(Equal(model.ResourceListOptions{Kind: libapiv3.KindWorkloadEndpoint}))
+	})
+
+	It("should list the WorkloadEndpoints if the backend can't read the revision", func() {
+		be := &listingBackend{revision: "43"}
+		rv, err := newWEPs(be).LatestResourceVersion(context.Background())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(rv).To(Equal("43"))
+		Expect(be.lists).To(Equal(1))
+	})
+
+	It("should return the context error without calling the backend", func() {
+		be := &revisionReaderBackend{listingBackend: listingBackend{revision: "42"}}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := newWEPs(be).LatestResourceVersion(ctx)
+		Expect(err).To(Equal(context.Canceled))
+		Expect(be.list).To(BeNil())
+	})
+})
+
+// listingBackend is a backend client whose List returns no objects at a fixed revision.
+type listingBackend struct {
+	bapi.Client
+	revision string
+	lists    int
+}
+
+func (b *listingBackend) List(ctx context.Context, list model.ListInterface, revision string) (*model.KVPairList, error) {
+	b.lists++
+	return &model.KVPairList{Revision: b.revision}, nil
+}
+
+// revisionReaderBackend is a listingBackend that also implements bapi.RevisionReader, recording
+// the list options it was called with.
+type revisionReaderBackend struct {
+	listingBackend
+	list model.ListInterface
+}
+
+func (b *revisionReaderBackend) LatestRevision(ctx context.Context, list model.ListInterface) (string, error) {
+	b.list = list
+	return b.revision, nil
+}
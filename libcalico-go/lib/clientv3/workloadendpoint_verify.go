@@ -0,0 +1,182 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clientv3
+
+import (
+	"context"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+
+	libapiv3 "github.com/projectcalico/calico/libcalico-go/lib/apis/v3"
+	"github.com/projectcalico/calico/libcalico-go/lib/options"
+	"github.com/projectcalico/calico/libcalico-go/lib/watch"
+)
+
+// WorkloadEndpointCache is a cache of WorkloadEndpoints that is maintained by applying the events
+// from a WorkloadEndpoint watch, and that can be checked against the datastore with VerifyCache.
+// It should be created from a List and then updated from a Watch that starts at the list's
+// ResourceVersion.  It is not safe for concurrent use.
+type WorkloadEndpointCache struct {
+	// resourceVersion is the revision of the datastore that the cache reflects: that of the
+	// initial list or of the most recent Added or Modified event.
+	resourceVersion string
+	// items contains the cached WorkloadEndpoints, keyed by namespace/name.
+	items map[string]*libapiv3.WorkloadEndpoint
+	// deletedSinceRV contains the WorkloadEndpoints that have been deleted since
+	// resourceVersion was last advanced, mapped to their ResourceVersion before the deletion.
+	// Deleted events don't carry the revision of the deletion so they can't advance
+	// resourceVersion; without this, VerifyCache would report these WorkloadEndpoints as
+	// missing.
+	deletedSinceRV map[string]string
+}
+
+// NewWorkloadEndpointCache returns a cache that contains the listed WorkloadEndpoints, at the
+// list's ResourceVersion.
+func NewWorkloadEndpointCache(list *libapiv3.WorkloadEndpointList) *WorkloadEndpointCache {
+	c := &WorkloadEndpointCache{
+		resourceVersion: list.ResourceVersion,
+		items:           map[string]*libapiv3.WorkloadEndpoint{},
+		deletedSinceRV:  map[string]string{},
+	}
+	for i := range list.Items {
+		c.items[relistKey(&list.Items[i])] = &list.Items[i]
+	}
+	return c
+}
+
+// Apply updates the cache according to the watch event.  Error events are ignored.
+func (c *WorkloadEndpointCache) Apply(e watch.Event) {
+	switch e.Type {
+	case watch.Added, watch.Modified:
+		if wep, ok := e.Object.(*libapiv3.WorkloadEndpoint); ok {
+			c.items[relistKey(wep)] = wep
+			c.resourceVersion = wep.ResourceVersion
+			// Any deletions that we've seen happened before this revision.
+			c.deletedSinceRV = map[string]string{}
+		}
+	case watch.Deleted:
+		if wep, ok := e.Previous.(*libapiv3.WorkloadEndpoint); ok {
+			key := relistKey(wep)
+			delete(c.items, key)
+			c.deletedSinceRV[key] = wep.ResourceVersion
+		}
+	}
+}
+
+// ResourceVersion returns the revision of the datastore that the cache reflects.
+func (c *WorkloadEndpointCache) ResourceVersion() string {
+	return c.resourceVersion
+}
+
+// Get returns the cached WorkloadEndpoint, or nil if it is not in the cache.
+func (c *WorkloadEndpointCache) Get(namespace, name string) *libapiv3.WorkloadEndpoint {
+	return c.items[namespace+"/"+name]
+}
+
+// Len returns the number of cached WorkloadEndpoints.
+func (c *WorkloadEndpointCache) Len() int {
+	return len(c.items)
+}
+
+// WorkloadEndpointCacheDivergence describes the differences between a WorkloadEndpointCache and
+// the datastore at the cache's ResourceVersion.  WorkloadEndpoints are identified by
+// namespace/name.
+type WorkloadEndpointCacheDivergence struct {
+	ResourceVersion string
+
+	// Missing contains the WorkloadEndpoints that are in the datastore but not in the cache.
+	Missing []string
+	// Extra contains the WorkloadEndpoints that are in the cache but not in the datastore.
+	Extra []string
+	// Stale contains a diff, from the cached to the stored revision, for each WorkloadEndpoint
+	// whose cached revision or contents don't match the datastore.
+	Stale []*WorkloadEndpointDiff
+}
+
+// IsEmpty returns true if the cache matches the datastore.
+func (d *WorkloadEndpointCacheDivergence) IsEmpty() bool {
+	return len(d.Missing) == 0 && len(d.Extra) == 0 && len(d.Stale) == 0
+}
+
+// VerifyCache lists the WorkloadEndpoints that match the supplied options at the cache's
+// ResourceVersion and reports any differences between the cache and the list.  The options
+// should match those used to populate the cache.  This allows a consumer to check that the
+// events it has applied produce the same state as a fresh List, to detect bugs in the watch
+// machinery or in its own event handling.
+//
+// The check is exact for etcd, where a List at a revision reflects the datastore at that
+// revision.  It returns an error if the revision is no longer available, for example, because it
+// has been compacted; the caller can simply retry later, once the cache has advanced.
+func (r workloadEndpoints) VerifyCache(ctx context.Context, cache *WorkloadEndpointCache, opts options.ListOptions) (*WorkloadEndpointCacheDivergence, error) {
+	opts.ResourceVersion = cache.resourceVersion
+	opts.Consistent = true
+	list, err := r.List(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	div := &WorkloadEndpointCacheDivergence{ResourceVersion: cache.resourceVersion}
+	listed := map[string]*libapiv3.WorkloadEndpoint{}
+	for i := range list.Items {
+		wep := &list.Items[i]
+		key := relistKey(wep)
+		listed[key] = wep
+		cached, ok := cache.items[key]
+		if !ok {
+			if rv, ok := cache.deletedSinceRV[key]; ok && rv == wep.ResourceVersion {
+				// Deleted after the cache's revision.
+				continue
+			}
+			div.Missing = append(div.Missing, key)
+			continue
+		}
+		diff := &WorkloadEndpointDiff{
+			Namespace: wep.Namespace,
+			Name:      wep.Name,
+			RevisionA: cached.ResourceVersion,
+			RevisionB: wep.ResourceVersion,
+			ExistsA:   true,
+			ExistsB:   true,
+		}
+		diffWorkloadEndpoints(cached, wep, diff)
+		if cached.ResourceVersion != wep.ResourceVersion || !diff.IsEmpty() {
+			div.Stale = append(div.Stale, diff)
+		}
+	}
+	for key := range cache.items {
+		if _, ok := listed[key]; !ok {
+			div.Extra = append(div.Extra, key)
+		}
+	}
+	sort.Strings(div.Missing)
+	sort.Strings(div.Extra)
+	sort.Slice(div.Stale, func(i, j int) bool {
+		if div.Stale[i].Namespace != div.Stale[j].Namespace {
+			return div.Stale[i].Namespace < div.Stale[j].Namespace
+		}
+		return div.Stale[i].Name < div.Stale[j].Name
+	})
+
+	if !div.IsEmpty() {
+		log.WithFields(log.Fields{
+			"resourceVersion": div.ResourceVersion,
+			"missing":         div.Missing,
+			"extra":           div.Extra,
+			"numStale":        len(div.Stale),
+		}).Warning("WorkloadEndpoint cache diverges from datastore")
+	}
+	return div, nil
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package clientv3
+
+import (
+	"fmt"
+
+	apiv3 "github.com/projectcalico/api/pkg/apis/projectcalico/v3"
+	log "github.com/sirupsen/logrus"
+
+	libapiv3 "github.com/projectcalico/calico/libcalico-go/lib/apis/v3"
+	"github.com/projectcalico/calico/libcalico-go/lib/options"
+)
+
+// deprecatedOrchestrators contains the WorkloadEndpoint orchestrators that are deprecated.
+var deprecatedOrchestrators = map[string]bool{
+	"libnetwork": true,
+}
+
+// wepWarnings collects the advisory messages about a WorkloadEndpoint write.  They are passed
+// to the WarningHandler in the options once the write has succeeded.  A nil *wepWarnings
+// discards them.
+type wepWarnings []string
+
+func (w *wepWarnings) add(format string, args ...interface{}) {
+	if w == nil {
+		return
+	}
+	*w = append(*w, fmt.Sprintf(format, args...))
+}
+
+// deliver passes the warnings to the WarningHandler in the options, if there is one.
+func (w wepWarnings) deliver(opts options.SetOptions) {
+	for _, warning := range w {
+		log.WithField("warning", warning).Debug("WorkloadEndpoint write succeeded with a warning")
+		if opts.WarningHandler != nil {
+			opts.WarningHandler(warning)
+		}
+	}
+}
+
+// checkWarnings adds warnings for the parts of a valid WorkloadEndpoint that are deprecated, or
+// that will be ignored or overridden when it is stored.  It must be called before the labels are
+// updated for storage.
+func checkWarnings(res *libapiv3.WorkloadEndpoint, warnings *wepWarnings) {
+	if deprecatedOrchestrators[res.Spec.Orchestrator] {
+		warnings.add("Spec.Orchestrator: the %s orchestrator is deprecated", res.Spec.Orchestrator)
+	}
+	switch res.Spec.Orchestrator {
+	case "k8s", "cni", "libnetwork":
+		if res.Spec.Workload != "" {
+			warnings.add("Spec.Workload is ignored for the %s orchestrator", res.Spec.Orchestrator)
+		}
+	}
+	for label, value := range map[string]string{
+		apiv3.LabelNamespace:    res.Namespace,
+		apiv3.LabelOrchestrator: res.Spec.Orchestrator,
+	} {
+		if v, ok := res.Labels[label]; ok && v != value {
+			warnings.add("label %s=%q was replaced with %q", label, v, value)
+		}
+	}
+}
@@ -17,12 +17,46 @@ package errors
 import (
 	"fmt"
 	"net/http"
+	"reflect"
+	"strings"
 
 	networkingv1 "k8s.io/api/networking/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+// ResourceIdentifier is implemented by error Identifiers, such as model.ResourceKey, that identify
+// a resource by its kind, namespace and name.  The namespace is empty for resources that are not
+// namespaced.
+type ResourceIdentifier interface {
+	ResourceIdentity() (kind, namespace, name string)
+}
+
+func resourceIdentity(id interface{}) (kind, namespace, name string) {
+	if ri, ok := id.(ResourceIdentifier); ok {
+		return ri.ResourceIdentity()
+	}
+	return "", "", ""
+}
+
+// identifierMatches reports whether the Identifier of an error matches the Identifier of a
+// target passed to errors.Is.  A nil target matches any Identifier.
+func identifierMatches(target, id interface{}) bool {
+	if target == nil {
+		return true
+	}
+	if t, ok := target.(ResourceIdentifier); ok {
+		i, ok := id.(ResourceIdentifier)
+		if !ok {
+			return false
+		}
+		tKind, tNamespace, tName := t.ResourceIdentity()
+		kind, namespace, name := i.ResourceIdentity()
+		return tKind == kind && tNamespace == namespace && tName == name
+	}
+	return reflect.TypeOf(target).Comparable() && target == id
+}
+
 // Error indicating a problem connecting to the backend.
 type ErrorDatastoreError struct {
 	Err        error
@@ -33,6 +67,10 @@ func (e ErrorDatastoreError) Error() string {
 	return e.Err.Error()
 }
 
+func (e ErrorDatastoreError) Unwrap() error {
+	return e.Err
+}
+
 func (e ErrorDatastoreError) Status() metav1.Status {
 	if i, ok := e.Err.(apierrors.APIStatus); ok {
 		return i.Status()
@@ -61,6 +99,36 @@ func (e ErrorResourceDoesNotExist) Error() string {
 	return fmt.Sprintf("resource does not exist: %v with error: %v", e.Identifier, e.Err)
 }
 
+func (e ErrorResourceDoesNotExist) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is an ErrorResourceDoesNotExist for the same resource, or for any
+// resource if the target has no Identifier.
+func (e ErrorResourceDoesNotExist) Is(target error) bool {
+	t, ok := target.(ErrorResourceDoesNotExist)
+	return ok && identifierMatches(t.Identifier, e.Identifier)
+}
+
+// Kind returns the kind of the resource, or "" if the Identifier is not a ResourceIdentifier.
+func (e ErrorResourceDoesNotExist) Kind() string {
+	kind, _, _ := resourceIdentity(e.Identifier)
+	return kind
+}
+
+// Namespace returns the namespace of the resource, or "" if the resource is not namespaced or
+// the Identifier is not a ResourceIdentifier.
+func (e ErrorResourceDoesNotExist) Namespace() string {
+	_, namespace, _ := resourceIdentity(e.Identifier)
+	return namespace
+}
+
+// Name returns the name of the resource, or "" if the Identifier is not a ResourceIdentifier.
+func (e ErrorResourceDoesNotExist) Name() string {
+	_, _, name := resourceIdentity(e.Identifier)
+	return name
+}
+
 // Error indicating an operation is not supported.
 type ErrorOperationNotSupported struct {
 	Operation  string
@@ -87,6 +155,36 @@ func (e ErrorResourceAlreadyExists) Error() string {
 	return fmt.Sprintf("resource already exists: %v", e.Identifier)
 }
 
+func (e ErrorResourceAlreadyExists) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is an ErrorResourceAlreadyExists for the same resource, or for any
+// resource if the target has no Identifier.
+func (e ErrorResourceAlreadyExists) Is(target error) bool {
+	t, ok := target.(ErrorResourceAlreadyExists)
+	return ok && identifierMatches(t.Identifier, e.Identifier)
+}
+
+// Kind returns the kind of the resource, or "" if the Identifier is not a ResourceIdentifier.
+func (e ErrorResourceAlreadyExists) Kind() string {
+	kind, _, _ := resourceIdentity(e.Identifier)
+	return kind
+}
+
+// Namespace returns the namespace of the resource, or "" if the resource is not namespaced or
+// the Identifier is not a ResourceIdentifier.
+func (e ErrorResourceAlreadyExists) Namespace() string {
+	_, namespace, _ := resourceIdentity(e.Identifier)
+	return namespace
+}
+
+// Name returns the name of the resource, or "" if the Identifier is not a ResourceIdentifier.
+func (e ErrorResourceAlreadyExists) Name() string {
+	_, _, name := resourceIdentity(e.Identifier)
+	return name
+}
+
 // Error indicating a problem connecting to the backend.
 type ErrorConnectionUnauthorized struct {
 	Err error
@@ -155,6 +253,36 @@ func (e ErrorResourceUpdateConflict) Error() string {
 	return fmt.Sprintf("update conflict: %v", e.Identifier)
 }
 
+func (e ErrorResourceUpdateConflict) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is an ErrorResourceUpdateConflict for the same resource, or for any
+// resource if the target has no Identifier.
+func (e ErrorResourceUpdateConflict) Is(target error) bool {
+	t, ok := target.(ErrorResourceUpdateConflict)
+	return ok && identifierMatches(t.Identifier, e.Identifier)
+}
+
+// Kind returns the kind of the resource, or "" if the Identifier is not a ResourceIdentifier.
+func (e ErrorResourceUpdateConflict) Kind() string {
+	kind, _, _ := resourceIdentity(e.Identifier)
+	return kind
+}
+
+// Namespace returns the namespace of the resource, or "" if the resource is not namespaced or
+// the Identifier is not a ResourceIdentifier.
+func (e ErrorResourceUpdateConflict) Namespace() string {
+	_, namespace, _ := resourceIdentity(e.Identifier)
+	return namespace
+}
+
+// Name returns the name of the resource, or "" if the Identifier is not a ResourceIdentifier.
+func (e ErrorResourceUpdateConflict) Name() string {
+	_, _, name := resourceIdentity(e.Identifier)
+	return name
+}
+
 // Error indicating that the caller has attempted to release an IP address using
 // outdated information.
 type ErrorBadHandle struct {
@@ -190,6 +318,80 @@ func (e ErrorPartialFailure) Error() string {
 	return fmt.Sprintf("operation partially failed: %v", e.Err)
 }
 
+// Error indicating that an operation on a collection of resources failed for some of them, while
+// continuing with the others.  Errors holds the error for each resource that failed.
+type ErrorAggregate struct {
+	Errors []error
+}
+
+func (e ErrorAggregate) Error() string {
+	if len(e.Errors) == 1 {
+		return e.Errors[0].Error()
+	}
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d errors occurred: %s", len(e.Errors), strings.Join(msgs, "; "))
+}
+
+func (e ErrorAggregate) Unwrap() []error {
+	return e.Errors
+}
+
+// Error indicating that a conditional operation was not performed because the resource did not
+// satisfy the caller's precondition.
+type ErrorPreconditionFailed struct {
+	Identifier interface{}
+	Reason     string
+}
+
+func (e ErrorPreconditionFailed) Error() string {
+	return fmt.Sprintf("precondition failed for %v: %s", e.Identifier, e.Reason)
+}
+
+// Error indicating that an operation was rejected by a client-side rate limiter, because it
+// would have exceeded the configured rate.
+type ErrorRateLimited struct {
+	Operation string
+	QPS       float64
+}
+
+func (e ErrorRateLimited) Error() string {
+	return fmt.Sprintf("%s rate limited: client limit of %v requests per second exceeded", e.Operation, e.QPS)
+}
+
+// Error indicating that a transaction failed, so none of its operations were applied.  Index is
+// the position of the operation that caused the failure, and Err the reason it failed.
+type ErrorTransactionFailed struct {
+	Index int
+	Err   error
+}
+
+func (e ErrorTransactionFailed) Error() string {
+	return fmt.Sprintf("transaction failed at operation %d: %v", e.Index, e.Err)
+}
+
+func (e ErrorTransactionFailed) Unwrap() error {
+	return e.Err
+}
+
+// Error indicating that a watch's consumer did not keep up with its events, so the watch's buffer
+// of undelivered events overflowed.  If Closed, the watch was stopped; otherwise Dropped is the
+// number of buffered events that were discarded.
+type ErrorWatchOverflow struct {
+	BufferSize int
+	Dropped    int
+	Closed     bool
+}
+
+func (e ErrorWatchOverflow) Error() string {
+	if e.Closed {
+		return fmt.Sprintf("watch closed: buffer of %d events overflowed", e.BufferSize)
+	}
+	return fmt.Sprintf("watch buffer of %d events overflowed: dropped %d events", e.BufferSize, e.Dropped)
+}
+
 // UpdateErrorIdentifier modifies the supplied error to use the new resource
 // identifier.
 func UpdateErrorIdentifier(err error, id interface{}) error {
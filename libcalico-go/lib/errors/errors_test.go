@@ -15,6 +15,10 @@
 package errors_test
 
 import (
+	goerrors "errors"
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 
@@ -54,6 +58,21 @@ var _ = DescribeTable(
 		},
 		"operation apply is not supported on foo.bar.baz: cannot mix foobar with baz",
 	),
+	Entry(
+		"Aggregate with one error",
+		errors.ErrorAggregate{Errors: []error{
+			errors.ErrorResourceDoesNotExist{Identifier: "foo"},
+		}},
+		"resource does not exist: foo with error: <nil>",
+	),
+	Entry(
+		"Aggregate with several errors",
+		errors.ErrorAggregate{Errors: []error{
+			errors.ErrorResourceDoesNotExist{Identifier: "foo"},
+			errors.ErrorResourceUpdateConflict{Identifier: "bar"},
+		}},
+		"2 errors occurred: resource does not exist: foo with error: <nil>; update conflict: bar",
+	),
 	Entry(
 		"Policy conversion with no rules",
 		errors.ErrorPolicyConversion{
@@ -165,3 +184,74 @@ var _ = DescribeTable(
 		"policy: test-policy3: error with the following rules:\n-  &NetworkPolicyEgressRule{Ports:[]NetworkPolicyPort{NetworkPolicyPort{Protocol:nil,Port:80,EndPort:nil,},NetworkPolicyPort{Protocol:nil,Port:-22:-3,EndPort:nil,},},To:[]NetworkPolicyPeer{NetworkPolicyPeer{PodSelector:&v1.LabelSelector{MatchLabels:map[string]string{k: v,k2: v2,},MatchExpressions:[]LabelSelectorRequirement{},},NamespaceSelector:nil,IPBlock:nil,},},} (reason1)\n-  &NetworkPolicyIngressRule{Ports:[]NetworkPolicyPort{NetworkPolicyPort{Protocol:nil,Port:80,EndPort:nil,},NetworkPolicyPort{Protocol:nil,Port:-50:-1,EndPort:nil,},},From:[]NetworkPolicyPeer{NetworkPolicyPeer{PodSelector:&v1.LabelSelector{MatchLabels:map[string]string{k: v,k2: v2,},MatchExpressions:[]LabelSelectorRequirement{},},NamespaceSelector:nil,IPBlock:nil,},},} (reason2)\n-  unknown rule (reason3)\n",
 	),
 )
+
+var _ = Describe("resource error identity", func() {
+	key := model.ResourceKey{Kind: v3.KindNetworkPolicy, Namespace: "namespace1", Name: "policy1"}
+
+	DescribeTable("should keep the existing error strings",
+		func(err error, expected string) {
+			Expect(err.Error()).To(Equal(expected))
+		},
+		Entry("does not exist", errors.ErrorResourceDoesNotExist{Identifier: key, Err: fmt.Errorf("not found")},
+			"resource does not exist: NetworkPolicy(namespace1/policy1) with error: not found"),
+		Entry("already exists", errors.ErrorResourceAlreadyExists{Identifier: key},
+			"resource already exists: NetworkPolicy(namespace1/policy1)"),
+		Entry("update conflict", errors.ErrorResourceUpdateConflict{Identifier: key},
+			"update conflict: NetworkPolicy(namespace1/policy1)"),
+	)
+
+	It("should extract the identifier fields of a wrapped error with errors.As", func() {
+		err := fmt.Errorf("getting policy: %w", errors.ErrorResourceDoesNotExist{Identifier: key})
+		var doesNotExist errors.ErrorResourceDoesNotExist
+		Expect(goerrors.As(err, &doesNotExist)).To(BeTrue())
+		Expect(doesNotExist.Kind()).To(Equal(v3.KindNetworkPolicy))
+		Expect(doesNotExist.Namespace()).To(Equal("namespace1"))
+		Expect(doesNotExist.Name()).To(Equal("policy1"))
+
+		err = fmt.Errorf("creating policy: %w", errors.ErrorResourceAlreadyExists{Identifier: key})
+		var alreadyExists errors.ErrorResourceAlreadyExists
+		Expect(goerrors.As(err, &alreadyExists)).To(BeTrue())
+		Expect(alreadyExists.Kind()).To(Equal(v3.KindNetworkPolicy))
+		Expect(alreadyExists.Namespace()).To(Equal("namespace1"))
+		Expect(alreadyExists.Name()).To(Equal("policy1"))
+
+		err = fmt.Errorf("updating policy: %w", errors.ErrorResourceUpdateConflict{Identifier: key})
+		var conflict errors.ErrorResourceUpdateConflict
+		Expect(goerrors.As(err, &conflict)).To(BeTrue())
+		Expect(conflict.Kind()).To(Equal(v3.KindNetworkPolicy))
+		Expect(conflict.Namespace()).To(Equal("namespace1"))
+		Expect(conflict.Name()).To(Equal("policy1"))
+		Expect(goerrors.As(err, &doesNotExist)).To(BeFalse())
+	})
+
+	It("should return empty identifier fields for other identifiers", func() {
+		err := errors.ErrorResourceDoesNotExist{Identifier: "policy1"}
+		Expect(err.Kind()).To(BeEmpty())
+		Expect(err.Namespace()).To(BeEmpty())
+		Expect(err.Name()).To(BeEmpty())
+	})
+
+	It("should unwrap the underlying error", func() {
+		cause := fmt.Errorf("key not found")
+		Expect(goerrors.Is(errors.ErrorResourceDoesNotExist{Identifier: key, Err: cause}, cause)).To(BeTrue())
+		Expect(goerrors.Is(errors.ErrorResourceAlreadyExists{Identifier: key, Err: cause}, cause)).To(BeTrue())
+		Expect(goerrors.Is(errors.ErrorResourceUpdateConflict{Identifier: key, Err: cause}, cause)).To(BeTrue())
+		Expect(goerrors.Is(errors.ErrorDatastoreError{Identifier: key, Err: cause}, cause)).To(BeTrue())
+	})
+
+	DescribeTable("errors.Is",
+		func(err, target error, expected bool) {
+			Expect(goerrors.Is(fmt.Errorf("wrapped: %w", err), target)).To(Equal(expected))
+		},
+		Entry("any resource", errors.ErrorResourceDoesNotExist{Identifier: key, Err: fmt.Errorf("not found")},
+			errors.ErrorResourceDoesNotExist{}, true),
+		Entry("same resource", errors.ErrorResourceUpdateConflict{Identifier: key},
+			errors.ErrorResourceUpdateConflict{Identifier: model.ResourceKey{Kind: v3.KindNetworkPolicy, Namespace: "namespace1", Name: "policy1"}}, true),
+		Entry("different resource", errors.ErrorResourceUpdateConflict{Identifier: key},
+			errors.ErrorResourceUpdateConflict{Identifier: model.ResourceKey{Kind: v3.KindNetworkPolicy, Namespace: "namespace2", Name: "policy1"}}, false),
+		Entry("same string identifier", errors.ErrorResourceAlreadyExists{Identifier: "policy1"},
+			errors.ErrorResourceAlreadyExists{Identifier: "policy1"}, true),
+		Entry("different type", errors.ErrorResourceAlreadyExists{Identifier: key},
+			errors.ErrorResourceDoesNotExist{}, false),
+	)
+})
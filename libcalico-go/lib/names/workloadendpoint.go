@@ -257,6 +257,12 @@ func ParseWorkloadEndpointName(wepName string) (WorkloadEndpointIdentifiers, err
 		default:
 			orchFlds = otherFields
 		}
+		if pl-2 > len(orchFlds) {
+			// This is not a name we could have constructed, for example a name using a
+			// legacy format that predates the dash escaping.
+			return WorkloadEndpointIdentifiers{}, fmt.Errorf(
+				"Cannot parse %s: too many name segments for orchestrator %s", wepName, parts[1])
+		}
 		if pl > 2 {
 			weidR := reflect.ValueOf(&weid)
 			weidStruct := weidR.Elem()
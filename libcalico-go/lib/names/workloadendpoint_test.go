@@ -240,4 +240,6 @@ var _ = DescribeTable("WorkloadEndpoint name parsing",
 		Workload:     "workload",
 		Endpoint:     "eth0",
 	}),
+	Entry("Legacy k8s name with unescaped dashes", "node-1-k8s-pod-1-eth0", true, names.WorkloadEndpointIdentifiers{}),
+	Entry("Legacy k8s name with too many segments", "node-k8s-pod-eth0-extra", true, names.WorkloadEndpointIdentifiers{}),
 )
@@ -0,0 +1,323 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package options
+
+import (
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// The builders below construct the options structs fluently, for example:
+//
+//	opts, err := options.NewListOptions().WithNamespace("default").WithPrefix("node1-").Build()
+//
+// Each option is validated as it is set, along with the options already set, so that an invalid
+// combination is reported against the option that caused it.  Once an option has been rejected,
+// the builder ignores any further options and Build() returns the error.  The structs can still
+// be used directly; their Validate() methods apply the same checks.
+
+// ListOptionsBuilder builds a ListOptions; see NewListOptions().
+type ListOptionsBuilder struct {
+	opts ListOptions
+	err  error
+}
+
+// NewListOptions returns a builder for a ListOptions, starting from the defaults.
+func NewListOptions() *ListOptionsBuilder {
+	return &ListOptionsBuilder{}
+}
+
+func (b *ListOptionsBuilder) set(update func(o *ListOptions)) *ListOptionsBuilder {
+	if b.err != nil {
+		return b
+	}
+	o := b.opts
+	update(&o)
+	if err := o.Validate(); err != nil {
+		b.err = err
+		return b
+	}
+	b.opts = o
+	return b
+}
+
+// WithNamespace restricts the List or Watch to the given namespace.
+func (b *ListOptionsBuilder) WithNamespace(namespace string) *ListOptionsBuilder {
+	return b.set(func(o *ListOptions) { o.Namespace = namespace })
+}
+
+// WithName restricts the List or Watch to the resource with the given name.
+func (b *ListOptionsBuilder) WithName(name string) *ListOptionsBuilder {
+	return b.set(func(o *ListOptions) { o.Name = name; o.Prefix = false })
+}
+
+// WithPrefix restricts the List or Watch to the resources whose names start with the given
+// prefix, which must not be empty.
+func (b *ListOptionsBuilder) WithPrefix(prefix string) *ListOptionsBuilder {
+	return b.set(func(o *ListOptions) { o.Name = prefix; o.Prefix = true })
+}
+
+// WithNames restricts the List or Watch to the resources with the given names.
+func (b *ListOptionsBuilder) WithNames(names ...string) *ListOptionsBuilder {
+	return b.set(func(o *ListOptions) { o.Names = append([]string(nil), names...) })
+}
+
+// WithResourceVersion sets the resource version to List or Watch from.
+func (b *ListOptionsBuilder) WithResourceVersion(rev string) *ListOptionsBuilder {
+	return b.set(func(o *ListOptions) { o.ResourceVersion = rev })
+}
+
+// WithConsistent forces the List or Watch to be served by the primary datastore.
+func (b *ListOptionsBuilder) WithConsistent() *ListOptionsBuilder {
+	return b.set(func(o *ListOptions) { o.Consistent = true })
+}
+
+// WithReserved sets the filter for reserved WorkloadEndpoints.
+func (b *ListOptionsBuilder) WithReserved(filter ReservedFilter) *ListOptionsBuilder {
+	return b.set(func(o *ListOptions) { o.Reserved = filter })
+}
+
+// WithProjection sets the subset of fields to populate in each listed resource.
+func (b *ListOptionsBuilder) WithProjection(fields ...string) *ListOptionsBuilder {
+	return b.set(func(o *ListOptions) { o.Projection = append([]string(nil), fields...) })
+}
+
+// WithLabelSelector restricts a List to the resources whose labels match the selector.
+func (b *ListOptionsBuilder) WithLabelSelector(selector string) *ListOptionsBuilder {
+	return b.set(func(o *ListOptions) { o.LabelSelector = selector })
+}
+
+// WithFieldSelector restricts a List or Watch to the resources whose fields match the selector.
+func (b *ListOptionsBuilder) WithFieldSelector(selector string) *ListOptionsBuilder {
+	return b.set(func(o *ListOptions) { o.FieldSelector = selector })
+}
+
+// WithLimit sets the maximum number of resources that a List returns, which must not be negative.
+func (b *ListOptionsBuilder) WithLimit(limit int64) *ListOptionsBuilder {
+	return b.set(func(o *ListOptions) { o.Limit = limit })
+}
+
+// WithContinue sets the token to list the next page of a List with a Limit.
+func (b *ListOptionsBuilder) WithContinue(token string) *ListOptionsBuilder {
+	return b.set(func(o *ListOptions) { o.Continue = token })
+}
+
+// WithWatchBufferSize sets the number of undelivered events that a Watch buffers.
+func (b *ListOptionsBuilder) WithWatchBufferSize(size int) *ListOptionsBuilder {
+	return b.set(func(o *ListOptions) { o.WatchBufferSize = size })
+}
+
+// WithWatchOverflowPolicy sets what a Watch does when its buffer is full.
+func (b *ListOptionsBuilder) WithWatchOverflowPolicy(policy WatchOverflowPolicy) *ListOptionsBuilder {
+	return b.set(func(o *ListOptions) { o.WatchOverflowPolicy = policy })
+}
+
+// WithReconnect makes a Watch re-establish itself after transient datastore errors.
+func (b *ListOptionsBuilder) WithReconnect() *ListOptionsBuilder {
+	return b.set(func(o *ListOptions) { o.Reconnect = true })
+}
+
+// WithWatchBookmarks makes a Watch send Bookmark events, every interval, or every minute if the
+// interval is zero.  The interval must not be negative.
+func (b *ListOptionsBuilder) WithWatchBookmarks(interval time.Duration) *ListOptionsBuilder {
+	return b.set(func(o *ListOptions) {
+		o.AllowWatchBookmarks = true
+		o.WatchBookmarkInterval = interval
+	})
+}
+
+// WithWatchProjection sets the subset of fields to populate in the objects of each watch event.
+func (b *ListOptionsBuilder) WithWatchProjection(fields ...string) *ListOptionsBuilder {
+	return b.set(func(o *ListOptions) { o.WatchProjection = append([]string(nil), fields...) })
+}
+
+// Build returns the ListOptions, or the error from the first option that was rejected.
+func (b *ListOptionsBuilder) Build() (ListOptions, error) {
+	if b.err != nil {
+		return ListOptions{}, b.err
+	}
+	return b.opts, nil
+}
+
+// GetOptionsBuilder builds a GetOptions; see NewGetOptions().
+type GetOptionsBuilder struct {
+	opts GetOptions
+}
+
+// NewGetOptions returns a builder for a GetOptions, starting from the defaults.
+func NewGetOptions() *GetOptionsBuilder {
+	return &GetOptionsBuilder{}
+}
+
+// WithResourceVersion sets the resource version to get.
+func (b *GetOptionsBuilder) WithResourceVersion(rev string) *GetOptionsBuilder {
+	b.opts.ResourceVersion = rev
+	return b
+}
+
+// WithConsistent forces the read to be served by the primary datastore.
+func (b *GetOptionsBuilder) WithConsistent() *GetOptionsBuilder {
+	b.opts.Consistent = true
+	return b
+}
+
+// Build returns the GetOptions.  None of the get options can conflict, so the error is always
+// nil; it's returned for consistency with the other builders.
+func (b *GetOptionsBuilder) Build() (GetOptions, error) {
+	return b.opts, nil
+}
+
+// SetOptionsBuilder builds a SetOptions; see NewSetOptions().
+type SetOptionsBuilder struct {
+	opts SetOptions
+	err  error
+}
+
+// NewSetOptions returns a builder for a SetOptions, starting from the defaults.
+func NewSetOptions() *SetOptionsBuilder {
+	return &SetOptionsBuilder{}
+}
+
+func (b *SetOptionsBuilder) set(update func(o *SetOptions)) *SetOptionsBuilder {
+	if b.err != nil {
+		return b
+	}
+	o := b.opts
+	update(&o)
+	if err := o.Validate(); err != nil {
+		b.err = err
+		return b
+	}
+	b.opts = o
+	return b
+}
+
+// WithTTL sets the TTL of the datastore entry, which must not be negative.
+func (b *SetOptionsBuilder) WithTTL(ttl time.Duration) *SetOptionsBuilder {
+	return b.set(func(o *SetOptions) { o.TTL = ttl })
+}
+
+// WithDryRun makes a Create or Update validate the resource without writing it.
+func (b *SetOptionsBuilder) WithDryRun() *SetOptionsBuilder {
+	return b.set(func(o *SetOptions) { o.DryRun = true })
+}
+
+// WithWarningHandler sets the function that is called with each warning about a successful
+// Create or Update.
+func (b *SetOptionsBuilder) WithWarningHandler(handler func(warning string)) *SetOptionsBuilder {
+	return b.set(func(o *SetOptions) { o.WarningHandler = handler })
+}
+
+// WithAllowLegacyName makes a Create or Update accept a WorkloadEndpoint whose name does not
+// match its Spec.
+func (b *SetOptionsBuilder) WithAllowLegacyName() *SetOptionsBuilder {
+	return b.set(func(o *SetOptions) { o.AllowLegacyName = true })
+}
+
+// Build returns the SetOptions, or the error from the first option that was rejected.
+func (b *SetOptionsBuilder) Build() (SetOptions, error) {
+	if b.err != nil {
+		return SetOptions{}, b.err
+	}
+	return b.opts, nil
+}
+
+// DeleteOptionsBuilder builds a DeleteOptions; see NewDeleteOptions().
+type DeleteOptionsBuilder struct {
+	opts DeleteOptions
+	err  error
+}
+
+// NewDeleteOptions returns a builder for a DeleteOptions, starting from the defaults.
+func NewDeleteOptions() *DeleteOptionsBuilder {
+	return &DeleteOptionsBuilder{}
+}
+
+func (b *DeleteOptionsBuilder) set(update func(o *DeleteOptions)) *DeleteOptionsBuilder {
+	if b.err != nil {
+		return b
+	}
+	o := b.opts
+	update(&o)
+	if err := o.Validate(); err != nil {
+		b.err = err
+		return b
+	}
+	b.opts = o
+	return b
+}
+
+// WithResourceVersion makes the delete conditional on the resource version.
+func (b *DeleteOptionsBuilder) WithResourceVersion(rev string) *DeleteOptionsBuilder {
+	return b.set(func(o *DeleteOptions) { o.ResourceVersion = rev })
+}
+
+// WithUID makes the delete conditional on the UID, which must not be empty.
+func (b *DeleteOptionsBuilder) WithUID(uid types.UID) *DeleteOptionsBuilder {
+	return b.set(func(o *DeleteOptions) { o.UID = &uid })
+}
+
+// Build returns the DeleteOptions, or the error from the first option that was rejected.
+func (b *DeleteOptionsBuilder) Build() (DeleteOptions, error) {
+	if b.err != nil {
+		return DeleteOptions{}, b.err
+	}
+	return b.opts, nil
+}
+
+// PatchOptionsBuilder builds a PatchOptions; see NewPatchOptions().
+type PatchOptionsBuilder struct {
+	opts PatchOptions
+	err  error
+}
+
+// NewPatchOptions returns a builder for a PatchOptions, starting from the defaults.
+func NewPatchOptions() *PatchOptionsBuilder {
+	return &PatchOptionsBuilder{}
+}
+
+func (b *PatchOptionsBuilder) set(update func(o *PatchOptions)) *PatchOptionsBuilder {
+	if b.err != nil {
+		return b
+	}
+	o := b.opts
+	update(&o)
+	if err := o.Validate(); err != nil {
+		b.err = err
+		return b
+	}
+	b.opts = o
+	return b
+}
+
+// WithResourceVersion makes the patch conditional on the resource version.
+func (b *PatchOptionsBuilder) WithResourceVersion(rev string) *PatchOptionsBuilder {
+	return b.set(func(o *PatchOptions) { o.ResourceVersion = rev })
+}
+
+// WithWarningHandler sets the function that is called with each warning about a successful
+// patch.
+func (b *PatchOptionsBuilder) WithWarningHandler(handler func(warning string)) *PatchOptionsBuilder {
+	return b.set(func(o *PatchOptions) { o.WarningHandler = handler })
+}
+
+// Build returns the PatchOptions, or the error from the first option that was rejected.
+func (b *PatchOptionsBuilder) Build() (PatchOptions, error) {
+	if b.err != nil {
+		return PatchOptions{}, b.err
+	}
+	return b.opts, nil
+}
@@ -0,0 +1,203 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package options_test
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/types"
+
+	cerrors "github.com/projectcalico/calico/libcalico-go/lib/errors"
+	"github.com/projectcalico/calico/libcalico-go/lib/options"
+)
+
+// rejectedField returns the name of the single field that err reports as invalid.
+func rejectedField(err error) string {
+	ExpectWithOffset(1, err).To(BeAssignableToTypeOf(cerrors.ErrorValidation{}))
+	fields := err.(cerrors.ErrorValidation).ErroredFields
+	ExpectWithOffset(1, fields).To(HaveLen(1))
+	return fields[0].Name
+}
+
+var _ = Describe("ListOptions builder", func() {
+	It("should build the same struct as setting the fields directly", func() {
+		opts, err := options.NewListOptions().
+			WithNamespace("ns1").
+			WithPrefix("node1-").
+			WithResourceVersion("1234").
+			WithConsistent().
+			WithReserved(options.ReservedExclude).
+			WithProjection("Name", "Node").
+			WithLabelSelector("app == 'web'").
+			WithFieldSelector("spec.node=node1").
+			WithLimit(10).
+			WithWatchBufferSize(10).
+			WithWatchOverflowPolicy(options.WatchOverflowDropOldest).
+			WithWatchBookmarks(10*time.Second).
+			WithReconnect().
+			WithWatchProjection("Name", "ResourceVersion").
+			Build()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(opts).To(Equal(options.ListOptions{
+			Namespace:             "ns1",
+			Name:                  "node1-",
+			Prefix:                true,
+			ResourceVersion:       "1234",
+			Consistent:            true,
+			Reserved:              options.ReservedExclude,
+			Projection:            []string{"Name", "Node"},
+			LabelSelector:         "app == 'web'",
+			FieldSelector:         "spec.node=node1",
+			Limit:                 10,
+			WatchBufferSize:       10,
+			WatchOverflowPolicy:   options.WatchOverflowDropOldest,
+			AllowWatchBookmarks:   true,
+			WatchBookmarkInterval: 10 * time.Second,
+			Reconnect:             true,
+			WatchProjection:       []string{"Name", "ResourceVersion"},
+		}))
+	})
+
+	It("should build the default struct with no options", func() {
+		opts, err := options.NewListOptions().Build()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(opts).To(Equal(options.ListOptions{}))
+	})
+
+	It("should build a list of names", func() {
+		opts, err := options.NewListOptions().WithNamespace("ns1").WithNames("a", "b").Build()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(opts).To(Equal(options.ListOptions{Namespace: "ns1", Names: []string{"a", "b"}}))
+	})
+
+	It("should build the options for the next page of a List", func() {
+		opts, err := options.NewListOptions().WithLimit(10).WithContinue("token").Build()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(opts).To(Equal(options.ListOptions{Limit: 10, Continue: "token"}))
+	})
+
+	It("should replace a prefix with an exact name", func() {
+		opts, err := options.NewListOptions().WithPrefix("node1-").WithName("node1-eth0").Build()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(opts).To(Equal(options.ListOptions{Name: "node1-eth0"}))
+	})
+
+	DescribeTable("should reject invalid combinations as they are set",
+		func(b *options.ListOptionsBuilder, field string) {
+			opts, err := b.Build()
+			Expect(rejectedField(err)).To(Equal(field))
+			Expect(opts).To(Equal(options.ListOptions{}))
+		},
+		Entry("empty prefix", options.NewListOptions().WithPrefix(""), "Prefix"),
+		Entry("name then names", options.NewListOptions().WithName("a").WithNames("b"), "Names"),
+		Entry("names then name", options.NewListOptions().WithNames("b").WithName("a"), "Names"),
+		Entry("names then prefix", options.NewListOptions().WithNames("b").WithPrefix("a"), "Names"),
+		Entry("unknown reserved filter", options.NewListOptions().WithReserved("Sometimes"), "Reserved"),
+		Entry("negative limit", options.NewListOptions().WithLimit(-1), "Limit"),
+		Entry("continue with a resource version",
+			options.NewListOptions().WithResourceVersion("1234").WithContinue("token"), "ResourceVersion"),
+		Entry("negative buffer size", options.NewListOptions().WithWatchBufferSize(-1), "WatchBufferSize"),
+		Entry("unknown overflow policy", options.NewListOptions().WithWatchOverflowPolicy("Sometimes"), "WatchOverflowPolicy"),
+		Entry("buffer too small for DropOldest",
+			options.NewListOptions().WithWatchOverflowPolicy(options.WatchOverflowDropOldest).WithWatchBufferSize(1),
+			"WatchBufferSize"),
+		Entry("negative bookmark interval", options.NewListOptions().WithWatchBookmarks(-time.Second), "WatchBookmarkInterval"),
+	)
+
+	It("should report the first rejected option and ignore later ones", func() {
+		_, err := options.NewListOptions().
+			WithWatchBufferSize(-1).
+			WithReserved("Sometimes").
+			WithNamespace("ns1").
+			Build()
+		Expect(rejectedField(err)).To(Equal("WatchBufferSize"))
+	})
+
+	It("should validate raw structs with the same checks", func() {
+		Expect(options.ListOptions{Namespace: "ns1", Name: "a", Prefix: true}.Validate()).To(Succeed())
+		Expect(rejectedField(options.ListOptions{Prefix: true}.Validate())).To(Equal("Prefix"))
+
+		err := options.ListOptions{Name: "a", Names: []string{"b"}, WatchBufferSize: -1}.Validate()
+		Expect(err).To(BeAssignableToTypeOf(cerrors.ErrorValidation{}))
+		Expect(err.(cerrors.ErrorValidation).ErroredFields).To(HaveLen(2))
+	})
+})
+
+var _ = Describe("GetOptions builder", func() {
+	It("should build the same struct as setting the fields directly", func() {
+		opts, err := options.NewGetOptions().WithResourceVersion("1234").WithConsistent().Build()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(opts).To(Equal(options.GetOptions{ResourceVersion: "1234", Consistent: true}))
+	})
+})
+
+var _ = Describe("SetOptions builder", func() {
+	It("should build the same struct as setting the fields directly", func() {
+		opts, err := options.NewSetOptions().WithTTL(time.Minute).WithDryRun().WithAllowLegacyName().Build()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(opts).To(Equal(options.SetOptions{TTL: time.Minute, DryRun: true, AllowLegacyName: true}))
+	})
+
+	It("should reject a negative TTL", func() {
+		opts, err := options.NewSetOptions().WithTTL(-time.Second).WithDryRun().Build()
+		Expect(rejectedField(err)).To(Equal("TTL"))
+		Expect(opts).To(Equal(options.SetOptions{}))
+		Expect(rejectedField(options.SetOptions{TTL: -time.Second}.Validate())).To(Equal("TTL"))
+	})
+
+	It("should set the warning handler", func() {
+		var warnings []string
+		opts, err := options.NewSetOptions().WithWarningHandler(func(w string) { warnings = append(warnings, w) }).Build()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(opts.WarningHandler).NotTo(BeNil())
+		opts.WarningHandler("deprecated")
+		Expect(warnings).To(Equal([]string{"deprecated"}))
+	})
+})
+
+var _ = Describe("DeleteOptions builder", func() {
+	It("should build the same struct as setting the fields directly", func() {
+		uid := types.UID("abcd")
+		opts, err := options.NewDeleteOptions().WithResourceVersion("1234").WithUID(uid).Build()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(opts).To(Equal(options.DeleteOptions{ResourceVersion: "1234", UID: &uid}))
+	})
+
+	It("should reject an empty UID", func() {
+		_, err := options.NewDeleteOptions().WithUID("").Build()
+		Expect(rejectedField(err)).To(Equal("UID"))
+		empty := types.UID("")
+		Expect(rejectedField(options.DeleteOptions{UID: &empty}.Validate())).To(Equal("UID"))
+	})
+})
+
+var _ = Describe("PatchOptions builder", func() {
+	It("should build the same struct as setting the fields directly", func() {
+		opts, err := options.NewPatchOptions().WithResourceVersion("1234").Build()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(opts).To(Equal(options.PatchOptions{ResourceVersion: "1234"}))
+	})
+
+	It("should set the warning handler", func() {
+		var warnings []string
+		opts, err := options.NewPatchOptions().WithWarningHandler(func(w string) { warnings = append(warnings, w) }).Build()
+		Expect(err).NotTo(HaveOccurred())
+		opts.WarningHandler("deprecated")
+		Expect(warnings).To(Equal([]string{"deprecated"}))
+	})
+})
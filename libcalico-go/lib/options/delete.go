@@ -16,6 +16,8 @@ package options
 
 import (
 	"k8s.io/apimachinery/pkg/types"
+
+	cerrors "github.com/projectcalico/calico/libcalico-go/lib/errors"
 )
 
 // DeleteOptions is the standard options for deleting a resource through the Calico API.
@@ -30,3 +32,18 @@ type DeleteOptions struct {
 	// only delete the resource if its UID matches.
 	UID *types.UID
 }
+
+// Validate checks the options for invalid values, returning an ErrorValidation listing the
+// offending fields.
+func (o DeleteOptions) Validate() error {
+	if o.UID != nil && *o.UID == "" {
+		return cerrors.ErrorValidation{
+			ErroredFields: []cerrors.ErroredField{{
+				Name:   "UID",
+				Value:  "",
+				Reason: "must not be empty if set",
+			}},
+		}
+	}
+	return nil
+}
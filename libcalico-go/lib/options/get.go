@@ -21,4 +21,9 @@ type GetOptions struct {
 	// - if set to non zero, then the result is at least as fresh as given rv.
 	// +optional
 	ResourceVersion string
+
+	// Consistent forces the read to be served by the primary datastore, even if the client
+	// was configured with a read replica.  Use this when the read must reflect all prior
+	// writes.
+	Consistent bool
 }
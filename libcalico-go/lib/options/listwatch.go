@@ -14,6 +14,42 @@
 
 package options
 
+import (
+	"fmt"
+	"time"
+
+	cerrors "github.com/projectcalico/calico/libcalico-go/lib/errors"
+)
+
+// ReservedFilter controls whether reserved (placeholder) WorkloadEndpoints are included in a List.
+type ReservedFilter string
+
+const (
+	// ReservedInclude includes reserved WorkloadEndpoints along with all others.  This is the
+	// default.
+	ReservedInclude ReservedFilter = ""
+	// ReservedExclude excludes reserved WorkloadEndpoints.
+	ReservedExclude ReservedFilter = "Exclude"
+	// ReservedOnly returns only reserved WorkloadEndpoints.
+	ReservedOnly ReservedFilter = "Only"
+)
+
+// WatchOverflowPolicy controls what a Watch does when its consumer falls behind, and its buffer
+// of undelivered events is full.
+type WatchOverflowPolicy string
+
+const (
+	// WatchOverflowBlock stops reading from the datastore until the consumer catches up.  This is
+	// the default.
+	WatchOverflowBlock WatchOverflowPolicy = ""
+	// WatchOverflowDropOldest discards the oldest buffered events to make room for new ones.  An
+	// Error event is sent ahead of the new event so that the consumer knows it has missed events.
+	WatchOverflowDropOldest WatchOverflowPolicy = "DropOldest"
+	// WatchOverflowClose stops the watch.  The consumer receives the buffered events, then an
+	// Error event, and then the result channel is closed.
+	WatchOverflowClose WatchOverflowPolicy = "Close"
+)
+
 // ListOptions is the query options a List or Watch operation in the Calico API.
 type ListOptions struct {
 	// The namespace of the resource to List or Watch.  If blank, the list or watch wildcards
@@ -36,4 +72,175 @@ type ListOptions struct {
 	// as a mechanism for enumerating endpoints within a Pod (since the name construction for a
 	// Workload endpoint is hierarchically constructed).
 	Prefix bool
+
+	// Consistent forces the List or Watch to be served by the primary datastore, even if the
+	// client was configured with a read replica.  Use this when the results must reflect all
+	// prior writes.
+	Consistent bool
+
+	// Reserved filters reserved (placeholder) WorkloadEndpoints from a List.  Only used when
+	// listing WorkloadEndpoints, and ignored by Watch.
+	Reserved ReservedFilter
+
+	// Projection, if non-empty, is the subset of fields to populate in each listed resource;
+	// all other fields are left empty.  Metadata fields are named as in ObjectMeta (e.g.
+	// "Name", "Namespace", "Labels") and spec fields as in the resource's Spec (e.g. "Node",
+	// "IPNetworks").  The TypeMeta is always populated.  The etcdv3 datastore only decodes the
+	// requested fields (and those needed to filter the list).  The Kubernetes datastore builds
+	// each WorkloadEndpoint from the whole Pod, so it reads the whole Pod and then drops the
+	// other fields.  Only supported when listing WorkloadEndpoints, and ignored by Watch.
+	Projection []string
+
+	// Names, if non-empty, restricts the List or Watch to the resources with these names.  It
+	// may not be combined with Name.  Only supported for WorkloadEndpoints.
+	Names []string
+
+	// LabelSelector, if non-empty, restricts a List to the resources whose labels match the
+	// selector, using the Kubernetes label selector syntax, for example "app=web,tier in (a,b)".
+	// For compatibility, a Calico selector expression such as "app == 'web'" is also accepted.
+	// The Kubernetes datastore filters custom resources by the selector when listing them;
+	// otherwise, the resources are filtered after they are listed.  Ignored by Watch.
+	LabelSelector string
+
+	// FieldSelector, if non-empty, restricts a List or Watch to the resources whose fields match
+	// the selector, using the Kubernetes field selector syntax, for example
+	// "spec.node=node-1,spec.orchestrator!=k8s".  Only supported for WorkloadEndpoints, which
+	// may be selected by "metadata.name", "metadata.namespace", "spec.node" and
+	// "spec.orchestrator"; other fields are rejected.  The Kubernetes datastore lists and watches
+	// only the Pods on the node selected by "spec.node"; otherwise, the resources are filtered
+	// after they are read.  A Watch sends an Added or Deleted event when a modification moves a
+	// resource into or out of the selection.
+	FieldSelector string
+
+	// Limit, if non-zero, is the maximum number of resources that a List reads from the
+	// datastore.  If there are more, the Continue field of the returned list is set, along with
+	// the number of resources remaining unless the List is filtered.  A filtered page may have
+	// fewer than Limit resources, or none, without being the last.  In the Kubernetes datastore
+	// the limit is of Pods, so a Pod with more than one WorkloadEndpoint can take a page over the
+	// limit.  Only supported when listing WorkloadEndpoints; a List of any other kind with a
+	// Limit fails with ErrorOperationNotSupported.  Ignored by Watch.
+	Limit int64
+
+	// Continue is the token from the Continue field of the previous page of a List with a
+	// Limit, to list the next page.  The other options must be the same as for the previous
+	// page, except that ResourceVersion may not be set: the token includes the resource
+	// version of the first page.  As for Limit, only supported when listing WorkloadEndpoints,
+	// and ignored by Watch.
+	Continue string
+
+	// WatchBufferSize, if non-zero, is the number of undelivered events that a Watch buffers for
+	// its consumer.  Ignored by List.
+	WatchBufferSize int
+
+	// WatchOverflowPolicy controls what a Watch does when its buffer of undelivered events is
+	// full.  Ignored by List.
+	WatchOverflowPolicy WatchOverflowPolicy
+
+	// AllowWatchBookmarks makes a Watch send Bookmark events, carrying the resource version that
+	// the watch has reached, even when there are no changes to report.  A consumer can record
+	// the resource version to resume the watch from, without replaying the events it has
+	// already seen.  Ignored by List.
+	AllowWatchBookmarks bool
+
+	// WatchBookmarkInterval is how often a Watch with AllowWatchBookmarks sends Bookmark events.
+	// If zero, a default of one minute is used.  The Kubernetes datastore ignores this, since
+	// the API server decides when to send bookmarks.  Ignored by List.
+	WatchBookmarkInterval time.Duration
+
+	// Reconnect makes a Watch re-establish itself, from the resource version of the last event it
+	// delivered, when the connection to the datastore fails with a transient error, rather
+	// than sending an Error event and terminating.  Some events may be delivered more than once
+	// after a reconnect.  If the watch cannot resume because its resource version is no longer
+	// available (for example, because it has been compacted), an Error event is sent and the
+	// Watch terminates: the consumer must List again, and Watch from the resource version of
+	// the list.  Ignored by List.
+	Reconnect bool
+
+	// WatchProjection, if non-empty, is the subset of fields to populate in the Object and
+	// Previous of each watch event, named as for Projection; all other fields are left empty.
+	// As for Projection, the client masks the fields of the full resources it receives.
+	// Include "ResourceVersion" in order to be able to resume the watch.  Only supported when
+	// watching WorkloadEndpoints, and ignored by List.
+	WatchProjection []string
+}
+
+// Validate checks the options for invalid values and combinations, returning an ErrorValidation
+// listing the offending fields.  Some options are only supported by some resource types or
+// datastores; those are checked when the options are used.
+func (o ListOptions) Validate() error {
+	var fields []cerrors.ErroredField
+	if o.Prefix && o.Name == "" {
+		fields = append(fields, cerrors.ErroredField{
+			Name:   "Prefix",
+			Value:  o.Prefix,
+			Reason: "Prefix requires a Name to use as the prefix",
+		})
+	}
+	if len(o.Names) > 0 && o.Name != "" {
+		fields = append(fields, cerrors.ErroredField{
+			Name:   "Names",
+			Value:  o.Names,
+			Reason: "Names may not be combined with Name",
+		})
+	}
+	if o.Limit < 0 {
+		fields = append(fields, cerrors.ErroredField{
+			Name:   "Limit",
+			Value:  o.Limit,
+			Reason: "must not be negative",
+		})
+	}
+	if o.Continue != "" && o.ResourceVersion != "" {
+		fields = append(fields, cerrors.ErroredField{
+			Name:   "ResourceVersion",
+			Value:  o.ResourceVersion,
+			Reason: "ResourceVersion may not be combined with Continue",
+		})
+	}
+	switch o.Reserved {
+	case ReservedInclude, ReservedExclude, ReservedOnly:
+	default:
+		fields = append(fields, cerrors.ErroredField{
+			Name:   "Reserved",
+			Value:  o.Reserved,
+			Reason: "unknown reserved filter",
+		})
+	}
+	if o.WatchBufferSize < 0 {
+		fields = append(fields, cerrors.ErroredField{
+			Name:   "WatchBufferSize",
+			Value:  o.WatchBufferSize,
+			Reason: "must not be negative",
+		})
+	}
+	if o.WatchBookmarkInterval < 0 {
+		fields = append(fields, cerrors.ErroredField{
+			Name:   "WatchBookmarkInterval",
+			Value:  o.WatchBookmarkInterval,
+			Reason: "must not be negative",
+		})
+	}
+	switch o.WatchOverflowPolicy {
+	case WatchOverflowBlock, WatchOverflowClose:
+	case WatchOverflowDropOldest:
+		// An overflow needs room for the Error event as well as the new event.  Zero means
+		// the default size, which is large enough.
+		if o.WatchBufferSize == 1 {
+			fields = append(fields, cerrors.ErroredField{
+				Name:   "WatchBufferSize",
+				Value:  o.WatchBufferSize,
+				Reason: fmt.Sprintf("must be at least 2 for the %s overflow policy", o.WatchOverflowPolicy),
+			})
+		}
+	default:
+		fields = append(fields, cerrors.ErroredField{
+			Name:   "WatchOverflowPolicy",
+			Value:  o.WatchOverflowPolicy,
+			Reason: "unknown overflow policy",
+		})
+	}
+	if len(fields) > 0 {
+		return cerrors.ErrorValidation{ErroredFields: fields}
+	}
+	return nil
 }
@@ -0,0 +1,33 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package options
+
+// PatchOptions is the standard options for patching a resource through the Calico API.
+type PatchOptions struct {
+	// If set, the patch is only applied if the stored resource has this resource version;
+	// otherwise, the patch is applied to the current revision of the resource.
+	// +optional
+	ResourceVersion string
+
+	// WarningHandler, if set, is called with each warning about a patch that succeeded but may
+	// not have done what the caller intended; see SetOptions.
+	WarningHandler func(warning string)
+}
+
+// Validate checks the options for invalid values, returning an ErrorValidation listing the
+// offending fields.
+func (o PatchOptions) Validate() error {
+	return nil
+}
@@ -14,7 +14,11 @@
 
 package options
 
-import "time"
+import (
+	"time"
+
+	cerrors "github.com/projectcalico/calico/libcalico-go/lib/errors"
+)
 
 // SetOptions is the standard options for Create/Update actions on the Calico
 // API.
@@ -22,4 +26,37 @@ type SetOptions struct {
 	// TTL for the datastore entry.
 	// +optional
 	TTL time.Duration
+
+	// DryRun makes a Create or Update validate the resource and check it against the stored
+	// resource, returning the resource that would be written, without writing it.  Only
+	// supported for WorkloadEndpoints.
+	DryRun bool
+
+	// WarningHandler, if set, is called with each warning about a Create or Update that
+	// succeeded but may not have done what the caller intended, for example because it used a
+	// deprecated value or a field was ignored.  It is only called once the write has succeeded.
+	// Only supported for WorkloadEndpoints.
+	WarningHandler func(warning string)
+
+	// AllowLegacyName makes a Create or Update accept a WorkloadEndpoint whose name does not
+	// match the <node>-<orchestrator>-<workload>-<endpoint> name built from its Spec, with a
+	// warning, rather than rejecting it.  It is intended for callers that still construct names
+	// in a legacy format; such WorkloadEndpoints are not found by name-prefix lookups.  Only
+	// supported for WorkloadEndpoints.
+	AllowLegacyName bool
+}
+
+// Validate checks the options for invalid values, returning an ErrorValidation listing the
+// offending fields.
+func (o SetOptions) Validate() error {
+	if o.TTL < 0 {
+		return cerrors.ErrorValidation{
+			ErroredFields: []cerrors.ErroredField{{
+				Name:   "TTL",
+				Value:  o.TTL,
+				Reason: "must not be negative",
+			}},
+		}
+	}
+	return nil
 }
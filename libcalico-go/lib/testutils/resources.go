@@ -119,6 +119,10 @@ type TestResourceWatchInterface interface {
 	// called with sets of added events (not deleted or modified), and is used to verify an
 	// initial snapshot.
 	ExpectEventsAnyOrder(kind string, events []watch.Event)
+
+	// ExpectBookmark waits up to the timeout for the next event, which must be a bookmark,
+	// and returns the resource version of the bookmark.
+	ExpectBookmark(timeout time.Duration) string
 }
 
 // testResourceWatch implements the set of watch-test function described in the docs
@@ -219,6 +223,10 @@ func (t *testResourceWatcher) expectEvents(kind string, anyOrder bool, expectedE
 			} else {
 				o = e.Object
 			}
+			if o == nil {
+				log.Infof("Received event: EventType:%s; ResourceVersion:%s; Error:%v", e.Type, e.ResourceVersion, e.Error)
+				continue
+			}
 			log.Infof(
 				"Received event: EventType:%s; Kind:%s; Name:%s; Namespace:%s",
 				e.Type,
@@ -250,13 +258,17 @@ func (t *testResourceWatcher) expectEvents(kind string, anyOrder bool, expectedE
 		} else {
 			expectedObject = expectedEvent.Object
 		}
-		log.Infof(
-			"Expected: EventType:%s; Kind:%s; Name:%s; Namespace:%s",
-			expectedEvent.Type,
-			expectedObject.GetObjectKind().GroupVersionKind(),
-			expectedObject.(v1.ObjectMetaAccessor).GetObjectMeta().GetName(),
-			expectedObject.(v1.ObjectMetaAccessor).GetObjectMeta().GetNamespace(),
-		)
+		if expectedObject != nil {
+			log.Infof(
+				"Expected: EventType:%s; Kind:%s; Name:%s; Namespace:%s",
+				expectedEvent.Type,
+				expectedObject.GetObjectKind().GroupVersionKind(),
+				expectedObject.(v1.ObjectMetaAccessor).GetObjectMeta().GetName(),
+				expectedObject.(v1.ObjectMetaAccessor).GetObjectMeta().GetNamespace(),
+			)
+		} else {
+			log.Infof("Expected: EventType:%s; ResourceVersion:%s", expectedEvent.Type, expectedEvent.ResourceVersion)
+		}
 
 		if i < len(actualEvents) {
 			actualEvent := actualEvents[i]
@@ -291,6 +303,10 @@ func (t *testResourceWatcher) expectEvents(kind string, anyOrder bool, expectedE
 		traceString := fmt.Sprintf("\nTracing out event details\nActual event: %s\nExpected event: %s\n", actualYaml, expectedYaml)
 
 		Expect(actualEvent.Type).To(Equal(expectedEvent.Type), traceString)
+		Expect(actualEvent.Expired).To(Equal(expectedEvent.Expired), traceString)
+		if expectedEvent.Type == watch.Bookmark && expectedEvent.ResourceVersion != "" {
+			Expect(actualEvent.ResourceVersion).To(Equal(expectedEvent.ResourceVersion), traceString)
+		}
 		if expectedEvent.Object != nil {
 			Expect(actualEvent.Object).NotTo(BeNil(), traceString)
 			Expect(actualEvent.Object).To(MatchResourceWithStatus(
@@ -326,6 +342,26 @@ func (t *testResourceWatcher) expectEvents(kind string, anyOrder bool, expectedE
 	t.events = t.events[len(expectedEvents):]
 }
 
+// ExpectBookmark waits for the next event, validates that it is a bookmark and returns its
+// resource version.  This should be called within a Ginkgo test.
+func (t *testResourceWatcher) ExpectBookmark(timeout time.Duration) string {
+	By("Waiting for a bookmark event")
+	Eventually(func() int {
+		t.lock.Lock()
+		defer t.lock.Unlock()
+		return len(t.events)
+	}, timeout, 10*time.Millisecond).ShouldNot(BeZero(), "No bookmark received")
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	event := t.events[0]
+	Expect(event.Type).To(Equal(watch.Bookmark), fmt.Sprintf("Received %s event instead of a bookmark: %v", event.Type, event))
+	Expect(event.Object).To(BeNil())
+	Expect(event.ResourceVersion).NotTo(BeEmpty())
+	t.events = t.events[1:]
+	return event.ResourceVersion
+}
+
 // sortEvents sorts the events by name order.  Only one event should exist per name.
 func (t *testResourceWatcher) sortEvents(events []watch.Event) []watch.Event {
 	names := []string{}
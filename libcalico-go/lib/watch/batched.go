@@ -0,0 +1,110 @@
+// Copyright (c) 2023 Tigera, Inc. All rights reserved.
+
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package watch
+
+import (
+	"sync"
+	"time"
+)
+
+// BatchedInterface is the batched equivalent of Interface.  Rather than delivering one event per
+// channel read, events are coalesced and delivered as slices.
+type BatchedInterface interface {
+	// Stops watching. Will close the channel returned by ResultChan(). Releases
+	// any resources used by the watch, including the underlying watcher.
+	Stop()
+
+	// Returns a chan which will receive batches of events.  Each batch is non-empty and
+	// contains the events received from the underlying watcher during a single interval, in
+	// the order that they were received.  If the underlying watcher terminates or Stop() is
+	// called, this channel will be closed.
+	ResultChan() <-chan []Event
+}
+
+// NewBatched wraps the supplied watcher, buffering its events for up to interval before
+// delivering them as a single batch.  Events are never reordered or split across batches, so
+// per-key ordering is the same as for the underlying watcher.  If the consumer is slow to read
+// a batch, no further events are read from the underlying watcher until it does.
+func NewBatched(w Interface, interval time.Duration) BatchedInterface {
+	bw := &batchedWatcher{
+		watcher:  w,
+		interval: interval,
+		results:  make(chan []Event),
+		done:     make(chan struct{}),
+	}
+	go bw.run()
+	return bw
+}
+
+type batchedWatcher struct {
+	watcher  Interface
+	interval time.Duration
+	results  chan []Event
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// Stop stops the underlying watcher and the batching goroutine.
+func (bw *batchedWatcher) Stop() {
+	bw.stopOnce.Do(func() {
+		close(bw.done)
+		bw.watcher.Stop()
+	})
+}
+
+// ResultChan returns the channel of event batches.
+func (bw *batchedWatcher) ResultChan() <-chan []Event {
+	return bw.results
+}
+
+func (bw *batchedWatcher) run() {
+	defer close(bw.results)
+	ticker := time.NewTicker(bw.interval)
+	defer ticker.Stop()
+
+	var pending []Event
+	for {
+		select {
+		case e, ok := <-bw.watcher.ResultChan():
+			if !ok {
+				// The underlying watcher has terminated, flush any remaining events.
+				bw.send(pending)
+				return
+			}
+			pending = append(pending, e)
+		case <-ticker.C:
+			if !bw.send(pending) {
+				return
+			}
+			pending = nil
+		case <-bw.done:
+			return
+		}
+	}
+}
+
+// send delivers the batch, if non-empty, to the consumer.  Returns false if the watcher was
+// stopped before the batch could be delivered.
+func (bw *batchedWatcher) send(batch []Event) bool {
+	if len(batch) == 0 {
+		return true
+	}
+	select {
+	case bw.results <- batch:
+		return true
+	case <-bw.done:
+		return false
+	}
+}
@@ -46,10 +46,14 @@ const (
 	// Error
 	// * an error has occurred.  If the error is terminating, the results channel
 	//   will be closed.
+	// Bookmark
+	// * the watcher has delivered all events up to ResourceVersion.  Only sent if
+	//   bookmarks were requested.
 	Added    EventType = "ADDED"
 	Modified EventType = "MODIFIED"
 	Deleted  EventType = "DELETED"
 	Error    EventType = "ERROR"
+	Bookmark EventType = "BOOKMARK"
 
 	DefaultChanSize int32 = 100
 )
@@ -59,14 +63,22 @@ type Event struct {
 	Type EventType
 
 	// Previous is:
-	// * If Type is Added, Error or Synced: nil
+	// * If Type is Added, Error, Bookmark or Synced: nil
 	// * If Type is Modified or Deleted: the previous state of the object
 	// Object is:
 	//  * If Type is Added or Modified: the new state of the object.
-	//  * If Type is Deleted, Error or Synced: nil
+	//  * If Type is Deleted, Error, Bookmark or Synced: nil
 	Previous runtime.Object
 	Object   runtime.Object
 
 	// The error, if EventType is Error.
 	Error error
+
+	// The resource version that the watch has reached, if EventType is Bookmark.  A watch
+	// started from this resource version continues after the events already delivered.
+	ResourceVersion string
+
+	// Expired is true if Type is Deleted and the object was deleted because its TTL expired,
+	// rather than by an explicit delete.
+	Expired bool
 }
@@ -1567,3 +1567,15 @@ func (c shimClient) BlockAffinities() client.BlockAffinityInterface {
 func (c shimClient) EnsureInitialized(ctx context.Context, calicoVersion, clusterType string) error {
 	return nil
 }
+
+func (c shimClient) BackendType() apiconfig.DatastoreType {
+	return c.client.BackendType()
+}
+
+func (c shimClient) Capabilities() client.Capabilities {
+	return c.client.Capabilities()
+}
+
+func (c shimClient) Txn() *client.Txn {
+	return c.client.Txn()
+}
@@ -387,6 +387,18 @@ func (b *mockDatastore) Backend() bapi.Client {
 	panic("not implemented")
 }
 
+func (b *mockDatastore) BackendType() apiconfig.DatastoreType {
+	panic("not implemented")
+}
+
+func (b *mockDatastore) Capabilities() clientv3.Capabilities {
+	panic("not implemented")
+}
+
+func (b *mockDatastore) Txn() *clientv3.Txn {
+	panic("not implemented")
+}
+
 func (m *mockDatastore) IPReservations() clientv3.IPReservationInterface {
 	panic("not implemented") // TODO: Implement
 }